@@ -0,0 +1,479 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// AccessGrant is a single identity to principal grant and the conditions
+// verify applies to it
+type AccessGrant struct {
+	Principal string `json:"principal"`
+	Identity  string `json:"identity"`
+	Issuer    string `json:"issuer"`
+	// SourceType is one of system, issuer, fragment or home
+	SourceType string `json:"source_type"`
+	Source     string `json:"source"`
+	// Effective is false if verify never allows this grant, e.g. because
+	// the principal is denied or the issuer is not an allowed provider
+	Effective  bool     `json:"effective"`
+	Conditions []string `json:"conditions"`
+}
+
+// AccessReport is the effective access to the local host
+type AccessReport struct {
+	Hostname    string        `json:"hostname"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Grants      []AccessGrant `json:"grants"`
+	// Plugins are the policy plugin configs found. Plugins decide at login
+	// time so the access they grant can not be listed.
+	Plugins []string `json:"plugins"`
+	// Errors are the policy sources that could not be read
+	Errors []string `json:"errors"`
+}
+
+// AccessExportCmd computes who can log in to the local host, as which
+// principal, from every policy source
+type AccessExportCmd struct {
+	Fs           afero.Fs
+	Out          io.Writer
+	ServerConfig *config.ServerConfig
+	Revocations  *policy.RevocationList
+	HomeDirs     func() ([]userHomeEntry, error)
+	// Groups resolves the allowed_groups of home_policy, nil uses
+	// policy.NewOsGroupLookup
+	Groups policy.GroupLookup
+
+	ProvidersPath string
+	PolicyPath    string
+	FragmentDir   string
+	// IssuerPolicyDir is the directory of the per-issuer system policies
+	IssuerPolicyDir string
+	PluginDir       string
+
+	// Flags
+	Format         string // json or csv
+	SkipUserPolicy bool
+	// IncludeInsecure lists the grants of policy files that fail the
+	// permission check, as not effective, instead of reporting an error
+	IncludeInsecure bool
+}
+
+// NewAccessExportCmd creates a new AccessExportCmd reading the default policy
+// locations. serverConfig may be nil.
+func NewAccessExportCmd(rt *Runtime, serverConfig *config.ServerConfig) *AccessExportCmd {
+	audit := &AuditCmd{Fs: files.NewFileSystem(rt.Fs)}
+	return &AccessExportCmd{
+		Fs:              rt.Fs,
+		Out:             rt.Out,
+		ServerConfig:    serverConfig,
+		Revocations:     policy.NewRevocationList(),
+		HomeDirs:        audit.enumerateUserHomeDirs,
+		ProvidersPath:   policy.Defaults.ProvidersPath,
+		PolicyPath:      policy.Defaults.PolicyPath,
+		FragmentDir:     policy.Defaults.FragmentDir,
+		IssuerPolicyDir: policy.SystemDefaultIssuerPolicyDir,
+		PluginDir:       policy.GetPluginPolicyDir(),
+		Format:          "json",
+	}
+}
+
+// Run writes the access report in Format
+func (a *AccessExportCmd) Run() error {
+	if a.Format != "json" && a.Format != "csv" {
+		return fmt.Errorf("unsupported format %q, expected json or csv", a.Format)
+	}
+	report, err := a.Report()
+	if err != nil {
+		return err
+	}
+	if a.Format == "csv" {
+		return writeAccessCSV(a.Out, report)
+	}
+	enc := json.NewEncoder(a.Out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// Report computes the access report
+func (a *AccessExportCmd) Report() (*AccessReport, error) {
+	providerLoader := &policy.ProvidersFileLoader{FileLoader: files.FileLoader{Fs: a.Fs, RequiredPerm: files.ModeSystemPerms}}
+	providerPolicy, err := providerLoader.LoadProviderPolicy(a.ProvidersPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load providers (%s): %w", a.ProvidersPath, err)
+	}
+	expirations := map[string]string{}
+	for _, row := range providerPolicy.GetRows() {
+		expirations[row.Issuer] = row.ExpirationPolicy
+	}
+
+	report := &AccessReport{
+		GeneratedAt: time.Now().UTC(),
+		Grants:      []AccessGrant{},
+		Plugins:     []string{},
+		Errors:      []string{},
+	}
+	report.Hostname, _ = os.Hostname()
+
+	var revoked []policy.RevokedIdentity
+	if a.Revocations != nil {
+		if revoked, err = a.Revocations.Entries(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("revocation list: %v", err))
+		}
+	}
+	c := &accessConditions{
+		now:         report.GeneratedAt,
+		validator:   policy.NewPolicyValidator(providerPolicy),
+		expirations: expirations,
+		revoked:     revoked,
+	}
+	if a.ServerConfig != nil {
+		c.denyUsers = a.ServerConfig.DenyUsers
+		c.denyEmails = a.ServerConfig.DenyEmails
+		c.proxy = a.ServerConfig.Proxy
+	}
+
+	// verify reads the system policy from the store the server config selects
+	var db *policy.PolicyDB
+	var storeErr error
+	if a.ServerConfig != nil {
+		if db, storeErr = PolicyStoreDB(a.ServerConfig.PolicyStore); storeErr != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("verify does not load the system policy: %v", storeErr))
+		}
+	}
+	switch {
+	case storeErr != nil:
+	case db != nil:
+		if systemPolicy, err := db.Load(); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", db.Path, err))
+		} else {
+			report.Grants = append(report.Grants, c.grants(systemPolicy, "system", db.Path, nil)...)
+		}
+	default:
+		if systemPolicy, permErr, err := a.loadPolicy(a.PolicyPath, files.ModeSystemPerms); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", a.PolicyPath, err))
+		} else {
+			report.Grants = append(report.Grants, insecure(c.grants(systemPolicy, "system", a.PolicyPath, nil), permErr)...)
+		}
+	}
+
+	if a.IssuerPolicyDir != "" {
+		report.Grants = append(report.Grants, a.issuerGrants(c, report)...)
+	}
+
+	fragments := &policy.FragmentStore{Fs: a.Fs, Dir: a.FragmentDir}
+	if a.ServerConfig != nil && len(a.ServerConfig.PolicyFragments.TrustedKeys) > 0 {
+		if fragments.TrustedKeys, err = FragmentTrustedKeys(a.ServerConfig.PolicyFragments); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: verify ignores all policy fragments: %v", a.FragmentDir, err))
+			fragments = nil
+		}
+	}
+	if fragments != nil {
+		report.Grants = append(report.Grants, a.fragmentGrants(fragments, c, report)...)
+	}
+
+	if !a.SkipUserPolicy && a.HomeDirs != nil {
+		homeDirs, err := a.HomeDirs()
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("could not enumerate user home directories: %v", err))
+		}
+		for _, home := range homeDirs {
+			report.Grants = append(report.Grants, a.homeGrants(home, c, report)...)
+		}
+	}
+
+	if entries, err := afero.ReadDir(a.Fs, a.PluginDir); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".yml") {
+				report.Plugins = append(report.Plugins, filepath.Join(a.PluginDir, entry.Name()))
+			}
+		}
+	}
+	return report, nil
+}
+
+// fragmentGrants returns the grants of every fragment verify loads
+func (a *AccessExportCmd) fragmentGrants(store *policy.FragmentStore, c *accessConditions, report *AccessReport) []AccessGrant {
+	_, paths, err := store.Load()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", store.Dir, err))
+		return nil
+	}
+	grants := []AccessGrant{}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), policy.FragmentExt)
+		fragment, err := store.LoadFragment(name)
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		grants = append(grants, c.grants(fragment, "fragment", path, nil)...)
+	}
+	return grants
+}
+
+// issuerGrants returns the grants of the per-issuer system policies. Entries
+// of another issuer than the one a file is named after are listed as not
+// effective.
+func (a *AccessExportCmd) issuerGrants(c *accessConditions, report *AccessReport) []AccessGrant {
+	paths, err := (&policy.IssuerPolicyStore{Fs: a.Fs, Dir: a.IssuerPolicyDir}).Paths()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", a.IssuerPolicyDir, err))
+		return nil
+	}
+	grants := []AccessGrant{}
+	for _, path := range paths {
+		issuerPolicy, permErr, err := a.loadPolicy(path, files.ModeSystemPerms)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		removed := map[string]string{}
+		for _, user := range issuerPolicy.Users {
+			if policy.IssuerPolicyHolds(path, user.Issuer) {
+				continue
+			}
+			for _, principal := range user.Principals {
+				removed[strings.Join([]string{principal, user.IdentityAttribute, user.Issuer}, " ")] = "the entries of issuer " + user.Issuer + " belong in " + policy.IssuerPolicyName(user.Issuer)
+			}
+		}
+		grants = append(grants, insecure(c.grants(issuerPolicy, "issuer", path, removed), permErr)...)
+	}
+	return grants
+}
+
+// homeGrants returns the grants of the home policy of home. Entries that
+// grant other principals are ignored by verify and are not listed. Entries
+// of a home policy that verify doesn't read, or ignores as a whole, are
+// listed as not effective.
+func (a *AccessExportCmd) homeGrants(home userHomeEntry, c *accessConditions, report *AccessReport) []AccessGrant {
+	path := filepath.Join(home.HomeDir, ".opk", "auth_id")
+	if exists, _ := afero.Exists(a.Fs, path); !exists {
+		return nil
+	}
+	homePolicy, permErr, err := a.loadPolicy(path, files.ModeHomePerms)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", path, err))
+		return nil
+	}
+	userPolicy := &policy.Policy{}
+	for _, user := range homePolicy.Users {
+		if slices.Contains(user.Principals, home.Username) {
+			userPolicy.Users = append(userPolicy.Users, policy.User{
+				IdentityAttribute: user.IdentityAttribute,
+				Principals:        []string{home.Username},
+				Issuer:            user.Issuer,
+				Expires:           user.Expires,
+				KeyOptions:        user.KeyOptions,
+			})
+		}
+	}
+
+	// Entries removed by the home policy constraints are listed as not
+	// effective with the reason they were removed
+	removed := map[string]string{}
+	if a.ServerConfig != nil {
+		constraints := policy.HomePolicyConstraints{
+			AllowedIssuers:       a.ServerConfig.HomePolicy.AllowedIssuers,
+			ForbiddenPrincipals:  a.ServerConfig.HomePolicy.ForbiddenPrincipals,
+			MaxEntries:           a.ServerConfig.HomePolicy.MaxEntries,
+			RequiredEmailDomains: a.ServerConfig.HomePolicy.RequiredEmailDomains,
+		}
+		kept, problems := constraints.Apply(userPolicy, path)
+		for _, problem := range problems {
+			if _, ok := removed[problem.OffendingLine]; !ok {
+				removed[problem.OffendingLine] = problem.ErrorMessage
+			}
+		}
+		ignoreAll := func(reason string) {
+			for _, user := range userPolicy.Users {
+				removed[strings.Join([]string{home.Username, user.IdentityAttribute, user.Issuer}, " ")] = reason
+			}
+		}
+		threshold := a.ServerConfig.HomePolicy.IdentityDenyThreshold
+		if err := HomePolicyAccess(a.ServerConfig.HomePolicy).Check(home.Username, a.Groups); err != nil {
+			ignoreAll(err.Error())
+		} else if count := policy.CountIdentities(kept); threshold > 0 && count > threshold {
+			ignoreAll(fmt.Sprintf("home policy of %s admits %d identities, more than the allowed %d", home.Username, count, threshold))
+		}
+	}
+	return insecure(c.grants(userPolicy, "home", path, removed), permErr)
+}
+
+// loadPolicy reads the policy file at path, which verify requires to have
+// perm. If IncludeInsecure is set, a file with other permissions is still
+// read and the permission problem is returned as permErr.
+func (a *AccessExportCmd) loadPolicy(path string, perm fs.FileMode) (p *policy.Policy, permErr error, err error) {
+	loader := &policy.PolicyLoader{FileLoader: files.FileLoader{Fs: a.Fs, RequiredPerm: perm}}
+	p, err = loader.LoadPolicyAtPath(path)
+	if err == nil || !a.IncludeInsecure {
+		return p, nil, err
+	}
+	if permErr = files.NewPermsChecker(a.Fs).CheckPerm(path, []fs.FileMode{perm}, "", ""); permErr == nil {
+		return nil, nil, err
+	}
+	content, err := afero.ReadFile(a.Fs, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	p, _ = policy.FromTable(content, path)
+	return p, permErr, nil
+}
+
+// insecure marks grants as not effective if their policy file failed the
+// permission check with permErr
+func insecure(grants []AccessGrant, permErr error) []AccessGrant {
+	if permErr == nil {
+		return grants
+	}
+	for i := range grants {
+		grants[i].Effective = false
+		grants[i].Conditions = append(grants[i].Conditions, "denied: insecure permissions: "+permErr.Error())
+	}
+	return grants
+}
+
+// accessConditions holds the checks verify applies on top of policy
+type accessConditions struct {
+	now         time.Time
+	validator   *policy.PolicyValidator
+	expirations map[string]string
+	denyUsers   []string
+	denyEmails  []string
+	revoked     []policy.RevokedIdentity
+	proxy       config.ProxyConfig
+}
+
+// grants returns a grant for each principal in p. removed maps the policy
+// lines that verify drops to the reason they are dropped.
+func (c *accessConditions) grants(p *policy.Policy, sourceType string, source string, removed map[string]string) []AccessGrant {
+	grants := []AccessGrant{}
+	for _, user := range p.Users {
+		for _, principal := range user.Principals {
+			grant := AccessGrant{
+				Principal:  principal,
+				Identity:   user.IdentityAttribute,
+				Issuer:     user.Issuer,
+				SourceType: sourceType,
+				Source:     source,
+				Effective:  true,
+				Conditions: []string{},
+			}
+			c.apply(&grant)
+			if !user.Expires.IsZero() {
+				if user.Expired(c.now) {
+					grant.Effective = false
+					grant.Conditions = append(grant.Conditions, "expired: "+policy.FormatExpiry(user.Expires))
+				} else {
+					grant.Conditions = append(grant.Conditions, "expires: "+policy.FormatExpiry(user.Expires))
+				}
+			}
+			if reason, ok := removed[strings.Join([]string{principal, user.IdentityAttribute, user.Issuer}, " ")]; ok {
+				grant.Effective = false
+				grant.Conditions = append(grant.Conditions, "ignored: "+reason)
+			}
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// apply notes the conditions on grant and clears Effective if verify always
+// denies it
+func (c *accessConditions) apply(grant *AccessGrant) {
+	deny := func(reason string) {
+		grant.Effective = false
+		grant.Conditions = append(grant.Conditions, "denied: "+reason)
+	}
+
+	identity := grant.Identity
+	switch {
+	case strings.HasPrefix(identity, policy.OIDC_WILDCARD_EMAIL):
+		grant.Conditions = append(grant.Conditions, "email ends with "+strings.TrimPrefix(identity, policy.OIDC_WILDCARD_EMAIL))
+	case strings.HasPrefix(identity, policy.EMAIL_DOMAIN):
+		grant.Conditions = append(grant.Conditions, "verified email in "+strings.TrimPrefix(identity, policy.EMAIL_DOMAIN))
+	case strings.HasPrefix(identity, policy.OIDC_CLAIMS):
+		grant.Conditions = append(grant.Conditions, "claim condition "+identity)
+	}
+
+	if result := c.validator.ValidateEntry(grant.Principal, identity, grant.Issuer, 0); result.Status == policy.StatusError {
+		deny(result.Reason)
+	} else if expiration := c.expirations[grant.Issuer]; expiration != "" {
+		grant.Conditions = append(grant.Conditions, "token expiration "+expiration)
+	}
+	if slices.Contains(c.denyUsers, grant.Principal) {
+		deny("principal is in deny_users")
+	}
+	for _, email := range c.denyEmails {
+		if strings.EqualFold(email, identity) {
+			deny("identity is in deny_emails")
+		}
+	}
+	for _, r := range c.revoked {
+		if r.Matches(grant.Issuer, identity, identity) {
+			deny("identity was revoked: " + r.Reason)
+			break
+		}
+	}
+	if slices.Contains(c.proxy.RequireFor, grant.Principal) {
+		names := []string{}
+		for _, proxy := range c.proxy.Trusted {
+			names = append(names, proxy.Name)
+		}
+		grant.Conditions = append(grant.Conditions, "only through trusted proxy ("+strings.Join(names, ", ")+")")
+	}
+}
+
+// writeAccessCSV writes the grants in report as CSV with a header row
+func writeAccessCSV(out io.Writer, report *AccessReport) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"hostname", "principal", "identity", "issuer", "source_type", "source", "effective", "conditions"}); err != nil {
+		return err
+	}
+	for _, grant := range report.Grants {
+		if err := w.Write([]string{
+			report.Hostname,
+			grant.Principal,
+			grant.Identity,
+			grant.Issuer,
+			grant.SourceType,
+			grant.Source,
+			strconv.FormatBool(grant.Effective),
+			strings.Join(grant.Conditions, "; "),
+		}); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,167 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessExport(t *testing.T) {
+	t.Parallel()
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/providers", []byte("https://accounts.google.com google-client 24h\n"), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte(
+		"root alice@example.com https://accounts.google.com\n"+
+			"dev oidc-match-end:email:@example.com https://accounts.google.com\n"+
+			"guest bob@example.com https://unknown.example.com\n"+
+			"deploy mallory@example.com https://accounts.google.com\n"), 0640))
+	fragments := &policy.FragmentStore{Fs: mockFs, Dir: "/var/lib/opk/policy"}
+	require.NoError(t, fragments.Write("google", nil, &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "carol@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"},
+	}}))
+	require.NoError(t, afero.WriteFile(mockFs, "/home/dave/.opk/auth_id", []byte(
+		"dave dave@example.com https://accounts.google.com\n"+
+			"root dave@example.com https://accounts.google.com\n"+
+			"dave dave@other.com https://accounts.google.com\n"), 0600))
+	revocations := &policy.RevocationList{Fs: mockFs, Path: "/var/lib/opk/revoked"}
+	require.NoError(t, revocations.Revoke(policy.RevokedIdentity{Issuer: "https://accounts.google.com", Email: "mallory@example.com", Reason: "user.lifecycle.suspend"}))
+
+	out := &bytes.Buffer{}
+	export := &AccessExportCmd{
+		Fs:  mockFs,
+		Out: out,
+		ServerConfig: &config.ServerConfig{
+			DenyUsers:  []string{"guest"},
+			HomePolicy: config.HomePolicyConfig{RequiredEmailDomains: []string{"example.com"}},
+			Proxy:      config.ProxyConfig{Trusted: []config.TrustedProxy{{Name: "bastion"}}, RequireFor: []string{"root"}},
+		},
+		Revocations: revocations,
+		HomeDirs: func() ([]userHomeEntry, error) {
+			return []userHomeEntry{{Username: "dave", HomeDir: "/home/dave"}, {Username: "erin", HomeDir: "/home/erin"}}, nil
+		},
+		ProvidersPath: "/etc/opk/providers",
+		PolicyPath:    "/etc/opk/auth_id",
+		FragmentDir:   "/var/lib/opk/policy",
+		PluginDir:     "/etc/opk/policy.d",
+		Format:        "json",
+	}
+
+	report, err := export.Report()
+	require.NoError(t, err)
+	require.Empty(t, report.Errors)
+	require.Len(t, report.Grants, 7)
+
+	grants := map[string]AccessGrant{}
+	for _, grant := range report.Grants {
+		grants[grant.Principal+" "+grant.Identity] = grant
+	}
+	root := grants["root alice@example.com"]
+	require.True(t, root.Effective)
+	require.Equal(t, "system", root.SourceType)
+	require.Equal(t, []string{"token expiration 24h", "only through trusted proxy (bastion)"}, root.Conditions)
+
+	require.Contains(t, grants["dev oidc-match-end:email:@example.com"].Conditions, "email ends with @example.com")
+
+	guest := grants["guest bob@example.com"]
+	require.False(t, guest.Effective)
+	require.Equal(t, []string{"denied: issuer not found in /etc/opk/providers", "denied: principal is in deny_users"}, guest.Conditions)
+
+	deploy := grants["deploy mallory@example.com"]
+	require.False(t, deploy.Effective)
+	require.Contains(t, deploy.Conditions, "denied: identity was revoked: user.lifecycle.suspend")
+
+	carol := grants["dev carol@example.com"]
+	require.True(t, carol.Effective)
+	require.Equal(t, "fragment", carol.SourceType)
+	require.Equal(t, "/var/lib/opk/policy/google.auth_id", carol.Source)
+
+	// The home policy only grants its own principal
+	require.NotContains(t, grants, "root dave@example.com")
+	require.True(t, grants["dave dave@example.com"].Effective)
+	other := grants["dave dave@other.com"]
+	require.False(t, other.Effective)
+	require.Equal(t, "home", other.SourceType)
+	require.Contains(t, other.Conditions[len(other.Conditions)-1], "ignored: identity dave@other.com is not an email in an allowed domain")
+
+	export.Format = "csv"
+	require.NoError(t, export.Run())
+	records, err := csv.NewReader(out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 8)
+	require.Equal(t, []string{"hostname", "principal", "identity", "issuer", "source_type", "source", "effective", "conditions"}, records[0])
+	require.Equal(t, "token expiration 24h; only through trusted proxy (bastion)", records[1][7])
+
+	export.Format = "xml"
+	require.ErrorContains(t, export.Run(), "unsupported format")
+}
+
+func TestAccessExportHomePolicyAccess(t *testing.T) {
+	t.Parallel()
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/providers", []byte("https://accounts.google.com google-client 24h\n"), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte{}, 0640))
+	require.NoError(t, afero.WriteFile(mockFs, "/home/dave/.opk/auth_id", []byte(
+		"dave dave@example.com https://accounts.google.com\n"+
+			"dave dave@other.com https://accounts.google.com\n"), 0600))
+
+	homeGrants := func(homePolicy config.HomePolicyConfig) []AccessGrant {
+		export := &AccessExportCmd{
+			Fs:           mockFs,
+			Out:          &bytes.Buffer{},
+			ServerConfig: &config.ServerConfig{HomePolicy: homePolicy},
+			Revocations:  &policy.RevocationList{Fs: mockFs, Path: "/var/lib/opk/revoked"},
+			HomeDirs: func() ([]userHomeEntry, error) {
+				return []userHomeEntry{{Username: "dave", HomeDir: "/home/dave"}}, nil
+			},
+			Groups:        testGroupLookup{"sshers": {"erin"}},
+			ProvidersPath: "/etc/opk/providers",
+			PolicyPath:    "/etc/opk/auth_id",
+			PluginDir:     "/etc/opk/policy.d",
+		}
+		report, err := export.Report()
+		require.NoError(t, err)
+		require.Len(t, report.Grants, 2)
+		return report.Grants
+	}
+
+	for _, grant := range homeGrants(config.HomePolicyConfig{}) {
+		require.True(t, grant.Effective)
+	}
+	for _, tc := range []struct {
+		homePolicy config.HomePolicyConfig
+		reason     string
+	}{
+		{config.HomePolicyConfig{Disabled: true}, "ignored: home policies are disabled"},
+		{config.HomePolicyConfig{AllowedGroups: []string{"sshers"}}, "ignored: home policies are only read for allowed principals and members of allowed groups, dave is neither"},
+		{config.HomePolicyConfig{IdentityDenyThreshold: 1}, "ignored: home policy of dave admits 2 identities, more than the allowed 1"},
+	} {
+		for _, grant := range homeGrants(tc.homePolicy) {
+			require.False(t, grant.Effective)
+			require.Contains(t, grant.Conditions, tc.reason)
+		}
+	}
+	for _, grant := range homeGrants(config.HomePolicyConfig{AllowedPrincipals: []string{"dave"}, IdentityDenyThreshold: 2}) {
+		require.True(t, grant.Effective)
+	}
+}
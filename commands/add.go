@@ -19,9 +19,14 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
 	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
 )
 
 // AddCmd provides functionality to read and update the opkssh policy file
@@ -34,9 +39,25 @@ type AddCmd struct {
 	//
 	// See AddCmd.LoadPolicy for more details.
 	Username string
+
+	// Journal, if set, records changes made to the system policy
+	Journal *policy.Journal
+
+	// DualControlPrincipals are principals that can only be added to the
+	// system policy by proposing the change and having a different admin
+	// approve it
+	DualControlPrincipals []string
+	// Groups resolves the members of a %group principal when checking it
+	// against DualControlPrincipals. If nil, any group requires approval.
+	Groups policy.GroupLookup
+	// Pending stores proposed changes awaiting approval
+	Pending *policy.PendingStore
+
+	// Expires, if set, is when the added entry stops allowing logins
+	Expires time.Time
 }
 
-// LoadPolicy reads the opkssh policy at the policy.SystemDefaultPolicyPath. If
+// LoadPolicy reads the opkssh policy at the policy.Defaults.PolicyPath. If
 // there is a permission error when reading this file, then the user's local
 // policy file (defined as ~/.opk/auth_id where ~ maps to AddCmd.Username's
 // home directory) is read instead.
@@ -62,7 +83,7 @@ func (a *AddCmd) LoadPolicy() (*policy.Policy, string, error) {
 		}
 	}
 
-	return systemPolicy, policy.SystemDefaultPolicyPath, nil
+	return systemPolicy, a.SystemPolicyLoader.Path(), nil
 }
 
 // GetPolicyPath returns the path to the policy file that the current command
@@ -86,7 +107,7 @@ func (a *AddCmd) GetPolicyPath(principal string, userEmail string, issuer string
 			return "", false, err
 		}
 	}
-	return policy.SystemDefaultPolicyPath, true, nil
+	return a.SystemPolicyLoader.Path(), true, nil
 }
 
 // Run adds a new allowed principal to the user whose email is equal to
@@ -95,22 +116,87 @@ func (a *AddCmd) GetPolicyPath(principal string, userEmail string, issuer string
 // If successful, returns the policy filepath updated. Otherwise, returns a
 // non-nil error
 func (a *AddCmd) Run(principal string, userEmail string, issuer string) (string, error) {
+	return a.run(principal, userEmail, issuer, a.Expires, nil)
+}
+
+// Propose stages the addition of principal for userEmail so that it is only
+// added to the system policy once a different admin approves it with
+// `opkssh approve`.
+func (a *AddCmd) Propose(principal string, userEmail string, issuer string) (policy.PendingChange, error) {
+	if a.Pending == nil {
+		return policy.PendingChange{}, fmt.Errorf("no pending change store configured")
+	}
+	proposed := policy.PendingChange{
+		Principal:  principal,
+		Identity:   userEmail,
+		Issuer:     issuer,
+		ProposedBy: policy.CurrentActor(),
+	}
+	if !a.Expires.IsZero() {
+		proposed.Expires = policy.FormatExpiry(a.Expires)
+	}
+	change, err := a.Pending.Propose(proposed)
+	if err != nil {
+		return policy.PendingChange{}, err
+	}
+	if a.Journal != nil {
+		if err := a.Journal.Append(policy.JournalEntry{
+			Action:  "propose",
+			Path:    policy.Defaults.PolicyPath,
+			Summary: []string{fmt.Sprintf("proposed %s: + %s", change.ID, entrySummary(principal, userEmail, issuer, a.Expires))},
+		}); err != nil {
+			slog.Warn("Failed to record the change in the policy journal", "error", err)
+		}
+	}
+	return change, nil
+}
+
+// entrySummary returns the policy line of an added entry
+func entrySummary(principal string, userEmail string, issuer string, expires time.Time) string {
+	summary := fmt.Sprintf("%s %s %s", principal, userEmail, issuer)
+	if !expires.IsZero() {
+		summary += " " + policy.ExpiresOption + "=" + policy.FormatExpiry(expires)
+	}
+	return summary
+}
+
+// run adds the policy entry, expiring at expires unless it is zero.
+// approved is the pending change being applied, or nil if the entry was not
+// proposed.
+func (a *AddCmd) run(principal string, userEmail string, issuer string, expires time.Time, approved *policy.PendingChange) (string, error) {
 	policyPath, useSystemPolicy, err := a.GetPolicyPath(principal, userEmail, issuer)
 	if err != nil {
 		return "", fmt.Errorf("failed to load policy: %w", err)
 	}
 
-	var policyLoader *policy.PolicyLoader
+	if useSystemPolicy && approved == nil {
+		if account := a.dualControlMatch(principal); account != "" {
+			return "", fmt.Errorf("adding principal %s requires approval by a second admin as it allows %s, rerun with --propose", principal, account)
+		}
+	}
+
+	var policyLoader interface {
+		CreateIfDoesNotExist(path string) error
+		Dump(policy *policy.Policy, path string) error
+	}
+	var fileLoader *files.FileLoader
 	if useSystemPolicy {
-		policyLoader = a.SystemPolicyLoader.PolicyLoader
+		policyLoader = a.SystemPolicyLoader
+		fileLoader = &a.SystemPolicyLoader.FileLoader
 	} else {
 		policyLoader = a.HomePolicyLoader.PolicyLoader
+		fileLoader = &a.HomePolicyLoader.FileLoader
 	}
 
 	err = policyLoader.CreateIfDoesNotExist(policyPath)
 	if err != nil {
 		return "", fmt.Errorf("failed to create policy file: %w", err)
 	}
+	lock, err := fileLoader.Lock(policyPath)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
 
 	// Read current policy
 	currentPolicy, policyFilePath, err := a.LoadPolicy()
@@ -119,13 +205,83 @@ func (a *AddCmd) Run(principal string, userEmail string, issuer string) (string,
 	}
 
 	// Update policy
-	currentPolicy.AddAllowedPrincipal(principal, userEmail, issuer)
+	currentPolicy.AddAllowedPrincipalUntil(principal, userEmail, issuer, expires)
 
 	// Dump contents back to disk
 	err = policyLoader.Dump(currentPolicy, policyFilePath)
 	if err != nil {
 		return "", fmt.Errorf("failed to write updated policy: %w", err)
 	}
+	if useSystemPolicy && a.Journal != nil {
+		entry := policy.JournalEntry{
+			Action:  "add",
+			Path:    policyFilePath,
+			Summary: []string{"+ " + entrySummary(principal, userEmail, issuer, expires)},
+		}
+		if approved != nil {
+			entry.Action = "approve"
+			entry.Summary = append(entry.Summary, fmt.Sprintf("approved %s proposed by %s", approved.ID, approved.ProposedBy))
+		}
+		if err := a.Journal.Append(entry); err != nil {
+			slog.Warn("Failed to record the change in the policy journal", "error", err)
+		}
+	}
+	fields := map[string]string{
+		"path":      policyFilePath,
+		"action":    "add",
+		"principal": principal,
+		"identity":  userEmail,
+		"issuer":    issuer,
+	}
+	if !expires.IsZero() {
+		fields["expires"] = policy.FormatExpiry(expires)
+	}
+	events.Emit(events.PolicyChanged, fields)
 
 	return policyFilePath, nil
 }
+
+// dualControlMatch returns the dual control principal that principal allows,
+// through a pattern or group that matches it, or empty if it allows none. A
+// group whose members can't be resolved is assumed to allow them all.
+func (a *AddCmd) dualControlMatch(principal string) string {
+	for _, account := range a.DualControlPrincipals {
+		if principal == account {
+			return account
+		}
+		if policy.IsPrincipalPattern(principal) {
+			pattern, err := policy.CompilePrincipalPattern(principal)
+			if err != nil || pattern.Match(account) {
+				return account
+			}
+		} else if group, ok := policy.IsGroupPrincipal(principal); ok {
+			if a.Groups == nil {
+				return account
+			}
+			if member, err := a.Groups.IsMember(account, group); err != nil || member {
+				return account
+			}
+		}
+	}
+	return ""
+}
+
+// ConfigureBackups sets the backups the loader keeps of the system policy
+// from cfg
+func ConfigureBackups(loader *policy.SystemPolicyLoader, cfg config.PolicyBackupsConfig) {
+	backups := loader.FileLoader.Backups
+	switch {
+	case cfg.Keep < 0:
+		loader.FileLoader.Backups = nil
+		return
+	case backups == nil:
+		backups = files.NewBackups(loader.FileLoader.Fs, policy.SystemDefaultBackupDir)
+		loader.FileLoader.Backups = backups
+	}
+	if cfg.Dir != "" {
+		backups.Dir = cfg.Dir
+	}
+	if cfg.Keep > 0 {
+		backups.Keep = cfg.Keep
+	}
+}
@@ -17,7 +17,12 @@
 package commands
 
 import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
@@ -96,6 +101,53 @@ func TestAddErrors(t *testing.T) {
 	require.Equal(t, expectedPolicyContent, string(policyContent))
 }
 
+func TestAddExpiry(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte("root alice@example.com google\n"), 0640))
+	addCmd := MockAddCmd(mockFs)
+	addCmd.Expires = time.Date(2025, 12, 31, 18, 0, 0, 0, time.UTC)
+
+	_, err := addCmd.Run("dev", "alice@example.com", "google")
+	require.NoError(t, err)
+	// Adding it again with another expiry replaces the expiry
+	_, err = addCmd.Run("root", "alice@example.com", "google")
+	require.NoError(t, err)
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Equal(t, "dev alice@example.com google expires=2025-12-31T18:00:00Z\nroot alice@example.com google expires=2025-12-31T18:00:00Z\n", string(content))
+}
+
+// slowFs delays opening files so that concurrent changes interleave
+type slowFs struct{ afero.Fs }
+
+func (s slowFs) Stat(name string) (os.FileInfo, error) {
+	time.Sleep(time.Millisecond)
+	return s.Fs.Stat(name)
+}
+
+func TestAddConcurrent(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte{}, 0640))
+	addCmd := MockAddCmd(slowFs{mockFs})
+
+	// Adds running at the same time do not lose each other's entries
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := addCmd.Run(fmt.Sprintf("user%d", i), "alice@example.com", "google")
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Equal(t, 10, strings.Count(string(content), "alice@example.com"), string(content))
+}
+
 func TestAddUniqueness(t *testing.T) {
 
 	mockFs := afero.NewMemMapFs()
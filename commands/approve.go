@@ -0,0 +1,91 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+)
+
+// ApproveCmd applies policy changes proposed with `opkssh add --propose`
+type ApproveCmd struct {
+	Add *AddCmd
+	Out io.Writer
+	// Actor returns the name of the admin approving, defaults to
+	// policy.CurrentActor
+	Actor func() string
+}
+
+// List prints all changes awaiting approval
+func (c *ApproveCmd) List() error {
+	changes, err := c.Add.Pending.List()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(c.Out, "No pending changes")
+		return nil
+	}
+	for _, change := range changes {
+		entry := change.Principal + " " + change.Identity + " " + change.Issuer
+		if change.Expires != "" {
+			entry += " " + policy.ExpiresOption + "=" + change.Expires
+		}
+		fmt.Fprintf(c.Out, "%s %s proposed by %s: %s\n", change.ID,
+			change.ProposedAt.Local().Format(time.RFC3339), change.ProposedBy, entry)
+	}
+	return nil
+}
+
+// Run approves and applies the pending change with id. The approver must not
+// be the admin that proposed the change. Returns the policy file updated.
+func (c *ApproveCmd) Run(id string) (string, error) {
+	change, err := c.Add.Pending.Get(id)
+	if err != nil {
+		return "", err
+	}
+
+	actor := policy.CurrentActor
+	if c.Actor != nil {
+		actor = c.Actor
+	}
+	approver := actor()
+	if approver == "" {
+		return "", fmt.Errorf("unable to determine who is approving the change")
+	}
+	if approver == change.ProposedBy {
+		return "", fmt.Errorf("change %s was proposed by %s and must be approved by a different admin", id, change.ProposedBy)
+	}
+
+	var expires time.Time
+	if change.Expires != "" {
+		if expires, err = policy.ParseExpiry(change.Expires); err != nil {
+			return "", fmt.Errorf("change %s: %w", id, err)
+		}
+	}
+	policyFilePath, err := c.Add.run(change.Principal, change.Identity, change.Issuer, expires, &change)
+	if err != nil {
+		return "", err
+	}
+	if err := c.Add.Pending.Remove(id); err != nil {
+		return "", fmt.Errorf("change was applied to %s but failed to remove pending change %s: %w", policyFilePath, id, err)
+	}
+	return policyFilePath, nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func mockDualControlAddCmd(t *testing.T) (*AddCmd, afero.Fs) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte{}, files.ModeSystemPerms))
+
+	addCmd := MockAddCmd(mockFs)
+	addCmd.DualControlPrincipals = []string{"root"}
+	addCmd.Pending = &policy.PendingStore{Fs: mockFs, Dir: policy.SystemDefaultPendingDir}
+	addCmd.Journal = &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath}
+	return addCmd, mockFs
+}
+
+func TestAddRequiresApprovalForDualControlPrincipals(t *testing.T) {
+	t.Parallel()
+	addCmd, mockFs := mockDualControlAddCmd(t)
+
+	_, err := addCmd.Run("root", "alice@example.com", "https://accounts.google.com")
+	require.ErrorContains(t, err, "requires approval by a second admin")
+
+	// Principals not under dual control are added directly
+	_, err = addCmd.Run("dev", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "root")
+	require.Contains(t, string(content), "dev alice@example.com")
+}
+
+func TestAddRequiresApprovalForMatchingPatternsAndGroups(t *testing.T) {
+	t.Parallel()
+	addCmd, mockFs := mockDualControlAddCmd(t)
+	addCmd.Groups = testGroupLookup{"wheel": {"root"}, "developers": {"alice"}}
+
+	for _, principal := range []string{"*", "re:.*", "ro?t", "%wheel"} {
+		_, err := addCmd.Run(principal, "alice@example.com", "https://accounts.google.com")
+		require.ErrorContains(t, err, "as it allows root, rerun with --propose", principal)
+	}
+
+	// Patterns and groups that can't allow root are added directly
+	_, err := addCmd.Run("dev-*", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+	_, err = addCmd.Run("%developers", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+
+	// Without a group lookup every group needs approval
+	addCmd.Groups = nil
+	_, err = addCmd.Run("%developers", "bob@example.com", "https://accounts.google.com")
+	require.ErrorContains(t, err, "requires approval by a second admin")
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "root")
+	require.Contains(t, string(content), "'dev-*' alice@example.com")
+	require.Contains(t, string(content), "%developers alice@example.com")
+	require.NotContains(t, string(content), "bob@example.com")
+}
+
+func TestApproveExpiry(t *testing.T) {
+	t.Parallel()
+	addCmd, mockFs := mockDualControlAddCmd(t)
+	addCmd.Expires = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	change, err := addCmd.Propose("root", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+	require.Equal(t, "2025-12-31", change.Expires)
+
+	var out bytes.Buffer
+	approve := &ApproveCmd{Add: addCmd, Out: &out, Actor: func() string { return "second-admin" }}
+	require.NoError(t, approve.List())
+	require.Contains(t, out.String(), "root alice@example.com https://accounts.google.com expires=2025-12-31")
+
+	// The expiry of the proposed change is applied, not the current one
+	addCmd.Expires = time.Time{}
+	_, err = approve.Run(change.ID)
+	require.NoError(t, err)
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Equal(t, "root alice@example.com https://accounts.google.com expires=2025-12-31\n", string(content))
+}
+
+func TestApprove(t *testing.T) {
+	t.Parallel()
+	addCmd, mockFs := mockDualControlAddCmd(t)
+
+	change, err := addCmd.Propose("root", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+	require.Len(t, change.ID, 16)
+
+	var out bytes.Buffer
+	approve := &ApproveCmd{Add: addCmd, Out: &out, Actor: func() string { return change.ProposedBy }}
+	require.NoError(t, approve.List())
+	require.Contains(t, out.String(), change.ID)
+	require.Contains(t, out.String(), "root alice@example.com https://accounts.google.com")
+
+	// The proposer can not approve their own change
+	_, err = approve.Run(change.ID)
+	require.ErrorContains(t, err, "must be approved by a different admin")
+
+	approve.Actor = func() string { return "second-admin" }
+	policyPath, err := approve.Run(change.ID)
+	require.NoError(t, err)
+	require.Equal(t, policy.SystemDefaultPolicyPath, policyPath)
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "root alice@example.com https://accounts.google.com")
+
+	// An approved change is removed and can not be applied twice
+	_, err = approve.Run(change.ID)
+	require.ErrorContains(t, err, "no pending change")
+
+	entries, err := addCmd.Journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "propose", entries[0].Action)
+	require.Equal(t, "approve", entries[1].Action)
+
+	_, err = approve.Run("../../etc/passwd")
+	require.ErrorContains(t, err, "invalid pending change id")
+}
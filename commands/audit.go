@@ -26,7 +26,6 @@ import (
 
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
-	"github.com/spf13/afero"
 )
 
 // AuditCmd provides functionality to audit policy files against provider definitions
@@ -45,16 +44,18 @@ type AuditCmd struct {
 }
 
 // NewAuditCmd creates a new AuditCmd with default settings
-func NewAuditCmd(out io.Writer, errOut io.Writer) *AuditCmd {
+func NewAuditCmd(rt *Runtime) *AuditCmd {
+	providerLoader := policy.NewProviderFileLoader()
+	providerLoader.Fs = rt.Fs
 	return &AuditCmd{
-		Fs:              files.NewFileSystem(afero.NewOsFs()),
-		Out:             out,
-		ErrOut:          errOut,
-		ProviderLoader:  policy.NewProviderFileLoader(),
+		Fs:              files.NewFileSystem(rt.Fs),
+		Out:             rt.Out,
+		ErrOut:          rt.ErrOut,
+		ProviderLoader:  providerLoader,
 		CurrentUsername: getCurrentUsername(),
 
-		ProviderPath:   policy.SystemDefaultProvidersPath,
-		PolicyPath:     policy.SystemDefaultPolicyPath,
+		ProviderPath:   policy.Defaults.ProvidersPath,
+		PolicyPath:     policy.Defaults.PolicyPath,
 		SkipUserPolicy: false,
 	}
 }
@@ -72,7 +73,7 @@ func (a *AuditCmd) Audit(opksshVersion string) (*TotalResults, error) {
 	providerPolicy, err := a.ProviderLoader.LoadProviderPolicy(providerPath)
 	if err != nil {
 		if strings.Contains(err.Error(), "permission denied") {
-			fmt.Fprint(a.ErrOut, "opkssh audit must be run as root, try `sudo opkssh audit`\n")
+			fmt.Fprintf(a.ErrOut, "opkssh audit must be run as root, try `%s opkssh audit`\n", files.ElevateCommand)
 		}
 		return nil, fmt.Errorf("failed to load providers (%s): %v", providerPath, err)
 	}
@@ -203,41 +204,28 @@ func (a *AuditCmd) auditPolicyFileWithStatus(policyPath string, permInfo files.P
 		return nil, true, fmt.Errorf("failed to read policy file: %w", err)
 	}
 
-	rowDetailsList := files.ReadRowsWithDetails(content)
-	for i, rowDetails := range rowDetailsList {
-		lineNumber := i + 1
+	for _, rowDetails := range files.ReadRowsWithDetails(content) {
+		lineNumber := rowDetails.Line
 
 		if rowDetails.Empty {
 			continue
 		}
-		if rowDetails.Error != nil {
-			result := policy.ValidationRowResult{
-				Status:     policy.StatusError,
-				Reason:     rowDetails.Error.Error(),
-				LineNumber: lineNumber,
-			}
-			results.Rows = append(results.Rows, result)
-			continue
+		parseErr := rowDetails.Error
+		if parseErr == nil {
+			parseErr = rowDetails.CheckColumns("principal", "identity", "issuer")
 		}
-
-		// We break the table by rows and then feed each row as if it is its own table record the line number of error
-		p, problems := policy.FromTable([]byte(rowDetails.Content), policyPath)
-		if len(problems) > 0 {
+		if parseErr != nil {
 			result := policy.ValidationRowResult{
 				Status:     policy.StatusError,
-				Reason:     problems[0].ErrorMessage,
+				Reason:     parseErr.Detail(),
 				LineNumber: lineNumber,
 			}
 			results.Rows = append(results.Rows, result)
 			continue
 		}
-		for _, user := range p.Users {
-			// Each user entry maps to principals
-			for _, principal := range user.Principals {
-				result := validator.ValidateEntry(principal, user.IdentityAttribute, user.Issuer, lineNumber)
-				results.Rows = append(results.Rows, result)
-			}
-		}
+
+		principal, identity, issuer := rowDetails.Columns[0], rowDetails.Columns[1], rowDetails.Columns[2]
+		results.Rows = append(results.Rows, validator.ValidateEntry(principal, identity, issuer, lineNumber))
 	}
 	return results, true, nil
 }
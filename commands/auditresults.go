@@ -50,6 +50,7 @@ type TotalResults struct {
 	HomePolicyFiles  []PolicyFileResult `json:"home_policy"`
 	OpkVersion       string             `json:"opk_version"`
 	OpenSSHVersion   string             `json:"openssh_version"`
+	PQKexAlgorithms  []string           `json:"pq_kex_algorithms"`
 	OsInfo           string             `json:"os_info"`
 }
 
@@ -59,6 +60,7 @@ func (t *TotalResults) SetOsInfo() {
 
 func (t *TotalResults) SetOpenSSHVersion() {
 	t.OpenSSHVersion = sysdetails.GetOpenSSHVersion()
+	t.PQKexAlgorithms = sysdetails.SupportedPQKexAlgorithms(t.OpenSSHVersion)
 }
 
 func (t *TotalResults) SetOk() {
@@ -0,0 +1,373 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/sshcert"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultCAMaxLifetime is how long the certificates signed by opkssh ca
+// sign are valid at most, unless ca max_lifetime is set in the server config
+const DefaultCAMaxLifetime = time.Hour
+
+// caClockSkew is how long before it is signed a certificate is valid, so
+// that hosts whose clock is a little behind accept it
+const caClockSkew = 5 * time.Minute
+
+// caKeyComment is the comment of the CA keys opkssh ca creates
+const caKeyComment = "opkssh-ca"
+
+// caExtensions are the extensions of a certificate without restrictions,
+// the ones ssh-keygen sets by default
+var caExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// caRestrictions are the extensions each authorized_keys option removes
+var caRestrictions = map[string][]string{
+	"no-agent-forwarding": {"permit-agent-forwarding"},
+	"no-port-forwarding":  {"permit-port-forwarding"},
+	"no-pty":              {"permit-pty"},
+	"no-user-rc":          {"permit-user-rc"},
+	"no-x11-forwarding":   {"permit-X11-forwarding"},
+	"restrict":            {"permit-X11-forwarding", "permit-agent-forwarding", "permit-port-forwarding", "permit-pty", "permit-user-rc"},
+}
+
+// CACmd manages the SSH CA of the server. sshd trusts the CA with
+// TrustedUserCAKeys, and Sign turns the opkssh certificates of the logins
+// verify allows into short-lived certificates of the CA, so that sshd
+// checks them without running opkssh.
+type CACmd struct {
+	Fs  afero.Fs
+	In  io.Reader
+	Out io.Writer
+	// FileSystem sets the owner and permissions of the files written
+	FileSystem files.FileSystem
+	// KeyPath is the private key of the CA
+	KeyPath string
+	// PublicKeysPath lists the public keys sshd trusts, the current key
+	// first and then the key it replaced
+	PublicKeysPath string
+	// Verify checks the opkssh certificates before they are signed
+	Verify *VerifyCmd
+	// MaxLifetime is how long a certificate is valid at most, it never
+	// outlives the PK Token it was signed for
+	MaxLifetime time.Duration
+
+	permChecker files.PermsChecker
+	now         func() time.Time
+	rand        io.Reader
+}
+
+// NewCACmd returns a CACmd of the CA key and public keys at their default
+// paths
+func NewCACmd(rt *Runtime) *CACmd {
+	return &CACmd{
+		Fs:             rt.Fs,
+		In:             rt.In,
+		Out:            rt.Out,
+		FileSystem:     files.NewFileSystem(rt.Fs),
+		KeyPath:        policy.SystemDefaultCAKeyPath,
+		PublicKeysPath: policy.SystemDefaultCAPublicKeysPath,
+		MaxLifetime:    DefaultCAMaxLifetime,
+		permChecker:    files.PermsChecker{Fs: rt.Fs, CmdRunner: rt.CmdRunner},
+		now:            rt.Now,
+		rand:           rand.Reader,
+	}
+}
+
+// ApplyServerConfig sets the settings of the ca section of serverConfig,
+// which may be nil
+func (c *CACmd) ApplyServerConfig(serverConfig *config.ServerConfig) error {
+	if serverConfig == nil || serverConfig.CA.MaxLifetime == "" {
+		return nil
+	}
+	maxLifetime, err := time.ParseDuration(serverConfig.CA.MaxLifetime)
+	if err != nil || maxLifetime <= 0 {
+		return fmt.Errorf("invalid ca max_lifetime %q in the server config", serverConfig.CA.MaxLifetime)
+	}
+	c.MaxLifetime = maxLifetime
+	return nil
+}
+
+// Init creates the CA key and the public keys file
+func (c *CACmd) Init() error {
+	if exists, err := afero.Exists(c.Fs, c.KeyPath); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("the CA key %s already exists, replace it with opkssh ca rotate", c.KeyPath)
+	}
+	signer, keyPEM, err := c.generateKey()
+	if err != nil {
+		return err
+	}
+	// sshd trusts the key before anything is signed with it
+	if err := c.writeFile(c.PublicKeysPath, ssh.MarshalAuthorizedKey(signer.PublicKey()), files.RequiredPerms.CAPublicKeys); err != nil {
+		return err
+	}
+	if err := c.writeFile(c.KeyPath, keyPEM, files.RequiredPerms.CAKey); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Created the CA key %s (%s)\n", c.KeyPath, ssh.FingerprintSHA256(signer.PublicKey()))
+	fmt.Fprintf(c.Out, "Add this line to the sshd config to trust it:\n  TrustedUserCAKeys %s\n", c.PublicKeysPath)
+	return nil
+}
+
+// Rotate replaces the CA key. The public keys file keeps the key it
+// replaces, so that the certificates signed with it are trusted until they
+// expire, and drops the key before it.
+func (c *CACmd) Rotate() error {
+	previous, err := c.loadKey()
+	if err != nil {
+		return err
+	}
+	signer, keyPEM, err := c.generateKey()
+	if err != nil {
+		return err
+	}
+	publicKeys := append(ssh.MarshalAuthorizedKey(signer.PublicKey()), ssh.MarshalAuthorizedKey(previous.PublicKey())...)
+	if err := c.writeFile(c.PublicKeysPath, publicKeys, files.RequiredPerms.CAPublicKeys); err != nil {
+		return err
+	}
+	if err := c.writeFile(c.KeyPath, keyPEM, files.RequiredPerms.CAKey); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Replaced the CA key %s with %s\n", ssh.FingerprintSHA256(previous.PublicKey()), ssh.FingerprintSHA256(signer.PublicKey()))
+	fmt.Fprintf(c.Out, "%s trusts both until the next rotation, rotate again once the certificates of the old key have expired, after at most %s\n", c.PublicKeysPath, c.MaxLifetime)
+	return nil
+}
+
+// Sign verifies the opkssh certificate at certPath, or on In if certPath
+// is -, like verify does for a login as principal. If the login is allowed
+// it writes a certificate of the CA for the key of the opkssh certificate
+// to Out.
+func (c *CACmd) Sign(ctx context.Context, principal string, certPath string) error {
+	typArg, certB64Arg, err := c.readCert(certPath)
+	if err != nil {
+		return err
+	}
+	signer, err := c.loadKey()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Verify.AuthorizedKeysCommand(ctx, principal, typArg, certB64Arg, nil); err != nil {
+		return err
+	}
+	opkCert, err := sshcert.NewFromAuthorizedKey(typArg, certB64Arg)
+	if err != nil {
+		return err
+	}
+	pkt, err := opkCert.GetPKToken()
+	if err != nil {
+		return err
+	}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return err
+	}
+	claims := idt.GetClaims()
+
+	now := c.now()
+	validBefore := now.Add(c.MaxLifetime)
+	if c.Verify.ProviderPolicy != nil {
+		if expiresAt, ok := c.Verify.ProviderPolicy.ExpiresAt(claims.Issuer, claims.Audience, time.Unix(claims.IssuedAt, 0), time.Unix(claims.Expiration, 0)); ok && expiresAt.Before(validBefore) {
+			validBefore = expiresAt
+		}
+	} else if claims.Expiration != 0 && time.Unix(claims.Expiration, 0).Before(validBefore) {
+		validBefore = time.Unix(claims.Expiration, 0)
+	}
+	var options []string
+	if c.Verify.match != nil {
+		options = c.Verify.match.Options
+	}
+	permissions, expiry, err := certPermissions(options)
+	if err != nil {
+		return fmt.Errorf("refusing to sign the certificate: %w", err)
+	}
+	if !expiry.IsZero() && expiry.Before(validBefore) {
+		validBefore = expiry
+	}
+	if !validBefore.After(now) {
+		return fmt.Errorf("refusing to sign the certificate: the PK Token expired at %s", validBefore.UTC().Format(time.RFC3339))
+	}
+
+	var serial [8]byte
+	if _, err := io.ReadFull(c.rand, serial[:]); err != nil {
+		return err
+	}
+	keyID := claims.Email
+	if keyID == "" {
+		keyID = claims.Subject
+	}
+	cert := &ssh.Certificate{
+		Key:             opkCert.SshCert.Key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           keyID + " " + claims.Issuer,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(now.Add(-caClockSkew).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions:     permissions,
+	}
+	if err := cert.SignCert(c.rand, signer); err != nil {
+		return fmt.Errorf("failed to sign the certificate: %w", err)
+	}
+	_, err = c.Out.Write(ssh.MarshalAuthorizedKey(cert))
+	return err
+}
+
+// certPermissions returns the critical options and extensions of a
+// certificate restricted by the authorized_keys options, and the
+// expiry-time option if set. Options that a certificate can't express are
+// an error rather than being dropped.
+func certPermissions(options []string) (ssh.Permissions, time.Time, error) {
+	permissions := ssh.Permissions{CriticalOptions: map[string]string{}, Extensions: map[string]string{}}
+	for name, value := range caExtensions {
+		permissions.Extensions[name] = value
+	}
+	var expiry time.Time
+	for _, option := range options {
+		name, value, err := files.ParseKeyOption(option)
+		if err != nil {
+			return ssh.Permissions{}, time.Time{}, err
+		}
+		switch name {
+		case "command":
+			permissions.CriticalOptions["force-command"] = value
+		case "from":
+			for _, address := range strings.Split(value, ",") {
+				if _, _, err := net.ParseCIDR(address); err != nil && net.ParseIP(address) == nil {
+					return ssh.Permissions{}, time.Time{}, fmt.Errorf("option from=%q must only list addresses and CIDR ranges in a certificate", value)
+				}
+			}
+			permissions.CriticalOptions["source-address"] = value
+		case "expiry-time":
+			if expiry, err = parseKeyExpiryTime(value); err != nil {
+				return ssh.Permissions{}, time.Time{}, err
+			}
+		default:
+			removed, ok := caRestrictions[name]
+			if !ok {
+				return ssh.Permissions{}, time.Time{}, fmt.Errorf("option %s has no equivalent in a certificate", name)
+			}
+			for _, extension := range removed {
+				delete(permissions.Extensions, extension)
+			}
+		}
+	}
+	return permissions, expiry, nil
+}
+
+// parseKeyExpiryTime parses the value of the expiry-time option,
+// YYYYMMDD[HHMM[SS]] in local time or in UTC if followed by Z
+func parseKeyExpiryTime(value string) (time.Time, error) {
+	location := time.Local
+	if trimmed, ok := strings.CutSuffix(value, "Z"); ok {
+		value, location = trimmed, time.UTC
+	}
+	for _, layout := range []string{"20060102", "200601021504", "20060102150405"} {
+		if len(value) == len(layout) {
+			if expiry, err := time.ParseInLocation(layout, value, location); err == nil {
+				return expiry, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry-time %q, expected YYYYMMDD[HHMM[SS]][Z]", value)
+}
+
+// readCert returns the key type and base64 certificate of the opkssh
+// certificate file at path, or on In if path is -
+func (c *CACmd) readCert(path string) (string, string, error) {
+	if path != "-" {
+		return ReadSshCertFile(c.Fs, path)
+	}
+	content, err := io.ReadAll(c.In)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read SSH certificate: %w", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 2 || !strings.HasSuffix(fields[0], "-cert-v01@openssh.com") {
+		return "", "", fmt.Errorf("stdin is not an SSH certificate")
+	}
+	return fields[0], fields[1], nil
+}
+
+// generateKey returns a new ed25519 CA key and its OpenSSH PEM encoding
+func (c *CACmd) generateKey() (ssh.Signer, []byte, error) {
+	_, key, err := ed25519.GenerateKey(c.rand)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate the CA key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, caKeyComment)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signer, pem.EncodeToMemory(block), nil
+}
+
+// loadKey reads the CA key, which must only be readable by its owner
+func (c *CACmd) loadKey() (ssh.Signer, error) {
+	if _, err := c.Fs.Stat(c.KeyPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("the CA key %s does not exist, create it with opkssh ca init", c.KeyPath)
+	}
+	perm := files.RequiredPerms.CAKey
+	content, err := c.permChecker.ReadFile(c.KeyPath, []fs.FileMode{perm.Mode}, perm.Owner, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the CA key %s: %w", c.KeyPath, err)
+	}
+	return signer, nil
+}
+
+// writeFile replaces the file at path with content. The file is given the
+// permissions perm before the content is written.
+func (c *CACmd) writeFile(path string, content []byte, perm files.PermInfo) error {
+	if err := files.SetSecureFileState(c.FileSystem, path, perm, false); err != nil {
+		return err
+	}
+	return files.WriteFileAtomic(c.Fs, path, content, perm.Mode)
+}
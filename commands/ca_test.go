@@ -0,0 +1,231 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/sshcert"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestCACmd(t *testing.T, fs afero.Fs, out *bytes.Buffer) *CACmd {
+	t.Helper()
+	statRunner := func(name string, arg ...string) ([]byte, error) { return []byte("root root"), nil }
+	return &CACmd{
+		Fs:             fs,
+		Out:            out,
+		FileSystem:     &mockFileSystem{fs: fs},
+		KeyPath:        "/etc/opk/ca_key",
+		PublicKeysPath: "/etc/opk/ca_keys.pub",
+		MaxLifetime:    DefaultCAMaxLifetime,
+		permChecker:    files.PermsChecker{Fs: fs, CmdRunner: statRunner},
+		now:            time.Now,
+		rand:           rand.Reader,
+	}
+}
+
+// trustedCAKeys returns the keys in the public keys file of c
+func trustedCAKeys(t *testing.T, c *CACmd) []ssh.PublicKey {
+	t.Helper()
+	content, err := afero.ReadFile(c.Fs, c.PublicKeysPath)
+	require.NoError(t, err)
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(content)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(content)
+		require.NoError(t, err)
+		keys = append(keys, key)
+		content = rest
+	}
+	return keys
+}
+
+// checkCACert parses the certificate signed by c and checks it for
+// principal against the public keys file of c
+func checkCACert(t *testing.T, c *CACmd, signed string, principal string) (*ssh.Certificate, error) {
+	t.Helper()
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	require.NoError(t, err)
+	cert, ok := key.(*ssh.Certificate)
+	require.True(t, ok)
+	checker := ssh.CertChecker{SupportedCriticalOptions: []string{"force-command", "source-address"}, IsUserAuthority: func(auth ssh.PublicKey) bool {
+		for _, trusted := range trustedCAKeys(t, c) {
+			if bytes.Equal(trusted.Marshal(), auth.Marshal()) {
+				return true
+			}
+		}
+		return false
+	}}
+	return cert, checker.CheckCert(principal, cert)
+}
+
+func TestCAInitRotate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	c := newTestCACmd(t, fs, out)
+
+	_, err := c.loadKey()
+	require.ErrorContains(t, err, "the CA key /etc/opk/ca_key does not exist, create it with opkssh ca init")
+	require.ErrorContains(t, c.Rotate(), "create it with opkssh ca init")
+
+	require.NoError(t, c.Init())
+	require.Contains(t, out.String(), "TrustedUserCAKeys /etc/opk/ca_keys.pub")
+	info, err := fs.Stat(c.KeyPath)
+	require.NoError(t, err)
+	require.Equal(t, files.RequiredPerms.CAKey.Mode, info.Mode().Perm())
+	info, err = fs.Stat(c.PublicKeysPath)
+	require.NoError(t, err)
+	require.Equal(t, files.RequiredPerms.CAPublicKeys.Mode, info.Mode().Perm())
+	first, err := c.loadKey()
+	require.NoError(t, err)
+	require.Equal(t, []ssh.PublicKey{first.PublicKey()}, trustedCAKeys(t, c))
+	require.ErrorContains(t, c.Init(), "already exists")
+
+	// The replaced key is trusted until the next rotation
+	require.NoError(t, c.Rotate())
+	second, err := c.loadKey()
+	require.NoError(t, err)
+	require.Equal(t, []ssh.PublicKey{second.PublicKey(), first.PublicKey()}, trustedCAKeys(t, c))
+	require.NoError(t, c.Rotate())
+	third, err := c.loadKey()
+	require.NoError(t, err)
+	require.Equal(t, []ssh.PublicKey{third.PublicKey(), second.PublicKey()}, trustedCAKeys(t, c))
+
+	// The key must only be readable by root
+	require.NoError(t, fs.Chmod(c.KeyPath, 0o640))
+	_, err = c.loadKey()
+	require.ErrorContains(t, err, "failed to read the CA key")
+}
+
+func TestCASign(t *testing.T) {
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+	providerOpts := providers.DefaultMockProviderOpts()
+	providerOpts.Issuer = "https://accounts.google.com"
+	op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{"email": "arthur.aardvark@example.com"}
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+	opkCert, err := sshcert.New(pkt, nil, []string{"dev"})
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoECDSA256})
+	require.NoError(t, err)
+	sshCert, err := opkCert.SignCert(signerMas)
+	require.NoError(t, err)
+	verPkt, err := verifier.New(op, verifier.WithExpirationPolicy(verifier.ExpirationPolicies.NEVER_EXPIRE))
+	require.NoError(t, err)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/home/dev/.ssh/id_ecdsa-cert.pub", ssh.MarshalAuthorizedKey(sshCert), 0o644))
+	out := &bytes.Buffer{}
+	c := newTestCACmd(t, fs, out)
+	require.NoError(t, c.Init())
+	out.Reset()
+
+	var options []string
+	c.Verify = &VerifyCmd{Fs: fs, PktVerifier: *verPkt}
+	c.Verify.CheckPolicy = func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+		if userDesired != "dev" {
+			return fmt.Errorf("no policy to allow %s", userDesired)
+		}
+		c.Verify.RecordMatch(policy.Match{Entry: "dev arthur.aardvark@example.com https://accounts.google.com", Options: options})
+		return nil
+	}
+
+	require.NoError(t, c.Sign(context.Background(), "dev", "/home/dev/.ssh/id_ecdsa-cert.pub"))
+	cert, err := checkCACert(t, c, out.String(), "dev")
+	require.NoError(t, err)
+	require.Equal(t, sshCert.Key.Marshal(), cert.Key.Marshal())
+	require.Equal(t, "arthur.aardvark@example.com https://accounts.google.com", cert.KeyId)
+	require.Equal(t, caExtensions, cert.Extensions)
+	require.Empty(t, cert.CriticalOptions)
+	require.LessOrEqual(t, time.Until(time.Unix(int64(cert.ValidBefore), 0)), DefaultCAMaxLifetime)
+	_, err = checkCACert(t, c, out.String(), "root")
+	require.ErrorContains(t, err, `principal "root" not in the set of valid principals`)
+
+	// The options that restrict the login restrict the certificate
+	options = []string{"no-pty", "no-port-forwarding", `command="/usr/bin/rrsync -ro /backup"`, `from="10.0.0.0/8,192.0.2.1"`}
+	out.Reset()
+	require.NoError(t, c.Sign(context.Background(), "dev", "/home/dev/.ssh/id_ecdsa-cert.pub"))
+	cert, err = checkCACert(t, c, out.String(), "dev")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"force-command": "/usr/bin/rrsync -ro /backup", "source-address": "10.0.0.0/8,192.0.2.1"}, cert.CriticalOptions)
+	require.NotContains(t, cert.Extensions, "permit-pty")
+	require.NotContains(t, cert.Extensions, "permit-port-forwarding")
+	require.Contains(t, cert.Extensions, "permit-agent-forwarding")
+
+	// An expiry-time earlier than the PK Token shortens the certificate
+	options = []string{`expiry-time="` + time.Now().Add(10*time.Minute).UTC().Format("200601021504") + `Z"`}
+	out.Reset()
+	require.NoError(t, c.Sign(context.Background(), "dev", "/home/dev/.ssh/id_ecdsa-cert.pub"))
+	cert, err = checkCACert(t, c, out.String(), "dev")
+	require.NoError(t, err)
+	require.Less(t, time.Until(time.Unix(int64(cert.ValidBefore), 0)), 11*time.Minute)
+
+	// Restrictions a certificate can't express are not dropped
+	for _, option := range []string{`permitopen="localhost:80"`, `from="*.example.com"`} {
+		options = []string{option}
+		out.Reset()
+		require.ErrorContains(t, c.Sign(context.Background(), "dev", "/home/dev/.ssh/id_ecdsa-cert.pub"), "refusing to sign the certificate", option)
+		require.Empty(t, out.String())
+	}
+
+	options = nil
+	require.ErrorContains(t, c.Sign(context.Background(), "root", "/home/dev/.ssh/id_ecdsa-cert.pub"), "no policy to allow root")
+	require.Empty(t, out.String())
+
+	// The certificate can be read on stdin
+	c.In = strings.NewReader(string(ssh.MarshalAuthorizedKey(sshCert)))
+	require.NoError(t, c.Sign(context.Background(), "dev", "-"))
+	_, err = checkCACert(t, c, out.String(), "dev")
+	require.NoError(t, err)
+	c.In = strings.NewReader("ssh-ed25519 AAAA")
+	require.ErrorContains(t, c.Sign(context.Background(), "dev", "-"), "stdin is not an SSH certificate")
+}
+
+func TestParseKeyExpiryTime(t *testing.T) {
+	expiry, err := parseKeyExpiryTime("20260102Z")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC), expiry)
+	expiry, err = parseKeyExpiryTime("20260102030405Z")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), expiry)
+	_, err = parseKeyExpiryTime("2026-01-02")
+	require.ErrorContains(t, err, "invalid expiry-time")
+}
@@ -19,9 +19,10 @@ package config
 import (
 	_ "embed"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 
 	"github.com/spf13/afero"
 	"gopkg.in/yaml.v3"
@@ -33,6 +34,13 @@ var DefaultClientConfig []byte
 type ClientConfig struct {
 	DefaultProvider string           `yaml:"default_provider"`
 	Providers       []ProviderConfig `yaml:"providers"`
+	// ExpiryWarning is a duration (e.g. 30m). Login warns if the ID Token
+	// expires within this window.
+	ExpiryWarning string `yaml:"expiry_warning"`
+	// StoreToken makes every login save its PK Token, as login
+	// --store-token does
+	StoreToken bool            `yaml:"store_token"`
+	Telemetry  TelemetryConfig `yaml:"telemetry"`
 }
 
 func NewClientConfig(c []byte) (*ClientConfig, error) {
@@ -59,19 +67,43 @@ func (c *ClientConfig) GetByIssuer(issuer string) (*ProviderConfig, bool) {
 	return nil, false
 }
 
+// DefaultClientConfigDir returns the directory of the client config and other
+// client state: ~/Library/Application Support/opkssh on macOS and ~/.opk
+// elsewhere.
+func DefaultClientConfigDir() (string, error) {
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user config dir: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		macDir := filepath.Join(dir, "Library", "Application Support", "opkssh")
+		// Keep using ~/.opk for users who set up opkssh before it had a macOS
+		// specific location
+		legacyDir := filepath.Join(dir, ".opk")
+		if _, err := os.Stat(filepath.Join(macDir, "config.yml")); err != nil {
+			if _, err := os.Stat(filepath.Join(legacyDir, "config.yml")); err == nil {
+				return legacyDir, nil
+			}
+		}
+		return macDir, nil
+	}
+	return filepath.Join(dir, ".opk"), nil
+}
+
 func ResolveClientConfigPath(configPath *string) error {
 	if *configPath == "" {
-		dir, dirErr := os.UserHomeDir()
-		if dirErr != nil {
-			return fmt.Errorf("failed to get user config dir: %w", dirErr)
+		dir, err := DefaultClientConfigDir()
+		if err != nil {
+			return err
 		}
-		*configPath = filepath.Join(dir, ".opk", "config.yml")
+		*configPath = filepath.Join(dir, "config.yml")
 	}
 	return nil
 }
 
 // GetClientConfigFromFile retrieves the client config from the configuration file at configPath.
-// If configPath is not specified then the default configuration path is uses ~/.opk/config.yml
+// If configPath is not specified then config.yml in DefaultClientConfigDir is
+// used.
 func GetClientConfigFromFile(configPath string, Fs afero.Fs) (*ClientConfig, error) {
 	if err := ResolveClientConfigPath(&configPath); err != nil {
 		return nil, err
@@ -99,6 +131,6 @@ func CreateDefaultClientConfig(configPath string, Fs afero.Fs) error {
 	if err := afs.WriteFile(configPath, DefaultClientConfig, 0o644); err != nil {
 		return fmt.Errorf("failed to write default config file: %w", err)
 	}
-	log.Printf("created client config file at %s", configPath)
+	slog.Info("Created client config file", "path", configPath)
 	return nil
 }
@@ -17,6 +17,10 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -76,3 +80,42 @@ providers:
 	require.NotNil(t, clientConfig)
 	require.Equal(t, clientConfig.Providers[0].SendAccessToken, true)
 }
+
+func TestParseConfigWithRedirectPorts(t *testing.T) {
+	c := `---
+providers:
+  - alias: work
+    issuer: https://idp.example.com
+    client_id: opkssh
+    redirect_ports: [3000, 4000]`
+
+	clientConfig, err := NewClientConfig([]byte(c))
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://localhost:3000/login-callback", "http://localhost:4000/login-callback"}, clientConfig.Providers[0].RedirectURIs)
+
+	_, err = NewClientConfig([]byte(c + "\n    redirect_uris: [http://localhost:3000/login-callback]"))
+	require.ErrorContains(t, err, "sets both redirect_uris and redirect_ports")
+	_, err = NewClientConfig([]byte(strings.Replace(c, "4000", "70000", 1)))
+	require.ErrorContains(t, err, "invalid redirect port 70000")
+}
+
+func TestDefaultClientConfigDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+
+	dir, err := DefaultClientConfigDir()
+	require.NoError(t, err)
+	if runtime.GOOS != "darwin" {
+		require.Equal(t, filepath.Join(home, ".opk"), dir)
+		return
+	}
+	require.Equal(t, filepath.Join(home, "Library", "Application Support", "opkssh"), dir)
+
+	// An existing ~/.opk/config.yml keeps being used
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".opk"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".opk", "config.yml"), DefaultClientConfig, 0o600))
+	dir, err = DefaultClientConfigDir()
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(home, ".opk"), dir)
+}
@@ -45,6 +45,9 @@ type ProviderConfig struct {
 	// logic and should not be specified most of the time.
 	RemoteRedirectURI string `yaml:"remote_redirect_uri,omitempty"`
 	SendAccessToken   bool   `yaml:"send_access_token,omitempty"`
+	// MaxValidity is the longest duration, e.g. 12h, that login --validity
+	// may request for certificates of this provider
+	MaxValidity string `yaml:"max_validity,omitempty"`
 }
 
 func (p *ProviderConfig) UnmarshalYAML(value *yaml.Node) error {
@@ -64,6 +67,9 @@ func (p *ProviderConfig) UnmarshalYAML(value *yaml.Node) error {
 		// logic and should not be specified most of the time.
 		RemoteRedirectURI string `yaml:"remote_redirect_uri,omitempty"`
 		SendAccessToken   bool   `yaml:"send_access_token,omitempty"`
+		MaxValidity       string `yaml:"max_validity,omitempty"`
+		// RedirectPorts is a shorter way to write redirect URIs on localhost
+		RedirectPorts []int `yaml:"redirect_ports,omitempty"`
 	}
 
 	// Set default values
@@ -79,6 +85,18 @@ func (p *ProviderConfig) UnmarshalYAML(value *yaml.Node) error {
 	if err := value.Decode(&tmp); err != nil {
 		return err
 	}
+	if len(tmp.RedirectPorts) > 0 {
+		if hasKey(value, "redirect_uris") {
+			return fmt.Errorf("provider %s sets both redirect_uris and redirect_ports, use only one", tmp.Issuer)
+		}
+		tmp.RedirectURIs = []string{}
+		for _, port := range tmp.RedirectPorts {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("provider %s has invalid redirect port %d", tmp.Issuer, port)
+			}
+			tmp.RedirectURIs = append(tmp.RedirectURIs, fmt.Sprintf("http://localhost:%d/login-callback", port))
+		}
+	}
 	*p = ProviderConfig{
 		AliasList:         strings.Fields(tmp.AliasList),
 		Issuer:            tmp.Issuer,
@@ -90,10 +108,24 @@ func (p *ProviderConfig) UnmarshalYAML(value *yaml.Node) error {
 		RedirectURIs:      tmp.RedirectURIs,
 		RemoteRedirectURI: tmp.RemoteRedirectURI,
 		SendAccessToken:   tmp.SendAccessToken,
+		MaxValidity:       tmp.MaxValidity,
 	}
 	return nil
 }
 
+// hasKey returns true if the mapping node has key
+func hasKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
 // TODO: Move this into OpenPubkey providers package
 func DefaultProviderConfig() ProviderConfig {
 	return ProviderConfig{
@@ -121,6 +153,25 @@ func GitHubProviderConfig() ProviderConfig {
 	}
 }
 
+// KeycloakProviderConfig returns the config of a Keycloak realm. issuer is
+// the realm URL, e.g. https://keycloak.example.com/realms/myrealm. The roles
+// scope is requested so that realm_access and resource_access can be used in
+// policy.
+func KeycloakProviderConfig(issuer string, clientID string) ProviderConfig {
+	providerConfig := DefaultProviderConfig()
+	providerConfig.AliasList = []string{"keycloak"}
+	providerConfig.Issuer = issuer
+	providerConfig.ClientID = clientID
+	providerConfig.Scopes = []string{"openid", "email", "profile", "roles"}
+	return providerConfig
+}
+
+// IsKeycloakIssuer returns true if issuer looks like a Keycloak realm URL
+func IsKeycloakIssuer(issuer string) bool {
+	_, realm, ok := strings.Cut(strings.TrimSuffix(issuer, "/"), "/realms/")
+	return ok && realm != "" && !strings.Contains(realm, "/")
+}
+
 // NewProviderConfigFromString is a function to create the provider config from a string of the format
 // {alias},{provider_url},{client_id},{client_secret},{scopes}
 func NewProviderConfigFromString(configStr string, hasAlias bool) (ProviderConfig, error) {
@@ -155,6 +206,8 @@ func NewProviderConfigFromString(configStr string, hasAlias bool) (ProviderConfi
 
 	if len(parts) > 3 {
 		providerConfig.Scopes = strings.Split(parts[3], " ")
+	} else if IsKeycloakIssuer(providerConfig.Issuer) {
+		providerConfig.Scopes = KeycloakProviderConfig(providerConfig.Issuer, providerConfig.ClientID).Scopes
 	} else {
 		providerConfig.Scopes = []string{"openid", "email"}
 	}
@@ -159,6 +159,12 @@ func TestProviderConfigFromString(t *testing.T) {
 			hasAlias:       false,
 			expectedIssuer: "https://issuer.hello.coop",
 		},
+		{
+			name:           "Good path with test Keycloak OP",
+			configString:   "keycloak,https://keycloak.example.com/realms/myrealm,opkssh",
+			hasAlias:       true,
+			expectedIssuer: "https://keycloak.example.com/realms/myrealm",
+		},
 		{
 			name:           "Alias set but no alias expected",
 			configString:   "exampleOp,https://token.example.com/,client_id,,openid profile email,",
@@ -202,3 +208,20 @@ func TestProviderConfigFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestKeycloakProviderConfig(t *testing.T) {
+	providerConfig, err := NewProviderConfigFromString("keycloak,https://keycloak.example.com/realms/myrealm,opkssh", true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"openid", "email", "profile", "roles"}, providerConfig.Scopes)
+	require.Equal(t, KeycloakProviderConfig("https://keycloak.example.com/realms/myrealm", "opkssh").Scopes, providerConfig.Scopes)
+
+	// Explicit scopes are kept
+	providerConfig, err = NewProviderConfigFromString("https://keycloak.example.com/realms/myrealm,opkssh,,openid email", false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"openid", "email"}, providerConfig.Scopes)
+
+	require.True(t, IsKeycloakIssuer("https://keycloak.example.com/realms/myrealm/"))
+	require.True(t, IsKeycloakIssuer("https://sso.example.com/auth/realms/myrealm"))
+	require.False(t, IsKeycloakIssuer("https://example.com/realms/"))
+	require.False(t, IsKeycloakIssuer("https://accounts.google.com"))
+}
@@ -27,6 +27,349 @@ type ServerConfig struct {
 	EnvVars    map[string]string `yaml:"env_vars"`
 	DenyUsers  []string          `yaml:"deny_users"`
 	DenyEmails []string          `yaml:"deny_emails"`
+	HomePolicy HomePolicyConfig  `yaml:"home_policy"`
+	Provision  ProvisionConfig   `yaml:"provision"`
+	// ExpiryWarning is a duration (e.g. 2h). Logins whose PK Token expires
+	// within this window are still allowed but emit an audit event.
+	ExpiryWarning string               `yaml:"expiry_warning"`
+	Notifications []NotificationConfig `yaml:"notifications"`
+	DualControl   DualControlConfig    `yaml:"dual_control"`
+	Okta          OktaConfig           `yaml:"okta"`
+	AzureAD       AzureADConfig        `yaml:"azure_ad"`
+	// GoogleWorkspace configures `opkssh sync google`
+	GoogleWorkspace GoogleWorkspaceConfig `yaml:"google_workspace"`
+	PolicyFragments PolicyFragmentsConfig `yaml:"policy_fragments"`
+	Proxy           ProxyConfig           `yaml:"proxy"`
+	// Preflight is warn (default), strict or off. It sets what verify and
+	// the daemons do when the configuration does not pass validation.
+	Preflight string          `yaml:"preflight"`
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+	Fleet     FleetConfig     `yaml:"fleet"`
+	Plugins   PluginsConfig   `yaml:"plugins"`
+	Audit     AuditConfig     `yaml:"audit"`
+	// DenyReasons sets where verify writes why a login was denied
+	DenyReasons DenyReasonsConfig `yaml:"deny_reasons"`
+	// PolicyBackups sets where the system policy is backed up before it
+	// is changed
+	PolicyBackups PolicyBackupsConfig `yaml:"policy_backups"`
+	// PolicyStore selects where the system policy is stored
+	PolicyStore PolicyStoreConfig `yaml:"policy_store"`
+	// JWKSCache sets how verify uses the cached keys of the providers
+	JWKSCache JWKSCacheConfig `yaml:"jwks_cache"`
+	// RateLimit throttles clients that keep failing to log in
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// ReplayCache refuses PK Tokens replayed on other hosts
+	ReplayCache ReplayCacheConfig `yaml:"replay_cache"`
+	// Issuers restricts the issuers of the PK Tokens verify accepts, before
+	// the token is verified
+	Issuers IssuersConfig `yaml:"issuers"`
+	// Logging sets the level, format and destinations of the opkssh log
+	Logging LoggingConfig `yaml:"logging"`
+	// CA sets the certificates opkssh ca sign issues
+	CA CAConfig `yaml:"ca"`
+}
+
+// CAConfig configures the SSH CA of opkssh ca
+type CAConfig struct {
+	// MaxLifetime is a duration such as 8h (default 1h) the certificates
+	// are valid for at most. They never outlive the PK Token.
+	MaxLifetime string `yaml:"max_lifetime"`
+}
+
+// LoggingConfig sets how the opkssh log is written. The zero value keeps
+// the plain log file.
+type LoggingConfig struct {
+	// Level is debug, info (default), warn or error
+	Level string `yaml:"level"`
+	// Format is text (default) or json
+	Format string `yaml:"format"`
+	// Destinations are stderr, file (default), syslog or eventlog
+	Destinations []string `yaml:"destinations"`
+	// Path is the file of the file destination, default the opkssh log
+	// file
+	Path string `yaml:"path"`
+}
+
+// IsEmpty returns true if the logging section is not set
+func (c LoggingConfig) IsEmpty() bool {
+	return c.Level == "" && c.Format == "" && len(c.Destinations) == 0 && c.Path == ""
+}
+
+// IssuersConfig is a global allow list and deny list of issuers, applied to
+// every login whatever the policy says
+type IssuersConfig struct {
+	// Allow, if not empty, lists the only issuers accepted
+	Allow []string `yaml:"allow"`
+	// Deny lists issuers that are refused, e.g. a compromised provider,
+	// even if they are in Allow
+	Deny []string `yaml:"deny"`
+}
+
+// ReplayCacheConfig records the jti of the PK Tokens used to log in until
+// they expire, so that a captured token and key can't be used on another
+// host sharing the cache
+type ReplayCacheConfig struct {
+	// Backend is memory (opkssh serve only), file or sqlite. Empty disables
+	// the replay cache.
+	Backend string `yaml:"backend"`
+	// Path is the directory of the file backend (default
+	// /var/lib/opk/replay) or the database of the sqlite backend (default
+	// /var/lib/opk/replay/replay.db)
+	Path string `yaml:"path"`
+	// Bind is host (default), a token can only be used on the host it was
+	// first used on, or client, also only from the same client address
+	Bind string `yaml:"bind"`
+	// SingleUse only accepts each token once
+	SingleUse bool `yaml:"single_use"`
+	// RequireJTI refuses the tokens without a jti claim
+	RequireJTI bool `yaml:"require_jti"`
+}
+
+// RateLimitConfig refuses the logins as a principal from a client address
+// for a while after too many of them failed, before the PK Token is checked
+// or any policy plugin runs
+type RateLimitConfig struct {
+	// MaxFailures is how many failed logins within Window throttle the
+	// client, 0 (default) disables the rate limit
+	MaxFailures int `yaml:"max_failures"`
+	// Window is a duration (default 10m) in which failures are counted
+	Window string `yaml:"window"`
+	// Block is a duration (default 15m) logins are refused for once the
+	// client is throttled
+	Block string `yaml:"block"`
+	// Report emits a login_throttled event when a client is throttled
+	Report bool `yaml:"report"`
+}
+
+// JWKSCacheConfig sets how long the cached and imported keys of a provider
+// are used while the provider can't be reached
+type JWKSCacheConfig struct {
+	// MaxStale is a duration such as 720h (default 24h), counted from when
+	// cached keys expire and from when keys are imported
+	MaxStale string `yaml:"max_stale"`
+}
+
+// PolicyStoreConfig selects the backend of the system policy
+type PolicyStoreConfig struct {
+	// Backend is file (default), the policy file, or sqlite
+	Backend string `yaml:"backend"`
+	// Path is the path of the sqlite database (default /etc/opk/policy.db)
+	Path string `yaml:"path"`
+}
+
+// PolicyBackupsConfig sets the backups of the system policy kept by the
+// commands that change it
+type PolicyBackupsConfig struct {
+	// Dir is the backup directory (default /var/lib/opk/backups)
+	Dir string `yaml:"dir"`
+	// Keep is how many backups are kept (default 10), -1 disables backups
+	Keep int `yaml:"keep"`
+}
+
+// DenyReasonsConfig sets where the reason of a denied login is written so it
+// can be shown to the user, such as by a keyboard-interactive PAM stack
+type DenyReasonsConfig struct {
+	// File is the path of the file written with the reason of the last
+	// denied login as a principal, %u is replaced by the principal. Empty
+	// only logs the reason.
+	File string `yaml:"file"`
+}
+
+// AuditConfig configures the structured audit log of the decisions made by
+// verify
+type AuditConfig struct {
+	// Destination is file, syslog or eventlog (Windows). Empty disables the
+	// audit log.
+	Destination string `yaml:"destination"`
+	// Path is the file records are appended to by the file destination
+	Path string `yaml:"path"`
+}
+
+// PluginsConfig sets how verify runs the policy plugins in policy.d
+type PluginsConfig struct {
+	// Workers is how many plugin commands run at the same time (default 4)
+	Workers int `yaml:"workers"`
+	// Timeout is a duration (e.g. 10s, default 30s) after which a plugin
+	// command is stopped and counts as failed
+	Timeout string `yaml:"timeout"`
+	// TrustedKeys are SSH public keys in authorized_keys format. When set,
+	// verify only loads the plugin configs with a detached signature from
+	// one of them.
+	TrustedKeys []string `yaml:"trusted_keys"`
+}
+
+// FleetConfig configures `opkssh fleet`, which copies the signed policy
+// fragments of a leader host to replica hosts over mutual TLS
+type FleetConfig struct {
+	// Role is leader or replica
+	Role string `yaml:"role"`
+	// Listen is the address the leader listens on
+	Listen string `yaml:"listen"`
+	// Leader is the https URL of the leader replicas pull from
+	Leader string `yaml:"leader"`
+	// CAFile is the CA that issues the leader and replica certificates
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the server certificate on the leader and
+	// the client certificate on replicas
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Interval is a duration (e.g. 5m) between pulls
+	Interval string `yaml:"interval"`
+}
+
+// TelemetryConfig opts in to sending anonymous usage and crash counts to
+// Endpoint, off by default
+type TelemetryConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Endpoint string `yaml:"endpoint"`
+	// Interval is how often the counts are sent, e.g. 24h (default)
+	Interval string `yaml:"interval"`
+}
+
+// ProxyConfig describes the SSH proxies and bastions connections arrive
+// through. It requires verify to be passed the connection with
+// --connection %C.
+type ProxyConfig struct {
+	Trusted []TrustedProxy `yaml:"trusted"`
+	// RequireFor lists principals that can only be assumed through one of
+	// the trusted proxies
+	RequireFor []string `yaml:"require_for"`
+}
+
+// TrustedProxy names the addresses, IPs or CIDRs, of a proxy
+type TrustedProxy struct {
+	Name      string   `yaml:"name"`
+	Addresses []string `yaml:"addresses"`
+}
+
+// PolicyFragmentsConfig configures signing of the policy fragments written
+// by `opkssh sync`
+type PolicyFragmentsConfig struct {
+	// SigningKey is the path of the SSH private key sync signs fragments with
+	SigningKey string `yaml:"signing_key"`
+	// TrustedKeys are SSH public keys in authorized_keys format. When set,
+	// verify ignores fragments not signed by one of them.
+	TrustedKeys []string `yaml:"trusted_keys"`
+}
+
+// GoogleWorkspaceConfig configures `opkssh sync google`, which grants the
+// members of Google Groups principals through a generated policy fragment
+type GoogleWorkspaceConfig struct {
+	// CredentialsFile is a service account key used with domain-wide
+	// delegation to impersonate Subject. If empty, the token of the
+	// attached service account is fetched from the metadata server.
+	CredentialsFile string `yaml:"credentials_file"`
+	Subject         string `yaml:"subject"`
+	// Issuer of the members' ID Tokens, defaults to https://accounts.google.com
+	Issuer string `yaml:"issuer"`
+	// AdminURL and MetadataURL default to the Google endpoints
+	AdminURL    string `yaml:"admin_url"`
+	MetadataURL string `yaml:"metadata_url"`
+	// Groups are mapped by group email
+	Groups []GroupSyncMapping `yaml:"groups"`
+}
+
+// AzureADConfig configures `opkssh sync azure`, which grants the members of
+// Azure AD groups principals through a generated policy fragment
+type AzureADConfig struct {
+	TenantID     string `yaml:"tenant_id"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// Issuer of the members' ID Tokens, defaults to the v2.0 issuer of TenantID
+	Issuer string `yaml:"issuer"`
+	// GraphURL and LoginURL default to the Azure public cloud
+	GraphURL string             `yaml:"graph_url"`
+	LoginURL string             `yaml:"login_url"`
+	Groups   []GroupSyncMapping `yaml:"groups"`
+}
+
+// GroupSyncMapping grants every member of a directory group the listed
+// principals
+type GroupSyncMapping struct {
+	// Group is the id (Azure AD object id) or email of the group
+	Group      string   `yaml:"group"`
+	Principals []string `yaml:"principals"`
+}
+
+// OktaConfig configures revoking identities when their Okta user is
+// suspended or deactivated, either by receiving Okta event hooks or by
+// polling the Okta System Log
+type OktaConfig struct {
+	// Issuer is the issuer of Okta ID Tokens in the opkssh policy
+	Issuer string `yaml:"issuer"`
+	// PrunePolicy also removes the user's entries from the system policy
+	PrunePolicy bool `yaml:"prune_policy"`
+	// Listen is the address the event hook receiver listens on
+	Listen string `yaml:"listen"`
+	// HookSecret is the Authorization header value configured for the
+	// event hook in Okta
+	HookSecret  string `yaml:"hook_secret"`
+	TLSCertFile string `yaml:"tls_cert_file"`
+	TLSKeyFile  string `yaml:"tls_key_file"`
+	// OrgURL and APIToken are used to poll the System Log
+	OrgURL   string `yaml:"org_url"`
+	APIToken string `yaml:"api_token"`
+	// PollInterval is a duration (e.g. 30s) between System Log requests
+	PollInterval string `yaml:"poll_interval"`
+}
+
+// DualControlConfig lists principals that can only be added to the system
+// policy with the approval of a second admin
+type DualControlConfig struct {
+	Principals []string `yaml:"principals"`
+}
+
+// NotificationConfig configures a sink that events are sent to
+type NotificationConfig struct {
+	// Type is one of webhook, slack or smtp
+	Type string `yaml:"type"`
+	// Events lists the event types sent to this sink, empty means all
+	Events []string `yaml:"events"`
+	// URL is the endpoint for webhook and slack sinks
+	URL string `yaml:"url"`
+	// SMTP settings for the smtp sink
+	SMTPAddr     string   `yaml:"smtp_addr"`
+	SMTPFrom     string   `yaml:"smtp_from"`
+	SMTPTo       []string `yaml:"smtp_to"`
+	SMTPUsername string   `yaml:"smtp_username"`
+	SMTPPassword string   `yaml:"smtp_password"`
+}
+
+// ProvisionConfig configures creating local accounts just in time when policy
+// authorizes an identity for a principal that does not exist locally
+type ProvisionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Command, if set, is run instead of useradd
+	Command string   `yaml:"command"`
+	Shell   string   `yaml:"shell"`
+	Groups  []string `yaml:"groups"`
+}
+
+// HomePolicyConfig restricts what users may grant in their own home policy
+// (~/.opk/auth_id). Entries that violate these constraints are ignored.
+type HomePolicyConfig struct {
+	// Disabled never reads home policies, only the system policies apply
+	Disabled bool `yaml:"disabled"`
+	// AllowedPrincipals and AllowedGroups, if either is set, only read the
+	// home policies of these users and of the members of these groups
+	AllowedPrincipals []string `yaml:"allowed_principals"`
+	AllowedGroups     []string `yaml:"allowed_groups"`
+	// AllowedIssuers lists the only issuers home policy entries may use
+	AllowedIssuers []string `yaml:"allowed_issuers"`
+	// ForbiddenPrincipals lists principals home policies may never grant
+	ForbiddenPrincipals []string `yaml:"forbidden_principals"`
+	// MaxEntries is the maximum number of home policy entries honored
+	MaxEntries int `yaml:"max_entries"`
+	// RequiredEmailDomains lists the email domains home policy entries must be in
+	RequiredEmailDomains []string `yaml:"required_email_domains"`
+	// IdentityWarnThreshold logs a warning when a home policy admits more identities
+	IdentityWarnThreshold int `yaml:"identity_warn_threshold"`
+	// IdentityDenyThreshold ignores a home policy that admits more identities
+	IdentityDenyThreshold int `yaml:"identity_deny_threshold"`
+	// AnomalyIncrease emits an audit event when a home policy grows by this many identities
+	AnomalyIncrease int `yaml:"anomaly_increase"`
+	// StateDir is where the last seen identity count of each user is recorded
+	StateDir string `yaml:"state_dir"`
 }
 
 func NewServerConfig(c []byte) (*ServerConfig, error) {
@@ -34,6 +377,9 @@ func NewServerConfig(c []byte) (*ServerConfig, error) {
 	if err := yaml.Unmarshal(c, &serverConfig); err != nil {
 		return nil, err
 	}
+	if _, err := serverConfig.ApplyEnvOverrides(os.LookupEnv); err != nil {
+		return nil, err
+	}
 
 	return &serverConfig, nil
 }
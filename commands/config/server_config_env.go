@@ -0,0 +1,135 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServerEnvPrefix prefixes the environment variables that override the
+// settings of the server config, e.g. OPKSSH_SERVER_PREFLIGHT=strict or
+// OPKSSH_SERVER_RATE_LIMIT_MAX_FAILURES=5
+const ServerEnvPrefix = "OPKSSH_SERVER_"
+
+// EnvOverride is a setting of the server config set by an environment
+// variable
+type EnvOverride struct {
+	// Name is the environment variable
+	Name string
+	// Setting is the setting as a dotted path of its YAML keys
+	Setting string
+}
+
+// ApplyEnvOverrides sets the settings of c named by the environment
+// variables found by lookup, such as os.LookupEnv. Strings, booleans,
+// integers and lists of strings, comma separated, can be set. Maps and lists
+// of sections can't. It returns the settings it changed.
+func (c *ServerConfig) ApplyEnvOverrides(lookup func(string) (string, bool)) ([]EnvOverride, error) {
+	overrides := []EnvOverride{}
+	err := walkEnvSettings(reflect.ValueOf(c).Elem(), ServerEnvPrefix, "", func(o EnvOverride, field reflect.Value) error {
+		value, ok := lookup(o.Name)
+		if !ok {
+			return nil
+		}
+		if err := setFromEnv(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", o.Name, err)
+		}
+		overrides = append(overrides, o)
+		return nil
+	})
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Name < overrides[j].Name })
+	return overrides, err
+}
+
+// ServerEnvNames returns the environment variables that can override a
+// setting of the server config
+func ServerEnvNames() []EnvOverride {
+	names := []EnvOverride{}
+	_ = walkEnvSettings(reflect.ValueOf(&ServerConfig{}).Elem(), ServerEnvPrefix, "", func(o EnvOverride, _ reflect.Value) error {
+		names = append(names, o)
+		return nil
+	})
+	sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+	return names
+}
+
+// walkEnvSettings calls fn with every setting of the struct v that can be
+// set from an environment variable. The variable is the YAML keys to the
+// setting, upper case, joined with _ after prefix.
+func walkEnvSettings(v reflect.Value, prefix string, path string, fn func(o EnvOverride, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		field := v.Field(i)
+		o := EnvOverride{Name: prefix + strings.ToUpper(key), Setting: key}
+		if path != "" {
+			o.Setting = path + "." + key
+		}
+		switch field.Kind() {
+		case reflect.Struct:
+			if err := walkEnvSettings(field, o.Name+"_", o.Setting, fn); err != nil {
+				return err
+			}
+		case reflect.String, reflect.Bool, reflect.Int:
+			if err := fn(o, field); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			if field.Type().Elem().Kind() == reflect.String {
+				if err := fn(o, field); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Slice:
+		values := []string{}
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				values = append(values, s)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	}
+	return nil
+}
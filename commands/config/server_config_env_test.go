@@ -0,0 +1,59 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"OPKSSH_SERVER_PREFLIGHT":               "strict",
+		"OPKSSH_SERVER_DENY_USERS":              "root, admin",
+		"OPKSSH_SERVER_RATE_LIMIT_MAX_FAILURES": "5",
+		"OPKSSH_SERVER_REPLAY_CACHE_SINGLE_USE": "true",
+		"OPKSSH_DEFAULT":                        "google",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	c, err := NewServerConfig([]byte("preflight: warn\nrate_limit:\n  window: 10m\n"))
+	require.NoError(t, err)
+	overrides, err := c.ApplyEnvOverrides(lookup)
+	require.NoError(t, err)
+	require.Equal(t, "strict", c.Preflight)
+	require.Equal(t, []string{"root", "admin"}, c.DenyUsers)
+	require.Equal(t, 5, c.RateLimit.MaxFailures)
+	require.Equal(t, "10m", c.RateLimit.Window)
+	require.True(t, c.ReplayCache.SingleUse)
+	require.ElementsMatch(t, []EnvOverride{
+		{Name: "OPKSSH_SERVER_PREFLIGHT", Setting: "preflight"},
+		{Name: "OPKSSH_SERVER_DENY_USERS", Setting: "deny_users"},
+		{Name: "OPKSSH_SERVER_RATE_LIMIT_MAX_FAILURES", Setting: "rate_limit.max_failures"},
+		{Name: "OPKSSH_SERVER_REPLAY_CACHE_SINGLE_USE", Setting: "replay_cache.single_use"},
+	}, overrides)
+
+	env = map[string]string{"OPKSSH_SERVER_RATE_LIMIT_MAX_FAILURES": "five"}
+	_, err = c.ApplyEnvOverrides(lookup)
+	require.ErrorContains(t, err, "invalid OPKSSH_SERVER_RATE_LIMIT_MAX_FAILURES")
+
+	require.Contains(t, ServerEnvNames(), EnvOverride{Name: "OPKSSH_SERVER_LOGGING_LEVEL", Setting: "logging.level"})
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+)
+
+// Codes of a DenyReason, in addition to the codes of policy.DenialError
+const (
+	DenyCodeExpired      = "expired"
+	DenyCodeIssuer       = "issuer_not_allowed"
+	DenyCodeInvalidToken = "invalid_token"
+	DenyCodeProxy        = "proxy"
+	// DenyCodeRequiredClaim is an ID Token without a claim its provider is
+	// configured to require
+	DenyCodeRequiredClaim = "required_claim"
+	// DenyCodeAlgorithm is a token signed with an algorithm that is not
+	// allowed for its provider
+	DenyCodeAlgorithm = "signature_algorithm"
+	// DenyCodeError is a login that could not be checked
+	DenyCodeError = "error"
+)
+
+// DenyReason explains a denied login. Code is stable for logs and audit
+// records and Message is meant to be shown to the user, so it doesn't name
+// identities or paths.
+type DenyReason struct {
+	Code    string
+	Message string
+}
+
+// deniedError keeps the code of the step of verify that denied the login
+// with its error
+type deniedError struct {
+	code string
+	err  error
+}
+
+func (e *deniedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deniedError) Unwrap() error {
+	return e.err
+}
+
+// deny returns err with the code of the step that failed
+func deny(code string, err error) error {
+	return &deniedError{code: code, err: err}
+}
+
+// pktDenyCode returns the code of an error verifying the PK Token
+func pktDenyCode(err error) string {
+	var claimErr *policy.RequiredClaimError
+	var algErr *policy.AlgorithmError
+	msg := err.Error()
+	switch {
+	case errors.As(err, &claimErr):
+		return DenyCodeRequiredClaim
+	case errors.As(err, &algErr):
+		return DenyCodeAlgorithm
+	case strings.Contains(msg, "expired"):
+		return DenyCodeExpired
+	case strings.Contains(msg, "unrecognized issuer"):
+		return DenyCodeIssuer
+	default:
+		return DenyCodeInvalidToken
+	}
+}
+
+// NewDenyReason returns why err denied the login as principal
+func NewDenyReason(principal string, err error) DenyReason {
+	code := DenyCodeError
+	var pluginReasons []string
+	var denialErr *policy.DenialError
+	var stepErr *deniedError
+	if errors.As(err, &denialErr) {
+		code = denialErr.Code
+		pluginReasons = denialErr.PluginReasons
+	} else if errors.As(err, &stepErr) {
+		code = stepErr.code
+	}
+
+	reason := DenyReason{Code: code}
+	switch code {
+	case DenyCodeExpired:
+		reason.Message = "your ID Token has expired, run opkssh login to get a new one"
+	case DenyCodeIssuer:
+		reason.Message = "the OpenID Provider of your ID Token is not trusted by this server"
+	case DenyCodeInvalidToken:
+		reason.Message = "your SSH key is not a valid opkssh certificate"
+	case DenyCodeRequiredClaim:
+		reason.Message = "your account does not have the claims this server requires from its OpenID Provider"
+	case DenyCodeAlgorithm:
+		reason.Message = "your ID Token is signed with an algorithm this server does not accept from its OpenID Provider"
+	case DenyCodeProxy:
+		reason.Message = fmt.Sprintf("logins as %s must come through a trusted proxy", principal)
+	case policy.DenyCodeDenyList:
+		reason.Message = "your identity is not allowed on this server"
+	case policy.DenyCodeRevoked:
+		reason.Message = "your identity has been revoked"
+	case policy.DenyCodeReplay:
+		reason.Message = "your ID Token was already used or can't be tracked by this server, run opkssh login to get a new one"
+	case policy.DenyCodeRateLimited:
+		reason.Message = fmt.Sprintf("too many failed logins as %s, try again later", principal)
+	case policy.DenyCodeNoPolicy:
+		reason.Message = fmt.Sprintf("no policy allows you to log in as %s", principal)
+		if len(pluginReasons) > 0 {
+			reason.Message += ": " + strings.Join(pluginReasons, "; ")
+		}
+	default:
+		reason.Message = "opkssh failed to check your login, ask the administrator to check the logs"
+	}
+	return reason
+}
+
+// String returns the reason as it is logged
+func (r DenyReason) String() string {
+	return r.Code + ": " + r.Message
+}
+
+// reasonFilePath returns the path of the deny reason file of principal from
+// the template, in which %u is replaced by the principal. It is empty if
+// the principal could escape its directory.
+func reasonFilePath(template string, principal string) string {
+	if principal == "" || principal == "." || principal == ".." || strings.ContainsAny(principal, `/\`) {
+		return ""
+	}
+	return filepath.Clean(strings.ReplaceAll(template, "%u", principal))
+}
+
+// writeDenyReason writes the message of reason to the deny reason file of
+// principal. It doesn't change the decision so errors are only logged.
+func writeDenyReason(fs afero.Fs, template string, principal string, reason DenyReason) {
+	path := reasonFilePath(template, principal)
+	if path == "" {
+		slog.Warn("Not writing the deny reason of the principal", "principal", principal)
+		return
+	}
+	if err := afero.WriteFile(fs, path, []byte(reason.Message+"\n"), 0o640); err != nil {
+		slog.Warn("Failed to write the deny reason", "path", path, "error", err)
+	}
+}
+
+// clearDenyReason removes the deny reason file of principal once it logs in
+func clearDenyReason(fs afero.Fs, template string, principal string) {
+	if path := reasonFilePath(template, principal); path != "" {
+		if err := fs.Remove(path); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove the deny reason", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDenyReason(t *testing.T) {
+	tests := []struct {
+		err     error
+		code    string
+		message string
+	}{
+		{deny(pktDenyCode(errors.New("the ID token has expired (exp = 1)")), errors.New("x")), DenyCodeExpired, "your ID Token has expired, run opkssh login to get a new one"},
+		{deny(pktDenyCode(errors.New("unrecognized issuer: https://evil.example.com")), errors.New("x")), DenyCodeIssuer, "the OpenID Provider of your ID Token is not trusted by this server"},
+		{deny(pktDenyCode(errors.New("error verifying signature")), errors.New("x")), DenyCodeInvalidToken, "your SSH key is not a valid opkssh certificate"},
+		{deny(pktDenyCode(&policy.RequiredClaimError{Claim: "hd", Value: "example.com"}), errors.New("x")), DenyCodeRequiredClaim, "your account does not have the claims this server requires from its OpenID Provider"},
+		{deny(pktDenyCode(&policy.AlgorithmError{Token: "ID Token", Alg: "HS256"}), errors.New("x")), DenyCodeAlgorithm, "your ID Token is signed with an algorithm this server does not accept from its OpenID Provider"},
+		{deny(DenyCodeProxy, errors.New("x")), DenyCodeProxy, "logins as dev must come through a trusted proxy"},
+		{fmt.Errorf("wrapped: %w", &policy.DenialError{Code: policy.DenyCodeRevoked}), policy.DenyCodeRevoked, "your identity has been revoked"},
+		{&policy.DenialError{Code: policy.DenyCodeDenyList}, policy.DenyCodeDenyList, "your identity is not allowed on this server"},
+		{&policy.DenialError{Code: policy.DenyCodeNoPolicy}, policy.DenyCodeNoPolicy, "no policy allows you to log in as dev"},
+		{&policy.DenialError{Code: policy.DenyCodeNoPolicy, PluginReasons: []string{"a", "b"}}, policy.DenyCodeNoPolicy, "no policy allows you to log in as dev: a; b"},
+		{errors.New("failed to read revocation list"), DenyCodeError, "opkssh failed to check your login, ask the administrator to check the logs"},
+	}
+	for _, tt := range tests {
+		reason := NewDenyReason("dev", tt.err)
+		require.Equal(t, DenyReason{Code: tt.code, Message: tt.message}, reason, tt.err.Error())
+	}
+	// The error of a step is unchanged
+	require.Equal(t, "x", deny(DenyCodeProxy, errors.New("x")).Error())
+}
+
+func TestReasonFilePath(t *testing.T) {
+	require.Equal(t, filepath.FromSlash("/run/opk/deny/dev"), reasonFilePath("/run/opk/deny/%u", "dev"))
+	require.Equal(t, filepath.FromSlash("/run/opk/deny-dev.txt"), reasonFilePath("/run/opk/deny-%u.txt", "dev"))
+	for _, principal := range []string{"", ".", "..", "../etc/passwd", `a\b`} {
+		require.Empty(t, reasonFilePath("/run/opk/deny/%u", principal), principal)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/providers"
+)
+
+// defaultDevicePollInterval is how often the token endpoint is polled when
+// the provider doesn't say, as in RFC 8628 section 3.2
+const defaultDevicePollInterval = 5 * time.Second
+
+// DeviceFlowOp gets ID Tokens with the OAuth 2.0 device authorization grant
+// (RFC 8628) instead of a redirect to a localhost port, so that users on a
+// machine without a browser can log in by entering a code on another
+// device. The ID Token must still commit to the client instance claims, so
+// the provider must copy the nonce of the device authorization request into
+// the ID Token. ID Tokens are verified and refreshed by the embedded
+// provider.
+type DeviceFlowOp struct {
+	providers.OpenIdProvider
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// HttpClient is used to call the provider, http.DefaultClient if nil
+	HttpClient *http.Client
+	// Out is where the verification URI and the code to enter are written
+	Out io.Writer
+
+	// wait waits d between polls of the token endpoint
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// refreshableDeviceFlowOp is a DeviceFlowOp for a provider that supports
+// refresh tokens
+type refreshableDeviceFlowOp struct {
+	*DeviceFlowOp
+	refresher providers.RefreshableOpenIdProvider
+}
+
+func (r refreshableDeviceFlowOp) RefreshTokens(ctx context.Context, refreshToken []byte) (*oidc.Tokens, error) {
+	return r.refresher.RefreshTokens(ctx, refreshToken)
+}
+
+func (r refreshableDeviceFlowOp) VerifyRefreshedIDToken(ctx context.Context, origIdt []byte, reIdt []byte) error {
+	return r.refresher.VerifyRefreshedIDToken(ctx, origIdt, reIdt)
+}
+
+// NewDeviceFlowOp returns a provider that logs in to op with the device
+// authorization grant. It supports refresh if op does.
+func NewDeviceFlowOp(op providers.OpenIdProvider, clientID string, clientSecret string, scopes []string, out io.Writer) providers.OpenIdProvider {
+	d := &DeviceFlowOp{
+		OpenIdProvider: op,
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		Scopes:         scopes,
+		Out:            out,
+	}
+	if refresher, ok := op.(providers.RefreshableOpenIdProvider); ok {
+		return refreshableDeviceFlowOp{DeviceFlowOp: d, refresher: refresher}
+	}
+	return d
+}
+
+// deviceAuthorization is the response of the device authorization endpoint
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURL         string `json:"verification_url"` // Google's name for verification_uri
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// deviceTokenResponse is the response of the token endpoint, either the
+// tokens or an error
+type deviceTokenResponse struct {
+	IDToken          string `json:"id_token"`
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (d *DeviceFlowOp) RequestTokens(ctx context.Context, cic *clientinstance.Claims) (*oidc.Tokens, error) {
+	cicHash, err := cic.Hash()
+	if err != nil {
+		return nil, fmt.Errorf("error calculating client instance claim commitment: %w", err)
+	}
+	deviceEndpoint, tokenEndpoint, err := d.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := d.clientForm()
+	form.Set("scope", strings.Join(d.Scopes, " "))
+	form.Set("nonce", string(cicHash))
+	auth := &deviceAuthorization{}
+	if status, err := d.post(ctx, deviceEndpoint, form, auth); err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("device authorization request failed with status %d", status)
+	}
+	if auth.VerificationURI == "" {
+		auth.VerificationURI = auth.VerificationURL
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" || auth.VerificationURI == "" {
+		return nil, fmt.Errorf("device authorization response is missing the device code, user code or verification URI")
+	}
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(d.Out, "To log in, open %s on any device, or open %s and enter the code %s\n", auth.VerificationURIComplete, auth.VerificationURI, auth.UserCode)
+	} else {
+		fmt.Fprintf(d.Out, "To log in, open %s on any device and enter the code %s\n", auth.VerificationURI, auth.UserCode)
+	}
+
+	tokens, err := d.poll(ctx, tokenEndpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNonce(tokens.IDToken, string(cicHash)); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// poll polls the token endpoint until the user enters the code, denies the
+// request or the code expires
+func (d *DeviceFlowOp) poll(ctx context.Context, tokenEndpoint string, auth *deviceAuthorization) (*oidc.Tokens, error) {
+	interval := defaultDevicePollInterval
+	if auth.Interval > 0 {
+		interval = time.Duration(auth.Interval) * time.Second
+	}
+	var deadline time.Time
+	if auth.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	}
+	wait := d.wait
+	if wait == nil {
+		wait = sleepContext
+	}
+
+	form := d.clientForm()
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	form.Set("device_code", auth.DeviceCode)
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, fmt.Errorf("the device code expired before it was entered, run login again")
+		}
+		if err := wait(ctx, interval); err != nil {
+			return nil, err
+		}
+		resp := &deviceTokenResponse{}
+		status, err := d.post(ctx, tokenEndpoint, form, resp)
+		if err != nil {
+			return nil, fmt.Errorf("device access token request failed: %w", err)
+		}
+		switch {
+		case status == http.StatusOK && resp.Error == "":
+			if resp.IDToken == "" {
+				return nil, fmt.Errorf("provider did not return an ID Token, check that the openid scope is requested")
+			}
+			return &oidc.Tokens{
+				IDToken:      []byte(resp.IDToken),
+				RefreshToken: []byte(resp.RefreshToken),
+				AccessToken:  []byte(resp.AccessToken),
+			}, nil
+		case resp.Error == "authorization_pending":
+		case resp.Error == "slow_down":
+			interval += defaultDevicePollInterval
+		case resp.Error == "access_denied":
+			return nil, fmt.Errorf("the login was denied on the provider")
+		case resp.Error == "expired_token":
+			return nil, fmt.Errorf("the device code expired before it was entered, run login again")
+		case resp.Error != "":
+			return nil, fmt.Errorf("device access token request failed: %s %s", resp.Error, resp.ErrorDescription)
+		default:
+			return nil, fmt.Errorf("device access token request failed with status %d", status)
+		}
+	}
+}
+
+// endpoints returns the device authorization and token endpoints from the
+// OpenID configuration of the issuer
+func (d *DeviceFlowOp) endpoints(ctx context.Context) (string, string, error) {
+	discoveryURL := strings.TrimSuffix(d.Issuer(), "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch the OpenID configuration of %s: %w", d.Issuer(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to fetch the OpenID configuration of %s: status %d", d.Issuer(), resp.StatusCode)
+	}
+	var discovery struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return "", "", fmt.Errorf("failed to parse the OpenID configuration of %s: %w", d.Issuer(), err)
+	}
+	if discovery.DeviceAuthorizationEndpoint == "" {
+		return "", "", fmt.Errorf("%s does not support the device authorization grant, log in without --device-flow", d.Issuer())
+	}
+	if discovery.TokenEndpoint == "" {
+		return "", "", fmt.Errorf("the OpenID configuration of %s has no token endpoint", d.Issuer())
+	}
+	return discovery.DeviceAuthorizationEndpoint, discovery.TokenEndpoint, nil
+}
+
+func (d *DeviceFlowOp) clientForm() url.Values {
+	form := url.Values{}
+	form.Set("client_id", d.ClientID)
+	if d.ClientSecret != "" {
+		form.Set("client_secret", d.ClientSecret)
+	}
+	return form
+}
+
+// post posts form to endpoint and decodes the JSON response into v, for
+// error responses too
+func (d *DeviceFlowOp) post(ctx context.Context, endpoint string, form url.Values, v any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := d.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *DeviceFlowOp) httpClient() *http.Client {
+	if d.HttpClient != nil {
+		return d.HttpClient
+	}
+	return http.DefaultClient
+}
+
+// checkNonce checks that the ID Token idt has nonce, the commitment to the
+// client instance claims. Providers that drop the nonce of device
+// authorization requests can't be used with the device flow.
+func checkNonce(idt []byte, nonce string) error {
+	_, payload, _, err := oidc.SplitCompact(idt)
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to decode ID Token payload: %w", err)
+	}
+	var claims struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return fmt.Errorf("failed to parse ID Token payload: %w", err)
+	}
+	if claims.Nonce != nonce {
+		return fmt.Errorf("the ID Token does not have the nonce of the device authorization request, this provider can't be used with --device-flow")
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
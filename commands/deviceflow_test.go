@@ -0,0 +1,149 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDeviceFlowServer returns a provider that implements the device
+// authorization grant. tokenErrors are returned by the token endpoint
+// before it issues the tokens. If dropNonce is set the ID Token doesn't have
+// the nonce of the device authorization request.
+func newTestDeviceFlowServer(t *testing.T, withDeviceEndpoint bool, tokenErrors []string, dropNonce bool) (*DeviceFlowOp, *bytes.Buffer) {
+	t.Helper()
+	var op *providers.MockProvider
+	var nonce string
+	var polls int
+	var issue func(nonce string) string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			discovery := map[string]string{"issuer": op.Issuer(), "token_endpoint": op.Issuer() + "/token"}
+			if withDeviceEndpoint {
+				discovery["device_authorization_endpoint"] = op.Issuer() + "/device"
+			}
+			_ = json.NewEncoder(w).Encode(discovery)
+		case "/device":
+			require.Equal(t, "test_client_id", r.Form.Get("client_id"))
+			require.Equal(t, "openid email", r.Form.Get("scope"))
+			nonce = r.Form.Get("nonce")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"device_code":      "device-code",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": op.Issuer() + "/activate",
+				"expires_in":       600,
+			})
+		case "/token":
+			require.Equal(t, "device-code", r.Form.Get("device_code"))
+			require.Equal(t, "urn:ietf:params:oauth:grant-type:device_code", r.Form.Get("grant_type"))
+			if polls < len(tokenErrors) {
+				polls++
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"error": tokenErrors[polls-1]})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"id_token": issue(nonce), "access_token": "access", "refresh_token": "refresh"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	providerOpts := providers.DefaultMockProviderOpts()
+	providerOpts.Issuer = server.URL
+	op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{"email": "alice@example.com"}
+	issue = func(nonce string) string {
+		if dropNonce {
+			nonce = "another-nonce"
+		}
+		idtTemplate.AddCommit(nonce)
+		tokens, err := idtTemplate.IssueTokens()
+		require.NoError(t, err)
+		return string(tokens.IDToken)
+	}
+
+	out := &bytes.Buffer{}
+	d := NewDeviceFlowOp(op, "test_client_id", "", []string{"openid", "email"}, out).(refreshableDeviceFlowOp).DeviceFlowOp
+	d.HttpClient = server.Client()
+	d.wait = func(ctx context.Context, _ time.Duration) error { return ctx.Err() }
+	return d, out
+}
+
+func authWithDeviceFlow(t *testing.T, d *DeviceFlowOp) error {
+	signer, err := util.GenKeyPair(jwa.ES256)
+	require.NoError(t, err)
+	opkClient, err := client.New(d, client.WithSigner(signer, jwa.ES256))
+	require.NoError(t, err)
+	_, err = opkClient.Auth(context.Background())
+	return err
+}
+
+func TestDeviceFlow(t *testing.T) {
+	d, out := newTestDeviceFlowServer(t, true, []string{"authorization_pending", "slow_down", "authorization_pending"}, false)
+	require.NoError(t, authWithDeviceFlow(t, d))
+	require.Equal(t, "To log in, open "+d.Issuer()+"/activate on any device and enter the code ABCD-EFGH\n", out.String())
+}
+
+func TestDeviceFlowErrors(t *testing.T) {
+	d, _ := newTestDeviceFlowServer(t, false, nil, false)
+	require.ErrorContains(t, authWithDeviceFlow(t, d), "does not support the device authorization grant")
+
+	d, _ = newTestDeviceFlowServer(t, true, []string{"authorization_pending", "access_denied"}, false)
+	require.ErrorContains(t, authWithDeviceFlow(t, d), "the login was denied on the provider")
+
+	d, _ = newTestDeviceFlowServer(t, true, []string{"expired_token"}, false)
+	require.ErrorContains(t, authWithDeviceFlow(t, d), "the device code expired")
+
+	// A provider that doesn't copy the nonce into the ID Token
+	d, _ = newTestDeviceFlowServer(t, true, nil, true)
+	require.ErrorContains(t, authWithDeviceFlow(t, d), "does not have the nonce of the device authorization request")
+}
+
+func TestLoginDeviceFlowProvider(t *testing.T) {
+	defaultConfig, err := config.NewClientConfig(config.DefaultClientConfig)
+	require.NoError(t, err)
+	loginCmd := LoginCmd{DisableBrowserOpenArg: true, DeviceFlowArg: true, ProviderArg: "gitlab", Config: defaultConfig}
+	provider, chooser, err := loginCmd.determineProvider()
+	require.NoError(t, err)
+	require.Nil(t, chooser)
+	d, ok := provider.(refreshableDeviceFlowOp)
+	require.True(t, ok)
+	require.Equal(t, "https://gitlab.com", d.Issuer())
+	require.Equal(t, []string{"openid", "email"}, d.Scopes)
+
+	// The web chooser needs a browser
+	loginCmd.ProviderArg = ""
+	_, _, err = loginCmd.determineProvider()
+	require.ErrorContains(t, err, "--device-flow can't be used with the web chooser")
+}
@@ -0,0 +1,576 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+)
+
+// DoctorStatus is the outcome of a DoctorCheck
+type DoctorStatus string
+
+const (
+	DoctorPass DoctorStatus = "pass"
+	DoctorWarn DoctorStatus = "warn"
+	DoctorFail DoctorStatus = "fail"
+)
+
+// Checks run by doctor, reported in DoctorCheck.Name
+const (
+	DoctorCheckPermissions = "permissions"
+	DoctorCheckPolicy      = "policy"
+	DoctorCheckSshdConfig  = "sshd-config"
+	DoctorCheckUser        = "user"
+	DoctorCheckDiscovery   = "discovery"
+)
+
+// maxSshdIncludeDepth bounds nested Include directives, as sshd does
+const maxSshdIncludeDepth = 16
+
+// DoctorCheck is one line of the doctor report
+type DoctorCheck struct {
+	Name    string       `json:"name"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+	// Hint says how to fix a failed or warning check
+	Hint string `json:"hint,omitempty"`
+}
+
+// DoctorCmd checks everything opkssh needs on a server and reports what is
+// wrong with a hint to fix it
+type DoctorCmd struct {
+	Fs          afero.Fs
+	Out         io.Writer
+	UserLookup  policy.UserLookup
+	Permissions *PermissionsCmd
+	Lint        *LintCmd
+	HttpClient  *http.Client
+
+	// Executable is the installed opkssh binary sshd should run, empty to
+	// accept any opkssh binary
+	Executable string
+
+	// Args
+	SshdConfigPath string
+	JsonOutput     bool
+}
+
+// NewDoctorCmd creates a DoctorCmd checking the system configuration
+func NewDoctorCmd(rt *Runtime) *DoctorCmd {
+	lint := NewLintCmd(rt)
+	// The permissions check already covers these files
+	lint.FileSystem = nil
+	lint.SkipUserPolicy = true
+	// sshd should run the binary doctor is run from
+	exe, _ := os.Executable()
+	return &DoctorCmd{
+		Executable:     exe,
+		Fs:             rt.Fs,
+		Out:            rt.Out,
+		UserLookup:     rt.UserLookup,
+		Permissions:    NewPermissionsCmd(rt),
+		Lint:           lint,
+		HttpClient:     &http.Client{Timeout: 10 * time.Second},
+		SshdConfigPath: defaultSshdConfigPath(),
+	}
+}
+
+func defaultSshdConfigPath() string {
+	if runtime.GOOS == "windows" {
+		programData := os.Getenv("ProgramData")
+		if programData == "" {
+			programData = `C:\ProgramData`
+		}
+		return filepath.Join(programData, "ssh", "sshd_config")
+	}
+	return "/etc/ssh/sshd_config"
+}
+
+// Checks runs every check and returns the results in the order they ran
+func (d *DoctorCmd) Checks() []DoctorCheck {
+	var checks []DoctorCheck
+	checks = append(checks, d.checkPermissions()...)
+	checks = append(checks, d.checkPolicy()...)
+	sshdChecks, commandUser := d.checkSshdConfig()
+	checks = append(checks, sshdChecks...)
+	checks = append(checks, d.checkUser(commandUser))
+	checks = append(checks, d.checkDiscovery()...)
+	return checks
+}
+
+// Run prints the report and returns an error if any check failed
+func (d *DoctorCmd) Run() error {
+	checks := d.Checks()
+
+	failed, warnings := 0, 0
+	for _, c := range checks {
+		switch c.Status {
+		case DoctorFail:
+			failed++
+		case DoctorWarn:
+			warnings++
+		}
+	}
+
+	if d.JsonOutput {
+		enc := json.NewEncoder(d.Out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			return err
+		}
+	} else {
+		for _, c := range checks {
+			fmt.Fprintf(d.Out, "%-4s  %-11s  %s\n", strings.ToUpper(string(c.Status)), c.Name, c.Message)
+			if c.Hint != "" {
+				fmt.Fprintf(d.Out, "%17s  hint: %s\n", "", c.Hint)
+			}
+		}
+		fmt.Fprintf(d.Out, "\npassed: %d, warnings: %d, failed: %d\n", len(checks)-failed-warnings, warnings, failed)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("doctor found %d problems", failed)
+	}
+	return nil
+}
+
+func (d *DoctorCmd) checkPermissions() []DoctorCheck {
+	// Only the results are wanted, not the ACLs check prints
+	perms := *d.Permissions
+	perms.Out = io.Discard
+	perms.JsonOutput = true
+	_, problems := perms.checkPaths()
+	if len(problems) == 0 {
+		return []DoctorCheck{{Name: DoctorCheckPermissions, Status: DoctorPass, Message: "opkssh files have the expected permissions"}}
+	}
+	var checks []DoctorCheck
+	for _, prob := range problems {
+		checks = append(checks, DoctorCheck{
+			Name:    DoctorCheckPermissions,
+			Status:  DoctorFail,
+			Message: prob,
+			Hint:    "run sudo opkssh permissions fix",
+		})
+	}
+	return checks
+}
+
+func (d *DoctorCmd) checkPolicy() []DoctorCheck {
+	findings, err := d.Lint.Lint()
+	if err != nil {
+		return []DoctorCheck{{Name: DoctorCheckPolicy, Status: DoctorFail, Message: err.Error(), Hint: "run opkssh policy lint for details"}}
+	}
+	var checks []DoctorCheck
+	for _, f := range findings {
+		status := DoctorFail
+		switch f.Severity {
+		case LintInfo:
+			continue
+		case LintWarning:
+			status = DoctorWarn
+		}
+		location, hint := f.Path, "run opkssh policy lint for details"
+		if f.Line > 0 {
+			location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+			hint = "fix the line, run opkssh policy lint for details"
+		}
+		checks = append(checks, DoctorCheck{
+			Name:    DoctorCheckPolicy,
+			Status:  status,
+			Message: fmt.Sprintf("%s: %s [%s]", location, f.Message, f.Rule),
+			Hint:    hint,
+		})
+	}
+	if len(checks) == 0 {
+		return []DoctorCheck{{Name: DoctorCheckPolicy, Status: DoctorPass, Message: "the policy and providers files are valid"}}
+	}
+	return checks
+}
+
+// checkSshdConfig checks that sshd calls the installed opkssh verify for
+// every connection. It returns the AuthorizedKeysCommandUser it found, or
+// the default if there is none.
+func (d *DoctorCmd) checkSshdConfig() ([]DoctorCheck, string) {
+	commandUser := policy.Defaults.User
+	hint := fmt.Sprintf("add \"AuthorizedKeysCommand %s verify %%u %%k %%t\" and \"AuthorizedKeysCommandUser %s\" to %s and restart sshd",
+		opksshBinaryHint(), policy.Defaults.User, d.SshdConfigPath)
+
+	directives, err := readSshdConfig(d.Fs, d.SshdConfigPath, 0)
+	if err != nil {
+		return []DoctorCheck{{
+			Name:    DoctorCheckSshdConfig,
+			Status:  DoctorFail,
+			Message: fmt.Sprintf("failed to read sshd config: %v", err),
+			Hint:    "install the OpenSSH server or pass --sshd-config",
+		}}, commandUser
+	}
+
+	var checks []DoctorCheck
+	command, ok := firstSshdDirective(directives, sshdAuthKeysCommand)
+	if !ok || strings.EqualFold(command.Value, "none") {
+		checks = append(checks, DoctorCheck{
+			Name:    DoctorCheckSshdConfig,
+			Status:  DoctorFail,
+			Message: fmt.Sprintf("AuthorizedKeysCommand is not set in %s", d.SshdConfigPath),
+			Hint:    hint,
+		})
+	} else {
+		checks = append(checks, d.checkVerifyCommand(command.Value, hint))
+	}
+
+	for _, dir := range directives {
+		switch {
+		case dir.Keyword != sshdAuthKeysCommand && dir.Keyword != sshdAuthKeysUser:
+		case dir.Match != "":
+			// Connections matching the block may not use opkssh
+			checks = append(checks, DoctorCheck{
+				Name:    DoctorCheckSshdConfig,
+				Status:  DoctorWarn,
+				Message: fmt.Sprintf("%s at %s overrides it for connections matching \"Match %s\"", sshdKeywordName(dir.Keyword), dir.location(), dir.Match),
+				Hint:    "remove it unless these connections should not use opkssh",
+			})
+		case dir.Keyword == sshdAuthKeysCommand && dir != command && callsOpksshVerify(dir.Value) && !callsOpksshVerify(command.Value):
+			// sshd ignores every AuthorizedKeysCommand but the first
+			checks = append(checks, DoctorCheck{
+				Name:    DoctorCheckSshdConfig,
+				Status:  DoctorFail,
+				Message: fmt.Sprintf("AuthorizedKeysCommand at %s is shadowed by AuthorizedKeysCommand %q at %s, sshd uses the first one it reads", dir.location(), command.Value, command.location()),
+				Hint:    fmt.Sprintf("comment out the AuthorizedKeysCommand at %s, or run sudo opkssh install", command.location()),
+			})
+		}
+	}
+
+	if user, ok := firstSshdDirective(directives, sshdAuthKeysUser); !ok {
+		checks = append(checks, DoctorCheck{
+			Name:    DoctorCheckSshdConfig,
+			Status:  DoctorFail,
+			Message: fmt.Sprintf("AuthorizedKeysCommandUser is not set in %s", d.SshdConfigPath),
+			Hint:    hint,
+		})
+	} else {
+		commandUser = user.Value
+		if commandUser != policy.Defaults.User {
+			// The opkssh files are only readable by root and opksshuser
+			checks = append(checks, DoctorCheck{
+				Name:    DoctorCheckSshdConfig,
+				Status:  DoctorFail,
+				Message: fmt.Sprintf("AuthorizedKeysCommandUser at %s is %s, verify runs as %s", user.location(), commandUser, policy.Defaults.User),
+				Hint:    fmt.Sprintf("set AuthorizedKeysCommandUser %s at %s", policy.Defaults.User, user.location()),
+			})
+		}
+	}
+	return checks, commandUser
+}
+
+// checkVerifyCommand checks that command runs the installed opkssh verify
+// with the tokens it needs
+func (d *DoctorCmd) checkVerifyCommand(command string, hint string) DoctorCheck {
+	args := splitSshdArgs(command)
+	check := DoctorCheck{Name: DoctorCheckSshdConfig, Status: DoctorFail, Hint: hint}
+	if !callsOpksshVerify(command) {
+		check.Message = fmt.Sprintf("AuthorizedKeysCommand %q does not call opkssh verify", command)
+		return check
+	}
+	if !d.exists(args[0]) {
+		check.Message = fmt.Sprintf("AuthorizedKeysCommand %s does not exist", args[0])
+		check.Hint = "install opkssh at that path or change AuthorizedKeysCommand to where it is installed"
+		return check
+	}
+	check.Status = DoctorWarn
+	positional, unknown := verifyArgs(args[2:])
+	switch {
+	case d.Executable != "" && filepath.Clean(args[0]) != filepath.Clean(d.Executable):
+		check.Message = fmt.Sprintf("AuthorizedKeysCommand runs %s, not the installed opkssh %s", args[0], d.Executable)
+		check.Hint = fmt.Sprintf("run sudo %s install to point sshd at it", d.Executable)
+	case len(positional) < 3 || positional[0] != "%u" || positional[1] != "%k" || positional[2] != "%t":
+		check.Message = fmt.Sprintf("AuthorizedKeysCommand %q does not pass %%u %%k %%t", command)
+	case len(unknown) > 0:
+		check.Message = fmt.Sprintf("AuthorizedKeysCommand passes unknown flags to opkssh verify: %s", strings.Join(unknown, " "))
+	default:
+		return DoctorCheck{Name: DoctorCheckSshdConfig, Status: DoctorPass, Message: "AuthorizedKeysCommand calls " + args[0] + " verify"}
+	}
+	return check
+}
+
+// callsOpksshVerify returns true if the AuthorizedKeysCommand command runs
+// opkssh verify
+func callsOpksshVerify(command string) bool {
+	args := splitSshdArgs(command)
+	return len(args) >= 2 && strings.HasPrefix(filepath.Base(args[0]), "opkssh") && args[1] == "verify"
+}
+
+// verifyArgs returns the positional arguments of opkssh verify and the
+// flags it doesn't know
+func verifyArgs(args []string) ([]string, []string) {
+	var positional, unknown []string
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(args[i], "=")
+		switch name {
+		case "--socket", "--config-path", "--connection":
+			if !hasValue {
+				i++
+			}
+		case "--via-socket":
+		default:
+			if strings.HasPrefix(name, "-") {
+				unknown = append(unknown, args[i])
+			} else {
+				positional = append(positional, args[i])
+			}
+		}
+	}
+	return positional, unknown
+}
+
+// sshdKeywordName returns the name of a lowercase keyword as written in the
+// sshd documentation
+func sshdKeywordName(keyword string) string {
+	if keyword == sshdAuthKeysUser {
+		return "AuthorizedKeysCommandUser"
+	}
+	return "AuthorizedKeysCommand"
+}
+
+func (d *DoctorCmd) exists(path string) bool {
+	_, err := d.Fs.Stat(path)
+	return err == nil
+}
+
+func opksshBinaryHint() string {
+	if runtime.GOOS == "windows" {
+		return `"C:\Program Files\opk\opkssh.exe"`
+	}
+	return "/usr/local/bin/opkssh"
+}
+
+// sshdDirective is a setting of the sshd config
+type sshdDirective struct {
+	// Keyword is lowercase
+	Keyword string
+	Value   string
+	Path    string
+	Line    int
+	// Match is the criteria of the Match block the setting is in, empty if
+	// it applies to every connection
+	Match string
+}
+
+func (s sshdDirective) location() string {
+	return fmt.Sprintf("%s:%d", s.Path, s.Line)
+}
+
+// firstSshdDirective returns the setting of keyword for every connection.
+// Like sshd the first value of a keyword wins.
+func firstSshdDirective(directives []sshdDirective, keyword string) (sshdDirective, bool) {
+	for _, dir := range directives {
+		if dir.Keyword == keyword && dir.Match == "" {
+			return dir, true
+		}
+	}
+	return sshdDirective{}, false
+}
+
+// readSshdConfig returns the settings of the sshd config at path and of the
+// files it includes, in the order sshd reads them. A Match block lasts until
+// the next Match or the end of the file.
+func readSshdConfig(fs afero.Fs, path string, depth int) ([]sshdDirective, error) {
+	if depth > maxSshdIncludeDepth {
+		return nil, fmt.Errorf("too many nested Include directives at %s", path)
+	}
+	content, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, err
+	}
+	var directives []sshdDirective
+	match := ""
+	line := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line++
+		keyword, value := parseSshdLine(scanner.Text())
+		switch keyword {
+		case "":
+		case "match":
+			match = value
+			if strings.EqualFold(value, "all") {
+				match = ""
+			}
+		case "include":
+			for _, pattern := range splitSshdArgs(value) {
+				matches, err := afero.Glob(fs, sshdIncludePattern(pattern))
+				if err != nil {
+					return nil, err
+				}
+				for _, m := range matches {
+					included, err := readSshdConfig(fs, m, depth+1)
+					if err != nil {
+						return nil, err
+					}
+					for _, dir := range included {
+						if dir.Match == "" {
+							dir.Match = match
+						}
+						directives = append(directives, dir)
+					}
+				}
+			}
+		default:
+			directives = append(directives, sshdDirective{Keyword: keyword, Value: value, Path: path, Line: line, Match: match})
+		}
+	}
+	return directives, scanner.Err()
+}
+
+// parseSshdLine returns the lowercase keyword and the value of a line of the
+// sshd config, or an empty keyword for blank lines and comments
+func parseSshdLine(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", ""
+	}
+	// Keyword and value are separated by whitespace or an =
+	keyword, value := line, ""
+	if i := strings.IndexAny(line, " \t="); i >= 0 {
+		keyword, value = line[:i], line[i+1:]
+	}
+	return strings.ToLower(keyword), strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "="))
+}
+
+// sshdIncludePattern returns the pattern of an Include directive, relative
+// paths are relative to the sshd config directory
+func sshdIncludePattern(pattern string) string {
+	if !filepath.IsAbs(pattern) {
+		return filepath.Join(filepath.Dir(defaultSshdConfigPath()), pattern)
+	}
+	return pattern
+}
+
+// splitSshdArgs splits a value of the sshd config into arguments, keeping
+// double quoted arguments such as Windows paths with spaces together
+func splitSshdArgs(value string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
+
+func (d *DoctorCmd) checkUser(name string) DoctorCheck {
+	if _, err := d.UserLookup.Lookup(name); err != nil {
+		hint := fmt.Sprintf("create it with sudo useradd -r -M -s /sbin/nologin %s", name)
+		if runtime.GOOS == "windows" {
+			hint = fmt.Sprintf("create it with New-LocalUser -Name %s -NoPassword", name)
+		}
+		return DoctorCheck{
+			Name:    DoctorCheckUser,
+			Status:  DoctorFail,
+			Message: fmt.Sprintf("the AuthorizedKeysCommandUser %s does not exist: %v", name, err),
+			Hint:    hint,
+		}
+	}
+	return DoctorCheck{Name: DoctorCheckUser, Status: DoctorPass, Message: fmt.Sprintf("the AuthorizedKeysCommandUser %s exists", name)}
+}
+
+// checkDiscovery fetches the OpenID configuration of each provider, which
+// verify needs to find the keys the ID tokens are signed with
+func (d *DoctorCmd) checkDiscovery() []DoctorCheck {
+	providers := d.Lint.lintProviders(func(LintSeverity, string, string, int, string, ...any) {})
+	var checks []DoctorCheck
+	seen := map[string]bool{}
+	for _, row := range providers.GetRows() {
+		if seen[row.Issuer] {
+			continue
+		}
+		seen[row.Issuer] = true
+		if err := d.discover(row.Issuer); err != nil {
+			checks = append(checks, DoctorCheck{
+				Name:    DoctorCheckDiscovery,
+				Status:  DoctorFail,
+				Message: fmt.Sprintf("%s: %v", row.Issuer, err),
+				Hint:    "check the issuer in the providers file and that this host can reach it over HTTPS",
+			})
+			continue
+		}
+		checks = append(checks, DoctorCheck{Name: DoctorCheckDiscovery, Status: DoctorPass, Message: row.Issuer + " is reachable"})
+	}
+	if len(checks) == 0 {
+		checks = append(checks, DoctorCheck{
+			Name:    DoctorCheckDiscovery,
+			Status:  DoctorWarn,
+			Message: "no providers are configured, nobody can log in",
+			Hint:    "add a provider to " + d.Lint.ProvidersPath,
+		})
+	}
+	return checks
+}
+
+func (d *DoctorCmd) discover(issuer string) error {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := d.HttpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the OpenID configuration: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JwksURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&discovery); err != nil {
+		return fmt.Errorf("invalid OpenID configuration at %s: %w", url, err)
+	}
+	if discovery.Issuer != issuer {
+		return fmt.Errorf("the OpenID configuration is for issuer %q, the issuer must match exactly", discovery.Issuer)
+	}
+	if discovery.JwksURI == "" {
+		return fmt.Errorf("the OpenID configuration at %s has no jwks_uri", url)
+	}
+	return nil
+}
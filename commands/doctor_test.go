@@ -0,0 +1,206 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDoctorCmd returns a DoctorCmd of a server whose only provider is
+// issuer, served by server, and whose sshd config is sshdConfig
+func newTestDoctorCmd(t *testing.T, server *httptest.Server, issuer string, sshdConfig string) (*DoctorCmd, afero.Fs, *bytes.Buffer) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	base := policy.GetSystemConfigBasePath()
+	require.NoError(t, fs.MkdirAll(filepath.Join(base, "policy.d"), 0o750))
+	require.NoError(t, afero.WriteFile(fs, policy.SystemDefaultPolicyPath, []byte("root alice@example.com "+issuer+"\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, policy.SystemDefaultProvidersPath, []byte(issuer+" client-id 24h\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte(sshdConfig), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/usr/local/bin/opkssh", []byte("binary"), 0o755))
+
+	out := &bytes.Buffer{}
+	lint := newTestLintCmd(t, fs, out)
+	lint.PolicyPath = policy.SystemDefaultPolicyPath
+	lint.ProvidersPath = policy.SystemDefaultProvidersPath
+	lint.PluginsDir = policy.GetPluginPolicyDir()
+	d := &DoctorCmd{
+		Fs:             fs,
+		Out:            out,
+		UserLookup:     testUserLookup{"opksshuser": &user.User{Username: "opksshuser"}},
+		Permissions:    newTestPermissionsCmd(fs, out),
+		Lint:           lint,
+		HttpClient:     server.Client(),
+		SshdConfigPath: "/etc/ssh/sshd_config",
+	}
+	return d, fs, out
+}
+
+func newTestDiscoveryServer(t *testing.T, issuer *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": *issuer, "jwks_uri": *issuer + "/keys"})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doctorStatuses(checks []DoctorCheck) map[string][]DoctorStatus {
+	statuses := map[string][]DoctorStatus{}
+	for _, c := range checks {
+		statuses[c.Name] = append(statuses[c.Name], c.Status)
+	}
+	return statuses
+}
+
+func TestDoctor(t *testing.T) {
+	var issuer string
+	server := newTestDiscoveryServer(t, &issuer)
+	issuer = server.URL
+
+	d, fs, out := newTestDoctorCmd(t, server, issuer, "Port 22\nInclude /etc/ssh/sshd_config.d/*.conf\nAuthorizedKeysCommand /usr/bin/false\n")
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/60-opk-ssh.conf", []byte(
+		"AuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\n"+
+			"AuthorizedKeysCommandUser=opksshuser\n"), 0o644))
+
+	require.NoError(t, d.Run(), out.String())
+	require.Equal(t, map[string][]DoctorStatus{
+		DoctorCheckPermissions: {DoctorPass},
+		DoctorCheckPolicy:      {DoctorPass},
+		DoctorCheckSshdConfig:  {DoctorPass},
+		DoctorCheckUser:        {DoctorPass},
+		DoctorCheckDiscovery:   {DoctorPass},
+	}, doctorStatuses(d.Checks()))
+	require.Contains(t, out.String(), "PASS  sshd-config  AuthorizedKeysCommand calls /usr/local/bin/opkssh verify")
+	require.Contains(t, out.String(), "passed: 5, warnings: 0, failed: 0")
+}
+
+func TestDoctorReportsProblems(t *testing.T) {
+	issuer := "https://other.example.com"
+	server := newTestDiscoveryServer(t, &issuer)
+
+	// Settings in a Match block don't apply to every connection
+	d, fs, out := newTestDoctorCmd(t, server, server.URL, "Match User alice\n  AuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\n")
+	require.NoError(t, fs.Remove(policy.GetPluginPolicyDir()))
+	d.UserLookup = testUserLookup{}
+	d.JsonOutput = true
+
+	err := d.Run()
+	require.ErrorContains(t, err, "doctor found 5 problems")
+	var checks []DoctorCheck
+	require.NoError(t, json.Unmarshal(out.Bytes(), &checks), out.String())
+	require.Equal(t, map[string][]DoctorStatus{
+		DoctorCheckPermissions: {DoctorFail},
+		DoctorCheckPolicy:      {DoctorPass},
+		DoctorCheckSshdConfig:  {DoctorFail, DoctorWarn, DoctorFail},
+		DoctorCheckUser:        {DoctorFail},
+		DoctorCheckDiscovery:   {DoctorFail},
+	}, doctorStatuses(checks))
+	for _, c := range checks {
+		if c.Status == DoctorFail {
+			require.NotEmpty(t, c.Hint, c.Message)
+		}
+	}
+	require.Contains(t, checks[len(checks)-1].Message, `the OpenID configuration is for issuer "https://other.example.com"`)
+
+	// A command that isn't opkssh verify, or is called without the tokens
+	for config, want := range map[string]DoctorStatus{
+		"AuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\nAuthorizedKeysCommandUser opksshuser\n":        DoctorFail,
+		"AuthorizedKeysCommand /opt/opkssh verify %u %k %t\nAuthorizedKeysCommandUser opksshuser\n":               DoctorFail,
+		"AuthorizedKeysCommand /usr/local/bin/opkssh verify %u\nAuthorizedKeysCommandUser opksshuser\n":           DoctorWarn,
+		"authorizedkeyscommand \"/usr/local/bin/opkssh\" verify %u %k %t\nAuthorizedKeysCommandUser opksshuser\n": DoctorPass,
+	} {
+		require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte(config), 0o644))
+		checks, _ := d.checkSshdConfig()
+		require.Len(t, checks, 1, config)
+		require.Equal(t, want, checks[0].Status, config)
+	}
+}
+
+func TestDoctorSshdConfigDrift(t *testing.T) {
+	var issuer string
+	server := newTestDiscoveryServer(t, &issuer)
+	issuer = server.URL
+	d, fs, _ := newTestDoctorCmd(t, server, issuer, "Include /etc/ssh/sshd_config.d/*.conf\nAuthorizedKeysCommandUser opksshuser\n")
+	d.Executable = "/usr/local/bin/opkssh"
+	require.NoError(t, afero.WriteFile(fs, "/opt/opkssh", []byte("binary"), 0o755))
+	sshdCheck := func(dropIns map[string]string) []DoctorCheck {
+		t.Helper()
+		require.NoError(t, fs.RemoveAll("/etc/ssh/sshd_config.d"))
+		for name, content := range dropIns {
+			require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/"+name, []byte(content), 0o644))
+		}
+		checks, _ := d.checkSshdConfig()
+		return checks
+	}
+
+	// A directive read earlier shadows the one of opkssh
+	checks := sshdCheck(map[string]string{
+		"20-sssd.conf":    "AuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\n",
+		"60-opk-ssh.conf": "AuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\n",
+	})
+	require.Len(t, checks, 2)
+	require.Equal(t, DoctorFail, checks[0].Status)
+	require.Equal(t, DoctorFail, checks[1].Status)
+	require.Equal(t, `AuthorizedKeysCommand at /etc/ssh/sshd_config.d/60-opk-ssh.conf:1 is shadowed by AuthorizedKeysCommand "/usr/bin/sss_ssh_authorizedkeys %u" at /etc/ssh/sshd_config.d/20-sssd.conf:1, sshd uses the first one it reads`, checks[1].Message)
+
+	// Match blocks override the directives for some connections
+	checks = sshdCheck(map[string]string{
+		"60-opk-ssh.conf": "AuthorizedKeysCommand /usr/local/bin/opkssh verify --via-socket %u %k %t\nMatch Group legacy\n  AuthorizedKeysCommandUser nobody\n",
+	})
+	require.Len(t, checks, 2)
+	require.Equal(t, DoctorPass, checks[0].Status)
+	require.Equal(t, DoctorWarn, checks[1].Status)
+	require.Equal(t, `AuthorizedKeysCommandUser at /etc/ssh/sshd_config.d/60-opk-ssh.conf:3 overrides it for connections matching "Match Group legacy"`, checks[1].Message)
+
+	for command, want := range map[string]string{
+		"/opt/opkssh verify %u %k %t":                             "AuthorizedKeysCommand runs /opt/opkssh, not the installed opkssh /usr/local/bin/opkssh",
+		"/usr/local/bin/opkssh verify --socket /run/x.sock %u %t": `AuthorizedKeysCommand "/usr/local/bin/opkssh verify --socket /run/x.sock %u %t" does not pass %u %k %t`,
+		"/usr/local/bin/opkssh verify --debug %u %k %t":           "AuthorizedKeysCommand passes unknown flags to opkssh verify: --debug",
+		"/usr/local/bin/opkssh verify --connection=%C %u %k %t":   "",
+	} {
+		checks = sshdCheck(map[string]string{"60-opk-ssh.conf": "AuthorizedKeysCommand " + command + "\n"})
+		require.Len(t, checks, 1, command)
+		if want == "" {
+			require.Equal(t, DoctorPass, checks[0].Status, command)
+		} else {
+			require.Equal(t, DoctorWarn, checks[0].Status, command)
+			require.Equal(t, want, checks[0].Message)
+		}
+	}
+
+	// verify can't read the opkssh files as another user
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte("AuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\nAuthorizedKeysCommandUser nobody\n"), 0o644))
+	checks, commandUser := d.checkSshdConfig()
+	require.Equal(t, "nobody", commandUser)
+	require.Len(t, checks, 2)
+	require.Equal(t, DoctorFail, checks[1].Status)
+	require.Equal(t, "AuthorizedKeysCommandUser at /etc/ssh/sshd_config:2 is nobody, verify runs as opksshuser", checks[1].Message)
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/spf13/afero"
+)
+
+// Explain makes the verification write each of its steps to w, for opkssh
+// verify --explain. The login is checked as sshd would have it checked, but
+// nothing is recorded: the audit log, deny reason file, rate limit failures,
+// replay cache, notifications and account provisioning are skipped. Call it
+// once the server config is applied, it replaces CheckPolicy with a policy
+// enforcer for username that traces the policy files and entries.
+func (v *VerifyCmd) Explain(w io.Writer, username string) {
+	v.explain = true
+	v.Trace = func(format string, args ...any) {
+		fmt.Fprintf(w, format+"\n", args...)
+	}
+	policyEnforcer, policyLoader := newOpkPolicyEnforcer(username, v.ServerConfig, v.ProviderPolicy, v.RecordMatch)
+	policyEnforcer.Trace = v.Trace
+	policyLoader.Trace = v.Trace
+	v.CheckPolicy = policyEnforcer.CheckPolicy
+}
+
+// ReadSshCertFile returns the key type and base64-encoded certificate of
+// the SSH certificate file at path, in the authorized_keys format of
+// ~/.ssh/id_ecdsa-cert.pub
+func ReadSshCertFile(fsys afero.Fs, path string) (string, string, error) {
+	content, err := afero.ReadFile(fsys, path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read SSH certificate: %w", err)
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 2 || !strings.HasSuffix(fields[0], "-cert-v01@openssh.com") {
+		return "", "", fmt.Errorf("%s is not an SSH certificate file", path)
+	}
+	return fields[0], fields[1], nil
+}
+
+func (v *VerifyCmd) trace(format string, args ...any) {
+	if v.Trace != nil {
+		v.Trace(format, args...)
+	}
+}
+
+// traceToken traces the provider that verified pkt and the claims of its ID
+// Token
+func (v *VerifyCmd) traceToken(pkt *pktoken.PKToken) {
+	if v.Trace == nil {
+		return
+	}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return
+	}
+	claims := idt.GetClaims()
+	v.trace("Verified the PK Token of issuer %s", claims.Issuer)
+	if v.ProviderPolicy != nil {
+		for _, row := range v.ProviderPolicy.GetRows() {
+			if row.Issuer != claims.Issuer {
+				continue
+			}
+			v.trace("  provider: %s", row.ToString())
+			if expiresAt, ok := v.ProviderPolicy.ExpiresAt(claims.Issuer, claims.Audience, time.Unix(claims.IssuedAt, 0), time.Unix(claims.Expiration, 0)); ok {
+				v.trace("  accepted until %s under the %s expiration policy", expiresAt.UTC().Format(time.RFC3339), row.ExpirationPolicy)
+			}
+			break
+		}
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(pkt.Payload, &payload); err != nil {
+		return
+	}
+	names := make([]string, 0, len(payload))
+	for name := range payload {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	v.trace("ID Token claims:")
+	for _, name := range names {
+		v.trace("  %s: %s", name, strings.TrimSpace(string(payload[name])))
+	}
+}
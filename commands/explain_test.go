@@ -0,0 +1,95 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	state, err := s.Load()
+	require.NoError(t, err)
+	limiter := policy.NewRateLimiter(1, 0, 0)
+	limiter.Fs = afero.NewMemMapFs()
+	memory := policy.NewMemoryReplayStore()
+	ver := &VerifyCmd{
+		Fs:            afero.NewMemMapFs(),
+		PktVerifier:   state.PktVerifier,
+		SshConnection: "192.0.2.10 52044 10.0.1.20 22",
+		ReplayMemory:  memory,
+	}
+	ver.ApplyServerConfig(&config.ServerConfig{
+		ReplayCache: config.ReplayCacheConfig{Backend: ReplayBackendMemory, SingleUse: true},
+		DenyReasons: config.DenyReasonsConfig{File: "/run/opk/deny/%u"},
+	})
+	ver.RateLimiter = limiter
+	out := &bytes.Buffer{}
+	ver.Explain(out, req.Principal)
+	denied := true
+	ver.CheckPolicy = func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+		if denied {
+			return fmt.Errorf("no policy to allow %s", userDesired)
+		}
+		ver.RecordMatch(policy.Match{Entry: "user alice@example.com https://accounts.example.com", Source: "/etc/opk/auth_id"})
+		return nil
+	}
+
+	_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, req.Cert, nil)
+	require.EqualError(t, err, "no policy to allow user")
+	require.Contains(t, out.String(), "Verifying the login as user from \"192.0.2.10\"\n")
+	require.Contains(t, out.String(), "Verified the PK Token of issuer https://accounts.example.com\nID Token claims:\n")
+	require.Contains(t, out.String(), "  iss: \"https://accounts.example.com\"\n")
+	require.Contains(t, out.String(), "Denied (error): no policy to allow user\n")
+
+	// Nothing is recorded, so the failure doesn't throttle the client and
+	// the single use token can be used again
+	out.Reset()
+	denied = false
+	for i := 0; i < 2; i++ {
+		_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, req.Cert, nil)
+		require.NoError(t, err)
+	}
+	require.Contains(t, out.String(), "Not checking the replay cache, it would record the token\n")
+	require.Contains(t, out.String(), "Allowed by \"user alice@example.com https://accounts.example.com\" in /etc/opk/auth_id\n")
+	exists, err := afero.Exists(ver.Fs, "/run/opk/deny/user")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestReadSshCertFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/home/bob/.ssh/id_ecdsa-cert.pub", []byte("ecdsa-sha2-nistp256-cert-v01@openssh.com AAAA bob@example.com\n"), 0o644))
+	require.NoError(t, afero.WriteFile(fs, "/home/bob/.ssh/id_ecdsa.pub", []byte("ecdsa-sha2-nistp256 AAAA\n"), 0o644))
+
+	typ, cert, err := ReadSshCertFile(fs, "/home/bob/.ssh/id_ecdsa-cert.pub")
+	require.NoError(t, err)
+	require.Equal(t, "ecdsa-sha2-nistp256-cert-v01@openssh.com", typ)
+	require.Equal(t, "AAAA", cert)
+
+	_, _, err = ReadSshCertFile(fs, "/home/bob/.ssh/id_ecdsa.pub")
+	require.EqualError(t, err, "/home/bob/.ssh/id_ecdsa.pub is not an SSH certificate file")
+}
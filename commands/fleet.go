@@ -0,0 +1,597 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+)
+
+const (
+	FleetLeader  = "leader"
+	FleetReplica = "replica"
+
+	defaultFleetListen   = ":8449"
+	defaultFleetInterval = 5 * time.Minute
+	// maxFleetBody bounds the size of a fleet request or response
+	maxFleetBody = 16 << 20
+)
+
+// FleetIndex lists the fragments the leader distributes with the sha256 of
+// their content. Replicas only fetch the fragments whose sum changed.
+type FleetIndex struct {
+	Revision  string            `json:"revision"`
+	Fragments map[string]string `json:"fragments"`
+}
+
+// ReplicaStatus is reported by a replica to the leader after each pull
+type ReplicaStatus struct {
+	// Name is the common name of the replica's client certificate
+	Name     string    `json:"name"`
+	Revision string    `json:"revision"`
+	LastSync time.Time `json:"last_sync,omitzero"`
+	Error    string    `json:"error,omitempty"`
+	// SeenAt is when the leader received the status
+	SeenAt time.Time `json:"seen_at,omitzero"`
+}
+
+// FleetStatus is the state of the replicas as seen by the leader
+type FleetStatus struct {
+	Revision string          `json:"revision"`
+	Replicas []ReplicaStatus `json:"replicas"`
+}
+
+// fleetState is what a replica remembers between pulls
+type fleetState struct {
+	Revision  string            `json:"revision"`
+	Fragments map[string]string `json:"fragments"`
+	LastSync  time.Time         `json:"last_sync,omitzero"`
+}
+
+// FleetCmd copies the signed policy fragments of a leader host to replica
+// hosts. The leader serves its fragments over mutual TLS and replicas pull
+// the ones that changed, so the fragments sync jobs write on the leader reach
+// every host without any other distribution mechanism.
+type FleetCmd struct {
+	Config config.FleetConfig
+	Fs     afero.Fs
+	// Fragments is the fragment store that is served on the leader and
+	// written on replicas. Replicas only write fragments signed by one of
+	// its TrustedKeys.
+	Fragments *policy.FragmentStore
+	// StatePath is where a replica records the fragments it pulled
+	StatePath string
+	// Journal, if set, records the fragments a replica changed
+	Journal *policy.Journal
+	// HttpClient is used by replicas, if nil one is built from Config
+	HttpClient *http.Client
+	Logger     *slog.Logger
+	Out        io.Writer
+	Now        func() time.Time
+
+	mu       sync.Mutex
+	replicas map[string]ReplicaStatus
+}
+
+// NewFleetCmd creates a new FleetCmd using the default fragment store and
+// the fragment keys of the server config
+func NewFleetCmd(rt *Runtime, cfg config.FleetConfig, fragments config.PolicyFragmentsConfig) (*FleetCmd, error) {
+	trustedKeys, err := FragmentTrustedKeys(fragments)
+	if err != nil {
+		return nil, err
+	}
+	store := policy.NewFragmentStore()
+	store.TrustedKeys = trustedKeys
+	return &FleetCmd{
+		Config:    cfg,
+		Fs:        rt.Fs,
+		Fragments: store,
+		StatePath: filepath.Join(policy.GetSystemStateBasePath(), "fleet.json"),
+		Journal:   policy.NewJournal(),
+		Logger:    rt.Logger,
+		Out:       rt.Out,
+		Now:       rt.Now,
+	}, nil
+}
+
+// Run serves or pulls depending on the configured role until ctx is
+// cancelled. once makes a replica pull a single time.
+func (f *FleetCmd) Run(ctx context.Context, once bool) error {
+	switch f.Config.Role {
+	case FleetLeader:
+		if once {
+			return fmt.Errorf("--once can only be used on a replica")
+		}
+		return f.Serve(ctx)
+	case FleetReplica:
+		if once {
+			return f.PullOnce(ctx)
+		}
+		return f.Pull(ctx)
+	case "":
+		return fmt.Errorf("fleet role must be configured as %s or %s", FleetLeader, FleetReplica)
+	default:
+		return fmt.Errorf("invalid fleet role %q, expected %s or %s", f.Config.Role, FleetLeader, FleetReplica)
+	}
+}
+
+// tlsConfig loads the CA, certificate and key of Config. It is used both as
+// the server config of the leader and the client config of replicas.
+func (f *FleetCmd) tlsConfig() (*tls.Config, error) {
+	if f.Config.CAFile == "" || f.Config.CertFile == "" || f.Config.KeyFile == "" {
+		return nil, fmt.Errorf("fleet ca_file, cert_file and key_file must be configured")
+	}
+	caPEM, err := afero.ReadFile(f.Fs, f.Config.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet ca_file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("fleet ca_file %s does not contain a PEM certificate", f.Config.CAFile)
+	}
+	certPEM, err := afero.ReadFile(f.Fs, f.Config.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet cert_file: %w", err)
+	}
+	keyPEM, err := afero.ReadFile(f.Fs, f.Config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet key_file: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fleet certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// Index returns the fragments the leader distributes. Fragments that fail
+// the checks verify makes are left out.
+func (f *FleetCmd) Index() (*FleetIndex, error) {
+	names, err := f.Fragments.Names()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list policy fragments: %w", err)
+	}
+	index := &FleetIndex{Fragments: map[string]string{}}
+	for _, name := range names {
+		content, err := f.Fragments.Read(name)
+		if err != nil {
+			f.Logger.Warn("Not distributing policy fragment", "fragment", name, "error", err)
+			continue
+		}
+		index.Fragments[name] = contentSum(content)
+	}
+	index.Revision = indexRevision(index.Fragments)
+	return index, nil
+}
+
+// Handler returns the HTTP API of the leader
+func (f *FleetCmd) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/index", func(w http.ResponseWriter, r *http.Request) {
+		index, err := f.Index()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, index)
+	})
+	mux.HandleFunc("GET /v1/fragments/{name}", func(w http.ResponseWriter, r *http.Request) {
+		content, err := f.Fragments.Read(r.PathValue("name"))
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(content)
+	})
+	mux.HandleFunc("POST /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		name := replicaName(r)
+		if name == "" {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		var status ReplicaStatus
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxFleetBody)).Decode(&status); err != nil {
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		// The name comes from the certificate, not from the replica
+		status.Name = name
+		status.SeenAt = f.Now().UTC()
+		f.mu.Lock()
+		if f.replicas == nil {
+			f.replicas = map[string]ReplicaStatus{}
+		}
+		f.replicas[name] = status
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		index, err := f.Index()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := FleetStatus{Revision: index.Revision, Replicas: []ReplicaStatus{}}
+		f.mu.Lock()
+		for _, replica := range f.replicas {
+			status.Replicas = append(status.Replicas, replica)
+		}
+		f.mu.Unlock()
+		slices.SortFunc(status.Replicas, func(a, b ReplicaStatus) int { return strings.Compare(a.Name, b.Name) })
+		writeJSON(w, status)
+	})
+	return mux
+}
+
+// replicaName is the common name of the client certificate of r
+func replicaName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Serve runs the leader until ctx is cancelled. Only clients with a
+// certificate issued by the fleet CA are accepted.
+func (f *FleetCmd) Serve(ctx context.Context) error {
+	tlsConfig, err := f.tlsConfig()
+	if err != nil {
+		return err
+	}
+	listen := f.Config.Listen
+	if listen == "" {
+		listen = defaultFleetListen
+	}
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           f.Handler(),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	f.Logger.Info("Serving policy fragments to replicas", "addr", listen)
+	// The certificate is already in TLSConfig
+	err = server.ListenAndServeTLS("", "")
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (f *FleetCmd) client() (*http.Client, error) {
+	if f.HttpClient != nil {
+		return f.HttpClient, nil
+	}
+	tlsConfig, err := f.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	f.HttpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return f.HttpClient, nil
+}
+
+// do sends a request to the leader and returns the response body
+func (f *FleetCmd) do(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	if f.Config.Leader == "" {
+		return nil, fmt.Errorf("fleet leader must be configured")
+	}
+	if !strings.HasPrefix(f.Config.Leader, "https://") {
+		return nil, fmt.Errorf("fleet leader %q must be an https URL", f.Config.Leader)
+	}
+	client, err := f.client()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(f.Config.Leader, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach fleet leader: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFleetBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response of fleet leader: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("fleet leader returned %s for %s", resp.Status, path)
+	}
+	return data, nil
+}
+
+// PullOnce brings the fragments of a replica up to date with the leader and
+// reports the result to the leader
+func (f *FleetCmd) PullOnce(ctx context.Context) error {
+	state, pullErr := f.pull(ctx)
+
+	status := ReplicaStatus{Revision: state.Revision, LastSync: state.LastSync}
+	if pullErr != nil {
+		status.Error = pullErr.Error()
+	}
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if _, err := f.do(ctx, http.MethodPost, "/v1/status", body); err != nil {
+		f.Logger.Warn("Failed to report status to fleet leader", "error", err)
+	}
+	return pullErr
+}
+
+func (f *FleetCmd) pull(ctx context.Context) (*fleetState, error) {
+	state, err := f.loadState()
+	if err != nil {
+		return &fleetState{}, err
+	}
+	if len(f.Fragments.TrustedKeys) == 0 {
+		return state, fmt.Errorf("policy_fragments trusted_keys must be configured on a replica")
+	}
+
+	data, err := f.do(ctx, http.MethodGet, "/v1/index", nil)
+	if err != nil {
+		return state, err
+	}
+	var index FleetIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return state, fmt.Errorf("invalid index from fleet leader: %w", err)
+	}
+
+	var summary []string
+	names := make([]string, 0, len(index.Fragments))
+	for name := range index.Fragments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		sum := index.Fragments[name]
+		// The local copy is compared rather than the state so that a
+		// fragment changed locally is restored
+		if local, err := f.Fragments.Read(name); err == nil && contentSum(local) == sum {
+			continue
+		}
+		content, err := f.do(ctx, http.MethodGet, "/v1/fragments/"+name, nil)
+		if err != nil {
+			return state, err
+		}
+		if contentSum(content) != sum {
+			return state, fmt.Errorf("policy fragment %s changed on the leader while pulling", name)
+		}
+		if err := f.Fragments.WriteSigned(name, content); err != nil {
+			return state, err
+		}
+		summary = append(summary, "updated "+name)
+	}
+	for name := range state.Fragments {
+		if _, ok := index.Fragments[name]; ok {
+			continue
+		}
+		if err := f.Fragments.Remove(name); err != nil {
+			return state, err
+		}
+		summary = append(summary, "removed "+name)
+	}
+
+	state.Revision = index.Revision
+	state.Fragments = index.Fragments
+	state.LastSync = f.Now().UTC()
+	if err := f.saveState(state); err != nil {
+		return state, err
+	}
+	if len(summary) > 0 {
+		f.recordChange(index.Revision, summary)
+	}
+	return state, nil
+}
+
+func (f *FleetCmd) recordChange(revision string, summary []string) {
+	if f.Journal != nil {
+		if err := f.Journal.Append(policy.JournalEntry{
+			Action:  "fleet",
+			Path:    f.Fragments.Dir,
+			Summary: summary,
+		}); err != nil {
+			f.Logger.Warn("Failed to record change in policy journal", "error", err)
+		}
+	}
+	events.Emit(events.PolicyChanged, map[string]string{
+		"path":     f.Fragments.Dir,
+		"action":   "fleet",
+		"revision": revision,
+		"changed":  fmt.Sprint(len(summary)),
+	})
+}
+
+func (f *FleetCmd) loadState() (*fleetState, error) {
+	state := &fleetState{Fragments: map[string]string{}}
+	data, err := afero.ReadFile(f.Fs, f.StatePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return state, fmt.Errorf("failed to read fleet state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return &fleetState{Fragments: map[string]string{}}, fmt.Errorf("invalid fleet state %s: %w", f.StatePath, err)
+	}
+	return state, nil
+}
+
+func (f *FleetCmd) saveState(state *fleetState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := f.Fs.MkdirAll(filepath.Dir(f.StatePath), 0o750); err != nil {
+		return fmt.Errorf("failed to save fleet state: %w", err)
+	}
+	if err := afero.WriteFile(f.Fs, f.StatePath, data, 0o640); err != nil {
+		return fmt.Errorf("failed to save fleet state: %w", err)
+	}
+	return nil
+}
+
+// Pull runs PullOnce every Interval until ctx is cancelled
+func (f *FleetCmd) Pull(ctx context.Context) error {
+	interval, err := f.interval()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := f.PullOnce(ctx); err != nil {
+			f.Logger.Error("Failed to pull policy fragments from fleet leader", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *FleetCmd) interval() (time.Duration, error) {
+	if f.Config.Interval == "" {
+		return defaultFleetInterval, nil
+	}
+	d, err := time.ParseDuration(f.Config.Interval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid fleet interval %q", f.Config.Interval)
+	}
+	return d, nil
+}
+
+// Status fetches the state of the replicas from the leader
+func (f *FleetCmd) Status(ctx context.Context) (*FleetStatus, error) {
+	data, err := f.do(ctx, http.MethodGet, "/v1/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	var status FleetStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("invalid status from fleet leader: %w", err)
+	}
+	return &status, nil
+}
+
+// PrintStatus writes status as a table, or as JSON if jsonOutput is set. A
+// replica that has not reported for three intervals is shown as stale.
+func (f *FleetCmd) PrintStatus(status *FleetStatus, jsonOutput bool) error {
+	if jsonOutput {
+		enc := json.NewEncoder(f.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+	interval, err := f.interval()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f.Out, "Leader revision: %s\n", status.Revision)
+	if len(status.Replicas) == 0 {
+		fmt.Fprintln(f.Out, "No replica has reported yet")
+		return nil
+	}
+	w := tabwriter.NewWriter(f.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPLICA\tREVISION\tLAST SYNC\tSTATUS")
+	for _, replica := range status.Replicas {
+		state := "in sync"
+		switch {
+		case replica.Error != "":
+			state = "error: " + replica.Error
+		case f.Now().Sub(replica.SeenAt) > 3*interval:
+			state = "stale, last seen " + replica.SeenAt.Format(time.RFC3339)
+		case replica.Revision != status.Revision:
+			state = "behind"
+		}
+		lastSync := "never"
+		if !replica.LastSync.IsZero() {
+			lastSync = replica.LastSync.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", replica.Name, replica.Revision, lastSync, state)
+	}
+	return w.Flush()
+}
+
+func contentSum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// indexRevision identifies the set of fragments in an index
+func indexRevision(fragments map[string]string) string {
+	names := make([]string, 0, len(fragments))
+	for name := range fragments {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name + " " + fragments[name] + "\n")
+	}
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:8])
+}
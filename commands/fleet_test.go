@@ -0,0 +1,281 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testFleetPKI issues the certificates of a fleet
+type testFleetPKI struct {
+	t      *testing.T
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	caPEM  []byte
+	serial int64
+}
+
+func newTestFleetPKI(t *testing.T) *testFleetPKI {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fleet CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testFleetPKI{t: t, ca: ca, caKey: key, caPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), serial: 1}
+}
+
+// issue returns the PEM certificate and key of name
+func (p *testFleetPKI) issue(name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(p.t, err)
+	p.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	require.NoError(p.t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(p.t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestFleet starts a leader with the fragments signed by signer and
+// returns it with a replica named web1 that trusts signer
+func newTestFleet(t *testing.T, signer ssh.Signer) (*FleetCmd, *FleetCmd, *httptest.Server) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	leaderFs := afero.NewMemMapFs()
+	leader := &FleetCmd{
+		Config:    config.FleetConfig{Role: FleetLeader},
+		Fs:        leaderFs,
+		Fragments: &policy.FragmentStore{Fs: leaderFs, Dir: "/var/lib/opk/policy", Signer: signer},
+		Logger:    logger,
+		Now:       func() time.Time { return now },
+	}
+
+	pki := newTestFleetPKI(t)
+	serverCert, serverKey := pki.issue("leader", x509.ExtKeyUsageServerAuth)
+	cert, err := tls.X509KeyPair(serverCert, serverKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(pki.ca)
+	server := httptest.NewUnstartedServer(leader.Handler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	replicaFs := afero.NewMemMapFs()
+	clientCert, clientKey := pki.issue("web1", x509.ExtKeyUsageClientAuth)
+	require.NoError(t, afero.WriteFile(replicaFs, "/etc/opk/fleet/ca.pem", pki.caPEM, 0o640))
+	require.NoError(t, afero.WriteFile(replicaFs, "/etc/opk/fleet/web1.pem", clientCert, 0o640))
+	require.NoError(t, afero.WriteFile(replicaFs, "/etc/opk/fleet/web1.key", clientKey, 0o600))
+	replica := &FleetCmd{
+		Config: config.FleetConfig{
+			Role:     FleetReplica,
+			Leader:   server.URL,
+			CAFile:   "/etc/opk/fleet/ca.pem",
+			CertFile: "/etc/opk/fleet/web1.pem",
+			KeyFile:  "/etc/opk/fleet/web1.key",
+		},
+		Fs:        replicaFs,
+		Fragments: &policy.FragmentStore{Fs: replicaFs, Dir: "/var/lib/opk/policy", TrustedKeys: []ssh.PublicKey{signer.PublicKey()}},
+		StatePath: "/var/lib/opk/fleet.json",
+		Logger:    logger,
+		Out:       &bytes.Buffer{},
+		Now:       func() time.Time { return now },
+	}
+	return leader, replica, server
+}
+
+func newTestFragmentSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestFleetPull(t *testing.T) {
+	t.Parallel()
+	signer := newTestFragmentSigner(t)
+	leader, replica, _ := newTestFleet(t, signer)
+	ctx := context.Background()
+
+	dev := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"},
+	}}
+	ops := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "bob@example.com", Principals: []string{"root"}, Issuer: "https://accounts.google.com"},
+	}}
+	require.NoError(t, leader.Fragments.Write("google", nil, dev))
+	require.NoError(t, leader.Fragments.Write("azuread", nil, ops))
+
+	require.NoError(t, replica.PullOnce(ctx))
+	merged, _, err := replica.Fragments.Load()
+	require.NoError(t, err)
+	require.ElementsMatch(t, append(dev.Users, ops.Users...), merged.Users)
+
+	index, err := leader.Index()
+	require.NoError(t, err)
+	status, err := replica.Status(ctx)
+	require.NoError(t, err)
+	require.Equal(t, index.Revision, status.Revision)
+	require.Len(t, status.Replicas, 1)
+	require.Equal(t, "web1", status.Replicas[0].Name)
+	require.Equal(t, index.Revision, status.Replicas[0].Revision)
+	require.Empty(t, status.Replicas[0].Error)
+
+	// Only the changed fragment is fetched and removed fragments are deleted
+	require.NoError(t, leader.Fragments.Remove("azuread"))
+	dev.Users = append(dev.Users, policy.User{IdentityAttribute: "carol@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"})
+	require.NoError(t, leader.Fragments.Write("google", nil, dev))
+	require.NoError(t, replica.PullOnce(ctx))
+	names, err := replica.Fragments.Names()
+	require.NoError(t, err)
+	require.Equal(t, []string{"google"}, names)
+	merged, _, err = replica.Fragments.Load()
+	require.NoError(t, err)
+	require.Equal(t, dev.Users, merged.Users)
+
+	out := replica.Out.(*bytes.Buffer)
+	status, err = replica.Status(ctx)
+	require.NoError(t, err)
+	require.NoError(t, replica.PrintStatus(status, false))
+	require.Contains(t, out.String(), "Leader revision: "+status.Revision)
+	require.Contains(t, out.String(), "web1")
+	require.Contains(t, out.String(), "in sync")
+}
+
+func TestFleetPullRejectsUntrustedFragments(t *testing.T) {
+	t.Parallel()
+	leader, replica, _ := newTestFleet(t, newTestFragmentSigner(t))
+	ctx := context.Background()
+
+	// Signed by a key the replica does not trust
+	replica.Fragments.TrustedKeys = []ssh.PublicKey{newTestFragmentSigner(t).PublicKey()}
+	require.NoError(t, leader.Fragments.Write("google", nil, &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "eve@example.com", Principals: []string{"root"}, Issuer: "https://accounts.google.com"},
+	}}))
+	require.ErrorContains(t, replica.PullOnce(ctx), "not from a trusted key")
+	names, err := replica.Fragments.Names()
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	// The failure is reported to the leader
+	status, err := replica.Status(ctx)
+	require.NoError(t, err)
+	require.Contains(t, status.Replicas[0].Error, "not from a trusted key")
+	require.NoError(t, replica.PrintStatus(status, false))
+	require.Contains(t, replica.Out.(*bytes.Buffer).String(), "error: ")
+
+	replica.Fragments.TrustedKeys = nil
+	require.ErrorContains(t, replica.PullOnce(ctx), "trusted_keys must be configured")
+}
+
+func TestFleetLeaderRequiresClientCertificate(t *testing.T) {
+	t.Parallel()
+	leader, _, server := newTestFleet(t, newTestFragmentSigner(t))
+
+	// A client without a certificate is refused during the handshake
+	client := server.Client()
+	_, err := client.Get(server.URL + "/v1/index")
+	require.Error(t, err)
+
+	// The name of a replica comes from its certificate only
+	rec := httptest.NewRecorder()
+	leader.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/status", bytes.NewReader([]byte(`{"name":"web2"}`))))
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = httptest.NewRecorder()
+	leader.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/fragments/missing", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = httptest.NewRecorder()
+	leader.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/fragments/..%2Fauth_id", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestFleetRun(t *testing.T) {
+	t.Parallel()
+	f := &FleetCmd{}
+	require.ErrorContains(t, f.Run(context.Background(), false), "fleet role must be configured")
+	f.Config.Role = "primary"
+	require.ErrorContains(t, f.Run(context.Background(), false), `invalid fleet role "primary"`)
+	f.Config.Role = FleetLeader
+	require.ErrorContains(t, f.Run(context.Background(), true), "--once can only be used on a replica")
+	f.Fs = afero.NewMemMapFs()
+	require.ErrorContains(t, f.Run(context.Background(), false), "ca_file, cert_file and key_file must be configured")
+}
+
+func TestFleetPrintStatus(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	out := &bytes.Buffer{}
+	f := &FleetCmd{Out: out, Now: func() time.Time { return now }}
+	status := &FleetStatus{Revision: "r2", Replicas: []ReplicaStatus{
+		{Name: "db1", Revision: "r1", LastSync: now.Add(-time.Minute), SeenAt: now.Add(-time.Minute)},
+		{Name: "web1", Revision: "r1", LastSync: now.Add(-time.Hour), SeenAt: now.Add(-time.Hour)},
+	}}
+	require.NoError(t, f.PrintStatus(status, false))
+	require.Contains(t, out.String(), "behind")
+	require.Contains(t, out.String(), "stale, last seen 2026-01-02T02:04:05Z")
+
+	out.Reset()
+	require.NoError(t, f.PrintStatus(status, true))
+	var decoded FleetStatus
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Equal(t, *status, decoded)
+}
@@ -0,0 +1,615 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/openpubkey/opkssh/internal/eventlog"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// Lines written to the sshd config by opkssh install, so that they can be
+// found again by the next install
+const (
+	sshdInstallMarker   = "# Added by opkssh install"
+	sshdDisabledPrefix  = "# Disabled by opkssh install: "
+	sshdDropInSuffix    = "opk-ssh.conf"
+	sshdDefaultDropIn   = "60-" + sshdDropInSuffix
+	sshdAuthKeysCommand = "authorizedkeyscommand"
+	sshdAuthKeysUser    = "authorizedkeyscommanduser"
+)
+
+// InstallCmd sets up a server for opkssh: it creates the
+// AuthorizedKeysCommandUser and the configuration directory, and makes sshd
+// call opkssh verify. Uninstall undoes it.
+type InstallCmd struct {
+	Fs afero.Fs
+	// GOOS is runtime.GOOS, install supports linux and windows
+	GOOS string
+	// Executable is the path of the opkssh binary sshd runs
+	Executable string
+	// SshdConfigPath is the main sshd config file
+	SshdConfigPath string
+	// User is the AuthorizedKeysCommandUser
+	User       string
+	UserLookup policy.UserLookup
+	// Permissions creates the files and directories in the configuration
+	// directory with the permissions verify requires
+	Permissions *PermissionsCmd
+	// Service is removed by Uninstall
+	Service *ServiceCmd
+	// RemoveEventSource removes the event log source on Windows
+	RemoveEventSource func() error
+	IsElevatedFn      func() (bool, error)
+	// CmdRunner runs useradd, sshd and systemctl, or powershell.exe on
+	// Windows, defaults to exec
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+	Out       io.Writer
+
+	// NoSshdReload leaves reloading sshd to the user
+	NoSshdReload bool
+	// Purge makes Uninstall delete the configuration and state directories
+	Purge bool
+	// DryRun makes Uninstall print what it would do
+	DryRun bool
+}
+
+// NewInstallCmd creates an InstallCmd for the current binary
+func NewInstallCmd(rt *Runtime) (*InstallCmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the opkssh binary: %w", err)
+	}
+	service := &ServiceCmd{
+		Fs:         rt.Fs,
+		GOOS:       runtime.GOOS,
+		Executable: exe,
+		SocketPath: DefaultServeSocketPath(),
+		ConfigPath: policy.Defaults.ConfigPath,
+		CmdRunner:  rt.CmdRunner,
+		Out:        io.Discard,
+	}
+	return &InstallCmd{
+		Fs:                rt.Fs,
+		GOOS:              runtime.GOOS,
+		Executable:        exe,
+		SshdConfigPath:    defaultSshdConfigPath(),
+		User:              policy.Defaults.User,
+		UserLookup:        rt.UserLookup,
+		Permissions:       NewPermissionsCmd(rt),
+		Service:           service,
+		RemoveEventSource: eventlog.Uninstall,
+		IsElevatedFn:      IsElevated,
+		CmdRunner:         rt.CmdRunner,
+		Out:               rt.Out,
+	}, nil
+}
+
+// sshdFileChange is a file of the sshd config changed by install. A nil
+// After removes the file.
+type sshdFileChange struct {
+	Path   string
+	Before []byte
+	After  []byte
+}
+
+// String describes the change
+func (ch sshdFileChange) String() string {
+	if ch.After == nil {
+		return "Removed " + ch.Path
+	}
+	return "Updated " + ch.Path
+}
+
+// Run installs opkssh. The sshd config is only kept if sshd -t accepts it.
+func (c *InstallCmd) Run() error {
+	if c.GOOS != "linux" && c.GOOS != "windows" {
+		return fmt.Errorf("opkssh install supports Linux and Windows, use the install scripts on %s", c.GOOS)
+	}
+	elevated, err := c.IsElevatedFn()
+	if err != nil {
+		return fmt.Errorf("failed to determine elevation: %w", err)
+	}
+	if !elevated {
+		return fmt.Errorf("install requires elevated privileges (run as root or Administrator)")
+	}
+
+	if err := c.createUser(); err != nil {
+		return err
+	}
+	if err := c.createConfigDir(); err != nil {
+		return err
+	}
+
+	changes, err := c.planSshdConfig()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(c.Out, "sshd already calls %s verify\n", c.Executable)
+		return nil
+	}
+	if err := c.applySshdConfig(changes); err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		fmt.Fprintln(c.Out, ch.String())
+	}
+	if c.NoSshdReload {
+		fmt.Fprintln(c.Out, "Reload sshd to apply the changes")
+		return nil
+	}
+	return c.reloadSshd()
+}
+
+// run runs name and includes its output in the error
+func (c *InstallCmd) run(name string, arg ...string) error {
+	if out, err := c.CmdRunner(name, arg...); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// windowsCreateUserScript creates the AuthorizedKeysCommandUser %[1]s on
+// Windows. An account without a password could log in wherever blank
+// passwords are allowed, so it gets a random one that is never shown, and it
+// is denied the interactive and Remote Desktop logons. sshd runs the
+// AuthorizedKeysCommand as the user with an S4U logon, which needs neither.
+const windowsCreateUserScript = `$ErrorActionPreference = 'Stop'
+$bytes = New-Object byte[] 48
+[Security.Cryptography.RandomNumberGenerator]::Create().GetBytes($bytes)
+$password = ConvertTo-SecureString ([Convert]::ToBase64String($bytes) + 'aA1!') -AsPlainText -Force
+$user = New-LocalUser -Name %[1]s -Password $password -PasswordNeverExpires -UserMayNotChangePassword -AccountNeverExpires -Description 'opkssh AuthorizedKeysCommandUser'
+$sid = $user.SID.Value
+$cfg = [IO.Path]::GetTempFileName()
+$db = $cfg + '.sdb'
+secedit /export /cfg $cfg /areas USER_RIGHTS | Out-Null
+$lines = Get-Content $cfg | ForEach-Object { if ($_ -match '^(SeDenyInteractiveLogonRight|SeDenyRemoteInteractiveLogonRight) = ') { $_ + ',*' + $sid } else { $_ } }
+foreach ($right in 'SeDenyInteractiveLogonRight', 'SeDenyRemoteInteractiveLogonRight') {
+  if (-not ($lines -match "^$right = ")) { $lines = $lines -replace '^\[Privilege Rights\]$', ('[Privilege Rights]' + [Environment]::NewLine + "$right = *$sid") }
+}
+Set-Content $cfg $lines -Encoding Unicode
+secedit /configure /db $db /cfg $cfg /areas USER_RIGHTS | Out-Null
+$code = $LASTEXITCODE
+Remove-Item $cfg, $db -ErrorAction SilentlyContinue
+if ($code -ne 0) { throw "secedit failed to deny the interactive logons to " + %[1]s }`
+
+// createUser creates the AuthorizedKeysCommandUser, a system account that
+// can't log in, unless it exists
+func (c *InstallCmd) createUser() error {
+	if _, err := c.UserLookup.Lookup(c.User); err == nil {
+		fmt.Fprintf(c.Out, "User %s already exists\n", c.User)
+		return nil
+	}
+	if c.GOOS == "windows" {
+		script := fmt.Sprintf(windowsCreateUserScript, powerShellQuote(c.User))
+		if err := c.run("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", script); err != nil {
+			return fmt.Errorf("failed to create user %s: %w", c.User, err)
+		}
+	} else {
+		if _, err := c.CmdRunner("getent", "group", c.User); err != nil {
+			if err := c.run("groupadd", "--system", c.User); err != nil {
+				return fmt.Errorf("failed to create group %s: %w", c.User, err)
+			}
+		}
+		if err := c.run("useradd", "-r", "-M", "-s", "/sbin/nologin", "-g", c.User, c.User); err != nil {
+			return fmt.Errorf("failed to create user %s: %w", c.User, err)
+		}
+	}
+	fmt.Fprintf(c.Out, "Created user %s\n", c.User)
+	return nil
+}
+
+// createConfigDir creates the configuration directory, readable by the
+// AuthorizedKeysCommandUser, and the files in it with the permissions
+// verify requires
+func (c *InstallCmd) createConfigDir() error {
+	base := policy.GetSystemConfigBasePath()
+	perm := files.PermInfo{Mode: 0o750, Owner: "root", Group: c.User}
+	// On Windows the ACLs set by permissions fix grant the access
+	if c.GOOS == "windows" {
+		perm.Owner, perm.Group = "", ""
+	}
+	if err := files.SetSecureFileState(c.Permissions.FileSystem, base, perm, true); err != nil {
+		return err
+	}
+	c.Permissions.Yes = true
+	if err := c.Permissions.Fix(); err != nil {
+		return fmt.Errorf("failed to set up %s: %w", base, err)
+	}
+	return nil
+}
+
+// sshdDirectives returns the lines that make sshd call opkssh verify
+func (c *InstallCmd) sshdDirectives() []string {
+	return []string{
+		sshdInstallMarker,
+		"AuthorizedKeysCommand " + windowsQuote(c.Executable) + " verify %u %k %t",
+		"AuthorizedKeysCommandUser " + c.User,
+	}
+}
+
+// splitSshdConfig returns the lines of content and the line ending it uses
+func splitSshdConfig(content []byte) ([]string, string) {
+	eol := "\n"
+	if bytes.Contains(content, []byte("\r\n")) {
+		eol = "\r\n"
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, eol
+	}
+	return strings.Split(text, "\n"), eol
+}
+
+func joinSshdConfig(lines []string, eol string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, eol) + eol)
+}
+
+// planSshdConfig returns the changes that make sshd call opkssh verify.
+// sshd uses the first AuthorizedKeysCommand it reads, so the directives go
+// in a drop-in file if sshd_config includes the drop-in directory before
+// setting them itself, and in sshd_config otherwise.
+func (c *InstallCmd) planSshdConfig() ([]sshdFileChange, error) {
+	content, err := afero.ReadFile(c.Fs, c.SshdConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sshd config: %w", err)
+	}
+	lines, eol := splitSshdConfig(content)
+	dropInDir := ""
+	for _, line := range lines {
+		keyword, value := parseSshdLine(line)
+		if keyword == "match" || keyword == sshdAuthKeysCommand || keyword == sshdAuthKeysUser {
+			break
+		}
+		if keyword == "include" {
+			for _, pattern := range splitSshdArgs(value) {
+				if pattern = sshdIncludePattern(pattern); filepath.Base(pattern) == "*.conf" {
+					dropInDir = filepath.Dir(pattern)
+				}
+			}
+			if dropInDir != "" {
+				break
+			}
+		}
+	}
+
+	var changes []sshdFileChange
+	if dropInDir != "" {
+		changes, err = c.planDropIn(dropInDir)
+	} else {
+		after := joinSshdConfig(c.editSshdConfig(lines), eol)
+		if !bytes.Equal(after, content) {
+			changes = []sshdFileChange{{Path: c.SshdConfigPath, Before: content, After: after}}
+		}
+	}
+	return changes, err
+}
+
+// setsAuthorizedKeysCommand returns true if content sets one of the
+// directives install writes
+func setsAuthorizedKeysCommand(content []byte) bool {
+	lines, _ := splitSshdConfig(content)
+	for _, line := range lines {
+		if keyword, _ := parseSshdLine(line); keyword == sshdAuthKeysCommand || keyword == sshdAuthKeysUser {
+			return true
+		}
+	}
+	return false
+}
+
+// planDropIn writes the directives to a file of dir read before any other
+// file setting them, and removes the files of earlier installs
+func (c *InstallCmd) planDropIn(dir string) ([]sshdFileChange, error) {
+	matches, err := afero.Glob(c.Fs, filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	name := sshdDefaultDropIn
+	for _, m := range matches {
+		content, err := afero.ReadFile(c.Fs, m)
+		if err != nil || !setsAuthorizedKeysCommand(content) {
+			continue
+		}
+		if base := filepath.Base(m); strings.HasSuffix(base, "-"+sshdDropInSuffix) {
+			name = base
+		} else {
+			// Files are read in lexical order, use a lower prefix
+			digits := base[:len(base)-len(strings.TrimLeft(base, "0123456789"))]
+			n, err := strconv.Atoi(digits)
+			if err != nil || n == 0 {
+				return nil, fmt.Errorf("%s sets AuthorizedKeysCommand and is read before any file opkssh can add, comment out its AuthorizedKeysCommand and AuthorizedKeysCommandUser", m)
+			}
+			name = fmt.Sprintf("%0*d-%s", len(digits), n-1, sshdDropInSuffix)
+		}
+		break
+	}
+
+	path := filepath.Join(dir, name)
+	var changes []sshdFileChange
+	after := joinSshdConfig(c.sshdDirectives(), "\n")
+	before, err := afero.ReadFile(c.Fs, path)
+	if err != nil {
+		before = nil
+	}
+	if !bytes.Equal(before, after) {
+		changes = append(changes, sshdFileChange{Path: path, Before: before, After: after})
+	}
+	for _, m := range matches {
+		if m != path && strings.HasSuffix(filepath.Base(m), "-"+sshdDropInSuffix) {
+			content, err := afero.ReadFile(c.Fs, m)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, sshdFileChange{Path: m, Before: content})
+		}
+	}
+	return changes, nil
+}
+
+// editSshdConfig returns lines with the directives of an earlier install
+// replaced, other directives outside of Match blocks commented out, and the
+// directives added before the first Match block
+func (c *InstallCmd) editSshdConfig(lines []string) []string {
+	var out []string
+	added, inMatch, inInstall := false, false, false
+	for _, line := range lines {
+		keyword, _ := parseSshdLine(line)
+		if keyword == "match" && !added {
+			out = append(out, c.sshdDirectives()...)
+			added, inMatch = true, true
+		}
+		isDirective := keyword == sshdAuthKeysCommand || keyword == sshdAuthKeysUser
+		switch {
+		case inMatch:
+		case strings.TrimSpace(line) == sshdInstallMarker:
+			inInstall = true
+			continue
+		case isDirective && inInstall:
+			continue
+		case isDirective:
+			line = sshdDisabledPrefix + line
+		}
+		inInstall = inInstall && isDirective
+		out = append(out, line)
+	}
+	if !added {
+		out = append(out, c.sshdDirectives()...)
+	}
+	return out
+}
+
+// applySshdConfig makes the changes and restores the previous files if
+// sshd -t rejects the new config
+func (c *InstallCmd) applySshdConfig(changes []sshdFileChange) error {
+	restore := func() {
+		for _, ch := range changes {
+			if ch.Before != nil {
+				_ = afero.WriteFile(c.Fs, ch.Path, ch.Before, 0o644)
+			} else {
+				_ = c.Fs.Remove(ch.Path)
+			}
+		}
+	}
+	for _, ch := range changes {
+		var err error
+		if ch.After != nil {
+			err = afero.WriteFile(c.Fs, ch.Path, ch.After, 0o644)
+		} else {
+			err = c.Fs.Remove(ch.Path)
+		}
+		if err != nil {
+			restore()
+			return fmt.Errorf("failed to update %s: %w", ch.Path, err)
+		}
+	}
+	if err := c.run("sshd", "-t", "-f", c.SshdConfigPath); err != nil {
+		restore()
+		return fmt.Errorf("sshd rejected the new config, the previous config has been restored: %w", err)
+	}
+	return nil
+}
+
+// reloadSshd makes sshd use the new config for new connections
+func (c *InstallCmd) reloadSshd() error {
+	if c.GOOS == "windows" {
+		if err := c.run("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "Restart-Service sshd -Force"); err != nil {
+			return fmt.Errorf("failed to restart sshd, restart it to apply the changes: %w", err)
+		}
+		fmt.Fprintln(c.Out, "Restarted sshd")
+		return nil
+	}
+	// The unit is ssh on Debian and Ubuntu and sshd elsewhere
+	var err error
+	for _, unit := range []string{"sshd", "ssh"} {
+		if err = c.run("systemctl", "reload", unit); err == nil {
+			fmt.Fprintf(c.Out, "Reloaded %s\n", unit)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to reload sshd, reload it to apply the changes: %w", err)
+}
+
+// uninstallAction is a change made by Uninstall
+type uninstallAction struct {
+	Desc string
+	Run  func() error
+}
+
+// Uninstall removes the service, the sshd directives and the
+// AuthorizedKeysCommandUser, and with Purge the configuration and state
+// directories. It prints each change it makes, or would make with DryRun.
+func (c *InstallCmd) Uninstall() error {
+	if c.GOOS != "linux" && c.GOOS != "windows" {
+		return fmt.Errorf("opkssh uninstall supports Linux and Windows, use the uninstall scripts on %s", c.GOOS)
+	}
+	actions, err := c.planUninstall()
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		for _, a := range actions {
+			fmt.Fprintln(c.Out, "Action:", a.Desc)
+		}
+		fmt.Fprintln(c.Out, "dry-run complete")
+		return nil
+	}
+	elevated, err := c.IsElevatedFn()
+	if err != nil {
+		return fmt.Errorf("failed to determine elevation: %w", err)
+	}
+	if !elevated {
+		return fmt.Errorf("uninstall requires elevated privileges (run as root or Administrator)")
+	}
+	if len(actions) == 0 {
+		fmt.Fprintln(c.Out, "opkssh is not installed")
+		return nil
+	}
+	for _, a := range actions {
+		if err := a.Run(); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.Out, a.Desc)
+	}
+	return nil
+}
+
+// planUninstall returns the changes Uninstall makes, in order: sshd stops
+// calling opkssh before the service and the user it needs are removed
+func (c *InstallCmd) planUninstall() ([]uninstallAction, error) {
+	var actions []uninstallAction
+	changes, err := c.planSshdRestore()
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		var descs []string
+		for _, ch := range changes {
+			descs = append(descs, ch.String())
+		}
+		actions = append(actions, uninstallAction{
+			Desc: strings.Join(descs, ", "),
+			Run:  func() error { return c.applySshdConfig(changes) },
+		})
+		if !c.NoSshdReload {
+			actions = append(actions, uninstallAction{Desc: "Reloaded sshd", Run: c.reloadSshd})
+		}
+	}
+
+	if c.Service != nil && c.Service.installed() {
+		actions = append(actions, uninstallAction{Desc: "Removed service " + c.Service.name(), Run: c.Service.Uninstall})
+	}
+
+	if _, err := c.UserLookup.Lookup(c.User); err == nil {
+		actions = append(actions, uninstallAction{Desc: "Removed user " + c.User, Run: c.removeUser})
+	}
+
+	if c.Purge {
+		for _, dir := range []string{policy.GetSystemConfigBasePath(), policy.GetSystemStateBasePath()} {
+			if _, err := c.Fs.Stat(dir); err == nil {
+				actions = append(actions, uninstallAction{Desc: "Deleted " + dir, Run: func() error { return c.Fs.RemoveAll(dir) }})
+			}
+		}
+	}
+
+	if c.GOOS == "windows" && c.RemoveEventSource != nil {
+		actions = append(actions, uninstallAction{Desc: "Removed event log source " + eventlog.Source, Run: c.RemoveEventSource})
+	}
+	return actions, nil
+}
+
+// removeUser deletes the AuthorizedKeysCommandUser, and on Linux its group
+func (c *InstallCmd) removeUser() error {
+	if c.GOOS == "windows" {
+		if err := c.run("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", "Remove-LocalUser -Name "+powerShellQuote(c.User)); err != nil {
+			return fmt.Errorf("failed to remove user %s: %w", c.User, err)
+		}
+		return nil
+	}
+	if err := c.run("userdel", c.User); err != nil {
+		return fmt.Errorf("failed to remove user %s: %w", c.User, err)
+	}
+	// userdel already removes the group on most distributions
+	if _, err := c.CmdRunner("getent", "group", c.User); err == nil {
+		if err := c.run("groupdel", c.User); err != nil {
+			return fmt.Errorf("failed to remove group %s: %w", c.User, err)
+		}
+	}
+	return nil
+}
+
+// planSshdRestore returns the changes that undo install: the drop-in files
+// it wrote are removed, and in sshd_config its directives are removed and
+// the directives it disabled are restored
+func (c *InstallCmd) planSshdRestore() ([]sshdFileChange, error) {
+	content, err := afero.ReadFile(c.Fs, c.SshdConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sshd config: %w", err)
+	}
+	lines, eol := splitSshdConfig(content)
+	var changes []sshdFileChange
+	var out []string
+	inInstall := false
+	for _, line := range lines {
+		keyword, value := parseSshdLine(line)
+		isDirective := keyword == sshdAuthKeysCommand || keyword == sshdAuthKeysUser
+		switch {
+		case strings.TrimSpace(line) == sshdInstallMarker:
+			inInstall = true
+			continue
+		case isDirective && inInstall:
+			continue
+		case strings.HasPrefix(line, sshdDisabledPrefix):
+			line = strings.TrimPrefix(line, sshdDisabledPrefix)
+		case keyword == "include":
+			for _, pattern := range splitSshdArgs(value) {
+				matches, err := afero.Glob(c.Fs, sshdIncludePattern(pattern))
+				if err != nil {
+					return nil, err
+				}
+				for _, m := range matches {
+					if !strings.HasSuffix(filepath.Base(m), "-"+sshdDropInSuffix) {
+						continue
+					}
+					dropIn, err := afero.ReadFile(c.Fs, m)
+					if err == nil && bytes.HasPrefix(dropIn, []byte(sshdInstallMarker)) {
+						changes = append(changes, sshdFileChange{Path: m, Before: dropIn})
+					}
+				}
+			}
+		}
+		inInstall = false
+		out = append(out, line)
+	}
+	if after := joinSshdConfig(out, eol); !bytes.Equal(after, content) {
+		changes = append(changes, sshdFileChange{Path: c.SshdConfigPath, Before: content, After: after})
+	}
+	return changes, nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/user"
+	"strings"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestInstallCmd returns an InstallCmd for a Linux server whose sshd
+// config is sshdConfig, recording the commands it runs in ran
+func newTestInstallCmd(t *testing.T, sshdConfig string, ran *[]string) (*InstallCmd, afero.Fs, *bytes.Buffer) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte(sshdConfig), 0o644))
+	out := &bytes.Buffer{}
+	permissions := newTestPermissionsCmd(fs, out)
+	permissions.FileSystem = &mockFileSystem{fs: fs}
+	return &InstallCmd{
+		Fs:             fs,
+		GOOS:           "linux",
+		Executable:     "/usr/local/bin/opkssh",
+		SshdConfigPath: "/etc/ssh/sshd_config",
+		User:           policy.Defaults.User,
+		UserLookup:     testUserLookup{},
+		Permissions:    permissions,
+		IsElevatedFn:   func() (bool, error) { return true, nil },
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			*ran = append(*ran, strings.Join(append([]string{name}, arg...), " "))
+			if name == "getent" {
+				return nil, errors.New("exit status 2")
+			}
+			return nil, nil
+		},
+		Out: out,
+	}, fs, out
+}
+
+func TestInstall(t *testing.T) {
+	var ran []string
+	c, fs, out := newTestInstallCmd(t, "Port 22\nAuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\nMatch Group admins\n  AuthorizedKeysCommand /bin/other\n", &ran)
+	require.NoError(t, c.Run(), out.String())
+	require.Equal(t, []string{
+		"getent group opksshuser",
+		"groupadd --system opksshuser",
+		"useradd -r -M -s /sbin/nologin -g opksshuser opksshuser",
+		"sshd -t -f /etc/ssh/sshd_config",
+		"systemctl reload sshd",
+	}, ran)
+
+	info, err := fs.Stat(policy.GetSystemConfigBasePath())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o750), info.Mode().Perm())
+	_, err = fs.Stat(policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+
+	want := "Port 22\n" +
+		"# Disabled by opkssh install: AuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\n" +
+		"# Added by opkssh install\n" +
+		"AuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\n" +
+		"AuthorizedKeysCommandUser opksshuser\n" +
+		"Match Group admins\n" +
+		"  AuthorizedKeysCommand /bin/other\n"
+	config, err := afero.ReadFile(fs, "/etc/ssh/sshd_config")
+	require.NoError(t, err)
+	require.Equal(t, want, string(config))
+
+	// Installing again changes nothing
+	ran = nil
+	c.UserLookup = testUserLookup{"opksshuser": &user.User{Username: "opksshuser"}}
+	out.Reset()
+	require.NoError(t, c.Run())
+	require.Contains(t, out.String(), "sshd already calls /usr/local/bin/opkssh verify")
+	require.Empty(t, ran)
+
+	// A moved binary replaces the directives of the earlier install
+	c.Executable = "/opt/opkssh/opkssh"
+	require.NoError(t, c.Run())
+	config, err = afero.ReadFile(fs, "/etc/ssh/sshd_config")
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(want, "/usr/local/bin/opkssh", "/opt/opkssh/opkssh", 1), string(config))
+
+	// Windows creates a local user and restarts the sshd service
+	ran = nil
+	c.GOOS = "windows"
+	c.UserLookup = testUserLookup{}
+	c.Executable = `C:\Program Files\opk\opkssh.exe`
+	require.NoError(t, c.Run())
+	require.True(t, strings.HasPrefix(ran[0], "powershell.exe -NoProfile -NonInteractive -Command "), ran[0])
+	require.Contains(t, ran[0], "New-LocalUser -Name 'opksshuser' -Password $password ")
+	require.NotContains(t, ran[0], "-NoPassword")
+	require.Contains(t, ran[0], "SeDenyInteractiveLogonRight")
+	require.Contains(t, ran[0], "SeDenyRemoteInteractiveLogonRight")
+	require.Equal(t, "powershell.exe -NoProfile -NonInteractive -Command Restart-Service sshd -Force", ran[len(ran)-1])
+	config, err = afero.ReadFile(fs, "/etc/ssh/sshd_config")
+	require.NoError(t, err)
+	require.Contains(t, string(config), `AuthorizedKeysCommand "C:\Program Files\opk\opkssh.exe" verify %u %k %t`)
+}
+
+func TestInstallDropIn(t *testing.T) {
+	var ran []string
+	c, fs, out := newTestInstallCmd(t, "Include /etc/ssh/sshd_config.d/*.conf\nAuthorizedKeysCommand /bin/ignored\n", &ran)
+	c.UserLookup = testUserLookup{"opksshuser": &user.User{Username: "opksshuser"}}
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/50-cloud-init.conf", []byte("PasswordAuthentication no\n"), 0o644))
+	require.NoError(t, c.Run())
+	config, err := afero.ReadFile(fs, "/etc/ssh/sshd_config.d/60-opk-ssh.conf")
+	require.NoError(t, err)
+	require.Equal(t, "# Added by opkssh install\nAuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\nAuthorizedKeysCommandUser opksshuser\n", string(config))
+
+	// A file read earlier that sets the directives needs a lower prefix
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/20-sssd.conf", []byte("AuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\n"), 0o644))
+	require.NoError(t, c.Run())
+	_, err = fs.Stat("/etc/ssh/sshd_config.d/19-opk-ssh.conf")
+	require.NoError(t, err)
+	_, err = fs.Stat("/etc/ssh/sshd_config.d/60-opk-ssh.conf")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.Contains(t, out.String(), "Removed /etc/ssh/sshd_config.d/60-opk-ssh.conf")
+
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/00-first.conf", []byte("AuthorizedKeysCommandUser nobody\n"), 0o644))
+	_, err = c.planSshdConfig()
+	require.ErrorContains(t, err, "00-first.conf sets AuthorizedKeysCommand")
+}
+
+func TestInstallRestoresRejectedConfig(t *testing.T) {
+	var ran []string
+	original := "Port 22\r\nAuthorizedKeysCommand /bin/old\r\n"
+	c, fs, out := newTestInstallCmd(t, original, &ran)
+	c.UserLookup = testUserLookup{"opksshuser": &user.User{Username: "opksshuser"}}
+	c.CmdRunner = func(name string, arg ...string) ([]byte, error) {
+		if name == "sshd" {
+			return []byte("Bad configuration option"), errors.New("exit status 255")
+		}
+		return nil, nil
+	}
+	require.ErrorContains(t, c.Run(), "sshd rejected the new config, the previous config has been restored")
+	config, err := afero.ReadFile(fs, "/etc/ssh/sshd_config")
+	require.NoError(t, err)
+	require.Equal(t, original, string(config))
+	require.NotContains(t, out.String(), "Updated")
+
+	// Line endings are kept
+	changes, err := c.planSshdConfig()
+	require.NoError(t, err)
+	require.Contains(t, string(changes[0].After), "AuthorizedKeysCommandUser opksshuser\r\n")
+
+	c.IsElevatedFn = func() (bool, error) { return false, nil }
+	require.ErrorContains(t, c.Run(), "requires elevated privileges")
+	c.GOOS = "darwin"
+	require.ErrorContains(t, c.Run(), "supports Linux and Windows")
+}
+
+func TestUninstall(t *testing.T) {
+	var ran []string
+	original := "Include /etc/ssh/sshd_config.d/*.conf\nAuthorizedKeysCommand /usr/bin/sss_ssh_authorizedkeys %u\nMatch Group admins\n  PasswordAuthentication no\n"
+	c, fs, out := newTestInstallCmd(t, original, &ran)
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config.d/20-sssd.conf", []byte("AuthorizedKeysCommandUser nobody\n"), 0o644))
+	require.NoError(t, c.Run())
+	c.UserLookup = testUserLookup{"opksshuser": &user.User{Username: "opksshuser"}}
+
+	// An install into sshd_config too, as if the Include had been added later
+	require.NoError(t, afero.WriteFile(fs, "/etc/ssh/sshd_config", []byte(strings.Replace(original, "Match",
+		"# Disabled by opkssh install: AuthorizedKeysCommandUser nobody\n# Added by opkssh install\nAuthorizedKeysCommand /usr/local/bin/opkssh verify %u %k %t\nAuthorizedKeysCommandUser opksshuser\nMatch", 1)), 0o644))
+
+	c.Service = &ServiceCmd{
+		Fs:         fs,
+		GOOS:       "linux",
+		Executable: "/usr/local/bin/opkssh",
+		SocketPath: DefaultServeSocketPath(),
+		CmdRunner:  c.CmdRunner,
+		Out:        &bytes.Buffer{},
+	}
+	require.NoError(t, c.Service.Install())
+	c.Purge = true
+	c.DryRun = true
+	ran = nil
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, "Action: Removed /etc/ssh/sshd_config.d/19-opk-ssh.conf, Updated /etc/ssh/sshd_config\n"+
+		"Action: Reloaded sshd\n"+
+		"Action: Removed service opkssh-verify\n"+
+		"Action: Removed user opksshuser\n"+
+		"Action: Deleted "+policy.GetSystemConfigBasePath()+"\n"+
+		"Action: Deleted "+policy.GetSystemStateBasePath()+"\n"+
+		"dry-run complete\n", out.String())
+	require.Empty(t, ran)
+
+	c.DryRun = false
+	require.NoError(t, c.Uninstall())
+	config, err := afero.ReadFile(fs, "/etc/ssh/sshd_config")
+	require.NoError(t, err)
+	require.Equal(t, strings.Replace(original, "Match", "AuthorizedKeysCommandUser nobody\nMatch", 1), string(config))
+	for _, path := range []string{"/etc/ssh/sshd_config.d/19-opk-ssh.conf", "/etc/systemd/system/opkssh-verify.socket", policy.GetSystemConfigBasePath()} {
+		_, err = fs.Stat(path)
+		require.ErrorIs(t, err, os.ErrNotExist, path)
+	}
+	_, err = fs.Stat("/etc/ssh/sshd_config.d/20-sssd.conf")
+	require.NoError(t, err)
+	require.Contains(t, ran, "sshd -t -f /etc/ssh/sshd_config")
+	require.Contains(t, ran, "userdel opksshuser")
+
+	c.UserLookup = testUserLookup{}
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, "opkssh is not installed\n", out.String())
+}
@@ -0,0 +1,91 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/sshcert"
+)
+
+// IssuerFilter is the global allow list and deny list of issuers of the
+// server config. Issuers are compared without a trailing slash.
+type IssuerFilter struct {
+	allow []string
+	deny  []string
+}
+
+// NewIssuerFilter returns the filter of cfg
+func NewIssuerFilter(cfg config.IssuersConfig) IssuerFilter {
+	f := IssuerFilter{}
+	for _, issuer := range cfg.Allow {
+		f.allow = append(f.allow, normalizeIssuer(issuer))
+	}
+	for _, issuer := range cfg.Deny {
+		f.deny = append(f.deny, normalizeIssuer(issuer))
+	}
+	return f
+}
+
+func normalizeIssuer(issuer string) string {
+	return strings.TrimSuffix(strings.TrimSpace(issuer), "/")
+}
+
+// IsEmpty returns true if the filter accepts every issuer
+func (f IssuerFilter) IsEmpty() bool {
+	return len(f.allow) == 0 && len(f.deny) == 0
+}
+
+// Check returns an error if tokens of issuer are refused
+func (f IssuerFilter) Check(issuer string) error {
+	normalized := normalizeIssuer(issuer)
+	if slices.Contains(f.deny, normalized) {
+		return fmt.Errorf("issuer %s is denied by the server config", issuer)
+	}
+	if len(f.allow) > 0 && !slices.Contains(f.allow, normalized) {
+		return fmt.Errorf("issuer %s is not in the issuers allowed by the server config", issuer)
+	}
+	return nil
+}
+
+// checkIssuer refuses the certificate if the issuer of its PK Token is
+// refused by v.Issuers. It runs before the PK Token is verified, so that
+// the provider of a denied issuer is not contacted.
+func (v *VerifyCmd) checkIssuer(cert *sshcert.SshCertSmuggler) error {
+	if v.Issuers.IsEmpty() {
+		return nil
+	}
+	pkt, err := cert.GetPKToken()
+	if err != nil {
+		return deny(DenyCodeInvalidToken, err)
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(pkt.Payload, &claims); err != nil {
+		return deny(DenyCodeInvalidToken, fmt.Errorf("failed to read the ID Token claims: %w", err))
+	}
+	if err := v.Issuers.Check(claims.Issuer); err != nil {
+		return deny(DenyCodeIssuer, err)
+	}
+	v.trace("The issuer %s is allowed by the server config", claims.Issuer)
+	return nil
+}
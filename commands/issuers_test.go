@@ -0,0 +1,65 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuerFilter(t *testing.T) {
+	f := NewIssuerFilter(config.IssuersConfig{
+		Allow: []string{"https://accounts.google.com", "https://login.example.com/"},
+		Deny:  []string{"https://login.example.com"},
+	})
+	require.NoError(t, f.Check("https://accounts.google.com"))
+	require.NoError(t, f.Check("https://accounts.google.com/"))
+	require.EqualError(t, f.Check("https://login.example.com/"), "issuer https://login.example.com/ is denied by the server config")
+	require.EqualError(t, f.Check("https://other.example.com"), "issuer https://other.example.com is not in the issuers allowed by the server config")
+
+	// Without an allow list only the denied issuers are refused
+	f = NewIssuerFilter(config.IssuersConfig{Deny: []string{"https://login.example.com"}})
+	require.NoError(t, f.Check("https://other.example.com"))
+	require.True(t, NewIssuerFilter(config.IssuersConfig{}).IsEmpty())
+}
+
+func TestAuthorizedKeysCommandIssuers(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	serverConfig := &config.ServerConfig{Issuers: config.IssuersConfig{Deny: []string{"https://accounts.example.com"}}}
+	load := s.Load
+	s.Load = func() (*ServeState, error) {
+		state, err := load()
+		if err == nil {
+			state.ServerConfig = serverConfig
+		}
+		return state, err
+	}
+	require.NoError(t, s.Reload())
+
+	_, err := s.Verify(context.Background(), req)
+	require.EqualError(t, err, "issuer https://accounts.example.com is denied by the server config")
+	require.Equal(t, DenyCodeIssuer, NewDenyReason(req.Principal, err).Code)
+
+	// A reload takes effect for the next login
+	serverConfig.Issuers = config.IssuersConfig{Allow: []string{"https://accounts.example.com/"}}
+	require.NoError(t, s.Reload())
+	_, err = s.Verify(context.Background(), req)
+	require.NoError(t, err)
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// JWKSCmd fills the JWKS cache of verify with the OpenID configuration and
+// keys of every provider, so that the first logins don't wait for them
+type JWKSCmd struct {
+	Fs    afero.Fs
+	Out   io.Writer
+	Cache *policy.JWKSCache
+
+	// Args
+	ProvidersPath string
+}
+
+// NewJWKSCmd returns a JWKSCmd for the system providers and JWKS cache
+func NewJWKSCmd(rt *Runtime) *JWKSCmd {
+	cache := policy.NewJWKSCache()
+	cache.Fs = rt.Fs
+	return &JWKSCmd{
+		Fs:            rt.Fs,
+		Out:           rt.Out,
+		Cache:         cache,
+		ProvidersPath: policy.Defaults.ProvidersPath,
+	}
+}
+
+// Import stores the JWKS in the file at path as the keys of issuer, for
+// servers that can't reach the provider
+func (j *JWKSCmd) Import(issuer string, path string) error {
+	if !j.Cache.Enabled() {
+		return fmt.Errorf("the JWKS cache %s does not exist, create it with opkssh permissions fix", j.Cache.Dir)
+	}
+	providerPolicy, err := j.loadProviders()
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(providerPolicy.GetRows(), func(row policy.ProvidersRow) bool { return row.Issuer == issuer }) {
+		return fmt.Errorf("issuer %s is not in the providers file", issuer)
+	}
+	jwks, err := afero.ReadFile(j.Fs, path)
+	if err != nil {
+		return err
+	}
+	if err := j.Cache.Import(issuer, jwks); err != nil {
+		return fmt.Errorf("failed to import %s: %w", path, err)
+	}
+	fmt.Fprintf(j.Out, "Imported the keys of %s, verify uses them while the provider can't be reached for up to jwks_cache max_stale (default %s)\n", issuer, policy.DefaultJWKSMaxStale)
+	return nil
+}
+
+func (j *JWKSCmd) loadProviders() (*policy.ProviderPolicy, error) {
+	loader := &policy.ProvidersFileLoader{FileLoader: files.FileLoader{Fs: j.Fs, RequiredPerm: files.ModeSystemPerms}}
+	providerPolicy, err := loader.LoadProviderPolicy(j.ProvidersPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load providers (%s): %w", j.ProvidersPath, err)
+	}
+	return providerPolicy, nil
+}
+
+// Fetch fetches the keys of every provider, from the cache unless they have
+// expired or Cache.Refresh is set
+func (j *JWKSCmd) Fetch(ctx context.Context) error {
+	if !j.Cache.Enabled() {
+		return fmt.Errorf("the JWKS cache %s does not exist, create it with opkssh permissions fix", j.Cache.Dir)
+	}
+	providerPolicy, err := j.loadProviders()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, row := range providerPolicy.GetRows() {
+		if err := j.Cache.Fetch(ctx, row); err != nil {
+			fmt.Fprintf(j.Out, "%s: %v\n", row.Issuer, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(j.Out, "%s: ok\n", row.Issuer)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to fetch the keys of %d providers", failed)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSFetch(t *testing.T) {
+	fetched := map[string]int{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched[r.URL.Path]++
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+		case "/keys":
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte(server.URL+" client 24h\n"), 0o640))
+	out := &bytes.Buffer{}
+	j := &JWKSCmd{
+		Fs:            fs,
+		Out:           out,
+		Cache:         &policy.JWKSCache{Fs: fs, Dir: "/var/lib/opk/jwks-cache", Now: time.Now},
+		ProvidersPath: "/etc/opk/providers",
+	}
+	require.ErrorContains(t, j.Fetch(context.Background()), "create it with opkssh permissions fix")
+
+	require.NoError(t, fs.MkdirAll(j.Cache.Dir, 0o700))
+	require.NoError(t, j.Fetch(context.Background()))
+	require.Equal(t, server.URL+": ok\n", out.String())
+	entries, err := afero.ReadDir(fs, j.Cache.Dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	// The second fetch is answered by the cache, unless refreshed
+	require.NoError(t, j.Fetch(context.Background()))
+	require.Equal(t, 1, fetched["/.well-known/openid-configuration"])
+	j.Cache.Refresh = true
+	require.NoError(t, j.Fetch(context.Background()))
+	require.Equal(t, 2, fetched["/.well-known/openid-configuration"])
+}
+
+func TestJWKSImport(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte("https://accounts.google.com client 24h\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, "/tmp/google.jwks", []byte(`{"keys":[{"kty":"oct","k":"c2VjcmV0","kid":"1"}]}`), 0o644))
+	require.NoError(t, fs.MkdirAll("/var/lib/opk/jwks-cache", 0o700))
+	out := &bytes.Buffer{}
+	j := &JWKSCmd{
+		Fs:            fs,
+		Out:           out,
+		Cache:         &policy.JWKSCache{Fs: fs, Dir: "/var/lib/opk/jwks-cache", Now: time.Now},
+		ProvidersPath: "/etc/opk/providers",
+	}
+
+	require.ErrorContains(t, j.Import("https://gitlab.com", "/tmp/google.jwks"), "issuer https://gitlab.com is not in the providers file")
+	require.ErrorContains(t, j.Import("https://accounts.google.com", "/tmp/missing.jwks"), "file does not exist")
+	require.NoError(t, j.Import("https://accounts.google.com", "/tmp/google.jwks"))
+	require.Contains(t, out.String(), "Imported the keys of https://accounts.google.com")
+	entries, err := afero.ReadDir(fs, j.Cache.Dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func TestApplyServerConfigJWKSCache(t *testing.T) {
+	cache := &policy.JWKSCache{MaxStale: policy.DefaultJWKSMaxStale}
+	v := &VerifyCmd{ProviderPolicy: &policy.ProviderPolicy{JWKSCache: cache}}
+	v.ApplyServerConfig(&config.ServerConfig{JWKSCache: config.JWKSCacheConfig{MaxStale: "720h"}})
+	require.Equal(t, 720*time.Hour, cache.MaxStale)
+	v.ApplyServerConfig(&config.ServerConfig{JWKSCache: config.JWKSCacheConfig{MaxStale: "a month"}})
+	require.Equal(t, 720*time.Hour, cache.MaxStale)
+}
@@ -0,0 +1,83 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"crypto"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/thediveo/enumflag/v2"
+)
+
+// KeyBackend is where login generates and holds the user's key pair
+type KeyBackend enumflag.Flag
+
+const (
+	SoftwareKey KeyBackend = iota // In memory, the private key is written to ~/.ssh
+	TPMKey                        // In the TPM, with the Platform Crypto Provider on Windows and tpm2-tools on Linux
+)
+
+func (b KeyBackend) String() string {
+	switch b {
+	case SoftwareKey:
+		return "software"
+	case TPMKey:
+		return "tpm"
+	default:
+		return "unknown"
+	}
+}
+
+// hardwareKey is a key pair held by a security device. Its private key can't
+// be written to disk or added to ssh-agent, so login serves it to ssh itself.
+type hardwareKey interface {
+	crypto.Signer
+	// Close deletes the key from the device
+	Close() error
+}
+
+// newKeyPair generates a key pair for alg with backend
+func newKeyPair(backend KeyBackend, alg jwa.SignatureAlgorithm) (crypto.Signer, error) {
+	switch backend {
+	case SoftwareKey:
+		return util.GenKeyPair(alg)
+	case TPMKey:
+		if alg != jwa.ES256 {
+			return nil, fmt.Errorf("the tpm key backend only supports %s keys, use -t %s", ECDSA, ECDSA)
+		}
+		return newTPMKey()
+	default:
+		return nil, fmt.Errorf("unknown key backend (%s)", backend)
+	}
+}
+
+// ecdsaSignatureToASN1 converts an ECDSA signature of r and s, each in
+// half of raw, to the ASN.1 form returned by crypto.Signer
+func ecdsaSignatureToASN1(raw []byte) ([]byte, error) {
+	if len(raw) == 0 || len(raw)%2 != 0 {
+		return nil, fmt.Errorf("invalid ECDSA signature of %d bytes", len(raw))
+	}
+	half := len(raw) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(raw[:half]),
+		S: new(big.Int).SetBytes(raw[half:]),
+	})
+}
@@ -0,0 +1,147 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// tpmKey is an ECDSA P-256 key created in the TPM with tpm2-tools. The
+// private key never leaves the TPM, it is only stored in dir wrapped by the
+// storage key of the TPM.
+type tpmKey struct {
+	dir string
+	pub *ecdsa.PublicKey
+	run func(name string, args ...string) error
+
+	mu sync.Mutex // tpm2_sign writes its signature to a file in dir
+}
+
+func newTPMKey() (hardwareKey, error) {
+	return createTPMKey(runTPMTool)
+}
+
+// runTPMTool runs a command of tpm2-tools
+func runTPMTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%s not found, install tpm2-tools to use the tpm key backend", name)
+	} else if err != nil {
+		return fmt.Errorf("%s failed: %w: %s", name, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func createTPMKey(run func(name string, args ...string) error) (*tpmKey, error) {
+	dir, err := os.MkdirTemp("", "opkssh-tpm")
+	if err != nil {
+		return nil, err
+	}
+	k := &tpmKey{dir: dir, run: run}
+	for _, cmd := range [][]string{
+		{"tpm2_createprimary", "-C", "o", "-G", "ecc256", "-c", k.path("primary.ctx")},
+		{"tpm2_create", "-C", k.path("primary.ctx"), "-G", "ecc256:ecdsa-sha256",
+			"-a", "fixedtpm|fixedparent|sensitivedataorigin|userwithauth|sign",
+			"-u", k.path("key.pub"), "-r", k.path("key.priv")},
+		{"tpm2_load", "-C", k.path("primary.ctx"), "-u", k.path("key.pub"), "-r", k.path("key.priv"), "-c", k.path("key.ctx")},
+		{"tpm2_readpublic", "-c", k.path("key.ctx"), "-f", "pem", "-o", k.path("key.pem")},
+	} {
+		if err := run(cmd[0], cmd[1:]...); err != nil {
+			k.Close()
+			return nil, err
+		}
+	}
+
+	pemBytes, err := os.ReadFile(k.path("key.pem"))
+	if err != nil {
+		k.Close()
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		k.Close()
+		return nil, fmt.Errorf("tpm2_readpublic did not write a PEM public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		k.Close()
+		return nil, fmt.Errorf("failed to parse the TPM public key: %w", err)
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		k.Close()
+		return nil, fmt.Errorf("the TPM key is a %T, not an ECDSA key", pub)
+	}
+	k.pub = ecPub
+	return k, nil
+}
+
+func (k *tpmKey) path(name string) string {
+	return filepath.Join(k.dir, name)
+}
+
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign signs the SHA-256 digest in the TPM
+func (k *tpmKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, fmt.Errorf("the TPM key only signs SHA-256 digests")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := os.WriteFile(k.path("digest"), digest, 0o600); err != nil {
+		return nil, err
+	}
+	if err := k.run("tpm2_sign", "-c", k.path("key.ctx"), "-g", "sha256", "-d", "-f", "plain", "-o", k.path("sig"), k.path("digest")); err != nil {
+		return nil, err
+	}
+	sig, err := os.ReadFile(k.path("sig"))
+	if err != nil {
+		return nil, err
+	}
+	// Accept the ASN.1 form of openssl as well as r and s concatenated
+	if !ecdsa.VerifyASN1(k.pub, digest, sig) {
+		if sig, err = ecdsaSignatureToASN1(sig); err != nil {
+			return nil, err
+		}
+		if !ecdsa.VerifyASN1(k.pub, digest, sig) {
+			return nil, fmt.Errorf("tpm2_sign returned an invalid signature")
+		}
+	}
+	return sig, nil
+}
+
+// Close removes the key, the TPM can't use it without the files in dir
+func (k *tpmKey) Close() error {
+	return os.RemoveAll(k.dir)
+}
@@ -0,0 +1,101 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTPMTools runs tpm2-tools commands with a software key
+func fakeTPMTools(t *testing.T, key *ecdsa.PrivateKey, plain bool) func(name string, args ...string) error {
+	return func(name string, args ...string) error {
+		flag := func(f string) string {
+			for i, a := range args {
+				if a == f && i+1 < len(args) {
+					return args[i+1]
+				}
+			}
+			t.Fatalf("%s called without %s", name, f)
+			return ""
+		}
+		switch name {
+		case "tpm2_createprimary", "tpm2_load":
+			return os.WriteFile(flag("-c"), []byte("context"), 0o600)
+		case "tpm2_create":
+			require.Contains(t, args, "ecc256:ecdsa-sha256")
+			return os.WriteFile(flag("-r"), []byte("wrapped"), 0o600)
+		case "tpm2_readpublic":
+			der, err := x509.MarshalPKIXPublicKey(key.Public())
+			require.NoError(t, err)
+			return os.WriteFile(flag("-o"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600)
+		case "tpm2_sign":
+			require.Contains(t, args, "-d")
+			digest, err := os.ReadFile(args[len(args)-1])
+			require.NoError(t, err)
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+			require.NoError(t, err)
+			if plain {
+				r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+				require.NoError(t, err)
+				sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+			}
+			return os.WriteFile(flag("-o"), sig, 0o600)
+		}
+		return fmt.Errorf("unexpected command %s", name)
+	}
+}
+
+func TestTPMKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte("data"))
+
+	for _, plain := range []bool{true, false} {
+		k, err := createTPMKey(fakeTPMTools(t, key, plain))
+		require.NoError(t, err)
+		require.True(t, key.PublicKey.Equal(k.Public()))
+
+		sig, err := k.Sign(rand.Reader, digest[:], crypto.SHA256)
+		require.NoError(t, err)
+		require.True(t, ecdsa.VerifyASN1(&key.PublicKey, digest[:], sig))
+
+		_, err = k.Sign(rand.Reader, digest[:], crypto.SHA384)
+		require.ErrorContains(t, err, "only signs SHA-256 digests")
+
+		require.NoError(t, k.Close())
+		require.NoDirExists(t, k.dir)
+	}
+
+	_, err = createTPMKey(func(name string, args ...string) error {
+		return fmt.Errorf("%s failed: no TPM", name)
+	})
+	require.ErrorContains(t, err, "tpm2_createprimary failed: no TPM")
+}
@@ -0,0 +1,34 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeyPair(t *testing.T) {
+	signer, err := newKeyPair(SoftwareKey, jwa.ES256)
+	require.NoError(t, err)
+	require.IsType(t, &ecdsa.PrivateKey{}, signer)
+
+	_, err = newKeyPair(TPMKey, jwa.EdDSA)
+	require.ErrorContains(t, err, "the tpm key backend only supports ecdsa keys")
+}
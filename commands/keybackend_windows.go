@@ -0,0 +1,169 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modncrypt                     = windows.NewLazySystemDLL("ncrypt.dll")
+	procNCryptOpenStorageProvider = modncrypt.NewProc("NCryptOpenStorageProvider")
+	procNCryptCreatePersistedKey  = modncrypt.NewProc("NCryptCreatePersistedKey")
+	procNCryptFinalizeKey         = modncrypt.NewProc("NCryptFinalizeKey")
+	procNCryptExportKey           = modncrypt.NewProc("NCryptExportKey")
+	procNCryptSignHash            = modncrypt.NewProc("NCryptSignHash")
+	procNCryptDeleteKey           = modncrypt.NewProc("NCryptDeleteKey")
+	procNCryptFreeObject          = modncrypt.NewProc("NCryptFreeObject")
+)
+
+const (
+	msPlatformCryptoProvider = "Microsoft Platform Crypto Provider"
+	bcryptECDSAP256Magic     = 0x31534345 // BCRYPT_ECDSA_PUBLIC_P256_MAGIC
+)
+
+// tpmKey is an ECDSA P-256 key created in the TPM by the Platform Crypto
+// Provider. The private key never leaves the TPM.
+type tpmKey struct {
+	mu       sync.Mutex
+	provider uintptr
+	key      uintptr
+	pub      *ecdsa.PublicKey
+}
+
+// ncrypt calls an NCrypt function, which returns a SECURITY_STATUS
+func ncrypt(proc *windows.LazyProc, args ...uintptr) error {
+	if err := proc.Find(); err != nil {
+		return err
+	}
+	if status, _, _ := proc.Call(args...); status != 0 {
+		return fmt.Errorf("%s failed: %w", proc.Name, windows.Errno(status))
+	}
+	return nil
+}
+
+func newTPMKey() (hardwareKey, error) {
+	k := &tpmKey{}
+	providerName, err := windows.UTF16PtrFromString(msPlatformCryptoProvider)
+	if err != nil {
+		return nil, err
+	}
+	if err := ncrypt(procNCryptOpenStorageProvider, uintptr(unsafe.Pointer(&k.provider)), uintptr(unsafe.Pointer(providerName)), 0); err != nil {
+		return nil, fmt.Errorf("failed to open the TPM, check that it is enabled: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		k.Close()
+		return nil, err
+	}
+	alg, _ := windows.UTF16PtrFromString("ECDSA_P256")
+	keyName, _ := windows.UTF16PtrFromString("opkssh-" + hex.EncodeToString(suffix))
+	if err := ncrypt(procNCryptCreatePersistedKey, k.provider, uintptr(unsafe.Pointer(&k.key)), uintptr(unsafe.Pointer(alg)), uintptr(unsafe.Pointer(keyName)), 0, 0); err != nil {
+		k.Close()
+		return nil, err
+	}
+	if err := ncrypt(procNCryptFinalizeKey, k.key, 0); err != nil {
+		k.Close()
+		return nil, err
+	}
+
+	blobType, _ := windows.UTF16PtrFromString("ECCPUBLICBLOB")
+	var size uint32
+	if err := ncrypt(procNCryptExportKey, k.key, 0, uintptr(unsafe.Pointer(blobType)), 0, 0, 0, uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		k.Close()
+		return nil, err
+	}
+	blob := make([]byte, size)
+	if err := ncrypt(procNCryptExportKey, k.key, 0, uintptr(unsafe.Pointer(blobType)), 0, uintptr(unsafe.Pointer(&blob[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		k.Close()
+		return nil, err
+	}
+
+	// BCRYPT_ECCKEY_BLOB is the magic and the size of the coordinates,
+	// followed by X and Y
+	if len(blob) < 8 || binary.LittleEndian.Uint32(blob[0:4]) != bcryptECDSAP256Magic {
+		k.Close()
+		return nil, fmt.Errorf("the TPM key is not an ECDSA P-256 key")
+	}
+	n := int(binary.LittleEndian.Uint32(blob[4:8]))
+	if len(blob) < 8+2*n {
+		k.Close()
+		return nil, fmt.Errorf("the TPM returned a truncated public key")
+	}
+	k.pub = &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(blob[8 : 8+n]),
+		Y:     new(big.Int).SetBytes(blob[8+n : 8+2*n]),
+	}
+	return k, nil
+}
+
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign signs the SHA-256 digest in the TPM
+func (k *tpmKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 || len(digest) != crypto.SHA256.Size() {
+		return nil, fmt.Errorf("the TPM key only signs SHA-256 digests")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var size uint32
+	if err := ncrypt(procNCryptSignHash, k.key, 0, uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), 0, 0, uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		return nil, err
+	}
+	sig := make([]byte, size)
+	if err := ncrypt(procNCryptSignHash, k.key, 0, uintptr(unsafe.Pointer(&digest[0])), uintptr(len(digest)), uintptr(unsafe.Pointer(&sig[0])), uintptr(size), uintptr(unsafe.Pointer(&size)), 0); err != nil {
+		return nil, err
+	}
+	// NCrypt returns r and s concatenated
+	return ecdsaSignatureToASN1(sig[:size])
+}
+
+// Close deletes the key from the TPM
+func (k *tpmKey) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var err error
+	if k.key != 0 {
+		// NCryptDeleteKey also frees the handle
+		err = ncrypt(procNCryptDeleteKey, k.key, 0)
+		k.key = 0
+	}
+	if k.provider != 0 {
+		_ = ncrypt(procNCryptFreeObject, k.provider)
+		k.provider = 0
+	}
+	return err
+}
@@ -0,0 +1,344 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// LaunchAgentLabel is the launchd label of the opkssh login agent
+const LaunchAgentLabel = "com.openpubkey.opkssh.login"
+
+// SystemdUnitName is the systemd user service of the opkssh login agent
+const SystemdUnitName = "opkssh-login.service"
+
+// RunKeyValue is the name of the login agent in the Windows Run key
+const RunKeyValue = "opkssh-login"
+
+const runKey = `HKCU\Software\Microsoft\Windows\CurrentVersion\Run`
+
+// LaunchAgentCmd installs a user agent that keeps the SSH certificate fresh
+// by running `opkssh login --auto-refresh` when the user logs in: a launchd
+// agent on macOS, a systemd user service on Linux and an entry in the Run
+// key on Windows
+type LaunchAgentCmd struct {
+	Fs afero.Fs
+	// GOOS selects the kind of agent, runtime.GOOS by default
+	GOOS string
+	// HomeDir is the user's home directory
+	HomeDir string
+	// Executable is the path of the opkssh binary the agent runs
+	Executable string
+	// LoginArgs are passed to opkssh login in addition to --auto-refresh
+	LoginArgs []string
+	// CmdRunner runs launchctl, defaults to exec
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+	Out       io.Writer
+}
+
+// NewLaunchAgentCmd creates a LaunchAgentCmd for the current user and binary
+func NewLaunchAgentCmd(rt *Runtime, loginArgs []string) (*LaunchAgentCmd, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the opkssh binary: %w", err)
+	}
+	return &LaunchAgentCmd{
+		Fs:         rt.Fs,
+		HomeDir:    home,
+		Executable: exe,
+		GOOS:       runtime.GOOS,
+		LoginArgs:  loginArgs,
+		CmdRunner:  rt.CmdRunner,
+		Out:        rt.Out,
+	}, nil
+}
+
+func (c *LaunchAgentCmd) goos() string {
+	if c.GOOS != "" {
+		return c.GOOS
+	}
+	return runtime.GOOS
+}
+
+func (c *LaunchAgentCmd) loginArgs() []string {
+	return append([]string{"login", "--auto-refresh"}, c.LoginArgs...)
+}
+
+// PlistPath returns the path of the agent's property list
+func (c *LaunchAgentCmd) PlistPath() string {
+	return filepath.Join(c.HomeDir, "Library", "LaunchAgents", LaunchAgentLabel+".plist")
+}
+
+func (c *LaunchAgentCmd) domain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+// Plist returns the agent's property list
+func (c *LaunchAgentCmd) Plist() []byte {
+	logPath := filepath.Join(c.HomeDir, "Library", "Logs", "opkssh.log")
+	args := append([]string{c.Executable}, c.loginArgs()...)
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	writePlistString(&b, "Label", LaunchAgentLabel)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range args {
+		b.WriteString("\t\t<string>" + xmlEscape(arg) + "</string>\n")
+	}
+	b.WriteString("\t</array>\n")
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	// Log in again if the refresh loop exits with an error, e.g. when the
+	// refresh token has expired
+	b.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	b.WriteString("\t<key>ThrottleInterval</key>\n\t<integer>60</integer>\n")
+	writePlistString(&b, "StandardOutPath", logPath)
+	writePlistString(&b, "StandardErrorPath", logPath)
+	b.WriteString("</dict>\n</plist>\n")
+	return b.Bytes()
+}
+
+func writePlistString(b *bytes.Buffer, key string, value string) {
+	b.WriteString("\t<key>" + xmlEscape(key) + "</key>\n\t<string>" + xmlEscape(value) + "</string>\n")
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Install installs the agent and starts it
+func (c *LaunchAgentCmd) Install() error {
+	switch c.goos() {
+	case "darwin":
+		return c.installLaunchd()
+	case "linux":
+		return c.installSystemd()
+	case "windows":
+		return c.installRunKey()
+	default:
+		return fmt.Errorf("login agents are not supported on %s", c.goos())
+	}
+}
+
+// Uninstall stops the agent and removes it
+func (c *LaunchAgentCmd) Uninstall() error {
+	switch c.goos() {
+	case "darwin":
+		return c.uninstallLaunchd()
+	case "linux":
+		return c.uninstallSystemd()
+	case "windows":
+		return c.uninstallRunKey()
+	default:
+		return fmt.Errorf("login agents are not supported on %s", c.goos())
+	}
+}
+
+// installLaunchd writes the agent's property list and loads it
+func (c *LaunchAgentCmd) installLaunchd() error {
+	path := c.PlistPath()
+	if err := c.Fs.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := afero.WriteFile(c.Fs, path, c.Plist(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// Reload the agent if an older version is loaded
+	_, _ = c.CmdRunner("launchctl", "bootout", c.domain()+"/"+LaunchAgentLabel)
+	if out, err := c.CmdRunner("launchctl", "bootstrap", c.domain(), path); err != nil {
+		return fmt.Errorf("failed to load launch agent: %w: %s", err, out)
+	}
+	fmt.Fprintf(c.Out, "Installed launch agent %s, logs are written to ~/Library/Logs/opkssh.log\n", path)
+	return nil
+}
+
+// uninstallLaunchd unloads the agent and removes its property list
+func (c *LaunchAgentCmd) uninstallLaunchd() error {
+	path := c.PlistPath()
+	if _, err := c.Fs.Stat(path); err != nil {
+		fmt.Fprintln(c.Out, "Launch agent is not installed")
+		return nil
+	}
+	_, _ = c.CmdRunner("launchctl", "bootout", c.domain()+"/"+LaunchAgentLabel)
+	if err := c.Fs.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	fmt.Fprintf(c.Out, "Removed launch agent %s\n", path)
+	return nil
+}
+
+// UnitPath returns the path of the systemd user service
+func (c *LaunchAgentCmd) UnitPath() string {
+	return filepath.Join(c.HomeDir, ".config", "systemd", "user", SystemdUnitName)
+}
+
+// Unit returns the systemd user service. It is restarted if the refresh
+// loop exits with an error, e.g. when the refresh token has expired.
+func (c *LaunchAgentCmd) Unit() []byte {
+	args := []string{systemdQuote(c.Executable)}
+	for _, arg := range c.loginArgs() {
+		args = append(args, systemdQuote(arg))
+	}
+	return []byte("[Unit]\n" +
+		"Description=Keep the opkssh SSH certificate fresh\n" +
+		"\n" +
+		"[Service]\n" +
+		"ExecStart=" + strings.Join(args, " ") + "\n" +
+		"Restart=on-failure\n" +
+		"RestartSec=60\n" +
+		"\n" +
+		"[Install]\n" +
+		"WantedBy=default.target\n")
+}
+
+// systemdQuote quotes arg for an ExecStart line
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+}
+
+// installSystemd writes the systemd user service, enables and starts it
+func (c *LaunchAgentCmd) installSystemd() error {
+	path := c.UnitPath()
+	if err := c.Fs.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := afero.WriteFile(c.Fs, path, c.Unit(), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if out, err := c.CmdRunner("systemctl", "--user", "daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd user units: %w: %s", err, out)
+	}
+	// Restart picks up a changed unit if an older version is running
+	if out, err := c.CmdRunner("systemctl", "--user", "enable", SystemdUnitName); err != nil {
+		return fmt.Errorf("failed to enable %s: %w: %s", SystemdUnitName, err, out)
+	}
+	if out, err := c.CmdRunner("systemctl", "--user", "restart", SystemdUnitName); err != nil {
+		return fmt.Errorf("failed to start %s: %w: %s", SystemdUnitName, err, out)
+	}
+	fmt.Fprintf(c.Out, "Installed systemd user service %s, run journalctl --user -u %s to see its logs\n", path, SystemdUnitName)
+	return nil
+}
+
+// uninstallSystemd stops and disables the systemd user service and removes
+// it
+func (c *LaunchAgentCmd) uninstallSystemd() error {
+	path := c.UnitPath()
+	if _, err := c.Fs.Stat(path); err != nil {
+		fmt.Fprintln(c.Out, "Login agent is not installed")
+		return nil
+	}
+	_, _ = c.CmdRunner("systemctl", "--user", "disable", "--now", SystemdUnitName)
+	if err := c.Fs.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	_, _ = c.CmdRunner("systemctl", "--user", "daemon-reload")
+	fmt.Fprintf(c.Out, "Removed systemd user service %s\n", path)
+	return nil
+}
+
+// LogDir returns the directory the agent writes opkssh.log to on Windows
+func (c *LaunchAgentCmd) LogDir() string {
+	return filepath.Join(c.HomeDir, "AppData", "Local", "opkssh")
+}
+
+// RunCommand returns the command line of the Run key entry. Entries of the
+// Run key have no output, so the log is written with --log-dir.
+func (c *LaunchAgentCmd) RunCommand() string {
+	args := []string{windowsQuote(c.Executable)}
+	for _, arg := range append(c.loginArgs(), "--log-dir", c.LogDir()) {
+		args = append(args, windowsQuote(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+// windowsQuote quotes arg for a Windows command line, like
+// syscall.EscapeArg
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, r := range arg {
+		switch r {
+		case '\\':
+			slashes++
+			continue
+		case '"':
+			// Backslashes before a quote are escaped, and so is the quote
+			b.WriteString(strings.Repeat(`\`, 2*slashes+1))
+		default:
+			b.WriteString(strings.Repeat(`\`, slashes))
+		}
+		slashes = 0
+		b.WriteRune(r)
+	}
+	b.WriteString(strings.Repeat(`\`, 2*slashes))
+	b.WriteByte('"')
+	return b.String()
+}
+
+// installRunKey adds the agent to the Run key of the user, so it starts at
+// the next logon. Unlike a scheduled task this doesn't need administrator
+// rights.
+func (c *LaunchAgentCmd) installRunKey() error {
+	if err := c.Fs.MkdirAll(c.LogDir(), 0o700); err != nil {
+		return err
+	}
+	if out, err := c.CmdRunner("reg", "add", runKey, "/v", RunKeyValue, "/t", "REG_SZ", "/d", c.RunCommand(), "/f"); err != nil {
+		return fmt.Errorf("failed to add %s to %s: %w: %s", RunKeyValue, runKey, err, out)
+	}
+	fmt.Fprintf(c.Out, "Installed login agent %s in %s, it starts at your next logon and writes its logs to %s\n", RunKeyValue, runKey, filepath.Join(c.LogDir(), "opkssh.log"))
+	return nil
+}
+
+// uninstallRunKey removes the agent from the Run key of the user
+func (c *LaunchAgentCmd) uninstallRunKey() error {
+	if _, err := c.CmdRunner("reg", "query", runKey, "/v", RunKeyValue); err != nil {
+		fmt.Fprintln(c.Out, "Login agent is not installed")
+		return nil
+	}
+	if out, err := c.CmdRunner("reg", "delete", runKey, "/v", RunKeyValue, "/f"); err != nil {
+		return fmt.Errorf("failed to remove %s from %s: %w: %s", RunKeyValue, runKey, err, out)
+	}
+	fmt.Fprintf(c.Out, "Removed login agent %s from %s, an agent that is running keeps running until you log off\n", RunKeyValue, runKey)
+	return nil
+}
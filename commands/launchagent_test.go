@@ -0,0 +1,139 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaunchAgent(t *testing.T) {
+	var ran []string
+	out := &bytes.Buffer{}
+	c := &LaunchAgentCmd{
+		Fs:         afero.NewMemMapFs(),
+		GOOS:       "darwin",
+		HomeDir:    "/Users/alice",
+		Executable: "/usr/local/bin/opkssh",
+		LoginArgs:  []string{"google", "--provider=<a&b>"},
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			ran = append(ran, name+" "+strings.Join(arg, " "))
+			return nil, nil
+		},
+		Out: out,
+	}
+	path := "/Users/alice/Library/LaunchAgents/com.openpubkey.opkssh.login.plist"
+	require.Equal(t, path, c.PlistPath())
+
+	require.NoError(t, c.Install())
+	plist, err := afero.ReadFile(c.Fs, path)
+	require.NoError(t, err)
+	require.Contains(t, string(plist), "<string>com.openpubkey.opkssh.login</string>")
+	require.Contains(t, string(plist), "<string>/usr/local/bin/opkssh</string>\n\t\t<string>login</string>\n\t\t<string>--auto-refresh</string>\n\t\t<string>google</string>")
+	require.Contains(t, string(plist), "<string>--provider=&lt;a&amp;b&gt;</string>")
+	require.Contains(t, string(plist), "<string>/Users/alice/Library/Logs/opkssh.log</string>")
+	require.Len(t, ran, 2)
+	require.True(t, strings.HasPrefix(ran[1], "launchctl bootstrap gui/"))
+	require.True(t, strings.HasSuffix(ran[1], path))
+
+	require.NoError(t, c.Uninstall())
+	exists, err := afero.Exists(c.Fs, path)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Contains(t, out.String(), "not installed")
+}
+
+func TestLaunchAgentSystemd(t *testing.T) {
+	var ran []string
+	out := &bytes.Buffer{}
+	c := &LaunchAgentCmd{
+		Fs:         afero.NewMemMapFs(),
+		GOOS:       "linux",
+		HomeDir:    "/home/alice",
+		Executable: "/usr/local/bin/opkssh",
+		LoginArgs:  []string{"google", "--provider=a b", "100%"},
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			ran = append(ran, name+" "+strings.Join(arg, " "))
+			return nil, nil
+		},
+		Out: out,
+	}
+	path := "/home/alice/.config/systemd/user/opkssh-login.service"
+	require.Equal(t, path, c.UnitPath())
+
+	require.NoError(t, c.Install())
+	unit, err := afero.ReadFile(c.Fs, path)
+	require.NoError(t, err)
+	require.Contains(t, string(unit), "ExecStart=/usr/local/bin/opkssh login --auto-refresh google \"--provider=a b\" 100%%\n")
+	require.Contains(t, string(unit), "Restart=on-failure\n")
+	require.Equal(t, []string{
+		"systemctl --user daemon-reload",
+		"systemctl --user enable opkssh-login.service",
+		"systemctl --user restart opkssh-login.service",
+	}, ran)
+
+	ran = nil
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, []string{"systemctl --user disable --now opkssh-login.service", "systemctl --user daemon-reload"}, ran)
+	exists, err := afero.Exists(c.Fs, path)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestLaunchAgentWindows(t *testing.T) {
+	var ran [][]string
+	installed := false
+	c := &LaunchAgentCmd{
+		Fs:         afero.NewMemMapFs(),
+		GOOS:       "windows",
+		HomeDir:    `C:\Users\alice`,
+		Executable: `C:\Program Files\opkssh\opkssh.exe`,
+		LoginArgs:  []string{"google", `a"b`, `c\ d\`},
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			ran = append(ran, append([]string{name}, arg...))
+			if arg[0] == "query" && !installed {
+				return nil, errors.New("exit status 1")
+			}
+			installed = arg[0] == "add"
+			return nil, nil
+		},
+		Out: &bytes.Buffer{},
+	}
+
+	require.NoError(t, c.Install())
+	require.Len(t, ran, 1)
+	require.Equal(t, []string{"reg", "add", `HKCU\Software\Microsoft\Windows\CurrentVersion\Run`, "/v", "opkssh-login", "/t", "REG_SZ", "/d"}, ran[0][:8])
+	require.Equal(t, `"C:\Program Files\opkssh\opkssh.exe" login --auto-refresh google "a\"b" "c\ d\\" --log-dir `+c.LogDir(), ran[0][8])
+
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, "delete", ran[2][1])
+
+	ran = nil
+	out := c.Out.(*bytes.Buffer)
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Contains(t, out.String(), "not installed")
+	require.Len(t, ran, 1)
+}
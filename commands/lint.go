@@ -0,0 +1,612 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy/plugins"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// LintSeverity is how serious a LintFinding is
+type LintSeverity string
+
+const (
+	LintError   LintSeverity = "error"
+	LintWarning LintSeverity = "warning"
+	LintInfo    LintSeverity = "info"
+)
+
+// rank orders severities so that FailOn can be compared against them
+func (s LintSeverity) rank() int {
+	switch s {
+	case LintError:
+		return 2
+	case LintWarning:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Lint rules reported in LintFinding.Rule
+const (
+	LintRuleSyntax            = "syntax"
+	LintRuleMissingFile       = "missing-file"
+	LintRuleUnknownIssuer     = "unknown-issuer"
+	LintRuleInsecureIssuer    = "insecure-issuer"
+	LintRuleUnknownPrincipal  = "unknown-principal"
+	LintRuleDuplicate         = "duplicate"
+	LintRuleRevoked           = "revoked"
+	LintRuleExpirationPolicy  = "expiration-policy"
+	LintRuleDuplicateProvider = "duplicate-provider"
+	LintRulePluginConfig      = "plugin-config"
+	LintRulePluginCommand     = "plugin-command"
+	LintRulePermissions       = "permissions"
+	// LintRuleUnreachablePrincipal is a home policy entry for a principal
+	// other than the owner of the home directory, which verify ignores
+	LintRuleUnreachablePrincipal = "unreachable-principal"
+	// LintRuleCatchAllPrincipal is a principal pattern matching root or
+	// another privileged account without the catchall=true option
+	LintRuleCatchAllPrincipal = "catch-all-principal"
+	// LintRuleIssuerPolicy is an entry in the per-issuer policy of another
+	// issuer, which verify ignores
+	LintRuleIssuerPolicy = "issuer-policy"
+	// LintRuleIssuerAlias is an entry for an old issuer URL that is an alias
+	// of a provider, to be rewritten once the migration is done
+	LintRuleIssuerAlias = "issuer-alias"
+	// LintRuleShadowed is an entry that an earlier principal pattern entry
+	// of the same file already allows without restrictions
+	LintRuleShadowed = "shadowed"
+	// LintRuleExpired is an entry whose expires option is in the past,
+	// which verify skips
+	LintRuleExpired = "expired"
+)
+
+// LintFinding is a problem found by policy lint
+type LintFinding struct {
+	Severity LintSeverity `json:"severity"`
+	Rule     string       `json:"rule"`
+	Path     string       `json:"path"`
+	Line     int          `json:"line,omitempty"`
+	Message  string       `json:"message"`
+}
+
+// LintCmd checks the policy, providers and plugin configs for mistakes
+// without authenticating anyone
+type LintCmd struct {
+	Fs  afero.Fs
+	Out io.Writer
+	// LookupUser returns an error if the local account does not exist
+	LookupUser func(name string) error
+	// LookupGroup returns an error if the local group of a %group
+	// principal does not exist, nil skips the check
+	LookupGroup func(name string) error
+	// FileSystem checks the ownership and permissions of each file, nil
+	// skips the permission checks
+	FileSystem files.FileSystem
+	// HomeDirs lists the home directories whose ~/.opk/auth_id is checked
+	HomeDirs func() ([]userHomeEntry, error)
+	// Now returns the current time entry expiries are checked against,
+	// defaults to time.Now
+	Now func() time.Time
+
+	// Args
+	PolicyPath  string
+	FragmentDir string
+	// IssuerPolicyDir is the directory of the per-issuer system policies,
+	// empty to skip them
+	IssuerPolicyDir string
+	ProvidersPath   string
+	PluginsDir      string
+	RevocationPath  string
+	// SkipHostChecks skips the checks that depend on this host, the local
+	// accounts and the plugin commands, e.g. when linting a policy repository
+	SkipHostChecks bool
+	// SkipUserPolicy skips the home policies
+	SkipUserPolicy bool
+	JsonOutput     bool
+	// FailOn is the lowest severity that makes Run return an error
+	FailOn LintSeverity
+}
+
+// NewLintCmd creates a new LintCmd checking the system configuration
+func NewLintCmd(rt *Runtime) *LintCmd {
+	fileSystem := files.NewFileSystem(rt.Fs)
+	audit := &AuditCmd{Fs: fileSystem}
+	return &LintCmd{
+		Fs:  rt.Fs,
+		Out: rt.Out,
+		LookupUser: func(name string) error {
+			_, err := rt.UserLookup.Lookup(name)
+			return err
+		},
+		LookupGroup: func(name string) error {
+			_, err := user.LookupGroup(name)
+			return err
+		},
+		FileSystem:      fileSystem,
+		HomeDirs:        audit.enumerateUserHomeDirs,
+		Now:             rt.Now,
+		PolicyPath:      policy.Defaults.PolicyPath,
+		FragmentDir:     policy.Defaults.FragmentDir,
+		IssuerPolicyDir: policy.SystemDefaultIssuerPolicyDir,
+		ProvidersPath:   policy.Defaults.ProvidersPath,
+		PluginsDir:      policy.GetPluginPolicyDir(),
+		RevocationPath:  policy.SystemDefaultRevocationPath,
+		FailOn:          LintError,
+	}
+}
+
+// Lint returns the findings for every source, ordered by path and line
+func (l *LintCmd) Lint() ([]LintFinding, error) {
+	var findings []LintFinding
+	report := func(severity LintSeverity, rule string, path string, line int, format string, args ...any) {
+		findings = append(findings, LintFinding{
+			Severity: severity,
+			Rule:     rule,
+			Path:     path,
+			Line:     line,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	providerPolicy := l.lintProviders(report)
+	validator := policy.NewPolicyValidator(providerPolicy)
+
+	revoked, err := (&policy.RevocationList{Fs: l.Fs, Path: l.RevocationPath}).Entries()
+	if err != nil {
+		report(LintError, LintRuleSyntax, l.RevocationPath, 0, "%v", err)
+	}
+
+	policyPaths := []string{l.PolicyPath}
+	if l.IssuerPolicyDir != "" {
+		issuerPaths, err := (&policy.IssuerPolicyStore{Fs: l.Fs, Dir: l.IssuerPolicyDir}).Paths()
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range issuerPaths {
+			l.lintPermissions(path, files.RequiredPerms.SystemPolicy, report)
+		}
+		policyPaths = append(policyPaths, issuerPaths...)
+	}
+	if l.FragmentDir != "" {
+		fragments, err := afero.Glob(l.Fs, filepath.Join(l.FragmentDir, "*"+policy.FragmentExt))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(fragments)
+		policyPaths = append(policyPaths, fragments...)
+	}
+	l.lintPermissions(l.ProvidersPath, files.RequiredPerms.Providers, report)
+	l.lintPermissions(l.PolicyPath, files.RequiredPerms.SystemPolicy, report)
+	seen := map[string]string{}
+	for _, path := range policyPaths {
+		l.lintPolicyFile(path, "", validator, revoked, seen, report)
+	}
+
+	if !l.SkipUserPolicy && l.HomeDirs != nil {
+		homeDirs, err := l.HomeDirs()
+		if err != nil {
+			report(LintWarning, LintRuleMissingFile, "~/.opk/auth_id", 0, "could not enumerate user home directories: %v", err)
+		}
+		for _, home := range homeDirs {
+			path := filepath.Join(home.HomeDir, ".opk", "auth_id")
+			l.lintPermissions(path, files.RequiredPerms.HomePolicy, report)
+			l.lintPolicyFile(path, home.Username, validator, revoked, seen, report)
+		}
+	}
+
+	if err := l.lintPlugins(report); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Path != findings[j].Path {
+			return findings[i].Path < findings[j].Path
+		}
+		return findings[i].Line < findings[j].Line
+	})
+	return findings, nil
+}
+
+func (l *LintCmd) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+type lintReporter func(severity LintSeverity, rule string, path string, line int, format string, args ...any)
+
+// lintProviders checks the providers file and providers.yml and returns the
+// providers that the policy is checked against
+func (l *LintCmd) lintProviders(report lintReporter) *policy.ProviderPolicy {
+	providerPolicy := &policy.ProviderPolicy{}
+	yamlPath := policy.ProvidersYAMLPath(l.ProvidersPath)
+	yamlContent, yamlErr := afero.ReadFile(l.Fs, yamlPath)
+	content, err := afero.ReadFile(l.Fs, l.ProvidersPath)
+	if err != nil && (!errors.Is(err, os.ErrNotExist) || yamlErr != nil) {
+		report(LintError, LintRuleMissingFile, l.ProvidersPath, 0, "failed to read providers file: %v", err)
+	}
+
+	issuerLines := map[string]int{}
+	for _, row := range files.ReadRowsWithDetails(content) {
+		line := row.Line
+		if row.Empty {
+			continue
+		}
+		if row.Error != nil {
+			report(LintError, LintRuleSyntax, l.ProvidersPath, line, "%s", row.Error.Detail())
+			continue
+		}
+		if len(row.Columns) < 3 {
+			report(LintError, LintRuleSyntax, l.ProvidersPath, line, "%s", row.CheckColumns("issuer", "client-id", "expiration-policy").Detail())
+			continue
+		}
+		claims, err := policy.ParseRequiredClaims(row.Columns[3:])
+		if err != nil {
+			report(LintError, LintRuleSyntax, l.ProvidersPath, line, "%v", err)
+			continue
+		}
+		providerRow := policy.ProvidersRow{Issuer: row.Columns[0], ClientID: row.Columns[1], ExpirationPolicy: row.Columns[2], RequiredClaims: claims}
+		if _, err := providerRow.GetExpirationPolicy(); err != nil {
+			report(LintError, LintRuleExpirationPolicy, l.ProvidersPath, line, "%v", err)
+		} else if providerRow.ExpirationPolicy == "never" {
+			report(LintInfo, LintRuleExpirationPolicy, l.ProvidersPath, line, "PK Tokens from %s never expire", providerRow.Issuer)
+		}
+		if first, ok := issuerLines[providerRow.Issuer]; ok {
+			report(LintWarning, LintRuleDuplicateProvider, l.ProvidersPath, line, "issuer %s is already configured on line %d", providerRow.Issuer, first)
+		} else {
+			issuerLines[providerRow.Issuer] = line
+		}
+		providerPolicy.AddRow(providerRow)
+	}
+
+	if yamlErr != nil {
+		return providerPolicy
+	}
+	l.lintPermissions(yamlPath, files.RequiredPerms.Providers, report)
+	rows, errs := policy.ParseProvidersYAML(yamlContent)
+	for _, err := range errs {
+		report(LintError, LintRuleSyntax, yamlPath, 0, "%v", err)
+	}
+	for _, row := range rows {
+		if row.ExpirationPolicy == "never" && row.MaxSession == 0 {
+			report(LintInfo, LintRuleExpirationPolicy, yamlPath, 0, "PK Tokens from %s never expire", row.Issuer)
+		}
+		providerPolicy.AddRow(row)
+	}
+	if rows == nil {
+		// providers.yml is not valid YAML, already reported
+		return providerPolicy
+	}
+	aliases, errs := policy.ParseIssuerAliases(yamlContent)
+	for _, err := range errs {
+		report(LintError, LintRuleSyntax, yamlPath, 0, "%v", err)
+	}
+	names := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	for _, alias := range names {
+		issuer := aliases[alias]
+		if !slices.ContainsFunc(providerPolicy.GetRows(), func(r policy.ProvidersRow) bool { return r.Issuer == issuer }) {
+			report(LintError, LintRuleUnknownIssuer, yamlPath, 0, "issuer alias %s points to %s, which is not a configured provider", alias, issuer)
+			continue
+		}
+		providerPolicy.AddIssuerAlias(alias, issuer)
+	}
+	return providerPolicy
+}
+
+// lintPolicyFile checks every entry in the policy file at path. owner is the
+// user whose home policy it is, empty for the system policy. seen maps the
+// entries of the files already checked to where they were found.
+func (l *LintCmd) lintPolicyFile(path string, owner string, validator *policy.PolicyValidator, revoked []policy.RevokedIdentity, seen map[string]string, report lintReporter) {
+	content, err := afero.ReadFile(l.Fs, path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && path != l.PolicyPath {
+			return
+		}
+		report(LintError, LintRuleMissingFile, path, 0, "failed to read policy file: %v", err)
+		return
+	}
+
+	// patterns are the earlier unrestricted principal pattern entries
+	type patternEntry struct {
+		user    policy.User
+		pattern *policy.PrincipalPattern
+		line    int
+	}
+	var patterns []patternEntry
+	for _, row := range files.ReadRowsWithDetails(content) {
+		line := row.Line
+		if row.Empty {
+			continue
+		}
+		if row.Error != nil {
+			report(LintError, LintRuleSyntax, path, line, "%s", row.Error.Detail())
+			continue
+		}
+		user, err := policy.ParseEntry(row.Row())
+		if err != nil {
+			report(LintError, LintRuleSyntax, path, line, "%s", err.Detail())
+			continue
+		}
+		principal, identity, issuer := user.Principals[0], user.IdentityAttribute, user.Issuer
+		if user.Expired(l.now()) {
+			report(LintWarning, LintRuleExpired, path, line, "entry expired at %s, verify skips it", user.Expires.UTC().Format(time.RFC3339))
+		}
+
+		result := validator.ValidateEntry(principal, identity, issuer, line)
+		switch result.Status {
+		case policy.StatusError:
+			report(LintError, LintRuleUnknownIssuer, path, line, "%s", lintReason(result))
+		case policy.StatusWarning:
+			report(LintWarning, LintRuleInsecureIssuer, path, line, "%s", lintReason(result))
+		}
+		if result.AliasOf != "" {
+			report(LintInfo, LintRuleIssuerAlias, path, line, "issuer %s is an alias of %s, run opkssh policy rewrite-issuer once the migration is done", issuer, result.AliasOf)
+		}
+
+		if l.IssuerPolicyDir != "" && filepath.Dir(path) == filepath.Clean(l.IssuerPolicyDir) && !policy.IssuerPolicyHolds(path, issuer) {
+			report(LintError, LintRuleIssuerPolicy, path, line, "entry is ignored, the entries of issuer %s belong in %s", issuer, policy.IssuerPolicyName(issuer))
+		}
+		if owner != "" && principal != owner {
+			report(LintWarning, LintRuleUnreachablePrincipal, path, line, "principal %s is ignored, the home policy of %s can only grant access to %s", principal, owner, owner)
+		} else if policy.IsPrincipalPattern(principal) {
+			pattern, _ := policy.CompilePrincipalPattern(principal)
+			if account := pattern.CatchAll(); account != "" && !user.CatchAll {
+				report(LintError, LintRuleCatchAllPrincipal, path, line, "principal pattern %s matches %s, add the option %s=true if it is meant to", principal, account, policy.CatchAllOption)
+			}
+			if len(user.KeyOptions) == 0 {
+				patterns = append(patterns, patternEntry{user: user, pattern: pattern, line: line})
+			}
+		} else if group, ok := policy.IsGroupPrincipal(principal); ok {
+			if !l.SkipHostChecks && l.LookupGroup != nil {
+				if err := l.LookupGroup(group); err != nil {
+					report(LintWarning, LintRuleUnknownPrincipal, path, line, "principal %s is not a local group", principal)
+				}
+			}
+		} else if !l.SkipHostChecks {
+			if err := l.LookupUser(principal); err != nil {
+				report(LintWarning, LintRuleUnknownPrincipal, path, line, "principal %s is not a local account", principal)
+			}
+		}
+
+		if _, isGroup := policy.IsGroupPrincipal(principal); !isGroup && !policy.IsPrincipalPattern(principal) && (owner == "" || principal == owner) {
+			for _, p := range patterns {
+				// An entry that expires first grants nothing more
+				shadows := p.user.Expires.IsZero() || (!user.Expires.IsZero() && !p.user.Expires.Before(user.Expires))
+				if shadows && p.user.Issuer == issuer && strings.EqualFold(p.user.IdentityAttribute, identity) && p.pattern.Match(principal) {
+					report(LintWarning, LintRuleShadowed, path, line, "entry is shadowed by the principal pattern %s on line %d, which already allows it", p.user.Principals[0], p.line)
+					break
+				}
+			}
+		}
+
+		key := strings.Join([]string{principal, strings.ToLower(identity), issuer}, " ")
+		if first, ok := seen[key]; ok {
+			report(LintWarning, LintRuleDuplicate, path, line, "entry is a duplicate of %s", first)
+		} else {
+			seen[key] = fmt.Sprintf("%s:%d", path, line)
+		}
+
+		for _, r := range revoked {
+			if r.Matches(issuer, identity, identity) {
+				report(LintWarning, LintRuleRevoked, path, line, "identity %s is revoked, the entry never grants access", identity)
+				break
+			}
+		}
+	}
+}
+
+// lintPermissions checks the ownership, mode and ACL of path against
+// permInfo. Missing files are reported by the checks that read them.
+func (l *LintCmd) lintPermissions(path string, permInfo files.PermInfo, report lintReporter) {
+	if l.FileSystem == nil || l.SkipHostChecks {
+		return
+	}
+	result := CheckFilePermissions(l.FileSystem, path, permInfo)
+	if !result.Exists {
+		return
+	}
+	if result.PermsErr != "" {
+		report(LintError, LintRulePermissions, path, 0, "%s", result.PermsErr)
+	}
+	// On Unix the ACL problems repeat the mode and owner check, on Windows
+	// the mode does not describe who has access
+	if runtime.GOOS == "windows" && result.ACLReport != nil && result.ACLErr == nil {
+		for _, problem := range result.ACLReport.Problems {
+			report(LintWarning, LintRulePermissions, path, 0, "%s", problem)
+		}
+	}
+}
+
+// lintReason describes an invalid entry from its validation result
+func lintReason(result policy.ValidationRowResult) string {
+	if len(result.Hints) == 0 {
+		return result.Reason
+	}
+	return result.Reason + ": " + strings.Join(result.Hints, "; ")
+}
+
+// lintPlugins checks the schema of every plugin config in PluginsDir
+func (l *LintCmd) lintPlugins(report lintReporter) error {
+	entries, err := afero.ReadDir(l.Fs, l.PluginsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	l.lintPermissions(l.PluginsDir, files.RequiredPerms.PluginsDir, report)
+
+	names := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yml") {
+			continue
+		}
+		path := filepath.Join(l.PluginsDir, entry.Name())
+		content, err := afero.ReadFile(l.Fs, path)
+		if err != nil {
+			report(LintError, LintRuleMissingFile, path, 0, "failed to read plugin config: %v", err)
+			continue
+		}
+		l.lintPermissions(path, files.RequiredPerms.PluginFile, report)
+
+		var config plugins.PluginConfig
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			var typeErr *yaml.TypeError
+			if !errors.As(err, &typeErr) {
+				report(LintError, LintRuleSyntax, path, 0, "failed to parse YAML: %v", err)
+				continue
+			}
+			// Unknown fields are ignored by verify, but are likely typos
+			for _, msg := range typeErr.Errors {
+				report(LintWarning, LintRulePluginConfig, path, 0, "%s", msg)
+			}
+		}
+
+		if config.Name == "" {
+			report(LintError, LintRulePluginConfig, path, 0, "missing required field 'name'")
+		} else if first, ok := names[config.Name]; ok {
+			report(LintWarning, LintRulePluginConfig, path, 0, "plugin name %s is also used by %s", config.Name, first)
+		} else {
+			names[config.Name] = path
+		}
+
+		if err := config.ValidateProtocol(); err != nil {
+			report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+		}
+		if err := config.ValidateClaims(); err != nil {
+			report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+		}
+		if err := config.ValidateSHA256(); err != nil {
+			report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+		}
+		if _, err := config.CacheDuration(); err != nil {
+			report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+		}
+		if _, err := config.TimeoutDuration(); err != nil {
+			report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+		}
+
+		switch config.Type {
+		case "", plugins.PluginTypeExec:
+		case plugins.PluginTypeWebhook:
+			if err := config.ValidateWebhook(); err != nil {
+				report(LintError, LintRulePluginConfig, path, 0, "%v", err)
+			}
+			continue
+		default:
+			report(LintError, LintRulePluginConfig, path, 0, "unknown type %q, expected exec or webhook", config.Type)
+			continue
+		}
+
+		if config.Command == "" {
+			report(LintError, LintRulePluginConfig, path, 0, "missing required field 'command'")
+			continue
+		}
+		command, err := shellquote.Split(config.Command)
+		if err != nil || len(command) == 0 {
+			report(LintError, LintRulePluginCommand, path, 0, "failed to parse command %q: %v", config.Command, err)
+			continue
+		}
+		if !filepath.IsAbs(command[0]) {
+			report(LintWarning, LintRulePluginCommand, path, 0, "command %s is not an absolute path", command[0])
+		} else if !l.SkipHostChecks {
+			if _, err := l.Fs.Stat(command[0]); err != nil {
+				report(LintError, LintRulePluginCommand, path, 0, "command %s not found", command[0])
+			} else if config.SHA256 != "" && config.ValidateSHA256() == nil {
+				if err := plugins.CheckCommandSHA256(l.Fs, command[0], config.SHA256); err != nil {
+					report(LintError, LintRulePluginCommand, path, 0, "%v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Run prints the findings and returns an error if any is at least as severe
+// as FailOn
+func (l *LintCmd) Run() error {
+	switch l.FailOn {
+	case LintError, LintWarning, LintInfo:
+	default:
+		return fmt.Errorf("invalid --fail-on %q; use error, warning or info", l.FailOn)
+	}
+
+	findings, err := l.Lint()
+	if err != nil {
+		return err
+	}
+
+	if l.JsonOutput {
+		if findings == nil {
+			findings = []LintFinding{}
+		}
+		enc := json.NewEncoder(l.Out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		for _, f := range findings {
+			location := f.Path
+			if f.Line > 0 {
+				location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+			}
+			fmt.Fprintf(l.Out, "%s: %s: %s [%s]\n", location, f.Severity, f.Message, f.Rule)
+		}
+		if len(findings) == 0 {
+			fmt.Fprintln(l.Out, "no problems found")
+		}
+	}
+
+	failing := 0
+	for _, f := range findings {
+		if f.Severity.rank() >= l.FailOn.rank() {
+			failing++
+		}
+	}
+	if failing > 0 {
+		return fmt.Errorf("lint found %d problems", failing)
+	}
+	return nil
+}
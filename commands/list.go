@@ -0,0 +1,158 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// ListEntry is a policy entry and the result of the permission check of
+// the file it came from
+type ListEntry struct {
+	AccessGrant
+	// Permissions is "ok" or why the file fails the permission check
+	Permissions string `json:"permissions"`
+}
+
+// ListCmd prints the policy entries of the system policy, policy fragments
+// and home policies for a principal, or for every principal
+type ListCmd struct {
+	Export *AccessExportCmd
+	Out    io.Writer
+	Groups policy.GroupLookup
+
+	// Flags
+	Principal  string // Empty to list every principal
+	JsonOutput bool
+}
+
+// NewListCmd creates a new ListCmd reading the default policy locations.
+// serverConfig may be nil.
+func NewListCmd(rt *Runtime, serverConfig *config.ServerConfig) *ListCmd {
+	export := NewAccessExportCmd(rt, serverConfig)
+	export.IncludeInsecure = true
+	return &ListCmd{
+		Export: export,
+		Out:    rt.Out,
+		Groups: policy.NewOsGroupLookup(),
+	}
+}
+
+// Entries returns the policy entries for Principal. Entries of a local
+// group principal are included if Principal is a member of the group, and
+// those of a principal pattern if it matches Principal.
+func (l *ListCmd) Entries() ([]ListEntry, *AccessReport, error) {
+	report, err := l.Export.Report()
+	if err != nil {
+		return nil, nil, err
+	}
+	perms := map[string]string{}
+	entries := []ListEntry{}
+	for _, grant := range report.Grants {
+		if l.Principal != "" && !l.grants(grant.Principal, report) {
+			continue
+		}
+		if _, ok := perms[grant.Source]; !ok {
+			perms[grant.Source] = l.checkPerms(grant)
+		}
+		entries = append(entries, ListEntry{AccessGrant: grant, Permissions: perms[grant.Source]})
+	}
+	return entries, report, nil
+}
+
+// grants returns true if the policy principal gives access to Principal:
+// it is Principal, a local group Principal is a member of or a pattern
+// matching Principal
+func (l *ListCmd) grants(principal string, report *AccessReport) bool {
+	if principal == l.Principal {
+		return true
+	}
+	if policy.IsPrincipalPattern(principal) {
+		pattern, err := policy.CompilePrincipalPattern(principal)
+		return err == nil && pattern.Match(l.Principal)
+	}
+	group, ok := policy.IsGroupPrincipal(principal)
+	if !ok || l.Groups == nil {
+		return false
+	}
+	member, err := l.Groups.IsMember(l.Principal, group)
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("failed to look up group %s: %v", group, err))
+	}
+	return member
+}
+
+// checkPerms checks the file of grant has the permissions verify requires
+func (l *ListCmd) checkPerms(grant AccessGrant) string {
+	perm := files.ModeSystemPerms
+	if grant.SourceType == "home" {
+		perm = files.ModeHomePerms
+	}
+	if err := files.NewPermsChecker(l.Export.Fs).CheckPerm(grant.Source, []fs.FileMode{perm}, "", ""); err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// Run prints the policy entries as a table or as JSON
+func (l *ListCmd) Run() error {
+	entries, report, err := l.Entries()
+	if err != nil {
+		return err
+	}
+	if l.JsonOutput {
+		enc := json.NewEncoder(l.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Entries []ListEntry `json:"entries"`
+			Plugins []string    `json:"plugins"`
+			Errors  []string    `json:"errors"`
+		}{entries, report.Plugins, report.Errors})
+	}
+
+	w := tabwriter.NewWriter(l.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PRINCIPAL\tIDENTITY\tISSUER\tSOURCE\tPERMISSIONS\tSTATUS")
+	for _, entry := range entries {
+		status := "in effect"
+		if !entry.Effective {
+			status = "not in effect"
+		}
+		if len(entry.Conditions) > 0 {
+			status += ": " + strings.Join(entry.Conditions, "; ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Principal, entry.Identity, entry.Issuer, entry.Source, entry.Permissions, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, plugin := range report.Plugins {
+		fmt.Fprintf(l.Out, "Policy plugin %s also decides at login time\n", plugin)
+	}
+	for _, problem := range report.Errors {
+		fmt.Fprintf(l.Out, "Error: %s\n", problem)
+	}
+	return nil
+}
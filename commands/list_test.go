@@ -0,0 +1,127 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// testGroupLookup maps group names to their members
+type testGroupLookup map[string][]string
+
+func (m testGroupLookup) IsMember(username string, group string) (bool, error) {
+	for _, member := range m[group] {
+		if member == username {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newTestListCmd(t *testing.T) (*ListCmd, afero.Fs, *bytes.Buffer) {
+	t.Helper()
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/providers", []byte("https://accounts.google.com google-client 24h\n"), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte(
+		"root alice@example.com https://accounts.google.com\n"+
+			"%sshadmins bob@example.com https://accounts.google.com\n"+
+			"dave carol@example.com https://accounts.google.com expires=2020-01-01\n"), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, "/home/dave/.opk/auth_id", []byte(
+		"dave dave@example.com https://accounts.google.com\n"), 0644))
+
+	out := &bytes.Buffer{}
+	list := &ListCmd{
+		Export: &AccessExportCmd{
+			Fs: mockFs,
+			HomeDirs: func() ([]userHomeEntry, error) {
+				return []userHomeEntry{{Username: "dave", HomeDir: "/home/dave"}}, nil
+			},
+			ProvidersPath:   "/etc/opk/providers",
+			PolicyPath:      "/etc/opk/auth_id",
+			FragmentDir:     "/var/lib/opk/policy",
+			PluginDir:       "/etc/opk/policy.d",
+			IncludeInsecure: true,
+		},
+		Out:    out,
+		Groups: testGroupLookup{"sshadmins": {"dave"}},
+	}
+	return list, mockFs, out
+}
+
+func TestList(t *testing.T) {
+	t.Parallel()
+	list, _, out := newTestListCmd(t)
+
+	entries, report, err := list.Entries()
+	require.NoError(t, err)
+	require.Empty(t, report.Errors)
+	require.Len(t, entries, 4)
+
+	list.Principal = "dave"
+	entries, _, err = list.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	// Entries of a group the principal is a member of are included
+	require.Equal(t, "%sshadmins", entries[0].Principal)
+	require.True(t, entries[0].Effective)
+	require.Equal(t, "ok", entries[0].Permissions)
+
+	require.False(t, entries[1].Effective)
+	require.Contains(t, entries[1].Conditions, "expired: 2020-01-01")
+
+	// A home policy with insecure permissions is listed but not in effect
+	home := entries[2]
+	require.Equal(t, "/home/dave/.opk/auth_id", home.Source)
+	require.False(t, home.Effective)
+	require.Equal(t, "expected one of the following permissions [600], got (644)", home.Permissions)
+	require.Contains(t, home.Conditions, "denied: insecure permissions: expected one of the following permissions [600], got (644)")
+
+	list.Principal = "root"
+	require.NoError(t, list.Run())
+	require.Contains(t, out.String(), "PRINCIPAL  IDENTITY           ISSUER")
+	require.Contains(t, out.String(), "root       alice@example.com  https://accounts.google.com  /etc/opk/auth_id  ok           in effect: token expiration 24h")
+	require.NotContains(t, out.String(), "dave")
+
+	out.Reset()
+	list.JsonOutput = true
+	require.NoError(t, list.Run())
+	var result struct {
+		Entries []ListEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	require.Len(t, result.Entries, 1)
+	require.Equal(t, "system", result.Entries[0].SourceType)
+}
+
+func TestListWithoutInsecure(t *testing.T) {
+	t.Parallel()
+	list, _, _ := newTestListCmd(t)
+	list.Export.IncludeInsecure = false
+
+	// Without IncludeInsecure the home policy is reported as an error
+	entries, report, err := list.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Len(t, report.Errors, 1)
+	require.Contains(t, report.Errors[0], "insecure permissions")
+}
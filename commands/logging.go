@@ -0,0 +1,60 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"io"
+	"sync"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/logging"
+)
+
+var (
+	loggingMu     sync.Mutex
+	loggingCloser io.Closer
+)
+
+// ConfigureLogging sets up the process logger from the logging section of
+// the server config, replacing the one of an earlier call. An empty section
+// restores the plain log file. On error the current logger is kept.
+func ConfigureLogging(cfg config.LoggingConfig) error {
+	loggingMu.Lock()
+	defer loggingMu.Unlock()
+	var closer io.Closer
+	if cfg.IsEmpty() {
+		logging.Reset()
+	} else {
+		logger, c, err := logging.New(logging.Options{
+			Level:        cfg.Level,
+			Format:       cfg.Format,
+			Destinations: cfg.Destinations,
+			Path:         cfg.Path,
+		}, logging.Base())
+		if err != nil {
+			return err
+		}
+		logging.Install(logger)
+		closer = c
+	}
+	// The previous logger is no longer used once the new one is installed
+	if loggingCloser != nil {
+		_ = loggingCloser.Close()
+	}
+	loggingCloser = closer
+	return nil
+}
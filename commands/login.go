@@ -28,11 +28,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwa"
@@ -41,8 +44,8 @@ import (
 	"github.com/openpubkey/openpubkey/oidc"
 	"github.com/openpubkey/openpubkey/pktoken"
 	"github.com/openpubkey/openpubkey/providers"
-	"github.com/openpubkey/openpubkey/util"
 	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/sysdetails"
 	"github.com/openpubkey/opkssh/sshcert"
 	"github.com/spf13/afero"
 	"github.com/thediveo/enumflag/v2"
@@ -69,6 +72,35 @@ func (k KeyType) String() string {
 	}
 }
 
+// keyTypeSpec describes how the key pair of a KeyType is generated and used
+type keyTypeSpec struct {
+	Alg        jwa.SignatureAlgorithm // Algorithm of the upk in the PK Token
+	SSHKeyAlgo string                 // SSH public key algorithm of the key pair
+	// MinOpenSSH is the first OpenSSH release that supports SSHKeyAlgo, e.g.
+	// "v10.0", empty if every release supported by opkssh does. Login checks
+	// it against the local ssh so that post-quantum signature key types can
+	// be added as OpenSSH adopts them.
+	MinOpenSSH string
+}
+
+var keyTypeSpecs = map[KeyType]keyTypeSpec{
+	ECDSA:   {Alg: jwa.ES256, SSHKeyAlgo: ssh.KeyAlgoECDSA256},
+	ED25519: {Alg: jwa.EdDSA, SSHKeyAlgo: ssh.KeyAlgoED25519},
+}
+
+// DefaultMaxValidity bounds login --validity for providers without
+// max_validity in the client config. It is the longest expiration policy
+// supported by servers (1week).
+const DefaultMaxValidity = 7 * 24 * time.Hour
+
+// certClockSkew is how far before the current time the SSH cert becomes
+// valid when --validity is set, to allow for clock differences
+const certClockSkew = 5 * time.Minute
+
+// minRefreshInterval is the shortest wait between refreshes of login
+// --auto-refresh, so that a short --validity doesn't refresh in a loop
+const minRefreshInterval = 30 * time.Second
+
 // DefaultSSHKeyFileNames are the file names ssh key pairs that opkssh may
 // write to in ~/.ssh/ during login. These are used by both login and logout
 // so that if a new key type is added, logout will automatically pick it up.
@@ -92,14 +124,25 @@ type LoginCmd struct {
 	KeyPathArg            string // Path where SSH private key is written
 	ProviderArg           string // OpenID Provider specification in the format: <issuer>,<client_id> or <issuer>,<client_id>,<client_secret> or <issuer>,<client_id>,<client_secret>,<scopes>
 	ProviderAliasArg      string
+	ListProvidersArg      bool // Print the providers of the client config instead of logging in
+	DeviceFlowArg         bool // Log in with the device authorization grant instead of a localhost redirect
 	KeyTypeArg            KeyType
-	PrintKeyArg           bool // Print the raw private key and SSH cert to stdout instead of writing them to the filesystem
-	InspectCertArg        bool // Display a human-readable inspection of the generated SSH certificate (public information only)
+	KeyBackendArg         KeyBackend // Where the key pair is generated and held
+	PrintKeyArg           bool       // Print the raw private key and SSH cert to stdout instead of writing them to the filesystem
+	WriteToAgentArg       bool       // Add the SSH key and cert to the running ssh-agent until the ID Token or the cert expires
+	InspectCertArg        bool       // Display a human-readable inspection of the generated SSH certificate (public information only)
 	SSHConfigured         bool
 	Verbosity             int // Default verbosity is 0, 1 is verbose, 2 is debug
 	RemoteRedirectURI     string
-
-	overrideProvider *providers.OpenIdProvider // Used in tests to override the provider to inject a mock provider
+	PrincipalsArg         []string      // Principals written to the SSH cert, for SSH proxies that check them. Empty allows any principal
+	TokenStore            TokenStore    // Where the PK Token of the login is saved, if StoreTokenArg or store_token in the client config is set
+	StoreTokenArg         bool          // Save the PK Token of the login in TokenStore, for opkssh whoami
+	ValidityArg           time.Duration // If set, the SSH cert is only valid for this long
+	SSHClientVersion      func() string // Returns the output of ssh -V, defaults to sysdetails.GetSSHClientVersion
+
+	overrideProvider *providers.OpenIdProvider                           // Used in tests to override the provider to inject a mock provider
+	overrideKeyPair  func(jwa.SignatureAlgorithm) (crypto.Signer, error) // Used in tests to inject a hardware key
+	agentSocket      string                                              // Used in tests to override where hardware keys are served
 	// State
 	Config *config.ClientConfig
 
@@ -109,19 +152,27 @@ type LoginCmd struct {
 	alg        jwa.SignatureAlgorithm
 	client     *client.OpkClient
 	principals []string
+	keyPath    string
+	// validBefore is when the SSH cert expires, zero if the cert is valid
+	// for as long as the server's expiration policy allows
+	validBefore time.Time
+	// keyAgent serves the key to ssh if it is held by a security device
+	keyAgent    *signerAgent
+	hardwareKey hardwareKey
 
 	// For testing
 	OutWriter io.Writer // Captures non-logged output that would normally be written to stdout
 }
 
 // NewLogin creates a new LoginCmd instance with the provided arguments.
-func NewLogin(autoRefreshArg bool, configPathArg string, createConfigArg bool, configureArg bool, logDirArg string,
+func NewLogin(rt *Runtime, autoRefreshArg bool, configPathArg string, createConfigArg bool, configureArg bool, logDirArg string,
 	sendAccessTokenArg bool, disableBrowserOpenArg bool, printIdTokenArg bool,
 	providerArg string, printKeyArg bool, keyPathArg string, providerAliasArg string, keyTypeArg KeyType,
 	remoteRedirectUri string, inspectCertArg bool,
 ) *LoginCmd {
 	return &LoginCmd{
-		Fs:                    afero.NewOsFs(),
+		Fs:                    rt.Fs,
+		OutWriter:             rt.Out,
 		AutoRefreshArg:        autoRefreshArg,
 		ConfigPathArg:         configPathArg,
 		CreateConfigArg:       createConfigArg,
@@ -146,7 +197,7 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 		logFilePath := filepath.Join(l.LogDirArg, "opkssh.log")
 		logFile, err := l.Fs.OpenFile(logFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o660)
 		if err != nil {
-			log.Printf("Failed to open log for writing: %v \n", err)
+			slog.Error("Failed to open the log for writing", "error", err)
 		}
 		defer logFile.Close()
 		multiWriter := io.MultiWriter(os.Stdout, logFile)
@@ -156,7 +207,8 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 	}
 
 	if l.Verbosity >= 2 {
-		log.Printf("DEBUG: running login command with args: %+v", *l)
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		slog.Debug("Running login command", "args", fmt.Sprintf("%+v", *l))
 	}
 
 	// If the Config has been set in the struct don't replace it. This is useful for testing
@@ -166,7 +218,7 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 		}
 		if _, err := l.Fs.Stat(l.ConfigPathArg); err == nil {
 			if l.CreateConfigArg {
-				log.Printf("--create-config=true but config file already exists at %s", l.ConfigPathArg)
+				slog.Warn("--create-config=true but the config file already exists", "path", l.ConfigPathArg)
 			}
 
 			if client_config, err := config.GetClientConfigFromFile((l.ConfigPathArg), l.Fs); err != nil {
@@ -178,7 +230,7 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 			if l.CreateConfigArg {
 				return config.CreateDefaultClientConfig(l.ConfigPathArg, l.Fs)
 			} else {
-				log.Printf("failed to find client config file to generate a default config, run `opkssh login --create-config` to create a default config file")
+				slog.Warn("No client config file, using the default config, run `opkssh login --create-config` to create one")
 			}
 			l.Config, err = config.NewClientConfig(config.DefaultClientConfig)
 			if err != nil {
@@ -187,6 +239,10 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 		}
 	}
 
+	if l.ListProvidersArg {
+		return l.ListProviders()
+	}
+
 	if l.ConfigureArg {
 		err := l.configureSSH()
 		if err != nil {
@@ -227,12 +283,16 @@ func (l *LoginCmd) Run(ctx context.Context) error {
 	if !l.SendAccessTokenArg {
 		if opConfig, ok := l.Config.GetByIssuer(provider.Issuer()); !ok {
 			// This can happen if the provider is supplied via the command line or environment variables and thus not in the config
-			log.Printf("Warning: could not find issuer %s in client config providers\n", provider.Issuer())
+			slog.Warn("Could not find the issuer in the client config providers", "issuer", provider.Issuer())
 		} else {
 			l.SendAccessTokenArg = opConfig.SendAccessToken
 		}
 	}
 
+	if err := l.checkValidity(provider.Issuer()); err != nil {
+		return err
+	}
+
 	// Execute login command
 	if l.AutoRefreshArg {
 		if providerRefreshable, ok := provider.(providers.RefreshableOpenIdProvider); ok {
@@ -266,10 +326,10 @@ func (l *LoginCmd) configureSSH() error {
 	var userOpkSshConfig = filepath.Join(userOpkSshDir, "config")
 
 	if _, err := l.Fs.Stat(userOpkSshConfig); err == nil {
-		log.Println("--configure but already configured")
+		slog.Info("--configure but already configured")
 	}
 
-	log.Printf("Creating config directory at %s", userOpkSshDir)
+	slog.Info("Creating config directory", "path", userOpkSshDir)
 
 	afs := &afero.Afero{Fs: l.Fs}
 	err = afs.MkdirAll(userOpkSshDir, 0o0700)
@@ -277,7 +337,7 @@ func (l *LoginCmd) configureSSH() error {
 		return fmt.Errorf("failed to create opkssh SSH directory: %w", err)
 	}
 
-	log.Printf("Creating config file at %s", userOpkSshConfig)
+	slog.Info("Creating config file", "path", userOpkSshConfig)
 
 	file, err := afs.OpenFile(userOpkSshConfig, os.O_CREATE, 0o0600)
 	if err != nil {
@@ -285,7 +345,21 @@ func (l *LoginCmd) configureSSH() error {
 	}
 	defer file.Close()
 
-	log.Printf("Adding include directive to SSH config at %s", "~/.ssh/config")
+	if kexAlgorithms := pqKexConfig(l.sshClientVersion()); kexAlgorithms != "" {
+		opkConfig, err := afs.ReadFile(userOpkSshConfig)
+		if err != nil {
+			return fmt.Errorf("failed to read opkssh SSH config file: %w", err)
+		}
+		if !strings.Contains(string(opkConfig), "KexAlgorithms ") {
+			slog.Info("Preferring post-quantum key exchange", "path", userOpkSshConfig)
+			opkConfig = slices.Concat(opkConfig, []byte(kexAlgorithms+"\n"))
+			if err := afs.WriteFile(userOpkSshConfig, opkConfig, 0o0600); err != nil {
+				return fmt.Errorf("failed to write opkssh SSH config file: %w", err)
+			}
+		}
+	}
+
+	slog.Info("Adding include directive to the SSH config", "path", "~/.ssh/config")
 
 	content, err := afs.ReadFile(userSshConfig)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -293,7 +367,7 @@ func (l *LoginCmd) configureSSH() error {
 	}
 
 	if strings.Contains(string(content), includeDirective) {
-		log.Println("Found include directive file in SSH config, skipping...")
+		slog.Info("Found include directive in the SSH config, skipping")
 	} else {
 		// construct new SSH config
 		content = slices.Concat([]byte(includeDirective+"\n\n"), content)
@@ -305,15 +379,37 @@ func (l *LoginCmd) configureSSH() error {
 	}
 
 	l.SSHConfigured = true
-	log.Println("Configured SSH identity directory")
+	slog.Info("Configured SSH identity directory")
 	return nil
 }
 
+func (l *LoginCmd) sshClientVersion() string {
+	if l.SSHClientVersion != nil {
+		return l.SSHClientVersion()
+	}
+	return sysdetails.GetSSHClientVersion()
+}
+
+// pqKexConfig returns the ssh config line that puts the post-quantum hybrid
+// key exchange algorithms supported by the OpenSSH client version first, or
+// an empty string if it supports none of them
+func pqKexConfig(opensshVersion string) string {
+	// Prepending to the default list with ^ needs OpenSSH 8.7
+	if ok, err := sysdetails.OpenSSHVersionAtLeast(opensshVersion, "v8.7"); err != nil || !ok {
+		return ""
+	}
+	algs := sysdetails.SupportedPQKexAlgorithms(opensshVersion)
+	if len(algs) == 0 {
+		return ""
+	}
+	return "KexAlgorithms ^" + strings.Join(algs, ",")
+}
+
 func (l *LoginCmd) checkSSHConfigured() {
 
 	userhomeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Printf("Failed to get user config dir: %v", err)
+		slog.Warn("Failed to get the user home directory", "error", err)
 		return
 	}
 
@@ -355,10 +451,22 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 	var provider providers.OpenIdProvider
 	var err error
 
-	// If the user has supplied commandline arguments for the provider, short circuit and use providerArg
-	if l.ProviderArg != "" {
+	// A --provider without a comma can be the alias of a provider in the
+	// client config, like the alias argument
+	providerAliasArg := l.ProviderAliasArg
+	isAlias := l.ProviderArg != "" && !strings.Contains(l.ProviderArg, ",") && l.isProviderAlias(l.ProviderArg)
+	if isAlias {
+		if providerAliasArg != "" && providerAliasArg != l.ProviderArg {
+			return nil, nil, fmt.Errorf("both --provider %s and alias %s were given, use only one", l.ProviderArg, providerAliasArg)
+		}
+		providerAliasArg = l.ProviderArg
+	} else if l.ProviderArg != "" {
+		// If the user has supplied commandline arguments for the provider, short circuit and use providerArg
 		providerConfig, err := config.NewProviderConfigFromString(l.ProviderArg, false)
 		if err != nil {
+			if !strings.Contains(l.ProviderArg, ",") {
+				return nil, nil, fmt.Errorf("error parsing provider argument: %w. It is not the alias of a provider either, run opkssh login --list-providers to see the configured providers", err)
+			}
 			return nil, nil, fmt.Errorf("error parsing provider argument: %w", err)
 		}
 
@@ -367,7 +475,7 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 			providerConfig.RemoteRedirectURI = l.RemoteRedirectURI
 		}
 
-		if provider, err = providerConfig.ToProvider(openBrowser); err != nil {
+		if provider, err = l.toProvider(providerConfig, openBrowser); err != nil {
 			return nil, nil, fmt.Errorf("error creating provider from config: %w", err)
 		} else {
 			return provider, nil, nil
@@ -376,13 +484,13 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 
 	// Set the default provider from the env variable if specified
 	defaultProviderEnv, _ := os.LookupEnv(config.OPKSSH_DEFAULT_ENVVAR)
-	providerConfigsEnv, err := config.GetProvidersConfigFromEnv()
+	providerConfigs, err = l.providerConfigs()
 	if err != nil {
-		return nil, nil, fmt.Errorf("error getting provider config from env: %w", err)
+		return nil, nil, err
 	}
 
-	if l.ProviderAliasArg != "" {
-		defaultProviderAlias = l.ProviderAliasArg
+	if providerAliasArg != "" {
+		defaultProviderAlias = providerAliasArg
 	} else if defaultProviderEnv != "" {
 		defaultProviderAlias = defaultProviderEnv
 	} else if l.Config.DefaultProvider != "" {
@@ -391,11 +499,7 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 		defaultProviderAlias = config.WEBCHOOSER_ALIAS
 	}
 
-	if providerConfigsEnv != nil {
-		providerConfigs = providerConfigsEnv
-	} else if len(l.Config.Providers) > 0 {
-		providerConfigs = l.Config.Providers
-	} else {
+	if len(providerConfigs) == 0 {
 		return nil, nil, fmt.Errorf("no providers specified")
 	}
 
@@ -413,12 +517,15 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 			providerConfig.RemoteRedirectURI = l.RemoteRedirectURI
 		}
 
-		provider, err = providerConfig.ToProvider(openBrowser)
+		provider, err = l.toProvider(providerConfig, openBrowser)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error creating provider from config: %w", err)
 		}
 		return provider, nil, nil
 	} else {
+		if l.DeviceFlowArg {
+			return nil, nil, fmt.Errorf("--device-flow can't be used with the web chooser, choose a provider with --provider or default_provider")
+		}
 		// If the default provider is WEBCHOOSER, we need to create a chooser and return it
 		var providerList []providers.BrowserOpenIdProvider
 		for _, providerConfig := range providerConfigs {
@@ -440,23 +547,108 @@ func (l *LoginCmd) determineProvider() (providers.OpenIdProvider, *choosers.WebC
 	}
 }
 
+// toProvider creates the provider of providerConfig, which logs in with
+// the device authorization grant if --device-flow is set
+func (l *LoginCmd) toProvider(providerConfig config.ProviderConfig, openBrowser bool) (providers.OpenIdProvider, error) {
+	provider, err := providerConfig.ToProvider(openBrowser)
+	if err != nil || !l.DeviceFlowArg {
+		return provider, err
+	}
+	scopes := providerConfig.Scopes
+	if len(scopes) == 0 || (len(scopes) == 1 && scopes[0] == "") {
+		scopes = config.DefaultProviderConfig().Scopes
+	}
+	return NewDeviceFlowOp(provider, providerConfig.ClientID, providerConfig.ClientSecret, scopes, l.out()), nil
+}
+
+// providerConfigs returns the providers that can be logged in with, from
+// the OPKSSH_PROVIDERS env var if it is set and otherwise from the client
+// config
+func (l *LoginCmd) providerConfigs() ([]config.ProviderConfig, error) {
+	providerConfigsEnv, err := config.GetProvidersConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error getting provider config from env: %w", err)
+	}
+	if providerConfigsEnv != nil {
+		return providerConfigsEnv, nil
+	}
+	return l.Config.Providers, nil
+}
+
+// isProviderAlias returns true if alias is the alias of a provider that can
+// be logged in with
+func (l *LoginCmd) isProviderAlias(alias string) bool {
+	providerConfigs, err := l.providerConfigs()
+	if err != nil {
+		return false
+	}
+	for _, p := range providerConfigs {
+		if slices.Contains(p.AliasList, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListProviders prints the providers that can be chosen with --provider or
+// the alias argument
+func (l *LoginCmd) ListProviders() error {
+	providerConfigs, err := l.providerConfigs()
+	if err != nil {
+		return err
+	}
+	defaultProvider := l.Config.DefaultProvider
+	if env, _ := os.LookupEnv(config.OPKSSH_DEFAULT_ENVVAR); env != "" {
+		defaultProvider = env
+	}
+	if defaultProvider == "" {
+		defaultProvider = strings.ToLower(config.WEBCHOOSER_ALIAS)
+	}
+
+	w := tabwriter.NewWriter(l.out(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ALIAS\tISSUER\tCLIENT ID\tSCOPES")
+	for _, p := range providerConfigs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.Join(p.AliasList, ","), p.Issuer, p.ClientID, strings.Join(p.Scopes, " "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(l.out(), "\nDefault provider: %s\n", defaultProvider)
+	return err
+}
+
 func (l *LoginCmd) login(ctx context.Context, provider providers.OpenIdProvider, printIdToken bool, seckeyPath string) (*LoginCmd, error) {
 	var err error
 
-	var alg jwa.SignatureAlgorithm
-	switch l.KeyTypeArg {
-	case ECDSA:
-		alg = jwa.ES256
-	case ED25519:
-		alg = jwa.EdDSA
-	default:
+	spec, ok := keyTypeSpecs[l.KeyTypeArg]
+	if !ok {
 		return nil, fmt.Errorf("unsupported key type (%s); use -t <%s|%s>", l.KeyTypeArg.String(), ECDSA.String(), ED25519.String())
 	}
+	if spec.MinOpenSSH != "" {
+		version := l.sshClientVersion()
+		if ok, err := sysdetails.OpenSSHVersionAtLeast(version, spec.MinOpenSSH); err != nil || !ok {
+			return nil, fmt.Errorf("key type (%s) requires OpenSSH %s or later, found %q", l.KeyTypeArg.String(), strings.TrimPrefix(spec.MinOpenSSH, "v"), version)
+		}
+	}
+	alg := spec.Alg
 
-	signer, err := util.GenKeyPair(alg)
+	if l.KeyBackendArg != SoftwareKey && (l.PrintKeyArg || l.WriteToAgentArg) {
+		return nil, fmt.Errorf("--print-key and --write-to-agent can't be used with --key-backend %s, the private key can't leave the %s", l.KeyBackendArg, l.KeyBackendArg)
+	}
+	generate := func(alg jwa.SignatureAlgorithm) (crypto.Signer, error) { return newKeyPair(l.KeyBackendArg, alg) }
+	if l.overrideKeyPair != nil {
+		generate = l.overrideKeyPair
+	}
+	signer, err := generate(alg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate keypair: %w", err)
 	}
+	if hwKey, ok := signer.(hardwareKey); ok {
+		l.hardwareKey = hwKey
+		if l.keyAgent, err = newSignerAgent(hwKey); err != nil {
+			return nil, err
+		}
+	}
 
 	opkClient, err := client.New(provider, client.WithSigner(signer, alg))
 	if err != nil {
@@ -481,13 +673,26 @@ func (l *LoginCmd) login(ctx context.Context, provider providers.OpenIdProvider,
 	// If principals is empty the server does not enforce any principal. The OPK
 	// verifier should use policy to make this decision.
 	principals := []string{}
-	certBytes, seckeySshPem, err := createSSHCertWithAccessToken(pkt, accessToken, signer, principals)
+	if len(l.PrincipalsArg) > 0 {
+		principals = l.PrincipalsArg
+	}
+	certBytes, seckeySshPem, validBefore, err := createSSHCertWithAccessToken(pkt, accessToken, signer, principals, l.ValidityArg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate SSH cert: %w", err)
 	}
 
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ID Token: %w", err)
+	}
+	expiresAt := keyExpiresAt(idt.GetClaims().Expiration, validBefore)
+
 	// Write ssh secret key and public key to filesystem
-	if l.PrintKeyArg {
+	if l.keyAgent != nil {
+		if err := l.serveHardwareKey(certBytes, expiresAt); err != nil {
+			return nil, err
+		}
+	} else if l.PrintKeyArg {
 		w := l.out()
 		fmt.Fprintln(w, string(certBytes))    // Base64 encoded SSH cert
 		fmt.Fprintln(w, string(seckeySshPem)) // SSH private key in OpenSSH native format
@@ -507,6 +712,13 @@ func (l *LoginCmd) login(ctx context.Context, provider providers.OpenIdProvider,
 		}
 	}
 
+	if l.WriteToAgentArg {
+		if err := l.writeKeyToAgent(seckeySshPem, certBytes, expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to add SSH key to ssh-agent: %w", err)
+		}
+		fmt.Fprintln(l.out(), "Added the SSH key to ssh-agent")
+	}
+
 	if printIdToken {
 		idTokenStr, err := PrettyIdToken(*pkt)
 		if err != nil {
@@ -528,21 +740,39 @@ func (l *LoginCmd) login(ctx context.Context, provider providers.OpenIdProvider,
 		return nil, fmt.Errorf("failed to parse ID Token: %w", err)
 	}
 	fmt.Printf("Keys generated for identity\n%s\n", idStr)
+	l.validBefore = validBefore
+	l.warnIfExpiring(pkt)
+	l.storeToken(pkt, accessToken)
 
 	return &LoginCmd{
-		pkt:        pkt,
-		signer:     signer,
-		client:     opkClient,
-		alg:        alg,
-		principals: principals,
+		pkt:         pkt,
+		signer:      signer,
+		client:      opkClient,
+		alg:         alg,
+		principals:  principals,
+		validBefore: validBefore,
 	}, nil
 }
 
 // Login performs the OIDC login procedure and creates the SSH certs/keys in the
 // default SSH key location.
 func (l *LoginCmd) Login(ctx context.Context, provider providers.OpenIdProvider, printIdToken bool, seckeyPath string) error {
-	_, err := l.login(ctx, provider, printIdToken, seckeyPath)
-	return err
+	defer l.closeHardwareKey()
+	if _, err := l.login(ctx, provider, printIdToken, seckeyPath); err != nil {
+		return err
+	}
+	if l.keyAgent == nil {
+		return nil
+	}
+
+	// ssh can only use the key while opkssh serves it
+	_, expiresAt := l.keyAgent.Expiration()
+	select {
+	case <-time.After(time.Until(expiresAt)):
+		slog.Info("The SSH cert expired, run opkssh login again")
+	case <-ctx.Done():
+	}
+	return nil
 }
 
 // LoginWithRefresh performs the OIDC login procedure, creates the SSH
@@ -551,6 +781,7 @@ func (l *LoginCmd) Login(ctx context.Context, provider providers.OpenIdProvider,
 // function only returns if it encounters an error or if the supplied context is
 // cancelled.
 func (l *LoginCmd) LoginWithRefresh(ctx context.Context, provider providers.RefreshableOpenIdProvider, printIdToken bool, seckeyPath string) error {
+	defer l.closeHardwareKey()
 	if loginResult, err := l.login(ctx, provider, printIdToken, seckeyPath); err != nil {
 		return err
 	} else {
@@ -562,13 +793,15 @@ func (l *LoginCmd) LoginWithRefresh(ctx context.Context, provider providers.Refr
 		}
 
 		for {
-			// Sleep until a minute before expiration to give us time to refresh
-			// the token and minimize any interruptions
-			untilExpired := time.Until(time.Unix(claims.Expiration, 0)) - time.Minute
-			log.Printf("Waiting for %v before attempting to refresh id_token...", untilExpired)
+			// Sleep until a minute before the ID Token or the SSH cert expires
+			// to give us time to refresh the token and minimize any
+			// interruptions
+			expiresAt := keyExpiresAt(claims.Expiration, loginResult.validBefore)
+			untilExpired := max(time.Until(expiresAt)-time.Minute, minRefreshInterval)
+			slog.Info("Waiting before refreshing the id_token", "wait", untilExpired)
 			select {
 			case <-time.After(untilExpired):
-				log.Print("Refreshing id_token...")
+				slog.Info("Refreshing the id_token")
 			case <-ctx.Done():
 				return ctx.Err()
 			}
@@ -587,24 +820,35 @@ func (l *LoginCmd) LoginWithRefresh(ctx context.Context, provider providers.Refr
 				}
 			}
 
-			certBytes, seckeySshPem, err := createSSHCertWithAccessToken(loginResult.pkt, accessToken, loginResult.signer, loginResult.principals)
+			certBytes, seckeySshPem, validBefore, err := createSSHCertWithAccessToken(loginResult.pkt, accessToken, loginResult.signer, loginResult.principals, l.ValidityArg)
 			if err != nil {
 				return fmt.Errorf("failed to generate SSH cert: %w", err)
 			}
-
-			// Write ssh secret key and public key to filesystem
-			if seckeyPath != "" {
-				// If we have set seckeyPath then write it there
-				if err := l.writeKeys(seckeyPath, seckeyPath+"-cert.pub", seckeySshPem, certBytes); err != nil {
-					return fmt.Errorf("failed to write SSH keys to filesystem: %w", err)
-				}
-			} else {
-				// If keyPath isn't set then write it to the default location
-				if err := l.writeKeysToSSHDir(seckeySshPem, certBytes); err != nil {
-					return fmt.Errorf("failed to write SSH keys to filesystem: %w", err)
+			loginResult.validBefore = validBefore
+			l.validBefore = validBefore
+
+			// Check before the keys are written, as that removes the old
+			// key from the agent on macOS
+			inAgent := l.keyAgent == nil && l.agentHoldsKey(loginResult.signer)
+
+			// Write ssh secret key and public key to filesystem. A key held
+			// by a security device is served by the agent below instead.
+			if l.keyAgent == nil {
+				if seckeyPath != "" {
+					// If we have set seckeyPath then write it there
+					if err := l.writeKeys(seckeyPath, seckeyPath+"-cert.pub", seckeySshPem, certBytes); err != nil {
+						return fmt.Errorf("failed to write SSH keys to filesystem: %w", err)
+					}
+				} else {
+					// If keyPath isn't set then write it to the default location
+					if err := l.writeKeysToSSHDir(seckeySshPem, certBytes); err != nil {
+						return fmt.Errorf("failed to write SSH keys to filesystem: %w", err)
+					}
 				}
 			}
 
+			l.storeToken(refreshedPkt, accessToken)
+
 			comPkt, err := refreshedPkt.Compact()
 			if err != nil {
 				return err
@@ -619,8 +863,170 @@ func (l *LoginCmd) LoginWithRefresh(ctx context.Context, provider providers.Refr
 			if err = json.Unmarshal(payload, &claims); err != nil {
 				return fmt.Errorf("malformed refreshed ID token payload: %w", err)
 			}
+
+			if l.keyAgent != nil {
+				if err := l.keyAgent.SetCertificate(certBytes, keyExpiresAt(claims.Expiration, validBefore)); err != nil {
+					return err
+				}
+				slog.Info("Updated the SSH cert served by opkssh")
+			} else if inAgent || l.WriteToAgentArg {
+				l.updateAgentKey(seckeySshPem, certBytes, keyExpiresAt(claims.Expiration, validBefore))
+			}
+		}
+	}
+}
+
+// agentHoldsKey returns true if the ssh-agent at SSH_AUTH_SOCK holds the
+// key of signer
+func (l *LoginCmd) agentHoldsKey(signer crypto.Signer) bool {
+	pub, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return false
+	}
+	a, conn, err := dialSystemAgent()
+	if err != nil || a == nil {
+		return false
+	}
+	defer conn.Close()
+	ok, err := agentHasKey(a, pub)
+	return err == nil && ok
+}
+
+// serveHardwareKey serves the key held by a security device and its SSH cert
+// to ssh, as ssh-agent can't hold a key without its private key
+func (l *LoginCmd) serveHardwareKey(certBytes []byte, expiresAt time.Time) error {
+	if err := l.keyAgent.SetCertificate(certBytes, expiresAt); err != nil {
+		return err
+	}
+	sock := l.agentSocket
+	if sock == "" {
+		var err error
+		if sock, err = defaultSignerAgentSocket(); err != nil {
+			return fmt.Errorf("failed to find where to serve the SSH key: %w", err)
+		}
+	}
+	if err := l.keyAgent.Serve(sock); err != nil {
+		return err
+	}
+	fmt.Fprintf(l.out(), "The SSH key is held in the %s and opkssh serves it to ssh until %s, keep opkssh running.\n"+
+		"Add \"IdentityAgent %s\" to ~/.ssh/config or set SSH_AUTH_SOCK=%s to use it.\n",
+		l.KeyBackendArg, expiresAt.Format(time.RFC3339), sock, sock)
+	return nil
+}
+
+// closeHardwareKey stops serving the key held by a security device and
+// deletes it
+func (l *LoginCmd) closeHardwareKey() {
+	if l.keyAgent != nil {
+		_ = l.keyAgent.Close()
+	}
+	if l.hardwareKey != nil {
+		if err := l.hardwareKey.Close(); err != nil {
+			slog.Warn("Failed to delete the SSH key", "backend", l.KeyBackendArg, "error", err)
+		}
+	}
+}
+
+// keyExpiresAt returns when the SSH key stops working, the expiration of the
+// ID Token or of the SSH cert if it is earlier
+func keyExpiresAt(exp int64, validBefore time.Time) time.Time {
+	expiresAt := time.Unix(exp, 0)
+	if !validBefore.IsZero() && validBefore.Before(expiresAt) {
+		expiresAt = validBefore
+	}
+	return expiresAt
+}
+
+// writeKeyToAgent replaces the key in the ssh-agent with the SSH key and
+// cert. The agent forgets the key at expiresAt.
+func (l *LoginCmd) writeKeyToAgent(seckeySshPem []byte, certBytes []byte, expiresAt time.Time) error {
+	lifetime := time.Until(expiresAt)
+	if lifetime <= 0 {
+		return fmt.Errorf("the SSH key expired at %s", expiresAt.Format(time.RFC3339))
+	}
+	a, conn, err := dialSystemAgent()
+	if err != nil {
+		return err
+	} else if a == nil {
+		return fmt.Errorf("no ssh-agent is running, SSH_AUTH_SOCK is not set")
+	}
+	defer conn.Close()
+	return addKeyToAgent(a, seckeySshPem, certBytes, lifetime)
+}
+
+// updateAgentKey replaces the key in the ssh-agent with the refreshed
+// certificate, so that ssh offers it without the user adding the key again.
+// The agent forgets the key when the certificate expires.
+func (l *LoginCmd) updateAgentKey(seckeySshPem []byte, certBytes []byte, expiresAt time.Time) {
+	if err := l.writeKeyToAgent(seckeySshPem, certBytes, expiresAt); err != nil {
+		slog.Warn("Failed to update the key in ssh-agent", "error", err)
+		return
+	}
+	slog.Info("Updated the key in ssh-agent")
+}
+
+// checkValidity returns an error if --validity is longer than allowed for the
+// provider with issuer
+func (l *LoginCmd) checkValidity(issuer string) error {
+	if l.ValidityArg == 0 {
+		return nil
+	}
+	if l.ValidityArg < 0 {
+		return fmt.Errorf("--validity must be positive, got %s", l.ValidityArg)
+	}
+	maxValidity := DefaultMaxValidity
+	if l.Config != nil {
+		if opConfig, ok := l.Config.GetByIssuer(issuer); ok && opConfig.MaxValidity != "" {
+			d, err := time.ParseDuration(opConfig.MaxValidity)
+			if err != nil {
+				return fmt.Errorf("invalid max_validity %q for %s in client config: %w", opConfig.MaxValidity, issuer, err)
+			}
+			maxValidity = d
 		}
 	}
+	if l.ValidityArg > maxValidity {
+		return fmt.Errorf("--validity %s is longer than the %s allowed for %s", l.ValidityArg, maxValidity, issuer)
+	}
+	return nil
+}
+
+// storeToken saves the PK Token to the token store if asked to, failures
+// only print a warning since the SSH key has already been written
+func (l *LoginCmd) storeToken(pkt *pktoken.PKToken, accessToken []byte) {
+	if l.TokenStore == nil || l.PrintKeyArg {
+		return
+	}
+	if !l.StoreTokenArg && (l.Config == nil || !l.Config.StoreToken) {
+		return
+	}
+	token, err := NewStoredToken(pkt, accessToken, l.keyPath)
+	if err == nil {
+		token.ValidBefore = l.validBefore
+		err = l.TokenStore.Save(token)
+	}
+	if err != nil {
+		slog.Warn("Failed to save the token", "error", err)
+	}
+}
+
+// forgetAgentKey removes the key about to be replaced at seckeyPath from the
+// macOS ssh-agent so that ssh offers the new certificate
+func (l *LoginCmd) forgetAgentKey(seckeyPath string) {
+	if runtime.GOOS != "darwin" {
+		return
+	}
+	afs := &afero.Afero{Fs: l.Fs}
+	oldKey, err := afs.ReadFile(seckeyPath)
+	if err != nil {
+		return
+	}
+	if n, err := removeKeyFromSystemAgent(oldKey); err != nil {
+		if l.Verbosity >= 1 {
+			slog.Warn("Failed to remove the old key from ssh-agent", "error", err)
+		}
+	} else if n > 0 && l.Verbosity >= 1 {
+		slog.Info("Removed old identities from ssh-agent", "count", n)
+	}
 }
 
 func (l *LoginCmd) out() io.Writer {
@@ -631,49 +1037,64 @@ func (l *LoginCmd) out() io.Writer {
 }
 
 func createSSHCert(pkt *pktoken.PKToken, signer crypto.Signer, principals []string) ([]byte, []byte, error) {
-	return createSSHCertWithAccessToken(pkt, nil, signer, principals)
+	certBytes, seckeySshBytes, _, err := createSSHCertWithAccessToken(pkt, nil, signer, principals, 0)
+	return certBytes, seckeySshBytes, err
 }
 
-func createSSHCertWithAccessToken(pkt *pktoken.PKToken, accessToken []byte, signer crypto.Signer, principals []string) ([]byte, []byte, error) {
+// createSSHCertWithAccessToken creates the SSH cert and private key. If
+// validity is not zero the cert expires after validity and the time it expires
+// is returned.
+func createSSHCertWithAccessToken(pkt *pktoken.PKToken, accessToken []byte, signer crypto.Signer, principals []string, validity time.Duration) ([]byte, []byte, time.Time, error) {
 	cert, err := sshcert.New(pkt, accessToken, principals)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
+	}
+	var validBefore time.Time
+	if validity > 0 {
+		now := time.Now()
+		validBefore = now.Add(validity).Truncate(time.Second)
+		cert.SshCert.ValidAfter = uint64(now.Add(-certClockSkew).Unix())
+		cert.SshCert.ValidBefore = uint64(validBefore.Unix())
 	}
 	sshSigner, err := ssh.NewSignerFromSigner(signer)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
 	}
 
 	var keyAlgos []string
-	switch signer.(type) {
-	case *ecdsa.PrivateKey:
+	switch signer.Public().(type) {
+	case *ecdsa.PublicKey:
 		keyAlgos = []string{ssh.KeyAlgoECDSA256}
-	case ed25519.PrivateKey:
+	case ed25519.PublicKey:
 		keyAlgos = []string{ssh.KeyAlgoED25519}
 	default:
-		return nil, nil, fmt.Errorf("unsupported key type: %T", signer)
+		return nil, nil, time.Time{}, fmt.Errorf("unsupported key type: %T", signer)
 	}
 
 	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), keyAlgos)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
 	}
 
 	sshCert, err := cert.SignCert(signerMas)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
 	}
 	certBytes := ssh.MarshalAuthorizedKey(sshCert)
 	// Remove newline character that MarshalAuthorizedKey() adds
 	certBytes = certBytes[:len(certBytes)-1]
 
+	// A key held by a security device has no private key to write
+	if _, ok := signer.(hardwareKey); ok {
+		return certBytes, nil, validBefore, nil
+	}
 	seckeySsh, err := ssh.MarshalPrivateKey(signer, "openpubkey cert")
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, time.Time{}, err
 	}
 	seckeySshBytes := pem.EncodeToMemory(seckeySsh)
 
-	return certBytes, seckeySshBytes, nil
+	return certBytes, seckeySshBytes, validBefore, nil
 }
 
 func (l *LoginCmd) writeKeysToOpkSSHDir(secKeyPem []byte, certBytes []byte) error {
@@ -770,12 +1191,12 @@ func (l *LoginCmd) writeKeysToSSHDir(seckeySshPem []byte, certBytes []byte) erro
 			afs := &afero.Afero{Fs: l.Fs}
 			sshPubkey, err := afs.ReadFile(pubkeyPath)
 			if err != nil {
-				log.Println("Failed to read:", pubkeyPath)
+				slog.Warn("Failed to read the public key", "path", pubkeyPath)
 				continue
 			}
 			_, comment, _, _, err := ssh.ParseAuthorizedKey(sshPubkey)
 			if err != nil {
-				log.Println("Failed to parse:", pubkeyPath)
+				slog.Warn("Failed to parse the public key", "path", pubkeyPath)
 				continue
 			}
 
@@ -789,6 +1210,9 @@ func (l *LoginCmd) writeKeysToSSHDir(seckeySshPem []byte, certBytes []byte) erro
 }
 
 func (l *LoginCmd) writeKeys(seckeyPath string, pubkeyPath string, seckeySshPem []byte, certBytes []byte) error {
+	l.forgetAgentKey(seckeyPath)
+	l.keyPath = seckeyPath
+
 	// Write ssh secret key to filesystem
 	afs := &afero.Afero{Fs: l.Fs}
 	if err := afs.WriteFile(seckeyPath, seckeySshPem, 0o600); err != nil {
@@ -803,6 +1227,9 @@ func (l *LoginCmd) writeKeys(seckeyPath string, pubkeyPath string, seckeySshPem
 }
 
 func (l *LoginCmd) writeKeysComment(seckeyPath string, pubkeyPath string, seckeySshPem []byte, certBytes []byte, pubKeyComment string) error {
+	l.forgetAgentKey(seckeyPath)
+	l.keyPath = seckeyPath
+
 	// Write ssh secret key to filesystem
 	afs := &afero.Afero{Fs: l.Fs}
 	if err := afs.WriteFile(seckeyPath, seckeySshPem, 0o600); err != nil {
@@ -849,6 +1276,29 @@ func (l *LoginCmd) fileExists(fPath string) bool {
 	return !errors.Is(err, os.ErrNotExist)
 }
 
+// warnIfExpiring prints a warning if the ID Token in pkt expires within the
+// expiry_warning window set in the client config. Servers using the oidc
+// expiration policy reject the SSH key once the ID Token expires.
+func (l *LoginCmd) warnIfExpiring(pkt *pktoken.PKToken) {
+	if l.Config == nil || l.Config.ExpiryWarning == "" {
+		return
+	}
+	window, err := time.ParseDuration(l.Config.ExpiryWarning)
+	if err != nil {
+		slog.Warn("Ignoring invalid expiry_warning in the client config", "error", err)
+		return
+	}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return
+	}
+	expiresAt := time.Unix(idt.GetClaims().Expiration, 0)
+	if remaining := time.Until(expiresAt); remaining < window {
+		fmt.Fprintf(l.out(), "WARNING: ID token expires at %s (in %s). Servers using the oidc expiration policy will reject this key after that time, consider using --auto-refresh.\n",
+			expiresAt.Format(time.RFC3339), remaining.Round(time.Second))
+	}
+}
+
 // IdentityString returns a string representation of the identity from the PK Token.
 // e.g "Email, sub, issuer, audience"
 func IdentityString(pkt pktoken.PKToken) (string, error) {
@@ -23,10 +23,14 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ed25519"
 
@@ -40,6 +44,7 @@ import (
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 const providerAlias1 = "op1"
@@ -267,7 +272,7 @@ func TestLoginCmd(t *testing.T) {
 						logBytes, err := afero.ReadFile(mockFs, logPath)
 						require.NoError(t, err)
 						require.NotNil(t, logBytes)
-						require.Contains(t, string(logBytes), "running login command with args:")
+						require.Contains(t, string(logBytes), "DEBUG Running login command args=")
 
 						sshPubPath := filepath.Join(homePath, ".ssh", "id_ecdsa-cert.pub")
 						pubKeyBytes, err = afero.ReadFile(mockFs, sshPubPath)
@@ -288,6 +293,248 @@ func TestLoginCmd(t *testing.T) {
 	}
 }
 
+func TestLoginSavesToken(t *testing.T) {
+	defaultConfig, err := config.NewClientConfig(config.DefaultClientConfig)
+	require.NoError(t, err)
+
+	_, _, mockOp := Mocks(t, ECDSA)
+	mockFs := afero.NewMemMapFs()
+	store := &FileTokenStore{Fs: mockFs, Path: "/token.json"}
+	loginCmd := LoginCmd{
+		Fs:               mockFs,
+		Config:           defaultConfig,
+		KeyPathArg:       "/keys/id_ecdsa",
+		TokenStore:       store,
+		overrideProvider: &mockOp,
+		OutWriter:        &bytes.Buffer{},
+	}
+	// Nothing is saved unless asked to
+	require.NoError(t, loginCmd.Run(context.Background()))
+	token, err := store.Load()
+	require.NoError(t, err)
+	require.Nil(t, token)
+
+	loginCmd.StoreTokenArg = true
+	require.NoError(t, loginCmd.Run(context.Background()))
+	token, err = store.Load()
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	require.Equal(t, mockOp.Issuer(), token.Issuer)
+	require.Equal(t, "/keys/id_ecdsa", token.KeyPath)
+}
+
+func TestLoginWriteToAgent(t *testing.T) {
+	defaultConfig, err := config.NewClientConfig(config.DefaultClientConfig)
+	require.NoError(t, err)
+	_, _, mockOp := Mocks(t, ECDSA)
+	loginCmd := LoginCmd{
+		Fs:               afero.NewMemMapFs(),
+		Config:           defaultConfig,
+		KeyPathArg:       "/keys/id_ecdsa",
+		WriteToAgentArg:  true,
+		ValidityArg:      time.Hour,
+		overrideProvider: &mockOp,
+		OutWriter:        &bytes.Buffer{},
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if systemAgentSocket() == "" {
+		require.ErrorContains(t, loginCmd.Run(context.Background()), "no ssh-agent is running")
+	}
+
+	// Unix socket paths are limited to about 100 characters
+	dir, err := os.MkdirTemp("", "agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	listener, err := net.Listen("unix", filepath.Join(dir, "agent.sock"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	keyring := agent.NewKeyring()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", listener.Addr().String())
+
+	require.NoError(t, loginCmd.Run(context.Background()))
+	require.Contains(t, loginCmd.OutWriter.(*bytes.Buffer).String(), "Added the SSH key to ssh-agent")
+
+	keys, err := keyring.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "openpubkey", keys[0].Comment)
+	pub, err := ssh.ParsePublicKey(keys[0].Blob)
+	require.NoError(t, err)
+	cert, ok := pub.(*ssh.Certificate)
+	require.True(t, ok)
+	certOnDisk, err := afero.ReadFile(loginCmd.Fs, "/keys/id_ecdsa-cert.pub")
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))), strings.TrimSuffix(string(certOnDisk), " openpubkey"))
+}
+
+// syncBuffer is a bytes.Buffer that a login can write to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestLoginHardwareKey(t *testing.T) {
+	defaultConfig, err := config.NewClientConfig(config.DefaultClientConfig)
+	require.NoError(t, err)
+	_, _, mockOp := Mocks(t, ECDSA)
+	key := newTestHardwareKey(t)
+	dir, err := os.MkdirTemp("", "agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "agent.sock")
+	if runtime.GOOS == "windows" {
+		sock = `\\.\pipe\opkssh-test-` + filepath.Base(dir)
+	}
+
+	out := &syncBuffer{}
+	loginCmd := LoginCmd{
+		Fs:               afero.NewMemMapFs(),
+		Config:           defaultConfig,
+		KeyPathArg:       "/keys/id_ecdsa",
+		KeyBackendArg:    TPMKey,
+		overrideProvider: &mockOp,
+		overrideKeyPair:  func(jwa.SignatureAlgorithm) (crypto.Signer, error) { return key, nil },
+		agentSocket:      sock,
+		OutWriter:        out,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- loginCmd.Run(ctx) }()
+	require.Eventually(t, func() bool { return strings.Contains(out.String(), "IdentityAgent "+sock) }, 10*time.Second, 10*time.Millisecond)
+
+	conn, err := dialAgent(sock)
+	require.NoError(t, err)
+	defer conn.Close()
+	keys, err := agent.NewClient(conn).List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	pub, err := ssh.ParsePublicKey(keys[0].Blob)
+	require.NoError(t, err)
+	cert := pub.(*ssh.Certificate)
+	smuggler := sshcert.SshCertSmuggler{SshCert: cert}
+	pkt, err := smuggler.GetPKToken()
+	require.NoError(t, err)
+	require.NotNil(t, pkt)
+
+	// The private key is never written
+	exists, err := afero.Exists(loginCmd.Fs, "/keys/id_ecdsa")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.True(t, key.closed)
+
+	loginCmd.KeyBackendArg = TPMKey
+	loginCmd.PrintKeyArg = true
+	require.ErrorContains(t, loginCmd.Run(context.Background()), "can't be used with --key-backend tpm")
+}
+
+func TestLoginValidity(t *testing.T) {
+	_, _, mockOp := Mocks(t, ECDSA)
+	clientConfig, err := config.NewClientConfig([]byte(`
+providers:
+  - alias: mockOp
+    issuer: ` + mockOp.Issuer() + `
+    client_id: client-id
+    max_validity: 2h
+`))
+	require.NoError(t, err)
+	require.Equal(t, "2h", clientConfig.Providers[0].MaxValidity)
+
+	tests := []struct {
+		name        string
+		validity    time.Duration
+		errorString string
+	}{
+		{name: "shorter than max_validity", validity: time.Hour},
+		{name: "longer than max_validity", validity: 3 * time.Hour, errorString: "--validity 3h0m0s is longer than the 2h0m0s allowed"},
+		{name: "negative", validity: -time.Hour, errorString: "--validity must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockFs := afero.NewMemMapFs()
+			store := &FileTokenStore{Fs: mockFs, Path: "/token.json"}
+			loginCmd := LoginCmd{
+				Fs:               mockFs,
+				Config:           clientConfig,
+				KeyPathArg:       "/keys/id_ecdsa",
+				ValidityArg:      tt.validity,
+				TokenStore:       store,
+				StoreTokenArg:    true,
+				overrideProvider: &mockOp,
+				OutWriter:        &bytes.Buffer{},
+			}
+			err := loginCmd.Run(context.Background())
+			if tt.errorString != "" {
+				require.ErrorContains(t, err, tt.errorString)
+				return
+			}
+			require.NoError(t, err)
+
+			certBytes, err := afero.ReadFile(mockFs, "/keys/id_ecdsa-cert.pub")
+			require.NoError(t, err)
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+			require.NoError(t, err)
+			cert := pubKey.(*ssh.Certificate)
+			validBefore := time.Unix(int64(cert.ValidBefore), 0)
+			require.WithinDuration(t, time.Now().Add(tt.validity), validBefore, time.Minute)
+			require.Less(t, cert.ValidAfter, uint64(time.Now().Unix()))
+
+			token, err := store.Load()
+			require.NoError(t, err)
+			require.True(t, validBefore.Equal(token.ValidBefore))
+		})
+	}
+}
+
+func TestPQKexConfig(t *testing.T) {
+	require.Equal(t, "", pqKexConfig("OpenSSH_8.4p1"))
+	require.Equal(t, "", pqKexConfig("OpenSSH_8.6p1"))
+	require.Equal(t, "", pqKexConfig(""))
+	require.Equal(t, "KexAlgorithms ^sntrup761x25519-sha512@openssh.com", pqKexConfig("OpenSSH_9.6p1"))
+	require.Equal(t, "KexAlgorithms ^mlkem768x25519-sha256,sntrup761x25519-sha512,sntrup761x25519-sha512@openssh.com",
+		pqKexConfig("OpenSSH_9.9p1"))
+}
+
+func TestLoginKeyTypeRequiresOpenSSH(t *testing.T) {
+	keyTypeSpecs[KeyType(99)] = keyTypeSpec{Alg: jwa.EdDSA, SSHKeyAlgo: "ssh-pq-test", MinOpenSSH: "v99.0"}
+	defer delete(keyTypeSpecs, KeyType(99))
+
+	loginCmd := &LoginCmd{
+		Fs:               afero.NewMemMapFs(),
+		KeyTypeArg:       KeyType(99),
+		SSHClientVersion: func() string { return "OpenSSH_9.9p1" },
+	}
+	_, err := loginCmd.login(context.Background(), nil, false, "")
+	require.ErrorContains(t, err, "requires OpenSSH 99.0 or later")
+}
+
 func TestDetermineProvider(t *testing.T) {
 	tests := []struct {
 		name              string
@@ -377,6 +624,30 @@ func TestDetermineProvider(t *testing.T) {
 			wantIssuer:        providerIssuer3,
 			wantError:         false,
 		},
+		{
+			name:        "Provider arg is an alias in the client config",
+			providerArg: "gitlab",
+			wantIssuer:  "https://gitlab.com",
+		},
+		{
+			name:        "Provider arg is an alias in OPKSSH_PROVIDERS",
+			envVars:     map[string]string{"OPKSSH_DEFAULT": providerAlias1, "OPKSSH_PROVIDERS": allProvidersStr},
+			providerArg: providerAlias2,
+			wantIssuer:  providerIssuer2,
+		},
+		{
+			name:          "Provider arg and a different alias",
+			providerArg:   "gitlab",
+			providerAlias: "google",
+			wantError:     true,
+			errorString:   "both --provider gitlab and alias google were given, use only one",
+		},
+		{
+			name:        "Provider arg is neither an alias nor a specification",
+			providerArg: "work",
+			wantError:   true,
+			errorString: "It is not the alias of a provider either, run opkssh login --list-providers",
+		},
 		{
 			name:              "Good path remoteRedirectURI set (when provider arg specified)",
 			envVars:           map[string]string{"OPKSSH_DEFAULT": providerAlias3, "OPKSSH_PROVIDERS": allProvidersStr},
@@ -451,6 +722,25 @@ func TestDetermineProvider(t *testing.T) {
 	}
 }
 
+func TestListProviders(t *testing.T) {
+	defaultConfig, err := config.NewClientConfig(config.DefaultClientConfig)
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	loginCmd := LoginCmd{Config: defaultConfig, OutWriter: out}
+	require.NoError(t, loginCmd.ListProviders())
+	require.Contains(t, out.String(), "ALIAS            ISSUER")
+	require.Contains(t, out.String(), "azure,microsoft  https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0  096ce0a3-5e72-4da8-9c86-12924b294a01")
+	require.Contains(t, out.String(), "Default provider: webchooser\n")
+
+	t.Setenv("OPKSSH_PROVIDERS", allProvidersStr)
+	t.Setenv("OPKSSH_DEFAULT", providerAlias3)
+	out.Reset()
+	require.NoError(t, loginCmd.ListProviders())
+	require.Contains(t, out.String(), providerAlias1+"    "+providerIssuer1)
+	require.NotContains(t, out.String(), "gitlab")
+	require.Contains(t, out.String(), "Default provider: "+providerAlias3+"\n")
+}
+
 func TestNewLogin(t *testing.T) {
 	autoRefresh := false
 	configPathArg := filepath.Join("..", "default-client-config.yml")
@@ -467,7 +757,7 @@ func TestNewLogin(t *testing.T) {
 	keyTypeArg := ECDSA
 	remoteRedirectURIArg := ""
 
-	loginCmd := NewLogin(autoRefresh, configPathArg, createConfig, configureArg, logDir,
+	loginCmd := NewLogin(NewRuntime(), autoRefresh, configPathArg, createConfig, configureArg, logDir,
 		sendAccessTokenArg, disableBrowserOpenArg, printIdTokenArg, providerArg, keyAsOutputArg, keyPathArg, providerAlias, keyTypeArg, remoteRedirectURIArg, false)
 	require.NotNil(t, loginCmd)
 }
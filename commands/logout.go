@@ -32,14 +32,16 @@ type LogoutCmd struct {
 	Fs         afero.Fs
 	KeyPathArg string // Optional: specific key path to remove
 	Verbosity  int    // Default verbosity is 0, 1 is verbose
+	// TokenStore, if set, is cleared when all keys are removed
+	TokenStore TokenStore
 	OutWriter  io.Writer
 	ErrWriter  io.Writer
 }
 
 // NewLogoutCmd creates a new LogoutCmd instance.
-func NewLogoutCmd(keyPathArg string) *LogoutCmd {
+func NewLogoutCmd(rt *Runtime, keyPathArg string) *LogoutCmd {
 	return &LogoutCmd{
-		Fs:         afero.NewOsFs(),
+		Fs:         rt.Fs,
 		KeyPathArg: keyPathArg,
 	}
 }
@@ -80,6 +82,12 @@ func (l *LogoutCmd) Run() error {
 	}
 	removedCount += n
 
+	if l.TokenStore != nil {
+		if err := l.TokenStore.Delete(); err != nil {
+			fmt.Fprintf(l.errOut(), "Warning: failed to delete stored token: %v\n", err)
+		}
+	}
+
 	if removedCount == 0 {
 		fmt.Fprintln(l.out(), "No opkssh keys found to remove")
 	} else {
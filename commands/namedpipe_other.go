@@ -0,0 +1,44 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNamedPipe = errors.New("named pipes are only available on Windows")
+
+func listenNamedPipe(path string, sddl string) (net.Listener, error) {
+	return nil, errNamedPipe
+}
+
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	return nil, errNamedPipe
+}
+
+func checkNamedPipeServer(conn net.Conn) error {
+	return errNamedPipe
+}
+
+func namedPipeSecurityDescriptor(accounts []string) (string, error) {
+	return "", errNamedPipe
+}
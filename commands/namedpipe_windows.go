@@ -0,0 +1,96 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+// listenNamedPipe listens on the named pipe path, which can be used by the
+// accounts granted access by the SDDL security descriptor sddl. winio creates
+// the first instance of the pipe with FILE_CREATE, the equivalent of
+// FILE_FLAG_FIRST_PIPE_INSTANCE, so this fails if another process already
+// created a pipe of that name.
+func listenNamedPipe(path string, sddl string) (net.Listener, error) {
+	listener, err := winio.ListenPipe(path, &winio.PipeConfig{SecurityDescriptor: sddl})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the named pipe %s, check that no other process serves it: %w", path, err)
+	}
+	return listener, nil
+}
+
+func dialNamedPipe(ctx context.Context, path string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, path)
+}
+
+// checkNamedPipeServer returns an error unless the process serving the named
+// pipe conn runs as SYSTEM or as the opkssh service. Any user can create a
+// pipe of a name that is not in use, such as while the service restarts.
+func checkNamedPipeServer(conn net.Conn) error {
+	pipe, ok := conn.(interface{ Fd() uintptr })
+	if !ok {
+		return fmt.Errorf("not a named pipe")
+	}
+	var pid uint32
+	if err := windows.GetNamedPipeServerProcessId(windows.Handle(pipe.Fd()), &pid); err != nil {
+		return fmt.Errorf("failed to get the process serving the named pipe: %w", err)
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return fmt.Errorf("failed to open the process %d serving the named pipe: %w", pid, err)
+	}
+	defer windows.CloseHandle(process)
+	var token windows.Token
+	if err := windows.OpenProcessToken(process, windows.TOKEN_QUERY, &token); err != nil {
+		return fmt.Errorf("failed to open the token of the process %d serving the named pipe: %w", pid, err)
+	}
+	defer token.Close()
+	tokenUser, err := token.GetTokenUser()
+	if err != nil {
+		return fmt.Errorf("failed to get the user of the process %d serving the named pipe: %w", pid, err)
+	}
+	sid := tokenUser.User.Sid
+	if sid.IsWellKnown(windows.WinLocalSystemSid) {
+		return nil
+	}
+	if serviceSID, _, _, err := windows.LookupSID("", `NT SERVICE\`+ServiceName); err == nil && sid.Equals(serviceSID) {
+		return nil
+	}
+	return fmt.Errorf("the named pipe is served by process %d running as %s, not SYSTEM or the %s service", pid, sid, ServiceName)
+}
+
+// namedPipeSecurityDescriptor returns a security descriptor that gives
+// SYSTEM, the Administrators and accounts full access
+func namedPipeSecurityDescriptor(accounts []string) (string, error) {
+	sddl := "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+	for _, account := range accounts {
+		sid, _, _, err := windows.LookupSID("", account)
+		if err != nil {
+			return "", fmt.Errorf("failed to find account %s: %w", account, err)
+		}
+		sddl += "(A;;GA;;;" + sid.String() + ")"
+	}
+	return sddl, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/spf13/afero"
+)
+
+// ConfigureNotifications subscribes a sink to hub for each notification
+// configured in the server config
+func ConfigureNotifications(hub *events.Hub, notifications []config.NotificationConfig) error {
+	for _, n := range notifications {
+		var sink events.Sink
+		switch n.Type {
+		case "webhook":
+			if n.URL == "" {
+				return fmt.Errorf("webhook notification requires url")
+			}
+			sink = &events.WebhookSink{URL: n.URL}
+		case "slack":
+			if n.URL == "" {
+				return fmt.Errorf("slack notification requires url")
+			}
+			sink = &events.SlackSink{URL: n.URL}
+		case "smtp":
+			if n.SMTPAddr == "" || n.SMTPFrom == "" || len(n.SMTPTo) == 0 {
+				return fmt.Errorf("smtp notification requires smtp_addr, smtp_from and smtp_to")
+			}
+			sink = &events.SMTPSink{
+				Addr:     n.SMTPAddr,
+				From:     n.SMTPFrom,
+				To:       n.SMTPTo,
+				Username: n.SMTPUsername,
+				Password: n.SMTPPassword,
+			}
+		default:
+			return fmt.Errorf("unknown notification type %q, expected webhook, slack or smtp", n.Type)
+		}
+
+		types := []events.Type{}
+		for _, e := range n.Events {
+			types = append(types, events.Type(e))
+		}
+		hub.Subscribe(sink, types...)
+	}
+	return nil
+}
+
+// ConfigureNotificationsFromServerConfig reads the server config at path and
+// configures the process wide event hub with its notifications. This is used
+// by commands other than verify, which reads the server config itself.
+func ConfigureNotificationsFromServerConfig(fsys afero.Fs, path string) error {
+	serverConfig, err := LoadServerConfig(fsys, path)
+	if err != nil || serverConfig == nil {
+		return err
+	}
+	return ConfigureNotifications(events.Default(), serverConfig.Notifications)
+}
@@ -0,0 +1,72 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureNotifications(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		notifications []config.NotificationConfig
+		errorString   string
+	}{
+		{
+			name: "all sink types",
+			notifications: []config.NotificationConfig{
+				{Type: "webhook", URL: "https://hooks.example.com/opkssh", Events: []string{"access_denied"}},
+				{Type: "slack", URL: "https://hooks.slack.com/services/T/B/X"},
+				{Type: "smtp", SMTPAddr: "smtp.example.com:25", SMTPFrom: "opkssh@example.com", SMTPTo: []string{"admin@example.com"}},
+			},
+		},
+		{
+			name:          "webhook without url",
+			notifications: []config.NotificationConfig{{Type: "webhook"}},
+			errorString:   "webhook notification requires url",
+		},
+		{
+			name:          "smtp without recipients",
+			notifications: []config.NotificationConfig{{Type: "smtp", SMTPAddr: "smtp.example.com:25", SMTPFrom: "opkssh@example.com"}},
+			errorString:   "smtp notification requires",
+		},
+		{
+			name:          "unknown type",
+			notifications: []config.NotificationConfig{{Type: "pager"}},
+			errorString:   "unknown notification type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ConfigureNotifications(events.NewHub(), tt.notifications)
+			if tt.errorString != "" {
+				require.ErrorContains(t, err, tt.errorString)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
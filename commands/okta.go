@@ -0,0 +1,342 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/policy"
+)
+
+const (
+	defaultOktaListen       = "127.0.0.1:8089"
+	defaultOktaPollInterval = 30 * time.Second
+	// maxOktaHookBody bounds the size of an event hook request
+	maxOktaHookBody = 1 << 20
+)
+
+// oktaRevokingEvents are the Okta System Log event types after which a
+// user's logins must be denied
+var oktaRevokingEvents = []string{
+	"user.lifecycle.suspend",
+	"user.lifecycle.deactivate",
+}
+
+// OktaEvent is the subset of an Okta System Log event used by opkssh. Event
+// hooks deliver the same object as the System Log API.
+type OktaEvent struct {
+	UUID      string            `json:"uuid"`
+	EventType string            `json:"eventType"`
+	Published time.Time         `json:"published"`
+	Target    []OktaEventTarget `json:"target"`
+}
+
+// OktaEventTarget is an entity an OktaEvent acted on
+type OktaEventTarget struct {
+	// ID is the Okta user id, which is the sub claim of Okta ID Tokens
+	ID string `json:"id"`
+	// Type is User for the events opkssh handles
+	Type string `json:"type"`
+	// AlternateID is the user's login, usually their email
+	AlternateID string `json:"alternateId"`
+}
+
+// OktaRevokeCmd revokes identities as soon as their Okta user is suspended
+// or deactivated, instead of waiting for their PK Token to expire
+type OktaRevokeCmd struct {
+	Config             config.OktaConfig
+	Revocations        *policy.RevocationList
+	SystemPolicyLoader *policy.SystemPolicyLoader
+	// Journal, if set, records entries pruned from the system policy
+	Journal    *policy.Journal
+	HttpClient *http.Client
+	Logger     *slog.Logger
+	// Health, if set, is served on /healthz by Serve
+	Health http.Handler
+
+	// mu serializes updates as hook requests may arrive concurrently
+	mu sync.Mutex
+}
+
+// NewOktaRevokeCmd creates a new OktaRevokeCmd using the default revocation
+// list, system policy and journal
+func NewOktaRevokeCmd(rt *Runtime, cfg config.OktaConfig) *OktaRevokeCmd {
+	return &OktaRevokeCmd{
+		Config:             cfg,
+		Revocations:        policy.NewRevocationList(),
+		SystemPolicyLoader: policy.NewSystemPolicyLoader(),
+		Journal:            policy.NewJournal(),
+		HttpClient:         &http.Client{Timeout: 30 * time.Second},
+		Logger:             rt.Logger,
+	}
+}
+
+// HandleEvent revokes the users targeted by e if it is a suspension or
+// deactivation. Other events are ignored. Returns the number of users revoked.
+func (o *OktaRevokeCmd) HandleEvent(e OktaEvent) (int, error) {
+	if !slices.Contains(oktaRevokingEvents, e.EventType) {
+		return 0, nil
+	}
+	if o.Config.Issuer == "" {
+		return 0, fmt.Errorf("okta issuer is not configured")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	revoked := 0
+	for _, target := range e.Target {
+		if target.Type != "User" {
+			continue
+		}
+		err := o.Revocations.Revoke(policy.RevokedIdentity{
+			Issuer:  o.Config.Issuer,
+			Subject: target.ID,
+			Email:   target.AlternateID,
+			Reason:  e.EventType,
+		})
+		if err != nil {
+			return revoked, err
+		}
+		events.Emit(events.IdentityRevoked, map[string]string{
+			"issuer":   o.Config.Issuer,
+			"sub":      target.ID,
+			"identity": target.AlternateID,
+			"reason":   e.EventType,
+		})
+		revoked++
+
+		if o.Config.PrunePolicy {
+			if err := o.prunePolicy(target, e.EventType); err != nil {
+				return revoked, err
+			}
+		}
+	}
+	return revoked, nil
+}
+
+// prunePolicy removes the entries of target from the system policy
+func (o *OktaRevokeCmd) prunePolicy(target OktaEventTarget, reason string) error {
+	lock, err := o.SystemPolicyLoader.FileLoader.Lock(o.SystemPolicyLoader.Path())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	systemPolicy, _, err := o.SystemPolicyLoader.LoadSystemPolicy()
+	if err != nil {
+		return fmt.Errorf("failed to load system policy: %w", err)
+	}
+	removed := systemPolicy.RemoveIdentity(o.Config.Issuer, target.AlternateID, target.ID)
+	if len(removed) == 0 {
+		return nil
+	}
+	if err := o.SystemPolicyLoader.Dump(systemPolicy, o.SystemPolicyLoader.Path()); err != nil {
+		return fmt.Errorf("failed to write updated policy: %w", err)
+	}
+
+	summary := []string{}
+	for _, user := range removed {
+		summary = append(summary, fmt.Sprintf("- %s %s %s", strings.Join(user.Principals, ","), user.IdentityAttribute, user.Issuer))
+	}
+	if o.Journal != nil {
+		if err := o.Journal.Append(policy.JournalEntry{
+			Action:  "revoke",
+			Path:    o.SystemPolicyLoader.Path(),
+			Summary: append(summary, "okta event "+reason),
+		}); err != nil {
+			o.Logger.Warn("Failed to record change in policy journal", "error", err)
+		}
+	}
+	events.Emit(events.PolicyChanged, map[string]string{
+		"path":     o.SystemPolicyLoader.Path(),
+		"action":   "revoke",
+		"identity": target.AlternateID,
+		"issuer":   o.Config.Issuer,
+	})
+	return nil
+}
+
+// ServeHTTP implements the Okta event hook protocol. GET requests are the
+// one time verification of the endpoint, POST requests deliver events.
+func (o *OktaRevokeCmd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if o.Config.HookSecret == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(o.Config.HookSecret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		challenge := r.Header.Get("X-Okta-Verification-Challenge")
+		if challenge == "" {
+			http.Error(w, "missing verification challenge", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"verification": challenge})
+	case http.MethodPost:
+		var hook struct {
+			Data struct {
+				Events []OktaEvent `json:"events"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOktaHookBody)).Decode(&hook); err != nil {
+			http.Error(w, "invalid event hook body", http.StatusBadRequest)
+			return
+		}
+		for _, e := range hook.Data.Events {
+			if _, err := o.HandleEvent(e); err != nil {
+				// Okta retries the delivery once when it fails
+				o.Logger.Error("Failed to handle Okta event", "uuid", e.UUID, "event_type", e.EventType, "error", err)
+				http.Error(w, "failed to handle event", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Serve listens for Okta event hooks until ctx is cancelled
+func (o *OktaRevokeCmd) Serve(ctx context.Context) error {
+	if o.Config.HookSecret == "" {
+		return fmt.Errorf("okta hook_secret must be configured to receive event hooks")
+	}
+	listen := o.Config.Listen
+	if listen == "" {
+		listen = defaultOktaListen
+	}
+	var handler http.Handler = o
+	if o.Health != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", o.Health)
+		mux.Handle("/", o)
+		handler = mux
+	}
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	o.Logger.Info("Listening for Okta event hooks", "addr", listen)
+	var err error
+	if o.Config.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(o.Config.TLSCertFile, o.Config.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// PollOnce fetches the revoking events published at or after since from the
+// Okta System Log and handles them. Returns the time to poll from next.
+func (o *OktaRevokeCmd) PollOnce(ctx context.Context, since time.Time) (time.Time, error) {
+	if o.Config.OrgURL == "" || o.Config.APIToken == "" {
+		return since, fmt.Errorf("okta org_url and api_token must be configured to poll the system log")
+	}
+	filters := []string{}
+	for _, eventType := range oktaRevokingEvents {
+		filters = append(filters, fmt.Sprintf("eventType eq %q", eventType))
+	}
+	query := url.Values{}
+	query.Set("since", since.UTC().Format(time.RFC3339Nano))
+	query.Set("filter", strings.Join(filters, " or "))
+	query.Set("sortOrder", "ASCENDING")
+	query.Set("limit", "1000")
+	logsURL := strings.TrimSuffix(o.Config.OrgURL, "/") + "/api/v1/logs?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, logsURL, nil)
+	if err != nil {
+		return since, err
+	}
+	req.Header.Set("Authorization", "SSWS "+o.Config.APIToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.HttpClient.Do(req)
+	if err != nil {
+		return since, fmt.Errorf("failed to query okta system log: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return since, fmt.Errorf("okta system log returned %s", resp.Status)
+	}
+
+	var logEvents []OktaEvent
+	if err := json.NewDecoder(resp.Body).Decode(&logEvents); err != nil {
+		return since, fmt.Errorf("failed to parse okta system log: %w", err)
+	}
+	for _, e := range logEvents {
+		if _, err := o.HandleEvent(e); err != nil {
+			return since, err
+		}
+		// Published has millisecond precision and since is inclusive
+		if next := e.Published.Add(time.Millisecond); next.After(since) {
+			since = next
+		}
+	}
+	return since, nil
+}
+
+// Poll handles revoking events from the Okta System Log every PollInterval
+// until ctx is cancelled. Events published before Poll starts are ignored.
+func (o *OktaRevokeCmd) Poll(ctx context.Context) error {
+	interval := defaultOktaPollInterval
+	if o.Config.PollInterval != "" {
+		var err error
+		if interval, err = time.ParseDuration(o.Config.PollInterval); err != nil {
+			return fmt.Errorf("invalid okta poll_interval: %w", err)
+		}
+	}
+
+	since := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var err error
+		if since, err = o.PollOnce(ctx, since); err != nil {
+			o.Logger.Error("Failed to poll Okta system log", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
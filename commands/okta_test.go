@@ -0,0 +1,155 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oktaTestIssuer = "https://example.okta.com"
+	oktaTestPolicy = "root alice@example.com https://example.okta.com\n" +
+		"dev alice@example.com https://example.okta.com\n" +
+		"dev bob@example.com https://example.okta.com\n"
+	oktaTestHook = `{"eventType":"com.okta.event_hook","data":{"events":[{
+	"uuid":"1d8a0b9c-0000-11ef-8000-000000000001",
+	"eventType":"user.lifecycle.suspend",
+	"published":"2026-10-14T12:00:00.000Z",
+	"target":[{"id":"00u1alice","type":"User","alternateId":"alice@example.com"}]
+}]}}`
+)
+
+func mockOktaRevokeCmd(t *testing.T) (*OktaRevokeCmd, afero.Fs) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte(oktaTestPolicy), files.ModeSystemPerms))
+
+	return &OktaRevokeCmd{
+		Config: config.OktaConfig{
+			Issuer:      oktaTestIssuer,
+			PrunePolicy: true,
+			HookSecret:  "hook-secret",
+		},
+		Revocations:        &policy.RevocationList{Fs: mockFs, Path: policy.SystemDefaultRevocationPath},
+		SystemPolicyLoader: MockAddCmd(mockFs).SystemPolicyLoader,
+		Journal:            &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath},
+		Logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HttpClient:         http.DefaultClient,
+	}, mockFs
+}
+
+func TestOktaHookVerification(t *testing.T) {
+	t.Parallel()
+	o, _ := mockOktaRevokeCmd(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Okta-Verification-Challenge", "challenge-value")
+	rec := httptest.NewRecorder()
+	o.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req.Header.Set("Authorization", "hook-secret")
+	rec = httptest.NewRecorder()
+	o.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Equal(t, "challenge-value", body["verification"])
+}
+
+func TestOktaHookRevokes(t *testing.T) {
+	t.Parallel()
+	o, mockFs := mockOktaRevokeCmd(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(oktaTestHook))
+	req.Header.Set("Authorization", "hook-secret")
+	rec := httptest.NewRecorder()
+	o.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	revoked, err := o.Revocations.Entries()
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+	require.True(t, revoked[0].Matches(oktaTestIssuer, "00u1alice", ""))
+	require.Equal(t, "user.lifecycle.suspend", revoked[0].Reason)
+
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "alice@example.com")
+	require.Contains(t, string(content), "bob@example.com")
+
+	entries, err := o.Journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "revoke", entries[0].Action)
+	require.Contains(t, entries[0].Summary, "- root alice@example.com https://example.okta.com")
+}
+
+func TestOktaIgnoresOtherEvents(t *testing.T) {
+	t.Parallel()
+	o, _ := mockOktaRevokeCmd(t)
+
+	count, err := o.HandleEvent(OktaEvent{
+		EventType: "user.session.start",
+		Target:    []OktaEventTarget{{ID: "00u1alice", Type: "User", AlternateID: "alice@example.com"}},
+	})
+	require.NoError(t, err)
+	require.Zero(t, count)
+
+	revoked, err := o.Revocations.Entries()
+	require.NoError(t, err)
+	require.Empty(t, revoked)
+}
+
+func TestOktaPollOnce(t *testing.T) {
+	t.Parallel()
+	o, _ := mockOktaRevokeCmd(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v1/logs", r.URL.Path)
+		require.Equal(t, "SSWS api-token", r.Header.Get("Authorization"))
+		require.Contains(t, r.URL.Query().Get("filter"), `eventType eq "user.lifecycle.deactivate"`)
+		_, _ = w.Write([]byte(`[{"uuid":"1","eventType":"user.lifecycle.deactivate","published":"2026-10-14T12:00:00.000Z",
+			"target":[{"id":"00u1bob","type":"User","alternateId":"bob@example.com"}]}]`))
+	}))
+	defer server.Close()
+	o.Config.OrgURL = server.URL
+	o.Config.APIToken = "api-token"
+
+	since := time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)
+	next, err := o.PollOnce(context.Background(), since)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2026, 10, 14, 12, 0, 0, int(time.Millisecond), time.UTC), next.UTC())
+
+	revoked, err := o.Revocations.Entries()
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+	require.Equal(t, "bob@example.com", revoked[0].Email)
+}
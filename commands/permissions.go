@@ -17,59 +17,103 @@
 package commands
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
+	"github.com/openpubkey/opkssh/internal/eventlog"
+	"github.com/openpubkey/opkssh/internal/events"
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
-	"github.com/openpubkey/opkssh/policy/plugins"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-// defaultConfirmPrompt reads a yes/no answer from stdin.
-func defaultConfirmPrompt(prompt string, in io.Reader) (bool, error) {
-	fmt.Print(prompt)
-	r := bufio.NewReader(in)
-	s, err := r.ReadString('\n')
-	if err != nil {
-		return false, err
-	}
-	s = strings.TrimSpace(strings.ToLower(s))
-	return s == "y" || s == "yes", nil
-}
-
 // PermissionsCmd provides functionality to check and fix file permissions
 type PermissionsCmd struct {
-	FileSystem    files.FileSystem
-	Out           io.Writer
-	ErrOut        io.Writer
-	In            io.Reader
-	IsElevatedFn  func() (bool, error)
-	ConfirmPrompt func(string, io.Reader) (bool, error)
+	FileSystem   files.FileSystem
+	Out          io.Writer
+	ErrOut       io.Writer
+	IsElevatedFn func() (bool, error)
+	Prompter     Prompter
+	UserLookup   policy.UserLookup
+	// ServerConfigPath is read to configure notifications for detected
+	// permission drift, empty disables notifications
+	ServerConfigPath string
+	// Journal, if set, records changes applied by fix
+	Journal *policy.Journal
+	// Executable is the opkssh binary sshd runs, its SELinux type is
+	// checked with the managed paths
+	Executable string
+	// HomeDirs, if set, lists the home directories whose ~/.opk/auth_id is
+	// checked
+	HomeDirs func() ([]userHomeEntry, error)
+	// HomePolicy selects the users whose home policy verify reads, only
+	// those are checked
+	HomePolicy policy.HomePolicyAccess
+	// Groups resolves the groups of HomePolicy, nil uses
+	// policy.NewOsGroupLookup
+	Groups policy.GroupLookup
 
 	// Flags
 	DryRun     bool
 	Yes        bool
 	Verbose    bool
 	JsonOutput bool
+	// Format is the output format of check, text or json
+	Format string
+	// Immutable sets the system immutable flag on the files fix changes
+	Immutable bool
+	// Strict makes fix also remove the ACEs that grant access to anyone
+	// the expected ACL doesn't
+	Strict bool
+	// User makes fix repair only the home policy of this user
+	User string
+	// Paths makes fix repair only these managed paths, given by name or
+	// by path. Empty repairs every managed path.
+	Paths []string
+	// EmitScript makes check print a bash or powershell script of the
+	// changes fix would make instead of checking
+	EmitScript string
+	// Output makes check write its results and the changes fix would make
+	// to this file instead of printing them
+	Output string
+	// FromReport makes fix apply the changes in this report written by
+	// check --output, if they are still changes fix would make
+	FromReport string
+	// Quiet makes check print nothing, only its exit code tells the result
+	Quiet bool
 }
 
+// Exit codes of permissions check, for monitoring agents
+const (
+	PermissionsCheckOK       = 0
+	PermissionsCheckProblems = 1
+	PermissionsCheckError    = 2
+)
+
 // NewPermissionsCmd creates a new PermissionsCmd with default settings
-func NewPermissionsCmd(out io.Writer, errOut io.Writer) *PermissionsCmd {
+func NewPermissionsCmd(rt *Runtime) *PermissionsCmd {
+	exe, _ := os.Executable()
 	return &PermissionsCmd{
-		FileSystem:    files.NewFileSystem(afero.NewOsFs()),
-		Out:           out,
-		ErrOut:        errOut,
-		In:            os.Stdin,
-		IsElevatedFn:  IsElevated,
-		ConfirmPrompt: defaultConfirmPrompt,
+		FileSystem:       files.NewFileSystem(rt.Fs),
+		Out:              rt.Out,
+		ErrOut:           rt.ErrOut,
+		IsElevatedFn:     IsElevated,
+		Prompter:         rt.Prompter,
+		UserLookup:       rt.UserLookup,
+		ServerConfigPath: policy.Defaults.ConfigPath,
+		Journal:          policy.NewJournal(),
+		Executable:       exe,
+		HomeDirs:         (&AuditCmd{Fs: files.NewFileSystem(rt.Fs)}).enumerateUserHomeDirs,
 	}
 }
 
@@ -84,11 +128,34 @@ func (p *PermissionsCmd) CobraCommand() *cobra.Command {
 	checkCmd := &cobra.Command{
 		Use:   "check",
 		Short: "Verify permissions and ownership for opkssh files",
+		Long: fmt.Sprintf(`Check verifies the permissions and ownership of the opkssh files.
+
+Exit codes:
+  %d  no problems found
+  %d  problems found
+  %d  the check could not be run, for instance because of an invalid flag`, PermissionsCheckOK, PermissionsCheckProblems, PermissionsCheckError),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return p.Check()
+			if p.Quiet {
+				p.Out, p.ErrOut = io.Discard, io.Discard
+				log.SetOutput(io.Discard)
+				cmd.SilenceErrors, cmd.SilenceUsage = true, true
+			}
+			err := p.runCheck()
+			var exitErr *ExitCodeError
+			if err != nil && !errors.As(err, &exitErr) {
+				return &ExitCodeError{Code: PermissionsCheckError, Err: err}
+			}
+			return err
 		},
 	}
-	checkCmd.Flags().BoolVarP(&p.JsonOutput, "json", "j", false, "Output results in JSON")
+	checkCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &ExitCodeError{Code: PermissionsCheckError, Err: err}
+	})
+	checkCmd.Flags().BoolVarP(&p.JsonOutput, "json", "j", false, "Output results in JSON, same as --format json")
+	checkCmd.Flags().StringVar(&p.Format, "format", "text", "Output format: text or json. json includes the owner, mode, ACEs and problems of each path")
+	checkCmd.Flags().StringVar(&p.EmitScript, "emit-script", "", "Print a script (bash or powershell) that makes the changes fix would make, instead of checking")
+	checkCmd.Flags().BoolVarP(&p.Quiet, "quiet", "q", false, "Print nothing, only the exit code tells the result")
+	checkCmd.Flags().StringVar(&p.Output, "output", "", "Write the results and the changes fix would make to this file, for permissions fix --from-report. Requires --format json")
 
 	fixCmd := &cobra.Command{
 		Use:   "fix",
@@ -101,6 +168,11 @@ func (p *PermissionsCmd) CobraCommand() *cobra.Command {
 	fixCmd.Flags().BoolVarP(&p.Yes, "yes", "y", false, "Apply changes without confirmation")
 	fixCmd.Flags().BoolVarP(&p.Verbose, "verbose", "v", false, "Verbose output")
 	fixCmd.Flags().BoolVarP(&p.JsonOutput, "json", "j", false, "Output results in JSON")
+	fixCmd.Flags().BoolVar(&p.Immutable, "immutable", false, "Make the policy, providers and config files immutable (chflags schg), BSD only")
+	fixCmd.Flags().BoolVar(&p.Strict, "strict", false, "Also remove the ACL entries that grant access to other users and groups")
+	fixCmd.Flags().StringVar(&p.User, "user", "", "Only fix the ~/.opk directory and auth_id of this user")
+	fixCmd.Flags().StringArrayVar(&p.Paths, "paths", nil, "Only fix this path, by name ("+strings.Join(managedPathNames(), ", ")+") or by path. Can be repeated")
+	fixCmd.Flags().StringVar(&p.FromReport, "from-report", "", "Apply the changes saved by permissions check --output in this file. The report must be owned by root and its changes still needed")
 
 	installCmd := &cobra.Command{
 		Use:   "install",
@@ -108,11 +180,18 @@ func (p *PermissionsCmd) CobraCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// installers expect non-interactive behavior; force yes=true
 			p.Yes = true
+			if !p.DryRun {
+				// Lets verify report its errors to Event Viewer on Windows
+				if err := eventlog.Install(); err != nil {
+					fmt.Fprintf(p.ErrOut, "warning: %v\n", err)
+				}
+			}
 			return p.Fix()
 		},
 	}
 	installCmd.Flags().BoolVar(&p.DryRun, "dry-run", false, "Don't modify anything; show planned changes")
 	installCmd.Flags().BoolVarP(&p.Verbose, "verbose", "v", false, "Verbose output")
+	installCmd.Flags().BoolVar(&p.Immutable, "immutable", false, "Make the policy, providers and config files immutable (chflags schg), BSD only")
 
 	permissionsCmd.AddCommand(checkCmd)
 	permissionsCmd.AddCommand(fixCmd)
@@ -120,16 +199,137 @@ func (p *PermissionsCmd) CobraCommand() *cobra.Command {
 	return permissionsCmd
 }
 
+// runCheck runs permissions check with the selected output
+func (p *PermissionsCmd) runCheck() error {
+	switch p.Format {
+	case "", "text":
+	case "json":
+		p.JsonOutput = true
+	default:
+		return fmt.Errorf("invalid format %q, expected text or json", p.Format)
+	}
+	if p.EmitScript != "" {
+		return p.EmitFixScript(p.EmitScript)
+	}
+	if p.Output != "" && !p.JsonOutput {
+		return fmt.Errorf("--output requires --format json")
+	}
+	if p.ServerConfigPath != "" {
+		// Notifications are best effort, the server config may not exist
+		_ = ConfigureNotificationsFromServerConfig(afero.NewOsFs(), p.ServerConfigPath)
+		if serverConfig, err := LoadServerConfig(afero.NewOsFs(), p.ServerConfigPath); err == nil && serverConfig != nil {
+			p.HomePolicy = HomePolicyAccess(serverConfig.HomePolicy)
+		}
+	}
+	return p.Check()
+}
+
 // checkResult is the JSON-serializable result of a permissions check.
 type checkResult struct {
 	Path     string `json:"path"`
 	Exists   bool   `json:"exists"`
 	PermsErr string `json:"permsErr,omitempty"`
 	ACLErr   string `json:"aclErr,omitempty"`
+	// ReadOnly is set for distribution defaults, which fix does not change
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// Immutable is set if the system immutable flag is set on the path
+	Immutable bool `json:"immutable,omitempty"`
+	// Symlink is set for a symbolic link in a managed directory
+	Symlink bool `json:"symlink,omitempty"`
+	// PathChain are the directories above the path that a user other than
+	// root can write to
+	PathChain []string `json:"pathChain,omitempty"`
+	// SELinuxType and SELinuxExpected are the SELinux type of the path and
+	// the one sshd needs, empty if SELinux is not enabled
+	SELinuxType     string `json:"selinuxType,omitempty"`
+	SELinuxExpected string `json:"selinuxExpected,omitempty"`
+	// ACL is the ownership and ACL of the path as read by VerifyACL
+	ACL *aclResult `json:"acl,omitempty"`
+}
+
+// aclResult is the JSON form of a files.ACLReport
+type aclResult struct {
+	Owner    string      `json:"owner"`
+	OwnerSID string      `json:"ownerSid,omitempty"`
+	Mode     string      `json:"mode"`
+	ACEs     []aceResult `json:"aces"`
+	Problems []string    `json:"problems"`
+}
+
+type aceResult struct {
+	Principal    string `json:"principal"`
+	PrincipalSID string `json:"principalSid,omitempty"`
+	Type         string `json:"type"`
+	Rights       string `json:"rights"`
+	Inherited    bool   `json:"inherited"`
+}
+
+func newACLResult(report *files.ACLReport) *aclResult {
+	r := &aclResult{
+		Owner:    report.Owner,
+		OwnerSID: report.OwnerSIDStr,
+		Mode:     fmt.Sprintf("%04o", report.Mode.Perm()),
+		ACEs:     []aceResult{},
+		Problems: []string{},
+	}
+	for _, a := range report.ACEs {
+		r.ACEs = append(r.ACEs, aceResult{
+			Principal:    a.Principal,
+			PrincipalSID: a.PrincipalSIDStr,
+			Type:         a.Type,
+			Rights:       a.Rights,
+			Inherited:    a.Inherited,
+		})
+	}
+	r.Problems = append(r.Problems, report.Problems...)
+	return r
 }
 
 // Check verifies permissions and ownership for opkssh files.
 func (p *PermissionsCmd) Check() error {
+	results, problems := p.checkPaths()
+
+	if len(problems) > 0 {
+		events.Emit(events.PermissionDrift, map[string]string{
+			"problems": strconv.Itoa(len(problems)),
+			"detail":   strings.Join(problems, "; "),
+		})
+	}
+
+	if p.Output != "" {
+		if err := p.writeReport(p.Output, results); err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return p.problemsFound(len(problems))
+		}
+		return nil
+	}
+
+	if p.JsonOutput {
+		enc := json.NewEncoder(p.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+
+	if len(problems) > 0 {
+		for _, prob := range problems {
+			fmt.Fprintln(p.Out, "Problem:", prob)
+		}
+		return p.problemsFound(len(problems))
+	}
+	// Success: print nothing and return nil
+	return nil
+}
+
+// problemsFound returns the error of a check that found n problems
+func (p *PermissionsCmd) problemsFound(n int) error {
+	return &ExitCodeError{Code: PermissionsCheckProblems, Err: fmt.Errorf("permissions check failed: %d problems found", n)}
+}
+
+// checkPaths checks every opkssh path and returns the result of each and
+// the problems found. Unless JsonOutput is set the ACLs are printed.
+func (p *PermissionsCmd) checkPaths() ([]checkResult, []string) {
 	var problems []string
 	var results []checkResult
 
@@ -141,6 +341,13 @@ func (p *PermissionsCmd) Check() error {
 			cr.ACLErr = result.ACLErr.Error()
 		} else if result.ACLReport != nil {
 			report := result.ACLReport
+			cr.ACL = newACLResult(report)
+			for _, a := range report.Unexpected {
+				problems = append(problems, fmt.Sprintf("%s: unexpected ACL entry grants %s %s", path, a.Principal, a.Rights))
+			}
+			if p.JsonOutput {
+				return
+			}
 			if report.OwnerSIDStr != "" {
 				fmt.Fprintf(p.Out, "%s: owner=%s ownerSID=%s mode=%o\n", path, report.Owner, report.OwnerSIDStr, report.Mode)
 			} else {
@@ -162,81 +369,188 @@ func (p *PermissionsCmd) Check() error {
 		}
 	}
 
-	// System policy file — use shared permission check
-	sp := files.RequiredPerms.SystemPolicy
-	systemPolicy := policy.SystemDefaultPolicyPath
-	sysResult := CheckFilePermissions(p.FileSystem, systemPolicy, sp)
-	if !sysResult.Exists {
-		problems = append(problems, fmt.Sprintf("%s: file does not exist", systemPolicy))
-		results = append(results, checkResult{Path: systemPolicy, Exists: false})
-	} else {
-		cr := checkResult{Path: systemPolicy, Exists: true, PermsErr: sysResult.PermsErr}
-		if sysResult.PermsErr != "" {
-			problems = append(problems, fmt.Sprintf("%s: %s", systemPolicy, sysResult.PermsErr))
+	// checkSELinux compares the SELinux type of path with the one sshd needs
+	checkSELinux := func(path string, want string, cr *checkResult) {
+		typ, err := p.FileSystem.SELinuxType(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: failed to read SELinux context: %v", path, err))
+			return
+		}
+		if typ == "" || want == "" {
+			return
+		}
+		cr.SELinuxType, cr.SELinuxExpected = typ, want
+		if typ != want {
+			problems = append(problems, fmt.Sprintf("%s: SELinux type is %s, expected %s", path, typ, want))
 		}
-		checkACLResult(systemPolicy, sysResult, &cr)
-		results = append(results, cr)
 	}
 
-	// Providers file
-	providersFile := policy.SystemDefaultProvidersPath
-	pp := files.RequiredPerms.Providers
-	provResult := CheckFilePermissions(p.FileSystem, providersFile, pp)
-	if !provResult.Exists {
-		// not fatal, but report
-		problems = append(problems, fmt.Sprintf("%s: file does not exist", providersFile))
-		results = append(results, checkResult{Path: providersFile, Exists: false})
-	} else {
-		cr := checkResult{Path: providersFile, Exists: true, PermsErr: provResult.PermsErr}
-		if provResult.PermsErr != "" {
-			problems = append(problems, fmt.Sprintf("%s: %s", providersFile, provResult.PermsErr))
+	// checkPathChain checks the directories above path. Paths share most
+	// of them, each problem is only reported once.
+	chainProblems := map[string]bool{}
+	checkPathChain := func(path string, cr *checkResult) {
+		chain, err := p.FileSystem.CheckPathChain(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+		}
+		cr.PathChain = chain
+		for _, prob := range chain {
+			if !chainProblems[prob] {
+				chainProblems[prob] = true
+				problems = append(problems, prob)
+			}
 		}
-		checkACLResult(providersFile, provResult, &cr)
-		results = append(results, cr)
 	}
 
-	// Config file
-	configFile := filepath.Join(policy.GetSystemConfigBasePath(), "config.yml")
-	cp := files.RequiredPerms.Config
-	cfgResult := CheckFilePermissions(p.FileSystem, configFile, cp)
-	if cfgResult.Exists {
-		cr := checkResult{Path: configFile, Exists: true, PermsErr: cfgResult.PermsErr}
-		if cfgResult.PermsErr != "" {
-			problems = append(problems, fmt.Sprintf("%s: %s", configFile, cfgResult.PermsErr))
+	for _, mp := range policy.ManagedPaths() {
+		if mp.Dir {
+			if _, err := p.FileSystem.Stat(mp.Path); err != nil {
+				if mp.Required {
+					problems = append(problems, fmt.Sprintf("%s: %v", mp.Path, err))
+					results = append(results, checkResult{Path: mp.Path, Exists: false, PermsErr: err.Error()})
+				}
+				continue
+			}
+			cr := checkResult{Path: mp.Path, Exists: true, ReadOnly: policy.IsVendorConfigPath(mp.Path)}
+			if err := p.FileSystem.CheckPerm(mp.Path, mp.AllowedModes(), mp.Perm.Owner, mp.Perm.Group); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", mp.Path, err))
+				cr.PermsErr = err.Error()
+			}
+			checkSELinux(mp.Path, mp.SELinuxType, &cr)
+			checkPathChain(mp.Path, &cr)
+			results = append(results, cr)
+
+			for _, e := range dirEntries(p.FileSystem, mp) {
+				ecr := checkResult{Path: e.Path, Exists: true, ReadOnly: cr.ReadOnly}
+				switch {
+				case e.Symlink:
+					// Links are followed when the directory is read, the
+					// file checked would not be the one used
+					ecr.Symlink = true
+					problems = append(problems, fmt.Sprintf("%s: is a symbolic link, replace it with the file it points to", e.Path))
+				case e.Dir:
+					if err := p.FileSystem.CheckPerm(e.Path, mp.AllowedModes(), mp.Perm.Owner, mp.Perm.Group); err != nil {
+						problems = append(problems, fmt.Sprintf("%s: %v", e.Path, err))
+						ecr.PermsErr = err.Error()
+					}
+				case e.Command:
+					if err := p.FileSystem.CheckPerm(e.Path, mp.CommandModes, mp.EntryPerm.Owner, ""); err != nil {
+						problems = append(problems, fmt.Sprintf("%s: policy plugin command: %v", e.Path, err))
+						ecr.PermsErr = err.Error()
+					}
+					checkPathChain(e.Path, &ecr)
+				default:
+					result := CheckFilePermissions(p.FileSystem, e.Path, mp.EntryPerm)
+					if result.PermsErr != "" {
+						problems = append(problems, fmt.Sprintf("%s: %s", e.Path, result.PermsErr))
+						ecr.PermsErr = result.PermsErr
+					}
+					checkACLResult(e.Path, result, &ecr)
+				}
+				results = append(results, ecr)
+			}
+			continue
+		}
+
+		result := CheckFilePermissions(p.FileSystem, mp.Path, mp.Perm)
+		if !result.Exists {
+			if mp.Required {
+				problems = append(problems, fmt.Sprintf("%s: file does not exist", mp.Path))
+				results = append(results, checkResult{Path: mp.Path, Exists: false})
+			}
+			continue
 		}
-		checkACLResult(configFile, cfgResult, &cr)
+		cr := checkResult{Path: mp.Path, Exists: true, PermsErr: result.PermsErr, ReadOnly: policy.IsVendorConfigPath(mp.Path)}
+		cr.Immutable, _ = p.FileSystem.IsImmutable(mp.Path)
+		if result.PermsErr != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", mp.Path, result.PermsErr))
+		}
+		checkACLResult(mp.Path, result, &cr)
+		checkSELinux(mp.Path, mp.SELinuxType, &cr)
+		checkPathChain(mp.Path, &cr)
 		results = append(results, cr)
 	}
 
-	// Policy plugins dir
-	pluginsDir := filepath.Join(policy.GetSystemConfigBasePath(), "policy.d")
-	if _, err := p.FileSystem.Stat(pluginsDir); err != nil {
-		problems = append(problems, fmt.Sprintf("%s: %v", pluginsDir, err))
-		results = append(results, checkResult{Path: pluginsDir, Exists: false, PermsErr: err.Error()})
-	} else {
-		cr := checkResult{Path: pluginsDir, Exists: true}
-		// Check directory perms using plugin package expectations
-		if err := p.FileSystem.CheckPerm(pluginsDir, plugins.RequiredPolicyDirPerms(), files.RequiredPerms.PluginsDir.Owner, files.RequiredPerms.PluginsDir.Group); err != nil {
-			problems = append(problems, fmt.Sprintf("%s: %v", pluginsDir, err))
-			cr.PermsErr = err.Error()
+	// Home policies verify doesn't read can't grant access, their
+	// permissions don't matter
+	if p.HomeDirs != nil && !p.HomePolicy.Disabled {
+		homes, err := p.HomeDirs()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("failed to list the home directories: %v", err))
+		}
+		for _, home := range homes {
+			if p.HomePolicy.Check(home.Username, p.Groups) != nil {
+				continue
+			}
+			path := filepath.Join(home.HomeDir, ".opk", "auth_id")
+			perm := files.RequiredPerms.HomePolicy
+			perm.Owner = home.Username
+			result := CheckFilePermissions(p.FileSystem, path, perm)
+			if !result.Exists {
+				continue
+			}
+			cr := checkResult{Path: path, Exists: true, PermsErr: result.PermsErr}
+			if result.PermsErr != "" {
+				problems = append(problems, fmt.Sprintf("%s: %s, fix it with opkssh permissions fix --user %s", path, result.PermsErr, home.Username))
+			}
+			checkACLResult(path, result, &cr)
+			results = append(results, cr)
 		}
-		results = append(results, cr)
 	}
 
-	if p.JsonOutput {
-		enc := json.NewEncoder(p.Out)
-		enc.SetIndent("", "  ")
-		return enc.Encode(results)
+	for _, sp := range p.selinuxPaths() {
+		if _, err := p.FileSystem.Stat(sp.Path); err != nil {
+			continue
+		}
+		cr := checkResult{Path: sp.Path, Exists: true}
+		checkSELinux(sp.Path, sp.SELinuxType, &cr)
+		if cr.SELinuxType != "" {
+			results = append(results, cr)
+		}
 	}
+	return results, problems
+}
 
-	if len(problems) > 0 {
-		for _, prob := range problems {
-			fmt.Fprintln(p.Out, "Problem:", prob)
+// selinuxPaths returns the paths besides the managed paths that need an
+// SELinux type: the config directory, where files get their type from, and
+// the opkssh binary sshd runs as the AuthorizedKeysCommand
+func (p *PermissionsCmd) selinuxPaths() []policy.ManagedPath {
+	paths := []policy.ManagedPath{{Path: policy.GetSystemConfigBasePath(), Dir: true, SELinuxType: files.SELinuxConfigType}}
+	if p.Executable != "" {
+		paths = append(paths, policy.ManagedPath{Path: p.Executable, SELinuxType: files.SELinuxBinaryType})
+	}
+	return paths
+}
+
+// managedPathNames returns the names of the managed paths
+func managedPathNames() []string {
+	var names []string
+	for _, mp := range policy.ManagedPaths() {
+		names = append(names, mp.Name)
+	}
+	return names
+}
+
+// selectPaths returns the managed paths selected by Paths, or nil if Paths
+// is empty and every path is selected
+func (p *PermissionsCmd) selectPaths() (map[string]bool, error) {
+	if len(p.Paths) == 0 {
+		return nil, nil
+	}
+	selected := map[string]bool{}
+	for _, arg := range p.Paths {
+		found := false
+		for _, mp := range policy.ManagedPaths() {
+			if arg == mp.Name || filepath.Clean(arg) == filepath.Clean(mp.Path) {
+				selected[mp.Path] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown path %q, expected one of %s or their paths", arg, strings.Join(managedPathNames(), ", "))
 		}
-		return fmt.Errorf("permissions check failed: %d problems found", len(problems))
 	}
-	// Success: print nothing and return nil
-	return nil
+	return selected, nil
 }
 
 // fixResult is the JSON-serializable result of a permissions fix.
@@ -244,64 +558,340 @@ type fixResult struct {
 	Planned []string `json:"planned"`
 	Errors  []string `json:"errors,omitempty"`
 	DryRun  bool     `json:"dryRun"`
+	// RolledBack is set if a change failed and the changes made before it
+	// were undone
+	RolledBack bool `json:"rolledBack,omitempty"`
 }
 
-// Fix attempts to repair permissions/ownership for key paths.
-func (p *PermissionsCmd) Fix() error {
-	// Planning phase: determine actions without performing them
-	var planned []string
+// fixActionKind is the kind of change planned by permissions fix
+type fixActionKind int
+
+const (
+	fixSkip fixActionKind = iota
+	fixCreate
+	fixMkdir
+	fixChmod
+	fixChown
+	fixACL
+	fixClearImmutable
+	fixSetImmutable
+	fixSELinux
+	fixInheritance
+	fixRemoveACE
+)
 
-	sp := files.RequiredPerms.SystemPolicy
-	pv := files.RequiredPerms.Providers
-	pld := files.RequiredPerms.PluginsDir
-	pf := files.RequiredPerms.PluginFile
+// fixAction is a change planned by permissions fix. Desc is shown to the
+// user, actions without one are only done as part of the previous action.
+type fixAction struct {
+	Kind  fixActionKind
+	Path  string
+	Mode  fs.FileMode
+	Owner string
+	Group string
+	// ACEs is the DACL a fixACL action sets, or the ACEs a fixRemoveACE
+	// action removes
+	ACEs []files.ACE
+	// SELinuxType is the type a fixSELinux action sets, on everything in
+	// Path if Dir is set
+	SELinuxType string
+	Dir         bool
+	// NoFollow changes the file through a handle opened without following
+	// symbolic links, for paths that a user can swap after they're checked
+	NoFollow bool
+	Desc     string
+}
+
+// planFix returns the changes fix makes to the paths in only, in the order
+// they are applied. A nil only plans the changes to every managed path.
+func (p *PermissionsCmd) planFix(only map[string]bool) []fixAction {
+	var actions []fixAction
+	want := func(path string) bool {
+		return only == nil || only[path]
+	}
+	add := func(a fixAction) {
+		actions = append(actions, a)
+	}
+	owner := func(pi files.PermInfo) string {
+		if pi.Group != "" {
+			return pi.Owner + ":" + pi.Group
+		}
+		return pi.Owner
+	}
+	// ownership returns the chmod, chown and on Windows ACL actions that
+	// give path the permissions pi
+	ownership := func(path string, pi files.PermInfo, modeDesc string) {
+		add(fixAction{Kind: fixChmod, Path: path, Mode: pi.Mode, Desc: "chmod " + path + " to " + modeDesc})
+		add(fixAction{Kind: fixChown, Path: path, Owner: pi.Owner, Group: pi.Group, Desc: "chown " + path + " to " + owner(pi)})
+		if runtime.GOOS == "windows" {
+			if a, ok := p.planACL(path, pi); ok {
+				add(a)
+			}
+		} else if a, ok := p.planRemoveACEs(path, pi); ok {
+			add(a)
+		}
+	}
 
-	systemPolicy := policy.SystemDefaultPolicyPath
-	if _, err := p.FileSystem.Stat(systemPolicy); err != nil {
-		planned = append(planned, "create file: "+systemPolicy)
+	// selinux relabels path if its SELinux type isn't the one sshd needs.
+	// A path fix creates gets the type of its directory.
+	selinux := func(mp policy.ManagedPath) {
+		if mp.SELinuxType == "" {
+			return
+		}
+		if typ, err := p.FileSystem.SELinuxType(mp.Path); err == nil && typ != "" && typ != mp.SELinuxType {
+			add(fixAction{Kind: fixSELinux, Path: mp.Path, SELinuxType: mp.SELinuxType, Dir: mp.Dir,
+				Desc: fmt.Sprintf("set SELinux type of %s to %s (is %s)", mp.Path, mp.SELinuxType, typ)})
+		}
 	}
-	planned = append(planned, "chmod "+systemPolicy+" to "+sp.Mode.String())
-	plannedOwner := sp.Owner
-	if sp.Group != "" {
-		plannedOwner += ":" + sp.Group
+
+	// Distribution defaults are on a read-only filesystem, only the files
+	// that override them in the system config directory are changed.
+	// Immutable files are left alone unless --immutable is passed.
+	var immutableFiles []string
+	readOnly := func(path string) bool {
+		if policy.IsVendorConfigPath(path) {
+			add(fixAction{Kind: fixSkip, Path: path, Desc: "skip " + path + ": read-only distribution default"})
+			return true
+		}
+		if immutable, _ := p.FileSystem.IsImmutable(path); immutable && !p.Immutable {
+			add(fixAction{Kind: fixSkip, Path: path, Desc: "skip " + path + ": immutable (schg), pass --immutable to change it"})
+			return true
+		}
+		return false
 	}
-	planned = append(planned, "chown "+systemPolicy+" to "+plannedOwner)
 
-	providersFile := policy.SystemDefaultProvidersPath
-	if _, err := p.FileSystem.Stat(providersFile); err == nil {
-		planned = append(planned, "chmod "+providersFile+" to "+pv.Mode.String())
-		pvOwner := pv.Owner
-		if pv.Group != "" {
-			pvOwner += ":" + pv.Group
+	for _, mp := range policy.ManagedPaths() {
+		if !want(mp.Path) || readOnly(mp.Path) {
+			continue
+		}
+		if _, err := p.FileSystem.Stat(mp.Path); err != nil {
+			if !mp.Create {
+				continue
+			}
+			if mp.Dir {
+				add(fixAction{Kind: fixMkdir, Path: mp.Path, Mode: mp.Perm.Mode, Desc: "mkdir " + mp.Path})
+			} else {
+				add(fixAction{Kind: fixCreate, Path: mp.Path, Desc: "create file: " + mp.Path})
+			}
+		}
+		if !mp.Dir {
+			ownership(mp.Path, mp.Perm, mp.Perm.Mode.String())
+			selinux(mp)
+			immutableFiles = append(immutableFiles, mp.Path)
+			continue
+		}
+		selinux(mp)
+		if !mp.KeepPerms && runtime.GOOS == "windows" {
+			if a, ok := p.planInheritance(mp.Path); ok {
+				add(a)
+			}
+		}
+		if !mp.KeepPerms {
+			add(fixAction{Kind: fixChmod, Path: mp.Path, Mode: mp.Perm.Mode, Desc: fmt.Sprintf("chmod %s to %04o", mp.Path, mp.Perm.Mode)})
+			add(fixAction{Kind: fixChown, Path: mp.Path, Owner: mp.Perm.Owner, Group: mp.Perm.Group, Desc: "chown " + mp.Path + " to " + owner(mp.Perm)})
+			if a, ok := p.planRemoveACEs(mp.Path, mp.Perm); ok {
+				add(a)
+			}
+		}
+		// include the files of the directory and its subdirectories if
+		// present, the commands run by them are left alone
+		if mp.EntrySuffix == "" {
+			continue
+		}
+		for _, e := range dirEntries(p.FileSystem, mp) {
+			switch {
+			case e.Symlink:
+				add(fixAction{Kind: fixSkip, Path: e.Path, Desc: "skip " + e.Path + ": symbolic link"})
+			case e.Dir:
+				if !mp.KeepPerms {
+					add(fixAction{Kind: fixChmod, Path: e.Path, Mode: mp.Perm.Mode, Desc: fmt.Sprintf("chmod %s to %04o", e.Path, mp.Perm.Mode)})
+					add(fixAction{Kind: fixChown, Path: e.Path, Owner: mp.Perm.Owner, Group: mp.Perm.Group, Desc: "chown " + e.Path + " to " + owner(mp.Perm)})
+				}
+			case !e.Command:
+				ownership(e.Path, mp.EntryPerm, fmt.Sprintf("%04o", mp.EntryPerm.Mode))
+			}
+		}
+	}
+	if only == nil {
+		for _, sp := range p.selinuxPaths() {
+			selinux(sp)
 		}
-		planned = append(planned, "chown "+providersFile+" to "+pvOwner)
 	}
 
-	configFile := filepath.Join(policy.GetSystemConfigBasePath(), "config.yml")
-	cp := files.RequiredPerms.Config
-	if _, err := p.FileSystem.Stat(configFile); err == nil {
-		planned = append(planned, "chmod "+configFile+" to "+cp.Mode.String())
-		cpOwner := cp.Owner
-		if cp.Group != "" {
-			cpOwner += ":" + cp.Group
+	if p.Immutable {
+		// The immutable flag has to be cleared before any other change
+		var clear []fixAction
+		for _, path := range immutableFiles {
+			clear = append(clear, fixAction{Kind: fixClearImmutable, Path: path})
+			add(fixAction{Kind: fixSetImmutable, Path: path, Desc: "chflags schg " + path})
 		}
-		planned = append(planned, "chown "+configFile+" to "+cpOwner)
+		actions = append(clear, actions...)
 	}
+	return actions
+}
+
+// planACL returns the action that replaces the DACL of path with the one
+// for pi, with each ACE it adds or removes in its description. ok is false
+// if the DACL is already right.
+func (p *PermissionsCmd) planACL(path string, pi files.PermInfo) (fixAction, bool) {
+	desired := files.DesiredDACL(pi)
+	// A missing file or an unreadable DACL gets the whole desired DACL
+	report, _ := p.FileSystem.VerifyACL(path, files.ExpectedACLFromPerm(pi))
+	changes := files.DACLChanges(report, desired)
+	if len(changes) == 0 {
+		return fixAction{}, false
+	}
+	return fixAction{
+		Kind: fixACL,
+		Path: path,
+		ACEs: desired,
+		Desc: "set ACL of " + path + ": " + strings.Join(changes, ", "),
+	}, true
+}
+
+// planRemoveACEs returns the action that removes the ACEs of path that
+// grant access the ACL for pi doesn't, such as a user added with setfacl.
+// ok is false unless --strict is set and there are such ACEs.
+func (p *PermissionsCmd) planRemoveACEs(path string, pi files.PermInfo) (fixAction, bool) {
+	if !p.Strict {
+		return fixAction{}, false
+	}
+	expected := files.ExpectedACLFromPerm(pi)
+	report, err := p.FileSystem.VerifyACL(path, expected)
+	if err != nil || !report.Exists {
+		return fixAction{}, false
+	}
+	aces := files.UnexpectedACEs(report, expected)
+	if len(aces) == 0 {
+		return fixAction{}, false
+	}
+	var removed []string
+	for _, ace := range aces {
+		removed = append(removed, ace.Principal+" "+ace.Rights)
+	}
+	return fixAction{
+		Kind: fixRemoveACE,
+		Path: path,
+		ACEs: aces,
+		Desc: "remove ACL entries of " + path + ": " + strings.Join(removed, ", "),
+	}, true
+}
 
-	pluginsDir := filepath.Join(policy.GetSystemConfigBasePath(), "policy.d")
-	if _, err := p.FileSystem.Stat(pluginsDir); err != nil {
-		planned = append(planned, "mkdir "+pluginsDir)
+// planInheritance returns the action that stops the DACL of the directory
+// path inheriting the ACEs of its parent, so that later changes to the
+// ACL of the parent don't reach it. The inherited ACEs are kept as ACEs of
+// path. ok is false if path already inherits no ACE.
+func (p *PermissionsCmd) planInheritance(path string) (fixAction, bool) {
+	// A missing directory inherits ACEs once fix creates it
+	report, err := p.FileSystem.VerifyACL(path, files.ExpectedACL{})
+	if err == nil && report.Exists {
+		inherited := false
+		for _, ace := range report.ACEs {
+			inherited = inherited || ace.Inherited
+		}
+		if !inherited {
+			return fixAction{}, false
+		}
 	}
-	// include plugin files if present
-	if fi, err := p.FileSystem.Open(pluginsDir); err == nil {
-		entries, _ := fi.Readdir(-1)
-		for _, e := range entries {
-			if !e.IsDir() && strings.HasSuffix(e.Name(), ".yml") {
-				planned = append(planned, fmt.Sprintf("chmod %s to %04o", filepath.Join(pluginsDir, e.Name()), pf.Mode))
-				planned = append(planned, "chown "+filepath.Join(pluginsDir, e.Name())+" to "+pf.Owner)
+	return fixAction{
+		Kind: fixInheritance,
+		Path: path,
+		Desc: "disable ACL inheritance of " + path + ", keeping the inherited ACEs",
+	}, true
+}
+
+// planUserFix returns the changes fix --user makes to the home policy of
+// username. Nothing outside of ~/.opk is changed and symbolic links are
+// refused, so a sudo rule can allow it for any user.
+func (p *PermissionsCmd) planUserFix(username string) ([]fixAction, error) {
+	u, err := p.UserLookup.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup username %s: %w", username, err)
+	}
+	if u.HomeDir == "" {
+		return nil, fmt.Errorf("user %s does not have a home directory", username)
+	}
+	dir := filepath.Join(u.HomeDir, ".opk")
+	policyPath := filepath.Join(dir, "auth_id")
+
+	var actions []fixAction
+	ownership := func(path string, mode fs.FileMode) {
+		actions = append(actions,
+			fixAction{Kind: fixChmod, Path: path, Mode: mode, NoFollow: true, Desc: fmt.Sprintf("chmod %s to %04o", path, mode)},
+			fixAction{Kind: fixChown, Path: path, Owner: username, NoFollow: true, Desc: "chown " + path + " to " + username})
+		if runtime.GOOS == "windows" {
+			if a, ok := p.planACL(path, files.PermInfo{Mode: mode, Owner: username}); ok {
+				actions = append(actions, a)
 			}
+		} else if a, ok := p.planRemoveACEs(path, files.PermInfo{Mode: mode, Owner: username}); ok {
+			a.NoFollow = true
+			actions = append(actions, a)
+		}
+	}
+
+	fi, err := p.FileSystem.Lstat(dir)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		actions = append(actions, fixAction{Kind: fixMkdir, Path: dir, Mode: files.ModeHomeDirPerms, Desc: "mkdir " + dir})
+	case err != nil:
+		return nil, fmt.Errorf("failed to stat %s: %w", dir, err)
+	case fi.Mode()&fs.ModeSymlink != 0:
+		return nil, fmt.Errorf("refusing to fix %s: it is a symbolic link", dir)
+	case !fi.IsDir():
+		return nil, fmt.Errorf("refusing to fix %s: it is not a directory", dir)
+	}
+	ownership(dir, files.ModeHomeDirPerms)
+
+	fi, err = p.FileSystem.Lstat(policyPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return actions, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to stat %s: %w", policyPath, err)
+	case !fi.Mode().IsRegular():
+		return nil, fmt.Errorf("refusing to fix %s: it is not a regular file", policyPath)
+	}
+	ownership(policyPath, files.RequiredPerms.HomePolicy.Mode)
+	return actions, nil
+}
+
+// Fix attempts to repair permissions/ownership for key paths.
+func (p *PermissionsCmd) Fix() error {
+	if p.Immutable && p.User != "" {
+		return fmt.Errorf("--immutable cannot be used with --user")
+	}
+	if p.Immutable && !files.ImmutableSupported {
+		return fmt.Errorf("--immutable is not supported on %s", runtime.GOOS)
+	}
+	if p.User != "" && len(p.Paths) > 0 {
+		return fmt.Errorf("--paths cannot be used with --user")
+	}
+	if p.FromReport != "" && (p.User != "" || len(p.Paths) > 0 || p.Immutable || p.Strict) {
+		return fmt.Errorf("--from-report cannot be used with --user, --paths, --immutable or --strict, the report has the changes to make")
+	}
+	only, err := p.selectPaths()
+	if err != nil {
+		return err
+	}
+
+	// Planning phase: determine actions without performing them
+	var actions []fixAction
+	if p.FromReport != "" {
+		if actions, err = p.readReport(p.FromReport); err != nil {
+			return err
+		}
+	} else if p.User != "" {
+		var err error
+		if actions, err = p.planUserFix(p.User); err != nil {
+			return err
+		}
+	} else {
+		actions = p.planFix(only)
+	}
+	var planned []string
+	for _, a := range actions {
+		if a.Desc != "" {
+			planned = append(planned, a.Desc)
 		}
-		fi.Close()
 	}
 
 	// If dry-run, just print planned actions
@@ -334,8 +924,10 @@ func (p *PermissionsCmd) Fix() error {
 		for _, a := range planned {
 			fmt.Fprintln(p.Out, "  -", a)
 		}
-		ok, err := p.ConfirmPrompt("Apply these changes? [y/N]: ", p.In)
-		if err != nil {
+		ok, err := p.Prompter.Confirm("Apply these changes? [y/N]: ")
+		if errors.Is(err, ErrNonInteractive) {
+			return fmt.Errorf("cannot ask for confirmation: %w; use --yes to apply the changes without prompting", err)
+		} else if err != nil {
 			return err
 		}
 		if !ok {
@@ -343,130 +935,64 @@ func (p *PermissionsCmd) Fix() error {
 		}
 	}
 
-	// Execution phase: perform actions
+	// Execution phase: perform actions, if one fails the ones applied
+	// before it are rolled back so that no path is left half fixed
 	var errorsFound []string
-
-	// Create system policy file if missing
-	if _, err := p.FileSystem.Stat(systemPolicy); err != nil {
-		if f, err := p.FileSystem.CreateFile(systemPolicy); err != nil {
-			errorsFound = append(errorsFound, "create "+systemPolicy+": "+err.Error())
-		} else {
-			f.Close()
+	var applied []Action
+	rolledBack := false
+	for _, fa := range actions {
+		a := p.newAction(fa)
+		before := ""
+		verbose := p.Verbose && !p.JsonOutput && a.Describe() != ""
+		if verbose {
+			before = pathState(p.FileSystem, fa.Path)
 		}
-	}
-	if err := p.FileSystem.Chmod(systemPolicy, sp.Mode); err != nil {
-		errorsFound = append(errorsFound, "chmod "+systemPolicy+": "+err.Error())
-	}
-	if err := p.FileSystem.Chown(systemPolicy, sp.Owner, sp.Group); err != nil {
-		errorsFound = append(errorsFound, "chown "+systemPolicy+": "+err.Error())
-	}
-
-	// Verify ACLs after changes and apply ACE fixes on Windows if needed
-	if runtime.GOOS == "windows" {
-		expected := files.ExpectedACLFromPerm(sp)
-		report, err := p.FileSystem.VerifyACL(systemPolicy, expected)
-		if err != nil {
-			errorsFound = append(errorsFound, "acl verify: "+err.Error())
-		} else {
-			for _, reqACE := range expected.RequiredACEs {
-				found := false
-				for _, a := range report.ACEs {
-					if a.Principal == reqACE.Principal && strings.Contains(a.Rights, reqACE.Rights) {
-						found = true
-						break
-					}
-				}
-				if !found {
-					ace := files.ACE{Principal: reqACE.Principal, Rights: reqACE.Rights, Type: reqACE.Type}
-					if sid, _, _ := files.ResolveAccountToSID(reqACE.Principal); len(sid) > 0 {
-						ace.PrincipalSID = sid
-					}
-					if err := p.FileSystem.ApplyACE(systemPolicy, ace); err != nil {
-						errorsFound = append(errorsFound, fmt.Sprintf("apply ACE %s:%s: %s", reqACE.Principal, reqACE.Rights, err.Error()))
-					}
+		if err := a.Apply(); err != nil {
+			errorsFound = append(errorsFound, err.Error())
+			for i := len(applied) - 1; i >= 0; i-- {
+				if err := applied[i].Rollback(); err != nil {
+					errorsFound = append(errorsFound, "roll back "+applied[i].Describe()+": "+err.Error())
 				}
 			}
+			rolledBack = len(applied) > 0
+			break
 		}
-	}
-
-	// Providers file
-	if _, err := p.FileSystem.Stat(providersFile); err == nil {
-		if err := p.FileSystem.Chmod(providersFile, pv.Mode); err != nil {
-			errorsFound = append(errorsFound, "chmod "+providersFile+": "+err.Error())
-		}
-		if err := p.FileSystem.Chown(providersFile, pv.Owner, pv.Group); err != nil {
-			errorsFound = append(errorsFound, "chown "+providersFile+": "+err.Error())
+		applied = append(applied, a)
+		if verbose {
+			fmt.Fprintf(p.Out, "%s\n  before: %s\n  after:  %s\n", a.Describe(), before, pathState(p.FileSystem, fa.Path))
 		}
 	}
 
-	// Config file
-	if _, err := p.FileSystem.Stat(configFile); err == nil {
-		if err := p.FileSystem.Chmod(configFile, cp.Mode); err != nil {
-			errorsFound = append(errorsFound, "chmod "+configFile+": "+err.Error())
+	if p.Journal != nil {
+		summary := append([]string{}, planned...)
+		if p.FromReport != "" {
+			summary = append([]string{"from report " + p.FromReport}, summary...)
 		}
-		if err := p.FileSystem.Chown(configFile, cp.Owner, cp.Group); err != nil {
-			errorsFound = append(errorsFound, "chown "+configFile+": "+err.Error())
+		for _, e := range errorsFound {
+			summary = append(summary, "error: "+e)
 		}
-	}
-
-	// Plugins dir
-	if _, err := p.FileSystem.Stat(pluginsDir); err != nil {
-		if err := p.FileSystem.MkdirAll(pluginsDir, pld.Mode); err != nil {
-			errorsFound = append(errorsFound, "mkdir "+pluginsDir+": "+err.Error())
+		if rolledBack {
+			summary = append(summary, "rolled back")
 		}
-	}
-	if fi, err := p.FileSystem.Open(pluginsDir); err == nil {
-		entries, _ := fi.Readdir(-1)
-		for _, e := range entries {
-			if !e.IsDir() && strings.HasSuffix(e.Name(), ".yml") {
-				path := filepath.Join(pluginsDir, e.Name())
-				if err := p.FileSystem.Chmod(path, pf.Mode); err != nil {
-					errorsFound = append(errorsFound, "chmod "+path+": "+err.Error())
-				}
-				if err := p.FileSystem.Chown(path, pf.Owner, pf.Group); err != nil {
-					errorsFound = append(errorsFound, "chown "+path+": "+err.Error())
-				}
-				// On Windows, ensure ACLs for plugin files as well
-				if runtime.GOOS == "windows" {
-					pfExpected := files.ExpectedACLFromPerm(pf)
-					if report, err := p.FileSystem.VerifyACL(path, pfExpected); err == nil {
-						for _, reqACE := range pfExpected.RequiredACEs {
-							found := false
-							for _, a := range report.ACEs {
-								if a.Principal == reqACE.Principal && strings.Contains(a.Rights, reqACE.Rights) {
-									found = true
-									break
-								}
-							}
-							if !found {
-								ace := files.ACE{Principal: reqACE.Principal, Rights: reqACE.Rights, Type: reqACE.Type}
-								if sid, _, _ := files.ResolveAccountToSID(reqACE.Principal); len(sid) > 0 {
-									ace.PrincipalSID = sid
-								}
-								if err := p.FileSystem.ApplyACE(path, ace); err != nil {
-									errorsFound = append(errorsFound, fmt.Sprintf("apply ACE %s:%s for %s: %s", reqACE.Principal, reqACE.Rights, path, err.Error()))
-								}
-							}
-						}
-					} else {
-						errorsFound = append(errorsFound, "acl verify for "+path+": "+err.Error())
-					}
-				}
-			}
+		if err := p.Journal.Append(policy.JournalEntry{Action: "fix", Summary: summary}); err != nil {
+			fmt.Fprintln(p.ErrOut, "Warning: failed to record changes in policy journal:", err)
 		}
-		fi.Close()
 	}
 
 	if p.JsonOutput {
 		enc := json.NewEncoder(p.Out)
 		enc.SetIndent("", "  ")
-		return enc.Encode(fixResult{Planned: planned, Errors: errorsFound})
+		return enc.Encode(fixResult{Planned: planned, Errors: errorsFound, RolledBack: rolledBack})
 	}
 
 	if len(errorsFound) > 0 {
 		for _, e := range errorsFound {
 			fmt.Fprintln(p.Out, "Error:", e)
 		}
+		if rolledBack {
+			fmt.Fprintln(p.Out, "Rolled back the changes made before the error")
+			return fmt.Errorf("fix failed and was rolled back: %s", errorsFound[0])
+		}
 		return fmt.Errorf("fix completed with %d errors", len(errorsFound))
 	}
 
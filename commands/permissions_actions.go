@@ -0,0 +1,375 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// Action is a change made by permissions fix. Apply records the state of
+// the path it changes, so that when a later action fails Rollback can put
+// it back.
+type Action interface {
+	// Describe returns the change shown to the user, empty for a change
+	// made as part of the previous one
+	Describe() string
+	Apply() error
+	// Rollback undoes Apply, it is only called after Apply succeeded
+	Rollback() error
+}
+
+// newAction returns the Action that makes the change planned by a
+func (p *PermissionsCmd) newAction(a fixAction) Action {
+	base := fileAction{fsys: p.FileSystem, fixAction: a}
+	switch a.Kind {
+	case fixCreate:
+		return &createAction{fileAction: base}
+	case fixMkdir:
+		return &mkdirAction{fileAction: base}
+	case fixChmod:
+		return &chmodAction{fileAction: base}
+	case fixChown:
+		return &chownAction{fileAction: base}
+	case fixACL:
+		return &aclAction{fileAction: base}
+	case fixClearImmutable:
+		return &immutableAction{fileAction: base}
+	case fixSetImmutable:
+		return &immutableAction{fileAction: base, set: true}
+	case fixSELinux:
+		return &selinuxAction{fileAction: base}
+	case fixInheritance:
+		return &inheritanceAction{fileAction: base}
+	case fixRemoveACE:
+		return &removeACEAction{fileAction: base}
+	}
+	return &skipAction{fileAction: base}
+}
+
+// fileAction is the planned change of an Action
+type fileAction struct {
+	fsys files.FileSystem
+	fixAction
+}
+
+func (a *fileAction) Describe() string {
+	return a.Desc
+}
+
+type skipAction struct {
+	fileAction
+}
+
+func (a *skipAction) Apply() error    { return nil }
+func (a *skipAction) Rollback() error { return nil }
+
+// missingPaths returns path and its parent directories that don't exist,
+// outermost first
+func missingPaths(fsys files.FileSystem, path string) []string {
+	var missing []string
+	for {
+		if _, err := fsys.Lstat(path); err == nil {
+			return missing
+		}
+		missing = append([]string{path}, missing...)
+		parent := filepath.Dir(path)
+		if parent == path {
+			return missing
+		}
+		path = parent
+	}
+}
+
+// removeCreated removes the paths returned by missingPaths, innermost first
+func removeCreated(fsys files.FileSystem, created []string) error {
+	for i := len(created) - 1; i >= 0; i-- {
+		if err := fsys.Remove(created[i]); err != nil {
+			return fmt.Errorf("remove %s: %w", created[i], err)
+		}
+	}
+	return nil
+}
+
+type createAction struct {
+	fileAction
+	created []string
+}
+
+func (a *createAction) Apply() error {
+	a.created = missingPaths(a.fsys, a.Path)
+	if len(a.created) == 0 {
+		return nil
+	}
+	f, err := a.fsys.CreateFile(a.Path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", a.Path, err)
+	}
+	f.Close()
+	return nil
+}
+
+func (a *createAction) Rollback() error {
+	return removeCreated(a.fsys, a.created)
+}
+
+type mkdirAction struct {
+	fileAction
+	created []string
+}
+
+func (a *mkdirAction) Apply() error {
+	a.created = missingPaths(a.fsys, a.Path)
+	if len(a.created) == 0 {
+		return nil
+	}
+	if err := a.fsys.MkdirAll(a.Path, a.Mode); err != nil {
+		return fmt.Errorf("mkdir %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+func (a *mkdirAction) Rollback() error {
+	return removeCreated(a.fsys, a.created)
+}
+
+type chmodAction struct {
+	fileAction
+	before fs.FileMode
+}
+
+func (a *chmodAction) Apply() error {
+	stat := a.fsys.Stat
+	if a.NoFollow {
+		stat = a.fsys.Lstat
+	}
+	fi, err := stat(a.Path)
+	if err != nil {
+		return fmt.Errorf("chmod %s: %w", a.Path, err)
+	}
+	a.before = fi.Mode().Perm()
+	if err := a.chmod(a.Mode); err != nil {
+		return fmt.Errorf("chmod %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+func (a *chmodAction) Rollback() error {
+	return a.chmod(a.before)
+}
+
+func (a *chmodAction) chmod(mode fs.FileMode) error {
+	if a.NoFollow {
+		return a.fsys.ChmodNoFollow(a.Path, mode)
+	}
+	return a.fsys.Chmod(a.Path, mode)
+}
+
+type chownAction struct {
+	fileAction
+	owner, group string
+}
+
+func (a *chownAction) Apply() error {
+	before, err := a.fsys.VerifyACL(a.Path, files.ExpectedACL{})
+	if err == nil {
+		a.owner, a.group = before.Owner, before.Group
+	}
+	if err := a.chown(a.Owner, a.Group); err != nil {
+		return fmt.Errorf("chown %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+func (a *chownAction) Rollback() error {
+	// Chown changes nothing if the owner couldn't be read
+	return a.chown(a.owner, a.group)
+}
+
+func (a *chownAction) chown(owner string, group string) error {
+	if a.NoFollow {
+		return a.fsys.ChownNoFollow(a.Path, owner, group)
+	}
+	return a.fsys.Chown(a.Path, owner, group)
+}
+
+type aclAction struct {
+	fileAction
+	before []files.ACE
+	read   bool
+}
+
+func (a *aclAction) Apply() error {
+	if report, err := a.fsys.VerifyACL(a.Path, files.ExpectedACL{}); err == nil && report.Exists {
+		a.read = true
+		for _, ace := range report.ACEs {
+			if !ace.Inherited {
+				a.before = append(a.before, ace)
+			}
+		}
+	}
+	if err := a.fsys.SetDACL(a.Path, a.ACEs); err != nil {
+		return fmt.Errorf("set ACL of %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+// Rollback restores the ACEs set on the path itself. The path no longer
+// inherits the ACEs of its directory.
+func (a *aclAction) Rollback() error {
+	if !a.read {
+		return fmt.Errorf("the previous ACL of %s could not be read", a.Path)
+	}
+	return a.fsys.SetDACL(a.Path, a.before)
+}
+
+type inheritanceAction struct {
+	fileAction
+	before []files.ACE
+	read   bool
+}
+
+func (a *inheritanceAction) Apply() error {
+	if report, err := a.fsys.VerifyACL(a.Path, files.ExpectedACL{}); err == nil && report.Exists {
+		a.read = true
+		for _, ace := range report.ACEs {
+			if !ace.Inherited {
+				a.before = append(a.before, ace)
+			}
+		}
+	}
+	if err := a.fsys.SetInheritance(a.Path, files.InheritanceCopy); err != nil {
+		return fmt.Errorf("disable ACL inheritance of %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+// Rollback drops the copies of the inherited ACEs and inherits again. The
+// copies stay if the path had no ACE of its own, they grant nothing the
+// inherited ACEs don't.
+func (a *inheritanceAction) Rollback() error {
+	if !a.read {
+		return fmt.Errorf("the previous ACL of %s could not be read", a.Path)
+	}
+	if len(a.before) > 0 {
+		if err := a.fsys.SetDACL(a.Path, a.before); err != nil {
+			return err
+		}
+	}
+	return a.fsys.SetInheritance(a.Path, files.InheritanceEnable)
+}
+
+type removeACEAction struct {
+	fileAction
+	removed []files.ACE
+}
+
+func (a *removeACEAction) Apply() error {
+	for _, ace := range a.ACEs {
+		remove := a.fsys.RemoveACE
+		if a.NoFollow {
+			remove = a.fsys.RemoveACENoFollow
+		}
+		if err := remove(a.Path, ace); err != nil {
+			return fmt.Errorf("remove ACL entry %s of %s: %w", ace.Principal, a.Path, err)
+		}
+		a.removed = append(a.removed, ace)
+	}
+	return nil
+}
+
+// Rollback grants the removed ACEs again. On Windows an ACE that had
+// specific rights gets them back as a single grant.
+func (a *removeACEAction) Rollback() error {
+	for i := len(a.removed) - 1; i >= 0; i-- {
+		if err := a.fsys.ApplyACE(a.Path, a.removed[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type immutableAction struct {
+	fileAction
+	set     bool
+	changed bool
+}
+
+func (a *immutableAction) Apply() error {
+	if immutable, _ := a.fsys.IsImmutable(a.Path); immutable == a.set {
+		return nil
+	}
+	if err := a.fsys.SetImmutable(a.Path, a.set); err != nil {
+		if a.set {
+			return fmt.Errorf("chflags schg %s: %w", a.Path, err)
+		}
+		return fmt.Errorf("chflags noschg %s: %w", a.Path, err)
+	}
+	a.changed = true
+	return nil
+}
+
+func (a *immutableAction) Rollback() error {
+	if !a.changed {
+		return nil
+	}
+	return a.fsys.SetImmutable(a.Path, !a.set)
+}
+
+type selinuxAction struct {
+	fileAction
+}
+
+func (a *selinuxAction) Apply() error {
+	if err := a.fsys.SetSELinuxType(a.Path, a.SELinuxType, a.Dir); err != nil {
+		return fmt.Errorf("set SELinux type of %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+// Rollback keeps the new type, which is the one sshd needs. Restoring the
+// previous one would need a file context rule for it.
+func (a *selinuxAction) Rollback() error {
+	return nil
+}
+
+// pathState describes the state of path fix --verbose shows before and
+// after each change
+func pathState(fsys files.FileSystem, path string) string {
+	fi, err := fsys.Lstat(path)
+	if err != nil {
+		return "missing"
+	}
+	state := fmt.Sprintf("mode %04o", fi.Mode().Perm())
+	if report, err := fsys.VerifyACL(path, files.ExpectedACL{}); err == nil && report.Owner != "" {
+		owner := report.Owner
+		if report.Group != "" {
+			owner += ":" + report.Group
+		}
+		state += ", owner " + owner
+	}
+	if immutable, _ := fsys.IsImmutable(path); immutable {
+		state += ", immutable"
+	}
+	if typ, _ := fsys.SELinuxType(path); typ != "" {
+		state += ", SELinux type " + typ
+	}
+	return state
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy/plugins"
+	"gopkg.in/yaml.v3"
+)
+
+// dirEntry is a path found in a managed directory by dirEntries
+type dirEntry struct {
+	Path string
+	Dir  bool
+	// Symlink is set for a symbolic link, which is not followed
+	Symlink bool
+	// Command is set for a command run by a policy plugin config of the
+	// directory, which may be anywhere
+	Command bool
+}
+
+// dirEntries returns the subdirectories of the managed directory mp and the
+// files in them with the suffix mp.EntrySuffix, in lexical order. If
+// mp.CommandModes is set, each file is followed by the command it runs.
+func dirEntries(fsys files.FileSystem, mp policy.ManagedPath) []dirEntry {
+	var found []dirEntry
+	commands := map[string]bool{}
+	var walk func(dir string)
+	walk = func(dir string) {
+		f, err := fsys.Open(dir)
+		if err != nil {
+			return
+		}
+		infos, _ := f.Readdir(-1)
+		f.Close()
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		for _, info := range infos {
+			path := filepath.Join(dir, info.Name())
+			fi, err := fsys.Lstat(path)
+			if err != nil {
+				continue
+			}
+			switch {
+			case fi.Mode()&fs.ModeSymlink != 0:
+				found = append(found, dirEntry{Path: path, Symlink: true})
+			case fi.IsDir():
+				found = append(found, dirEntry{Path: path, Dir: true})
+				walk(path)
+			case fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), mp.EntrySuffix):
+				found = append(found, dirEntry{Path: path})
+				if len(mp.CommandModes) == 0 {
+					continue
+				}
+				// A missing command is reported by lint
+				if command := pluginCommand(fsys, path); command != "" && !commands[command] {
+					if exists, _ := fsys.Exists(command); exists {
+						commands[command] = true
+						found = append(found, dirEntry{Path: command, Command: true})
+					}
+				}
+			}
+		}
+	}
+	walk(mp.Path)
+	return found
+}
+
+// pluginCommand returns the executable run by the exec policy plugin config
+// at path, empty if there is none
+func pluginCommand(fsys files.FileSystem, path string) string {
+	content, err := fsys.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var config plugins.PluginConfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return ""
+	}
+	if config.Type != "" && config.Type != plugins.PluginTypeExec {
+		return ""
+	}
+	command, err := shellquote.Split(config.Command)
+	if err != nil || len(command) == 0 || !filepath.IsAbs(command[0]) {
+		return ""
+	}
+	return command[0]
+}
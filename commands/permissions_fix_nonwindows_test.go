@@ -5,7 +5,6 @@ package commands
 
 import (
 	"bytes"
-	"io"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -16,12 +15,12 @@ func TestRunPermissionsFix_NonWindows_CreatesAndSetsPerms(t *testing.T) {
 	mfs := &mockFileSystem{fs: mem}
 
 	p := &PermissionsCmd{
-		FileSystem:    mfs,
-		Out:           &bytes.Buffer{},
-		ErrOut:        &bytes.Buffer{},
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
-		Yes:           true,
+		FileSystem:   mfs,
+		Out:          &bytes.Buffer{},
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
+		Yes:          true,
 	}
 
 	err := p.Fix()
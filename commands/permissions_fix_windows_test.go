@@ -5,7 +5,7 @@ package commands
 
 import (
 	"bytes"
-	"io"
+	"strings"
 	"testing"
 
 	"github.com/openpubkey/opkssh/policy"
@@ -13,7 +13,7 @@ import (
 	"github.com/spf13/afero"
 )
 
-func TestRunPermissionsFix_AppliesRequiredACEs_Windows(t *testing.T) {
+func TestRunPermissionsFix_SetsDesiredDACL_Windows(t *testing.T) {
 	// Setup in-memory fs with system policy file
 	mem := afero.NewMemMapFs()
 	systemPolicy := policy.SystemDefaultPolicyPath
@@ -29,12 +29,12 @@ func TestRunPermissionsFix_AppliesRequiredACEs_Windows(t *testing.T) {
 	}
 
 	p := &PermissionsCmd{
-		FileSystem:    mfs,
-		Out:           &bytes.Buffer{},
-		ErrOut:        &bytes.Buffer{},
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
-		Yes:           true,
+		FileSystem:   mfs,
+		Out:          &bytes.Buffer{},
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
+		Yes:          true,
 	}
 
 	err := p.Fix()
@@ -42,30 +42,36 @@ func TestRunPermissionsFix_AppliesRequiredACEs_Windows(t *testing.T) {
 		t.Fatalf("Fix failed: %v", err)
 	}
 
-	if len(mfs.Applied) < 2 {
-		t.Fatalf("expected at least 2 ApplyACE calls for Administrators and opksshuser, got %d", len(mfs.Applied))
+	// Administrators:F, SYSTEM:F and opksshuser:R, nothing else
+	dacl, ok := mfs.DACLs[systemPolicy]
+	if !ok {
+		t.Fatalf("expected the DACL of %s to be set, got: %+v", systemPolicy, mfs.DACLs)
 	}
-
-	// check principals present
-	var foundAdmin, foundOpksshuser bool
-	for _, a := range mfs.Applied {
-		if a.Principal == "Administrators" && a.Rights == "GENERIC_ALL" {
+	want := files.DesiredDACL(files.RequiredPerms.SystemPolicy)
+	if len(dacl) != len(want) {
+		t.Fatalf("expected DACL %+v, got: %+v", want, dacl)
+	}
+	var foundAdmin, foundSystem, foundOpksshuser bool
+	for _, a := range dacl {
+		switch {
+		case a.PrincipalSIDStr == files.SIDAdministrators && a.Rights == "GENERIC_ALL":
 			foundAdmin = true
-		}
-		if a.Principal == "opksshuser" && a.Rights == "GENERIC_READ" {
+		case a.PrincipalSIDStr == files.SIDSystem && a.Rights == "GENERIC_ALL":
+			foundSystem = true
+		case files.Principals().Service.Matches(a.Principal, a.PrincipalSIDStr) && a.Rights == "GENERIC_READ":
 			foundOpksshuser = true
 		}
 	}
-	if !foundAdmin {
-		t.Fatalf("expected ApplyACE for Administrators:GENERIC_ALL, got: %+v", mfs.Applied)
+	if !foundAdmin || !foundSystem || !foundOpksshuser {
+		t.Fatalf("expected Administrators:F, SYSTEM:F and opksshuser:R, got: %+v", dacl)
 	}
-	if !foundOpksshuser {
-		t.Fatalf("expected ApplyACE for opksshuser:GENERIC_READ, got: %+v", mfs.Applied)
+	if _, ok := mfs.DACLs[pluginsDir+"/plugin.yml"]; !ok {
+		t.Fatalf("expected the DACL of the plugin file to be set, got: %+v", mfs.DACLs)
 	}
 }
 
-func TestRunPermissionsFix_SkipsExistingACEs_Windows(t *testing.T) {
-	// Setup in-memory fs with system policy file, ACEs already present
+func TestRunPermissionsFix_SkipsCorrectDACL_Windows(t *testing.T) {
+	// Setup in-memory fs with system policy file, DACL already right
 	mem := afero.NewMemMapFs()
 	systemPolicy := policy.SystemDefaultPolicyPath
 	afero.WriteFile(mem, systemPolicy, []byte("x"), 0o644)
@@ -76,19 +82,20 @@ func TestRunPermissionsFix_SkipsExistingACEs_Windows(t *testing.T) {
 			Path:   systemPolicy,
 			Exists: true,
 			ACEs: []files.ACE{
-				{Principal: "Administrators", Rights: "GENERIC_ALL", Type: "allow"},
+				{Principal: "Administrators", PrincipalSIDStr: files.SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+				{Principal: "SYSTEM", PrincipalSIDStr: files.SIDSystem, Rights: "GENERIC_ALL", Type: "allow"},
 				{Principal: "opksshuser", Rights: "GENERIC_READ", Type: "allow"},
 			},
 		},
 	}
 
 	p := &PermissionsCmd{
-		FileSystem:    mfs,
-		Out:           &bytes.Buffer{},
-		ErrOut:        &bytes.Buffer{},
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
-		Yes:           true,
+		FileSystem:   mfs,
+		Out:          &bytes.Buffer{},
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
+		Yes:          true,
 	}
 
 	err := p.Fix()
@@ -96,42 +103,95 @@ func TestRunPermissionsFix_SkipsExistingACEs_Windows(t *testing.T) {
 		t.Fatalf("Fix failed: %v", err)
 	}
 
-	// No ACEs should have been applied since they already exist
-	for _, a := range mfs.Applied {
-		if a.Principal == "SYSTEM" {
-			t.Fatalf("should not apply SYSTEM ACE, but got: %+v", mfs.Applied)
-		}
+	// The DACL is not rewritten since it already matches
+	if dacl, ok := mfs.DACLs[systemPolicy]; ok {
+		t.Fatalf("should not set the DACL of %s, but got: %+v", systemPolicy, dacl)
 	}
 }
 
-func TestRunPermissionsFix_NoSystemACE_Windows(t *testing.T) {
-	// Verify that SYSTEM:F ACE is never applied
+func TestRunPermissionsFix_PlansACEChanges_Windows(t *testing.T) {
 	mem := afero.NewMemMapFs()
 	systemPolicy := policy.SystemDefaultPolicyPath
 	afero.WriteFile(mem, systemPolicy, []byte("x"), 0o644)
 
 	mfs := &mockFileSystem{
-		fs:        mem,
-		aclReport: files.ACLReport{Path: systemPolicy, Exists: true, ACEs: []files.ACE{}},
+		fs: mem,
+		aclReport: files.ACLReport{Path: systemPolicy, Exists: true, ACEs: []files.ACE{
+			{Principal: "Administrators", PrincipalSIDStr: files.SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+			{Principal: "BUILTIN\\Users", Rights: "GENERIC_READ", Type: "allow"},
+			{Principal: "Everyone", Rights: "GENERIC_READ", Type: "allow", Inherited: true},
+		}},
 	}
 
+	out := &bytes.Buffer{}
 	p := &PermissionsCmd{
-		FileSystem:    mfs,
-		Out:           &bytes.Buffer{},
-		ErrOut:        &bytes.Buffer{},
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
-		Yes:           true,
+		FileSystem:   mfs,
+		Out:          out,
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
+		DryRun:       true,
 	}
 
 	err := p.Fix()
 	if err != nil {
 		t.Fatalf("Fix failed: %v", err)
 	}
+	want := "set ACL of " + systemPolicy + ": disable inheritance, remove allow BUILTIN\\Users read, grant SYSTEM full control, grant opksshuser read"
+	if !strings.Contains(out.String(), want) {
+		t.Fatalf("expected %q in the plan, got: %s", want, out.String())
+	}
+	if len(mfs.DACLs) != 0 {
+		t.Fatalf("dry-run should not set any DACL, got: %+v", mfs.DACLs)
+	}
+}
 
-	for _, a := range mfs.Applied {
-		if a.Principal == "SYSTEM" {
-			t.Fatalf("SYSTEM ACE should not be applied, but got: %+v", mfs.Applied)
-		}
+func TestRunPermissionsFix_DisablesDirInheritance_Windows(t *testing.T) {
+	mem := afero.NewMemMapFs()
+	issuerDir := policy.SystemDefaultIssuerPolicyDir
+	mem.MkdirAll(issuerDir, 0o750)
+	mem.MkdirAll(policy.GetPluginPolicyDir(), 0o750)
+
+	explicit := files.ACE{Principal: "Administrators", PrincipalSIDStr: files.SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"}
+	mfs := &mockFileSystem{
+		fs: mem,
+		aclReport: files.ACLReport{Exists: true, ACEs: []files.ACE{
+			explicit,
+			{Principal: "BUILTIN\\Users", Rights: "GENERIC_READ", Type: "allow", Inherited: true},
+		}},
+	}
+	p := &PermissionsCmd{
+		FileSystem:   mfs,
+		Out:          &bytes.Buffer{},
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
+		Yes:          true,
+	}
+	if err := p.Fix(); err != nil {
+		t.Fatalf("Fix failed: %v", err)
+	}
+	if got := mfs.Inheritance[issuerDir]; len(got) != 1 || got[0] != files.InheritanceCopy {
+		t.Fatalf("expected the inheritance of %s to be disabled once, got: %v", issuerDir, got)
+	}
+	// policy.d keeps its permissions
+	if got, ok := mfs.Inheritance[policy.GetPluginPolicyDir()]; ok {
+		t.Fatalf("should not change the inheritance of policy.d, got: %v", got)
+	}
+
+	// Rolling back restores the ACEs of the directory itself and inherits again
+	mfs.Inheritance = nil
+	a := p.newAction(fixAction{Kind: fixInheritance, Path: issuerDir})
+	if err := a.Apply(); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if err := a.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if got := mfs.Inheritance[issuerDir]; len(got) != 2 || got[1] != files.InheritanceEnable {
+		t.Fatalf("expected inheritance to be enabled again, got: %v", got)
+	}
+	if got := mfs.DACLs[issuerDir]; len(got) != 1 || got[0].Principal != explicit.Principal || got[0].Rights != explicit.Rights {
+		t.Fatalf("expected the explicit ACEs to be restored, got: %+v", got)
 	}
 }
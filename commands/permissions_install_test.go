@@ -2,7 +2,6 @@ package commands
 
 import (
 	"bytes"
-	"io"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -13,11 +12,11 @@ func TestInstallCmd_ForceYes(t *testing.T) {
 	mfs := &mockFileSystem{fs: mem}
 
 	p := &PermissionsCmd{
-		FileSystem:    mfs,
-		Out:           &bytes.Buffer{},
-		ErrOut:        &bytes.Buffer{},
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
+		FileSystem:   mfs,
+		Out:          &bytes.Buffer{},
+		ErrOut:       &bytes.Buffer{},
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
 	}
 
 	// Execute the cobra command with 'install'
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 
@@ -16,10 +18,10 @@ func newTestPermissionsCmd(vfs afero.Fs, out io.Writer) *PermissionsCmd {
 		FileSystem: files.NewFileSystem(vfs, files.WithCmdRunner(func(name string, arg ...string) ([]byte, error) {
 			return []byte("root opksshuser"), nil
 		})),
-		Out:           out,
-		ErrOut:        out,
-		IsElevatedFn:  func() (bool, error) { return true, nil },
-		ConfirmPrompt: func(prompt string, in io.Reader) (bool, error) { return true, nil },
+		Out:          out,
+		ErrOut:       out,
+		IsElevatedFn: func() (bool, error) { return true, nil },
+		Prompter:     testPrompter{confirm: true},
 	}
 }
 
@@ -32,13 +34,47 @@ type mockFileSystem struct {
 	ChmodCalled bool
 	ChownCalled bool
 	Applied     []files.ACE
-	aclReport   files.ACLReport
+	// Removed records the ACEs RemoveACE removed
+	Removed   []files.ACE
+	aclReport files.ACLReport
+	// Immutable holds the paths with the system immutable flag set
+	Immutable map[string]bool
+	// Symlinks holds the paths Lstat reports as symbolic links
+	Symlinks map[string]bool
+	// Owners records the owner each path was changed to
+	Owners map[string]string
+	// DACLs records the DACL set on each path
+	DACLs map[string][]files.ACE
+	// Inheritance records the inheritance changes of each path in order
+	Inheritance map[string][]files.Inheritance
+	// SELinux holds the SELinux type of each path, SELinux is disabled if
+	// it is nil
+	SELinux map[string]string
+	// PathChain holds the problems CheckPathChain returns for each path
+	PathChain map[string][]string
+	// FailChown holds the paths Chown fails on
+	FailChown map[string]bool
 }
 
+// symlinkInfo is the fs.FileInfo of a path in mockFileSystem.Symlinks
+type symlinkInfo struct {
+	fs.FileInfo
+}
+
+func (symlinkInfo) Mode() fs.FileMode { return fs.ModeSymlink | 0o777 }
+
 func (m *mockFileSystem) Stat(path string) (fs.FileInfo, error) {
 	return m.fs.Stat(path)
 }
 
+func (m *mockFileSystem) Lstat(path string) (fs.FileInfo, error) {
+	fi, err := m.fs.Stat(path)
+	if err == nil && m.Symlinks[path] {
+		return symlinkInfo{fi}, nil
+	}
+	return fi, err
+}
+
 func (m *mockFileSystem) Exists(path string) (bool, error) {
 	return afero.Exists(m.fs, path)
 }
@@ -71,18 +107,113 @@ func (m *mockFileSystem) Chmod(path string, perm fs.FileMode) error {
 
 func (m *mockFileSystem) Chown(path string, owner string, group string) error {
 	m.ChownCalled = true
+	if m.FailChown[path] {
+		return errors.New("operation not permitted")
+	}
+	if m.Owners == nil {
+		m.Owners = map[string]string{}
+	}
+	m.Owners[path] = owner
+	return nil
+}
+
+// refuseSymlink fails like the NoFollow methods do for a path in Symlinks
+func (m *mockFileSystem) refuseSymlink(path string) error {
+	if m.Symlinks[path] {
+		return &files.PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)}
+	}
 	return nil
 }
 
+func (m *mockFileSystem) ChmodNoFollow(path string, perm fs.FileMode) error {
+	if err := m.refuseSymlink(path); err != nil {
+		return err
+	}
+	return m.Chmod(path, perm)
+}
+
+func (m *mockFileSystem) ChownNoFollow(path string, owner string, group string) error {
+	if err := m.refuseSymlink(path); err != nil {
+		return err
+	}
+	return m.Chown(path, owner, group)
+}
+
+func (m *mockFileSystem) RemoveACENoFollow(path string, ace files.ACE) error {
+	if err := m.refuseSymlink(path); err != nil {
+		return err
+	}
+	return m.RemoveACE(path, ace)
+}
+
 func (m *mockFileSystem) ApplyACE(path string, ace files.ACE) error {
 	m.Applied = append(m.Applied, ace)
 	return nil
 }
 
+func (m *mockFileSystem) RemoveACE(path string, ace files.ACE) error {
+	m.Removed = append(m.Removed, ace)
+	return nil
+}
+
+func (m *mockFileSystem) SetDACL(path string, aces []files.ACE) error {
+	if m.DACLs == nil {
+		m.DACLs = map[string][]files.ACE{}
+	}
+	m.DACLs[path] = aces
+	return nil
+}
+
+func (m *mockFileSystem) SetInheritance(path string, inheritance files.Inheritance) error {
+	if m.Inheritance == nil {
+		m.Inheritance = map[string][]files.Inheritance{}
+	}
+	m.Inheritance[path] = append(m.Inheritance[path], inheritance)
+	return nil
+}
+
+func (m *mockFileSystem) IsImmutable(path string) (bool, error) {
+	return m.Immutable[path], nil
+}
+
+func (m *mockFileSystem) SetImmutable(path string, immutable bool) error {
+	if m.Immutable == nil {
+		m.Immutable = map[string]bool{}
+	}
+	m.Immutable[path] = immutable
+	return nil
+}
+
+func (m *mockFileSystem) SELinuxType(path string) (string, error) {
+	if m.SELinux == nil {
+		return "", nil
+	}
+	if _, err := m.fs.Stat(path); err != nil {
+		return "", err
+	}
+	if typ, ok := m.SELinux[path]; ok {
+		return typ, nil
+	}
+	return files.SELinuxConfigType, nil
+}
+
+func (m *mockFileSystem) SetSELinuxType(path string, typ string, dir bool) error {
+	m.SELinux[path] = typ
+	return nil
+}
+
 func (m *mockFileSystem) CheckPerm(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) error {
 	return nil
 }
 
+func (m *mockFileSystem) Remove(path string) error {
+	return m.fs.Remove(path)
+}
+
+func (m *mockFileSystem) CheckPathChain(path string) ([]string, error) {
+	return m.PathChain[path], nil
+}
+
 func (m *mockFileSystem) VerifyACL(path string, expected files.ExpectedACL) (files.ACLReport, error) {
 	if m.aclReport.Path == "" {
 		return files.ACLReport{Path: path, Exists: true}, nil
@@ -0,0 +1,188 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// permissionsReport is written by permissions check --output. It has the
+// check results and the changes fix would make, so that the plan can be
+// reviewed and applied later as is by permissions fix --from-report.
+type permissionsReport struct {
+	Host    string          `json:"host"`
+	Created time.Time       `json:"created"`
+	Results []checkResult   `json:"results"`
+	Plan    []plannedAction `json:"plan"`
+}
+
+// plannedAction is the JSON form of a fixAction
+type plannedAction struct {
+	Action      string      `json:"action"`
+	Path        string      `json:"path"`
+	Mode        string      `json:"mode,omitempty"`
+	Owner       string      `json:"owner,omitempty"`
+	Group       string      `json:"group,omitempty"`
+	ACEs        []files.ACE `json:"aces,omitempty"`
+	SELinuxType string      `json:"selinuxType,omitempty"`
+	Dir         bool        `json:"dir,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+var fixActionNames = map[fixActionKind]string{
+	fixSkip:           "skip",
+	fixCreate:         "create",
+	fixMkdir:          "mkdir",
+	fixChmod:          "chmod",
+	fixChown:          "chown",
+	fixACL:            "acl",
+	fixClearImmutable: "clear-immutable",
+	fixSetImmutable:   "set-immutable",
+	fixSELinux:        "selinux",
+	fixInheritance:    "inheritance",
+	fixRemoveACE:      "remove-ace",
+}
+
+func newPlannedAction(a fixAction) plannedAction {
+	pa := plannedAction{
+		Action:      fixActionNames[a.Kind],
+		Path:        a.Path,
+		Owner:       a.Owner,
+		Group:       a.Group,
+		ACEs:        a.ACEs,
+		SELinuxType: a.SELinuxType,
+		Dir:         a.Dir,
+		Description: a.Desc,
+	}
+	if a.Kind == fixChmod || a.Kind == fixMkdir {
+		pa.Mode = fmt.Sprintf("%04o", a.Mode.Perm())
+	}
+	return pa
+}
+
+func (pa plannedAction) fixAction() (fixAction, error) {
+	a := fixAction{
+		Path:        pa.Path,
+		Owner:       pa.Owner,
+		Group:       pa.Group,
+		ACEs:        pa.ACEs,
+		SELinuxType: pa.SELinuxType,
+		Dir:         pa.Dir,
+		Desc:        pa.Description,
+	}
+	found := false
+	for kind, name := range fixActionNames {
+		if name == pa.Action {
+			a.Kind, found = kind, true
+		}
+	}
+	if !found {
+		return fixAction{}, fmt.Errorf("unknown action %q", pa.Action)
+	}
+	if pa.Path == "" {
+		return fixAction{}, fmt.Errorf("%s action without a path", pa.Action)
+	}
+	if a.Kind == fixChmod || a.Kind == fixMkdir {
+		mode, err := strconv.ParseUint(pa.Mode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return fixAction{}, fmt.Errorf("invalid mode %q for %s of %s", pa.Mode, pa.Action, pa.Path)
+		}
+		a.Mode = fs.FileMode(mode)
+	}
+	return a, nil
+}
+
+// writeReport writes the results of check and the plan of fix to path
+func (p *PermissionsCmd) writeReport(path string, results []checkResult) error {
+	report := permissionsReport{Created: time.Now().UTC(), Results: results, Plan: []plannedAction{}}
+	report.Host, _ = os.Hostname()
+	for _, a := range p.planFix(nil) {
+		report.Plan = append(report.Plan, newPlannedAction(a))
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := p.FileSystem.WriteFile(path, append(data, '\n'), 0o640); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// reportModes are the modes accepted for a report, which only its owner
+// can change
+var reportModes = []fs.FileMode{0o600, 0o640, 0o644, 0o400, 0o440, 0o444}
+
+// readReport returns the plan of the report written by check --output at
+// path. The report must have been made on this host and be owned by the
+// owner of the system policy, and every action in it must be one fix would
+// make now, so that a report edited or out of date is refused.
+func (p *PermissionsCmd) readReport(path string) ([]fixAction, error) {
+	owner := files.RequiredPerms.SystemPolicy.Owner
+	if err := p.FileSystem.CheckPerm(path, reportModes, owner, ""); err != nil {
+		return nil, fmt.Errorf("refusing report %s, it must be owned by %s and not writable by others: %w", path, owner, err)
+	}
+	data, err := p.FileSystem.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	var report permissionsReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	if host, _ := os.Hostname(); report.Host != host {
+		return nil, fmt.Errorf("report %s was made on %q, not on this host %q", path, report.Host, host)
+	}
+	var planned []plannedAction
+	for _, a := range p.planFix(nil) {
+		pa := newPlannedAction(a)
+		pa.Description = ""
+		planned = append(planned, pa)
+	}
+	var actions []fixAction
+	for i, pa := range report.Plan {
+		a, err := pa.fixAction()
+		if err != nil {
+			return nil, fmt.Errorf("report %s: action %d: %w", path, i+1, err)
+		}
+		if !containsAction(planned, pa) {
+			return nil, fmt.Errorf("report %s: action %d (%s %s) is not a change fix would make now, run permissions check --output again", path, i+1, pa.Action, pa.Path)
+		}
+		actions = append(actions, a)
+	}
+	return actions, nil
+}
+
+// containsAction reports whether planned has pa, the descriptions are not
+// compared
+func containsAction(planned []plannedAction, pa plannedAction) bool {
+	pa.Description = ""
+	for _, c := range planned {
+		if reflect.DeepEqual(c, pa) {
+			return true
+		}
+	}
+	return false
+}
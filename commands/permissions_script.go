@@ -0,0 +1,188 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// EmitFixScript writes a script to Out that makes the changes fix would
+// make, for hosts where opkssh is not allowed to run elevated and changes
+// have to be applied by configuration management. shell is bash or
+// powershell. Running the script again changes nothing.
+func (p *PermissionsCmd) EmitFixScript(shell string) error {
+	var writeAction func(io.Writer, fixAction)
+	switch shell {
+	case "bash":
+		writeAction = writeBashFixAction
+	case "powershell":
+		writeAction = writePowerShellFixAction
+	default:
+		return fmt.Errorf("unsupported script type %q; use bash or powershell", shell)
+	}
+
+	if shell == "bash" {
+		fmt.Fprintln(p.Out, "#!/usr/bin/env bash")
+	}
+	fmt.Fprintf(p.Out, "# Generated by opkssh permissions check --emit-script %s\n", shell)
+	fmt.Fprintln(p.Out, "# Makes the changes opkssh permissions fix would make on this host")
+	if shell == "bash" {
+		fmt.Fprintln(p.Out, "set -euo pipefail")
+	} else {
+		fmt.Fprintln(p.Out, "$ErrorActionPreference = 'Stop'")
+		fmt.Fprintln(p.Out, "function Invoke-Icacls { icacls @args | Out-Null; if ($LASTEXITCODE -ne 0) { throw \"icacls $args failed\" } }")
+	}
+	fmt.Fprintln(p.Out)
+	for _, a := range p.planFix(nil) {
+		writeAction(p.Out, a)
+	}
+	return nil
+}
+
+func writeBashFixAction(w io.Writer, a fixAction) {
+	path := bashQuote(a.Path)
+	switch a.Kind {
+	case fixSkip:
+		fmt.Fprintln(w, "#", a.Desc)
+	case fixCreate:
+		// Like fix, the parent directory is created if missing
+		fmt.Fprintf(w, "[ -e %s ] || { mkdir -p -m 0750 %s && touch %s; }\n", path, bashQuote(filepath.Dir(a.Path)), path)
+	case fixMkdir:
+		fmt.Fprintf(w, "mkdir -p -m %04o %s\n", a.Mode.Perm(), path)
+	case fixChmod:
+		fmt.Fprintf(w, "chmod %04o %s\n", a.Mode.Perm(), path)
+	case fixChown:
+		owner := a.Owner
+		if a.Group != "" {
+			owner += ":" + a.Group
+		}
+		fmt.Fprintf(w, "chown %s %s\n", bashQuote(owner), path)
+	case fixACL, fixInheritance:
+		fmt.Fprintf(w, "# %s: Windows ACLs are not supported in bash scripts\n", a.Path)
+	case fixRemoveACE:
+		for _, ace := range a.ACEs {
+			fmt.Fprintf(w, "setfacl -x %s %s\n", bashQuote(ace.Principal), path)
+		}
+	case fixClearImmutable:
+		fmt.Fprintf(w, "chflags noschg %s\n", path)
+	case fixSetImmutable:
+		fmt.Fprintf(w, "chflags schg %s\n", path)
+	case fixSELinux:
+		// Like fix, a file context rule is only added if the policy doesn't
+		// already give the type
+		spec := bashQuote(files.SELinuxFileSpec(a.Path, a.Dir))
+		fmt.Fprintf(w, "[ \"$(matchpathcon -n %s | cut -d: -f3)\" = %s ] || semanage fcontext -a -t %s %s || semanage fcontext -m -t %s %s\n",
+			path, a.SELinuxType, a.SELinuxType, spec, a.SELinuxType, spec)
+		if a.Dir {
+			fmt.Fprintf(w, "restorecon -R %s\n", path)
+		} else {
+			fmt.Fprintf(w, "restorecon %s\n", path)
+		}
+	}
+}
+
+func writePowerShellFixAction(w io.Writer, a fixAction) {
+	path := powerShellQuote(a.Path)
+	switch a.Kind {
+	case fixSkip:
+		fmt.Fprintln(w, "#", a.Desc)
+	case fixCreate:
+		// New-Item -Force creates the parent directory like fix does
+		fmt.Fprintf(w, "if (-not (Test-Path -LiteralPath %s)) { New-Item -ItemType File -Path %s -Force | Out-Null }\n", path, path)
+	case fixMkdir:
+		fmt.Fprintf(w, "if (-not (Test-Path -LiteralPath %s)) { New-Item -ItemType Directory -Path %s -Force | Out-Null }\n", path, path)
+	case fixChmod:
+		// Like os.Chmod on Windows, only the read-only attribute is set
+		if a.Mode&0o200 == 0 {
+			fmt.Fprintf(w, "attrib +R %s\n", path)
+		} else {
+			fmt.Fprintf(w, "attrib -R %s\n", path)
+		}
+	case fixChown:
+		if a.Owner == "" && a.Group == "" {
+			return
+		}
+		principals := files.Principals()
+		if a.Owner != "" {
+			fmt.Fprintf(w, "Invoke-Icacls %s /setowner %s\n", path, powerShellQuote(icaclsPrincipal(principals.Resolve(a.Owner))))
+		}
+		if a.Group != "" {
+			fmt.Fprintf(w, "Invoke-Icacls %s /grant %s\n", path, powerShellQuote(icaclsPrincipal(principals.Resolve(a.Group))+":(R)"))
+		}
+		fmt.Fprintf(w, "Invoke-Icacls %s /grant %s\n", path, powerShellQuote(icaclsPrincipal(principals.Administrators)+":(F)"))
+	case fixACL:
+		// Start from the inherited ACEs only, then drop them and grant
+		// exactly the desired ACEs
+		fmt.Fprintln(w, "#", a.Desc)
+		fmt.Fprintf(w, "Invoke-Icacls %s /reset\n", path)
+		grants := []string{}
+		for _, ace := range a.ACEs {
+			principal := icaclsPrincipal(files.SecurityPrincipal{Name: ace.Principal, SID: ace.PrincipalSIDStr})
+			grants = append(grants, powerShellQuote(principal+":("+icaclsRights(ace.Rights)+")"))
+		}
+		fmt.Fprintf(w, "Invoke-Icacls %s /inheritance:r /grant:r %s\n", path, strings.Join(grants, " "))
+	case fixInheritance:
+		fmt.Fprintf(w, "Invoke-Icacls %s /inheritance:d\n", path)
+	case fixRemoveACE:
+		for _, ace := range a.ACEs {
+			principal := icaclsPrincipal(files.SecurityPrincipal{Name: ace.Principal, SID: ace.PrincipalSIDStr})
+			fmt.Fprintf(w, "Invoke-Icacls %s /remove %s\n", path, powerShellQuote(principal))
+		}
+	case fixClearImmutable, fixSetImmutable:
+		fmt.Fprintf(w, "# %s: file flags are not supported in PowerShell scripts\n", a.Path)
+	case fixSELinux:
+		fmt.Fprintf(w, "# %s: SELinux is not supported in PowerShell scripts\n", a.Path)
+	}
+}
+
+// icaclsPrincipal names p for icacls, by SID when it is known so that
+// the script works whatever the language of the host
+func icaclsPrincipal(p files.SecurityPrincipal) string {
+	if p.SID != "" {
+		return "*" + p.SID
+	}
+	return p.Name
+}
+
+// icaclsRights returns the icacls permission for the rights of an ACE
+func icaclsRights(rights string) string {
+	switch rights {
+	case "GENERIC_ALL":
+		return "F"
+	case "GENERIC_READ":
+		return "R"
+	case "GENERIC_WRITE":
+		return "W"
+	case "GENERIC_EXECUTE":
+		return "RX"
+	default:
+		return rights
+	}
+}
+
+func bashQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -2,10 +2,18 @@ package commands
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/require"
 )
@@ -45,6 +53,88 @@ func TestPermissionsCheck_WithSystemPolicyAndPlugins_Succeeds(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestPermissionsCheckFormatJSON(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetSystemConfigBasePath(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = &mockFileSystem{fs: vfs, aclReport: files.ACLReport{
+		Path:        policy.SystemDefaultPolicyPath,
+		Exists:      true,
+		Owner:       "root",
+		OwnerSIDStr: "S-1-5-18",
+		Mode:        0o640,
+		ACEs: []files.ACE{
+			{Principal: "SYSTEM", PrincipalSIDStr: "S-1-5-18", Type: "allow", Rights: "GENERIC_ALL"},
+			{Principal: "Everyone", Type: "allow", Rights: "GENERIC_READ", Inherited: true},
+		},
+		Problems: []string{"Everyone has read access"},
+	}}
+
+	cmd := p.CobraCommand()
+	cmd.SetArgs([]string{"check", "--format", "json"})
+	cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, cmd.Execute())
+
+	// Only the JSON document is written
+	var results []checkResult
+	require.NoError(t, json.Unmarshal(out.Bytes(), &results), out.String())
+	require.Equal(t, policy.SystemDefaultPolicyPath, results[0].Path)
+	require.Equal(t, &aclResult{
+		Owner:    "root",
+		OwnerSID: "S-1-5-18",
+		Mode:     "0640",
+		ACEs: []aceResult{
+			{Principal: "SYSTEM", PrincipalSID: "S-1-5-18", Type: "allow", Rights: "GENERIC_ALL"},
+			{Principal: "Everyone", Type: "allow", Rights: "GENERIC_READ", Inherited: true},
+		},
+		Problems: []string{"Everyone has read access"},
+	}, results[0].ACL)
+
+	cmd = newTestPermissionsCmd(vfs, out).CobraCommand()
+	cmd.SetArgs([]string{"check", "--format", "yaml"})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	require.ErrorContains(t, cmd.Execute(), `invalid format "yaml"`)
+}
+
+func TestPermissionsCheckExitCodes(t *testing.T) {
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	vfs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	run := func(args ...string) int {
+		t.Helper()
+		cmd := newTestPermissionsCmd(vfs, out).CobraCommand()
+		cmd.SetArgs(append([]string{"check"}, args...))
+		cmd.SetOut(out)
+		cmd.SetErr(out)
+		var exitErr *ExitCodeError
+		if err := cmd.Execute(); err == nil {
+			return PermissionsCheckOK
+		} else if errors.As(err, &exitErr) {
+			return exitErr.Code
+		}
+		return -1
+	}
+
+	require.Equal(t, PermissionsCheckProblems, run())
+	require.Contains(t, out.String(), "Problem: ")
+	require.Equal(t, PermissionsCheckError, run("--format", "yaml"))
+	require.Equal(t, PermissionsCheckError, run("--bogus"))
+
+	out.Reset()
+	require.Equal(t, PermissionsCheckProblems, run("--quiet"))
+	require.Equal(t, PermissionsCheckError, run("--quiet", "--output", "/tmp/report.json"))
+	require.Empty(t, out.String())
+
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultProvidersPath, []byte(""), 0o640))
+	require.Equal(t, PermissionsCheckOK, run("-q"))
+	require.Empty(t, out.String())
+}
+
 func TestPermissionsFix_DryRun_NoPanic(t *testing.T) {
 	vfs := afero.NewMemMapFs()
 	out := &bytes.Buffer{}
@@ -56,3 +146,478 @@ func TestPermissionsFix_DryRun_NoPanic(t *testing.T) {
 	err := p.Fix()
 	require.NoError(t, err)
 }
+
+func TestPermissionsFix_DryRun_PlansStateDir(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	p.DryRun = true
+
+	err := p.Fix()
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "mkdir "+policy.GetSystemStateBasePath())
+	require.NotContains(t, out.String(), "read-only distribution default")
+}
+
+func TestPermissionsFixPaths(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	p.DryRun = true
+	p.Paths = []string{"policy.d", policy.GetSystemStateBasePath()}
+
+	require.NoError(t, p.Fix())
+	require.Contains(t, out.String(), "mkdir "+policy.GetPluginPolicyDir())
+	require.Contains(t, out.String(), "mkdir "+policy.GetSystemStateBasePath())
+	require.NotContains(t, out.String(), policy.SystemDefaultPolicyPath)
+
+	p.Paths = []string{"cache"}
+	require.ErrorContains(t, p.Fix(), `unknown path "cache", expected one of policy, auth_id.d, providers, providers.yml, config, ldap, ca_key, ca_keys.pub, policy.db, policy.d, state, jwks-cache, ratelimit, replay, telemetry, plugin-cache, fragments, backups or their paths`)
+
+	p.Paths = []string{"policy"}
+	p.User = "alice"
+	require.ErrorContains(t, p.Fix(), "--paths cannot be used with --user")
+}
+
+func TestPermissionsFix_SkipsImmutableFiles(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	mfs := &mockFileSystem{fs: vfs, Immutable: map[string]bool{policy.SystemDefaultPolicyPath: true}}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = mfs
+	p.DryRun = true
+
+	err := p.Fix()
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "skip "+policy.SystemDefaultPolicyPath+": immutable")
+	require.NotContains(t, out.String(), "chmod "+policy.SystemDefaultPolicyPath)
+}
+
+func TestPermissionsSELinux(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	base := policy.GetSystemConfigBasePath()
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultProvidersPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, "/opt/opkssh", []byte("binary"), 0o755))
+	out := &bytes.Buffer{}
+	// The config was moved from a home directory and kept its type
+	mfs := &mockFileSystem{fs: vfs, SELinux: map[string]string{
+		base:                              "user_home_t",
+		policy.SystemDefaultProvidersPath: "user_home_t",
+		"/opt/opkssh":                     "usr_t",
+		policy.GetSystemStateBasePath():   files.SELinuxStateType,
+		policy.JWKSCacheDir():             files.SELinuxStateType,
+		policy.RateLimitDir():             files.SELinuxStateType,
+		policy.ReplayCacheDir():           files.SELinuxStateType,
+		policy.TelemetryDir():             files.SELinuxStateType,
+		policy.GetPluginCacheDir():        files.SELinuxStateType,
+	}}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = mfs
+	p.Executable = "/opt/opkssh"
+
+	results, problems := p.checkPaths()
+	require.Equal(t, []string{
+		policy.SystemDefaultProvidersPath + ": SELinux type is user_home_t, expected etc_t",
+		base + ": SELinux type is user_home_t, expected etc_t",
+		"/opt/opkssh: SELinux type is usr_t, expected bin_t",
+	}, problems)
+	require.Equal(t, checkResult{Path: "/opt/opkssh", Exists: true, SELinuxType: "usr_t", SELinuxExpected: "bin_t"}, results[len(results)-1])
+
+	require.NoError(t, p.Fix())
+	require.Contains(t, out.String(), "set SELinux type of "+base+" to etc_t (is user_home_t)")
+	require.Equal(t, "etc_t", mfs.SELinux[base])
+	require.Equal(t, "etc_t", mfs.SELinux[policy.SystemDefaultProvidersPath])
+	require.Equal(t, "bin_t", mfs.SELinux["/opt/opkssh"])
+	_, problems = p.checkPaths()
+	require.Empty(t, problems)
+
+	mfs.SELinux["/opt/opkssh"] = "usr_t"
+	out.Reset()
+	require.NoError(t, p.EmitFixScript("bash"))
+	require.Contains(t, out.String(), `[ "$(matchpathcon -n '/opt/opkssh' | cut -d: -f3)" = bin_t ] || semanage fcontext -a -t bin_t '/opt/opkssh' || semanage fcontext -m -t bin_t '/opt/opkssh'`+"\nrestorecon '/opt/opkssh'\n")
+}
+
+func TestPermissionsCheckPolicyDirEntries(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	dir := policy.GetPluginPolicyDir()
+	require.NoError(t, vfs.MkdirAll(dir, 0o750))
+	require.NoError(t, vfs.Mkdir(filepath.Join(dir, "team"), 0o777))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultProvidersPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "a.yml"), []byte("name: a\ncommand: /usr/local/bin/plugin --flag\n"), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "b.yml"), []byte("name: b\ncommand: /usr/local/bin/missing\n"), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "team", "c.yml"), []byte("name: c\ncommand: /usr/local/bin/plugin\n"), 0o666))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "README"), []byte(""), 0o666))
+	require.NoError(t, afero.WriteFile(vfs, "/usr/local/bin/plugin", []byte("binary"), 0o777))
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	p.JsonOutput = true
+
+	results, problems := p.checkPaths()
+	require.Len(t, problems, 3, problems)
+	require.Contains(t, problems[0], "/usr/local/bin/plugin: policy plugin command: expected one of the following permissions [555, 755], got (777)")
+	require.Contains(t, problems[1], filepath.Join(dir, "team")+": expected one of the following permissions")
+	require.Contains(t, problems[2], filepath.Join(dir, "team", "c.yml")+": expected one of the following permissions [640], got (666)")
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+	// The command is checked once and a missing one is left to lint
+	require.Subset(t, paths, []string{filepath.Join(dir, "a.yml"), "/usr/local/bin/plugin", filepath.Join(dir, "b.yml"), filepath.Join(dir, "team"), filepath.Join(dir, "team", "c.yml")})
+	require.NotContains(t, paths, "/usr/local/bin/missing")
+	require.NotContains(t, paths, filepath.Join(dir, "README"))
+
+	// Symbolic links are reported and fix leaves them alone
+	mfs := &mockFileSystem{fs: vfs, Symlinks: map[string]bool{filepath.Join(dir, "b.yml"): true}}
+	p.FileSystem = mfs
+	_, problems = p.checkPaths()
+	require.Equal(t, []string{filepath.Join(dir, "b.yml") + ": is a symbolic link, replace it with the file it points to"}, problems)
+	p.JsonOutput = false
+	p.DryRun = true
+	require.NoError(t, p.Fix())
+	require.Contains(t, out.String(), "skip "+filepath.Join(dir, "b.yml")+": symbolic link")
+	require.Contains(t, out.String(), "chmod "+filepath.Join(dir, "team", "c.yml")+" to 0640")
+	require.NotContains(t, out.String(), "chmod /usr/local/bin/plugin")
+}
+
+func TestPermissionsCheckPathChain(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultProvidersPath, []byte(""), 0o640))
+	base := policy.GetSystemConfigBasePath()
+	writable := []string{base + " is writable by group staff (775)"}
+	p := newTestPermissionsCmd(vfs, &bytes.Buffer{})
+	p.FileSystem = &mockFileSystem{fs: vfs, PathChain: map[string][]string{
+		policy.SystemDefaultPolicyPath:    writable,
+		policy.SystemDefaultProvidersPath: writable,
+	}}
+
+	// The directory is reported once, and with each path below it
+	results, problems := p.checkPaths()
+	require.Equal(t, writable, problems)
+	require.Equal(t, writable, results[0].PathChain)
+	require.Equal(t, writable, results[1].PathChain)
+	require.ErrorContains(t, p.Check(), "1 problems found")
+}
+
+func TestPermissionsCheckExtendedACL(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	mallory := files.ACE{Principal: "user:mallory", Rights: "r--", Type: "allow"}
+	p.FileSystem = &mockFileSystem{fs: vfs, aclReport: files.ACLReport{
+		Path:       policy.SystemDefaultPolicyPath,
+		Exists:     true,
+		Mode:       0o640,
+		ACEs:       []files.ACE{mallory},
+		Unexpected: []files.ACE{mallory},
+		Problems:   []string{"extended ACL grants user:mallory r--"},
+	}}
+
+	// The mode bits are right, the ACL entry still fails the check
+	_, problems := p.checkPaths()
+	require.Contains(t, problems, policy.SystemDefaultPolicyPath+": unexpected ACL entry grants user:mallory r--")
+	require.ErrorContains(t, p.Check(), "problems found")
+	require.Contains(t, out.String(), "ACL problem: extended ACL grants user:mallory r--")
+}
+
+func TestPermissionsFixStrict(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	mallory := files.ACE{Principal: "user:mallory", Rights: "r--", Type: "allow"}
+	mfs := &mockFileSystem{fs: vfs, aclReport: files.ACLReport{
+		Path:       policy.SystemDefaultPolicyPath,
+		Exists:     true,
+		ACEs:       []files.ACE{mallory},
+		Unexpected: []files.ACE{mallory},
+	}}
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = mfs
+	p.Yes = true
+
+	// Without --strict the entries are only reported
+	require.NoError(t, p.Fix())
+	require.Empty(t, mfs.Removed)
+
+	p.Strict = true
+	p.DryRun = true
+	require.NoError(t, p.Fix())
+	require.Contains(t, out.String(), "remove ACL entries of "+policy.SystemDefaultPolicyPath+": user:mallory r--")
+
+	p.DryRun = false
+	require.NoError(t, p.Fix())
+	require.Contains(t, mfs.Removed, mallory)
+
+	// Rolling back grants the entry again
+	a := p.newAction(fixAction{Kind: fixRemoveACE, Path: policy.SystemDefaultPolicyPath, ACEs: []files.ACE{mallory}})
+	require.NoError(t, a.Apply())
+	require.NoError(t, a.Rollback())
+	require.Equal(t, []files.ACE{mallory}, mfs.Applied)
+
+	script := &bytes.Buffer{}
+	writeBashFixAction(script, fixAction{Kind: fixRemoveACE, Path: policy.SystemDefaultPolicyPath, ACEs: []files.ACE{mallory}})
+	require.Equal(t, "setfacl -x 'user:mallory' "+bashQuote(policy.SystemDefaultPolicyPath)+"\n", script.String())
+}
+
+func TestPermissionsFixRollback(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	dir := policy.GetPluginPolicyDir()
+	require.NoError(t, vfs.MkdirAll(dir, 0o755))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "a.yml"), []byte("name: a\n"), 0o644))
+	out := &bytes.Buffer{}
+	mfs := &mockFileSystem{fs: vfs, FailChown: map[string]bool{filepath.Join(dir, "a.yml"): true}}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = mfs
+	p.Yes = true
+	p.Verbose = true
+
+	err := p.Fix()
+	require.ErrorContains(t, err, "fix failed and was rolled back: chown "+filepath.Join(dir, "a.yml")+": operation not permitted")
+	require.Contains(t, out.String(), "create file: "+policy.SystemDefaultPolicyPath+"\n  before: missing\n  after:  mode ")
+	require.Contains(t, out.String(), "Rolled back the changes made before the error")
+
+	// The files are as they were before fix
+	_, err = vfs.Stat(policy.SystemDefaultPolicyPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	fi, err := vfs.Stat(filepath.Join(dir, "a.yml"))
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o644), fi.Mode().Perm())
+	require.Empty(t, mfs.Owners[policy.SystemDefaultPolicyPath])
+}
+
+func TestPermissionsFixFromReport(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetPluginPolicyDir(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o666))
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	cmd := p.CobraCommand()
+	cmd.SetArgs([]string{"check", "--format", "json", "--output", "/tmp/report.json"})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	require.ErrorContains(t, cmd.Execute(), "problems found")
+	require.Empty(t, out.String())
+
+	var report permissionsReport
+	data, err := afero.ReadFile(vfs, "/tmp/report.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &report))
+	require.Equal(t, policy.SystemDefaultPolicyPath, report.Results[0].Path)
+	require.Contains(t, report.Plan, plannedAction{
+		Action:      "chmod",
+		Path:        policy.SystemDefaultPolicyPath,
+		Mode:        "0640",
+		Description: "chmod " + policy.SystemDefaultPolicyPath + " to -rw-r-----",
+	})
+
+	// The saved plan is applied, not a new one
+	require.NoError(t, vfs.Remove(policy.GetPluginPolicyDir()))
+	p = newTestPermissionsCmd(vfs, out)
+	p.FileSystem = &mockFileSystem{fs: vfs}
+	p.FromReport = "/tmp/report.json"
+	p.Yes = true
+	require.NoError(t, p.Fix())
+	fi, err := vfs.Stat(policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o640), fi.Mode().Perm())
+	_, err = vfs.Stat(policy.GetPluginPolicyDir())
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	report.Host = "other"
+	data, _ = json.Marshal(report)
+	require.NoError(t, afero.WriteFile(vfs, "/tmp/report.json", data, 0o640))
+	require.ErrorContains(t, p.Fix(), `report /tmp/report.json was made on "other", not on this host`)
+
+	// The plan was applied, the report is out of date
+	report.Host, _ = os.Hostname()
+	data, _ = json.Marshal(report)
+	require.NoError(t, afero.WriteFile(vfs, "/tmp/report.json", data, 0o640))
+	require.ErrorContains(t, p.Fix(), "is not a change fix would make now, run permissions check --output again")
+
+	report.Plan = []plannedAction{{Action: "chmod", Path: "/etc/shadow", Mode: "7777"}}
+	data, _ = json.Marshal(report)
+	require.NoError(t, afero.WriteFile(vfs, "/tmp/report.json", data, 0o640))
+	require.ErrorContains(t, p.Fix(), `invalid mode "7777" for chmod of /etc/shadow`)
+
+	report.Plan = []plannedAction{{Action: "chmod", Path: "/etc/shadow", Mode: "0644"}}
+	data, _ = json.Marshal(report)
+	require.NoError(t, afero.WriteFile(vfs, "/tmp/report.json", data, 0o640))
+	require.ErrorContains(t, p.Fix(), "action 1 (chmod /etc/shadow) is not a change fix would make now")
+
+	// A report others can write is refused
+	require.NoError(t, vfs.Chmod("/tmp/report.json", 0o666))
+	p.FileSystem = newTestPermissionsCmd(vfs, out).FileSystem
+	require.ErrorContains(t, p.Fix(), "refusing report /tmp/report.json, it must be owned by "+files.RequiredPerms.SystemPolicy.Owner)
+
+	p.Paths = []string{"policy"}
+	require.ErrorContains(t, p.Fix(), "--from-report cannot be used with --user, --paths, --immutable or --strict")
+}
+
+func TestPermissionsFixUser(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	home := filepath.Join(string(filepath.Separator), "home", "alice")
+	dir := filepath.Join(home, ".opk")
+	policyPath := filepath.Join(dir, "auth_id")
+	require.NoError(t, vfs.MkdirAll(dir, 0o777))
+	require.NoError(t, afero.WriteFile(vfs, policyPath, []byte("alice alice@example.com https://accounts.google.com\n"), 0o666))
+
+	out := &bytes.Buffer{}
+	mfs := &mockFileSystem{fs: vfs}
+	p := newTestPermissionsCmd(vfs, out)
+	p.FileSystem = mfs
+	p.UserLookup = testUserLookup{"alice": {Username: "alice", HomeDir: home}}
+	p.User = "alice"
+	p.Yes = true
+
+	require.NoError(t, p.Fix())
+	require.Equal(t, map[string]string{dir: "alice", policyPath: "alice"}, mfs.Owners)
+	fi, err := vfs.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, files.ModeHomeDirPerms, fi.Mode().Perm())
+	fi, err = vfs.Stat(policyPath)
+	require.NoError(t, err)
+	require.Equal(t, files.ModeHomePerms, fi.Mode().Perm())
+	require.False(t, mfs.Created)
+
+	// System files are left alone
+	require.NotContains(t, out.String(), policy.SystemDefaultPolicyPath)
+
+	// A missing ~/.opk is created, without an auth_id
+	require.NoError(t, vfs.RemoveAll(dir))
+	mfs.Owners = nil
+	require.NoError(t, p.Fix())
+	require.Equal(t, map[string]string{dir: "alice"}, mfs.Owners)
+	exists, err := afero.Exists(vfs, policyPath)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestPermissionsFixUserRefuses(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	home := filepath.Join(string(filepath.Separator), "home", "alice")
+	dir := filepath.Join(home, ".opk")
+	require.NoError(t, vfs.MkdirAll(dir, 0o700))
+
+	mfs := &mockFileSystem{fs: vfs, Symlinks: map[string]bool{dir: true}}
+	p := newTestPermissionsCmd(vfs, &bytes.Buffer{})
+	p.FileSystem = mfs
+	p.UserLookup = testUserLookup{"alice": {Username: "alice", HomeDir: home}}
+	p.Yes = true
+
+	p.User = "bob"
+	require.ErrorContains(t, p.Fix(), "failed to lookup username bob")
+
+	p.User = "alice"
+	require.ErrorContains(t, p.Fix(), "is a symbolic link")
+	require.Empty(t, mfs.Owners)
+
+	mfs.Symlinks = nil
+	require.NoError(t, vfs.MkdirAll(filepath.Join(dir, "auth_id"), 0o700))
+	require.ErrorContains(t, p.Fix(), "is not a regular file")
+
+	// auth_id is swapped for a symbolic link after fix checked it
+	require.NoError(t, vfs.RemoveAll(filepath.Join(dir, "auth_id")))
+	require.NoError(t, afero.WriteFile(vfs, filepath.Join(dir, "auth_id"), []byte(""), 0o666))
+	p.Yes = false
+	p.Prompter = swapPrompter{swap: func() { mfs.Symlinks = map[string]bool{filepath.Join(dir, "auth_id"): true} }}
+	require.ErrorContains(t, p.Fix(), "is a symlink, symlinks are unsafe in this context")
+	require.NotContains(t, mfs.Owners, filepath.Join(dir, "auth_id"))
+
+	p.Immutable = true
+	require.ErrorContains(t, p.Fix(), "--immutable cannot be used with --user")
+}
+
+// swapPrompter calls swap when asked to confirm the changes, between the
+// time they're planned and applied
+type swapPrompter struct {
+	testPrompter
+	swap func()
+}
+
+func (p swapPrompter) Confirm(string) (bool, error) {
+	p.swap()
+	return true, nil
+}
+
+func TestPermissionsCheckHomePolicies(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	require.NoError(t, vfs.MkdirAll(policy.GetSystemConfigBasePath(), 0o750))
+	require.NoError(t, afero.WriteFile(vfs, policy.SystemDefaultPolicyPath, []byte(""), 0o640))
+	var homes []userHomeEntry
+	for _, name := range []string{"alice", "bob"} {
+		home := filepath.Join(string(filepath.Separator), "home", name)
+		require.NoError(t, vfs.MkdirAll(filepath.Join(home, ".opk"), 0o700))
+		require.NoError(t, afero.WriteFile(vfs, filepath.Join(home, ".opk", "auth_id"), []byte(name+" "+name+"@example.com https://accounts.google.com\n"), 0o600))
+		homes = append(homes, userHomeEntry{Username: name, HomeDir: home})
+	}
+	alicePolicy := filepath.Join(homes[0].HomeDir, ".opk", "auth_id")
+	bobPolicy := filepath.Join(homes[1].HomeDir, ".opk", "auth_id")
+
+	checked := func(access policy.HomePolicyAccess) []string {
+		t.Helper()
+		out := &bytes.Buffer{}
+		p := newTestPermissionsCmd(vfs, out)
+		p.FileSystem = &mockFileSystem{fs: vfs}
+		p.HomeDirs = func() ([]userHomeEntry, error) { return homes, nil }
+		p.HomePolicy = access
+		p.Groups = testGroupLookup{"opk-users": {"bob"}}
+		p.JsonOutput = true
+		require.NoError(t, p.Check())
+		var results []checkResult
+		require.NoError(t, json.Unmarshal(out.Bytes(), &results), out.String())
+		var paths []string
+		for _, r := range results {
+			if r.Path == alicePolicy || r.Path == bobPolicy {
+				paths = append(paths, r.Path)
+			}
+		}
+		return paths
+	}
+
+	require.Equal(t, []string{alicePolicy, bobPolicy}, checked(policy.HomePolicyAccess{}))
+	require.Equal(t, []string{alicePolicy}, checked(policy.HomePolicyAccess{AllowedPrincipals: []string{"alice"}}))
+	require.Equal(t, []string{bobPolicy}, checked(policy.HomePolicyAccess{AllowedGroups: []string{"opk-users"}}))
+	require.Empty(t, checked(policy.HomePolicyAccess{Disabled: true}))
+}
+
+func TestPermissionsEmitScript(t *testing.T) {
+	vfs := afero.NewMemMapFs()
+	path := policy.SystemDefaultPolicyPath
+	sp := files.RequiredPerms.SystemPolicy
+
+	out := &bytes.Buffer{}
+	p := newTestPermissionsCmd(vfs, out)
+	err := p.EmitFixScript("bash")
+	require.NoError(t, err)
+	script := out.String()
+	require.True(t, strings.HasPrefix(script, "#!/usr/bin/env bash\n"))
+	require.Contains(t, script, "[ -e '"+path+"' ] || { mkdir -p -m 0750 '"+filepath.Dir(path)+"' && touch '"+path+"'; }\n")
+	require.Contains(t, script, fmt.Sprintf("chmod %04o '%s'\n", sp.Mode.Perm(), path))
+	require.Contains(t, script, "mkdir -p -m ")
+
+	out.Reset()
+	err = p.EmitFixScript("powershell")
+	require.NoError(t, err)
+	require.Contains(t, out.String(), "$ErrorActionPreference = 'Stop'")
+	require.Contains(t, out.String(), "New-Item -ItemType File -Path '"+path+"'")
+
+	err = p.EmitFixScript("zsh")
+	require.ErrorContains(t, err, "unsupported script type")
+
+	// Nothing is changed
+	_, err = vfs.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestBashQuote(t *testing.T) {
+	require.Equal(t, `'/etc/opk/auth_id'`, bashQuote("/etc/opk/auth_id"))
+	require.Equal(t, `'it'\''s'`, bashQuote("it's"))
+	require.Equal(t, `'it''s'`, powerShellQuote("it's"))
+}
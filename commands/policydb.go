@@ -0,0 +1,146 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+const (
+	PolicyBackendFile   = "file"
+	PolicyBackendSQLite = "sqlite"
+)
+
+// PolicyStoreDB returns the policy database selected by cfg, or nil if the
+// system policy is stored in the policy file
+func PolicyStoreDB(cfg config.PolicyStoreConfig) (*policy.PolicyDB, error) {
+	switch cfg.Backend {
+	case "", PolicyBackendFile:
+		return nil, nil
+	case PolicyBackendSQLite:
+		db := policy.NewPolicyDB()
+		if cfg.Path != "" {
+			db.Path = cfg.Path
+		}
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unsupported policy store backend %q, expected %s or %s", cfg.Backend, PolicyBackendFile, PolicyBackendSQLite)
+	}
+}
+
+// ConfigurePolicyStore sets the store the loader reads and writes the system
+// policy in from cfg. If cfg is invalid the loader fails to load the system
+// policy.
+func ConfigurePolicyStore(loader *policy.SystemPolicyLoader, cfg config.PolicyStoreConfig) error {
+	db, err := PolicyStoreDB(cfg)
+	if err != nil {
+		loader.StoreErr = fmt.Errorf("invalid policy_store in server config: %w", err)
+		return err
+	}
+	loader.DB = db
+	return nil
+}
+
+// PolicyDBCmd converts the system policy between the policy file and the
+// SQLite policy store
+type PolicyDBCmd struct {
+	Fs      afero.Fs
+	Out     io.Writer
+	DB      *policy.PolicyDB
+	Journal *policy.Journal
+}
+
+// NewPolicyDBCmd creates a new PolicyDBCmd for the database at dbPath
+func NewPolicyDBCmd(rt *Runtime, dbPath string) *PolicyDBCmd {
+	return &PolicyDBCmd{
+		Fs:      rt.Fs,
+		Out:     rt.Out,
+		DB:      &policy.PolicyDB{Path: dbPath, Ops: files.NewDefaultFilePermsOps(rt.Fs)},
+		Journal: policy.NewJournal(),
+	}
+}
+
+// Import replaces the entries of the database with those of the policy file
+// at path and returns how many entries were imported. A file with lines
+// that can't be read is refused, as they would be lost.
+func (c *PolicyDBCmd) Import(path string) (int, error) {
+	content, err := afero.ReadFile(c.Fs, path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	p, problems := policy.FromTable(content, path)
+	if len(problems) > 0 {
+		return 0, fmt.Errorf("%s has %d invalid lines, the first is %s; fix them before importing", path, len(problems), problems[0].String())
+	}
+
+	if err := c.DB.CreateIfDoesNotExist(); err != nil {
+		return 0, err
+	}
+	lock, err := files.LockFile(afero.NewOsFs(), c.DB.Path, files.DefaultLockTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+	if err := c.DB.Store(p); err != nil {
+		return 0, err
+	}
+
+	entries := 0
+	for _, user := range p.Users {
+		entries += len(user.Principals)
+	}
+	if c.Journal != nil {
+		if err := c.Journal.Append(policy.JournalEntry{
+			Action:  "import",
+			Path:    c.DB.Path,
+			Summary: []string{fmt.Sprintf("replaced the entries with the %d entries of %s", entries, path)},
+		}); err != nil {
+			slog.Warn("Failed to record the change in the policy journal", "error", err)
+		}
+	}
+	return entries, nil
+}
+
+// Export writes the entries of the database as a policy file to path, or
+// to Out if path is empty
+func (c *PolicyDBCmd) Export(path string) error {
+	p, err := c.DB.Load()
+	if err != nil {
+		return err
+	}
+	content, err := p.ToTable()
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		_, err := c.Out.Write(content)
+		return err
+	}
+	lock, err := files.LockFile(c.Fs, path, files.DefaultLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	return files.WriteFileAtomic(c.Fs, path, content, files.ModeSystemPerms)
+}
@@ -0,0 +1,72 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyDBImportExport(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	policyFile := "root alice@example.com https://accounts.google.com\n" +
+		"dev alice@example.com https://accounts.google.com expires=2025-12-31\n"
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte(policyFile), 0640))
+
+	out := &bytes.Buffer{}
+	c := &PolicyDBCmd{Fs: mockFs, Out: out, DB: &policy.PolicyDB{Path: filepath.Join(t.TempDir(), "policy.db")}}
+	entries, err := c.Import("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, 2, entries)
+
+	require.NoError(t, c.Export(""))
+	require.Equal(t, policyFile, out.String())
+
+	require.NoError(t, c.Export("/etc/opk/exported"))
+	content, err := afero.ReadFile(mockFs, "/etc/opk/exported")
+	require.NoError(t, err)
+	require.Equal(t, policyFile, string(content))
+
+	// Lines that can't be read would be lost, so the import is refused
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte("root alice@example.com\n"), 0640))
+	_, err = c.Import("/etc/opk/auth_id")
+	require.ErrorContains(t, err, "/etc/opk/auth_id has 1 invalid lines")
+	out.Reset()
+	require.NoError(t, c.Export(""))
+	require.Equal(t, policyFile, out.String())
+}
+
+func TestConfigurePolicyStore(t *testing.T) {
+	loader := &policy.SystemPolicyLoader{PolicyLoader: &policy.PolicyLoader{}}
+	require.NoError(t, ConfigurePolicyStore(loader, config.PolicyStoreConfig{}))
+	require.Nil(t, loader.DB)
+
+	require.NoError(t, ConfigurePolicyStore(loader, config.PolicyStoreConfig{Backend: "sqlite", Path: "/srv/policy.db"}))
+	require.Equal(t, "/srv/policy.db", loader.Path())
+
+	// A typo must not silently fall back to the policy file
+	loader = &policy.SystemPolicyLoader{PolicyLoader: &policy.PolicyLoader{}}
+	require.ErrorContains(t, ConfigurePolicyStore(loader, config.PolicyStoreConfig{Backend: "sqlit"}), `unsupported policy store backend "sqlit"`)
+	_, _, err := loader.LoadSystemPolicy()
+	require.ErrorContains(t, err, "invalid policy_store in server config")
+}
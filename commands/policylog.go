@@ -0,0 +1,75 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+)
+
+// PolicyLogCmd prints the journal of policy mutations
+type PolicyLogCmd struct {
+	Journal *policy.Journal
+	Out     io.Writer
+
+	// Flags
+	Limit      int
+	JsonOutput bool
+}
+
+// NewPolicyLogCmd creates a new PolicyLogCmd reading the default journal
+func NewPolicyLogCmd(rt *Runtime) *PolicyLogCmd {
+	return &PolicyLogCmd{
+		Journal: policy.NewJournal(),
+		Out:     rt.Out,
+	}
+}
+
+// Run prints the most recent Limit journal entries, oldest first. A Limit of
+// zero prints every entry.
+func (p *PolicyLogCmd) Run() error {
+	entries, err := p.Journal.Entries()
+	if err != nil {
+		return err
+	}
+	if p.Limit > 0 && len(entries) > p.Limit {
+		entries = entries[len(entries)-p.Limit:]
+	}
+
+	if p.JsonOutput {
+		enc := json.NewEncoder(p.Out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	for _, e := range entries {
+		who := e.User
+		if e.SudoUser != "" {
+			who = fmt.Sprintf("%s (sudo by %s)", e.User, e.SudoUser)
+		}
+		fmt.Fprintf(p.Out, "%s %s %s %s\n", e.Time.Local().Format(time.RFC3339), who, e.Action, e.Path)
+		fmt.Fprintf(p.Out, "    command: %s\n", e.Command)
+		for _, line := range e.Summary {
+			fmt.Fprintf(p.Out, "    %s\n", line)
+		}
+	}
+	return nil
+}
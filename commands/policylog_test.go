@@ -0,0 +1,76 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddRecordsJournal(t *testing.T) {
+	t.Parallel()
+
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte{}, files.ModeSystemPerms))
+
+	addCmd := MockAddCmd(mockFs)
+	addCmd.Journal = &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath}
+	_, err := addCmd.Run("root", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	logCmd := &PolicyLogCmd{Journal: addCmd.Journal, Out: &out}
+	require.NoError(t, logCmd.Run())
+	require.Contains(t, out.String(), " add "+policy.SystemDefaultPolicyPath)
+	require.Contains(t, out.String(), "+ root alice@example.com https://accounts.google.com")
+}
+
+func TestPolicyLogLimitAndJson(t *testing.T) {
+	t.Parallel()
+
+	mockFs := afero.NewMemMapFs()
+	journal := &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath}
+	for _, action := range []string{"add", "fix", "add"} {
+		require.NoError(t, journal.Append(policy.JournalEntry{
+			Time:    time.Unix(0, 0).UTC(),
+			User:    "root",
+			Command: "opkssh " + action,
+			Action:  action,
+		}))
+	}
+
+	var out bytes.Buffer
+	logCmd := &PolicyLogCmd{Journal: journal, Out: &out, Limit: 2}
+	require.NoError(t, logCmd.Run())
+	require.Equal(t, 2, strings.Count(out.String(), "command: "))
+
+	out.Reset()
+	logCmd.JsonOutput = true
+	require.NoError(t, logCmd.Run())
+	var entries []policy.JournalEntry
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	require.Len(t, entries, 2)
+	require.Equal(t, "fix", entries[0].Action)
+}
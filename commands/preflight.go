@@ -0,0 +1,180 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Preflight modes set by preflight in the server config
+const (
+	// PreflightWarn logs the problems found and carries on in degraded mode
+	PreflightWarn = "warn"
+	// PreflightStrict refuses to authorize or start while there are errors
+	PreflightStrict = "strict"
+	// PreflightOff skips the validation
+	PreflightOff = "off"
+)
+
+// ValidationStatus is the outcome of a preflight
+type ValidationStatus string
+
+const (
+	ValidationOK       ValidationStatus = "ok"
+	ValidationDegraded ValidationStatus = "degraded"
+	ValidationFailed   ValidationStatus = "failed"
+	ValidationSkipped  ValidationStatus = "skipped"
+)
+
+// ValidationState is the result of the last preflight, served by the
+// health endpoint
+type ValidationState struct {
+	Status    ValidationStatus `json:"status"`
+	Mode      string           `json:"mode"`
+	CheckedAt time.Time        `json:"checked_at"`
+	Errors    int              `json:"errors"`
+	Warnings  int              `json:"warnings"`
+	// Findings only lists the errors
+	Findings []LintFinding `json:"findings,omitempty"`
+}
+
+// Preflight runs the lint checks over the providers, policy and plugin
+// configs before verify or a daemon uses them, so that a malformed file is
+// reported once instead of by every failed login.
+type Preflight struct {
+	Lint   *LintCmd
+	Mode   string
+	Logger *slog.Logger
+	Now    func() time.Time
+
+	mu    sync.Mutex
+	state *ValidationState
+}
+
+// NewPreflight creates a Preflight of the system configuration in mode
+func NewPreflight(rt *Runtime, mode string) *Preflight {
+	lint := NewLintCmd(rt)
+	// The home policies and permissions are checked by verify when it
+	// reads them
+	lint.FileSystem = nil
+	lint.SkipUserPolicy = true
+	return &Preflight{
+		Lint:   lint,
+		Mode:   mode,
+		Logger: rt.Logger,
+		Now:    rt.Now,
+	}
+}
+
+// NewVerifyPreflight creates the Preflight run by verify in mode. Every
+// login runs it, so it only parses the configuration, the account lookups
+// and plugin command checks of this host are left to serve and policy lint.
+func NewVerifyPreflight(rt *Runtime, mode string) *Preflight {
+	p := NewPreflight(rt, mode)
+	p.Lint.SkipHostChecks = true
+	return p
+}
+
+// Run validates the configuration and logs every error found. It returns an
+// error only in strict mode when the configuration has errors.
+func (p *Preflight) Run() (*ValidationState, error) {
+	mode := p.Mode
+	switch mode {
+	case "":
+		mode = PreflightWarn
+	case PreflightWarn, PreflightStrict, PreflightOff:
+	default:
+		p.Logger.Warn("Ignoring invalid preflight in config file", "preflight", mode, "using", PreflightWarn)
+		mode = PreflightWarn
+	}
+	state := &ValidationState{Status: ValidationOK, Mode: mode, CheckedAt: p.Now()}
+	defer p.setState(state)
+
+	if mode == PreflightOff {
+		state.Status = ValidationSkipped
+		return state, nil
+	}
+
+	findings, err := p.Lint.Lint()
+	if err != nil {
+		findings = []LintFinding{{Severity: LintError, Rule: LintRuleMissingFile, Message: err.Error()}}
+	}
+	for _, f := range findings {
+		switch f.Severity {
+		case LintError:
+			state.Errors++
+			state.Findings = append(state.Findings, f)
+			location := f.Path
+			if f.Line > 0 {
+				location = fmt.Sprintf("%s:%d", f.Path, f.Line)
+			}
+			p.Logger.Error("Preflight found a configuration error", "location", location, "rule", f.Rule, "message", f.Message)
+		case LintWarning:
+			state.Warnings++
+		}
+	}
+	if state.Errors == 0 {
+		return state, nil
+	}
+
+	if mode == PreflightStrict {
+		state.Status = ValidationFailed
+		return state, fmt.Errorf("preflight found %d errors in the configuration, run opkssh policy lint for details", state.Errors)
+	}
+	state.Status = ValidationDegraded
+	p.Logger.Warn("Preflight running in degraded mode, run opkssh policy lint for details", "errors", state.Errors)
+	return state, nil
+}
+
+func (p *Preflight) setState(state *ValidationState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+// State returns the result of the last Run, nil before the first
+func (p *Preflight) State() *ValidationState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// ServeHTTP serves the last ValidationState as JSON. The status code is 503
+// until a preflight has run and when it failed, so load balancers and
+// monitoring can alert on it.
+func (p *Preflight) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := p.State()
+	code := http.StatusOK
+	if state == nil {
+		state = &ValidationState{Status: ValidationFailed, Mode: p.Mode}
+		code = http.StatusServiceUnavailable
+	} else if state.Status == ValidationFailed {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(state)
+}
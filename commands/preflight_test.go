@@ -0,0 +1,127 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPreflight(t *testing.T, fs afero.Fs, mode string, logs *bytes.Buffer) *Preflight {
+	t.Helper()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &Preflight{
+		Lint:   newTestLintCmd(t, fs, &bytes.Buffer{}),
+		Mode:   mode,
+		Logger: slog.New(slog.NewTextHandler(logs, nil)),
+		Now:    func() time.Time { return now },
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte("https://accounts.google.com google-client 24h\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte(
+		"bob bob@example.com https://accounts.google.com\n"+
+			"root alice@example.com\n"), 0o640))
+
+	logs := &bytes.Buffer{}
+	state, err := newTestPreflight(t, fs, "", logs).Run()
+	require.NoError(t, err)
+	require.Equal(t, ValidationDegraded, state.Status)
+	require.Equal(t, PreflightWarn, state.Mode)
+	require.Equal(t, 1, state.Errors)
+	require.Equal(t, 1, state.Warnings)
+	require.Len(t, state.Findings, 1)
+	require.Contains(t, logs.String(), "location=/etc/opk/auth_id:2")
+	require.Contains(t, logs.String(), `msg="Preflight running in degraded mode, run opkssh policy lint for details" errors=1`)
+
+	state, err = newTestPreflight(t, fs, PreflightStrict, logs).Run()
+	require.ErrorContains(t, err, "preflight found 1 errors")
+	require.Equal(t, ValidationFailed, state.Status)
+
+	state, err = newTestPreflight(t, fs, PreflightOff, logs).Run()
+	require.NoError(t, err)
+	require.Equal(t, ValidationSkipped, state.Status)
+
+	// Warnings alone leave the configuration healthy
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte("bob bob@example.com https://accounts.google.com\n"), 0o640))
+	state, err = newTestPreflight(t, fs, PreflightStrict, logs).Run()
+	require.NoError(t, err)
+	require.Equal(t, ValidationOK, state.Status)
+
+	logs.Reset()
+	state, err = newTestPreflight(t, fs, "loud", logs).Run()
+	require.NoError(t, err)
+	require.Equal(t, PreflightWarn, state.Mode)
+	require.Contains(t, logs.String(), `msg="Ignoring invalid preflight in config file" preflight=loud using=warn`)
+}
+
+func TestVerifyPreflight(t *testing.T) {
+	t.Parallel()
+	rt := newTestRuntime(afero.NewMemMapFs(), &bytes.Buffer{}, time.Now())
+	// verify only parses the configuration, serve checks the host too
+	require.True(t, NewVerifyPreflight(rt, "").Lint.SkipHostChecks)
+	require.False(t, NewPreflight(rt, "").Lint.SkipHostChecks)
+}
+
+func TestPreflightHealth(t *testing.T) {
+	t.Parallel()
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte("https://accounts.google.com\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte(""), 0o640))
+	p := newTestPreflight(t, fs, PreflightStrict, &bytes.Buffer{})
+
+	get := func() (int, ValidationState) {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		var state ValidationState
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+		return rec.Code, state
+	}
+
+	code, state := get()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, ValidationFailed, state.Status)
+
+	_, err := p.Run()
+	require.Error(t, err)
+	code, state = get()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, 1, state.Errors)
+	require.Equal(t, LintRuleSyntax, state.Findings[0].Rule)
+
+	p.Mode = PreflightWarn
+	_, err = p.Run()
+	require.NoError(t, err)
+	code, state = get()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, ValidationDegraded, state.Status)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/healthz", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
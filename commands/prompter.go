@@ -0,0 +1,125 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ErrNonInteractive is returned by a Prompter when there is no user to ask,
+// for example when stdin is not a terminal or opkssh runs in CI. Callers
+// wrap it with the flag that avoids the prompt.
+var ErrNonInteractive = errors.New("not running in an interactive terminal")
+
+// Prompter asks the user for input
+type Prompter interface {
+	// Confirm asks a yes/no question, anything but y or yes is no
+	Confirm(prompt string) (bool, error)
+	// Select asks the user to pick one of options and returns its index
+	Select(prompt string, options []string) (int, error)
+	// Secret reads a line without echoing it
+	Secret(prompt string) (string, error)
+}
+
+// streamPrompter writes prompts to out and reads answers from in
+type streamPrompter struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	terminal    bool
+	interactive bool
+}
+
+// NewPrompter returns a Prompter that reads from in and writes to out. It
+// fails with ErrNonInteractive instead of waiting for input when in is not
+// a terminal or the CI environment variable is set.
+func NewPrompter(in io.Reader, out io.Writer) Prompter {
+	p := &streamPrompter{in: bufio.NewReader(in), out: out, fd: -1}
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.fd = int(f.Fd())
+		p.terminal = true
+	}
+	p.interactive = p.terminal && os.Getenv("CI") == ""
+	return p
+}
+
+func (p *streamPrompter) readLine(prompt string) (string, error) {
+	if !p.interactive {
+		return "", ErrNonInteractive
+	}
+	fmt.Fprint(p.out, prompt)
+	s, err := p.in.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func (p *streamPrompter) Confirm(prompt string) (bool, error) {
+	s, err := p.readLine(prompt)
+	if err != nil {
+		return false, err
+	}
+	s = strings.ToLower(s)
+	return s == "y" || s == "yes", nil
+}
+
+func (p *streamPrompter) Select(prompt string, options []string) (int, error) {
+	if len(options) == 0 {
+		return -1, fmt.Errorf("nothing to select")
+	}
+	if !p.interactive {
+		return -1, ErrNonInteractive
+	}
+	fmt.Fprintln(p.out, prompt)
+	for i, o := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, o)
+	}
+	s, err := p.readLine(fmt.Sprintf("Enter a number [1-%d]: ", len(options)))
+	if err != nil {
+		return -1, err
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(options) {
+		return -1, fmt.Errorf("invalid selection %q", s)
+	}
+	return n - 1, nil
+}
+
+func (p *streamPrompter) Secret(prompt string) (string, error) {
+	if !p.interactive {
+		return "", ErrNonInteractive
+	}
+	if !p.terminal {
+		return p.readLine(prompt)
+	}
+	fmt.Fprint(p.out, prompt)
+	b, err := term.ReadPassword(p.fd)
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
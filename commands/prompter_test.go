@@ -0,0 +1,106 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// testPrompter answers every prompt with its fields
+type testPrompter struct {
+	confirm  bool
+	selected int
+	secret   string
+	err      error
+}
+
+func (p testPrompter) Confirm(string) (bool, error)         { return p.confirm, p.err }
+func (p testPrompter) Select(string, []string) (int, error) { return p.selected, p.err }
+func (p testPrompter) Secret(string) (string, error)        { return p.secret, p.err }
+
+// newInteractivePrompter returns a prompter that answers with input as if
+// it were typed in a terminal
+func newInteractivePrompter(input string, out io.Writer) *streamPrompter {
+	return &streamPrompter{in: bufio.NewReader(strings.NewReader(input)), out: out, fd: -1, interactive: true}
+}
+
+func TestPrompterConfirm(t *testing.T) {
+	t.Parallel()
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false} {
+		out := &bytes.Buffer{}
+		ok, err := newInteractivePrompter(answer, out).Confirm("Apply? ")
+		require.NoError(t, err)
+		require.Equal(t, want, ok, answer)
+		require.Equal(t, "Apply? ", out.String())
+	}
+
+	_, err := newInteractivePrompter("", io.Discard).Confirm("Apply? ")
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestPrompterSelect(t *testing.T) {
+	t.Parallel()
+	out := &bytes.Buffer{}
+	i, err := newInteractivePrompter("2\n", out).Select("Provider:", []string{"google", "azure"})
+	require.NoError(t, err)
+	require.Equal(t, 1, i)
+	require.Equal(t, "Provider:\n  1) google\n  2) azure\nEnter a number [1-2]: ", out.String())
+
+	for _, answer := range []string{"0\n", "3\n", "azure\n"} {
+		_, err = newInteractivePrompter(answer, io.Discard).Select("Provider:", []string{"google", "azure"})
+		require.ErrorContains(t, err, "invalid selection", answer)
+	}
+	_, err = newInteractivePrompter("1\n", io.Discard).Select("Provider:", nil)
+	require.ErrorContains(t, err, "nothing to select")
+}
+
+func TestPrompterSecret(t *testing.T) {
+	t.Parallel()
+	s, err := newInteractivePrompter(" s3cret \n", io.Discard).Secret("Token: ")
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", s)
+}
+
+func TestPrompterNonInteractive(t *testing.T) {
+	t.Parallel()
+	// Input that is not a terminal is never read, so nothing hangs
+	out := &bytes.Buffer{}
+	p := NewPrompter(strings.NewReader("y\n"), out)
+	_, err := p.Confirm("Apply? ")
+	require.ErrorIs(t, err, ErrNonInteractive)
+	_, err = p.Select("Provider:", []string{"google"})
+	require.ErrorIs(t, err, ErrNonInteractive)
+	_, err = p.Secret("Token: ")
+	require.ErrorIs(t, err, ErrNonInteractive)
+	require.Empty(t, out.String())
+}
+
+func TestPermissionsFixNonInteractive(t *testing.T) {
+	t.Parallel()
+	p := newTestPermissionsCmd(afero.NewMemMapFs(), io.Discard)
+	p.Prompter = NewPrompter(strings.NewReader(""), io.Discard)
+	err := p.Fix()
+	require.ErrorIs(t, err, ErrNonInteractive)
+	require.ErrorContains(t, err, "use --yes")
+}
@@ -0,0 +1,184 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os/user"
+	"regexp"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+var validProvisionUsername = regexp.MustCompile(`^[a-z_][a-z0-9_\-.]*$`)
+
+// Provisioner creates a local account just in time when policy authorizes an
+// identity for a principal that does not exist yet, or the home directory of
+// an account resolved by NSS that has none. This lets admins avoid
+// pre-creating accounts that are rarely used.
+type Provisioner struct {
+	Fs afero.Fs
+	// Command, if set, is run instead of useradd. The username, identity
+	// (email or sub) and issuer are appended as arguments.
+	Command string
+	// Shell is the login shell of accounts created with useradd
+	Shell string
+	// Groups are the supplementary groups of accounts created with useradd
+	Groups []string
+	// UserLookup resolves accounts, including those of NSS sources
+	UserLookup policy.UserLookup
+	// CmdRunner can be mocked in tests
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+}
+
+// NewProvisioner creates a Provisioner from the provision section of the
+// server config
+func NewProvisioner(cfg config.ProvisionConfig) *Provisioner {
+	return &Provisioner{
+		Fs:         afero.NewOsFs(),
+		Command:    cfg.Command,
+		Shell:      cfg.Shell,
+		Groups:     cfg.Groups,
+		UserLookup: &nssUserLookup{CmdRunner: files.ExecCmd},
+		CmdRunner:  files.ExecCmd,
+	}
+}
+
+// EnsureUser creates the local account username if it can't be resolved, or
+// its home directory if it has none. It must only be called after policy has
+// authorized pkt to assume username.
+func (p *Provisioner) EnsureUser(username string, pkt *pktoken.PKToken) error {
+	account, err := p.UserLookup.Lookup(username)
+	if err == nil {
+		if account.HomeDir == "" {
+			return nil
+		}
+		if exists, err := afero.DirExists(p.Fs, account.HomeDir); err != nil {
+			return fmt.Errorf("failed to check the home directory of %s: %w", username, err)
+		} else if exists {
+			return nil
+		}
+	} else if _, ok := err.(user.UnknownUserError); !ok {
+		return fmt.Errorf("failed to lookup user %s: %w", username, err)
+	}
+
+	if !validProvisionUsername.MatchString(username) {
+		return fmt.Errorf("refusing to provision %s, it is not a valid username", username)
+	}
+
+	var name string
+	var args []string
+	if account == nil {
+		name, args, err = p.provisionCommand(username, pkt)
+	} else {
+		// The account is resolved by NSS, useradd would refuse it
+		name, args, err = p.homeCommand(username, pkt)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("Provisioning local account", "user", username, "command", name+" "+strings.Join(args, " "))
+	if out, err := p.CmdRunner(name, args...); err != nil {
+		return fmt.Errorf("failed to provision user %s: %w (output: %s)", username, err, strings.TrimSpace(string(out)))
+	}
+
+	account, err = p.UserLookup.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("provisioned user %s can not be found: %w", username, err)
+	}
+	if exists, _ := afero.DirExists(p.Fs, account.HomeDir); account.HomeDir != "" && !exists {
+		return fmt.Errorf("provisioned user %s has no home directory %s", username, account.HomeDir)
+	}
+	return nil
+}
+
+// homeCommand returns the command creating the home directory of an account
+// that already resolves. A custom Command is run as for a new account.
+func (p *Provisioner) homeCommand(username string, pkt *pktoken.PKToken) (string, []string, error) {
+	if p.Command != "" {
+		return p.provisionCommand(username, pkt)
+	}
+	return "sudo", []string{"-n", "mkhomedir_helper", username}, nil
+}
+
+func (p *Provisioner) provisionCommand(username string, pkt *pktoken.PKToken) (string, []string, error) {
+	if p.Command != "" {
+		command, err := shellquote.Split(p.Command)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to parse provision command: %w", err)
+		}
+		if len(command) == 0 {
+			return "", nil, fmt.Errorf("provision command is empty")
+		}
+
+		identity, issuer := "", ""
+		if pkt != nil {
+			idt, err := oidc.NewJwt(pkt.OpToken)
+			if err != nil {
+				return "", nil, err
+			}
+			claims := idt.GetClaims()
+			identity, issuer = claims.Email, claims.Issuer
+			if identity == "" {
+				identity = claims.Subject
+			}
+		}
+		return command[0], append(command[1:], username, identity, issuer), nil
+	}
+
+	// opkssh verify runs as the unprivileged AuthorizedKeysCommandUser and so
+	// needs a sudoers rule to run useradd
+	args := []string{"-n", "useradd", "-m"}
+	if p.Shell != "" {
+		args = append(args, "-s", p.Shell)
+	}
+	if len(p.Groups) > 0 {
+		args = append(args, "-G", strings.Join(p.Groups, ","))
+	}
+	return "sudo", append(args, "--", username), nil
+}
+
+// nssUserLookup resolves users with os/user and, as a build without cgo only
+// reads /etc/passwd, with getent so that NSS sources are resolved too
+type nssUserLookup struct {
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+}
+
+func (l *nssUserLookup) Lookup(username string) (*user.User, error) {
+	u, err := user.Lookup(username)
+	if _, ok := err.(user.UnknownUserError); !ok {
+		return u, err
+	}
+	// getent exits with 2 if the user is unknown
+	out, getentErr := l.CmdRunner("getent", "passwd", username)
+	if getentErr != nil {
+		return nil, err
+	}
+	fields := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(fields) != 7 || fields[0] != username {
+		return nil, fmt.Errorf("unexpected getent passwd output %q", strings.TrimSpace(string(out)))
+	}
+	return &user.User{Username: fields[0], Uid: fields[2], Gid: fields[3], Name: fields[4], HomeDir: fields[5]}, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"os/user"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// provisionUserLookup reports a user as unknown until it has been created
+type provisionUserLookup struct {
+	created map[string]bool
+}
+
+func (l *provisionUserLookup) Lookup(username string) (*user.User, error) {
+	if l.created[username] {
+		return &user.User{Username: username, HomeDir: "/home/" + username}, nil
+	}
+	return nil, user.UnknownUserError(username)
+}
+
+func TestProvisionerEnsureUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		provisioner  Provisioner
+		username     string
+		existing     bool
+		homeExists   bool
+		cmdErr       error
+		expectedCmd  []string
+		errorString  string
+		expectNoExec bool
+	}{
+		{
+			name:        "useradd with shell and groups",
+			provisioner: Provisioner{Shell: "/bin/bash", Groups: []string{"users", "dev"}},
+			username:    "alice",
+			expectedCmd: []string{"sudo", "-n", "useradd", "-m", "-s", "/bin/bash", "-G", "users,dev", "--", "alice"},
+		},
+		{
+			name:        "custom command",
+			provisioner: Provisioner{Command: "/usr/local/sbin/mkuser --quiet"},
+			username:    "alice",
+			expectedCmd: []string{"/usr/local/sbin/mkuser", "--quiet", "alice", "", ""},
+		},
+		{
+			name:         "existing user is not provisioned",
+			provisioner:  Provisioner{},
+			username:     "alice",
+			existing:     true,
+			homeExists:   true,
+			expectNoExec: true,
+		},
+		{
+			name:        "NSS user without a home directory",
+			provisioner: Provisioner{Shell: "/bin/bash", Groups: []string{"users"}},
+			username:    "alice",
+			existing:    true,
+			expectedCmd: []string{"sudo", "-n", "mkhomedir_helper", "alice"},
+		},
+		{
+			name:        "NSS user without a home directory with a custom command",
+			provisioner: Provisioner{Command: "/usr/local/sbin/mkuser"},
+			username:    "alice",
+			existing:    true,
+			expectedCmd: []string{"/usr/local/sbin/mkuser", "alice", "", ""},
+		},
+		{
+			name:         "invalid username",
+			provisioner:  Provisioner{},
+			username:     "-o root",
+			errorString:  "not a valid username",
+			expectNoExec: true,
+		},
+		{
+			name:        "command fails",
+			provisioner: Provisioner{},
+			username:    "alice",
+			cmdErr:      fmt.Errorf("exit status 1"),
+			expectedCmd: []string{"sudo", "-n", "useradd", "-m", "--", "alice"},
+			errorString: "failed to provision user alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			lookup := &provisionUserLookup{created: map[string]bool{tt.username: tt.existing}}
+			var executed []string
+			p := tt.provisioner
+			p.Fs = afero.NewMemMapFs()
+			if tt.homeExists {
+				require.NoError(t, p.Fs.MkdirAll("/home/"+tt.username, 0o750))
+			}
+			p.UserLookup = lookup
+			p.CmdRunner = func(name string, arg ...string) ([]byte, error) {
+				executed = append([]string{name}, arg...)
+				if tt.cmdErr != nil {
+					return []byte("useradd: failure"), tt.cmdErr
+				}
+				lookup.created[tt.username] = true
+				return nil, p.Fs.MkdirAll("/home/"+tt.username, 0o750)
+			}
+
+			err := p.EnsureUser(tt.username, nil)
+			if tt.errorString != "" {
+				require.ErrorContains(t, err, tt.errorString)
+			} else {
+				require.NoError(t, err)
+			}
+			if tt.expectNoExec {
+				require.Nil(t, executed)
+			} else {
+				require.Equal(t, tt.expectedCmd, executed)
+			}
+		})
+	}
+}
+
+func TestNSSUserLookup(t *testing.T) {
+	t.Parallel()
+	l := &nssUserLookup{CmdRunner: func(name string, arg ...string) ([]byte, error) {
+		require.Equal(t, []string{"getent", "passwd", "opkssh-nss-user"}, append([]string{name}, arg...))
+		return []byte("opkssh-nss-user:*:15001:15001:NSS User:/home/opkssh-nss-user:/bin/bash\n"), nil
+	}}
+	u, err := l.Lookup("opkssh-nss-user")
+	require.NoError(t, err)
+	require.Equal(t, "/home/opkssh-nss-user", u.HomeDir)
+	require.Equal(t, "15001", u.Uid)
+
+	l.CmdRunner = func(name string, arg ...string) ([]byte, error) {
+		return nil, fmt.Errorf("exit status 2")
+	}
+	_, err = l.Lookup("opkssh-nss-user")
+	require.IsType(t, user.UnknownUserError(""), err)
+}
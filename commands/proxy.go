@@ -0,0 +1,107 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/opkssh/commands/config"
+)
+
+// ConnectionSource is where an SSH connection comes from, as reported by
+// sshd's %C token
+type ConnectionSource struct {
+	Address netip.Addr
+	Port    string
+	// Proxy is the name of the trusted proxy at Address, empty if the
+	// connection did not come through one
+	Proxy string
+}
+
+// ProxyPolicy restricts principals to connections that come through a
+// trusted SSH proxy or bastion
+type ProxyPolicy struct {
+	trusted    map[string][]netip.Prefix
+	names      []string
+	requireFor []string
+}
+
+// NewProxyPolicy parses the trusted proxies in cfg
+func NewProxyPolicy(cfg config.ProxyConfig) (*ProxyPolicy, error) {
+	p := &ProxyPolicy{trusted: map[string][]netip.Prefix{}, requireFor: cfg.RequireFor}
+	for _, proxy := range cfg.Trusted {
+		if proxy.Name == "" {
+			return nil, fmt.Errorf("trusted proxy is missing a name")
+		}
+		for _, address := range proxy.Addresses {
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(address)
+				if addrErr != nil {
+					return nil, fmt.Errorf("invalid address %q for proxy %s", address, proxy.Name)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			p.trusted[proxy.Name] = append(p.trusted[proxy.Name], prefix.Masked())
+		}
+		p.names = append(p.names, proxy.Name)
+	}
+	return p, nil
+}
+
+// Source parses connection, the expansion of sshd's %C token ("client
+// address, client port, server address, server port"), and looks up the
+// proxy it came through
+func (p *ProxyPolicy) Source(connection string) (ConnectionSource, error) {
+	fields := strings.Fields(connection)
+	if len(fields) != 4 {
+		return ConnectionSource{}, fmt.Errorf("invalid connection %q, expected the value of %%C", connection)
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return ConnectionSource{}, fmt.Errorf("invalid client address in connection %q", connection)
+	}
+	src := ConnectionSource{Address: addr.Unmap(), Port: fields[1]}
+	for _, name := range p.names {
+		for _, prefix := range p.trusted[name] {
+			if prefix.Contains(src.Address) {
+				src.Proxy = name
+				return src, nil
+			}
+		}
+	}
+	return src, nil
+}
+
+// Check returns an error if principal can only be assumed through a trusted
+// proxy and src did not come through one. src is nil when verify was not
+// passed the connection.
+func (p *ProxyPolicy) Check(principal string, src *ConnectionSource) error {
+	if !slices.Contains(p.requireFor, principal) {
+		return nil
+	}
+	if src == nil {
+		return fmt.Errorf("principal %s requires a trusted proxy but the connection is unknown, pass --connection %%C to verify", principal)
+	}
+	if src.Proxy == "" {
+		return fmt.Errorf("principal %s requires a trusted proxy but the connection came from %s", principal, src.Address)
+	}
+	return nil
+}
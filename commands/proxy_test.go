@@ -0,0 +1,63 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPolicy(t *testing.T) {
+	t.Parallel()
+	proxy, err := NewProxyPolicy(config.ProxyConfig{
+		Trusted: []config.TrustedProxy{
+			{Name: "bastion", Addresses: []string{"10.0.0.5", "2001:db8::/64"}},
+			{Name: "teleport", Addresses: []string{"10.1.0.0/16"}},
+		},
+		RequireFor: []string{"root"},
+	})
+	require.NoError(t, err)
+
+	src, err := proxy.Source("10.1.4.2 40022 10.0.1.20 22")
+	require.NoError(t, err)
+	require.Equal(t, "teleport", src.Proxy)
+	require.Equal(t, "40022", src.Port)
+	require.NoError(t, proxy.Check("root", &src))
+
+	src, err = proxy.Source("2001:db8::1 40022 2001:db8::2 22")
+	require.NoError(t, err)
+	require.Equal(t, "bastion", src.Proxy)
+
+	src, err = proxy.Source("::ffff:10.0.0.5 40022 10.0.1.20 22")
+	require.NoError(t, err)
+	require.Equal(t, "bastion", src.Proxy)
+
+	direct, err := proxy.Source("10.0.0.6 40022 10.0.1.20 22")
+	require.NoError(t, err)
+	require.Empty(t, direct.Proxy)
+	require.ErrorContains(t, proxy.Check("root", &direct), "requires a trusted proxy")
+	require.NoError(t, proxy.Check("dev", &direct))
+	require.ErrorContains(t, proxy.Check("root", nil), "connection is unknown")
+
+	_, err = proxy.Source("10.0.0.5")
+	require.ErrorContains(t, err, "expected the value of %C")
+
+	_, err = NewProxyPolicy(config.ProxyConfig{Trusted: []config.TrustedProxy{{Name: "bastion", Addresses: []string{"bastion.example.com"}}}})
+	require.ErrorContains(t, err, "invalid address")
+}
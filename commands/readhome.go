@@ -14,30 +14,33 @@
 //
 // SPDX-License-Identifier: Apache-2.0
 
-//go:build linux || darwin
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
 
 package commands
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
-	"io"
 	"os"
+	"os/exec"
 	"os/user"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"syscall"
 
-	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy"
 )
 
 // ReadHome is used to read the home policy file for the user with
 // the specified username. This is used when opkssh is called by
 // AuthorizedKeysCommand as the opksshuser and needs to use sudoer
 // access to read the home policy file (`/home/<username>/opk/auth_id`).
-// This function is only available on Linux and Darwin because it relies on
-// syscall.Stat_t to determine the owner of the file.
+//
+// Security critical: sudo runs this function as root, which could read
+// any file a symlink in the home directory points to. When it runs as
+// root, opkssh readhome runs again as the user, so the file is read with
+// the privileges of the user, and policy.HomeReader refuses symlinks and
+// files not owned by the user.
 func ReadHome(username string) ([]byte, error) {
 	if matched, _ := regexp.MatchString("^[a-z0-9_\\-.]+$", username); !matched {
 		return nil, fmt.Errorf("%s is not a valid linux username", username)
@@ -47,47 +50,48 @@ func ReadHome(username string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to find user %s", username)
 	}
-	homePolicyPath := filepath.Join(userObj.HomeDir, ".opk", "auth_id")
+	if os.Geteuid() == 0 && userObj.Uid != "0" {
+		return readHomeAsUser(userObj)
+	}
+	return policy.NewHomeReader().Read(userObj)
+}
 
-	// Security critical: We reading this file as `sudo -u opksshuser`
-	// and opksshuser has elevated permissions to read any file whose
-	// path matches `/home/*/opk/auth_id`. We need to be cautious we do follow
-	// a symlink as it could be to a file the user is not permitted to read.
-	// This would not permit the user to read the file, but they might be able
-	// to determine the existence of the file. We use O_NOFOLLOW to prevent
-	// following symlinks.
-	file, err := os.OpenFile(homePolicyPath, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+// readHomeAsUser runs opkssh readhome as u and returns what it read. The
+// credentials are set in the child process after fork and before exec, so
+// opkssh never runs as the user with root privileges in reach.
+func readHomeAsUser(u *user.User) ([]byte, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
 	if err != nil {
-		if errors.Is(err, syscall.ELOOP) {
-			return nil, fmt.Errorf("home policy file %s is a symlink, symlink are unsafe in this context", homePolicyPath)
+		return nil, fmt.Errorf("invalid UID %s of user %s", u.Uid, u.Username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GID %s of user %s", u.Gid, u.Username)
+	}
+	var groups []uint32
+	if groupIds, err := u.GroupIds(); err == nil {
+		for _, id := range groupIds {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
 		}
-		return nil, fmt.Errorf("failed to open %s, %v", homePolicyPath, err)
 	}
-	defer file.Close()
 
-	if fileInfo, err := file.Stat(); err != nil {
-		return nil, fmt.Errorf("failed to get info on file %s", homePolicyPath)
-	} else if stat, ok := fileInfo.Sys().(*syscall.Stat_t); !ok { // This syscall.Stat_t is doesn't work on Windows
-		return nil, fmt.Errorf("failed to stat file %s", homePolicyPath)
-	} else {
-		// We want to ensure that the file is owned by the correct user and has the correct permissions.
-		requiredOwnerUid := userObj.Uid
-		fileOwnerUID := strconv.FormatUint(uint64(stat.Uid), 10)
-		fileOwner, err := user.LookupId(fileOwnerUID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to find username for UID %s for file %s", fileOwnerUID, homePolicyPath)
-		}
-		if fileOwnerUID != userObj.Uid || fileOwner.Username != username {
-			return nil, fmt.Errorf("unsafe file permissions on %s expected file owner %s (UID %s) got %s (UID %s)",
-				homePolicyPath, username, requiredOwnerUid, fileOwner.Username, fileOwnerUID)
-		}
-		if fileInfo.Mode().Perm() != files.ModeHomePerms {
-			return nil, fmt.Errorf("unsafe file permissions for %s got %o expected %o", homePolicyPath, fileInfo.Mode().Perm(), files.ModeHomePerms)
-		}
-		fileBytes, err := io.ReadAll(file)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read %s, %v", homePolicyPath, err)
-		}
-		return fileBytes, nil
+	opkBin, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("error getting opkssh executable path: %w", err)
+	}
+	cmd := exec.Command(opkBin, "readhome", u.Username)
+	cmd.Dir = "/"
+	cmd.Env = []string{}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	content, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the home policy as %s: %v: %s", u.Username, err, bytes.TrimSpace(stderr.Bytes()))
 	}
+	return content, nil
 }
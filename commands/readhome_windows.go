@@ -27,6 +27,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
 	"github.com/spf13/afero"
 )
@@ -100,10 +101,8 @@ func ReadHome(username string) ([]byte, error) {
 		return nil, fmt.Errorf("ACL problems on %s: %s", homePolicyPath, strings.Join(report.Problems, "; "))
 	}
 
-	// Read and return file contents
-	content, err := os.ReadFile(homePolicyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read %s: %w", homePolicyPath, err)
-	}
-	return content, nil
+	// Impersonating the user needs a logon token of the user. Instead the
+	// file is read without following symlinks, and the file opened must be
+	// the one whose owner was checked.
+	return policy.NewHomeReader().Read(userObj)
 }
@@ -0,0 +1,105 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+)
+
+const (
+	ReplayBackendMemory = "memory"
+	ReplayBackendFile   = "file"
+	ReplayBackendSQLite = "sqlite"
+
+	ReplayBindHost   = "host"
+	ReplayBindClient = "client"
+)
+
+// NewReplayGuard returns the replay guard configured by cfg. memory is the
+// store of the memory backend, which only opkssh serve keeps between
+// logins.
+func NewReplayGuard(cfg config.ReplayCacheConfig, memory *policy.MemoryReplayStore) (*policy.ReplayGuard, error) {
+	guard := &policy.ReplayGuard{SingleUse: cfg.SingleUse, RequireJTI: cfg.RequireJTI}
+	switch cfg.Bind {
+	case "", ReplayBindHost:
+	case ReplayBindClient:
+		guard.BindClient = true
+	default:
+		return nil, fmt.Errorf("unsupported replay_cache bind %q, expected %s or %s", cfg.Bind, ReplayBindHost, ReplayBindClient)
+	}
+	switch cfg.Backend {
+	case ReplayBackendMemory:
+		if memory == nil {
+			return nil, fmt.Errorf("the %s replay_cache backend only works with opkssh serve", ReplayBackendMemory)
+		}
+		guard.Store = memory
+	case ReplayBackendFile:
+		path := cfg.Path
+		if path == "" {
+			path = policy.ReplayCacheDir()
+		}
+		guard.Store = policy.NewFileReplayStore(path)
+	case ReplayBackendSQLite:
+		path := cfg.Path
+		if path == "" {
+			path = policy.ReplayCacheDBPath()
+		}
+		guard.Store = policy.NewSQLiteReplayStore(path)
+	default:
+		return nil, fmt.Errorf("unsupported replay_cache backend %q, expected %s, %s or %s", cfg.Backend, ReplayBackendMemory, ReplayBackendFile, ReplayBackendSQLite)
+	}
+	return guard, nil
+}
+
+// replayStoreError is the store of an invalid replay_cache, which fails
+// every login
+type replayStoreError struct {
+	err error
+}
+
+func (s replayStoreError) Record(jti string, binding string, expires time.Time) (string, bool, error) {
+	return "", false, s.err
+}
+
+// checkReplay records the login with pkt from clientIP in the replay cache
+func (v *VerifyCmd) checkReplay(pkt *pktoken.PKToken, clientIP string) error {
+	var claims oidc.OidcClaims
+	var jti struct {
+		JTI string `json:"jti"`
+	}
+	if err := json.Unmarshal(pkt.Payload, &claims); err != nil {
+		return fmt.Errorf("failed to read the ID Token claims: %w", err)
+	}
+	if err := json.Unmarshal(pkt.Payload, &jti); err != nil {
+		return fmt.Errorf("failed to read the ID Token claims: %w", err)
+	}
+	// The entry is kept for as long as the token is accepted
+	expires := time.Unix(claims.Expiration, 0)
+	if v.ProviderPolicy != nil {
+		if expiresAt, ok := v.ProviderPolicy.ExpiresAt(claims.Issuer, claims.Audience, time.Unix(claims.IssuedAt, 0), expires); ok {
+			expires = expiresAt
+		}
+	}
+	return v.Replay.Check(jti.JTI, clientIP, expires)
+}
@@ -0,0 +1,245 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// RewriteIssuerCmd replaces an issuer URL with another in the entries of the
+// system policy and the per-issuer policies, e.g. once the users of an
+// OpenID Provider were migrated to a new tenant. Home policies are left to
+// their owners and policy fragments to where they are published, the
+// entries found in fragments are only reported.
+type RewriteIssuerCmd struct {
+	Out                io.Writer
+	SystemPolicyLoader *policy.SystemPolicyLoader
+	// IssuerPolicies and Fragments, if set, are the per-issuer policies and
+	// the policy fragments
+	IssuerPolicies *policy.IssuerPolicyStore
+	Fragments      *policy.FragmentStore
+	Journal        *policy.Journal
+	// DryRun reports the entries that would be rewritten without changing
+	// any file
+	DryRun bool
+}
+
+// NewRewriteIssuerCmd creates a RewriteIssuerCmd of the system policy files
+func NewRewriteIssuerCmd(rt *Runtime) *RewriteIssuerCmd {
+	return &RewriteIssuerCmd{
+		Out:                rt.Out,
+		SystemPolicyLoader: policy.NewSystemPolicyLoader(),
+		IssuerPolicies:     policy.NewIssuerPolicyStore(),
+		Fragments:          policy.NewFragmentStore(),
+		Journal:            policy.NewJournal(),
+	}
+}
+
+// Run rewrites the entries of oldIssuer to newIssuer and returns how many
+// entries were rewritten
+func (c *RewriteIssuerCmd) Run(oldIssuer string, newIssuer string) (int, error) {
+	if oldIssuer == "" || newIssuer == "" {
+		return 0, fmt.Errorf("both the old and the new issuer are required")
+	}
+	if oldIssuer == newIssuer {
+		return 0, fmt.Errorf("the old and the new issuer are both %s", oldIssuer)
+	}
+
+	total, err := c.rewriteSystemPolicy(oldIssuer, newIssuer)
+	if err != nil {
+		return total, err
+	}
+	if c.IssuerPolicies != nil {
+		rewritten, err := c.rewriteIssuerPolicies(oldIssuer, newIssuer)
+		total += rewritten
+		if err != nil {
+			return total, err
+		}
+	}
+	if c.Fragments != nil {
+		c.reportFragments(oldIssuer)
+	}
+	if total == 0 {
+		fmt.Fprintf(c.Out, "No policy entries of %s found\n", oldIssuer)
+	}
+	return total, nil
+}
+
+// rewriteSystemPolicy rewrites the system policy, keeping the comments of
+// the policy file
+func (c *RewriteIssuerCmd) rewriteSystemPolicy(oldIssuer string, newIssuer string) (int, error) {
+	loader := c.SystemPolicyLoader
+	path := loader.Path()
+	lock, err := loader.FileLoader.Lock(path)
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Unlock()
+
+	if loader.DB != nil {
+		systemPolicy, _, err := loader.LoadSystemPolicy()
+		if err != nil {
+			return 0, fmt.Errorf("failed to load system policy: %w", err)
+		}
+		rewritten := 0
+		for i := range systemPolicy.Users {
+			if systemPolicy.Users[i].Issuer == oldIssuer {
+				systemPolicy.Users[i].Issuer = newIssuer
+				rewritten++
+			}
+		}
+		return rewritten, c.commit(path, rewritten, oldIssuer, newIssuer, func() error {
+			return loader.Dump(systemPolicy, path)
+		})
+	}
+
+	content, err := afero.ReadFile(loader.FileLoader.Fs, path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read system policy: %w", err)
+	}
+	content, rewritten := policy.RewriteIssuer(content, oldIssuer, newIssuer)
+	return rewritten, c.commit(path, rewritten, oldIssuer, newIssuer, func() error {
+		return loader.FileLoader.Dump(content, path)
+	})
+}
+
+// rewriteIssuerPolicies rewrites the per-issuer policies. The policy of
+// oldIssuer is renamed to the policy of newIssuer, or appended to it if
+// there is one already.
+func (c *RewriteIssuerCmd) rewriteIssuerPolicies(oldIssuer string, newIssuer string) (int, error) {
+	store := c.IssuerPolicies
+	paths, err := store.Paths()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the issuer policies: %w", err)
+	}
+	fileLoader := files.FileLoader{Fs: store.Fs, RequiredPerm: files.ModeSystemPerms, Backups: c.SystemPolicyLoader.FileLoader.Backups}
+	total := 0
+	for _, path := range paths {
+		content, err := afero.ReadFile(store.Fs, path)
+		if err != nil {
+			return total, fmt.Errorf("failed to read issuer policy: %w", err)
+		}
+		content, rewritten := policy.RewriteIssuer(content, oldIssuer, newIssuer)
+		if !policy.IssuerPolicyHolds(path, oldIssuer) {
+			err = c.commit(path, rewritten, oldIssuer, newIssuer, func() error {
+				return fileLoader.Dump(content, path)
+			})
+			total += rewritten
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+
+		newPath := store.Path(newIssuer)
+		existing, err := afero.ReadFile(store.Fs, newPath)
+		if err != nil && !os.IsNotExist(err) {
+			return total, fmt.Errorf("failed to read issuer policy: %w", err)
+		}
+		if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+			existing = append(existing, '\n')
+		}
+		err = c.commit(path, rewritten, oldIssuer, newIssuer, func() error {
+			if err := fileLoader.Dump(append(existing, content...), newPath); err != nil {
+				return err
+			}
+			if fileLoader.Backups != nil {
+				if _, err := fileLoader.Backups.Save(path); err != nil {
+					return err
+				}
+			}
+			return store.Fs.Remove(path)
+		}, "moved to "+newPath)
+		total += rewritten
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// commit runs write if entries were rewritten in path and records it, with
+// the extra lines of summary
+func (c *RewriteIssuerCmd) commit(path string, rewritten int, oldIssuer string, newIssuer string, write func() error, summary ...string) error {
+	if rewritten == 0 {
+		return nil
+	}
+	summary = append([]string{fmt.Sprintf("%d entries of %s now use %s", rewritten, oldIssuer, newIssuer)}, summary...)
+	if c.DryRun {
+		fmt.Fprintf(c.Out, "Would rewrite %s: %s\n", path, strings.Join(summary, ", "))
+		return nil
+	}
+	if err := write(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Fprintf(c.Out, "Rewrote %s: %s\n", path, strings.Join(summary, ", "))
+
+	if c.Journal != nil {
+		if err := c.Journal.Append(policy.JournalEntry{
+			Action:  "rewrite-issuer",
+			Path:    path,
+			Summary: summary,
+		}); err != nil {
+			slog.Warn("Failed to record the change in the policy journal", "error", err)
+		}
+	}
+	events.Emit(events.PolicyChanged, map[string]string{
+		"path":       path,
+		"action":     "rewrite-issuer",
+		"issuer":     newIssuer,
+		"old_issuer": oldIssuer,
+	})
+	return nil
+}
+
+// reportFragments prints the fragments that still have entries of
+// oldIssuer. Fragments are replaced by the fleet or sync that publishes
+// them, and may be signed, so they must be changed at their source.
+func (c *RewriteIssuerCmd) reportFragments(oldIssuer string) {
+	names, err := c.Fragments.Names()
+	if err != nil {
+		slog.Warn("Failed to read the policy fragments", "error", err)
+		return
+	}
+	for _, name := range names {
+		content, err := afero.ReadFile(c.Fragments.Fs, c.Fragments.Path(name))
+		if err != nil {
+			slog.Warn("Failed to read policy fragment", "name", name, "error", err)
+			continue
+		}
+		entries := 0
+		for _, row := range files.ParseRows(content) {
+			if len(row.Columns) >= 3 && row.Columns[2] == oldIssuer {
+				entries++
+			}
+		}
+		if entries > 0 {
+			fmt.Fprintf(c.Out, "Policy fragment %s has %d entries of %s, update it where it is published\n", c.Fragments.Path(name), entries, oldIssuer)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteIssuer(t *testing.T) {
+	const oldIssuer, newIssuer = "https://old.example.com", "https://new.example.com"
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte(
+		"# Admins\n"+
+			"root alice@example.com "+oldIssuer+" # on call\n"+
+			"root bob@example.com https://accounts.google.com\n"), 0o640))
+	issuerPolicies := &policy.IssuerPolicyStore{Fs: mockFs, Dir: "/etc/opk/auth_id.d"}
+	require.NoError(t, afero.WriteFile(mockFs, issuerPolicies.Path(oldIssuer), []byte("dev carol@example.com "+oldIssuer+"\n"), 0o640))
+	require.NoError(t, afero.WriteFile(mockFs, issuerPolicies.Path(newIssuer), []byte("dev dave@example.com "+newIssuer), 0o640))
+	fragments := &policy.FragmentStore{Fs: mockFs, Dir: "/etc/opk/policy.fragments.d"}
+	require.NoError(t, afero.WriteFile(mockFs, fragments.Path("fleet"), []byte("dev erin@example.com "+oldIssuer+"\n"), 0o640))
+
+	out := &bytes.Buffer{}
+	c := &RewriteIssuerCmd{
+		Out: out,
+		SystemPolicyLoader: &policy.SystemPolicyLoader{PolicyLoader: &policy.PolicyLoader{FileLoader: files.FileLoader{
+			Fs:           mockFs,
+			RequiredPerm: files.ModeSystemPerms,
+			Backups:      files.NewBackups(mockFs, "/var/lib/opk/backups"),
+		}}},
+		IssuerPolicies: issuerPolicies,
+		Fragments:      fragments,
+		Journal:        &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath},
+		DryRun:         true,
+	}
+
+	// A dry run changes nothing
+	rewritten, err := c.Run(oldIssuer, newIssuer)
+	require.NoError(t, err)
+	require.Equal(t, 2, rewritten)
+	require.Contains(t, out.String(), "Would rewrite "+policy.SystemDefaultPolicyPath+": 1 entries of "+oldIssuer+" now use "+newIssuer)
+	exists, err := afero.Exists(mockFs, issuerPolicies.Path(oldIssuer))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	c.DryRun = false
+	out.Reset()
+	rewritten, err = c.Run(oldIssuer, newIssuer)
+	require.NoError(t, err)
+	require.Equal(t, 2, rewritten)
+	content, err := afero.ReadFile(mockFs, policy.SystemDefaultPolicyPath)
+	require.NoError(t, err)
+	require.Equal(t, "# Admins\n"+
+		"root alice@example.com "+newIssuer+" # on call\n"+
+		"root bob@example.com https://accounts.google.com\n", string(content))
+
+	// The policy of the old issuer is merged into the policy of the new one
+	exists, err = afero.Exists(mockFs, issuerPolicies.Path(oldIssuer))
+	require.NoError(t, err)
+	require.False(t, exists)
+	content, err = afero.ReadFile(mockFs, issuerPolicies.Path(newIssuer))
+	require.NoError(t, err)
+	require.Equal(t, "dev dave@example.com "+newIssuer+"\ndev carol@example.com "+newIssuer+"\n", string(content))
+	require.Contains(t, out.String(), "Policy fragment "+fragments.Path("fleet")+" has 1 entries of "+oldIssuer+", update it where it is published")
+
+	backups, err := afero.ReadDir(mockFs, "/var/lib/opk/backups")
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	entries, err := c.Journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "rewrite-issuer", entries[1].Action)
+	require.Equal(t, issuerPolicies.Path(oldIssuer), entries[1].Path)
+	require.Equal(t, []string{"1 entries of " + oldIssuer + " now use " + newIssuer, "moved to " + issuerPolicies.Path(newIssuer)}, entries[1].Summary)
+
+	// Nothing left to rewrite
+	out.Reset()
+	rewritten, err = c.Run(oldIssuer, newIssuer)
+	require.NoError(t, err)
+	require.Zero(t, rewritten)
+	require.Contains(t, out.String(), "No policy entries of "+oldIssuer+" found")
+
+	_, err = c.Run(newIssuer, newIssuer)
+	require.ErrorContains(t, err, "the old and the new issuer are both "+newIssuer)
+}
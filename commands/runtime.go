@@ -0,0 +1,63 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/openpubkey/opkssh/internal/logging"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// Runtime is the environment commands run in. main builds one with
+// NewRuntime and passes it to the command constructors. Tests and programs
+// embedding opkssh pass their own instead of changing package state.
+type Runtime struct {
+	Fs         afero.Fs
+	UserLookup policy.UserLookup
+	Now        func() time.Time
+	// CmdRunner runs a command and returns its combined output
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+	Logger    *slog.Logger
+	Prompter  Prompter
+
+	In     io.Reader
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+// NewRuntime returns the Runtime of the opkssh process: the OS filesystem,
+// users and clock, the standard logger and the standard streams
+func NewRuntime() *Runtime {
+	rt := &Runtime{
+		Fs:         afero.NewOsFs(),
+		UserLookup: policy.NewOsUserLookup(),
+		Now:        time.Now,
+		CmdRunner:  files.ExecCmd,
+		Logger:     logging.Default(),
+		In:         os.Stdin,
+		Out:        os.Stdout,
+		ErrOut:     os.Stderr,
+	}
+	rt.Prompter = NewPrompter(rt.In, rt.Out)
+	return rt
+}
@@ -0,0 +1,85 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// testUserLookup knows the users in it
+type testUserLookup map[string]*user.User
+
+func (l testUserLookup) Lookup(username string) (*user.User, error) {
+	if u, ok := l[username]; ok {
+		return u, nil
+	}
+	return nil, user.UnknownUserError(username)
+}
+
+// newTestRuntime returns a Runtime that only touches fs and out, with the
+// clock stopped at now
+func newTestRuntime(fs afero.Fs, out io.Writer, now time.Time) *Runtime {
+	rt := &Runtime{
+		Fs:         fs,
+		UserLookup: testUserLookup{"root": {Username: "root", HomeDir: "/root"}},
+		Now:        func() time.Time { return now },
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			return nil, fmt.Errorf("unexpected command %s %s", name, strings.Join(arg, " "))
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		In:     strings.NewReader(""),
+		Out:    out,
+		ErrOut: io.Discard,
+	}
+	rt.Prompter = NewPrompter(rt.In, out)
+	return rt
+}
+
+func TestCommandsUseRuntime(t *testing.T) {
+	t.Parallel()
+	fs := afero.NewMemMapFs()
+	out := &bytes.Buffer{}
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rt := newTestRuntime(fs, out, now)
+
+	lint := NewLintCmd(rt)
+	require.NoError(t, lint.LookupUser("root"))
+	require.Error(t, lint.LookupUser("bob"))
+	require.Equal(t, fs, lint.Fs)
+
+	whoami := NewWhoamiCmd(rt, nil)
+	require.Equal(t, now, whoami.Now())
+	require.Equal(t, out, whoami.Out)
+
+	perms := NewPermissionsCmd(rt)
+	require.Equal(t, out, perms.Out)
+	require.Equal(t, rt.Prompter, perms.Prompter)
+
+	require.Equal(t, fs, NewLogoutCmd(rt, "").Fs)
+	require.Equal(t, rt.Logger, NewOktaRevokeCmd(rt, config.OktaConfig{}).Logger)
+}
@@ -0,0 +1,539 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/internal/telemetry"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy/plugins"
+	"github.com/spf13/afero"
+)
+
+// ServeRequestTimeout is how long a connection to opkssh serve may take to
+// send its request and read the answer
+const ServeRequestTimeout = 30 * time.Second
+
+// serveReloadDelay groups the events of an editor saving a file, or of a
+// package upgrade, into a single reload
+const serveReloadDelay = 200 * time.Millisecond
+
+// ErrServeUnavailable is returned by VerifyWithServer when opkssh serve can't
+// be reached, in which case the caller verifies the login itself
+var ErrServeUnavailable = errors.New("opkssh serve is not available")
+
+// ServePipe is the named pipe opkssh serve listens on by default on Windows
+const ServePipe = `\\.\pipe\opkssh`
+
+// DefaultServeSocketPath returns the socket opkssh serve listens on:
+// /run/opk/opkssh.sock on Unix-like systems and the named pipe ServePipe on
+// Windows
+func DefaultServeSocketPath() string {
+	if runtime.GOOS == "windows" {
+		return ServePipe
+	}
+	return "/run/opk/opkssh.sock"
+}
+
+// isNamedPipe returns true if path is a Windows named pipe
+func isNamedPipe(path string) bool {
+	return strings.HasPrefix(path, `\\.\pipe\`)
+}
+
+// ServeRequest is a login sent by opkssh verify --socket to opkssh serve, its
+// fields are the arguments of opkssh verify
+type ServeRequest struct {
+	Principal     string   `json:"principal"`
+	KeyType       string   `json:"key_type"`
+	Cert          string   `json:"cert"`
+	ExtraArgs     []string `json:"extra_args,omitempty"`
+	Connection    string   `json:"connection,omitempty"`
+	SshConnection string   `json:"ssh_connection,omitempty"`
+}
+
+// ServeResponse is the answer of opkssh serve to a ServeRequest. Error is set
+// when the login is denied.
+type ServeResponse struct {
+	AuthorizedKey string `json:"authorized_key,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ServeState is the configuration opkssh serve loads at start up and after
+// each change
+type ServeState struct {
+	ProviderPolicy *policy.ProviderPolicy
+	PktVerifier    verifier.Verifier
+	// ServerConfig is nil if the server config could not be read
+	ServerConfig *config.ServerConfig
+}
+
+// ServeCmd is a verify daemon. It keeps the providers, server config, system
+// policy and plugin configs in memory, reloads them when the files change,
+// and answers the logins that opkssh verify --socket forwards over a Unix
+// socket or, on Windows, a named pipe.
+type ServeCmd struct {
+	// SocketPath is the socket to listen on, or a named pipe \\.\pipe\<name>
+	SocketPath string
+	// PipeUsers are the accounts, besides SYSTEM and the Administrators, that
+	// can connect to a named pipe SocketPath
+	PipeUsers []string
+	// Listener, if set, is used instead of SocketPath, e.g. the socket
+	// passed by a systemd socket unit
+	Listener net.Listener
+	// ConfigPath is the path to the server config file
+	ConfigPath string
+	// Fs is the filesystem the logins are verified with
+	Fs afero.Fs
+	// WatchDirs are the directories whose changes trigger a reload
+	WatchDirs []string
+	// Load reads the configuration, it is called at start up and on every
+	// reload
+	Load func() (*ServeState, error)
+	// Preflight validates the configuration after each reload. Logins are
+	// refused while it returns an error.
+	Preflight func(serverConfig *config.ServerConfig) error
+	// NewPolicyEnforcer returns the policy enforcer of a login
+	NewPolicyEnforcer func(username string, serverConfig *config.ServerConfig, providerPolicy *policy.ProviderPolicy, onAllow func(policy.Match)) PolicyEnforcerFunc
+	Logger            *slog.Logger
+	// Metrics, if set, counts the logins, plugin runs, JWKS cache requests
+	// and reloads
+	Metrics *ServeMetrics
+	// MetricsListen, if set, is the TCP address, e.g. 127.0.0.1:9464, where
+	// Metrics are served on /metrics in the Prometheus text format
+	MetricsListen string
+	// Telemetry, if set, sends the usage counts when a report is due, as
+	// verify only counts its runs
+	Telemetry *telemetry.Recorder
+
+	mu      sync.RWMutex
+	state   *ServeState
+	loadErr error
+	// fileCache and pluginCache hold the system policy and plugin configs
+	// until the next reload
+	fileCache   *files.ReadCache
+	pluginCache *plugins.ConfigCache
+	// replayMemory is the memory replay_cache, kept across reloads
+	replayMemory *policy.MemoryReplayStore
+}
+
+// NewServeCmd creates a ServeCmd of the system configuration
+func NewServeCmd(rt *Runtime, socketPath string, configPath string) *ServeCmd {
+	s := &ServeCmd{
+		SocketPath: socketPath,
+		ConfigPath: configPath,
+		WatchDirs: []string{
+			policy.GetSystemConfigBasePath(),
+			policy.GetPluginPolicyDir(),
+			filepath.Dir(configPath),
+		},
+		PipeUsers:    []string{policy.Defaults.User},
+		Fs:           rt.Fs,
+		Logger:       rt.Logger,
+		Metrics:      NewServeMetrics(),
+		fileCache:    files.NewReadCache(),
+		pluginCache:  plugins.NewConfigCache(),
+		replayMemory: policy.NewMemoryReplayStore(),
+	}
+	s.Load = s.loadSystemConfig
+	s.Preflight = func(serverConfig *config.ServerConfig) error {
+		mode := ""
+		if serverConfig != nil {
+			mode = serverConfig.Preflight
+		}
+		_, err := NewPreflight(rt, mode).Run()
+		return err
+	}
+	s.NewPolicyEnforcer = s.opkPolicyEnforcer
+	return s
+}
+
+// loadSystemConfig reads the providers and the server config, the same way
+// opkssh verify does. The environment variables and notifications of the
+// server config are set here as they apply to every login.
+func (s *ServeCmd) loadSystemConfig() (*ServeState, error) {
+	providerPolicy, err := policy.NewProviderFileLoader().LoadProviderPolicy(policy.Defaults.ProvidersPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", policy.Defaults.ProvidersPath, err)
+	}
+	providerPolicy.JWKSCache = policy.NewJWKSCache()
+	if s.Metrics != nil {
+		providerPolicy.JWKSCache.OnLookup = s.Metrics.ObserveJWKSCache
+	}
+	pktVerifier, err := providerPolicy.CreateVerifier()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pk token verifier (likely bad configuration): %w", err)
+	}
+
+	events.Default().Reset()
+	v := NewVerifyCmd(s.Fs, *pktVerifier, nil, s.ConfigPath)
+	v.ProviderPolicy = providerPolicy
+	if err := v.ReadFromServerConfig(); err != nil {
+		s.Logger.Error("Failed to set environment variables in config", "error", err)
+	}
+	return &ServeState{
+		ProviderPolicy: providerPolicy,
+		PktVerifier:    *pktVerifier,
+		ServerConfig:   v.ServerConfig,
+	}, nil
+}
+
+// opkPolicyEnforcer is the policy enforcer of opkssh verify reading the
+// system policy and plugin configs through the caches of s
+func (s *ServeCmd) opkPolicyEnforcer(username string, serverConfig *config.ServerConfig, providerPolicy *policy.ProviderPolicy, onAllow func(policy.Match)) PolicyEnforcerFunc {
+	policyEnforcer, policyLoader := newOpkPolicyEnforcer(username, serverConfig, providerPolicy, onAllow)
+	policyLoader.SystemPolicyLoader.FileLoader.Cache = s.fileCache
+	policyEnforcer.PluginConfigs = s.pluginCache
+	if s.Metrics != nil {
+		policyEnforcer.OnPluginResult = s.Metrics.ObservePlugin
+	}
+	return policyEnforcer.CheckPolicy
+}
+
+// Reload loads the configuration again. If it fails, logins are refused
+// until a reload succeeds.
+func (s *ServeCmd) Reload() error {
+	if s.fileCache != nil {
+		s.fileCache.Reset()
+	}
+	if s.pluginCache != nil {
+		s.pluginCache.Reset()
+	}
+	state, err := s.Load()
+	if err == nil && s.Preflight != nil {
+		if perr := s.Preflight(state.ServerConfig); perr != nil {
+			err = fmt.Errorf("preflight failed: %w", perr)
+		}
+	}
+	if s.Metrics != nil {
+		s.Metrics.ObserveReload(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.state, s.loadErr = nil, err
+		s.Logger.Error("Failed to reload configuration, refusing logins", "error", err)
+		return err
+	}
+	s.state, s.loadErr = state, nil
+	s.Logger.Info("Configuration loaded", "providers", state.ProviderPolicy.ToString())
+	return nil
+}
+
+// Verify authorizes a login with the configuration of the last reload
+func (s *ServeCmd) Verify(ctx context.Context, req ServeRequest) (string, error) {
+	s.mu.RLock()
+	state, loadErr := s.state, s.loadErr
+	s.mu.RUnlock()
+	if (loadErr != nil || state == nil) && s.Metrics != nil {
+		s.Metrics.observeRefused()
+	}
+	if loadErr != nil {
+		return "", fmt.Errorf("refusing to verify: %w", loadErr)
+	} else if state == nil {
+		return "", fmt.Errorf("refusing to verify: configuration not loaded")
+	}
+
+	v := NewVerifyCmd(s.Fs, state.PktVerifier, nil, s.ConfigPath)
+	v.ProviderPolicy = state.ProviderPolicy
+	v.ConnectionArg = req.Connection
+	v.SshConnection = req.SshConnection
+	v.ReplayMemory = s.replayMemory
+	if state.ServerConfig != nil {
+		v.ApplyServerConfig(state.ServerConfig)
+	}
+	v.CheckPolicy = s.NewPolicyEnforcer(req.Principal, v.ServerConfig, state.ProviderPolicy, v.RecordMatch)
+	if s.Metrics != nil {
+		v.OnDecision = s.Metrics.ObserveDecision
+	}
+	if v.Audit != nil {
+		defer v.Audit.Close()
+	}
+	return v.AuthorizedKeysCommand(ctx, req.Principal, req.KeyType, req.Cert, req.ExtraArgs)
+}
+
+// Run loads the configuration and answers logins on SocketPath until ctx is
+// done
+func (s *ServeCmd) Run(ctx context.Context) error {
+	// Logins are refused, not failed, when the first load fails so that
+	// fixing the configuration is enough to recover
+	_ = s.Reload()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch the configuration: %w", err)
+	}
+	defer watcher.Close()
+	s.watch(watcher)
+
+	listener := s.Listener
+	if listener == nil {
+		if listener, err = s.listen(); err != nil {
+			return err
+		}
+		if !isNamedPipe(s.SocketPath) {
+			defer os.Remove(s.SocketPath)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go s.watchLoop(ctx, watcher, hup)
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	if s.MetricsListen != "" && s.Metrics != nil {
+		if err := s.listenMetrics(ctx); err != nil {
+			return err
+		}
+	}
+
+	if s.Telemetry != nil {
+		go s.flushTelemetry(ctx)
+	}
+
+	s.Logger.Info("Listening", "addr", listener.Addr().String())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// flushTelemetry sends the usage counts once a report is due until ctx is
+// done, checking at least every hour
+func (s *ServeCmd) flushTelemetry(ctx context.Context) {
+	interval := min(s.Telemetry.Interval, time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Telemetry.Flush(); err != nil {
+				s.Logger.Warn("Failed to send telemetry", "error", err)
+			}
+		}
+	}
+}
+
+// listen creates the socket, which only the user running opkssh serve can
+// connect to. A named pipe can also be used by PipeUsers.
+func (s *ServeCmd) listen() (net.Listener, error) {
+	if isNamedPipe(s.SocketPath) {
+		sddl, err := namedPipeSecurityDescriptor(s.PipeUsers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to secure %s: %w", s.SocketPath, err)
+		}
+		listener, err := listenNamedPipe(s.SocketPath, sddl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", s.SocketPath, err)
+		}
+		return listener, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.SocketPath), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// A socket left by a daemon that did not shut down cleanly
+	if err := os.Remove(s.SocketPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %w", s.SocketPath, err)
+	}
+	listener, err := listenUnixSocket(s.SocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", s.SocketPath, err)
+	}
+	return listener, nil
+}
+
+// SystemdListener returns the socket passed by systemd socket activation, or
+// nil if opkssh was not started by a socket unit
+func SystemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// The sockets are not passed on to the commands opkssh runs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n != 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, the socket unit must have a single ListenStream", n)
+	}
+	// Passed sockets start at file descriptor 3 (SD_LISTEN_FDS_START)
+	f := os.NewFile(3, "systemd socket")
+	defer f.Close()
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket passed by systemd: %w", err)
+	}
+	return listener, nil
+}
+
+// watch adds the watch directories that exist and are not watched yet, a
+// directory created after start up is watched after the next reload
+func (s *ServeCmd) watch(watcher *fsnotify.Watcher) {
+	watched := map[string]bool{}
+	for _, dir := range watcher.WatchList() {
+		watched[dir] = true
+	}
+	for _, dir := range s.WatchDirs {
+		if watched[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err == nil {
+			watched[dir] = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			s.Logger.Warn("Failed to watch configuration directory", "dir", dir, "error", err)
+		}
+	}
+}
+
+// watchLoop reloads the configuration after the watched files change, and
+// right away on SIGHUP
+func (s *ServeCmd) watchLoop(ctx context.Context, watcher *fsnotify.Watcher, hup <-chan os.Signal) {
+	reload := time.NewTimer(serveReloadDelay)
+	reload.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			// Chmod events matter too as the loaders check permissions
+			s.Logger.Info("Configuration changed", "event", event.String())
+			reload.Reset(serveReloadDelay)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.Logger.Warn("Configuration watcher failed", "error", err)
+		case <-hup:
+			s.Logger.Info("Reloading the configuration on SIGHUP")
+			reload.Reset(0)
+		case <-reload.C:
+			_ = s.Reload()
+			s.watch(watcher)
+		}
+	}
+}
+
+// handle answers the single request of conn
+func (s *ServeCmd) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ServeRequestTimeout))
+
+	var req ServeRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		s.Logger.Warn("Invalid request", "error", err)
+		_ = json.NewEncoder(conn).Encode(ServeResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	resp := ServeResponse{}
+	if authKey, err := s.Verify(ctx, req); err != nil {
+		s.Logger.Warn("Failed to verify login", "user", req.Principal, "error", err)
+		resp.Error = err.Error()
+	} else {
+		s.Logger.Info("Successfully verified login", "user", req.Principal)
+		resp.AuthorizedKey = authKey
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.Logger.Warn("Failed to send response", "error", err)
+	}
+}
+
+// VerifyWithServer forwards a login to the opkssh serve listening on
+// socketPath. It returns an error wrapping ErrServeUnavailable if the daemon
+// doesn't answer, and the reason the daemon gave if the login is denied.
+func VerifyWithServer(ctx context.Context, socketPath string, req ServeRequest) (string, error) {
+	var conn net.Conn
+	var err error
+	if isNamedPipe(socketPath) {
+		dialCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		conn, err = dialNamedPipe(dialCtx, socketPath)
+		cancel()
+		if err == nil {
+			// Deny the login instead of verifying it locally, another user
+			// may have created the pipe to answer for opkssh
+			if checkErr := checkNamedPipeServer(conn); checkErr != nil {
+				conn.Close()
+				return "", checkErr
+			}
+		}
+	} else {
+		dialer := net.Dialer{Timeout: 2 * time.Second}
+		conn, err = dialer.DialContext(ctx, "unix", socketPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrServeUnavailable, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(ServeRequestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrServeUnavailable, err)
+	}
+	var resp ServeResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrServeUnavailable, err)
+	}
+	if resp.Error != "" {
+		return "", errors.New(resp.Error)
+	}
+	return resp.AuthorizedKey, nil
+}
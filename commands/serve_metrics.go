@@ -0,0 +1,143 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/openpubkey/opkssh/internal/audit"
+	"github.com/openpubkey/opkssh/internal/metrics"
+	"github.com/openpubkey/opkssh/policy/plugins"
+)
+
+// DenyCodeNotLoaded is the reason of the logins opkssh serve refuses while
+// its configuration is not loaded
+const DenyCodeNotLoaded = "config_not_loaded"
+
+// ServeMetrics are the metrics opkssh serve exposes on /metrics. The issuer
+// label is empty when the PK Token could not be verified.
+type ServeMetrics struct {
+	Registry *metrics.Registry
+
+	verifications  *metrics.Counter
+	allowed        *metrics.Counter
+	denied         *metrics.Counter
+	verifyDuration *metrics.Histogram
+	pluginDuration *metrics.Histogram
+	jwksCache      *metrics.Counter
+	reloads        *metrics.Counter
+	reloadErrors   *metrics.Counter
+}
+
+// NewServeMetrics registers the metrics of opkssh serve in a new registry
+func NewServeMetrics() *ServeMetrics {
+	r := metrics.NewRegistry()
+	return &ServeMetrics{
+		Registry:       r,
+		verifications:  r.NewCounter("opkssh_verifications_total", "Logins verified, by issuer.", "issuer"),
+		allowed:        r.NewCounter("opkssh_verifications_allowed_total", "Logins allowed, by issuer.", "issuer"),
+		denied:         r.NewCounter("opkssh_verifications_denied_total", "Logins denied, by issuer and reason code.", "issuer", "reason"),
+		verifyDuration: r.NewHistogram("opkssh_verification_duration_seconds", "How long verifying a login took, by issuer.", metrics.DefaultBuckets, "issuer"),
+		pluginDuration: r.NewHistogram("opkssh_plugin_duration_seconds", "How long a policy plugin ran, by plugin and result (allow, deny, error or timeout). Cached results are not counted.", metrics.DefaultBuckets, "plugin", "result"),
+		jwksCache:      r.NewCounter("opkssh_jwks_cache_requests_total", "Requests to the providers through the JWKS cache, by result (hit, miss or stale).", "result"),
+		reloads:        r.NewCounter("opkssh_config_reloads_total", "Configuration reloads."),
+		reloadErrors:   r.NewCounter("opkssh_config_reload_errors_total", "Configuration reloads that failed, logins are refused until the next one succeeds."),
+	}
+}
+
+// ObserveDecision counts a login verified by VerifyCmd
+func (m *ServeMetrics) ObserveDecision(record audit.Record, elapsed time.Duration) {
+	m.verifications.Inc(record.Issuer)
+	if record.Decision == audit.Allow {
+		m.allowed.Inc(record.Issuer)
+	} else {
+		m.denied.Inc(record.Issuer, record.ReasonCode)
+	}
+	m.verifyDuration.Observe(elapsed.Seconds(), record.Issuer)
+}
+
+// observeRefused counts a login refused without verifying it
+func (m *ServeMetrics) observeRefused() {
+	m.verifications.Inc("")
+	m.denied.Inc("", DenyCodeNotLoaded)
+}
+
+// ObservePlugin records how long a policy plugin ran
+func (m *ServeMetrics) ObservePlugin(result *plugins.PluginResult) {
+	// Nothing ran for cached results and configs that failed to load
+	if result.Cached || result.Duration == 0 {
+		return
+	}
+	name := result.PluginConfig.Name
+	if name == "" {
+		name = filepath.Base(result.Path)
+	}
+	outcome := "deny"
+	switch {
+	case result.TimedOut:
+		outcome = "timeout"
+	case result.Error != nil:
+		outcome = "error"
+	case result.Allowed:
+		outcome = "allow"
+	}
+	m.pluginDuration.Observe(result.Duration.Seconds(), name, outcome)
+}
+
+// ObserveJWKSCache counts a request through the JWKS cache, result is one
+// of policy.JWKSCacheHit, JWKSCacheMiss and JWKSCacheStale
+func (m *ServeMetrics) ObserveJWKSCache(result string) {
+	m.jwksCache.Inc(result)
+}
+
+// ObserveReload counts a reload of the configuration that failed with err,
+// or succeeded if err is nil
+func (m *ServeMetrics) ObserveReload(err error) {
+	m.reloads.Inc()
+	if err != nil {
+		m.reloadErrors.Inc()
+	}
+}
+
+// listenMetrics serves the metrics on http://MetricsListen/metrics until
+// ctx is done
+func (s *ServeCmd) listenMetrics(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.MetricsListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics on %s: %w", s.MetricsListen, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.Metrics.Registry.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: ServeRequestTimeout}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.Logger.Error("Metrics endpoint stopped", "error", err)
+		}
+	}()
+	s.Logger.Info("Serving metrics", "url", "http://"+listener.Addr().String()+"/metrics")
+	return nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/metrics"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/plugins"
+	"github.com/openpubkey/opkssh/sshcert"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestServeCmd returns a ServeCmd listening in a new directory, which it
+// also watches, and a request for a login as user
+func newTestServeCmd(t *testing.T) (*ServeCmd, ServeRequest) {
+	t.Helper()
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+	op, _, _, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+	require.NoError(t, err)
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+	cert, err := sshcert.New(pkt, nil, []string{"user"})
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoECDSA256})
+	require.NoError(t, err)
+	sshCert, err := cert.SignCert(signerMas)
+	require.NoError(t, err)
+	typeArg, certB64Arg, _ := strings.Cut(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshCert))), " ")
+	pktVerifier, err := verifier.New(op, verifier.WithExpirationPolicy(verifier.ExpirationPolicies.NEVER_EXPIRE))
+	require.NoError(t, err)
+
+	// Unix socket paths are short, t.TempDir may be too long
+	dir, err := os.MkdirTemp("", "opkssh")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	s := &ServeCmd{
+		SocketPath: filepath.Join(dir, "run", "opkssh.sock"),
+		WatchDirs:  []string{dir},
+		Load: func() (*ServeState, error) {
+			return &ServeState{ProviderPolicy: &policy.ProviderPolicy{}, PktVerifier: *pktVerifier}, nil
+		},
+		NewPolicyEnforcer: func(username string, serverConfig *config.ServerConfig, providerPolicy *policy.ProviderPolicy, onAllow func(policy.Match)) PolicyEnforcerFunc {
+			return func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+				if userDesired != "user" {
+					return fmt.Errorf("no policy to allow %s", userDesired)
+				}
+				return nil
+			}
+		},
+		Fs:     afero.NewOsFs(),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	return s, ServeRequest{Principal: "user", KeyType: typeArg, Cert: certB64Arg}
+}
+
+// startServe runs s until the test ends
+func startServe(t *testing.T, s *ServeCmd) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(s.SocketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServe(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	_, err := VerifyWithServer(context.Background(), s.SocketPath, req)
+	require.ErrorIs(t, err, ErrServeUnavailable)
+
+	// Strict preflight failures refuse logins
+	s.Preflight = func(serverConfig *config.ServerConfig) error { return fmt.Errorf("policy has errors") }
+	require.ErrorContains(t, s.Reload(), "preflight failed: policy has errors")
+	_, err = s.Verify(context.Background(), req)
+	require.ErrorContains(t, err, "refusing to verify: preflight failed")
+	s.Preflight = nil
+
+	startServe(t, s)
+	info, err := os.Stat(s.SocketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	authKey, err := VerifyWithServer(context.Background(), s.SocketPath, req)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(authKey, "cert-authority ecdsa-sha2-nistp256"), authKey)
+
+	// A denied login is not a daemon failure
+	req.Principal = "root"
+	_, err = VerifyWithServer(context.Background(), s.SocketPath, req)
+	require.ErrorContains(t, err, "no policy to allow root")
+	require.NotErrorIs(t, err, ErrServeUnavailable)
+}
+
+func TestServeReloadsOnChange(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	load := s.Load
+	var loads atomic.Int32
+	brokenPath := filepath.Join(s.WatchDirs[0], "providers")
+	s.Load = func() (*ServeState, error) {
+		loads.Add(1)
+		if content, err := os.ReadFile(brokenPath); err == nil && string(content) == "broken" {
+			return nil, fmt.Errorf("invalid providers")
+		}
+		return load()
+	}
+	startServe(t, s)
+	require.Equal(t, int32(1), loads.Load())
+
+	require.NoError(t, os.WriteFile(brokenPath, []byte("broken"), 0o640))
+	require.Eventually(t, func() bool {
+		_, err := VerifyWithServer(context.Background(), s.SocketPath, req)
+		return err != nil && strings.Contains(err.Error(), "refusing to verify: invalid providers")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Fixing the file is enough to recover
+	require.NoError(t, os.WriteFile(brokenPath, []byte("fixed"), 0o640))
+	require.Eventually(t, func() bool {
+		_, err := VerifyWithServer(context.Background(), s.SocketPath, req)
+		return err == nil
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestServeSystemdSocket(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	socketPath := filepath.Join(filepath.Dir(filepath.Dir(s.SocketPath)), "activated.sock")
+	listener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	s.Listener = listener
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	require.Eventually(t, func() bool {
+		_, err := VerifyWithServer(context.Background(), socketPath, req)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = os.Stat(s.SocketPath)
+	require.ErrorIs(t, err, os.ErrNotExist, "serve must not listen on --socket")
+}
+
+func TestSystemdListener(t *testing.T) {
+	// The sockets are for another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listener, err := SystemdListener()
+	require.NoError(t, err)
+	require.Nil(t, listener)
+	require.Equal(t, "1", os.Getenv("LISTEN_FDS"))
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	_, err = SystemdListener()
+	require.ErrorContains(t, err, "systemd passed 2 sockets")
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	require.False(t, ok)
+}
+
+func TestServeMetrics(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	s.Metrics = NewServeMetrics()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s.MetricsListen = free.Addr().String()
+	require.NoError(t, free.Close())
+
+	// Logins refused before the configuration is loaded
+	_, err = s.Verify(context.Background(), req)
+	require.Error(t, err)
+
+	startServe(t, s)
+	_, err = s.Verify(context.Background(), req)
+	require.NoError(t, err)
+	req.Principal = "root"
+	_, err = s.Verify(context.Background(), req)
+	require.Error(t, err)
+	req.Cert = "invalid"
+	_, err = s.Verify(context.Background(), req)
+	require.Error(t, err)
+
+	s.Metrics.ObservePlugin(&plugins.PluginResult{Path: "/etc/opk/policy.d/groups.yml", Allowed: true, Duration: 20 * time.Millisecond})
+	s.Metrics.ObservePlugin(&plugins.PluginResult{PluginConfig: plugins.PluginConfig{Name: "slow"}, TimedOut: true, Error: fmt.Errorf("timed out"), Duration: 3 * time.Second})
+	s.Metrics.ObservePlugin(&plugins.PluginResult{Path: "/etc/opk/policy.d/cached.yml", Allowed: true, Cached: true})
+	s.Metrics.ObserveJWKSCache(policy.JWKSCacheHit)
+	s.Metrics.ObserveReload(fmt.Errorf("invalid providers"))
+
+	resp, err := http.Get("http://" + s.MetricsListen + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+
+	issuer := `issuer="https://accounts.example.com"`
+	for _, line := range []string{
+		`opkssh_verifications_total{issuer=""} 2`,
+		`opkssh_verifications_total{` + issuer + `} 2`,
+		`opkssh_verifications_allowed_total{` + issuer + `} 1`,
+		`opkssh_verifications_denied_total{issuer="",reason="config_not_loaded"} 1`,
+		`opkssh_verifications_denied_total{issuer="",reason="invalid_token"} 1`,
+		`opkssh_verifications_denied_total{` + issuer + `,reason="error"} 1`,
+		`opkssh_verification_duration_seconds_count{` + issuer + `} 2`,
+		`opkssh_plugin_duration_seconds_count{plugin="groups.yml",result="allow"} 1`,
+		`opkssh_plugin_duration_seconds_bucket{plugin="slow",result="timeout",le="2.5"} 0`,
+		`opkssh_plugin_duration_seconds_count{plugin="slow",result="timeout"} 1`,
+		`opkssh_jwks_cache_requests_total{result="hit"} 1`,
+		`opkssh_config_reloads_total 2`,
+		`opkssh_config_reload_errors_total 1`,
+	} {
+		require.Contains(t, string(body), line+"\n")
+	}
+	require.NotContains(t, string(body), "cached.yml")
+}
@@ -0,0 +1,56 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeNamedPipe(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	s.SocketPath = fmt.Sprintf(`\\.\pipe\opkssh-test-%d`, time.Now().UnixNano())
+	_, err := VerifyWithServer(context.Background(), s.SocketPath, req)
+	require.ErrorIs(t, err, ErrServeUnavailable)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	var authKey string
+	require.Eventually(t, func() bool {
+		authKey, err = VerifyWithServer(context.Background(), s.SocketPath, req)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, strings.HasPrefix(authKey, "cert-authority ecdsa-sha2-nistp256"), authKey)
+
+	req.Principal = "root"
+	_, err = VerifyWithServer(context.Background(), s.SocketPath, req)
+	require.ErrorContains(t, err, "no policy to allow root")
+}
@@ -0,0 +1,265 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/audit"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/internal/logging"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// ServerConfigCmd checks and prints the server config read by verify and
+// the daemons
+type ServerConfigCmd struct {
+	Fs           afero.Fs
+	Out          io.Writer
+	ConfigPath   string
+	PermsChecker files.PermsChecker
+	// LookupEnv finds the environment variables overriding settings,
+	// os.LookupEnv
+	LookupEnv func(string) (string, bool)
+}
+
+// NewServerConfigCmd creates a ServerConfigCmd of the server config at
+// configPath
+func NewServerConfigCmd(rt *Runtime, configPath string) *ServerConfigCmd {
+	return &ServerConfigCmd{
+		Fs:           rt.Fs,
+		Out:          rt.Out,
+		ConfigPath:   configPath,
+		PermsChecker: files.PermsChecker{Fs: rt.Fs, CmdRunner: rt.CmdRunner},
+		LookupEnv:    os.LookupEnv,
+	}
+}
+
+// load reads the server config with its environment overrides. Unknown
+// settings, which verify ignores, are returned as warnings.
+func (c *ServerConfigCmd) load() (*config.ServerConfig, []config.EnvOverride, []string, error) {
+	content, err := afero.ReadFile(c.Fs, c.ConfigPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	serverConfig := &config.ServerConfig{}
+	if err := yaml.Unmarshal(content, serverConfig); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	var warnings []string
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	var typeErr *yaml.TypeError
+	if err := decoder.Decode(&config.ServerConfig{}); errors.As(err, &typeErr) {
+		for _, e := range typeErr.Errors {
+			warnings = append(warnings, strings.Replace(e, "in type config.", "in ", 1))
+		}
+	}
+	overrides, err := serverConfig.ApplyEnvOverrides(c.LookupEnv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return serverConfig, overrides, warnings, nil
+}
+
+// Validate checks the permissions of the server config and its settings,
+// and returns an error if verify would refuse or ignore some of them
+func (c *ServerConfigCmd) Validate() error {
+	if exists, err := afero.Exists(c.Fs, c.ConfigPath); err != nil {
+		return err
+	} else if !exists {
+		fmt.Fprintf(c.Out, "%s does not exist, the defaults apply\n", c.ConfigPath)
+		return nil
+	}
+
+	var problems []string
+	if err := c.PermsChecker.CheckPerm(c.ConfigPath, []fs.FileMode{0o640}, policy.Defaults.Owner, policy.Defaults.Group); err != nil {
+		problems = append(problems, fmt.Sprintf("verify refuses to read it: %v", err))
+	}
+	serverConfig, overrides, warnings, err := c.load()
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		for _, o := range overrides {
+			fmt.Fprintf(c.Out, "%s overrides %s\n", o.Name, o.Setting)
+		}
+		for _, err := range ValidateServerConfig(serverConfig) {
+			problems = append(problems, err.Error())
+		}
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(c.Out, "warning: %s\n", w)
+	}
+	for _, p := range problems {
+		fmt.Fprintf(c.Out, "error: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", c.ConfigPath, len(problems))
+	}
+	fmt.Fprintf(c.Out, "%s is valid\n", c.ConfigPath)
+	return nil
+}
+
+// Show prints the server config as verify reads it, with the environment
+// overrides applied and secrets redacted, after the built-in locations
+func (c *ServerConfigCmd) Show() error {
+	fmt.Fprintf(c.Out, "# Built in, set when opkssh is built:\n")
+	for _, setting := range [][2]string{
+		{"config directory", policy.GetSystemConfigBasePath()},
+		{"state directory", policy.GetSystemStateBasePath()},
+		{"providers", policy.Defaults.ProvidersPath},
+		{"system policy", policy.Defaults.PolicyPath},
+		{"issuer policies", policy.SystemDefaultIssuerPolicyDir},
+		{"policy plugins", policy.GetPluginPolicyDir()},
+		{"AuthorizedKeysCommandUser", policy.Defaults.User},
+	} {
+		fmt.Fprintf(c.Out, "#   %s: %s\n", setting[0], setting[1])
+	}
+
+	serverConfig := &config.ServerConfig{}
+	var overrides []config.EnvOverride
+	if exists, err := afero.Exists(c.Fs, c.ConfigPath); err != nil {
+		return err
+	} else if exists {
+		if serverConfig, overrides, _, err = c.load(); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(c.Out, "# %s does not exist\n", c.ConfigPath)
+		if overrides, err = serverConfig.ApplyEnvOverrides(c.LookupEnv); err != nil {
+			return err
+		}
+	}
+	for _, o := range overrides {
+		fmt.Fprintf(c.Out, "# %s overrides %s\n", o.Name, o.Setting)
+	}
+
+	var node yaml.Node
+	if err := node.Encode(serverConfig); err != nil {
+		return err
+	}
+	redactSecrets(&node)
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+	_, err = c.Out.Write(out)
+	return err
+}
+
+// redactSecrets replaces the values of the settings holding secrets
+func redactSecrets(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if value.Kind == yaml.ScalarNode && value.Value != "" &&
+				(strings.Contains(key, "secret") || strings.Contains(key, "password") || strings.HasSuffix(key, "token")) {
+				value.Value, value.Tag, value.Style = "REDACTED", "!!str", 0
+			}
+		}
+	}
+	for _, child := range node.Content {
+		redactSecrets(child)
+	}
+}
+
+// ValidateServerConfig returns the settings of serverConfig that verify
+// ignores, or refuses logins because of, with why
+func ValidateServerConfig(serverConfig *config.ServerConfig) []error {
+	var errs []error
+	add := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	duration := func(name string, value string) {
+		if value == "" {
+			return
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			add(name, err)
+		} else if d <= 0 {
+			add(name, fmt.Errorf("must be positive, got %s", value))
+		}
+	}
+
+	switch serverConfig.Preflight {
+	case "", PreflightWarn, PreflightStrict, PreflightOff:
+	default:
+		add("preflight", fmt.Errorf("unknown mode %q, expected %s, %s or %s", serverConfig.Preflight, PreflightWarn, PreflightStrict, PreflightOff))
+	}
+	duration("expiry_warning", serverConfig.ExpiryWarning)
+	duration("jwks_cache.max_stale", serverConfig.JWKSCache.MaxStale)
+	duration("plugins.timeout", serverConfig.Plugins.Timeout)
+	duration("rate_limit.window", serverConfig.RateLimit.Window)
+	duration("rate_limit.block", serverConfig.RateLimit.Block)
+	duration("ca.max_lifetime", serverConfig.CA.MaxLifetime)
+	if serverConfig.RateLimit.MaxFailures < 0 {
+		add("rate_limit.max_failures", fmt.Errorf("must not be negative"))
+	}
+	if len(serverConfig.Proxy.Trusted) > 0 || len(serverConfig.Proxy.RequireFor) > 0 {
+		_, err := NewProxyPolicy(serverConfig.Proxy)
+		add("proxy", err)
+	}
+	if serverConfig.ReplayCache.Backend != "" {
+		// The memory backend is checked as opkssh serve would use it
+		_, err := NewReplayGuard(serverConfig.ReplayCache, policy.NewMemoryReplayStore())
+		add("replay_cache", err)
+	}
+	switch serverConfig.Audit.Destination {
+	case "", audit.DestinationSyslog, audit.DestinationEventLog:
+	case audit.DestinationFile:
+		if serverConfig.Audit.Path == "" {
+			add("audit", fmt.Errorf("destination file requires a path"))
+		}
+	default:
+		add("audit", fmt.Errorf("unknown destination %q, expected file, syslog or eventlog", serverConfig.Audit.Destination))
+	}
+	add("logging", logging.Options{
+		Level:        serverConfig.Logging.Level,
+		Format:       serverConfig.Logging.Format,
+		Destinations: serverConfig.Logging.Destinations,
+	}.Validate())
+	add("notifications", ConfigureNotifications(events.NewHub(), serverConfig.Notifications))
+	_, err := PolicyStoreDB(serverConfig.PolicyStore)
+	add("policy_store", err)
+	if len(serverConfig.PolicyFragments.TrustedKeys) > 0 {
+		_, err := FragmentTrustedKeys(serverConfig.PolicyFragments)
+		add("policy_fragments", err)
+	}
+	if len(serverConfig.Plugins.TrustedKeys) > 0 {
+		_, err := PluginTrustedKeys(serverConfig.Plugins)
+		add("plugins", err)
+	}
+	for _, issuer := range append(append([]string{}, serverConfig.Issuers.Allow...), serverConfig.Issuers.Deny...) {
+		if strings.TrimSpace(issuer) == "" {
+			add("issuers", fmt.Errorf("empty issuer"))
+		}
+	}
+	return errs
+}
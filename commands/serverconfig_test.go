@@ -0,0 +1,77 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServerConfigCmd(t *testing.T, content string, env map[string]string) (*ServerConfigCmd, *bytes.Buffer) {
+	t.Helper()
+	fs := afero.NewMemMapFs()
+	if content != "" {
+		require.NoError(t, afero.WriteFile(fs, policy.SystemDefaultServerConfigPath, []byte(content), 0o640))
+	}
+	out := &bytes.Buffer{}
+	rt := newTestRuntime(fs, out, time.Now())
+	rt.CmdRunner = func(name string, arg ...string) ([]byte, error) {
+		return []byte("root opksshuser"), nil
+	}
+	c := NewServerConfigCmd(rt, policy.SystemDefaultServerConfigPath)
+	c.LookupEnv = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	return c, out
+}
+
+func TestServerConfigValidate(t *testing.T) {
+	c, out := newTestServerConfigCmd(t, "", nil)
+	require.NoError(t, c.Validate())
+	require.Contains(t, out.String(), "does not exist, the defaults apply")
+
+	c, out = newTestServerConfigCmd(t, "preflight: strict\nrate_limit:\n  max_failures: 5\n  window: 10m\n", nil)
+	require.NoError(t, c.Validate(), out.String())
+	require.Contains(t, out.String(), policy.SystemDefaultServerConfigPath+" is valid")
+
+	// Unknown settings are warnings, invalid values are errors
+	c, out = newTestServerConfigCmd(t, "preflight: bogus\nrate_limt:\n  window: 10m\n",
+		map[string]string{"OPKSSH_SERVER_RATE_LIMIT_WINDOW": "5x"})
+	require.ErrorContains(t, c.Validate(), "has 2 problem(s)")
+	require.Contains(t, out.String(), "OPKSSH_SERVER_RATE_LIMIT_WINDOW overrides rate_limit.window")
+	require.Contains(t, out.String(), "warning: line 2: field rate_limt not found")
+	require.Contains(t, out.String(), `error: preflight: unknown mode "bogus"`)
+	require.Contains(t, out.String(), `error: rate_limit.window: time: unknown unit "x"`)
+}
+
+func TestServerConfigShow(t *testing.T) {
+	c, out := newTestServerConfigCmd(t, "okta:\n  org_url: https://example.okta.com\n  api_token: s3cr3t\n",
+		map[string]string{"OPKSSH_SERVER_PREFLIGHT": "strict"})
+	require.NoError(t, c.Show())
+	require.Contains(t, out.String(), "#   AuthorizedKeysCommandUser: opksshuser")
+	require.Contains(t, out.String(), "# OPKSSH_SERVER_PREFLIGHT overrides preflight")
+	require.Contains(t, out.String(), "preflight: strict")
+	require.Contains(t, out.String(), "org_url: https://example.okta.com")
+	require.Contains(t, out.String(), "api_token: REDACTED")
+	require.NotContains(t, out.String(), "s3cr3t")
+}
@@ -0,0 +1,315 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ServiceName is the name of the Windows service that runs opkssh serve
+const ServiceName = "opkssh"
+
+// SystemdServeUnitName is the name of the systemd socket and service units
+// that run opkssh serve on Linux
+const SystemdServeUnitName = "opkssh-verify"
+
+const systemdSystemUnitDir = "/etc/systemd/system"
+
+// ServiceCmd manages the service that runs the verify daemon, so that
+// opkssh verify forwards each login to it instead of loading and checking
+// the configuration itself: a Windows service listening on a named pipe, or
+// a socket activated systemd service on Linux
+type ServiceCmd struct {
+	Fs afero.Fs
+	// GOOS is runtime.GOOS, services are supported on windows and linux
+	GOOS string
+	// Executable is the path of the opkssh binary the service runs
+	Executable string
+	// SocketPath is the named pipe or socket the service listens on
+	SocketPath string
+	// ConfigPath is the path to the server config file
+	ConfigPath string
+	// CmdRunner runs sc.exe or systemctl, defaults to exec
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+	Out       io.Writer
+}
+
+// NewServiceCmd creates a ServiceCmd for the current binary
+func NewServiceCmd(rt *Runtime, socketPath string, configPath string) (*ServiceCmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the opkssh binary: %w", err)
+	}
+	return &ServiceCmd{
+		Fs:         rt.Fs,
+		GOOS:       runtime.GOOS,
+		Executable: exe,
+		SocketPath: socketPath,
+		ConfigPath: configPath,
+		CmdRunner:  rt.CmdRunner,
+		Out:        rt.Out,
+	}, nil
+}
+
+func (c *ServiceCmd) checkOS() error {
+	if c.GOOS != "windows" && c.GOOS != "linux" {
+		return fmt.Errorf("opkssh service is only available on Windows and Linux, run opkssh serve with the service manager of %s instead", c.GOOS)
+	}
+	return nil
+}
+
+// BinPath returns the command line the service control manager runs
+func (c *ServiceCmd) BinPath() string {
+	args := []string{c.Executable, "service", "run", "--socket", c.SocketPath}
+	if c.ConfigPath != "" {
+		args = append(args, "--config-path", c.ConfigPath)
+	}
+	for i, arg := range args {
+		args[i] = windowsQuote(arg)
+	}
+	return strings.Join(args, " ")
+}
+
+// VerifyCommand returns the AuthorizedKeysCommand that forwards logins to
+// the service. On Linux verify only forwards them, with --via-socket.
+func (c *ServiceCmd) VerifyCommand() string {
+	if c.GOOS == "windows" {
+		return windowsQuote(c.Executable) + " verify --socket " + windowsQuote(c.SocketPath) + " %u %k %t"
+	}
+	command := windowsQuote(c.Executable) + " verify --via-socket"
+	if c.SocketPath != DefaultServeSocketPath() {
+		command += " --socket " + windowsQuote(c.SocketPath)
+	}
+	return command + " %u %k %t"
+}
+
+// SocketUnitPath returns the path of the systemd socket unit
+func (c *ServiceCmd) SocketUnitPath() string {
+	return filepath.Join(systemdSystemUnitDir, SystemdServeUnitName+".socket")
+}
+
+// ServiceUnitPath returns the path of the systemd service the socket unit
+// starts
+func (c *ServiceCmd) ServiceUnitPath() string {
+	return filepath.Join(systemdSystemUnitDir, SystemdServeUnitName+".service")
+}
+
+// SocketUnit returns the systemd socket unit. The socket is owned by root
+// and only the opksshuser group, which verify runs as, can connect to it.
+func (c *ServiceCmd) SocketUnit() []byte {
+	return []byte("[Unit]\n" +
+		"Description=opkssh verify responder socket\n" +
+		"\n" +
+		"[Socket]\n" +
+		"ListenStream=" + c.SocketPath + "\n" +
+		"SocketUser=root\n" +
+		"SocketGroup=opksshuser\n" +
+		"SocketMode=0660\n" +
+		"\n" +
+		"[Install]\n" +
+		"WantedBy=sockets.target\n")
+}
+
+// ServiceUnit returns the systemd service that runs opkssh serve as root on
+// the socket passed by the socket unit. It is started by the first login.
+func (c *ServiceCmd) ServiceUnit() []byte {
+	args := []string{systemdQuote(c.Executable), "serve"}
+	if c.ConfigPath != "" {
+		args = append(args, "--config-path", systemdQuote(c.ConfigPath))
+	}
+	return []byte("[Unit]\n" +
+		"Description=opkssh verify responder\n" +
+		"Requires=" + SystemdServeUnitName + ".socket\n" +
+		"After=network-online.target\n" +
+		"\n" +
+		"[Service]\n" +
+		"ExecStart=" + strings.Join(args, " ") + "\n" +
+		"Restart=on-failure\n")
+}
+
+// systemctl runs systemctl
+func (c *ServiceCmd) systemctl(args ...string) error {
+	if out, err := c.CmdRunner("systemctl", args...); err != nil {
+		return fmt.Errorf("systemctl %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// sc runs sc.exe
+func (c *ServiceCmd) sc(args ...string) error {
+	if out, err := c.CmdRunner("sc.exe", args...); err != nil {
+		return fmt.Errorf("sc.exe %s failed: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c *ServiceCmd) installed() bool {
+	if c.GOOS == "linux" {
+		_, err := c.Fs.Stat(c.SocketUnitPath())
+		return err == nil
+	}
+	_, err := c.CmdRunner("sc.exe", "query", ServiceName)
+	return err == nil
+}
+
+// waitForState waits until sc.exe query reports the service in state, e.g.
+// STOPPED
+func (c *ServiceCmd) waitForState(state string) error {
+	for i := 0; i < 30; i++ {
+		if out, err := c.CmdRunner("sc.exe", "query", ServiceName); err == nil && strings.Contains(string(out), state) {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("service %s is not %s after 30s", ServiceName, state)
+}
+
+// Install registers the service, or updates it if it exists, and starts it.
+// The service starts at boot and is restarted a minute after it fails.
+func (c *ServiceCmd) Install() error {
+	if err := c.checkOS(); err != nil {
+		return err
+	}
+	if c.GOOS == "linux" {
+		return c.installSystemd()
+	}
+	// sc.exe expects each option and its value as separate arguments
+	options := []string{"binPath=", c.BinPath(), "start=", "auto", "DisplayName=", "opkssh verify broker"}
+	if c.installed() {
+		// Restart with the new command line
+		if c.Stop() == nil {
+			if err := c.waitForState("STOPPED"); err != nil {
+				return err
+			}
+		}
+		if err := c.sc(append([]string{"config", ServiceName}, options...)...); err != nil {
+			return err
+		}
+	} else if err := c.sc(append([]string{"create", ServiceName}, options...)...); err != nil {
+		return err
+	}
+	if err := c.sc("description", ServiceName, "Verifies the logins that opkssh verify --socket forwards from sshd"); err != nil {
+		return err
+	}
+	if err := c.sc("failure", ServiceName, "reset=", "86400", "actions=", "restart/60000/restart/60000/restart/60000"); err != nil {
+		return err
+	}
+	if err := c.Start(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Installed service %s listening on %s. Set in sshd_config:\n  AuthorizedKeysCommand %s\n", ServiceName, c.SocketPath, c.VerifyCommand())
+	return nil
+}
+
+// installSystemd writes the socket and service units, enables the socket
+// and starts listening
+func (c *ServiceCmd) installSystemd() error {
+	for _, unit := range []struct {
+		path    string
+		content []byte
+	}{{c.SocketUnitPath(), c.SocketUnit()}, {c.ServiceUnitPath(), c.ServiceUnit()}} {
+		if err := c.Fs.MkdirAll(filepath.Dir(unit.path), 0o755); err != nil {
+			return err
+		}
+		if err := afero.WriteFile(c.Fs, unit.path, unit.content, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", unit.path, err)
+		}
+	}
+	if err := c.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	// The next login starts the changed service if an older version is running
+	_, _ = c.CmdRunner("systemctl", "stop", SystemdServeUnitName+".service")
+	if err := c.systemctl("enable", SystemdServeUnitName+".socket"); err != nil {
+		return err
+	}
+	if err := c.systemctl("restart", SystemdServeUnitName+".socket"); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Installed %s listening on %s, run journalctl -u %s to see its logs. Set in sshd_config:\n  AuthorizedKeysCommand %s\n",
+		c.SocketUnitPath(), c.SocketPath, SystemdServeUnitName, c.VerifyCommand())
+	return nil
+}
+
+// Uninstall stops the service and removes it
+func (c *ServiceCmd) Uninstall() error {
+	if err := c.checkOS(); err != nil {
+		return err
+	}
+	if !c.installed() {
+		fmt.Fprintf(c.Out, "Service %s is not installed\n", c.name())
+		return nil
+	}
+	if c.GOOS == "linux" {
+		_, _ = c.CmdRunner("systemctl", "disable", "--now", SystemdServeUnitName+".socket")
+		_ = c.Stop()
+		for _, path := range []string{c.SocketUnitPath(), c.ServiceUnitPath()} {
+			if err := c.Fs.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+		_, _ = c.CmdRunner("systemctl", "daemon-reload")
+		fmt.Fprintf(c.Out, "Removed %s, point AuthorizedKeysCommand back at opkssh verify before the next login\n", c.SocketUnitPath())
+		return nil
+	}
+	_ = c.Stop()
+	if err := c.sc("delete", ServiceName); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "Removed service %s, opkssh verify --socket verifies logins itself again\n", ServiceName)
+	return nil
+}
+
+// name returns the name of the service, or on Linux of its units
+func (c *ServiceCmd) name() string {
+	if c.GOOS == "linux" {
+		return SystemdServeUnitName
+	}
+	return ServiceName
+}
+
+// Start starts the service. On Linux it starts listening on the socket, the
+// service itself is started by the next login.
+func (c *ServiceCmd) Start() error {
+	if err := c.checkOS(); err != nil {
+		return err
+	}
+	if c.GOOS == "linux" {
+		return c.systemctl("start", SystemdServeUnitName+".socket")
+	}
+	return c.sc("start", ServiceName)
+}
+
+// Stop stops the service. opkssh verify --socket verifies logins itself
+// while it is stopped, verify --via-socket refuses them.
+func (c *ServiceCmd) Stop() error {
+	if err := c.checkOS(); err != nil {
+		return err
+	}
+	if c.GOOS == "linux" {
+		return c.systemctl("stop", SystemdServeUnitName+".socket", SystemdServeUnitName+".service")
+	}
+	return c.sc("stop", ServiceName)
+}
@@ -0,0 +1,32 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// RunService is only available on Windows, elsewhere opkssh serve is run by
+// the service manager of the OS
+func RunService(ctx context.Context, serve *ServeCmd) error {
+	return fmt.Errorf("opkssh service is only available on Windows, run opkssh serve with the service manager of %s instead", runtime.GOOS)
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService(t *testing.T) {
+	var ran []string
+	state := ""
+	c := &ServiceCmd{
+		GOOS:       "windows",
+		Executable: `C:\Program Files\opkssh\opkssh.exe`,
+		SocketPath: ServePipe,
+		ConfigPath: `C:\ProgramData\opk\config.yml`,
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			require.Equal(t, "sc.exe", name)
+			ran = append(ran, arg[0])
+			switch arg[0] {
+			case "query":
+				if state == "" {
+					return []byte("The specified service does not exist as an installed service."), errors.New("exit status 1060")
+				}
+				return []byte("STATE : " + state), nil
+			case "create":
+				require.Equal(t, []string{"opkssh", "binPath=", `"C:\Program Files\opkssh\opkssh.exe" service run --socket \\.\pipe\opkssh --config-path C:\ProgramData\opk\config.yml`, "start=", "auto"}, arg[1:6])
+				state = "STOPPED"
+			case "start":
+				state = "RUNNING"
+			case "stop":
+				if state != "RUNNING" {
+					return nil, errors.New("exit status 1062")
+				}
+				state = "STOPPED"
+			case "delete":
+				state = ""
+			}
+			return nil, nil
+		},
+		Out: &bytes.Buffer{},
+	}
+	out := c.Out.(*bytes.Buffer)
+
+	require.NoError(t, c.Install())
+	require.Equal(t, []string{"query", "create", "description", "failure", "start"}, ran)
+	require.Contains(t, out.String(), `AuthorizedKeysCommand "C:\Program Files\opkssh\opkssh.exe" verify --socket \\.\pipe\opkssh %u %k %t`)
+
+	// Installing again updates the service and restarts it
+	ran = nil
+	require.NoError(t, c.Install())
+	require.Equal(t, []string{"query", "stop", "query", "config", "description", "failure", "start"}, ran)
+
+	require.NoError(t, c.Stop())
+	require.ErrorContains(t, c.Stop(), "sc.exe stop failed: exit status 1062")
+
+	ran = nil
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, []string{"query", "stop", "delete"}, ran)
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Contains(t, out.String(), "not installed")
+
+	c.GOOS = "darwin"
+	require.ErrorContains(t, c.Install(), "only available on Windows and Linux")
+}
+
+func TestServiceSystemd(t *testing.T) {
+	var ran [][]string
+	fs := afero.NewMemMapFs()
+	c := &ServiceCmd{
+		Fs:         fs,
+		GOOS:       "linux",
+		Executable: "/usr/local/bin/opkssh",
+		SocketPath: "/run/opk/opkssh.sock",
+		ConfigPath: "/etc/opk/config.yml",
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			require.Equal(t, "systemctl", name)
+			ran = append(ran, arg)
+			return nil, nil
+		},
+		Out: &bytes.Buffer{},
+	}
+	out := c.Out.(*bytes.Buffer)
+
+	require.NoError(t, c.Install())
+	require.Equal(t, [][]string{
+		{"daemon-reload"},
+		{"stop", "opkssh-verify.service"},
+		{"enable", "opkssh-verify.socket"},
+		{"restart", "opkssh-verify.socket"},
+	}, ran)
+	socketUnit, err := afero.ReadFile(fs, "/etc/systemd/system/opkssh-verify.socket")
+	require.NoError(t, err)
+	require.Contains(t, string(socketUnit), "ListenStream=/run/opk/opkssh.sock\nSocketUser=root\nSocketGroup=opksshuser\nSocketMode=0660\n")
+	serviceUnit, err := afero.ReadFile(fs, "/etc/systemd/system/opkssh-verify.service")
+	require.NoError(t, err)
+	require.Contains(t, string(serviceUnit), "ExecStart=/usr/local/bin/opkssh serve --config-path /etc/opk/config.yml\n")
+	require.Contains(t, out.String(), "AuthorizedKeysCommand /usr/local/bin/opkssh verify --via-socket %u %k %t")
+
+	c.SocketPath = "/run/other.sock"
+	require.Equal(t, "/usr/local/bin/opkssh verify --via-socket --socket /run/other.sock %u %k %t", c.VerifyCommand())
+
+	ran = nil
+	require.NoError(t, c.Stop())
+	require.Equal(t, [][]string{{"stop", "opkssh-verify.socket", "opkssh-verify.service"}}, ran)
+
+	ran = nil
+	require.NoError(t, c.Uninstall())
+	require.Equal(t, []string{"disable", "--now", "opkssh-verify.socket"}, ran[0])
+	_, err = fs.Stat("/etc/systemd/system/opkssh-verify.service")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	out.Reset()
+	require.NoError(t, c.Uninstall())
+	require.Contains(t, out.String(), "opkssh-verify is not installed")
+}
+
+func TestServeNamedPipePaths(t *testing.T) {
+	require.True(t, isNamedPipe(ServePipe))
+	require.False(t, isNamedPipe("/run/opk/opkssh.sock"))
+	require.False(t, isNamedPipe(`C:\ProgramData\opk\state\opkssh.sock`))
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"context"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// RunService runs serve until the service control manager stops it. Started
+// from a console, it runs serve until ctx is done.
+func RunService(ctx context.Context, serve *ServeCmd) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return serve.Run(ctx)
+	}
+	return svc.Run(ServiceName, &serviceHandler{ctx: ctx, serve: serve})
+}
+
+type serviceHandler struct {
+	ctx   context.Context
+	serve *ServeCmd
+}
+
+// Execute reports a failure to the service control manager if serve stops
+// by itself, so the service is restarted
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.serve.Run(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			h.serve.Logger.Error("opkssh serve stopped", "error", err)
+			return true, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				if err := <-done; err != nil {
+					h.serve.Logger.Error("opkssh serve stopped", "error", err)
+				}
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var errSignerAgentReadOnly = errors.New("the opkssh agent only holds the key of opkssh login")
+
+// signerAgent is an ssh-agent that holds one key, whose private key is only
+// reachable through a crypto.Signer, and its SSH certificate. It stops
+// offering the key once the certificate has expired.
+type signerAgent struct {
+	signer ssh.Signer
+
+	mu        sync.Mutex
+	cert      *ssh.Certificate
+	expiresAt time.Time
+	listener  net.Listener
+}
+
+var _ agent.ExtendedAgent = (*signerAgent)(nil)
+
+// newSignerAgent returns an agent for the key of signer
+func newSignerAgent(signer crypto.Signer) (*signerAgent, error) {
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	if err != nil {
+		return nil, err
+	}
+	return &signerAgent{signer: sshSigner}, nil
+}
+
+// SetCertificate replaces the SSH certificate, in authorized_keys format,
+// offered by the agent until expiresAt
+func (a *signerAgent) SetCertificate(certBytes []byte, expiresAt time.Time) error {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH cert: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("SSH cert is a %s public key, not a certificate", pub.Type())
+	}
+	if !bytes.Equal(cert.Key.Marshal(), a.signer.PublicKey().Marshal()) {
+		return fmt.Errorf("SSH cert is not for the key of the agent")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cert = cert
+	a.expiresAt = expiresAt
+	return nil
+}
+
+// Expiration returns the SSH certificate and when the agent stops offering it
+func (a *signerAgent) Expiration() (*ssh.Certificate, time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cert, a.expiresAt
+}
+
+// certSigner returns the signer of the certificate, or nil if it expired
+func (a *signerAgent) certSigner() (ssh.Signer, *ssh.Certificate) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cert == nil || !time.Now().Before(a.expiresAt) {
+		return nil, nil
+	}
+	signer, err := ssh.NewCertSigner(a.cert, a.signer)
+	if err != nil {
+		return nil, nil
+	}
+	return signer, a.cert
+}
+
+func (a *signerAgent) List() ([]*agent.Key, error) {
+	_, cert := a.certSigner()
+	if cert == nil {
+		return nil, nil
+	}
+	return []*agent.Key{{Format: cert.Type(), Blob: cert.Marshal(), Comment: "openpubkey"}}, nil
+}
+
+func (a *signerAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *signerAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	signer, cert := a.certSigner()
+	if cert == nil || !bytes.Equal(key.Marshal(), cert.Marshal()) {
+		return nil, fmt.Errorf("the opkssh agent does not hold the key %s", ssh.FingerprintSHA256(key))
+	}
+	return signer.Sign(rand.Reader, data)
+}
+
+func (a *signerAgent) Signers() ([]ssh.Signer, error) {
+	signer, _ := a.certSigner()
+	if signer == nil {
+		return nil, nil
+	}
+	return []ssh.Signer{signer}, nil
+}
+
+func (a *signerAgent) Add(key agent.AddedKey) error   { return errSignerAgentReadOnly }
+func (a *signerAgent) Remove(key ssh.PublicKey) error { return errSignerAgentReadOnly }
+func (a *signerAgent) RemoveAll() error               { return errSignerAgentReadOnly }
+func (a *signerAgent) Lock(passphrase []byte) error   { return errSignerAgentReadOnly }
+func (a *signerAgent) Unlock(passphrase []byte) error { return errSignerAgentReadOnly }
+func (a *signerAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// Serve listens at sock and answers ssh until Close is called
+func (a *signerAgent) Serve(sock string) error {
+	listener, err := listenAgent(sock)
+	if err != nil {
+		return fmt.Errorf("failed to listen at %s: %w", sock, err)
+	}
+	a.mu.Lock()
+	a.listener = listener
+	a.mu.Unlock()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if err := agent.ServeAgent(a, conn); err != nil && !errors.Is(err, io.EOF) {
+					slog.Warn("Failed to serve the opkssh agent", "error", err)
+				}
+			}()
+		}
+	}()
+	return nil
+}
+
+// Close stops serving the key
+func (a *signerAgent) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.listener == nil {
+		return nil
+	}
+	err := a.listener.Close()
+	a.listener = nil
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testHardwareKey is a software key that login treats as held by a device
+type testHardwareKey struct {
+	*ecdsa.PrivateKey
+	closed bool
+}
+
+func (k *testHardwareKey) Close() error {
+	k.closed = true
+	return nil
+}
+
+func newTestHardwareKey(t *testing.T) *testHardwareKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &testHardwareKey{PrivateKey: key}
+}
+
+func TestSignerAgent(t *testing.T) {
+	key := newTestHardwareKey(t)
+	a, err := newSignerAgent(key)
+	require.NoError(t, err)
+
+	newCert := func(key crypto.Signer, serial uint64) []byte {
+		signer, err := ssh.NewSignerFromSigner(key)
+		require.NoError(t, err)
+		cert := &ssh.Certificate{Key: signer.PublicKey(), Serial: serial, CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+		require.NoError(t, cert.SignCert(rand.Reader, signer))
+		return ssh.MarshalAuthorizedKey(cert)
+	}
+
+	clientConn, agentConn := net.Pipe()
+	t.Cleanup(func() { _ = clientConn.Close() })
+	go func() { _ = agent.ServeAgent(a, agentConn) }()
+	client := agent.NewClient(clientConn)
+
+	// Nothing is offered until there is a certificate
+	keys, err := client.List()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	require.NoError(t, a.SetCertificate(newCert(key, 1), time.Now().Add(time.Hour)))
+	keys, err = client.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "openpubkey", keys[0].Comment)
+
+	sig, err := client.Sign(keys[0], []byte("data"))
+	require.NoError(t, err)
+	cert, err := ssh.ParsePublicKey(keys[0].Blob)
+	require.NoError(t, err)
+	require.NoError(t, cert.(*ssh.Certificate).Key.Verify([]byte("data"), sig))
+
+	require.Error(t, client.Add(agent.AddedKey{PrivateKey: key.PrivateKey}))
+	require.ErrorContains(t, a.SetCertificate(newCert(newTestHardwareKey(t), 2), time.Now().Add(time.Hour)), "not for the key of the agent")
+
+	// The key is forgotten when the certificate expires
+	require.NoError(t, a.SetCertificate(newCert(key, 3), time.Now().Add(-time.Second)))
+	keys, err = client.List()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	_, err = client.Sign(cert, []byte("data"))
+	require.Error(t, err)
+}
@@ -0,0 +1,138 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// removeKeyFromAgent removes pub and certificates for pub from the agent and
+// returns how many identities were removed
+func removeKeyFromAgent(a agent.Agent, pub ssh.PublicKey) (int, error) {
+	keys, err := a.List()
+	if err != nil {
+		return 0, err
+	}
+	want := pub.Marshal()
+	removed := 0
+	for _, k := range keys {
+		key, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+		if bytes.Equal(key.Marshal(), want) {
+			if err := a.Remove(k); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeKeyFromSystemAgent removes the SSH key secKeyPem from the ssh-agent at
+// SSH_AUTH_SOCK. macOS starts an ssh-agent for every user, and with
+// AddKeysToAgent or UseKeychain it keeps offering the old certificate after
+// login replaced the key on disk.
+func removeKeyFromSystemAgent(secKeyPem []byte) (int, error) {
+	signer, err := ssh.ParsePrivateKey(secKeyPem)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+	a, closer, err := dialSystemAgent()
+	if err != nil || a == nil {
+		return 0, err
+	}
+	defer closer.Close()
+	return removeKeyFromAgent(a, signer.PublicKey())
+}
+
+// dialSystemAgent connects to the ssh-agent at SSH_AUTH_SOCK, or on Windows
+// to the OpenSSH agent service if SSH_AUTH_SOCK is not set. It returns a nil
+// agent if there is no agent to connect to.
+func dialSystemAgent() (agent.ExtendedAgent, io.Closer, error) {
+	sock := systemAgentSocket()
+	if sock == "" {
+		return nil, nil, nil
+	}
+	conn, err := dialAgent(sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", sock, err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// agentHasKey returns true if the agent holds pub or a certificate for pub
+func agentHasKey(a agent.Agent, pub ssh.PublicKey) (bool, error) {
+	keys, err := a.List()
+	if err != nil {
+		return false, err
+	}
+	want := pub.Marshal()
+	for _, k := range keys {
+		key, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+		if bytes.Equal(key.Marshal(), want) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addKeyToAgent replaces the identities of the SSH key secKeyPem in the
+// agent with the key and its certificate certBytes, in authorized_keys
+// format. The agent forgets them after lifetime unless it is zero.
+func addKeyToAgent(a agent.Agent, secKeyPem []byte, certBytes []byte, lifetime time.Duration) error {
+	privateKey, err := ssh.ParseRawPrivateKey(secKeyPem)
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH key: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse SSH cert: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return fmt.Errorf("SSH cert is a %s public key, not a certificate", pub.Type())
+	}
+	if _, err := removeKeyFromAgent(a, cert.Key); err != nil {
+		return err
+	}
+	added := agent.AddedKey{
+		PrivateKey:  privateKey,
+		Certificate: cert,
+		Comment:     "openpubkey",
+	}
+	if lifetime > 0 {
+		added.LifetimeSecs = uint32((lifetime + time.Second - 1) / time.Second)
+	}
+	return a.Add(added)
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestRemoveKeyFromAgent(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	oldSigner, err := ssh.NewSignerFromKey(oldKey)
+	require.NoError(t, err)
+	cert := &ssh.Certificate{Key: oldSigner.PublicKey(), CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+	require.NoError(t, cert.SignCert(rand.Reader, oldSigner))
+
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: oldKey, Comment: "openpubkey cert"}))
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: oldKey, Certificate: cert, Comment: "openpubkey cert"}))
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: otherKey, Comment: "other"}))
+
+	removed, err := removeKeyFromAgent(keyring, oldSigner.PublicKey())
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+
+	keys, err := keyring.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.Equal(t, "other", keys[0].Comment)
+}
+
+func TestAddKeyToAgent(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	pemBlock, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	secKeyPem := pem.EncodeToMemory(pemBlock)
+
+	newCert := func(serial uint64) []byte {
+		cert := &ssh.Certificate{Key: signer.PublicKey(), Serial: serial, CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+		require.NoError(t, cert.SignCert(rand.Reader, signer))
+		return ssh.MarshalAuthorizedKey(cert)
+	}
+
+	keyring := agent.NewKeyring()
+	has, err := agentHasKey(keyring, signer.PublicKey())
+	require.NoError(t, err)
+	require.False(t, has)
+
+	require.NoError(t, addKeyToAgent(keyring, secKeyPem, newCert(1), time.Hour))
+	has, err = agentHasKey(keyring, signer.PublicKey())
+	require.NoError(t, err)
+	require.True(t, has)
+
+	// The refreshed certificate replaces the old one
+	require.NoError(t, addKeyToAgent(keyring, secKeyPem, newCert(2), time.Hour))
+	keys, err := keyring.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	pub, err := ssh.ParsePublicKey(keys[0].Blob)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), pub.(*ssh.Certificate).Serial)
+
+	require.ErrorContains(t, addKeyToAgent(keyring, secKeyPem, ssh.MarshalAuthorizedKey(signer.PublicKey()), 0), "not a certificate")
+}
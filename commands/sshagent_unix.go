@@ -0,0 +1,65 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// systemAgentSocket returns the socket of the ssh-agent, or an empty string
+// if SSH_AUTH_SOCK is not set
+func systemAgentSocket() string {
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+func dialAgent(sock string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", sock)
+}
+
+// defaultSignerAgentSocket returns where login serves keys that are held by a
+// security device
+func defaultSignerAgentSocket() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "opkssh-agent.sock"), nil
+}
+
+// listenAgent listens at the unix socket sock, which only the user can
+// connect to. A socket that is left over from an earlier login is replaced.
+func listenAgent(sock string) (net.Listener, error) {
+	if conn, err := net.Dial("unix", sock); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("another agent is listening at %s", sock)
+	}
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return listenUnixSocket(sock)
+}
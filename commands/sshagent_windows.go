@@ -0,0 +1,74 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+// +build windows
+
+package commands
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// OpenSSHAgentPipe is the named pipe of the ssh-agent service that ships
+// with OpenSSH for Windows
+const OpenSSHAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// systemAgentSocket returns SSH_AUTH_SOCK, which may be a named pipe or a
+// unix socket, and defaults to the OpenSSH agent service
+func systemAgentSocket() string {
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		return sock
+	}
+	return OpenSSHAgentPipe
+}
+
+func dialAgent(sock string) (io.ReadWriteCloser, error) {
+	if isNamedPipe(sock) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return dialNamedPipe(ctx, sock)
+	}
+	return net.Dial("unix", sock)
+}
+
+// defaultSignerAgentSocket returns the named pipe where login serves keys
+// that are held by a security device
+func defaultSignerAgentSocket() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return `\\.\pipe\opkssh-agent-` + strings.ReplaceAll(u.Username, `\`, "-"), nil
+}
+
+// listenAgent listens at the named pipe sock, which only the current user
+// can connect to
+func listenAgent(sock string) (net.Listener, error) {
+	tokenUser, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+	return listenNamedPipe(sock, "D:P(A;;GA;;;"+tokenUser.User.Sid.String()+")")
+}
@@ -0,0 +1,164 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// FragmentTrustedKeys parses the keys verify accepts policy fragment
+// signatures from
+func FragmentTrustedKeys(cfg config.PolicyFragmentsConfig) ([]ssh.PublicKey, error) {
+	return parseTrustedKeys("policy_fragments", cfg.TrustedKeys)
+}
+
+// PluginTrustedKeys parses the keys verify accepts policy plugin config
+// signatures from
+func PluginTrustedKeys(cfg config.PluginsConfig) ([]ssh.PublicKey, error) {
+	return parseTrustedKeys("plugins", cfg.TrustedKeys)
+}
+
+// parseTrustedKeys parses the authorized_keys lines of the trusted_keys of
+// the server config field
+func parseTrustedKeys(field string, trustedKeys []string) ([]ssh.PublicKey, error) {
+	keys := []ssh.PublicKey{}
+	for _, trustedKey := range trustedKeys {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(trustedKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s trusted key %q: %w", field, trustedKey, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// NewSigningFragmentStore returns the default FragmentStore, signing written
+// fragments with the key configured in cfg if any
+func NewSigningFragmentStore(fsys afero.Fs, cfg config.PolicyFragmentsConfig) (*policy.FragmentStore, error) {
+	store := policy.NewFragmentStore()
+	if cfg.SigningKey == "" {
+		return store, nil
+	}
+	keyBytes, err := afero.ReadFile(fsys, cfg.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy_fragments signing key: %w", err)
+	}
+	if store.Signer, err = ssh.ParsePrivateKey(keyBytes); err != nil {
+		return nil, fmt.Errorf("failed to parse policy_fragments signing key: %w", err)
+	}
+	return store, nil
+}
+
+// policyFromGroups grants each identity in members[mapping.Group] the
+// principals of mapping. The result is sorted so that unchanged membership
+// produces an identical fragment.
+func policyFromGroups(issuer string, mappings []config.GroupSyncMapping, members map[string][]string) *policy.Policy {
+	p := &policy.Policy{}
+	seen := map[string]bool{}
+	for _, mapping := range mappings {
+		identities := slices.Clone(members[mapping.Group])
+		slices.Sort(identities)
+		for _, identity := range identities {
+			for _, principal := range mapping.Principals {
+				key := principal + " " + strings.ToLower(identity)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				p.Users = append(p.Users, policy.User{
+					IdentityAttribute: identity,
+					Principals:        []string{principal},
+					Issuer:            issuer,
+				})
+			}
+		}
+	}
+	return p
+}
+
+// policyLines returns each principal, identity and issuer in p as a line
+func policyLines(p *policy.Policy) []string {
+	lines := []string{}
+	for _, user := range p.Users {
+		for _, principal := range user.Principals {
+			lines = append(lines, fmt.Sprintf("%s %s %s", principal, user.IdentityAttribute, user.Issuer))
+		}
+	}
+	return lines
+}
+
+// writeSyncedFragment replaces the policy fragment called name with p if it
+// changed, recording the change in journal. Returns the entries added and
+// removed.
+func writeSyncedFragment(store *policy.FragmentStore, journal *policy.Journal, name string, header []string, p *policy.Policy) ([]string, []string, error) {
+	current, err := store.LoadFragment(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read current policy fragment: %w", err)
+	}
+	currentLines, newLines := policyLines(current), policyLines(p)
+	added, removed := []string{}, []string{}
+	for _, line := range newLines {
+		if !slices.Contains(currentLines, line) {
+			added = append(added, line)
+		}
+	}
+	for _, line := range currentLines {
+		if !slices.Contains(newLines, line) {
+			removed = append(removed, line)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return added, removed, nil
+	}
+
+	if err := store.Write(name, header, p); err != nil {
+		return nil, nil, err
+	}
+	path := store.Path(name)
+	if journal != nil {
+		summary := []string{}
+		for _, line := range added {
+			summary = append(summary, "+ "+line)
+		}
+		for _, line := range removed {
+			summary = append(summary, "- "+line)
+		}
+		if err := journal.Append(policy.JournalEntry{
+			Action:  "sync",
+			Path:    path,
+			Summary: summary,
+		}); err != nil {
+			slog.Warn("Failed to record the change in the policy journal", "error", err)
+		}
+	}
+	events.Emit(events.PolicyChanged, map[string]string{
+		"path":    path,
+		"action":  "sync",
+		"added":   fmt.Sprint(len(added)),
+		"removed": fmt.Sprint(len(removed)),
+	})
+	return added, removed, nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+)
+
+const (
+	azureFragmentName  = "azuread"
+	defaultAzureGraph  = "https://graph.microsoft.com/v1.0"
+	defaultAzureLogin  = "https://login.microsoftonline.com"
+	azureGraphUserType = "#microsoft.graph.user"
+	// azureIdentityPrefix matches the immutable object id of the user in the
+	// oid claim. The email claim is not verified by Entra ID.
+	azureIdentityPrefix  = policy.OIDC_CLAIMS + "oid:"
+	azureSyncHTTPTimeout = 30 * time.Second
+)
+
+// Azure AD object ids are GUIDs. Validating them also prevents injection
+// into the Graph $filter expression.
+var validAzureObjectID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// errAzureDeltaExpired is returned when Graph no longer accepts a delta link
+// and the group must be synced from scratch
+var errAzureDeltaExpired = errors.New("delta link expired")
+
+// azureSyncCache is persisted between runs so that only membership changes
+// are fetched from Graph
+type azureSyncCache struct {
+	Groups map[string]*azureGroupCache `json:"groups"`
+}
+
+type azureGroupCache struct {
+	DeltaLink string `json:"delta_link"`
+	// Members are the object ids of the members
+	Members map[string]bool `json:"members"`
+}
+
+// AzureSyncCmd resolves the members of Azure AD groups with Microsoft Graph
+// and writes a policy fragment granting them the principals mapped to their
+// groups
+type AzureSyncCmd struct {
+	Config     config.AzureADConfig
+	Fragments  *policy.FragmentStore
+	Journal    *policy.Journal
+	Fs         afero.Fs
+	CachePath  string
+	HttpClient *http.Client
+	Logger     *slog.Logger
+}
+
+// NewAzureSyncCmd creates a new AzureSyncCmd writing to the default fragment
+// directory
+func NewAzureSyncCmd(rt *Runtime, cfg config.AzureADConfig) *AzureSyncCmd {
+	return &AzureSyncCmd{
+		Config:     cfg,
+		Fragments:  policy.NewFragmentStore(),
+		Journal:    policy.NewJournal(),
+		Fs:         rt.Fs,
+		CachePath:  filepath.Join(policy.GetSystemStateBasePath(), "cache", azureFragmentName+".json"),
+		HttpClient: &http.Client{Timeout: azureSyncHTTPTimeout},
+		Logger:     rt.Logger,
+	}
+}
+
+// Run syncs every configured group and updates the policy fragment. Returns
+// the policy entries added and removed.
+func (a *AzureSyncCmd) Run(ctx context.Context) ([]string, []string, error) {
+	if a.Config.TenantID == "" || a.Config.ClientID == "" || a.Config.ClientSecret == "" {
+		return nil, nil, fmt.Errorf("azure_ad tenant_id, client_id and client_secret must be configured")
+	}
+	for _, mapping := range a.Config.Groups {
+		if !validAzureObjectID.MatchString(mapping.Group) {
+			return nil, nil, fmt.Errorf("azure_ad group %q is not an object id", mapping.Group)
+		}
+	}
+
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cache, err := a.loadCache()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := map[string][]string{}
+	for _, mapping := range a.Config.Groups {
+		groupCache, ok := cache.Groups[mapping.Group]
+		if !ok {
+			groupCache = &azureGroupCache{Members: map[string]bool{}}
+			cache.Groups[mapping.Group] = groupCache
+		}
+		err := a.syncGroup(ctx, token, mapping.Group, groupCache)
+		if errors.Is(err, errAzureDeltaExpired) {
+			a.Logger.Info("Delta link expired, syncing all members", "group", mapping.Group)
+			groupCache = &azureGroupCache{Members: map[string]bool{}}
+			cache.Groups[mapping.Group] = groupCache
+			err = a.syncGroup(ctx, token, mapping.Group, groupCache)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to sync group %s: %w", mapping.Group, err)
+		}
+		for id := range groupCache.Members {
+			members[mapping.Group] = append(members[mapping.Group], azureIdentityPrefix+strings.ToLower(id))
+		}
+	}
+
+	issuer := a.Config.Issuer
+	if issuer == "" {
+		issuer = defaultAzureLogin + "/" + a.Config.TenantID + "/v2.0"
+	}
+	header := []string{
+		"Generated by opkssh sync azure, do not edit. Changes are overwritten on the next sync.",
+		"Last changed " + time.Now().UTC().Format(time.RFC3339),
+	}
+	added, removed, err := writeSyncedFragment(a.Fragments, a.Journal, azureFragmentName, header, policyFromGroups(issuer, a.Config.Groups, members))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only advance the delta links once the policy reflects them
+	if err := a.saveCache(cache); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// syncGroup applies the membership changes since groupCache.DeltaLink, or all
+// members if there is no delta link yet
+func (a *AzureSyncCmd) syncGroup(ctx context.Context, token string, groupID string, groupCache *azureGroupCache) error {
+	next := groupCache.DeltaLink
+	if next == "" {
+		query := url.Values{}
+		query.Set("$filter", fmt.Sprintf("id eq '%s'", groupID))
+		query.Set("$select", "members")
+		next = a.graphURL() + "/groups/delta?" + query.Encode()
+	}
+
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID           string          `json:"id"`
+				Removed      json.RawMessage `json:"@removed"`
+				MembersDelta []struct {
+					Type    string          `json:"@odata.type"`
+					ID      string          `json:"id"`
+					Removed json.RawMessage `json:"@removed"`
+				} `json:"members@delta"`
+			} `json:"value"`
+			NextLink  string `json:"@odata.nextLink"`
+			DeltaLink string `json:"@odata.deltaLink"`
+		}
+		if err := a.graphGet(ctx, token, next, &page); err != nil {
+			return err
+		}
+		for _, group := range page.Value {
+			if group.Removed != nil {
+				// The group was deleted, nobody is a member anymore
+				clear(groupCache.Members)
+				continue
+			}
+			for _, member := range group.MembersDelta {
+				if member.Removed != nil {
+					delete(groupCache.Members, member.ID)
+				} else if member.Type == azureGraphUserType {
+					groupCache.Members[member.ID] = true
+				}
+			}
+		}
+		next = page.NextLink
+		if page.DeltaLink != "" {
+			groupCache.DeltaLink = page.DeltaLink
+		}
+	}
+	return nil
+}
+
+// accessToken gets a Graph access token with the client credentials grant
+func (a *AzureSyncCmd) accessToken(ctx context.Context) (string, error) {
+	loginURL := a.Config.LoginURL
+	if loginURL == "" {
+		loginURL = defaultAzureLogin
+	}
+	graph, err := url.Parse(a.graphURL())
+	if err != nil {
+		return "", fmt.Errorf("invalid azure_ad graph_url: %w", err)
+	}
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", a.Config.ClientID)
+	form.Set("client_secret", a.Config.ClientSecret)
+	form.Set("scope", graph.Scheme+"://"+graph.Host+"/.default")
+
+	tokenURL := strings.TrimSuffix(loginURL, "/") + "/" + url.PathEscape(a.Config.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.HttpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get graph access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get graph access token: %s", resp.Status)
+	}
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil || tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("invalid graph access token response")
+	}
+	return tokenResp.AccessToken, nil
+}
+
+func (a *AzureSyncCmd) graphURL() string {
+	if a.Config.GraphURL != "" {
+		return strings.TrimSuffix(a.Config.GraphURL, "/")
+	}
+	return defaultAzureGraph
+}
+
+// graphGet decodes the JSON response to a Graph GET request into out
+func (a *AzureSyncCmd) graphGet(ctx context.Context, token string, requestURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return errAzureDeltaExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("graph request %s returned %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *AzureSyncCmd) loadCache() (*azureSyncCache, error) {
+	cache := &azureSyncCache{Groups: map[string]*azureGroupCache{}}
+	cacheBytes, err := afero.ReadFile(a.Fs, a.CachePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, fmt.Errorf("failed to read sync cache: %w", err)
+	}
+	if err := json.Unmarshal(cacheBytes, cache); err != nil {
+		// The cache only saves work, start over rather than fail
+		a.Logger.Warn("Ignoring corrupt sync cache", "path", a.CachePath, "error", err)
+		return &azureSyncCache{Groups: map[string]*azureGroupCache{}}, nil
+	}
+	if cache.Groups == nil {
+		cache.Groups = map[string]*azureGroupCache{}
+	}
+	return cache, nil
+}
+
+func (a *AzureSyncCmd) saveCache(cache *azureSyncCache) error {
+	cacheBytes, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := a.Fs.MkdirAll(filepath.Dir(a.CachePath), 0700); err != nil {
+		return fmt.Errorf("failed to create sync cache directory: %w", err)
+	}
+	if err := afero.WriteFile(a.Fs, a.CachePath, cacheBytes, 0600); err != nil {
+		return fmt.Errorf("failed to write sync cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const azureTestGroup = "6f1c9a52-1b0e-4d7f-9d43-0c2a5e8b7a10"
+
+// mockGraph serves a token endpoint and a group whose first delta adds alice
+// and bob and whose second delta removes bob
+func mockGraph(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tenant-id/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		require.Equal(t, server.URL+"/.default", r.PostForm.Get("scope"))
+		_, _ = w.Write([]byte(`{"access_token":"graph-token","token_type":"Bearer"}`))
+	})
+	mux.HandleFunc("GET /v1.0/groups/delta", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer graph-token", r.Header.Get("Authorization"))
+		switch r.URL.Query().Get("$deltatoken") {
+		case "":
+			require.Equal(t, "id eq '"+azureTestGroup+"'", r.URL.Query().Get("$filter"))
+			fmt.Fprintf(w, `{"value":[{"id":"%s","members@delta":[
+				{"@odata.type":"#microsoft.graph.user","id":"u-alice"},
+				{"@odata.type":"#microsoft.graph.group","id":"g-nested"}]}],
+				"@odata.nextLink":"%s/v1.0/groups/delta?$skiptoken=page2"}`, azureTestGroup, server.URL)
+		case "first":
+			fmt.Fprintf(w, `{"value":[{"id":"%s","members@delta":[
+				{"@odata.type":"#microsoft.graph.user","id":"u-bob","@removed":{"reason":"deleted"}}]}],
+				"@odata.deltaLink":"%s/v1.0/groups/delta?$deltatoken=second"}`, azureTestGroup, server.URL)
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	})
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The second page of the first sync adds bob and returns the delta link
+		if r.URL.Query().Get("$skiptoken") == "page2" {
+			fmt.Fprintf(w, `{"value":[{"id":"%s","members@delta":[
+				{"@odata.type":"#microsoft.graph.user","id":"u-bob"}]}],
+				"@odata.deltaLink":"%s/v1.0/groups/delta?$deltatoken=first"}`, azureTestGroup, server.URL)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return server
+}
+
+func TestAzureSync(t *testing.T) {
+	t.Parallel()
+	server := mockGraph(t)
+	defer server.Close()
+
+	mockFs := afero.NewMemMapFs()
+	azureSync := &AzureSyncCmd{
+		Config: config.AzureADConfig{
+			TenantID:     "tenant-id",
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			GraphURL:     server.URL + "/v1.0",
+			LoginURL:     server.URL,
+			Groups:       []config.GroupSyncMapping{{Group: azureTestGroup, Principals: []string{"dev", "deploy"}}},
+		},
+		Fragments:  &policy.FragmentStore{Fs: mockFs, Dir: policy.SystemDefaultFragmentDir},
+		Journal:    &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath},
+		Fs:         mockFs,
+		CachePath:  "/var/lib/opk/cache/azuread.json",
+		HttpClient: server.Client(),
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	issuer := "https://login.microsoftonline.com/tenant-id/v2.0"
+	added, removed, err := azureSync.Run(context.Background())
+	require.NoError(t, err)
+	// Members are granted by object id, their mail isn't verified by Entra ID
+	require.Equal(t, []string{
+		"dev oidc:oid:u-alice " + issuer,
+		"deploy oidc:oid:u-alice " + issuer,
+		"dev oidc:oid:u-bob " + issuer,
+		"deploy oidc:oid:u-bob " + issuer,
+	}, added)
+	require.Empty(t, removed)
+
+	// The second run uses the delta link from the cache and only sees bob leave
+	added, removed, err = azureSync.Run(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, added)
+	require.Equal(t, []string{"dev oidc:oid:u-bob " + issuer, "deploy oidc:oid:u-bob " + issuer}, removed)
+
+	fragment, err := azureSync.Fragments.LoadFragment("azuread")
+	require.NoError(t, err)
+	require.Len(t, fragment.Users, 2)
+
+	// An expired delta link falls back to a full sync
+	added, removed, err = azureSync.Run(context.Background())
+	require.NoError(t, err)
+	require.Len(t, added, 2)
+	require.Empty(t, removed)
+
+	entries, err := azureSync.Journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "sync", entries[0].Action)
+}
+
+func TestAzureSyncRejectsInvalidGroup(t *testing.T) {
+	t.Parallel()
+	azureSync := NewAzureSyncCmd(newTestRuntime(afero.NewMemMapFs(), io.Discard, time.Now()), config.AzureADConfig{
+		TenantID:     "tenant-id",
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		Groups:       []config.GroupSyncMapping{{Group: "x' or id ne 'y", Principals: []string{"root"}}},
+	})
+	_, _, err := azureSync.Run(context.Background())
+	require.ErrorContains(t, err, "is not an object id")
+}
@@ -0,0 +1,261 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+)
+
+const (
+	googleFragmentName  = "google"
+	googleIssuer        = "https://accounts.google.com"
+	defaultGoogleAdmin  = "https://admin.googleapis.com"
+	defaultGoogleMeta   = "http://metadata.google.internal"
+	googleMemberScope   = "https://www.googleapis.com/auth/admin.directory.group.member.readonly"
+	googleJwtBearerType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// googleServiceAccountKey is the subset of a service account JSON key used
+// to sign the domain-wide delegation assertion
+type googleServiceAccountKey struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// GoogleSyncCmd resolves the members of Google Groups with the Admin SDK
+// Directory API and writes a policy fragment granting them the principals
+// mapped to their groups
+type GoogleSyncCmd struct {
+	Config     config.GoogleWorkspaceConfig
+	Fragments  *policy.FragmentStore
+	Journal    *policy.Journal
+	Fs         afero.Fs
+	HttpClient *http.Client
+}
+
+// NewGoogleSyncCmd creates a new GoogleSyncCmd writing to the default
+// fragment directory
+func NewGoogleSyncCmd(rt *Runtime, cfg config.GoogleWorkspaceConfig) *GoogleSyncCmd {
+	return &GoogleSyncCmd{
+		Config:     cfg,
+		Fragments:  policy.NewFragmentStore(),
+		Journal:    policy.NewJournal(),
+		Fs:         rt.Fs,
+		HttpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Run syncs every configured group and updates the policy fragment. Returns
+// the policy entries added and removed.
+func (g *GoogleSyncCmd) Run(ctx context.Context) ([]string, []string, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	members := map[string][]string{}
+	for _, mapping := range g.Config.Groups {
+		groupMembers, err := g.groupMembers(ctx, token, mapping.Group)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to sync group %s: %w", mapping.Group, err)
+		}
+		members[mapping.Group] = groupMembers
+	}
+
+	issuer := g.Config.Issuer
+	if issuer == "" {
+		issuer = googleIssuer
+	}
+	header := []string{
+		"Generated by opkssh sync google, do not edit. Changes are overwritten on the next sync.",
+		"Last changed " + time.Now().UTC().Format(time.RFC3339),
+	}
+	return writeSyncedFragment(g.Fragments, g.Journal, googleFragmentName, header, policyFromGroups(issuer, g.Config.Groups, members))
+}
+
+// groupMembers returns the emails of the active users in group, including
+// members of nested groups
+func (g *GoogleSyncCmd) groupMembers(ctx context.Context, token string, group string) ([]string, error) {
+	adminURL := g.Config.AdminURL
+	if adminURL == "" {
+		adminURL = defaultGoogleAdmin
+	}
+	emails := []string{}
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("includeDerivedMembership", "true")
+		query.Set("maxResults", "200")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		membersURL := strings.TrimSuffix(adminURL, "/") + "/admin/directory/v1/groups/" + url.PathEscape(group) + "/members?" + query.Encode()
+
+		var page struct {
+			Members []struct {
+				Email  string `json:"email"`
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"members"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := g.getJSON(ctx, membersURL, map[string]string{"Authorization": "Bearer " + token}, &page); err != nil {
+			return nil, err
+		}
+		for _, member := range page.Members {
+			if member.Type == "USER" && member.Status == "ACTIVE" && member.Email != "" {
+				emails = append(emails, member.Email)
+			}
+		}
+		if page.NextPageToken == "" {
+			return emails, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// accessToken gets a Directory API access token, by domain-wide delegation
+// if a credentials file is configured and otherwise from the metadata server
+func (g *GoogleSyncCmd) accessToken(ctx context.Context) (string, error) {
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if g.Config.CredentialsFile == "" {
+		metadataURL := g.Config.MetadataURL
+		if metadataURL == "" {
+			metadataURL = defaultGoogleMeta
+		}
+		tokenURL := strings.TrimSuffix(metadataURL, "/") + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(googleMemberScope)
+		if err := g.getJSON(ctx, tokenURL, map[string]string{"Metadata-Flavor": "Google"}, &tokenResp); err != nil {
+			return "", fmt.Errorf("failed to get access token from metadata server: %w", err)
+		}
+		return tokenResp.AccessToken, nil
+	}
+
+	if g.Config.Subject == "" {
+		return "", fmt.Errorf("google_workspace subject must be set to the admin to impersonate with domain-wide delegation")
+	}
+	keyBytes, err := afero.ReadFile(g.Fs, g.Config.CredentialsFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read google_workspace credentials_file: %w", err)
+	}
+	var key googleServiceAccountKey
+	if err := json.Unmarshal(keyBytes, &key); err != nil || key.Type != "service_account" {
+		return "", fmt.Errorf("google_workspace credentials_file is not a service account key")
+	}
+	assertion, err := key.assertion(g.Config.Subject, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", googleJwtBearerType)
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.HttpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get access token: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil || tokenResp.AccessToken == "" {
+		return "", fmt.Errorf("invalid access token response")
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// assertion returns a JWT signed by the service account asking to act as
+// subject
+func (k googleServiceAccountKey) assertion(subject string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid service account private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid service account private key: %w", err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account private key is not an RSA key")
+	}
+
+	token, err := jwt.NewBuilder().
+		Issuer(k.ClientEmail).
+		Subject(subject).
+		Audience([]string{k.TokenURI}).
+		IssuedAt(now).
+		Expiration(now.Add(time.Hour)).
+		Claim("scope", googleMemberScope).
+		Build()
+	if err != nil {
+		return "", err
+	}
+	headers := jws.NewHeaders()
+	if err := headers.Set(jws.KeyIDKey, k.PrivateKeyID); err != nil {
+		return "", err
+	}
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256, rsaKey, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign assertion: %w", err)
+	}
+	return string(signed), nil
+}
+
+func (g *GoogleSyncCmd) getJSON(ctx context.Context, requestURL string, headers map[string]string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := g.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request %s returned %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
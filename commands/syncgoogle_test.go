@@ -0,0 +1,146 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDirectory serves the members of dev@example.com over two pages
+func mockDirectory(t *testing.T, wantToken string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/directory/v1/groups/dev@example.com/members", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer "+wantToken, r.Header.Get("Authorization"))
+		require.Equal(t, "true", r.URL.Query().Get("includeDerivedMembership"))
+		if r.URL.Query().Get("pageToken") == "" {
+			_, _ = w.Write([]byte(`{"members":[
+				{"email":"alice@example.com","type":"USER","status":"ACTIVE"},
+				{"email":"nested@example.com","type":"GROUP","status":"ACTIVE"},
+				{"email":"suspended@example.com","type":"USER","status":"SUSPENDED"}],
+				"nextPageToken":"page2"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"members":[{"email":"bob@example.com","type":"USER","status":"ACTIVE"}]}`))
+	})
+	return mux
+}
+
+func TestGoogleSyncDomainWideDelegation(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+
+	mux := mockDirectory(t, "dwd-token")
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		require.Equal(t, googleJwtBearerType, r.PostForm.Get("grant_type"))
+		assertion, err := jwt.Parse([]byte(r.PostForm.Get("assertion")), jwt.WithKey(jwa.RS256, &rsaKey.PublicKey))
+		require.NoError(t, err)
+		require.Equal(t, "sync@project.iam.gserviceaccount.com", assertion.Issuer())
+		require.Equal(t, "admin@example.com", assertion.Subject())
+		require.Equal(t, []string{server.URL + "/token"}, assertion.Audience())
+		_, _ = w.Write([]byte(`{"access_token":"dwd-token"}`))
+	})
+
+	keyJson, err := json.Marshal(googleServiceAccountKey{
+		Type:         "service_account",
+		ClientEmail:  "sync@project.iam.gserviceaccount.com",
+		PrivateKeyID: "key-id",
+		PrivateKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		TokenURI:     server.URL + "/token",
+	})
+	require.NoError(t, err)
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/google-sa.json", keyJson, 0600))
+
+	googleSync := &GoogleSyncCmd{
+		Config: config.GoogleWorkspaceConfig{
+			CredentialsFile: "/etc/opk/google-sa.json",
+			Subject:         "admin@example.com",
+			AdminURL:        server.URL,
+			Groups:          []config.GroupSyncMapping{{Group: "dev@example.com", Principals: []string{"dev"}}},
+		},
+		Fragments:  &policy.FragmentStore{Fs: mockFs, Dir: policy.SystemDefaultFragmentDir},
+		Journal:    &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath},
+		Fs:         mockFs,
+		HttpClient: server.Client(),
+	}
+	added, removed, err := googleSync.Run(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"dev alice@example.com https://accounts.google.com",
+		"dev bob@example.com https://accounts.google.com",
+	}, added)
+	require.Empty(t, removed)
+
+	// No change, no rewrite
+	added, removed, err = googleSync.Run(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, added)
+	require.Empty(t, removed)
+	entries, err := googleSync.Journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestGoogleSyncMetadataServer(t *testing.T) {
+	t.Parallel()
+
+	mux := mockDirectory(t, "metadata-token")
+	mux.HandleFunc("GET /computeMetadata/v1/instance/service-accounts/default/token", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		require.Equal(t, googleMemberScope, r.URL.Query().Get("scopes"))
+		_, _ = w.Write([]byte(`{"access_token":"metadata-token"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mockFs := afero.NewMemMapFs()
+	googleSync := &GoogleSyncCmd{
+		Config: config.GoogleWorkspaceConfig{
+			AdminURL:    server.URL,
+			MetadataURL: server.URL,
+			Groups:      []config.GroupSyncMapping{{Group: "dev@example.com", Principals: []string{"dev"}}},
+		},
+		Fragments:  &policy.FragmentStore{Fs: mockFs, Dir: policy.SystemDefaultFragmentDir},
+		Fs:         mockFs,
+		HttpClient: server.Client(),
+	}
+	added, _, err := googleSync.Run(context.Background())
+	require.NoError(t, err)
+	require.Len(t, added, 2)
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/spf13/afero"
+)
+
+// StoredToken is what login keeps about the most recent login
+type StoredToken struct {
+	Issuer string `json:"issuer"`
+	// PKToken is the compact PK Token
+	PKToken     string `json:"pk_token"`
+	AccessToken string `json:"access_token,omitempty"`
+	KeyPath     string `json:"key_path,omitempty"`
+	// ExpiresAt is when the ID Token expires
+	ExpiresAt time.Time `json:"expires_at"`
+	// ValidBefore is when the SSH cert expires if login --validity was set
+	ValidBefore time.Time `json:"valid_before,omitzero"`
+}
+
+// NewStoredToken creates the StoredToken for a login with pkt whose SSH key
+// was written to keyPath
+func NewStoredToken(pkt *pktoken.PKToken, accessToken []byte, keyPath string) (*StoredToken, error) {
+	compact, err := pkt.Compact()
+	if err != nil {
+		return nil, err
+	}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return nil, err
+	}
+	claims := idt.GetClaims()
+	return &StoredToken{
+		Issuer:      claims.Issuer,
+		PKToken:     string(compact),
+		AccessToken: string(accessToken),
+		KeyPath:     keyPath,
+		ExpiresAt:   time.Unix(claims.Expiration, 0),
+	}, nil
+}
+
+// TokenStore keeps the StoredToken of the most recent login. Load returns
+// nil, nil if nothing is stored.
+type TokenStore interface {
+	Save(token *StoredToken) error
+	Load() (*StoredToken, error)
+	Delete() error
+}
+
+// FileTokenStore keeps the token in a file readable only by the user
+type FileTokenStore struct {
+	Fs   afero.Fs
+	Path string
+}
+
+// NewFileTokenStore returns a FileTokenStore at token.json in the client
+// config directory
+func NewFileTokenStore(fs afero.Fs) (*FileTokenStore, error) {
+	dir, err := config.DefaultClientConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{Fs: fs, Path: filepath.Join(dir, "token.json")}, nil
+}
+
+func (s *FileTokenStore) Save(token *StoredToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := s.Fs.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return err
+	}
+	if err := afero.WriteFile(s.Fs, s.Path, data, 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return s.Fs.Chmod(s.Path, 0o600)
+}
+
+func (s *FileTokenStore) Load() (*StoredToken, error) {
+	data, err := afero.ReadFile(s.Fs, s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var token StoredToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse stored token %s: %w", s.Path, err)
+	}
+	return &token, nil
+}
+
+func (s *FileTokenStore) Delete() error {
+	if err := s.Fs.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+// +build darwin
+
+package commands
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// keychainService is the service name of the Keychain item opkssh stores the
+// token in
+const keychainService = "opkssh"
+
+// keychainAccount is the account name of the Keychain item
+const keychainAccount = "default"
+
+// KeychainTokenStore keeps the token in the user's login Keychain through
+// /usr/bin/security
+type KeychainTokenStore struct {
+	// CmdRunner runs security with the given stdin, defaults to exec
+	CmdRunner func(stdin []byte, args ...string) ([]byte, error)
+}
+
+// NewTokenStore returns the token store of the platform, the macOS Keychain
+func NewTokenStore(fs afero.Fs) (TokenStore, error) {
+	return &KeychainTokenStore{}, nil
+}
+
+func (k *KeychainTokenStore) run(stdin []byte, args ...string) ([]byte, error) {
+	if k.CmdRunner != nil {
+		return k.CmdRunner(stdin, args...)
+	}
+	cmd := exec.Command("/usr/bin/security", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	return cmd.Output()
+}
+
+func (k *KeychainTokenStore) Save(token *StoredToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	// Pass the command on stdin in interactive mode so that the token is not
+	// visible in the process list
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -X %s\n", keychainService, keychainAccount, hex.EncodeToString(data))
+	if out, err := k.run([]byte(command), "-i"); err != nil {
+		return fmt.Errorf("failed to add token to Keychain: %w: %s", err, out)
+	}
+	return nil
+}
+
+func (k *KeychainTokenStore) Load() (*StoredToken, error) {
+	out, err := k.run(nil, "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+			// errSecItemNotFound
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read token from Keychain: %w", err)
+	}
+	data := []byte(strings.TrimSpace(string(out)))
+	// security prints data that is not valid UTF-8 as hex
+	if !json.Valid(data) {
+		if data, err = hex.DecodeString(string(data)); err != nil {
+			return nil, fmt.Errorf("failed to decode token from Keychain: %w", err)
+		}
+	}
+	var token StoredToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to parse token from Keychain: %w", err)
+	}
+	return &token, nil
+}
+
+func (k *KeychainTokenStore) Delete() error {
+	if _, err := k.run(nil, "delete-generic-password", "-s", keychainService, "-a", keychainAccount); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 44 {
+			return nil
+		}
+		return fmt.Errorf("failed to delete token from Keychain: %w", err)
+	}
+	return nil
+}
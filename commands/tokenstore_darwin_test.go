@@ -0,0 +1,52 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+// +build darwin
+
+package commands
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeychainTokenStore(t *testing.T) {
+	var saved []byte
+	store := &KeychainTokenStore{CmdRunner: func(stdin []byte, args ...string) ([]byte, error) {
+		switch args[0] {
+		case "-i":
+			fields := strings.Fields(string(stdin))
+			require.Equal(t, "add-generic-password", fields[0])
+			data, err := hex.DecodeString(fields[len(fields)-1])
+			require.NoError(t, err)
+			saved = data
+			return nil, nil
+		case "find-generic-password":
+			return append(saved, '\n'), nil
+		}
+		return nil, nil
+	}}
+
+	require.NoError(t, store.Save(&StoredToken{Issuer: "https://accounts.google.com", PKToken: "a.b.c"}))
+	token, err := store.Load()
+	require.NoError(t, err)
+	require.Equal(t, "https://accounts.google.com", token.Issuer)
+	require.Equal(t, "a.b.c", token.PKToken)
+}
@@ -0,0 +1,56 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	pkt, _, mockOp := Mocks(t, ECDSA)
+	store := &FileTokenStore{Fs: afero.NewMemMapFs(), Path: "/home/alice/.opk/token.json"}
+
+	token, err := store.Load()
+	require.NoError(t, err)
+	require.Nil(t, token)
+
+	stored, err := NewStoredToken(pkt, []byte("access-token"), "/home/alice/.ssh/id_ecdsa")
+	require.NoError(t, err)
+	require.Equal(t, mockOp.Issuer(), stored.Issuer)
+	require.False(t, stored.ExpiresAt.IsZero())
+	require.NoError(t, store.Save(stored))
+
+	fi, err := store.Fs.Stat(store.Path)
+	require.NoError(t, err)
+	require.Equal(t, "-rw-------", fi.Mode().Perm().String())
+
+	token, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, stored.PKToken, token.PKToken)
+	require.Equal(t, "access-token", token.AccessToken)
+	require.Equal(t, "/home/alice/.ssh/id_ecdsa", token.KeyPath)
+	require.True(t, stored.ExpiresAt.Equal(token.ExpiresAt))
+
+	require.NoError(t, store.Delete())
+	require.NoError(t, store.Delete())
+	token, err = store.Load()
+	require.NoError(t, err)
+	require.Nil(t, token)
+}
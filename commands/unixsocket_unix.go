@@ -0,0 +1,40 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+// +build !windows
+
+package commands
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// umaskMu serializes the umask changes of listenUnixSocket, as the umask is
+// shared by the whole process
+var umaskMu sync.Mutex
+
+// listenUnixSocket listens at the unix socket path, which is created with
+// mode 0600 so that no other user can connect before it is secured
+func listenUnixSocket(path string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/sshcert"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// UserInitCmd bootstraps the home policy file (~/.opk/auth_id) of a user so
+// that the user can grant their own identity access to their own account.
+type UserInitCmd struct {
+	HomePolicyLoader *policy.HomePolicyLoader
+	// Username is the local account whose home policy is created
+	Username string
+}
+
+// NewUserInitCmd creates a new UserInitCmd instance for username
+func NewUserInitCmd(rt *Runtime, username string) *UserInitCmd {
+	loader := policy.NewHomePolicyLoader()
+	loader.FileLoader.Fs = rt.Fs
+	loader.UserLookup = rt.UserLookup
+	return &UserInitCmd{
+		HomePolicyLoader: loader,
+		Username:         username,
+	}
+}
+
+// DefaultCertPaths returns the paths of the SSH certificates written by
+// `opkssh login` in the user's home directory in the order they are checked
+func (u *UserInitCmd) DefaultCertPaths(homeDir string) []string {
+	paths := []string{}
+	for _, keyType := range []KeyType{ECDSA, ED25519} {
+		for _, name := range DefaultSSHKeyFileNames[keyType] {
+			paths = append(paths, filepath.Join(homeDir, ".ssh", name+"-cert.pub"))
+		}
+	}
+	return paths
+}
+
+// IdentityFromCert reads the identity (email, or sub if email is missing) and
+// issuer from the PK token in the opkssh SSH certificate at certPath
+func (u *UserInitCmd) IdentityFromCert(certPath string) (string, string, error) {
+	afs := &afero.Afero{Fs: u.HomePolicyLoader.FileLoader.Fs}
+	certBytes, err := afs.ReadFile(certPath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read SSH certificate %s: %w", certPath, err)
+	}
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse SSH certificate %s: %w", certPath, err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return "", "", fmt.Errorf("%s is not an SSH certificate", certPath)
+	}
+	pkt, err := (&sshcert.SshCertSmuggler{SshCert: cert}).GetPKToken()
+	if err != nil {
+		return "", "", err
+	}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return "", "", err
+	}
+	claims := idt.GetClaims()
+	if claims.Email != "" {
+		return claims.Email, claims.Issuer, nil
+	}
+	return claims.Subject, claims.Issuer, nil
+}
+
+// Run creates ~/.opk/auth_id with the correct permissions if it does not
+// exist, adds an entry granting identity from issuer access to Username and
+// then loads the file using the same checks the server applies during
+// `opkssh verify`. Returns the path of the home policy file.
+func (u *UserInitCmd) Run(identity string, issuer string) (string, error) {
+	if identity == "" || issuer == "" {
+		return "", fmt.Errorf("both an identity and an issuer are required")
+	}
+
+	policyPath, err := u.HomePolicyLoader.UserPolicyPath(u.Username)
+	if err != nil {
+		return "", err
+	}
+
+	if err := u.HomePolicyLoader.CreateIfDoesNotExist(policyPath); err != nil {
+		return "", fmt.Errorf("failed to create home policy file: %w", err)
+	}
+	lock, err := u.HomePolicyLoader.FileLoader.Lock(policyPath)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+	// A file created by hand often has the wrong permissions, correct them
+	// before reading it so the user does not get a cryptic denial later.
+	if err := u.HomePolicyLoader.FileLoader.Fs.Chmod(policyPath, u.HomePolicyLoader.FileLoader.RequiredPerm); err != nil {
+		return "", fmt.Errorf("failed to set permissions on %s: %w", policyPath, err)
+	}
+
+	currentPolicy, _, err := u.HomePolicyLoader.LoadHomePolicy(u.Username, false)
+	if err != nil {
+		return "", err
+	}
+	currentPolicy.AddAllowedPrincipal(u.Username, identity, issuer)
+	if err := u.HomePolicyLoader.Dump(currentPolicy, policyPath); err != nil {
+		return "", err
+	}
+
+	return policyPath, u.Verify(identity, issuer)
+}
+
+// Verify loads the home policy the same way the server does and checks that
+// it grants identity from issuer access to Username
+func (u *UserInitCmd) Verify(identity string, issuer string) error {
+	homePolicy, policyPath, err := u.HomePolicyLoader.LoadHomePolicy(u.Username, true)
+	if err != nil {
+		return fmt.Errorf("home policy would be rejected by the server: %w", err)
+	}
+	for _, user := range homePolicy.Users {
+		if strings.EqualFold(user.IdentityAttribute, identity) &&
+			user.Issuer == issuer &&
+			slices.Contains(user.Principals, u.Username) {
+			return nil
+		}
+	}
+	return fmt.Errorf("home policy %s does not grant %s (%s) access to %s", policyPath, identity, issuer, u.Username)
+}
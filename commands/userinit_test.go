@@ -0,0 +1,139 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/sshcert"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func MockUserInitCmd(mockFs afero.Fs) *UserInitCmd {
+	return &UserInitCmd{
+		HomePolicyLoader: MockAddCmd(mockFs).HomePolicyLoader,
+		Username:         ValidUser.Username,
+	}
+}
+
+func TestUserInit(t *testing.T) {
+	t.Parallel()
+
+	mockFs := afero.NewMemMapFs()
+	initCmd := MockUserInitCmd(mockFs)
+
+	policyPath, err := initCmd.Run("alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(ValidUser.HomeDir, ".opk", "auth_id"), policyPath)
+
+	info, err := mockFs.Stat(policyPath)
+	require.NoError(t, err)
+	require.Equal(t, files.ModeHomePerms, info.Mode().Perm())
+
+	content, err := afero.ReadFile(mockFs, policyPath)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "foo alice@example.com https://accounts.google.com")
+
+	// Running again with another identity keeps the existing entry
+	_, err = initCmd.Run("bob@example.com", "https://gitlab.com")
+	require.NoError(t, err)
+	content, err = afero.ReadFile(mockFs, policyPath)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "alice@example.com")
+	require.Contains(t, string(content), "bob@example.com")
+}
+
+func TestUserInitFixesExistingPermissions(t *testing.T) {
+	t.Parallel()
+
+	mockFs := afero.NewMemMapFs()
+	policyPath := filepath.Join(ValidUser.HomeDir, ".opk", "auth_id")
+	require.NoError(t, afero.WriteFile(mockFs, policyPath, []byte("foo carol@example.com https://gitlab.com\n"), 0644))
+
+	initCmd := MockUserInitCmd(mockFs)
+	_, err := initCmd.Run("alice@example.com", "https://accounts.google.com")
+	require.NoError(t, err)
+
+	info, err := mockFs.Stat(policyPath)
+	require.NoError(t, err)
+	require.Equal(t, files.ModeHomePerms, info.Mode().Perm())
+	require.NoError(t, initCmd.Verify("carol@example.com", "https://gitlab.com"))
+}
+
+func TestUserInitErrors(t *testing.T) {
+	t.Parallel()
+
+	initCmd := MockUserInitCmd(afero.NewMemMapFs())
+	_, err := initCmd.Run("", "https://accounts.google.com")
+	require.ErrorContains(t, err, "both an identity and an issuer are required")
+
+	err = initCmd.Verify("alice@example.com", "https://accounts.google.com")
+	require.ErrorContains(t, err, "home policy would be rejected by the server")
+}
+
+func TestUserInitIdentityFromCert(t *testing.T) {
+	t.Parallel()
+
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+
+	providerOpts := providers.DefaultMockProviderOpts()
+	providerOpts.Issuer = "https://accounts.google.com"
+	op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{
+		"email": "arthur.aardvark@example.com",
+	}
+
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	cert, err := sshcert.New(pkt, nil, []string{})
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoECDSA256})
+	require.NoError(t, err)
+	sshCert, err := cert.SignCert(signerMas)
+	require.NoError(t, err)
+
+	mockFs := afero.NewMemMapFs()
+	initCmd := MockUserInitCmd(mockFs)
+	certPath := initCmd.DefaultCertPaths(ValidUser.HomeDir)[0]
+	require.Equal(t, filepath.Join(ValidUser.HomeDir, ".ssh", "id_ecdsa-cert.pub"), certPath)
+	require.NoError(t, afero.WriteFile(mockFs, certPath, ssh.MarshalAuthorizedKey(sshCert), 0644))
+
+	identity, issuer, err := initCmd.IdentityFromCert(certPath)
+	require.NoError(t, err)
+	require.Equal(t, "arthur.aardvark@example.com", identity)
+	require.Equal(t, "https://accounts.google.com", issuer)
+
+	_, _, err = initCmd.IdentityFromCert(filepath.Join(ValidUser.HomeDir, ".ssh", "missing-cert.pub"))
+	require.ErrorContains(t, err, "failed to read SSH certificate")
+}
@@ -20,13 +20,22 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/openpubkey/openpubkey/oidc"
 	"github.com/openpubkey/openpubkey/pktoken"
 	"github.com/openpubkey/openpubkey/verifier"
 	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/audit"
+	"github.com/openpubkey/opkssh/internal/events"
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy/ldap"
 	"github.com/openpubkey/opkssh/sshcert"
 	"github.com/spf13/afero"
 	"golang.org/x/crypto/ssh"
@@ -56,11 +65,63 @@ type VerifyCmd struct {
 	HttpClient *http.Client
 	// denyList is populated from ServerConfig after successful parsing
 	denyList policy.DenyList
+	// ServerConfig is the parsed server config file, nil until
+	// ReadFromServerConfig succeeds
+	ServerConfig *config.ServerConfig
+	// Provisioner, if set, creates the requested local account when policy
+	// authorizes the login but the account does not exist
+	Provisioner *Provisioner
+	// ProviderPolicy is used to determine when the PK Token expires under the
+	// expiration policy of its provider
+	ProviderPolicy *policy.ProviderPolicy
+	// ExpiryWarning is the window before expiry in which logins emit an
+	// expiring audit event, zero disables it
+	ExpiryWarning time.Duration
+	// Revocations, if set, lists identities that are denied even though
+	// their PK Token has not expired yet
+	Revocations *policy.RevocationList
+	// Proxy, if set, restricts principals to connections through a trusted
+	// SSH proxy or bastion
+	Proxy *ProxyPolicy
+	// RateLimiter, if set, refuses the logins of clients that failed too
+	// many times, before the PK Token is checked
+	RateLimiter *policy.RateLimiter
+	// Issuers refuses the PK Tokens of issuers the server config doesn't
+	// allow, before they are verified
+	Issuers IssuerFilter
+	// Replay, if set, refuses PK Tokens already used on other hosts
+	Replay *policy.ReplayGuard
+	// ReplayMemory is the store of the memory replay_cache backend, set by
+	// opkssh serve
+	ReplayMemory *policy.MemoryReplayStore
+	// ConnectionArg is sshd's %C token, the client and server address and
+	// port of the connection being authorized
+	ConnectionArg string
+	// SshConnection is the SSH_CONNECTION environment variable, used for
+	// the client address in the audit log when ConnectionArg is not set
+	SshConnection string
+	// Audit, if set, records every decision
+	Audit *audit.Logger
+	// DenyReasonFile, if set, is where the reason of a denied login is
+	// written for the user, %u is replaced by the principal
+	DenyReasonFile string
+	// OnDecision, if set, is called with the record of every decision and
+	// how long it took to make
+	OnDecision func(record audit.Record, elapsed time.Duration)
+	// Trace, if set, is called with each step of the verification, see
+	// Explain
+	Trace func(format string, args ...any)
+	// explain skips what a login records, set by Explain
+	explain bool
+	// match is what allowed the login, reported by the policy enforcer
+	match *policy.Match
+	// rateLimited is set when the login failed in a way RateLimiter counts:
+	// the PK Token failed to verify or the login was denied by the policy
+	rateLimited bool
 }
 
 // NewVerifyCmd creates a new VerifyCmd instance with the provided arguments.
-func NewVerifyCmd(pktVerifier verifier.Verifier, checkPolicy PolicyEnforcerFunc, configPathArg string) *VerifyCmd {
-	fs := afero.NewOsFs()
+func NewVerifyCmd(fs afero.Fs, pktVerifier verifier.Verifier, checkPolicy PolicyEnforcerFunc, configPathArg string) *VerifyCmd {
 	return &VerifyCmd{
 		Fs:            fs,
 		PktVerifier:   pktVerifier,
@@ -70,6 +131,8 @@ func NewVerifyCmd(pktVerifier verifier.Verifier, checkPolicy PolicyEnforcerFunc,
 			Fs:        fs,
 			CmdRunner: files.ExecCmd,
 		},
+		Revocations:   &policy.RevocationList{Fs: fs, Path: policy.SystemDefaultRevocationPath},
+		SshConnection: os.Getenv("SSH_CONNECTION"),
 	}
 }
 
@@ -101,32 +164,198 @@ func NewVerifyCmd(pktVerifier verifier.Verifier, checkPolicy PolicyEnforcerFunc,
 // output when using sshd's AuthorizedKeysCommand feature). Otherwise, a non-nil
 // error is returned.
 func (v *VerifyCmd) AuthorizedKeysCommand(ctx context.Context, userArg string, typArg string, certB64Arg string, extraArgs []string) (string, error) {
+	start := time.Now()
+	v.match, v.rateLimited = nil, false
+	record := audit.Record{Principal: userArg}
+	record.ClientIP, record.ClientPort = audit.ClientAddress(v.SshConnection)
+	if v.ConnectionArg != "" {
+		record.ClientIP, record.ClientPort = audit.ClientAddress(v.ConnectionArg)
+	}
+
+	// The address of the connection, the proxy's for proxied connections
+	clientIP := record.ClientIP
+	var authKey string
+	var err error
+	v.trace("Verifying the login as %s from %q", userArg, clientIP)
+	if v.RateLimiter != nil {
+		err = v.RateLimiter.Check(clientIP, userArg)
+	}
+	if err == nil {
+		authKey, err = v.authorizedKeysCommand(ctx, &record, userArg, typArg, certB64Arg, extraArgs)
+		// Keys that aren't opkssh certificates, such as the other keys of
+		// an SSH client, fail without counting
+		if v.RateLimiter != nil && !v.explain && (err == nil || v.rateLimited) {
+			v.RateLimiter.Record(clientIP, userArg, err != nil)
+		}
+	}
+	var reason DenyReason
+	if err != nil {
+		reason = NewDenyReason(userArg, err)
+		slog.Info("Denied login", "user", userArg, "code", reason.Code, "reason", reason.Message)
+		v.trace("Denied (%s): %v", reason.Code, err)
+	} else if v.match != nil && v.match.Plugin != "" {
+		v.trace("Allowed by the policy plugin %s", v.match.Plugin)
+	} else if v.match != nil {
+		v.trace("Allowed by %q in %s", v.match.Entry, v.match.Source)
+	} else {
+		v.trace("Allowed")
+	}
+	if v.explain {
+		return authKey, err
+	}
+	if v.DenyReasonFile != "" {
+		if err != nil {
+			writeDenyReason(v.Fs, v.DenyReasonFile, userArg, reason)
+		} else {
+			clearDenyReason(v.Fs, v.DenyReasonFile, userArg)
+		}
+	}
+	record.Decision = audit.Allow
+	if err != nil {
+		record.Decision = audit.Deny
+		record.Reason = err.Error()
+		record.ReasonCode = reason.Code
+	} else if v.match != nil {
+		record.Policy = v.match.Entry
+		record.PolicySource = v.match.Source
+		record.Plugin = v.match.Plugin
+	}
+	if v.Audit != nil {
+		// The decision has been made, failing to record it must not change it
+		if auditErr := v.Audit.Log(record); auditErr != nil {
+			slog.Warn("Failed to write the audit record", "error", auditErr)
+		}
+	}
+	if v.OnDecision != nil {
+		v.OnDecision(record, time.Since(start))
+	}
+	return authKey, err
+}
+
+// RecordMatch is passed to the policy enforcer to report what allowed the
+// login for the audit log
+func (v *VerifyCmd) RecordMatch(m policy.Match) {
+	v.match = &m
+}
+
+func (v *VerifyCmd) authorizedKeysCommand(ctx context.Context, record *audit.Record, userArg string, typArg string, certB64Arg string, extraArgs []string) (string, error) {
 	// Parse the b64 pubkey and expect it to be an ssh certificate
 	cert, err := sshcert.NewFromAuthorizedKey(typArg, certB64Arg)
 	if err != nil {
+		return "", deny(DenyCodeInvalidToken, err)
+	}
+	v.trace("Parsed the %s SSH certificate", typArg)
+	if err := v.checkIssuer(cert); err != nil {
+		// A PK Token from an issuer the server refuses is a policy denial
+		v.rateLimited = NewDenyReason(userArg, err).Code == DenyCodeIssuer
 		return "", err
 	}
 
 	if pkt, err := cert.VerifySshPktCert(ctx, v.PktVerifier); err != nil { // Verify the PKT contained in the cert
-		return "", err
+		v.rateLimited = true
+		return "", deny(pktDenyCode(err), err)
 	} else {
+		if idt, err := oidc.NewJwt(pkt.OpToken); err == nil {
+			claims := idt.GetClaims()
+			record.Issuer, record.Subject, record.Email = claims.Issuer, claims.Subject, claims.Email
+		}
+		v.traceToken(pkt)
+
 		userInfo := ""
 		if accessToken := cert.GetAccessToken(); accessToken != "" {
 			if userInfoRet, err := v.UserInfoLookup(ctx, pkt, accessToken); err == nil {
 				// userInfo is optional so we should not fail if we can't access it
 				userInfo = userInfoRet
+				v.trace("Fetched the userinfo claims: %s", userInfo)
+			} else {
+				v.trace("Failed to fetch the userinfo claims, checking the ID Token only: %v", err)
 			}
 		}
 
-		if err := v.CheckPolicy(userArg, pkt, userInfo, certB64Arg, typArg, v.denyList, extraArgs); err != nil {
+		var source *ConnectionSource
+		if v.Proxy != nil && v.ConnectionArg != "" {
+			src, err := v.Proxy.Source(v.ConnectionArg)
+			if err != nil {
+				return "", err
+			}
+			source = &src
+			record.ClientIP, record.ClientPort = src.Address.String(), src.Port
+			v.trace("The connection is from %s, proxy %q", src.Address, src.Proxy)
+		}
+
+		denyList := v.denyList
+		if v.Revocations != nil {
+			// Fail closed, an unreadable list could be hiding a revocation
+			if denyList.Revoked, err = v.Revocations.Entries(); err != nil {
+				return "", fmt.Errorf("failed to read revocation list: %w", err)
+			}
+		}
+
+		err := v.CheckPolicy(userArg, pkt, userInfo, certB64Arg, typArg, denyList, extraArgs)
+		if err == nil && v.Proxy != nil {
+			if err = v.Proxy.Check(userArg, source); err != nil {
+				err = deny(DenyCodeProxy, err)
+			}
+		}
+		// Only allowed logins are recorded, a denied one must not claim the
+		// token for this host
+		if err == nil && v.Replay != nil {
+			if v.explain {
+				v.trace("Not checking the replay cache, it would record the token")
+			} else {
+				err = v.checkReplay(pkt, record.ClientIP)
+			}
+		}
+		if err != nil {
+			v.rateLimited = true
+			// The PK Token is valid so this is a known identity being denied
+			fields := identityFields(pkt)
+			fields["user"] = userArg
+			fields["reason"] = err.Error()
+			fields["deny_code"] = NewDenyReason(userArg, err).Code
+			if source != nil {
+				fields["source"] = source.Address.String()
+				if source.Proxy != "" {
+					fields["proxy"] = source.Proxy
+				}
+			}
+			if !v.explain {
+				events.Emit(events.AccessDenied, fields)
+			}
 			return "", err
-		} else { // Success!
-			// sshd expects the public key in the cert, not the cert itself. This
-			// public key is key of the CA that signs the cert, in our setting there
-			// is no CA.
-			pubkeyBytes := ssh.MarshalAuthorizedKey(cert.SshCert.SignatureKey)
-			return "cert-authority " + string(pubkeyBytes), nil
 		}
+
+		// Only create the account once policy has authorized the login
+		if v.Provisioner != nil && v.explain {
+			v.trace("Not provisioning the account %s", userArg)
+		} else if v.Provisioner != nil {
+			if err := v.Provisioner.EnsureUser(userArg, pkt); err != nil {
+				return "", err
+			}
+		}
+
+		if !v.explain {
+			v.warnIfExpiring(userArg, pkt)
+			if v.match != nil && v.match.CatchAll {
+				fields := identityFields(pkt)
+				fields["user"] = userArg
+				fields["entry"] = v.match.Entry
+				fields["source"] = v.match.Source
+				events.Emit(events.BreakGlassUsed, fields)
+			}
+		}
+
+		// Success!
+		// sshd expects the public key in the cert, not the cert itself. This
+		// public key is key of the CA that signs the cert, in our setting there
+		// is no CA.
+		pubkeyBytes := ssh.MarshalAuthorizedKey(cert.SshCert.SignatureKey)
+		options := "cert-authority"
+		if v.match != nil && len(v.match.Options) > 0 {
+			// The restrictions of the policy plugins that allowed the login
+			options += "," + strings.Join(v.match.Options, ",")
+		}
+		return options + " " + string(pubkeyBytes), nil
 	}
 }
 
@@ -142,7 +371,7 @@ func (v *VerifyCmd) ReadFromServerConfig() error {
 		return fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	err = v.filePermChecker.CheckPerm(v.ConfigPathArg, []fs.FileMode{0640}, "root", "opksshuser")
+	err = v.filePermChecker.CheckPerm(v.ConfigPathArg, []fs.FileMode{0640}, policy.Defaults.Owner, policy.Defaults.Group)
 	if err != nil {
 		return err
 	}
@@ -151,11 +380,161 @@ func (v *VerifyCmd) ReadFromServerConfig() error {
 	if err != nil {
 		return fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := ConfigureNotifications(events.Default(), serverConfig.Notifications); err != nil {
+		slog.Warn("Ignoring invalid notifications in the config file", "error", err)
+	}
+	if err := ConfigureLogging(serverConfig.Logging); err != nil {
+		slog.Warn("Ignoring invalid logging in the config file", "error", err)
+	}
+	v.ApplyServerConfig(serverConfig)
+	return serverConfig.SetEnvVars()
+}
+
+// ApplyServerConfig sets the settings in serverConfig that apply to this
+// verification. The environment variables and notifications are process
+// wide and are set by ReadFromServerConfig.
+func (v *VerifyCmd) ApplyServerConfig(serverConfig *config.ServerConfig) {
+	var err error
+	v.ServerConfig = serverConfig
+	if serverConfig.ExpiryWarning != "" {
+		// A bad value should not prevent the rest of the config being applied
+		if v.ExpiryWarning, err = time.ParseDuration(serverConfig.ExpiryWarning); err != nil {
+			slog.Warn("Ignoring invalid expiry_warning in the config file", "error", err)
+		}
+	}
+	if serverConfig.Provision.Enabled {
+		v.Provisioner = NewProvisioner(serverConfig.Provision)
+	}
+	if len(serverConfig.Proxy.Trusted) > 0 || len(serverConfig.Proxy.RequireFor) > 0 {
+		if v.Proxy, err = NewProxyPolicy(serverConfig.Proxy); err != nil {
+			// Fail closed, no proxy is trusted for the restricted principals
+			slog.Warn("Ignoring invalid trusted proxies in the config file", "error", err)
+			v.Proxy = &ProxyPolicy{requireFor: serverConfig.Proxy.RequireFor}
+		}
+	}
+	if serverConfig.Audit.Destination != "" {
+		if v.Audit, err = audit.Open(serverConfig.Audit.Destination, serverConfig.Audit.Path); err != nil {
+			slog.Warn("Audit log disabled", "error", err)
+		}
+	}
+	if serverConfig.JWKSCache.MaxStale != "" && v.ProviderPolicy != nil && v.ProviderPolicy.JWKSCache != nil {
+		if maxStale, err := time.ParseDuration(serverConfig.JWKSCache.MaxStale); err != nil || maxStale < 0 {
+			slog.Warn("Ignoring invalid jwks_cache max_stale in the config file", "max_stale", serverConfig.JWKSCache.MaxStale)
+		} else {
+			v.ProviderPolicy.JWKSCache.MaxStale = maxStale
+		}
+	}
+	v.Issuers = NewIssuerFilter(serverConfig.Issuers)
+	if serverConfig.RateLimit.MaxFailures > 0 {
+		v.RateLimiter = policy.NewRateLimiter(serverConfig.RateLimit.MaxFailures,
+			rateLimitDuration("window", serverConfig.RateLimit.Window),
+			rateLimitDuration("block", serverConfig.RateLimit.Block))
+		v.RateLimiter.Report = serverConfig.RateLimit.Report
+	}
+	if serverConfig.ReplayCache.Backend != "" {
+		if v.Replay, err = NewReplayGuard(serverConfig.ReplayCache, v.ReplayMemory); err != nil {
+			// Fail closed, logins are refused until the config is fixed
+			slog.Error("Invalid replay_cache in the config file, refusing logins", "error", err)
+			v.Replay = &policy.ReplayGuard{Store: replayStoreError{err: err}}
+		}
+	}
+	v.DenyReasonFile = serverConfig.DenyReasons.File
 	v.denyList = policy.DenyList{
 		Emails: serverConfig.DenyEmails,
 		Users:  serverConfig.DenyUsers,
 	}
-	return serverConfig.SetEnvVars()
+}
+
+// rateLimitDuration parses the duration name of the rate_limit config,
+// zero for the default
+func rateLimitDuration(name string, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Ignoring invalid rate_limit setting in the config file", "setting", name, "value", value)
+		return 0
+	}
+	return d
+}
+
+// warnIfExpiring logs an audit event if pkt, or the policy entry that
+// allowed the login, will stop being accepted within ExpiryWarning. The
+// login is still allowed.
+func (v *VerifyCmd) warnIfExpiring(userArg string, pkt *pktoken.PKToken) {
+	if v.ExpiryWarning <= 0 {
+		return
+	}
+	if v.ProviderPolicy != nil {
+		if idt, err := oidc.NewJwt(pkt.OpToken); err == nil {
+			claims := idt.GetClaims()
+			if expiresAt, ok := v.ProviderPolicy.ExpiresAt(claims.Issuer, claims.Audience, time.Unix(claims.IssuedAt, 0), time.Unix(claims.Expiration, 0)); ok {
+				v.emitExpiring(userArg, pkt, expiresAt, nil)
+			}
+		}
+	}
+	if v.match != nil && !v.match.Expires.IsZero() {
+		v.emitExpiring(userArg, pkt, v.match.Expires, map[string]string{
+			"entry":  v.match.Entry,
+			"source": v.match.Source,
+		})
+	}
+}
+
+// emitExpiring emits a certificate_expiring event with extra fields if
+// expiresAt is within ExpiryWarning
+func (v *VerifyCmd) emitExpiring(userArg string, pkt *pktoken.PKToken, expiresAt time.Time, extra map[string]string) {
+	remaining := time.Until(expiresAt)
+	if remaining >= v.ExpiryWarning {
+		return
+	}
+	fields := identityFields(pkt)
+	fields["user"] = userArg
+	fields["expires_at"] = expiresAt.UTC().Format(time.RFC3339)
+	fields["remaining"] = remaining.Round(time.Second).String()
+	maps.Copy(fields, extra)
+	events.Emit(events.CertificateExpiring, fields)
+}
+
+// identityFields returns the identity (email, or sub if email is missing) and
+// issuer of pkt as event fields
+func identityFields(pkt *pktoken.PKToken) map[string]string {
+	fields := map[string]string{}
+	idt, err := oidc.NewJwt(pkt.OpToken)
+	if err != nil {
+		return fields
+	}
+	claims := idt.GetClaims()
+	fields["identity"] = claims.Email
+	if claims.Email == "" {
+		fields["identity"] = claims.Subject
+	}
+	fields["issuer"] = claims.Issuer
+	return fields
+}
+
+// LoadServerConfig reads and parses the server config at path for commands
+// other than verify. The file must be owned by root and have mode 0640.
+// Returns nil and no error if the file does not exist.
+func LoadServerConfig(fsys afero.Fs, path string) (*config.ServerConfig, error) {
+	if exists, err := afero.Exists(fsys, path); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+	if err := files.NewPermsChecker(fsys).CheckPerm(path, []fs.FileMode{0640}, "root", ""); err != nil {
+		return nil, err
+	}
+	configBytes, err := afero.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	serverConfig, err := config.NewServerConfig(configBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return serverConfig, nil
 }
 
 func (v *VerifyCmd) UserInfoLookup(ctx context.Context, pkt *pktoken.PKToken, accessToken string) (string, error) {
@@ -168,10 +547,88 @@ func (v *VerifyCmd) UserInfoLookup(ctx context.Context, pkt *pktoken.PKToken, ac
 }
 
 // OpkPolicyEnforcerAuthFunc returns an opkssh policy.Enforcer that can be
-// used in the opkssh verify command.
-func OpkPolicyEnforcerFunc(username string) PolicyEnforcerFunc {
+// used in the opkssh verify command. serverConfig may be nil if the server
+// config file could not be read. The issuer aliases of providerPolicy, if
+// set, apply to the policy entries. onAllow, if set, is called with what
+// allowed the login.
+func OpkPolicyEnforcerFunc(username string, serverConfig *config.ServerConfig, providerPolicy *policy.ProviderPolicy, onAllow func(policy.Match)) PolicyEnforcerFunc {
+	policyEnforcer, _ := newOpkPolicyEnforcer(username, serverConfig, providerPolicy, onAllow)
+	return policyEnforcer.CheckPolicy
+}
+
+// HomePolicyAccess returns the users whose home policy is read according to
+// cfg
+func HomePolicyAccess(cfg config.HomePolicyConfig) policy.HomePolicyAccess {
+	return policy.HomePolicyAccess{
+		Disabled:          cfg.Disabled,
+		AllowedPrincipals: cfg.AllowedPrincipals,
+		AllowedGroups:     cfg.AllowedGroups,
+	}
+}
+
+// newOpkPolicyEnforcer returns the policy.Enforcer of OpkPolicyEnforcerFunc
+// and its policy loader
+func newOpkPolicyEnforcer(username string, serverConfig *config.ServerConfig, providerPolicy *policy.ProviderPolicy, onAllow func(policy.Match)) (*policy.Enforcer, *policy.MultiPolicyLoader) {
+	policyLoader := policy.NewMultiPolicyLoader(username, policy.ReadWithSudoScript)
+	if serverConfig != nil {
+		if err := ConfigurePolicyStore(policyLoader.SystemPolicyLoader, serverConfig.PolicyStore); err != nil {
+			slog.Warn("Not loading the system policy", "error", err)
+		}
+		policyLoader.HomePolicyAccess = HomePolicyAccess(serverConfig.HomePolicy)
+		policyLoader.HomePolicyConstraints = policy.HomePolicyConstraints{
+			AllowedIssuers:       serverConfig.HomePolicy.AllowedIssuers,
+			ForbiddenPrincipals:  serverConfig.HomePolicy.ForbiddenPrincipals,
+			MaxEntries:           serverConfig.HomePolicy.MaxEntries,
+			RequiredEmailDomains: serverConfig.HomePolicy.RequiredEmailDomains,
+		}
+		policyLoader.GrantQuota = policy.GrantQuota{
+			WarnThreshold:   serverConfig.HomePolicy.IdentityWarnThreshold,
+			DenyThreshold:   serverConfig.HomePolicy.IdentityDenyThreshold,
+			AnomalyIncrease: serverConfig.HomePolicy.AnomalyIncrease,
+			StateDir:        serverConfig.HomePolicy.StateDir,
+		}
+		if len(serverConfig.PolicyFragments.TrustedKeys) > 0 {
+			trustedKeys, err := FragmentTrustedKeys(serverConfig.PolicyFragments)
+			if err != nil {
+				// Fail closed, a typo must not turn off signature checks
+				slog.Warn("Ignoring all policy fragments", "error", err)
+				policyLoader.Fragments = nil
+			} else {
+				policyLoader.Fragments.TrustedKeys = trustedKeys
+			}
+		}
+	}
 	policyEnforcer := &policy.Enforcer{
-		PolicyLoader: policy.NewMultiPolicyLoader(username, policy.ReadWithSudoScript),
+		PolicyLoader: policyLoader,
+		OnAllow:      onAllow,
 	}
-	return policyEnforcer.CheckPolicy
+	if providerPolicy != nil {
+		policyEnforcer.IssuerAliases = providerPolicy.IssuerAliases()
+	}
+	if ldapConfig, err := ldap.LoadConfig(afero.NewOsFs(), policy.SystemDefaultLDAPConfigPath); err != nil {
+		slog.Warn("Ignoring the LDAP group policy", "error", err)
+	} else if ldapConfig != nil {
+		policyEnforcer.LDAP = ldap.NewPolicy(ldapConfig, policy.SystemDefaultLDAPConfigPath)
+	}
+	if serverConfig != nil {
+		policyEnforcer.PluginWorkers = serverConfig.Plugins.Workers
+		if serverConfig.Plugins.Timeout != "" {
+			timeout, err := time.ParseDuration(serverConfig.Plugins.Timeout)
+			if err != nil || timeout <= 0 {
+				slog.Warn("Ignoring invalid plugins timeout in the config file", "timeout", serverConfig.Plugins.Timeout)
+			} else {
+				policyEnforcer.PluginTimeout = timeout
+			}
+		}
+		if len(serverConfig.Plugins.TrustedKeys) > 0 {
+			trustedKeys, err := PluginTrustedKeys(serverConfig.Plugins)
+			if err != nil {
+				// Fail closed, no plugin config is signed by an empty list
+				slog.Warn("Ignoring all policy plugins", "error", err)
+				trustedKeys = []ssh.PublicKey{}
+			}
+			policyEnforcer.PluginTrustedKeys = trustedKeys
+		}
+	}
+	return policyEnforcer, policyLoader
 }
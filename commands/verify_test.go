@@ -18,6 +18,7 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/openpubkey/openpubkey/client"
@@ -33,6 +35,9 @@ import (
 	"github.com/openpubkey/openpubkey/providers/mocks"
 	"github.com/openpubkey/openpubkey/util"
 	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/audit"
+	"github.com/openpubkey/opkssh/internal/events"
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
 	"github.com/openpubkey/opkssh/sshcert"
@@ -85,11 +90,19 @@ func TestAuthorizedKeysCommand(t *testing.T) {
 		"extraArg2",
 	}
 
+	bastion, err := NewProxyPolicy(config.ProxyConfig{
+		Trusted:    []config.TrustedProxy{{Name: "bastion", Addresses: []string{"10.0.0.5"}}},
+		RequireFor: []string{"user"},
+	})
+	require.NoError(t, err)
+
 	tests := []struct {
 		name        string
 		accessToken string
 		errorString string
 		policyFunc  func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error
+		proxy       *ProxyPolicy
+		connection  string
 	}{
 		{
 			name:       "Happy Path",
@@ -116,6 +129,25 @@ func TestAuthorizedKeysCommand(t *testing.T) {
 				return fmt.Errorf("extraArgs doesn't match (expected %v, got %v)", mockExtraArgs, extraArgs)
 			},
 		},
+		{
+			name:       "Through trusted proxy",
+			policyFunc: AllowAllPolicyEnforcer,
+			proxy:      bastion,
+			connection: "10.0.0.5 52044 10.0.1.20 22",
+		},
+		{
+			name:        "Proxy required but connected directly",
+			policyFunc:  AllowAllPolicyEnforcer,
+			proxy:       bastion,
+			connection:  "192.0.2.10 52044 10.0.1.20 22",
+			errorString: "requires a trusted proxy but the connection came from 192.0.2.10",
+		},
+		{
+			name:        "Proxy required but connection unknown",
+			policyFunc:  AllowAllPolicyEnforcer,
+			proxy:       bastion,
+			errorString: "pass --connection %C to verify",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -158,9 +190,11 @@ func TestAuthorizedKeysCommand(t *testing.T) {
 
 			userArg := "user"
 			ver := VerifyCmd{
-				PktVerifier: *verPkt,
-				CheckPolicy: tt.policyFunc,
-				HttpClient:  mocks.NewMockGoogleUserInfoHTTPClient(userInfoResponse, expectedAccessToken),
+				PktVerifier:   *verPkt,
+				CheckPolicy:   tt.policyFunc,
+				HttpClient:    mocks.NewMockGoogleUserInfoHTTPClient(userInfoResponse, expectedAccessToken),
+				Proxy:         tt.proxy,
+				ConnectionArg: tt.connection,
 			}
 
 			pubkeyList, err := ver.AuthorizedKeysCommand(context.Background(), userArg, typeArg, certB64Arg, mockExtraArgs)
@@ -179,6 +213,148 @@ func TestAuthorizedKeysCommand(t *testing.T) {
 	}
 }
 
+type recordingAuditWriter struct {
+	lines []string
+}
+
+func (r *recordingAuditWriter) Write(_ audit.Decision, line []byte) error {
+	r.lines = append(r.lines, string(line))
+	return nil
+}
+
+func (r *recordingAuditWriter) Close() error { return nil }
+
+func TestAuthorizedKeysCommandAudit(t *testing.T) {
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+	providerOpts := providers.DefaultMockProviderOpts()
+	providerOpts.Issuer = "https://accounts.google.com"
+	op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{"email": "arthur.aardvark@example.com"}
+
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+	cert, err := sshcert.New(pkt, nil, []string{"user"})
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoECDSA256})
+	require.NoError(t, err)
+	sshCert, err := cert.SignCert(signerMas)
+	require.NoError(t, err)
+	typeArg, certB64Arg, _ := strings.Cut(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshCert))), " ")
+	verPkt, err := verifier.New(op, verifier.WithExpirationPolicy(verifier.ExpirationPolicies.NEVER_EXPIRE))
+	require.NoError(t, err)
+
+	writer := &recordingAuditWriter{}
+	logger := audit.NewLogger(writer)
+	logger.Now = func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) }
+	fs := afero.NewMemMapFs()
+	ver := &VerifyCmd{
+		Fs:             fs,
+		PktVerifier:    *verPkt,
+		Audit:          logger,
+		SshConnection:  "192.0.2.10 52044 10.0.1.20 22",
+		DenyReasonFile: "/run/opk/deny/%u",
+	}
+	ver.CheckPolicy = func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+		if userDesired == "admin" {
+			return fmt.Errorf("failed to read policy")
+		}
+		if userDesired != "user" {
+			return &policy.DenialError{Code: policy.DenyCodeNoPolicy, PluginReasons: []string{"root logins need a ticket"}}
+		}
+		ver.RecordMatch(policy.Match{Entry: "user arthur.aardvark@example.com https://accounts.google.com", Source: "/etc/opk/auth_id"})
+		return nil
+	}
+
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.NoError(t, err)
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "root", typeArg, certB64Arg, nil)
+	require.Error(t, err)
+	content, err := afero.ReadFile(fs, "/run/opk/deny/root")
+	require.NoError(t, err)
+	require.Equal(t, "no policy allows you to log in as root: root logins need a ticket\n", string(content))
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "root", typeArg, "bad-cert", nil)
+	require.Error(t, err)
+	content, err = afero.ReadFile(fs, "/run/opk/deny/root")
+	require.NoError(t, err)
+	require.Equal(t, "your SSH key is not a valid opkssh certificate\n", string(content))
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "admin", typeArg, certB64Arg, nil)
+	require.ErrorContains(t, err, "failed to read policy")
+
+	// A successful login removes the reason of the last denied one
+	require.NoError(t, afero.WriteFile(fs, "/run/opk/deny/user", []byte("stale\n"), 0o640))
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.NoError(t, err)
+	exists, err := afero.Exists(fs, "/run/opk/deny/user")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// The options of the plugins that allowed the login restrict it
+	ver.CheckPolicy = func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+		ver.RecordMatch(policy.Match{Plugin: "/etc/opk/policy.d/backup.yml", Options: []string{"no-pty", `command="/usr/bin/backup"`}})
+		return nil
+	}
+	authKey, err := ver.AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(authKey, `cert-authority,no-pty,command="/usr/bin/backup" ecdsa-sha2-nistp256 `), authKey)
+
+	require.Len(t, writer.lines, 6)
+	require.Equal(t, `{"time":"2026-01-02T03:04:05Z","decision":"allow","principal":"user","issuer":"https://accounts.google.com","subject":"me","email":"arthur.aardvark@example.com","policy":"user arthur.aardvark@example.com https://accounts.google.com","policy_source":"/etc/opk/auth_id","client_ip":"192.0.2.10","client_port":"52044"}`, writer.lines[0])
+	require.Equal(t, `{"time":"2026-01-02T03:04:05Z","decision":"deny","principal":"root","issuer":"https://accounts.google.com","subject":"me","email":"arthur.aardvark@example.com","reason_code":"no_policy","client_ip":"192.0.2.10","client_port":"52044"}`, writer.lines[1])
+	// The identity is unknown when the certificate can't be verified
+	require.Contains(t, writer.lines[2], `"decision":"deny","principal":"root","reason":`)
+	require.Contains(t, writer.lines[2], `"reason_code":"invalid_token"`)
+	require.NotContains(t, writer.lines[2], "issuer")
+	require.Contains(t, writer.lines[3], `"reason":"failed to read policy","reason_code":"error"`)
+}
+
+// recordingSink keeps the events sent to it
+type recordingSink struct {
+	events []events.Event
+}
+
+func (r *recordingSink) Send(e events.Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestWarnIfExpiring(t *testing.T) {
+	op, _, _, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+	require.NoError(t, err)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	sink := &recordingSink{}
+	events.Default().Subscribe(sink, events.CertificateExpiring)
+	defer events.Default().Reset()
+
+	// Only the row of the client ID of the token applies, the first row of
+	// the issuer would expire it within the warning window
+	providerPolicy := &policy.ProviderPolicy{}
+	providerPolicy.AddRow(policy.ProvidersRow{Issuer: "https://accounts.example.com", ClientID: "other_client_id", ExpirationPolicy: "12h"})
+	providerPolicy.AddRow(policy.ProvidersRow{Issuer: "https://accounts.example.com", ClientID: "test_client_id", ExpirationPolicy: "1week"})
+	ver := &VerifyCmd{ProviderPolicy: providerPolicy, ExpiryWarning: 13 * time.Hour}
+	ver.warnIfExpiring("user", pkt)
+	require.Empty(t, sink.events)
+
+	// The policy entry that allowed the login expires first
+	expires := time.Now().Add(time.Hour)
+	ver.RecordMatch(policy.Match{Entry: "user arthur.aardvark@example.com https://accounts.example.com", Source: "/etc/opk/auth_id", Expires: expires})
+	ver.warnIfExpiring("user", pkt)
+	require.Len(t, sink.events, 1)
+	require.Equal(t, "user arthur.aardvark@example.com https://accounts.example.com", sink.events[0].Fields["entry"])
+	require.Equal(t, "/etc/opk/auth_id", sink.events[0].Fields["source"])
+	require.Equal(t, expires.UTC().Format(time.RFC3339), sink.events[0].Fields["expires_at"])
+}
+
 func TestEnvFromConfig(t *testing.T) {
 	// Do not run this test in parallel with other tests as it modifies environment variables
 
@@ -186,6 +362,7 @@ func TestEnvFromConfig(t *testing.T) {
 env_vars:
   OPKSSH_TEST_EXAMPLE_VAR1: ABC
   OPKSSH_TEST_EXAMPLE_VAR2: DEF
+expiry_warning: 2h
 `
 
 	tests := []struct {
@@ -259,8 +436,112 @@ env_vars:
 				require.NoError(t, err)
 				require.Equal(t, "ABC", os.Getenv("OPKSSH_TEST_EXAMPLE_VAR1"))
 				require.Equal(t, "DEF", os.Getenv("OPKSSH_TEST_EXAMPLE_VAR2"))
+				require.NotNil(t, ver.ServerConfig)
+				require.Equal(t, 2*time.Hour, ver.ExpiryWarning)
 			}
 		})
 	}
 
 }
+
+func TestAuthorizedKeysCommandRateLimit(t *testing.T) {
+	s, req := newTestServeCmd(t)
+	state, err := s.Load()
+	require.NoError(t, err)
+	checks := 0
+	var policyErr error
+	limiter := policy.NewRateLimiter(2, time.Minute, time.Minute)
+	limiter.Fs = afero.NewMemMapFs()
+	ver := &VerifyCmd{
+		Fs:            afero.NewMemMapFs(),
+		PktVerifier:   state.PktVerifier,
+		SshConnection: "192.0.2.10 52044 10.0.1.20 22",
+		RateLimiter:   limiter,
+		CheckPolicy: func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+			checks++
+			return policyErr
+		},
+	}
+
+	// Keys that aren't opkssh certificates are not counted
+	for i := 0; i < 3; i++ {
+		_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, "bad-cert", nil)
+		require.Equal(t, DenyCodeInvalidToken, NewDenyReason(req.Principal, err).Code)
+	}
+
+	policyErr = errors.New("no policy entry")
+	for i := 0; i < 2; i++ {
+		_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, req.Cert, nil)
+		require.ErrorContains(t, err, "no policy entry")
+	}
+	require.Equal(t, 2, checks)
+
+	// Throttled logins are refused before the certificate is checked
+	policyErr = nil
+	_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, req.Cert, nil)
+	require.Equal(t, DenyReason{Code: policy.DenyCodeRateLimited, Message: "too many failed logins as user, try again later"}, NewDenyReason(req.Principal, err))
+	require.Equal(t, 2, checks)
+
+	ver.SshConnection = "192.0.2.11 52044 10.0.1.20 22"
+	_, err = ver.AuthorizedKeysCommand(context.Background(), req.Principal, req.KeyType, req.Cert, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, checks)
+}
+
+func TestAuthorizedKeysCommandReplay(t *testing.T) {
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+	op, _, idtTemplate, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{"jti": "jti-1"}
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+	cert, err := sshcert.New(pkt, nil, []string{"user"})
+	require.NoError(t, err)
+	sshSigner, err := ssh.NewSignerFromSigner(signer)
+	require.NoError(t, err)
+	signerMas, err := ssh.NewSignerWithAlgorithms(sshSigner.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoECDSA256})
+	require.NoError(t, err)
+	sshCert, err := cert.SignCert(signerMas)
+	require.NoError(t, err)
+	typeArg, certB64Arg, _ := strings.Cut(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshCert))), " ")
+	verPkt, err := verifier.New(op, verifier.WithExpirationPolicy(verifier.ExpirationPolicies.NEVER_EXPIRE))
+	require.NoError(t, err)
+
+	memory := policy.NewMemoryReplayStore()
+	newVerify := func(host string) *VerifyCmd {
+		ver := &VerifyCmd{
+			Fs:           afero.NewMemMapFs(),
+			PktVerifier:  *verPkt,
+			ReplayMemory: memory,
+			CheckPolicy: func(userDesired string, pkt *pktoken.PKToken, userInfo string, certB64 string, typArg string, denyList policy.DenyList, extraArgs []string) error {
+				return nil
+			},
+		}
+		ver.ApplyServerConfig(&config.ServerConfig{ReplayCache: config.ReplayCacheConfig{Backend: ReplayBackendMemory}})
+		ver.Replay.Host = host
+		return ver
+	}
+
+	_, err = newVerify("host-a").AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.NoError(t, err)
+	_, err = newVerify("host-a").AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.NoError(t, err)
+	_, err = newVerify("host-b").AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.ErrorContains(t, err, "ID Token with jti jti-1 was first used by host-a, not host-b")
+	require.Equal(t, policy.DenyCodeReplay, NewDenyReason("user", err).Code)
+
+	// An invalid replay_cache refuses every login
+	ver := &VerifyCmd{Fs: afero.NewMemMapFs(), PktVerifier: *verPkt, CheckPolicy: newVerify("host-a").CheckPolicy}
+	ver.ApplyServerConfig(&config.ServerConfig{ReplayCache: config.ReplayCacheConfig{Backend: ReplayBackendMemory}})
+	_, err = ver.AuthorizedKeysCommand(context.Background(), "user", typeArg, certB64Arg, nil)
+	require.ErrorContains(t, err, "the memory replay_cache backend only works with opkssh serve")
+
+	_, err = NewReplayGuard(config.ReplayCacheConfig{Backend: ReplayBackendFile, Bind: "user"}, nil)
+	require.ErrorContains(t, err, `unsupported replay_cache bind "user"`)
+	_, err = NewReplayGuard(config.ReplayCacheConfig{Backend: "redis"}, nil)
+	require.ErrorContains(t, err, `unsupported replay_cache backend "redis"`)
+}
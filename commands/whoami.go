@@ -0,0 +1,79 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/openpubkey/openpubkey/pktoken"
+)
+
+// WhoamiCmd prints the identity and expiry of the most recent login
+type WhoamiCmd struct {
+	TokenStore TokenStore
+	Out        io.Writer
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+// NewWhoamiCmd creates a WhoamiCmd that reads the platform's token store
+func NewWhoamiCmd(rt *Runtime, store TokenStore) *WhoamiCmd {
+	return &WhoamiCmd{TokenStore: store, Out: rt.Out, Now: rt.Now}
+}
+
+func (w *WhoamiCmd) Run() error {
+	token, err := w.TokenStore.Load()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return fmt.Errorf("no stored login, run opkssh login --store-token or set store_token in the client config")
+	}
+	pkt, err := pktoken.NewFromCompact([]byte(token.PKToken))
+	if err != nil {
+		return fmt.Errorf("failed to parse stored PK Token: %w", err)
+	}
+	idStr, err := IdentityString(*pkt)
+	if err != nil {
+		return fmt.Errorf("failed to parse ID Token: %w", err)
+	}
+	fmt.Fprintln(w.Out, idStr)
+	if token.KeyPath != "" {
+		fmt.Fprintf(w.Out, "SSH key: %s\n", token.KeyPath)
+	}
+	fmt.Fprintf(w.Out, "ID token expires: %s\n", w.describe(token.ExpiresAt))
+	if token.ValidBefore.IsZero() {
+		fmt.Fprintln(w.Out, "SSH cert expires: as set by the server's expiration policy")
+	} else {
+		fmt.Fprintf(w.Out, "SSH cert expires: %s\n", w.describe(token.ValidBefore))
+	}
+	return nil
+}
+
+func (w *WhoamiCmd) describe(t time.Time) string {
+	now := time.Now
+	if w.Now != nil {
+		now = w.Now
+	}
+	remaining := t.Sub(now())
+	if remaining <= 0 {
+		return fmt.Sprintf("%s (expired)", t.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("%s (in %s)", t.Format(time.RFC3339), remaining.Round(time.Second))
+}
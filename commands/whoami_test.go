@@ -0,0 +1,51 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhoami(t *testing.T) {
+	pkt, _, _ := Mocks(t, ECDSA)
+	store := &FileTokenStore{Fs: afero.NewMemMapFs(), Path: "/token.json"}
+	out := &bytes.Buffer{}
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	whoami := &WhoamiCmd{TokenStore: store, Out: out, Now: func() time.Time { return now }}
+
+	err := whoami.Run()
+	require.ErrorContains(t, err, "no stored login, run opkssh login --store-token")
+
+	token, err := NewStoredToken(pkt, nil, "/home/alice/.ssh/id_ecdsa")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(token))
+	require.NoError(t, whoami.Run())
+	require.Contains(t, out.String(), "arthur.aardvark@example.com")
+	require.Contains(t, out.String(), "SSH key: /home/alice/.ssh/id_ecdsa")
+	require.Contains(t, out.String(), "SSH cert expires: as set by the server's expiration policy")
+
+	out.Reset()
+	token.ValidBefore = now.Add(90 * time.Minute)
+	require.NoError(t, store.Save(token))
+	require.NoError(t, whoami.Run())
+	require.Contains(t, out.String(), "SSH cert expires: 2026-10-01T13:30:00Z (in 1h30m0s)")
+}
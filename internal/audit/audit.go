@@ -0,0 +1,159 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit records every authorization decision made by opkssh verify
+// as a JSON line, so they can be fed into a SIEM
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Decision is the outcome of an authorization
+type Decision string
+
+const (
+	Allow Decision = "allow"
+	Deny  Decision = "deny"
+)
+
+// Destinations of the audit log
+const (
+	DestinationFile     = "file"
+	DestinationSyslog   = "syslog"
+	DestinationEventLog = "eventlog"
+)
+
+// Record is a single authorization decision
+type Record struct {
+	Time      time.Time `json:"time"`
+	Decision  Decision  `json:"decision"`
+	Principal string    `json:"principal"`
+	Issuer    string    `json:"issuer,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	// Policy is the policy entry that allowed the login and PolicySource
+	// the file it was loaded from
+	Policy       string `json:"policy,omitempty"`
+	PolicySource string `json:"policy_source,omitempty"`
+	// Plugin is the policy plugin that allowed the login
+	Plugin string `json:"plugin,omitempty"`
+	// Reason is why the login was denied and ReasonCode classifies it, such
+	// as expired or no_policy
+	Reason     string `json:"reason,omitempty"`
+	ReasonCode string `json:"reason_code,omitempty"`
+	ClientIP   string `json:"client_ip,omitempty"`
+	ClientPort string `json:"client_port,omitempty"`
+}
+
+// Writer delivers encoded records to a destination
+type Writer interface {
+	// Write delivers line, the JSON encoding of a record with the given
+	// decision
+	Write(decision Decision, line []byte) error
+	Close() error
+}
+
+// Logger encodes records and writes them to a Writer
+type Logger struct {
+	w Writer
+	// Now can be replaced in tests
+	Now func() time.Time
+}
+
+// NewLogger returns a Logger writing to w
+func NewLogger(w Writer) *Logger {
+	return &Logger{w: w, Now: time.Now}
+}
+
+// Open returns a Logger for destination. path is the file records are
+// appended to and is only used by the file destination.
+func Open(destination string, path string) (*Logger, error) {
+	var w Writer
+	var err error
+	switch destination {
+	case DestinationFile:
+		if path == "" {
+			return nil, fmt.Errorf("audit destination file requires a path")
+		}
+		w, err = openFile(path)
+	case DestinationSyslog:
+		w, err = openSyslog()
+	case DestinationEventLog:
+		w, err = openEventLog()
+	default:
+		return nil, fmt.Errorf("unknown audit destination %q, expected file, syslog or eventlog", destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewLogger(w), nil
+}
+
+// Log sets the time of r if it is unset and writes it
+func (l *Logger) Log(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = l.Now()
+	}
+	r.Time = r.Time.UTC()
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return l.w.Write(r.Decision, line)
+}
+
+func (l *Logger) Close() error {
+	return l.w.Close()
+}
+
+// ClientAddress returns the client IP and port from the SSH_CONNECTION
+// value ("client address, client port, server address, server port")
+func ClientAddress(sshConnection string) (ip string, port string) {
+	fields := strings.Fields(sshConnection)
+	if len(fields) != 4 {
+		return "", ""
+	}
+	return fields[0], fields[1]
+}
+
+type fileWriter struct {
+	f *os.File
+}
+
+func openFile(path string) (*fileWriter, error) {
+	// Decisions may identify users, keep them from other accounts
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &fileWriter{f: f}, nil
+}
+
+func (w *fileWriter) Write(_ Decision, line []byte) error {
+	// A single write of the whole line keeps concurrent verify processes
+	// from interleaving records
+	_, err := w.f.Write(append(line, '\n'))
+	return err
+}
+
+func (w *fileWriter) Close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	logger, err := Open(DestinationFile, path)
+	require.NoError(t, err)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	logger.Now = func() time.Time { return now }
+
+	require.NoError(t, logger.Log(Record{
+		Decision:     Allow,
+		Principal:    "root",
+		Issuer:       "https://accounts.google.com",
+		Subject:      "1234",
+		Email:        "alice@example.com",
+		Policy:       "root alice@example.com https://accounts.google.com",
+		PolicySource: "/etc/opk/auth_id",
+		ClientIP:     "192.0.2.10",
+		ClientPort:   "52044",
+	}))
+	require.NoError(t, logger.Log(Record{Decision: Deny, Principal: "root", Reason: "denied user root"}))
+	require.NoError(t, logger.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, `{"time":"2026-01-02T02:04:05Z","decision":"allow","principal":"root","issuer":"https://accounts.google.com","subject":"1234","email":"alice@example.com","policy":"root alice@example.com https://accounts.google.com","policy_source":"/etc/opk/auth_id","client_ip":"192.0.2.10","client_port":"52044"}`, lines[0])
+
+	var denied Record
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &denied))
+	require.Equal(t, Record{Time: now.UTC(), Decision: Deny, Principal: "root", Reason: "denied user root"}, denied)
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, err := Open(DestinationFile, "")
+	require.ErrorContains(t, err, "requires a path")
+	_, err = Open("splunk", "")
+	require.ErrorContains(t, err, `unknown audit destination "splunk"`)
+	_, err = Open(DestinationFile, filepath.Join(t.TempDir(), "missing", "audit.log"))
+	require.ErrorContains(t, err, "failed to open audit log")
+}
+
+func TestClientAddress(t *testing.T) {
+	ip, port := ClientAddress("2001:db8::1 52044 2001:db8::2 22")
+	require.Equal(t, "2001:db8::1", ip)
+	require.Equal(t, "52044", port)
+
+	ip, port = ClientAddress("")
+	require.Empty(t, ip)
+	require.Empty(t, port)
+}
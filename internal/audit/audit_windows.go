@@ -0,0 +1,60 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package audit
+
+import (
+	"fmt"
+
+	opkeventlog "github.com/openpubkey/opkssh/internal/eventlog"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs of the records in the Windows Event Log
+const (
+	EventIDAllow = 100
+	EventIDDeny  = 101
+)
+
+type eventLogWriter struct {
+	l *eventlog.Log
+}
+
+func openEventLog() (Writer, error) {
+	l, err := eventlog.Open(opkeventlog.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log source %s: %w", opkeventlog.Source, err)
+	}
+	return &eventLogWriter{l: l}, nil
+}
+
+func (e *eventLogWriter) Write(decision Decision, line []byte) error {
+	if decision == Deny {
+		return e.l.Warning(EventIDDeny, string(line))
+	}
+	return e.l.Info(EventIDAllow, string(line))
+}
+
+func (e *eventLogWriter) Close() error {
+	return e.l.Close()
+}
+
+func openSyslog() (Writer, error) {
+	return nil, fmt.Errorf("audit destination syslog is not supported on Windows, use eventlog")
+}
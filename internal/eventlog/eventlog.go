@@ -0,0 +1,91 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package eventlog reports server side errors, such as failed verifications
+// and plugin errors, to the Windows Event Log where admins can see them in
+// Event Viewer. On other systems nothing is reported, the errors are only in
+// the opkssh log.
+package eventlog
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Source is the event source opkssh registers and writes to in the
+// Application log
+const Source = "opkssh"
+
+// EventID identifies the kind of error in Event Viewer
+type EventID uint32
+
+const (
+	// VerifyFailed is reported when opkssh verify refuses a login
+	VerifyFailed EventID = 200
+	// PolicyLoadFailed is reported when the policy or providers file can't
+	// be loaded
+	PolicyLoadFailed EventID = 201
+	// PluginFailed is reported when a policy plugin can't be loaded or its
+	// command fails
+	PluginFailed EventID = 202
+)
+
+// Writer writes error events
+type Writer interface {
+	Error(id uint32, msg string) error
+	Close() error
+}
+
+var (
+	mu            sync.Mutex
+	defaultWriter Writer
+)
+
+// SetWriter sets the writer errors are reported to, nil stops reporting
+func SetWriter(w Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultWriter = w
+}
+
+// Enable opens the event log and reports errors to it until the returned
+// function is called. It does nothing on systems without an event log.
+func Enable() (func(), error) {
+	w, err := Open()
+	if err != nil || w == nil {
+		return func() {}, err
+	}
+	SetWriter(w)
+	return func() {
+		SetWriter(nil)
+		_ = w.Close()
+	}, nil
+}
+
+// Report writes an error event if a writer is set. Failing to write is
+// logged, it must not change the outcome of the operation that failed.
+func Report(id EventID, format string, args ...any) {
+	mu.Lock()
+	w := defaultWriter
+	mu.Unlock()
+	if w == nil {
+		return
+	}
+	if err := w.Error(uint32(id), fmt.Sprintf(format, args...)); err != nil {
+		slog.Warn("Failed to write to the event log", "error", err)
+	}
+}
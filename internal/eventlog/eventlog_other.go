@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+// Open returns nil, there is no event log on this system
+func Open() (Writer, error) {
+	return nil, nil
+}
+
+// Install does nothing, there is no event log on this system
+func Install() error {
+	return nil
+}
+
+// Uninstall does nothing, there is no event log on this system
+func Uninstall() error {
+	return nil
+}
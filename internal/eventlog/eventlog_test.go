@@ -0,0 +1,55 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingWriter struct {
+	events map[uint32][]string
+	err    error
+}
+
+func (r *recordingWriter) Error(id uint32, msg string) error {
+	r.events[id] = append(r.events[id], msg)
+	return r.err
+}
+
+func (r *recordingWriter) Close() error { return nil }
+
+func TestReport(t *testing.T) {
+	// Nothing is reported, and nothing fails, until a writer is set
+	Report(VerifyFailed, "failed to verify: %v", "no policy")
+
+	w := &recordingWriter{events: map[uint32][]string{}}
+	SetWriter(w)
+	t.Cleanup(func() { SetWriter(nil) })
+
+	Report(VerifyFailed, "Failed to verify login as %s: %v", "root", fmt.Errorf("no policy"))
+	Report(PluginFailed, "Policy plugin %s failed", "/etc/opk/policy.d/ldap.yml")
+	w.err = fmt.Errorf("event log is full")
+	Report(PluginFailed, "Policy plugin %s failed", "/etc/opk/policy.d/ldap.yml")
+
+	require.Equal(t, map[uint32][]string{
+		200: {"Failed to verify login as root: no policy"},
+		202: {"Policy plugin /etc/opk/policy.d/ldap.yml failed", "Policy plugin /etc/opk/policy.d/ldap.yml failed"},
+	}, w.events)
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package eventlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Open returns a writer to the Application log with the opkssh source
+func Open() (Writer, error) {
+	l, err := eventlog.Open(Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log source %s: %w", Source, err)
+	}
+	return l, nil
+}
+
+// Install registers the opkssh source so Event Viewer can display its
+// messages. It requires admin and succeeds if the source already exists.
+func Install() error {
+	err := eventlog.InstallAsEventCreate(Source, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil && !strings.Contains(err.Error(), "registry key already exists") {
+		return fmt.Errorf("failed to register event log source %s: %w", Source, err)
+	}
+	return nil
+}
+
+// Uninstall removes the opkssh source. It requires admin and succeeds if the
+// source doesn't exist.
+func Uninstall() error {
+	err := eventlog.Remove(Source)
+	if err != nil && !errors.Is(err, windows.ERROR_FILE_NOT_FOUND) {
+		return fmt.Errorf("failed to remove event log source %s: %w", Source, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package events is a small publish/subscribe hub for security relevant
+// events such as policy changes and denied logins. Every event is written to
+// the log as an audit line and forwarded to the configured sinks.
+package events
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Type identifies the kind of event
+type Type string
+
+const (
+	// PolicyChanged is emitted when a policy file is modified by opkssh
+	PolicyChanged Type = "policy_changed"
+	// AccessDenied is emitted when a verified identity is denied by policy
+	AccessDenied Type = "access_denied"
+	// PermissionDrift is emitted when opkssh files have insecure permissions
+	PermissionDrift Type = "permission_drift"
+	// CertificateExpiring is emitted when a login uses a PK Token that is
+	// about to expire
+	CertificateExpiring Type = "certificate_expiring"
+	// HomePolicyIdentityAnomaly is emitted when a home policy suddenly grows
+	HomePolicyIdentityAnomaly Type = "home_policy_identity_anomaly"
+	// HomePolicyQuotaExceeded is emitted when a home policy admits more
+	// identities than allowed
+	HomePolicyQuotaExceeded Type = "home_policy_identity_quota_exceeded"
+	// IdentityRevoked is emitted when an identity is added to the local
+	// revocation list, e.g. after it was suspended at its provider
+	IdentityRevoked Type = "identity_revoked"
+	// LoginThrottled is emitted when logins as a principal from a client
+	// address are refused after too many failures
+	LoginThrottled Type = "login_throttled"
+	// BreakGlassUsed is emitted when a login is allowed by a policy entry
+	// with the catchall=true option
+	BreakGlassUsed Type = "break_glass_used"
+)
+
+// Event is a single occurrence of a Type with its details
+type Event struct {
+	Type   Type              `json:"type"`
+	Time   time.Time         `json:"time"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// String formats the event as a single key=value audit line
+func (e Event) String() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("event=" + string(e.Type))
+	for _, k := range keys {
+		fmt.Fprintf(&sb, " %s=%s", k, e.Fields[k])
+	}
+	return sb.String()
+}
+
+// attrs are the type and the fields of e, sorted by name, as slog
+// attributes
+func (e Event) attrs() []any {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := []any{"event", string(e.Type)}
+	for _, k := range keys {
+		attrs = append(attrs, k, e.Fields[k])
+	}
+	return attrs
+}
+
+// Sink delivers events to an external system
+type Sink interface {
+	Send(e Event) error
+}
+
+type subscription struct {
+	sink Sink
+	// types is the set of event types delivered to sink, empty means all
+	types []Type
+}
+
+// Hub fans events out to subscribed sinks
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions []subscription
+	// Now can be replaced in tests
+	Now func() time.Time
+}
+
+// NewHub returns a Hub with no sinks
+func NewHub() *Hub {
+	return &Hub{Now: time.Now}
+}
+
+// Subscribe delivers events of the given types to sink. If no types are
+// given, all events are delivered.
+func (h *Hub) Subscribe(sink Sink, types ...Type) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscriptions = append(h.subscriptions, subscription{sink: sink, types: types})
+}
+
+// Reset removes all sinks
+func (h *Hub) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscriptions = nil
+}
+
+// Emit logs the event and sends it to every sink subscribed to its type.
+// Sink failures are logged but never returned, a notification problem must
+// not change the outcome of the operation that triggered it.
+func (h *Hub) Emit(eventType Type, fields map[string]string) {
+	e := Event{Type: eventType, Time: h.Now().UTC(), Fields: fields}
+	slog.Info("Audit event", e.attrs()...)
+
+	h.mu.Lock()
+	subscriptions := slices.Clone(h.subscriptions)
+	h.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, eventType) {
+			continue
+		}
+		if err := sub.sink.Send(e); err != nil {
+			slog.Warn("Failed to send notification", "event", eventType, "error", err)
+		}
+	}
+}
+
+var defaultHub = NewHub()
+
+// Default returns the process wide Hub
+func Default() *Hub {
+	return defaultHub
+}
+
+// Emit emits an event on the process wide Hub
+func Emit(eventType Type, fields map[string]string) {
+	defaultHub.Emit(eventType, fields)
+}
@@ -0,0 +1,144 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSink struct {
+	received []Event
+	err      error
+}
+
+func (r *recordingSink) Send(e Event) error {
+	r.received = append(r.received, e)
+	return r.err
+}
+
+func TestHubEmit(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	hub := NewHub()
+	hub.Now = func() time.Time { return now }
+
+	all := &recordingSink{}
+	deniedOnly := &recordingSink{}
+	failing := &recordingSink{err: fmt.Errorf("unreachable")}
+	hub.Subscribe(all)
+	hub.Subscribe(deniedOnly, AccessDenied)
+	hub.Subscribe(failing)
+
+	hub.Emit(PolicyChanged, map[string]string{"path": "/etc/opk/auth_id"})
+	hub.Emit(AccessDenied, map[string]string{"user": "root", "identity": "alice@example.com"})
+
+	require.Len(t, all.received, 2)
+	require.Len(t, failing.received, 2)
+	require.Len(t, deniedOnly.received, 1)
+	require.Equal(t, Event{
+		Type:   AccessDenied,
+		Time:   now,
+		Fields: map[string]string{"user": "root", "identity": "alice@example.com"},
+	}, deniedOnly.received[0])
+	require.Equal(t, "event=access_denied identity=alice@example.com user=root", deniedOnly.received[0].String())
+
+	hub.Reset()
+	hub.Emit(PolicyChanged, nil)
+	require.Len(t, all.received, 2)
+}
+
+func TestWebhookAndSlackSinks(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	e := Event{Type: PermissionDrift, Time: time.Unix(0, 0).UTC(), Fields: map[string]string{"problems": "1"}}
+
+	require.NoError(t, (&WebhookSink{URL: server.URL}).Send(e))
+	var decoded Event
+	require.NoError(t, json.Unmarshal([]byte(bodies[0]), &decoded))
+	require.Equal(t, e, decoded)
+
+	require.NoError(t, (&SlackSink{URL: server.URL}).Send(e))
+	require.Equal(t, `{"text":"opkssh permission_drift: event=permission_drift problems=1"}`, bodies[1])
+
+	err := (&WebhookSink{URL: server.URL + "/fail"}).Send(e)
+	require.ErrorContains(t, err, "500")
+}
+
+func TestSMTPSink(t *testing.T) {
+	var gotAddr, gotFrom string
+	var gotTo []string
+	var gotMsg string
+	sink := &SMTPSink{
+		Addr:     "smtp.example.com:587",
+		From:     "opkssh@example.com",
+		To:       []string{"admin@example.com"},
+		Username: "opkssh",
+		Password: "secret",
+		SendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			require.NotNil(t, a)
+			gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, string(msg)
+			return nil
+		},
+	}
+
+	require.NoError(t, sink.Send(Event{Type: AccessDenied, Time: time.Unix(0, 0).UTC(), Fields: map[string]string{"user": "root"}}))
+	require.Equal(t, "smtp.example.com:587", gotAddr)
+	require.Equal(t, "opkssh@example.com", gotFrom)
+	require.Equal(t, []string{"admin@example.com"}, gotTo)
+	require.True(t, strings.Contains(gotMsg, "Subject: opkssh access_denied\r\n"))
+	require.True(t, strings.Contains(gotMsg, "event=access_denied user=root"))
+}
+
+func TestSMTPSinkTimeout(t *testing.T) {
+	// A server that accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	sink := &SMTPSink{Addr: listener.Addr().String(), From: "opkssh@example.com", To: []string{"admin@example.com"}, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = sink.Send(Event{Type: AccessDenied, Time: time.Unix(0, 0).UTC()})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,189 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// DefaultSinkTimeout bounds how long a sink may block. Events are sent
+// synchronously from short lived processes such as opkssh verify which sshd
+// is waiting on.
+const DefaultSinkTimeout = 5 * time.Second
+
+// WebhookSink POSTs each event as JSON to URL
+type WebhookSink struct {
+	URL        string
+	HttpClient *http.Client
+}
+
+func (w *WebhookSink) Send(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return postJSON(w.client(), w.URL, body)
+}
+
+func (w *WebhookSink) client() *http.Client {
+	if w.HttpClient != nil {
+		return w.HttpClient
+	}
+	return &http.Client{Timeout: DefaultSinkTimeout}
+}
+
+// SlackSink posts each event as a message to a Slack compatible incoming
+// webhook
+type SlackSink struct {
+	URL        string
+	HttpClient *http.Client
+}
+
+func (s *SlackSink) Send(e Event) error {
+	body, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("opkssh %s: %s", e.Type, e.String()),
+	})
+	if err != nil {
+		return err
+	}
+	client := s.HttpClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultSinkTimeout}
+	}
+	return postJSON(client, s.URL, body)
+}
+
+func postJSON(client *http.Client, url string, body []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned status %s", url, resp.Status)
+	}
+	return nil
+}
+
+// SMTPSink emails each event
+type SMTPSink struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+	// Timeout bounds the whole exchange with the server, 0 uses
+	// DefaultSinkTimeout
+	Timeout time.Duration
+	// SendMail can be replaced in tests
+	SendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func (s *SMTPSink) Send(e Event) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address %s: %w", s.Addr, err)
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + strings.Join(s.To, ", "),
+		"Subject: opkssh " + string(e.Type),
+		"Date: " + e.Time.Format(time.RFC1123Z),
+		"",
+		e.String(),
+		"",
+	}, "\r\n")
+
+	sendMail := s.SendMail
+	if sendMail == nil {
+		sendMail = s.sendMail
+	}
+	return sendMail(s.Addr, auth, s.From, s.To, []byte(msg))
+}
+
+// sendMail is smtp.SendMail with a deadline, as smtp.SendMail can block
+// forever on a server that stops responding
+func (s *SMTPSink) sendMail(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = DefaultSinkTimeout
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address %s: %w", addr, err)
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("SMTP server %s doesn't support AUTH", addr)
+		}
+		if err := c.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,348 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package logging builds the slog logger of opkssh from the logging section
+// of the server config
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Destinations of the log
+const (
+	DestinationStderr   = "stderr"
+	DestinationFile     = "file"
+	DestinationSyslog   = "syslog"
+	DestinationEventLog = "eventlog"
+)
+
+// Formats of the log lines
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options sets the logger built by New
+type Options struct {
+	// Level is debug, info (default), warn or error
+	Level string
+	// Format is text (default) or json
+	Format string
+	// Destinations are where the lines are written, default file
+	Destinations []string
+	// Path is the file of the file destination, default the opkssh log
+	// file the log package was writing to
+	Path string
+}
+
+// Sink delivers formatted lines to a destination
+type Sink interface {
+	Write(level slog.Level, line []byte) error
+	Close() error
+}
+
+// ParseLevel returns the level named s, info if s is empty
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return slog.LevelInfo, nil
+	case "warning":
+		return slog.LevelWarn, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// Validate returns an error if the level, format or a destination of o is
+// unknown
+func (o Options) Validate() error {
+	if _, err := ParseLevel(o.Level); err != nil {
+		return err
+	}
+	if o.Format != "" && o.Format != FormatText && o.Format != FormatJSON {
+		return fmt.Errorf("unknown log format %q, expected text or json", o.Format)
+	}
+	for _, destination := range o.Destinations {
+		switch destination {
+		case DestinationStderr, DestinationFile, DestinationSyslog, DestinationEventLog:
+		default:
+			return fmt.Errorf("unknown log destination %q, expected stderr, file, syslog or eventlog", destination)
+		}
+	}
+	return nil
+}
+
+// New returns a logger writing to the destinations of opts, and the closer
+// of the sinks it opened. base is the writer of the file destination when
+// opts.Path is empty.
+func New(opts Options, base io.Writer) (*slog.Logger, io.Closer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, err
+	}
+	level, _ := ParseLevel(opts.Level)
+	format := opts.Format
+	if format == "" {
+		format = FormatText
+	}
+	destinations := opts.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{DestinationFile}
+	}
+
+	sinks := sinkCloser{}
+	handlers := multiHandler{}
+	for _, destination := range destinations {
+		var sink Sink
+		var err error
+		switch destination {
+		case DestinationStderr:
+			sink = writerSink{w: os.Stderr}
+		case DestinationFile:
+			if opts.Path == "" {
+				sink = writerSink{w: base}
+			} else {
+				sink, err = openFile(opts.Path)
+			}
+		case DestinationSyslog:
+			sink, err = openSyslog()
+		case DestinationEventLog:
+			sink, err = openEventLog()
+		}
+		if err != nil {
+			_ = sinks.Close()
+			return nil, nil, err
+		}
+		sinks = append(sinks, sink)
+		handlers = append(handlers, newSinkHandler(sink, format, level))
+	}
+	if len(handlers) == 1 {
+		return slog.New(handlers[0]), sinks, nil
+	}
+	return slog.New(handlers), sinks, nil
+}
+
+var (
+	mu          sync.Mutex
+	base        io.Writer
+	baseFlags   int
+	baseDefault *slog.Logger
+)
+
+// Base returns the writer of the log package before the first Install,
+// the opkssh log file once main has opened it
+func Base() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	return saveBase()
+}
+
+func saveBase() io.Writer {
+	if base == nil {
+		base, baseFlags, baseDefault = log.Writer(), log.Flags(), slog.Default()
+	}
+	return base
+}
+
+// Install makes l the default slog logger. slog.SetDefault also redirects
+// the log package to it, at the info level.
+func Install(l *slog.Logger) {
+	mu.Lock()
+	defer mu.Unlock()
+	saveBase()
+	slog.SetDefault(l)
+}
+
+// Reset undoes Install, the log package writes to its writer again
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	if base == nil {
+		return
+	}
+	slog.SetDefault(baseDefault)
+	log.SetFlags(baseFlags)
+	log.SetOutput(base)
+	base, baseDefault = nil, nil
+}
+
+// Default returns a logger that writes to the default slog logger at the time
+// of each call, so it follows Install and Reset. Commands take it before the
+// logging section of the config is read.
+func Default() *slog.Logger {
+	return slog.New(defaultHandler{})
+}
+
+// defaultHandler hands records to the handler of the default slog logger
+type defaultHandler struct{}
+
+func (defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	return slog.Default().Handler().Handle(ctx, r)
+}
+
+func (defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return slog.Default().Handler().WithAttrs(attrs)
+}
+
+func (defaultHandler) WithGroup(name string) slog.Handler {
+	return slog.Default().Handler().WithGroup(name)
+}
+
+// sinkHandler formats records with a text or JSON handler and writes the
+// lines to a sink with their level
+type sinkHandler struct {
+	inner slog.Handler
+	out   *sinkWriter
+}
+
+type sinkWriter struct {
+	mu    sync.Mutex
+	sink  Sink
+	level slog.Level
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	return len(p), w.sink.Write(w.level, p)
+}
+
+func newSinkHandler(sink Sink, format string, level slog.Level) *sinkHandler {
+	out := &sinkWriter{sink: sink}
+	opts := &slog.HandlerOptions{Level: level}
+	var inner slog.Handler = slog.NewTextHandler(out, opts)
+	if format == FormatJSON {
+		inner = slog.NewJSONHandler(out, opts)
+	}
+	return &sinkHandler{inner: inner, out: out}
+}
+
+func (h *sinkHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sinkHandler) Handle(ctx context.Context, r slog.Record) error {
+	// The handler writes each record with a single Write
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.level = r.Level
+	return h.inner.Handle(ctx, r)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithAttrs(attrs), out: h.out}
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	return &sinkHandler{inner: h.inner.WithGroup(name), out: h.out}
+}
+
+// multiHandler writes every record to each of its handlers
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, len(m))
+	for i, h := range m {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
+
+// writerSink writes to a writer it does not close
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) Write(_ slog.Level, line []byte) error {
+	_, err := s.w.Write(line)
+	return err
+}
+
+func (s writerSink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	f *os.File
+}
+
+func openFile(path string) (*fileSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o660)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return &fileSink{f: f}, nil
+}
+
+func (s *fileSink) Write(_ slog.Level, line []byte) error {
+	_, err := s.f.Write(line)
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// sinkCloser closes the sinks opened by New
+type sinkCloser []Sink
+
+func (s sinkCloser) Close() error {
+	var errs []error
+	for _, sink := range s {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
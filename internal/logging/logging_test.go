@@ -0,0 +1,96 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{"": slog.LevelInfo, "debug": slog.LevelDebug, "WARN": slog.LevelWarn, "warning": slog.LevelWarn, "error": slog.LevelError} {
+		level, err := ParseLevel(s)
+		require.NoError(t, err, s)
+		require.Equal(t, want, level, s)
+	}
+	_, err := ParseLevel("verbose")
+	require.EqualError(t, err, `unknown log level "verbose", expected debug, info, warn or error`)
+}
+
+func TestNew(t *testing.T) {
+	_, _, err := New(Options{Format: "xml"}, nil)
+	require.EqualError(t, err, `unknown log format "xml", expected text or json`)
+	_, _, err = New(Options{Destinations: []string{"journald"}}, nil)
+	require.EqualError(t, err, `unknown log destination "journald", expected stderr, file, syslog or eventlog`)
+
+	path := filepath.Join(t.TempDir(), "opkssh.log")
+	base := &bytes.Buffer{}
+	logger, closer, err := New(Options{Level: "warn", Format: FormatJSON, Destinations: []string{DestinationFile}, Path: path}, base)
+	require.NoError(t, err)
+	logger.Info("not logged")
+	logger.With("user", "root").Warn("denied", "code", "no_policy")
+	require.NoError(t, closer.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(content, &line), string(content))
+	require.Equal(t, "WARN", line["level"])
+	require.Equal(t, "denied", line["msg"])
+	require.Equal(t, "root", line["user"])
+	require.Equal(t, "no_policy", line["code"])
+	require.Empty(t, base.String())
+}
+
+func TestInstall(t *testing.T) {
+	base := &bytes.Buffer{}
+	log.SetOutput(base)
+	defer log.SetOutput(os.Stderr)
+
+	// Default is taken before Install and still follows it
+	def := Default()
+	logger, _, err := New(Options{Level: "info"}, Base())
+	require.NoError(t, err)
+	require.Equal(t, base, Base())
+	Install(logger)
+
+	slog.Warn("Failed to watch", "path", "/etc/opk")
+	slog.Debug("not logged")
+	log.Println("Configuration loaded")
+	def.Info("Listening", "addr", "127.0.0.1:22")
+	def.Debug("not logged")
+	lines := strings.Split(strings.TrimSpace(base.String()), "\n")
+	require.Len(t, lines, 3, base.String())
+	require.Contains(t, lines[0], `level=WARN msg="Failed to watch" path=/etc/opk`)
+	require.Contains(t, lines[1], `level=INFO msg="Configuration loaded"`)
+	require.Contains(t, lines[2], `level=INFO msg=Listening addr=127.0.0.1:22`)
+
+	// Reset writes the plain lines of the log package again
+	Reset()
+	base.Reset()
+	log.Println("plain")
+	require.Contains(t, base.String(), "plain\n")
+	require.NotContains(t, base.String(), "level=")
+}
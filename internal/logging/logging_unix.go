@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"log/syslog"
+)
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func openSyslog() (Sink, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "opkssh")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(level slog.Level, line []byte) error {
+	switch {
+	case level >= slog.LevelError:
+		return s.w.Err(string(line))
+	case level >= slog.LevelWarn:
+		return s.w.Warning(string(line))
+	case level >= slog.LevelInfo:
+		return s.w.Info(string(line))
+	default:
+		return s.w.Debug(string(line))
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
+
+func openEventLog() (Sink, error) {
+	return nil, fmt.Errorf("log destination eventlog is only supported on Windows")
+}
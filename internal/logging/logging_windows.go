@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+
+	opkeventlog "github.com/openpubkey/opkssh/internal/eventlog"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// EventIDLog is the event ID of the log lines in the Windows Event Log
+const EventIDLog = 300
+
+type eventLogSink struct {
+	l *eventlog.Log
+}
+
+func openEventLog() (Sink, error) {
+	l, err := eventlog.Open(opkeventlog.Source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log source %s: %w", opkeventlog.Source, err)
+	}
+	return &eventLogSink{l: l}, nil
+}
+
+func (e *eventLogSink) Write(level slog.Level, line []byte) error {
+	switch {
+	case level >= slog.LevelError:
+		return e.l.Error(EventIDLog, string(line))
+	case level >= slog.LevelWarn:
+		return e.l.Warning(EventIDLog, string(line))
+	default:
+		return e.l.Info(EventIDLog, string(line))
+	}
+}
+
+func (e *eventLogSink) Close() error {
+	return e.l.Close()
+}
+
+func openSyslog() (Sink, error) {
+	return nil, fmt.Errorf("log destination syslog is not supported on Windows, use eventlog")
+}
@@ -0,0 +1,236 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics keeps counters and histograms in memory and writes them in
+// the Prometheus text exposition format, for the /metrics endpoint of
+// opkssh serve.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of a
+// histogram of durations
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Registry holds the metrics written by WriteText
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// metric is a counter or a histogram and its series, one for each set of
+// label values
+type metric struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	// sum is the value of a counter, or the sum of the observations of a
+	// histogram
+	sum float64
+	// counts are the observations in each bucket, not cumulative
+	counts []uint64
+	count  uint64
+}
+
+// Counter is a value that only goes up, with a series for each set of
+// label values
+type Counter struct {
+	r *Registry
+	m *metric
+}
+
+// Histogram counts observations, such as durations, in buckets
+type Histogram struct {
+	r *Registry
+	m *metric
+}
+
+func (r *Registry) add(m *metric) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.metrics {
+		if existing.name == m.name {
+			panic(fmt.Sprintf("metric %s is already registered", m.name))
+		}
+	}
+	m.series = map[string]*series{}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// NewCounter registers a counter with the label names labels
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{r: r, m: r.add(&metric{name: name, help: help, typ: "counter", labels: labels})}
+}
+
+// NewHistogram registers a histogram with the bucket upper bounds buckets,
+// in increasing order, and the label names labels
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r: r, m: r.add(&metric{name: name, help: help, typ: "histogram", labels: labels, buckets: buckets})}
+}
+
+// get returns the series of the label values, the caller holds r.mu
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.typ == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the series of the label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.m.get(values).sum += v
+}
+
+// Observe adds v to the series of the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.m.get(values)
+	s.sum += v
+	s.count++
+	for i, bound := range h.m.buckets {
+		if v <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+// The series of a metric are sorted by their label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, m := range r.metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.typ == "counter" {
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, labelSet(m.labels, s.values, "", ""), formatFloat(s.sum))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range m.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, labelSet(m.labels, s.values, "le", formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", m.name, labelSet(m.labels, s.values, "le", "+Inf"), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", m.name, labelSet(m.labels, s.values, "", ""), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", m.name, labelSet(m.labels, s.values, "", ""), s.count)
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics of r
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.WriteText(w)
+	})
+}
+
+// labelSet returns {name="value",...} with the extra label, if set, last
+func labelSet(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	logins := r.NewCounter("opkssh_logins_total", "Logins by result.\nOne line", "result", "issuer")
+	duration := r.NewHistogram("opkssh_duration_seconds", "How long it took", []float64{0.1, 1}, "issuer")
+	reloads := r.NewCounter("opkssh_reloads_total", `Reloads, a \ in the help`)
+
+	logins.Inc("deny", "https://b.example.com")
+	logins.Inc("allow", "https://a.example.com")
+	logins.Add(2, "allow", "https://a.example.com")
+	logins.Inc("deny", "say \"hi\"\\\n")
+	duration.Observe(0.05, "https://a.example.com")
+	duration.Observe(0.5, "https://a.example.com")
+	duration.Observe(3, "https://a.example.com")
+	reloads.Inc()
+
+	out := &bytes.Buffer{}
+	require.NoError(t, r.WriteText(out))
+	require.Equal(t, `# HELP opkssh_logins_total Logins by result.\nOne line
+# TYPE opkssh_logins_total counter
+opkssh_logins_total{result="allow",issuer="https://a.example.com"} 3
+opkssh_logins_total{result="deny",issuer="https://b.example.com"} 1
+opkssh_logins_total{result="deny",issuer="say \"hi\"\\\n"} 1
+# HELP opkssh_duration_seconds How long it took
+# TYPE opkssh_duration_seconds histogram
+opkssh_duration_seconds_bucket{issuer="https://a.example.com",le="0.1"} 1
+opkssh_duration_seconds_bucket{issuer="https://a.example.com",le="1"} 2
+opkssh_duration_seconds_bucket{issuer="https://a.example.com",le="+Inf"} 3
+opkssh_duration_seconds_sum{issuer="https://a.example.com"} 3.55
+opkssh_duration_seconds_count{issuer="https://a.example.com"} 3
+# HELP opkssh_reloads_total Reloads, a \\ in the help
+# TYPE opkssh_reloads_total counter
+opkssh_reloads_total 1
+`, out.String())
+
+	require.Panics(t, func() { logins.Inc("allow") })
+	require.Panics(t, func() { r.NewCounter("opkssh_reloads_total", "again") })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("opkssh_reloads_total", "Reloads").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	require.Contains(t, rec.Body.String(), "opkssh_reloads_total 1\n")
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
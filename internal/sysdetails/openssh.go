@@ -17,16 +17,94 @@
 package sysdetails
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"os/exec"
+	"regexp"
 	"strings"
+
+	"golang.org/x/mod/semver"
 )
 
+// PQKexAlgorithm is a post-quantum hybrid key exchange algorithm and the
+// first OpenSSH release that supports it under this name
+type PQKexAlgorithm struct {
+	Name       string
+	MinVersion string
+}
+
+// PQKexAlgorithms lists the post-quantum hybrid key exchange algorithms
+// supported by OpenSSH in order of preference
+var PQKexAlgorithms = []PQKexAlgorithm{
+	{Name: "mlkem768x25519-sha256", MinVersion: "v9.9"},
+	{Name: "sntrup761x25519-sha512", MinVersion: "v9.9"},
+	{Name: "sntrup761x25519-sha512@openssh.com", MinVersion: "v8.5"},
+}
+
+var (
+	opensshVersionRe = regexp.MustCompile(`OpenSSH[_a-zA-Z]*[_](\d+\.\d+)`)
+	anyVersionRe     = regexp.MustCompile(`(\d+\.\d+)`)
+)
+
+// ParseOpenSSHVersion extracts the major.minor version from the output of
+// GetOpenSSHVersion, e.g. "OpenSSH_9.5p1" or
+// "OpenSSH_for_Windows_9.5p2, LibreSSL 3.8.2" returns "v9.5". The version is
+// prefixed with 'v' so that it can be compared using semver.
+func ParseOpenSSHVersion(opensshVersion string) (string, error) {
+	// Ignore everything after the comma, e.g. the LibreSSL suffix on Windows
+	opensshVersion = strings.Split(opensshVersion, ",")[0]
+
+	matches := opensshVersionRe.FindStringSubmatch(opensshVersion)
+	if len(matches) < 2 {
+		matches = anyVersionRe.FindStringSubmatch(opensshVersion)
+		if len(matches) < 2 {
+			return "", fmt.Errorf("invalid OpenSSH version format: %s", opensshVersion)
+		}
+	}
+	return "v" + matches[1], nil
+}
+
+// OpenSSHVersionAtLeast returns true if the OpenSSH version opensshVersion,
+// as returned by GetOpenSSHVersion, is minVersion (e.g. "v8.1") or later
+func OpenSSHVersionAtLeast(opensshVersion string, minVersion string) (bool, error) {
+	version, err := ParseOpenSSHVersion(opensshVersion)
+	if err != nil {
+		return false, err
+	}
+	// OpenSSH doesn't use semantic versioning, but does use major.minor which
+	// after stripping the patch version can be compared using semver
+	return semver.Compare(version, minVersion) >= 0, nil
+}
+
+// SupportedPQKexAlgorithms returns the post-quantum hybrid key exchange
+// algorithms from PQKexAlgorithms supported by the OpenSSH version
+// opensshVersion. The names of the newer algorithms are preferred over older
+// aliases of the same key exchange.
+func SupportedPQKexAlgorithms(opensshVersion string) []string {
+	var algs []string
+	for _, kex := range PQKexAlgorithms {
+		if ok, err := OpenSSHVersionAtLeast(opensshVersion, kex.MinVersion); err == nil && ok {
+			algs = append(algs, kex.Name)
+		}
+	}
+	return algs
+}
+
+// GetSSHClientVersion returns the output of ssh -V, the version of the
+// OpenSSH client, or an empty string if ssh could not be run
+func GetSSHClientVersion() string {
+	output, err := exec.Command("ssh", "-V").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
 // getOpenSSHVersion attempts to get OpenSSH version using multiple fallback methods
 func GetOpenSSHVersion() string {
 	// OS-specific package manager queries
 	osType := DetectOS()
-	log.Printf("Attempting OS-specific version detection for: %s", osType)
+	slog.Debug("Attempting OS-specific OpenSSH version detection", "os", osType)
 
 	switch osType {
 	case OSTypeRHEL:
@@ -66,7 +144,7 @@ func GetOpenSSHVersion() string {
 		}
 
 	default:
-		log.Printf("Warning: Could not determine OpenSSH version using OS-specific methods for %s", osType)
+		slog.Warn("Could not determine the OpenSSH version using OS-specific methods", "os", osType)
 	}
 
 	// Try ssh -V (works on most systems)
@@ -75,7 +153,7 @@ func GetOpenSSHVersion() string {
 	if err == nil && len(strings.TrimSpace(string(output))) > 0 {
 		return strings.TrimSpace(string(output))
 	}
-	log.Println("Warning: Error executing ssh -V:", err)
+	slog.Warn("Failed to run ssh -V", "error", err)
 
 	// Try sshd -V as fallback
 	cmd = exec.Command("sshd", "-V")
@@ -83,7 +161,7 @@ func GetOpenSSHVersion() string {
 	if err == nil && len(strings.TrimSpace(string(output))) > 0 {
 		return strings.TrimSpace(string(output))
 	}
-	log.Println("Warning: Error executing sshd -V:", err)
+	slog.Warn("Failed to run sshd -V", "error", err)
 
 	return ""
 }
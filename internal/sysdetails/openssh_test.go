@@ -0,0 +1,56 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package sysdetails
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOpenSSHVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "OpenSSH_9.5p1", want: "v9.5"},
+		{input: "OpenSSH_for_Windows_9.5p2, LibreSSL 3.8.2", want: "v9.5"},
+		{input: "OpenSSH_10.0p2 Debian-5, OpenSSL 3.5.1 1 Jul 2025", want: "v10.0"},
+		{input: "OpenSSH_, something not right", wantErr: true},
+		{input: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseOpenSSHVersion(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSupportedPQKexAlgorithms(t *testing.T) {
+	require.Empty(t, SupportedPQKexAlgorithms("OpenSSH_8.4"))
+	require.Empty(t, SupportedPQKexAlgorithms(""))
+	require.Equal(t, []string{"sntrup761x25519-sha512@openssh.com"}, SupportedPQKexAlgorithms("OpenSSH_9.6p1"))
+	require.Equal(t, []string{"mlkem768x25519-sha256", "sntrup761x25519-sha512", "sntrup761x25519-sha512@openssh.com"},
+		SupportedPQKexAlgorithms("OpenSSH_10.0p2"))
+}
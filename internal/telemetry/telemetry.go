@@ -0,0 +1,302 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package telemetry counts which commands are run, how often they fail and
+// where opkssh crashes, and periodically sends the counts to an endpoint
+// chosen by the administrator. Counting only writes a local file, the
+// counts are sent by Flush, which verify never calls. It is only used when telemetry is enabled in
+// the config. Reports never contain arguments, identities, hostnames or
+// panic messages.
+package telemetry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// DefaultInterval is how often a report is sent if no interval is configured
+const DefaultInterval = 24 * time.Hour
+
+// lockTimeout is how long a command waits for another one counting. The
+// counts of a command that gives up are lost, rather than delaying it.
+const lockTimeout = 2 * time.Second
+
+// maxCrashFrames is the number of stack frames kept in a crash signature
+const maxCrashFrames = 8
+
+// Report is the document sent to the endpoint. The same structure holds the
+// counts between reports.
+type Report struct {
+	Version string    `json:"version"`
+	OS      string    `json:"os"`
+	Arch    string    `json:"arch"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until,omitzero"`
+	// Features counts the runs of each command, e.g. "permissions fix"
+	Features map[string]int `json:"features,omitempty"`
+	// Errors counts the runs of each command that failed
+	Errors map[string]int `json:"errors,omitempty"`
+	// Crashes is keyed by crash signature
+	Crashes map[string]*Crash `json:"crashes,omitempty"`
+}
+
+// Crash is a panic, identified by the type of the panic value and the
+// functions on the stack
+type Crash struct {
+	Type   string   `json:"type"`
+	Frames []string `json:"frames"`
+	Count  int      `json:"count"`
+}
+
+// Recorder adds to the counts in the state file at Path, and Flush sends
+// them to Endpoint once Interval has passed since the last report
+type Recorder struct {
+	Fs         afero.Fs
+	Path       string
+	Endpoint   string
+	Interval   time.Duration
+	Version    string
+	HttpClient *http.Client
+	Now        func() time.Time
+}
+
+// NewRecorder creates a Recorder. interval is a duration such as 24h, empty
+// means DefaultInterval.
+func NewRecorder(fsys afero.Fs, path string, endpoint string, interval string, version string) (*Recorder, error) {
+	if endpoint == "" {
+		return nil, fmt.Errorf("telemetry endpoint must be set when telemetry is enabled")
+	}
+	if !strings.HasPrefix(endpoint, "https://") && !strings.HasPrefix(endpoint, "http://") {
+		return nil, fmt.Errorf("telemetry endpoint %q must be an http or https URL", endpoint)
+	}
+	r := &Recorder{
+		Fs:         fsys,
+		Path:       path,
+		Endpoint:   endpoint,
+		Interval:   DefaultInterval,
+		Version:    version,
+		HttpClient: &http.Client{Timeout: 5 * time.Second},
+		Now:        time.Now,
+	}
+	if interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid telemetry interval %q", interval)
+		}
+		r.Interval = d
+	}
+	return r, nil
+}
+
+// Record counts a run of feature, and a failure if err is not nil
+func (r *Recorder) Record(feature string, err error) error {
+	return r.update(func(report *Report) {
+		report.Features[feature]++
+		if err != nil {
+			report.Errors[feature]++
+		}
+	})
+}
+
+// RecordCrash counts a panic with value v and the stack from debug.Stack
+func (r *Recorder) RecordCrash(v any, stack []byte) error {
+	crash := &Crash{Type: fmt.Sprintf("%T", v), Frames: CrashFrames(stack)}
+	signature := CrashSignature(crash)
+	return r.update(func(report *Report) {
+		if c, ok := report.Crashes[signature]; ok {
+			c.Count++
+			return
+		}
+		crash.Count = 1
+		report.Crashes[signature] = crash
+	})
+}
+
+// update applies fn to the saved counts. It doesn't send them, so that
+// counting never waits for the endpoint.
+func (r *Recorder) update(fn func(*Report)) error {
+	lock, err := files.LockFile(r.Fs, r.Path, lockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock telemetry state: %w", err)
+	}
+	defer lock.Unlock()
+	report, err := r.load(r.Now().UTC())
+	if err != nil {
+		return err
+	}
+	fn(report)
+	return r.save(report)
+}
+
+// Flush sends the saved counts if a report is due. The counts start over
+// before sending, the state file is not locked while the endpoint is slow,
+// and counts that could not be sent are added back for the next report.
+func (r *Recorder) Flush() error {
+	now := r.Now().UTC()
+	lock, err := files.LockFile(r.Fs, r.Path, lockTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to lock telemetry state: %w", err)
+	}
+	report, err := r.load(now)
+	if err == nil && now.Sub(report.Since) >= r.Interval {
+		err = r.save(r.newReport(now))
+	} else {
+		report = nil
+	}
+	lock.Unlock()
+	if err != nil || report == nil {
+		return err
+	}
+
+	report.Until = now
+	sendErr := r.send(report)
+	if sendErr == nil {
+		return nil
+	}
+	return errors.Join(sendErr, r.update(func(current *Report) {
+		current.Since = report.Since
+		for feature, n := range report.Features {
+			current.Features[feature] += n
+		}
+		for feature, n := range report.Errors {
+			current.Errors[feature] += n
+		}
+		for signature, c := range report.Crashes {
+			if saved, ok := current.Crashes[signature]; ok {
+				saved.Count += c.Count
+			} else {
+				current.Crashes[signature] = c
+			}
+		}
+	}))
+}
+
+func (r *Recorder) newReport(now time.Time) *Report {
+	return &Report{
+		Version:  r.Version,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Since:    now,
+		Features: map[string]int{},
+		Errors:   map[string]int{},
+		Crashes:  map[string]*Crash{},
+	}
+}
+
+func (r *Recorder) load(now time.Time) (*Report, error) {
+	data, err := afero.ReadFile(r.Fs, r.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return r.newReport(now), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read telemetry state: %w", err)
+	}
+	report := r.newReport(now)
+	if err := json.Unmarshal(data, report); err != nil {
+		// Start over rather than failing every command
+		return r.newReport(now), nil
+	}
+	// The counts are reported for the version that is running now
+	report.Version, report.OS, report.Arch = r.Version, runtime.GOOS, runtime.GOARCH
+	for _, m := range []*map[string]int{&report.Features, &report.Errors} {
+		if *m == nil {
+			*m = map[string]int{}
+		}
+	}
+	if report.Crashes == nil {
+		report.Crashes = map[string]*Crash{}
+	}
+	return report, nil
+}
+
+func (r *Recorder) save(report *Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := r.Fs.MkdirAll(filepath.Dir(r.Path), 0o750); err != nil {
+		return fmt.Errorf("failed to save telemetry state: %w", err)
+	}
+	// The state is replaced rather than written in place, so that verify
+	// can update a file written by a command run as root. It only has
+	// counts, the directory restricts who can read it.
+	if err := files.WriteFileAtomic(r.Fs, r.Path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to save telemetry state: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) send(report *Report) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := r.HttpClient.Post(r.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send telemetry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to send telemetry: endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// CrashFrames returns the functions on a stack from debug.Stack, innermost
+// first, without arguments or file paths. When the stack was taken while
+// recovering, the frames of the recovering function are dropped.
+func CrashFrames(stack []byte) []string {
+	var frames []string
+	for _, line := range strings.Split(string(stack), "\n") {
+		if line == "" || strings.HasPrefix(line, "\t") || strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		fn := line
+		if i := strings.LastIndex(fn, "("); i > 0 {
+			fn = fn[:i]
+		}
+		if fn == "panic" {
+			frames = nil
+			continue
+		}
+		if strings.HasPrefix(fn, "runtime.") || strings.HasPrefix(fn, "runtime/debug.") {
+			continue
+		}
+		frames = append(frames, fn)
+	}
+	if len(frames) > maxCrashFrames {
+		frames = frames[:maxCrashFrames]
+	}
+	return frames
+}
+
+// CrashSignature identifies crashes with the same panic type and stack
+func CrashSignature(c *Crash) string {
+	sum := sha256.Sum256([]byte(c.Type + "\n" + strings.Join(c.Frames, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
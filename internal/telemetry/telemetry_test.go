@@ -0,0 +1,164 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecorder(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	r, err := NewRecorder(fs, "/var/lib/opk/telemetry.json", "https://telemetry.example.com", "", "v1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, DefaultInterval, r.Interval)
+
+	r, err = NewRecorder(fs, "/var/lib/opk/telemetry.json", "https://telemetry.example.com", "1h", "v1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, r.Interval)
+
+	_, err = NewRecorder(fs, "/var/lib/opk/telemetry.json", "", "", "v1.0.0")
+	require.ErrorContains(t, err, "endpoint must be set")
+	_, err = NewRecorder(fs, "/var/lib/opk/telemetry.json", "telemetry.example.com", "", "v1.0.0")
+	require.ErrorContains(t, err, "must be an http or https URL")
+	_, err = NewRecorder(fs, "/var/lib/opk/telemetry.json", "https://telemetry.example.com", "-1h", "v1.0.0")
+	require.ErrorContains(t, err, "invalid telemetry interval")
+}
+
+func TestRecorder(t *testing.T) {
+	var received []Report
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&report))
+		received = append(received, report)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	fs := afero.NewMemMapFs()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, err := NewRecorder(fs, "/var/lib/opk/telemetry.json", server.URL, "24h", "v1.0.0")
+	require.NoError(t, err)
+	r.Now = func() time.Time { return now }
+
+	require.NoError(t, r.Record("verify", nil))
+	require.NoError(t, r.Record("verify", errors.New("denied alice@example.com")))
+	now = now.Add(time.Hour)
+	require.NoError(t, r.Record("permissions fix", nil))
+	require.NoError(t, r.Flush())
+	require.Empty(t, received)
+
+	// Counting never sends, even when a report is due
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, r.Record("verify", nil))
+	require.Empty(t, received)
+
+	// The endpoint is down, the counts are kept
+	status = http.StatusInternalServerError
+	require.ErrorContains(t, r.Flush(), "endpoint returned 500")
+	require.Len(t, received, 1)
+
+	status = http.StatusNoContent
+	now = now.Add(time.Minute)
+	require.NoError(t, r.Record("audit", nil))
+	require.NoError(t, r.Flush())
+	require.Len(t, received, 2)
+	report := received[1]
+	require.Equal(t, "v1.0.0", report.Version)
+	require.Equal(t, runtime.GOOS, report.OS)
+	require.Equal(t, runtime.GOARCH, report.Arch)
+	require.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), report.Since)
+	require.Equal(t, now, report.Until)
+	require.Equal(t, map[string]int{"verify": 3, "permissions fix": 1, "audit": 1}, report.Features)
+	require.Equal(t, map[string]int{"verify": 1}, report.Errors)
+
+	// Nothing identifying is sent
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "alice")
+
+	// The counts start over after a report
+	require.NoError(t, r.Record("verify", nil))
+	state, err := afero.ReadFile(fs, "/var/lib/opk/telemetry.json")
+	require.NoError(t, err)
+	var saved Report
+	require.NoError(t, json.Unmarshal(state, &saved))
+	require.Equal(t, now, saved.Since)
+	require.Equal(t, map[string]int{"verify": 1}, saved.Features)
+	require.NoError(t, r.Flush())
+	require.Len(t, received, 2)
+}
+
+func TestRecordCrash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	r, err := NewRecorder(fs, "/var/lib/opk/telemetry.json", "https://telemetry.example.com", "", "v1.0.0")
+	require.NoError(t, err)
+
+	crash := func() {
+		defer func() {
+			v := recover()
+			require.NoError(t, r.RecordCrash(v, debug.Stack()))
+		}()
+		var m map[string]int
+		m["alice@example.com"] = 1
+	}
+	crash()
+	crash()
+
+	state, err := afero.ReadFile(fs, "/var/lib/opk/telemetry.json")
+	require.NoError(t, err)
+	require.NotContains(t, string(state), "alice")
+	var saved Report
+	require.NoError(t, json.Unmarshal(state, &saved))
+	require.Len(t, saved.Crashes, 1)
+	for signature, c := range saved.Crashes {
+		require.Equal(t, CrashSignature(c), signature)
+		require.Equal(t, 2, c.Count)
+		require.Equal(t, "runtime.plainError", c.Type)
+		require.True(t, strings.HasPrefix(c.Frames[0], "github.com/openpubkey/opkssh/internal/telemetry.TestRecordCrash"), c.Frames[0])
+		require.LessOrEqual(t, len(c.Frames), maxCrashFrames)
+	}
+}
+
+func TestCrashFrames(t *testing.T) {
+	stack := "goroutine 1 [running]:\n" +
+		"runtime/debug.Stack()\n" +
+		"\t/usr/local/go/src/runtime/debug/stack.go:26 +0x5e\n" +
+		"main.run.func1()\n" +
+		"\t/src/opkssh/main.go:68 +0x45\n" +
+		"panic({0x1, 0x2})\n" +
+		"\t/usr/local/go/src/runtime/panic.go:792 +0x132\n" +
+		"github.com/openpubkey/opkssh/commands.(*VerifyCmd).AuthorizedKeysCommand(0xc000, {0x3, 0x4})\n" +
+		"\t/src/opkssh/commands/verify.go:140 +0x10\n" +
+		"main.main()\n" +
+		"\t/src/opkssh/main.go:58 +0x13\n"
+	require.Equal(t, []string{
+		"github.com/openpubkey/opkssh/commands.(*VerifyCmd).AuthorizedKeysCommand",
+		"main.main",
+	}, CrashFrames([]byte(stack)))
+}
@@ -21,27 +21,33 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
-	"regexp"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/openpubkey/opkssh/commands"
 	config "github.com/openpubkey/opkssh/commands/config"
+	"github.com/openpubkey/opkssh/internal/eventlog"
+	"github.com/openpubkey/opkssh/internal/events"
 	"github.com/openpubkey/opkssh/internal/sysdetails"
+	"github.com/openpubkey/opkssh/internal/telemetry"
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/openpubkey/opkssh/policy/files"
-	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
 	"github.com/thediveo/enumflag/v2"
-	"golang.org/x/mod/semver"
 	"golang.org/x/term"
 )
 
@@ -56,6 +62,16 @@ func main() {
 }
 
 func run() int {
+	rt := commands.NewRuntime()
+	defer func() {
+		if r := recover(); r != nil {
+			if recorder := newTelemetryRecorder(rt); recorder != nil {
+				_ = recorder.RecordCrash(r, debug.Stack())
+			}
+			panic(r)
+		}
+	}()
+
 	rootCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "opkssh",
@@ -75,6 +91,8 @@ This program allows users to:
 	}
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 
+	var proposeArg bool
+	var expiryArg string
 	addCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "add <principal> <email|sub|group> <issuer>",
@@ -83,6 +101,10 @@ This program allows users to:
 
 It first attempts to write to the system-wide file (/etc/opk/auth_id). If it lacks permissions to update this file it falls back to writing to the user-specific file (~/.opk/auth_id).
 
+Principals listed under dual_control in the server config can only be added to the system-wide file with --propose. The proposed change is applied once a different admin runs "opkssh approve <id>".
+
+With --expiry the entry stops allowing logins after the given date or time. Expired entries are skipped by verify and can be left in the file.
+
 Arguments:
   principal            The target user account (requested principal).
   email|sub|group      Email address, subscriber ID or group authorized to assume this principal. If using an OIDC group, the argument needs to be in the format of oidc:groups:<groupId>.
@@ -91,40 +113,82 @@ Arguments:
 		Args: cobra.ExactArgs(3),
 		Example: `  opkssh add root alice@example.com https://accounts.google.com
   opkssh add alice 103030642802723203118 https://accounts.google.com
-  opkssh add developer oidc:groups:developer https://accounts.google.com`,
+  opkssh add developer oidc:groups:developer https://accounts.google.com
+  sudo opkssh add root alice@example.com google --propose
+  opkssh add alice alice@example.com google --expiry 2025-12-31`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			inputPrincipal := args[0]
 			inputEmail := args[1]
-			inputIssuer := args[2]
+			inputIssuer := expandIssuerAlias(args[2])
 
-			// Convenience aliases to save user time (who is going to remember the hideous Azure issuer string)
-			switch inputIssuer {
-			case "google":
-				inputIssuer = "https://accounts.google.com"
-			case "azure", "microsoft":
-				inputIssuer = "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0"
-			case "gitlab":
-				inputIssuer = "https://gitlab.com"
-			case "hello":
-				inputIssuer = "https://issuer.hello.coop"
+			add, err := newAdminAddCmd(rt, inputPrincipal)
+			if err != nil {
+				return err
 			}
-
-			add := commands.AddCmd{
-				HomePolicyLoader:   policy.NewHomePolicyLoader(),
-				SystemPolicyLoader: policy.NewSystemPolicyLoader(),
-				Username:           inputPrincipal,
+			if expiryArg != "" {
+				expires, err := policy.ParseExpiry(expiryArg)
+				if err != nil {
+					return err
+				}
+				if !expires.After(time.Now()) {
+					return fmt.Errorf("expiry %s is in the past", expiryArg)
+				}
+				add.Expires = expires
+			}
+			if proposeArg {
+				change, err := add.Propose(inputPrincipal, inputEmail, inputIssuer)
+				if err != nil {
+					fmt.Fprintf(rt.ErrOut, "Failed to propose policy change: %v\n", err)
+					return err
+				}
+				fmt.Fprintf(rt.Out, "Proposed change %s, a different admin must run: opkssh approve %s\n", change.ID, change.ID)
+				return nil
 			}
 			policyFilePath, err := add.Run(inputPrincipal, inputEmail, inputIssuer)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to add to policy: %v\n", err)
+				fmt.Fprintf(rt.ErrOut, "Failed to add to policy: %v\n", err)
 				return err
 			}
-			fmt.Fprintf(os.Stdout, "Successfully added new policy to %s\n", policyFilePath)
+			fmt.Fprintf(rt.Out, "Successfully added new policy to %s\n", policyFilePath)
 			return nil
 		},
 	}
+	addCmd.Flags().BoolVar(&proposeArg, "propose", false, "Stage the change for approval by a different admin instead of applying it")
+	addCmd.Flags().StringVar(&expiryArg, "expiry", "", "Date (2025-12-31, allowed until the end of that day in UTC) or RFC 3339 time after which the entry no longer allows logins")
 	rootCmd.AddCommand(addCmd)
 
+	var listPendingArg bool
+	approveCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "approve <id>",
+		Short:        "Approve a policy change proposed with add --propose",
+		Long: `Approve applies a policy change staged with "opkssh add --propose". The change must be approved by a different admin than the one that proposed it. When run with sudo, the admin is identified by the user that logged in, not the SUDO_USER environment variable.
+
+Run without an id, or with --list, to show the changes awaiting approval.`,
+		Args: cobra.MaximumNArgs(1),
+		Example: `  sudo opkssh approve --list
+  sudo opkssh approve 3f2a9c1b0d4e5f67`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			add, err := newAdminAddCmd(rt, "")
+			if err != nil {
+				return err
+			}
+			approve := commands.ApproveCmd{Add: add, Out: rt.Out}
+			if listPendingArg || len(args) == 0 {
+				return approve.List()
+			}
+			policyFilePath, err := approve.Run(args[0])
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Failed to approve change: %v\n", err)
+				return err
+			}
+			fmt.Fprintf(rt.Out, "Successfully approved %s and updated %s\n", args[0], policyFilePath)
+			return nil
+		},
+	}
+	approveCmd.Flags().BoolVar(&listPendingArg, "list", false, "List changes awaiting approval")
+	rootCmd.AddCommand(approveCmd)
+
 	inspectCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "inspect <path>",
@@ -132,9 +196,9 @@ Arguments:
 		Example:      "  opkssh inspect ~/.ssh/id_ecdsa_sk-cert.pub",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			keyPathArg := args[0]
-			inspect := commands.NewInspectCmd(keyPathArg, cmd.OutOrStdout())
+			inspect := commands.NewInspectCmd(keyPathArg, rt.Out)
 			if err := inspect.Run(); err != nil {
-				log.Println("Error executing inspect command:", err)
+				slog.Error("Failed to run the inspect command", "error", err)
 				return err
 			}
 			return nil
@@ -158,6 +222,13 @@ Arguments:
 	var keyPathArg string
 	var keyTypeArg commands.KeyType
 	var remoteRedirectURIArg string
+	var principalsArg []string
+	var validityArg time.Duration
+	var listProvidersArg bool
+	var deviceFlowArg bool
+	var writeToAgentArg bool
+	var storeTokenArg bool
+	var keyBackendArg commands.KeyBackend
 
 	loginCmd := &cobra.Command{
 		SilenceUsage: true,
@@ -169,10 +240,15 @@ Login generates a key pair, then opens a browser to authenticate the user with t
 
 Users can then SSH into servers configured to use opkssh as the AuthorizedKeysCommand. The server verifies the PK token and grants access if the token is valid and the user is authorized per the auth_id policy.
 Arguments:
-  alias      The provider alias to use. If not specified, the OPKSSH_DEFAULT provider will be used. The aliases are defined by the OPKSSH_PROVIDERS environment variable. The format is <alias>,<issuer>,<client_id>,<client_secret>,<scopes>
+  alias      The provider alias to use. If not specified, the OPKSSH_DEFAULT provider will be used. The aliases are defined by the OPKSSH_PROVIDERS environment variable, or by the providers of the client config if it is not set. The format is <alias>,<issuer>,<client_id>,<client_secret>,<scopes>
 `,
 		Example: `  opkssh login
   opkssh login google
+  opkssh login --provider work
+  opkssh login --list-providers
+  opkssh login --provider work --device-flow
+  opkssh login --write-to-agent
+  opkssh login --key-backend tpm
   opkssh login --provider=<issuer>,<client_id>,<client_secret>,<scopes>`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -193,11 +269,21 @@ Arguments:
 				inspectCertArg = true
 			}
 
-			login := commands.NewLogin(autoRefreshArg, configPathArg, createConfigArg, configureArg, logDirArg,
+			login := commands.NewLogin(rt, autoRefreshArg, configPathArg, createConfigArg, configureArg, logDirArg,
 				sendAccessTokenArg, disableBrowserOpenArg, printIdTokenArg, providerArg, printKeyArg, keyPathArg,
 				providerAliasArg, keyTypeArg, remoteRedirectURIArg, inspectCertArg)
+			login.PrincipalsArg = principalsArg
+			login.ValidityArg = validityArg
+			login.ListProvidersArg = listProvidersArg
+			login.DeviceFlowArg = deviceFlowArg
+			login.WriteToAgentArg = writeToAgentArg
+			login.KeyBackendArg = keyBackendArg
+			login.StoreTokenArg = storeTokenArg
+			if store, err := commands.NewTokenStore(rt.Fs); err == nil {
+				login.TokenStore = store
+			}
 			if err := login.Run(ctx); err != nil {
-				log.Println("Error executing login command:", err)
+				slog.Error("Failed to run the login command", "error", err)
 				return err
 			}
 			return nil
@@ -206,21 +292,28 @@ Arguments:
 	}
 
 	// Define flags for login.
-	loginCmd.Flags().BoolVar(&autoRefreshArg, "auto-refresh", false, "Automatically refresh PK token after login")
-	loginCmd.Flags().StringVar(&configPathArg, "config-path", "", "Path to the client config file. Default: ~/.opk/config.yml on linux and %APPDATA%\\.opk\\config.yml on windows")
+	loginCmd.Flags().BoolVar(&autoRefreshArg, "auto-refresh", false, "Keep running after login and refresh the PK token and SSH cert before they expire, also in ssh-agent if it holds the key. Use opkssh launch-agent install to run it in the background")
+	loginCmd.Flags().StringVar(&configPathArg, "config-path", "", "Path to the client config file. Default: ~/.opk/config.yml on linux, ~/Library/Application Support/opkssh/config.yml on macOS and %APPDATA%\\.opk\\config.yml on windows")
 	loginCmd.Flags().BoolVar(&createConfigArg, "create-config", false, "Creates a client config file if it does not exist")
 	loginCmd.Flags().BoolVar(&configureArg, "configure", false, "Apply changes to ssh config and create ~/.ssh/opkssh directory")
 	loginCmd.Flags().StringVar(&logDirArg, "log-dir", "", "Directory to write output logs")
 	loginCmd.Flags().BoolVar(&disableBrowserOpenArg, "disable-browser-open", false, "Set this flag to disable opening the browser. Useful for choosing the browser you want to use")
 	loginCmd.Flags().BoolVar(&printIdTokenArg, "print-id-token", false, "Set this flag to print out the contents of the id_token. Useful for inspecting claims")
 	loginCmd.Flags().BoolVar(&sendAccessTokenArg, "send-access-token", false, "Set this flag to send the Access Token as well as the PK Token in the SSH cert. The Access Token is used to call the userinfo endpoint to get claims not included in the ID Token")
-	loginCmd.Flags().StringVar(&providerArg, "provider", "", "OpenID Provider specification in the format: <issuer>,<client_id> or <issuer>,<client_id>,<client_secret> or <issuer>,<client_id>,<client_secret>,<scopes>")
+	loginCmd.Flags().StringVar(&providerArg, "provider", "", "The alias of a provider in the client config, or an OpenID Provider specification in the format: <issuer>,<client_id> or <issuer>,<client_id>,<client_secret> or <issuer>,<client_id>,<client_secret>,<scopes>")
+	loginCmd.Flags().BoolVar(&deviceFlowArg, "device-flow", false, "Log in by entering a code on another device, with the OAuth device authorization grant, instead of a browser on this machine. The provider must support it and copy the nonce into the ID Token")
+	loginCmd.Flags().BoolVar(&listProvidersArg, "list-providers", false, "List the providers of the client config that can be chosen with --provider, and the default provider")
 	loginCmd.Flags().BoolVarP(&printKeyArg, "print-key", "p", false, "Print the raw private key and SSH cert to stdout instead of writing them to the filesystem")
+	loginCmd.Flags().BoolVar(&writeToAgentArg, "write-to-agent", false, "Also add the SSH key and cert to the running ssh-agent (SSH_AUTH_SOCK, or the OpenSSH agent service on windows). The agent forgets the key when the ID Token or the SSH cert expires")
+	loginCmd.Flags().BoolVar(&storeTokenArg, "store-token", false, "Save the PK Token, and the access token with --send-access-token, for opkssh whoami: in the login Keychain on macOS and in ~/.opk/token.json elsewhere. Also set by store_token in the client config")
 	loginCmd.Flags().BoolVar(&inspectCertArg, "inspect-cert", false, "Print a human-readable inspection of the generated SSH certificate (public information only)")
 	loginCmd.Flags().BoolVarP(&verboseArg, "verbose", "v", false, "Enable verbose output")
 	loginCmd.Flags().StringVarP(&keyPathArg, "private-key-file", "i", "", "Path where private keys is written")
+	loginCmd.Flags().DurationVar(&validityArg, "validity", 0, "How long the SSH cert is valid, e.g. 1h. It can't be longer than max_validity for the provider in the client config (1 week by default), and servers still reject it once their expiration policy is exceeded")
+	loginCmd.Flags().StringSliceVar(&principalsArg, "principals", nil, "Comma separated principals to write to the SSH cert, for SSH proxies and bastions that check certificate principals. By default the cert is valid for any principal")
 	loginCmd.Flags().StringVar(&remoteRedirectURIArg, "remote-redirect-uri", "", "Remote redirect URI used for non-localhost redirects. This is an advanced option for embedding opkssh in server-side logic.")
 	loginCmd.Flags().VarP(enumflag.New(&keyTypeArg, "Key Type", map[commands.KeyType][]string{commands.ECDSA: {commands.ECDSA.String()}, commands.ED25519: {commands.ED25519.String()}}, enumflag.EnumCaseInsensitive), "key-type", "t", "Type of key to generate")
+	loginCmd.Flags().Var(enumflag.New(&keyBackendArg, "Key Backend", map[commands.KeyBackend][]string{commands.SoftwareKey: {commands.SoftwareKey.String()}, commands.TPMKey: {commands.TPMKey.String()}}, enumflag.EnumCaseInsensitive), "key-backend", "Where to generate the key pair: software, or tpm to keep the private key in the TPM (Windows, or Linux with tpm2-tools). opkssh then serves the key to ssh while it runs")
 	rootCmd.AddCommand(loginCmd)
 
 	var logoutKeyPathArg string
@@ -238,12 +331,15 @@ Use the -i flag to remove a specific key pair.`,
   opkssh logout -i ~/.ssh/id_ecdsa`,
 		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			logout := commands.NewLogoutCmd(logoutKeyPathArg)
+			logout := commands.NewLogoutCmd(rt, logoutKeyPathArg)
 			if logoutVerboseArg {
 				logout.Verbosity = 1
 			}
+			if store, err := commands.NewTokenStore(rt.Fs); err == nil {
+				logout.TokenStore = store
+			}
 			if err := logout.Run(); err != nil {
-				log.Println("Error executing logout command:", err)
+				slog.Error("Failed to run the logout command", "error", err)
 				return err
 			}
 			return nil
@@ -253,6 +349,63 @@ Use the -i flag to remove a specific key pair.`,
 	logoutCmd.Flags().BoolVarP(&logoutVerboseArg, "verbose", "v", false, "Print verbose output to stderr")
 	rootCmd.AddCommand(logoutCmd)
 
+	whoamiCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "whoami",
+		Short:        "Show the identity and expiry of the most recent login saved with login --store-token",
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := commands.NewTokenStore(rt.Fs)
+			if err != nil {
+				return err
+			}
+			return commands.NewWhoamiCmd(rt, store).Run()
+		},
+	}
+	rootCmd.AddCommand(whoamiCmd)
+
+	launchAgentCmd := &cobra.Command{
+		Use:   "launch-agent",
+		Short: "Manage the login agent that keeps the SSH certificate fresh",
+		Long: `Manage a user agent that runs "opkssh login --auto-refresh" when you log in, so the SSH certificate is renewed with the refresh token before the ID token expires. If the key is in your ssh-agent, the agent is given the renewed certificate too.
+
+The agent is a launchd user agent on macOS, a systemd user service on Linux and an entry in the Run key of your user on Windows.
+
+Arguments after -- are passed to opkssh login.`,
+		Args: cobra.NoArgs,
+	}
+	newLaunchAgent := func(cmd *cobra.Command, args []string) (*commands.LaunchAgentCmd, error) {
+		return commands.NewLaunchAgentCmd(rt, args)
+	}
+	launchAgentCmd.AddCommand(&cobra.Command{
+		SilenceUsage: true,
+		Use:          "install [-- login arguments]",
+		Short:        "Install and load the launch agent",
+		Example: `  opkssh launch-agent install
+  opkssh launch-agent install -- google --key-type ed25519`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			agent, err := newLaunchAgent(cmd, args)
+			if err != nil {
+				return err
+			}
+			return agent.Install()
+		},
+	})
+	launchAgentCmd.AddCommand(&cobra.Command{
+		SilenceUsage: true,
+		Use:          "uninstall",
+		Short:        "Unload and remove the launch agent",
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			agent, err := newLaunchAgent(cmd, nil)
+			if err != nil {
+				return err
+			}
+			return agent.Uninstall()
+		},
+	})
+	rootCmd.AddCommand(launchAgentCmd)
+
 	readhomeCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "readhome <principal>",
@@ -266,10 +419,10 @@ You should not call this command directly. It is called by the opkssh verify com
 		RunE: func(cmd *cobra.Command, args []string) error {
 			userArg := os.Args[2]
 			if fileBytes, err := commands.ReadHome(userArg); err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to read user's home policy file: %v\n", err)
+				fmt.Fprintf(rt.ErrOut, "Failed to read user's home policy file: %v\n", err)
 				return err
 			} else {
-				fmt.Fprint(os.Stdout, string(fileBytes))
+				fmt.Fprint(rt.Out, string(fileBytes))
 				return nil
 			}
 		},
@@ -277,6 +430,10 @@ You should not call this command directly. It is called by the opkssh verify com
 	rootCmd.AddCommand(readhomeCmd)
 
 	var serverConfigPathArg string
+	var connectionArg string
+	var verifySocketArg string
+	var viaSocketArg bool
+	var explainArg bool
 	verifyCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "verify <principal> <cert> <key_type>",
@@ -302,12 +459,20 @@ Verification checks performed:
 
 If all checks pass, Verify authorizes the SSH connection.
 
+With --explain, verify checks the login and prints each step: the provider that verified the PK Token, the claims of the ID Token, the policy files read, why each policy entry matched or not and what the policy plugins returned. Nothing is recorded, no audit record, rate limit failure, replay cache entry or account is written, and opkssh serve is not used. The cert can then be the path to the user's SSH certificate file, e.g. ~/.ssh/id_ecdsa-cert.pub, without key_type.
+
 Arguments:
   principal    Target username.
   cert         Base64-encoded SSH certificate.
   key_type     SSH certificate key type (e.g., ecdsa-sha2-nistp256-cert-v01@openssh.com)`,
-		Args:    cobra.MinimumNArgs(3),
-		Example: `  opkssh verify root <base64-encoded-cert> ecdsa-sha2-nistp256-cert-v01@openssh.com`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if explainArg {
+				return cobra.MinimumNArgs(2)(cmd, args)
+			}
+			return cobra.MinimumNArgs(3)(cmd, args)
+		},
+		Example: `  opkssh verify root <base64-encoded-cert> ecdsa-sha2-nistp256-cert-v01@openssh.com
+  opkssh verify --explain bob /home/bob/.ssh/id_ecdsa-cert.pub`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.Background()
 
@@ -315,66 +480,230 @@ Arguments:
 			logFilePath := GetLogFilePath()
 			logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0660) // Owner and group can read/write
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error opening log file: %v\n", err)
+				fmt.Fprintf(rt.ErrOut, "Error opening log file: %v\n", err)
 				// It could be very difficult to figure out what is going on if the log file was deleted. Hopefully this message saves someone an hour of debugging.
 				if runtime.GOOS == "windows" {
-					fmt.Fprintf(os.Stderr, "Check if the log file exists at %v. If it does not, create it and ensure the account running sshd has read/write access (for example, via the file's Security properties or using icacls). The log directory may be under %%ProgramData%%.\n", logFilePath)
+					fmt.Fprintf(rt.ErrOut, "Check if the log file exists at %v. If it does not, create it and ensure the account running sshd has read/write access (for example, via the file's Security properties or using icacls). The log directory may be under %%ProgramData%%.\n", logFilePath)
 				} else {
-					fmt.Fprintf(os.Stderr, "Check if log exists at %v, if it does not create it with permissions: chown root:opksshuser %v; chmod 660 %v\n", logFilePath, logFilePath, logFilePath)
+					fmt.Fprintf(rt.ErrOut, "Check if log exists at %v, if it does not create it with permissions: chown root:opksshuser %v; chmod 660 %v\n", logFilePath, logFilePath, logFilePath)
 				}
 			} else {
 				defer logFile.Close()
 				log.SetOutput(logFile)
 			}
 
-			// Logs if using an unsupported OpenSSH version
-			checkOpenSSHVersion()
+			// The warnings explain a denied login as much as the trace does
+			if explainArg {
+				log.SetOutput(io.MultiWriter(log.Writer(), rt.ErrOut))
+			}
+
+			// Failures are invisible on Windows unless they reach Event Viewer
+			if closeEventLog, err := eventlog.Enable(); err != nil {
+				slog.Warn("Errors will not be reported to the event log", "error", err)
+			} else {
+				defer closeEventLog()
+			}
 
 			// The "AuthorizedKeysCommand" func is designed to be used by sshd and specified as an AuthorizedKeysCommand
 			// ref: https://man.openbsd.org/sshd_config#AuthorizedKeysCommand
-			log.Println(strings.Join(os.Args, " "))
+			slog.Info("Running", "args", strings.Join(os.Args, " "))
 
 			userArg := args[0]
 			certB64Arg := args[1]
-			typArg := args[2]
-			extraArgs := args[3:]
+			var typArg string
+			var extraArgs []string
+			if len(args) > 2 {
+				typArg, extraArgs = args[2], args[3:]
+			}
+			if explainArg && len(args) == 2 {
+				if typArg, certB64Arg, err = commands.ReadSshCertFile(rt.Fs, args[1]); err != nil {
+					return err
+				}
+			}
 
-			providerPolicyPath := filepath.Join(policy.GetSystemConfigBasePath(), "providers")
-			providerPolicy, err := policy.NewProviderFileLoader().LoadProviderPolicy(providerPolicyPath)
-			if err != nil {
-				log.Printf("Failed to open %s: %v\n", providerPolicyPath, err)
-				return err
+			if explainArg {
+				verifySocketArg, viaSocketArg = "", false
+			}
+			if viaSocketArg && verifySocketArg == "" {
+				verifySocketArg = commands.DefaultServeSocketPath()
+			}
+			if verifySocketArg != "" {
+				authKey, err := commands.VerifyWithServer(ctx, verifySocketArg, commands.ServeRequest{
+					Principal:     userArg,
+					KeyType:       typArg,
+					Cert:          certB64Arg,
+					ExtraArgs:     extraArgs,
+					Connection:    connectionArg,
+					SshConnection: os.Getenv("SSH_CONNECTION"),
+				})
+				if err == nil {
+					slog.Info("Successfully verified by opkssh serve")
+					fmt.Println(authKey)
+					return nil
+				} else if viaSocketArg || !errors.Is(err, commands.ErrServeUnavailable) {
+					slog.Error("Failed to verify", "error", err)
+					return err
+				}
+				slog.Info("Verifying locally", "error", err)
 			}
 
-			printConfigProblems()
-			log.Println("Providers loaded: ", providerPolicy.ToString())
+			// Logs if using an unsupported OpenSSH version. This runs sshd,
+			// so it is skipped when opkssh serve answers the login.
+			checkOpenSSHVersion()
 
-			pktVerifier, err := providerPolicy.CreateVerifier()
+			v, err := newLocalVerifyCmd(rt, userArg, serverConfigPathArg, connectionArg)
 			if err != nil {
-				log.Println("Failed to create pk token verifier (likely bad configuration):", err)
 				return err
 			}
 
-			v := commands.NewVerifyCmd(*pktVerifier, commands.OpkPolicyEnforcerFunc(userArg), serverConfigPathArg)
-			if err := v.ReadFromServerConfig(); err != nil {
-				log.Println("Failed to set environment variables in config:", err)
+			if v.Audit != nil {
+				defer v.Audit.Close()
+			}
+			if explainArg {
+				v.Explain(rt.Out, userArg)
+				_, err := v.AuthorizedKeysCommand(ctx, userArg, typArg, certB64Arg, extraArgs)
+				return err
 			}
-
 			if authKey, err := v.AuthorizedKeysCommand(ctx, userArg, typArg, certB64Arg, extraArgs); err != nil {
-				log.Println("failed to verify:", err)
+				slog.Error("Failed to verify", "error", err)
+				eventlog.Report(eventlog.VerifyFailed, "Failed to verify login as %s: %v", userArg, err)
 				return err
 			} else {
-				log.Println("successfully verified")
+				slog.Info("Successfully verified")
 				// sshd is awaiting a specific line, which we print here. Printing anything else before or after will break our solution
 				fmt.Println(authKey)
 				return nil
 			}
 		},
 	}
-	defaultConfigPath := filepath.Join(policy.GetSystemConfigBasePath(), "config.yml")
+	defaultConfigPath := policy.Defaults.ConfigPath
 	verifyCmd.Flags().StringVar(&serverConfigPathArg, "config-path", defaultConfigPath, fmt.Sprintf("Path to the server config file. Default: %s", defaultConfigPath))
+	verifyCmd.Flags().StringVar(&connectionArg, "connection", "", "The connection being authorized, set to sshd's %C token. Required by the proxy settings in the server config")
+	verifyCmd.Flags().StringVar(&verifySocketArg, "socket", "", "Forward the login to the opkssh serve daemon listening on this socket, verifying locally if it is not running")
+	verifyCmd.Flags().BoolVar(&explainArg, "explain", false, "Print each step of the verification of the login instead of authorizing it, to find out why it is denied")
+	verifyCmd.Flags().BoolVar(&viaSocketArg, "via-socket", false, fmt.Sprintf("Only forward the login to opkssh serve on --socket (default %s) and deny it if serve can't be reached", commands.DefaultServeSocketPath()))
 	rootCmd.AddCommand(verifyCmd)
 
+	var serveSocketArg string
+	var serveConfigPathArg string
+	var serveMetricsListenArg string
+	serveCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "serve",
+		Short:        "Run a verify daemon that reloads the configuration when it changes",
+		Long: `Serve keeps the providers, server config, system policy and policy plugin configs in memory and answers the logins forwarded by opkssh verify --socket, so that every login does not read and check these files again. The files are watched and reloaded when they change. If a reload fails, or the preflight checks fail in strict mode, logins are refused until the configuration is fixed.
+
+Run serve as the AuthorizedKeysCommandUser (opksshuser), the socket can only be used by the user running serve. Then set in sshd_config:
+  AuthorizedKeysCommand /usr/local/bin/opkssh verify --socket /run/opk/opkssh.sock %%u %%k %%t
+
+verify checks the login itself when serve is not running.
+
+When systemd starts serve from a socket unit, see opkssh service, it answers the logins on the socket systemd passes instead of --socket.
+
+With --metrics-listen, serve also answers http://<address>/metrics with Prometheus counters of the logins allowed and denied, and histograms of how long verifications and policy plugins take.`,
+		Args: cobra.NoArgs,
+		Example: `  opkssh serve --socket /run/opk/opkssh.sock
+  opkssh serve --metrics-listen 127.0.0.1:9464`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			if closeEventLog, err := eventlog.Enable(); err != nil {
+				slog.Warn("Errors will not be reported to the event log", "error", err)
+			} else {
+				defer closeEventLog()
+			}
+			serve := commands.NewServeCmd(rt, serveSocketArg, serveConfigPathArg)
+			serve.MetricsListen = serveMetricsListenArg
+			serve.Telemetry = newTelemetryRecorder(rt)
+			listener, err := commands.SystemdListener()
+			if err != nil {
+				return err
+			}
+			serve.Listener = listener
+			return serve.Run(ctx)
+		},
+	}
+	serveCmd.Flags().StringVar(&serveSocketArg, "socket", commands.DefaultServeSocketPath(), "The socket to listen on, or a named pipe \\\\.\\pipe\\<name> on Windows")
+	serveCmd.Flags().StringVar(&serveConfigPathArg, "config-path", defaultConfigPath, fmt.Sprintf("Path to the server config file. Default: %s", defaultConfigPath))
+	serveCmd.Flags().StringVar(&serveMetricsListenArg, "metrics-listen", "", "Serve Prometheus metrics on /metrics at this TCP address, e.g. 127.0.0.1:9464. The endpoint has no authentication, listen on localhost or a management network")
+	rootCmd.AddCommand(serveCmd)
+
+	var serviceSocketArg string
+	var serviceConfigPathArg string
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage the service that runs the verify daemon",
+		Long: `Manage a Windows service that runs opkssh serve on a named pipe. Starting opkssh for every login is slow on Windows, with the service opkssh verify --socket only forwards the login to it, and the service writes every decision to its log and to the event log.
+
+After installing the service, set in sshd_config:
+  AuthorizedKeysCommand "C:\Program Files\opkssh\opkssh.exe" verify --socket \\.\pipe\opkssh %%u %%k %%t
+
+The pipe can be used by SYSTEM, the Administrators and opksshuser. verify checks the login itself while the service is stopped.
+
+On Linux, install writes the systemd units opkssh-verify.socket and opkssh-verify.service. systemd listens on the socket, owned by root and the opksshuser group, and starts opkssh serve as root on the first login. Set in sshd_config:
+  AuthorizedKeysCommand /usr/local/bin/opkssh verify --via-socket %%u %%k %%t
+
+verify --via-socket only forwards the login, and denies it while the service can't be reached.`,
+		Args: cobra.NoArgs,
+	}
+	serviceCmd.PersistentFlags().StringVar(&serviceSocketArg, "socket", commands.DefaultServeSocketPath(), "The named pipe, or socket on Linux, the service listens on")
+	serviceCmd.PersistentFlags().StringVar(&serviceConfigPathArg, "config-path", defaultConfigPath, fmt.Sprintf("Path to the server config file. Default: %s", defaultConfigPath))
+	newService := func() (*commands.ServiceCmd, error) {
+		return commands.NewServiceCmd(rt, serviceSocketArg, serviceConfigPathArg)
+	}
+	for _, sub := range []struct {
+		use, short string
+		run        func(*commands.ServiceCmd) error
+	}{
+		{"install", "Register the service, start it at boot and start it now", (*commands.ServiceCmd).Install},
+		{"uninstall", "Stop the service and remove it", (*commands.ServiceCmd).Uninstall},
+		{"start", "Start the service", (*commands.ServiceCmd).Start},
+		{"stop", "Stop the service", (*commands.ServiceCmd).Stop},
+	} {
+		serviceCmd.AddCommand(&cobra.Command{
+			SilenceUsage: true,
+			Use:          sub.use,
+			Short:        sub.short,
+			Args:         cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				service, err := newService()
+				if err != nil {
+					return err
+				}
+				return sub.run(service)
+			},
+		})
+	}
+	serviceCmd.AddCommand(&cobra.Command{
+		SilenceUsage: true,
+		Use:          "run",
+		Short:        "Run the verify daemon as the service, used by the service control manager",
+		Hidden:       true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			// A service has no console, logins are logged with those of verify
+			if logFile, err := os.OpenFile(GetLogFilePath(), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0660); err != nil {
+				slog.Warn("Failed to open the log file", "error", err)
+			} else {
+				defer logFile.Close()
+				log.SetOutput(logFile)
+			}
+			if closeEventLog, err := eventlog.Enable(); err != nil {
+				slog.Warn("Errors will not be reported to the event log", "error", err)
+			} else {
+				defer closeEventLog()
+			}
+			serve := commands.NewServeCmd(rt, serviceSocketArg, serviceConfigPathArg)
+			serve.Telemetry = newTelemetryRecorder(rt)
+			return commands.RunService(ctx, serve)
+		},
+	})
+	rootCmd.AddCommand(serviceCmd)
+
 	auditCmd := &cobra.Command{
 		SilenceUsage: true,
 		Use:          "audit",
@@ -395,7 +724,7 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 		Example: `  opkssh audit`,
 		Args:    cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			audit := commands.NewAuditCmd(os.Stdout, os.Stderr)
+			audit := commands.NewAuditCmd(rt)
 
 			// Apply command-line flags
 			providersFile, _ := cmd.Flags().GetString("providers-file")
@@ -416,13 +745,267 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 		},
 	}
 
-	auditCmd.Flags().String("providers-file", policy.SystemDefaultProvidersPath, "Path to providers file")
-	auditCmd.Flags().String("policy-file", policy.SystemDefaultPolicyPath, "Path to policy file")
+	auditCmd.Flags().String("providers-file", policy.Defaults.ProvidersPath, "Path to providers file")
+	auditCmd.Flags().String("policy-file", policy.Defaults.PolicyPath, "Path to policy file")
 	auditCmd.Flags().Bool("skip-user-policy", runtime.GOOS == "windows", "Skip auditing user policy file (~/.opk/auth_id)")
 	auditCmd.Flags().BoolP("json", "j", false, "Output complete audit results in JSON")
 
 	rootCmd.AddCommand(auditCmd)
 
+	doctor := commands.NewDoctorCmd(rt)
+	doctorCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "doctor",
+		Short:        "Diagnose the opkssh setup of this server",
+		Long: `Doctor runs every check opkssh needs to work on this server and prints a combined report with a hint to fix each problem.
+
+The doctor command checks that:
+  - The opkssh files have the expected permissions, as permissions check does
+  - The policy and providers files are valid, as policy lint does
+  - sshd_config calls this opkssh binary's verify as the AuthorizedKeysCommand with %u %k %t, and no AuthorizedKeysCommand read first or in a Match block overrides it
+  - The AuthorizedKeysCommandUser is opksshuser and the account exists
+  - The OpenID configuration of each provider can be fetched
+
+Exit code: 0 if no check failed, 1 otherwise. Warnings do not fail doctor.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh doctor
+  sudo opkssh doctor --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return doctor.Run()
+		},
+	}
+	doctorCmd.Flags().StringVar(&doctor.SshdConfigPath, "sshd-config", doctor.SshdConfigPath, "Path to the sshd config")
+	doctorCmd.Flags().BoolVarP(&doctor.JsonOutput, "json", "j", false, "Output the checks in JSON")
+	rootCmd.AddCommand(doctorCmd)
+
+	var installSshdConfigArg string
+	var installNoReloadArg bool
+	installCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "install",
+		Short:        "Set up this server to verify SSH logins with opkssh",
+		Long: `Install sets up the OpenSSH server of this machine, Linux or Windows, to let users log in with opkssh. Run it as root or Administrator from the opkssh binary sshd should run. It:
+  - Creates the AuthorizedKeysCommandUser account opksshuser, which can't log in, unless it exists. On Windows it gets a random password that is never shown and is denied the interactive and Remote Desktop logons
+  - Creates the configuration directory and its files with the permissions verify requires, as permissions install does
+  - Sets AuthorizedKeysCommand and AuthorizedKeysCommandUser in sshd_config, or in a drop-in file of sshd_config.d if sshd_config includes it first. Other AuthorizedKeysCommand directives are commented out
+  - Checks the new config with sshd -t, and restores the previous one if sshd rejects it
+  - Reloads sshd
+
+Running install again updates the directives, e.g. after moving the binary.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo /usr/local/bin/opkssh install
+  sudo opkssh install --no-sshd-reload`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			install, err := commands.NewInstallCmd(rt)
+			if err != nil {
+				return err
+			}
+			if installSshdConfigArg != "" {
+				install.SshdConfigPath = installSshdConfigArg
+			}
+			install.NoSshdReload = installNoReloadArg
+			return install.Run()
+		},
+	}
+	installCmd.Flags().StringVar(&installSshdConfigArg, "sshd-config", "", "Path to the sshd config (default "+doctor.SshdConfigPath+")")
+	installCmd.Flags().BoolVar(&installNoReloadArg, "no-sshd-reload", false, "Don't reload sshd after changing its config")
+	rootCmd.AddCommand(installCmd)
+
+	var uninstallSshdConfigArg string
+	var uninstallNoReloadArg, uninstallPurgeArg, uninstallDryRunArg bool
+	uninstallCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "uninstall",
+		Short:        "Undo opkssh install",
+		Long: `Uninstall removes what opkssh install and opkssh service install set up, and prints each change it makes:
+  - Removes the drop-in file and the directives install added to sshd_config, restores the directives it commented out, checks the config with sshd -t and reloads sshd
+  - Removes the verify service, the Windows service or the systemd units
+  - Removes the opksshuser account
+  - Removes the opkssh event log source on Windows
+
+The configuration and state directories, with the policy and providers files, are kept unless --purge is passed.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh uninstall --dry-run
+  sudo opkssh uninstall --purge`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			install, err := commands.NewInstallCmd(rt)
+			if err != nil {
+				return err
+			}
+			if uninstallSshdConfigArg != "" {
+				install.SshdConfigPath = uninstallSshdConfigArg
+			}
+			install.NoSshdReload = uninstallNoReloadArg
+			install.Purge = uninstallPurgeArg
+			install.DryRun = uninstallDryRunArg
+			return install.Uninstall()
+		},
+	}
+	uninstallCmd.Flags().StringVar(&uninstallSshdConfigArg, "sshd-config", "", "Path to the sshd config (default "+doctor.SshdConfigPath+")")
+	uninstallCmd.Flags().BoolVar(&uninstallNoReloadArg, "no-sshd-reload", false, "Don't reload sshd after changing its config")
+	uninstallCmd.Flags().BoolVar(&uninstallPurgeArg, "purge", false, fmt.Sprintf("Also delete %s and %s", policy.GetSystemConfigBasePath(), policy.GetSystemStateBasePath()))
+	uninstallCmd.Flags().BoolVar(&uninstallDryRunArg, "dry-run", false, "Don't modify anything; show planned changes")
+	rootCmd.AddCommand(uninstallCmd)
+
+	jwksCmd := &cobra.Command{
+		Use:   "jwks [subcommand]",
+		Short: "Manage the cached keys of the OpenID Providers",
+		Example: `  sudo opkssh jwks fetch
+  sudo opkssh jwks import google google.jwks`,
+		Args: cobra.ExactArgs(0),
+	}
+	jwksFetch := commands.NewJWKSCmd(rt)
+	jwksFetchCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "fetch",
+		Short:        "Fetch the keys of every provider into the JWKS cache",
+		Long: `Fetch fills the JWKS cache that verify uses with the OpenID configuration and keys of every provider in the providers file.
+
+verify caches the responses of the providers for the max-age of their Cache-Control header, at most 24 hours, and uses them for up to 24 more hours while a provider can't be reached. When a provider rotates its keys before the cached ones expire, run fetch with --refresh to replace them.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh jwks fetch
+  sudo opkssh jwks fetch --refresh`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return jwksFetch.Fetch(cmd.Context())
+		},
+	}
+	jwksFetchCmd.Flags().BoolVar(&jwksFetch.Cache.Refresh, "refresh", false, "Fetch the keys even if the cached ones have not expired")
+	jwksFetchCmd.Flags().StringVar(&jwksFetch.ProvidersPath, "providers", jwksFetch.ProvidersPath, "Path to the providers file")
+	jwksCmd.AddCommand(jwksFetchCmd)
+	jwksImport := commands.NewJWKSCmd(rt)
+	jwksImportCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "import <issuer> <file>",
+		Short:        "Import the keys of a provider for servers that can't reach it",
+		Long: `Import stores the JWKS in file as the keys of the provider issuer, so that verify can check PK Tokens on servers without outbound network access. Get the JWKS from the jwks_uri of the OpenID configuration of the provider on a machine that can reach it.
+
+verify still asks the provider first and only uses the imported keys while it can't be reached, for up to jwks_cache max_stale (default 24h) in the server config after they are imported. Import the keys again before then, and whenever the provider rotates them.`,
+		Args: cobra.ExactArgs(2),
+		Example: `  curl -s https://www.googleapis.com/oauth2/v3/certs > google.jwks
+  sudo opkssh jwks import google google.jwks`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return jwksImport.Import(expandIssuerAlias(args[0]), args[1])
+		},
+	}
+	jwksImportCmd.Flags().StringVar(&jwksImport.ProvidersPath, "providers", jwksImport.ProvidersPath, "Path to the providers file")
+	jwksCmd.AddCommand(jwksImportCmd)
+	rootCmd.AddCommand(jwksCmd)
+
+	caCmd := &cobra.Command{
+		Use:   "ca [subcommand]",
+		Short: "Manage the SSH CA that signs short-lived certificates for sshd",
+		Long: fmt.Sprintf(`In CA mode sshd trusts an SSH CA of the host with TrustedUserCAKeys instead of running opkssh verify for each login. opkssh ca sign checks an opkssh certificate like verify does and, if the login is allowed, signs a certificate of the CA for the same key. It is valid for the principal until the PK Token expires, and at most ca max_lifetime (default %s) in the server config.
+
+The CA key is %s, readable by root only, and the public keys sshd trusts are in %s.`, commands.DefaultCAMaxLifetime, policy.SystemDefaultCAKeyPath, policy.SystemDefaultCAPublicKeysPath),
+		Example: `  sudo opkssh ca init
+  sudo opkssh ca sign dev ~/.ssh/id_ecdsa-cert.pub > ~/.ssh/id_ecdsa-ca-cert.pub
+  sudo opkssh ca rotate`,
+		Args: cobra.ExactArgs(0),
+	}
+	caInit := commands.NewCACmd(rt)
+	caInitCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "init",
+		Short:        "Create the CA key",
+		Long:         `Init creates the ed25519 CA key and the public keys file, and prints the TrustedUserCAKeys line to add to the sshd config. It fails if the CA key already exists.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return caInit.Init()
+		},
+	}
+	caCmd.AddCommand(caInitCmd)
+	caRotate := commands.NewCACmd(rt)
+	caRotateCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "rotate",
+		Short:        "Replace the CA key",
+		Long: `Rotate replaces the CA key with a new one. sshd keeps trusting the replaced key, so the certificates it signed stay valid until they expire, and stops trusting the key before it.
+
+Rotate again, for instance to stop trusting a compromised key, once every certificate of the replaced key has expired.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfig, err := commands.LoadServerConfig(rt.Fs, policy.Defaults.ConfigPath)
+			if err != nil {
+				return err
+			}
+			if err := caRotate.ApplyServerConfig(serverConfig); err != nil {
+				return err
+			}
+			return caRotate.Rotate()
+		},
+	}
+	caCmd.AddCommand(caRotateCmd)
+	caSign := commands.NewCACmd(rt)
+	var caSignConfigPathArg string
+	caSignCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "sign <principal> <cert>",
+		Short:        "Sign a short-lived certificate for an allowed login",
+		Long: `Sign checks the opkssh certificate in the file cert, or on stdin if cert is -, as verify checks a login as principal: the PK Token, the providers, the policy and the policy plugins. If the login is allowed, it writes a certificate of the CA for the key of the opkssh certificate to stdout.
+
+The certificate is only valid for principal. The authorized_keys options of the policy entry or plugins that allowed the login become its options: command becomes force-command, from becomes source-address and the no-* options remove the matching permit-* extensions. A login restricted by options a certificate can't express, such as permitopen, is not signed.`,
+		Args: cobra.ExactArgs(2),
+		Example: `  sudo opkssh ca sign dev ~/.ssh/id_ecdsa-cert.pub > ~/.ssh/id_ecdsa-ca-cert.pub
+  cat id_ecdsa-cert.pub | sudo opkssh ca sign dev -`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := newLocalVerifyCmd(rt, args[0], caSignConfigPathArg, "")
+			if err != nil {
+				return err
+			}
+			if v.Audit != nil {
+				defer v.Audit.Close()
+			}
+			if err := caSign.ApplyServerConfig(v.ServerConfig); err != nil {
+				return err
+			}
+			caSign.Verify = v
+			return caSign.Sign(cmd.Context(), args[0], args[1])
+		},
+	}
+	caSignCmd.Flags().StringVar(&caSignConfigPathArg, "config-path", policy.Defaults.ConfigPath, "Path to the server config file")
+	caCmd.AddCommand(caSignCmd)
+	rootCmd.AddCommand(caCmd)
+
+	serverConfigCmd := &cobra.Command{
+		Use:   "config [subcommand]",
+		Short: "Check the server config read by verify",
+		Long: fmt.Sprintf(`Check and print the server config, %s, read by verify and the daemons.
+
+Settings of the server config can be overridden by environment variables named %s followed by their YAML keys in upper case joined with _, such as %sPREFLIGHT=strict or %sRATE_LIMIT_MAX_FAILURES=5. Lists are comma separated. sshd runs verify with an empty environment, so the overrides are for opkssh serve and the service, e.g. set with Environment= in their systemd unit.`,
+			policy.Defaults.ConfigPath, config.ServerEnvPrefix, config.ServerEnvPrefix, config.ServerEnvPrefix),
+		Example: `  sudo opkssh config validate
+  sudo opkssh config show`,
+		Args: cobra.ExactArgs(0),
+	}
+	serverConfigCmds := commands.NewServerConfigCmd(rt, policy.Defaults.ConfigPath)
+	serverConfigValidateCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "validate",
+		Short:        "Check the permissions and settings of the server config",
+		Long: `Validate checks that verify can read the server config and that every setting is one verify uses: unknown settings are warnings, as verify ignores them, and invalid values, such as a malformed duration or proxy address, are errors. The environment overrides are applied and listed.
+
+Returns a non-zero exit code if there are errors.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serverConfigCmds.Validate()
+		},
+	}
+	serverConfigValidateCmd.Flags().StringVar(&serverConfigCmds.ConfigPath, "config-path", serverConfigCmds.ConfigPath, "Path to the server config file")
+	serverConfigCmd.AddCommand(serverConfigValidateCmd)
+	serverConfigShowCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "show",
+		Short:        "Print the server config with the environment overrides applied",
+		Long:         `Show prints the built-in locations opkssh uses, then the server config as verify reads it, with the environment overrides applied and secrets redacted.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serverConfigCmds.Show()
+		},
+	}
+	serverConfigShowCmd.Flags().StringVar(&serverConfigCmds.ConfigPath, "config-path", serverConfigCmds.ConfigPath, "Path to the server config file")
+	serverConfigCmd.AddCommand(serverConfigShowCmd)
+	rootCmd.AddCommand(serverConfigCmd)
+
 	clientCmd := &cobra.Command{
 		Use:     "client [subcommand]",
 		Short:   "Interact with client configuration",
@@ -443,10 +1026,10 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 		Example: `  opkssh client provider list`,
 		Args:    cobra.ExactArgs(0),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			client_config, err := config.GetClientConfigFromFile(configPathArg, afero.NewOsFs())
+			client_config, err := config.GetClientConfigFromFile(configPathArg, rt.Fs)
 
 			if err != nil {
-				log.Fatal("Unable to load providers. ", err)
+				return fmt.Errorf("unable to load providers: %w", err)
 			}
 
 			isTTY := term.IsTerminal(int(os.Stdout.Fd()))
@@ -454,12 +1037,12 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 			var w *tabwriter.Writer
 			if isTTY {
 				// Nice aligned table for TTY output
-				w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				w = tabwriter.NewWriter(rt.Out, 0, 0, 2, ' ', 0)
 				fmt.Fprintln(w, "Alias\tIssuer")
 				fmt.Fprintln(w, "-----\t------")
 			} else {
 				// Simpler formatting for non-TTY (e.g., when piping to a file)
-				w = tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.DiscardEmptyColumns)
+				w = tabwriter.NewWriter(rt.Out, 0, 0, 1, ' ', tabwriter.DiscardEmptyColumns)
 			}
 
 			for _, p := range client_config.Providers {
@@ -471,7 +1054,7 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 
 			// and lets check it can be loaded into a map, after we print the contents
 			if _, err = config.CreateProvidersMap(client_config.Providers); err != nil {
-				log.Fatal("Unable to parse providers. ", err)
+				return fmt.Errorf("unable to parse providers: %w", err)
 			}
 
 			return nil
@@ -486,8 +1069,468 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 
 	rootCmd.AddCommand(clientCmd)
 
+	policyCmd := &cobra.Command{
+		Use:   "policy [subcommand]",
+		Short: "Inspect the server policy",
+		Example: `  opkssh policy log
+  opkssh policy lint`,
+		Args: cobra.ExactArgs(0),
+	}
+
+	policyLog := commands.NewPolicyLogCmd(rt)
+	policyLogCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "log",
+		Short:        "Show the journal of policy changes made by opkssh",
+		Long: fmt.Sprintf(`Log prints the append-only journal of changes made to the system policy and opkssh file permissions by opkssh commands such as add and permissions fix.
+
+Each record shows when the change was made, by whom, the command line used and a summary of the change. The journal is stored at %s.`, policy.SystemDefaultJournalPath),
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh policy log
+  sudo opkssh policy log -n 10 --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return policyLog.Run()
+		},
+	}
+	policyLogCmd.Flags().IntVarP(&policyLog.Limit, "limit", "n", 0, "Only show the most recent n entries")
+	policyLogCmd.Flags().BoolVarP(&policyLog.JsonOutput, "json", "j", false, "Output entries in JSON")
+	policyCmd.AddCommand(policyLogCmd)
+
+	policyLint := commands.NewLintCmd(rt)
+	policyLintCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "lint",
+		Short:        "Check the policy, providers and plugin configs for mistakes",
+		Long: `Lint checks the system policy, policy fragments, the home policy (~/.opk/auth_id) of every user, the providers file and the policy plugin configs without authenticating anyone. It reports syntax errors, issuers missing from the providers file, principals that are not local accounts, home policy entries for another user's principal, which are never reachable, duplicate entries, entries for revoked identities, invalid expiration policies, plugin config schema problems and files with insecure ownership or permissions.
+
+Each finding has a severity (error, warning or info) and a rule name. Lint returns a non-zero exit code if a finding is at least as severe as --fail-on. Point the path flags at a checkout and pass --skip-host-checks to lint a policy repository in CI before it is distributed.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh policy lint
+  opkssh policy lint --policy auth_id --providers providers --plugins-dir policy.d --skip-host-checks --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return policyLint.Run()
+		},
+	}
+	policyLintCmd.Flags().StringVar(&policyLint.PolicyPath, "policy", policyLint.PolicyPath, "Path to the system policy file")
+	policyLintCmd.Flags().StringVar(&policyLint.FragmentDir, "fragments-dir", policyLint.FragmentDir, "Directory of policy fragments, empty to skip")
+	policyLintCmd.Flags().StringVar(&policyLint.IssuerPolicyDir, "issuer-policy-dir", policyLint.IssuerPolicyDir, "Directory of the per-issuer policies, empty to skip")
+	policyLintCmd.Flags().StringVar(&policyLint.ProvidersPath, "providers", policyLint.ProvidersPath, "Path to the providers file")
+	policyLintCmd.Flags().StringVar(&policyLint.PluginsDir, "plugins-dir", policyLint.PluginsDir, "Directory of policy plugin configs")
+	policyLintCmd.Flags().StringVar(&policyLint.RevocationPath, "revocation-list", policyLint.RevocationPath, "Path to the revocation list")
+	policyLintCmd.Flags().BoolVar(&policyLint.SkipHostChecks, "skip-host-checks", false, "Skip checks against this host: local accounts, plugin commands and file permissions")
+	policyLintCmd.Flags().BoolVar(&policyLint.SkipUserPolicy, "skip-user-policy", false, "Skip the home policies (~/.opk/auth_id) of the users")
+	policyLintCmd.Flags().BoolVarP(&policyLint.JsonOutput, "json", "j", false, "Output findings in JSON")
+	policyLintCmd.Flags().StringVar((*string)(&policyLint.FailOn), "fail-on", string(commands.LintError), "Lowest severity that fails lint: error, warning or info")
+	policyCmd.AddCommand(policyLintCmd)
+
+	// policyDBPath is the database path of --db, or else of the server config
+	var policyDBArg string
+	policyDBPath := func() string {
+		if policyDBArg != "" {
+			return policyDBArg
+		}
+		serverConfig, err := commands.LoadServerConfig(rt.Fs, policy.Defaults.ConfigPath)
+		if err == nil && serverConfig != nil && serverConfig.PolicyStore.Path != "" {
+			return serverConfig.PolicyStore.Path
+		}
+		return policy.Defaults.PolicyDBPath
+	}
+	var policyImportFromArg string
+	policyImportCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "import",
+		Short:        "Import the policy file into the SQLite policy store",
+		Long: `Import replaces the entries of the SQLite policy store with those of the policy file, creating the database if needed. A policy file with lines that can't be read is refused.
+
+Set policy_store.backend to sqlite in the server config for verify and add to use the database.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh policy import
+  sudo opkssh policy import --from /etc/opk/auth_id --db /etc/opk/policy.db`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			importCmd := commands.NewPolicyDBCmd(rt, policyDBPath())
+			entries, err := importCmd.Import(policyImportFromArg)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(rt.Out, "Imported %d entries from %s into %s\n", entries, policyImportFromArg, importCmd.DB.Path)
+			return nil
+		},
+	}
+	policyImportCmd.Flags().StringVar(&policyImportFromArg, "from", policy.Defaults.PolicyPath, "Path of the policy file to import")
+	policyImportCmd.Flags().StringVar(&policyDBArg, "db", "", "Path of the policy database (default from the server config or "+policy.Defaults.PolicyDBPath+")")
+	policyCmd.AddCommand(policyImportCmd)
+
+	var policyExportOutputArg string
+	policyExportCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "export",
+		Short:        "Export the SQLite policy store as a policy file",
+		Long:         `Export writes the entries of the SQLite policy store in the policy file format, to standard output or to the file given with --output.`,
+		Args:         cobra.NoArgs,
+		Example: `  sudo opkssh policy export
+  sudo opkssh policy export --output /etc/opk/auth_id`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.NewPolicyDBCmd(rt, policyDBPath()).Export(policyExportOutputArg)
+		},
+	}
+	policyExportCmd.Flags().StringVarP(&policyExportOutputArg, "output", "o", "", "Write the policy file to this path instead of standard output")
+	policyExportCmd.Flags().StringVar(&policyDBArg, "db", "", "Path of the policy database (default from the server config or "+policy.Defaults.PolicyDBPath+")")
+	policyCmd.AddCommand(policyExportCmd)
+
+	var rewriteIssuerDryRunArg bool
+	policyRewriteIssuerCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "rewrite-issuer <old-issuer> <new-issuer>",
+		Short:        "Replace an issuer in the entries of the system policy",
+		Long: fmt.Sprintf(`Rewrite-issuer replaces the issuer of the entries of old-issuer with new-issuer in the system policy and the per-issuer policies in %s, e.g. once the users of an OpenID Provider were migrated to a new tenant. The comments of the policy files are kept and each changed file is backed up and recorded in the policy journal. The per-issuer policy of old-issuer becomes the policy of new-issuer.
+
+Home policies are not changed. Policy fragments are not changed either, as they are replaced when they are published again, but the fragments that still have entries of old-issuer are listed.
+
+To migrate without downtime, first add old-issuer to the aliases of providers.yml so that the entries of either issuer match the ID Tokens of new-issuer, then rewrite the policy and remove the alias.`, policy.SystemDefaultIssuerPolicyDir),
+		Args: cobra.ExactArgs(2),
+		Example: `  sudo opkssh policy rewrite-issuer https://login.microsoftonline.com/OLD_TENANT/v2.0 https://login.microsoftonline.com/NEW_TENANT/v2.0 --dry-run
+  sudo opkssh policy rewrite-issuer https://old.example.com https://new.example.com`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rewrite := commands.NewRewriteIssuerCmd(rt)
+			rewrite.DryRun = rewriteIssuerDryRunArg
+			serverConfig, err := commands.LoadServerConfig(rt.Fs, policy.Defaults.ConfigPath)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Warning: ignoring server config %s: %v\n", policy.Defaults.ConfigPath, err)
+			} else if serverConfig != nil {
+				commands.ConfigureBackups(rewrite.SystemPolicyLoader, serverConfig.PolicyBackups)
+				if err := commands.ConfigurePolicyStore(rewrite.SystemPolicyLoader, serverConfig.PolicyStore); err != nil {
+					return err
+				}
+			}
+			_, err = rewrite.Run(expandIssuerAlias(args[0]), expandIssuerAlias(args[1]))
+			return err
+		},
+	}
+	policyRewriteIssuerCmd.Flags().BoolVar(&rewriteIssuerDryRunArg, "dry-run", false, "Print the entries that would be rewritten without changing any file")
+	policyCmd.AddCommand(policyRewriteIssuerCmd)
+	rootCmd.AddCommand(policyCmd)
+
+	accessCmd := &cobra.Command{
+		Use:     "access [subcommand]",
+		Short:   "Report who can access this host",
+		Example: `  opkssh access export`,
+		Args:    cobra.ExactArgs(0),
+	}
+
+	var accessFormatArg string
+	var accessSkipUserArg bool
+	accessExportCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "export",
+		Short:        "Export the identities that can log in to this host and as which principals",
+		Long: `Export computes the effective access to this host, every identity to principal grant in the system policy, policy fragments and home policies, as JSON or CSV for access reviews.
+
+Each grant notes the conditions verify applies to it, such as claim conditions, token expiration and proxy requirements. Grants that verify always denies, for instance because the principal is in deny_users or the issuer is not in the providers file, are listed with effective set to false. Policy plugins decide at login time so only their configs are listed.`,
+		Args: cobra.NoArgs,
+		Example: `  sudo opkssh access export
+  sudo opkssh access export --format csv > access.csv`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfigPath := policy.Defaults.ConfigPath
+			serverConfig, err := commands.LoadServerConfig(rt.Fs, serverConfigPath)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Warning: ignoring server config %s: %v\n", serverConfigPath, err)
+			}
+			export := commands.NewAccessExportCmd(rt, serverConfig)
+			export.Format = accessFormatArg
+			export.SkipUserPolicy = accessSkipUserArg
+			return export.Run()
+		},
+	}
+	accessExportCmd.Flags().StringVar(&accessFormatArg, "format", "json", "Output format, json or csv")
+	accessExportCmd.Flags().BoolVar(&accessSkipUserArg, "skip-user-policy", runtime.GOOS == "windows", "Skip home policy files (~/.opk/auth_id)")
+	accessCmd.AddCommand(accessExportCmd)
+	rootCmd.AddCommand(accessCmd)
+
+	var listJsonArg, listSkipUserArg bool
+	listCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "list [principal]",
+		Short:        "List the policy entries in effect for a principal",
+		Long: `List prints the policy entries for the principal, or for every principal if none is given, from the system policy, policy fragments and home policies.
+
+Each entry names the file it came from and whether that file passes the permission check verify applies to it. Entries that verify never allows, for instance because their file has insecure permissions or they expired, are marked as not in effect with the reason. Entries of a local group principal (%group) are listed for the members of the group.`,
+		Args: cobra.MaximumNArgs(1),
+		Example: `  sudo opkssh list
+  sudo opkssh list root
+  sudo opkssh list alice --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfigPath := policy.Defaults.ConfigPath
+			serverConfig, err := commands.LoadServerConfig(rt.Fs, serverConfigPath)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Warning: ignoring server config %s: %v\n", serverConfigPath, err)
+			}
+			list := commands.NewListCmd(rt, serverConfig)
+			if len(args) == 1 {
+				list.Principal = args[0]
+			}
+			list.JsonOutput = listJsonArg
+			list.Export.SkipUserPolicy = listSkipUserArg
+			return list.Run()
+		},
+	}
+	listCmd.Flags().BoolVarP(&listJsonArg, "json", "j", false, "Output the entries in JSON")
+	listCmd.Flags().BoolVar(&listSkipUserArg, "skip-user-policy", runtime.GOOS == "windows", "Skip home policy files (~/.opk/auth_id)")
+	rootCmd.AddCommand(listCmd)
+
+	userCmd := &cobra.Command{
+		Use:     "user [subcommand]",
+		Short:   "Manage your own home policy",
+		Example: `  opkssh user init`,
+		Args:    cobra.ExactArgs(0),
+	}
+
+	var userInitIdentityArg, userInitIssuerArg, userInitCertArg string
+	userInitCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "init",
+		Short:        "Create your home policy file granting your identity access to your account",
+		Long: `Init creates the home policy file (~/.opk/auth_id) with the permissions the server requires and adds an entry granting your identity access to your own account.
+
+The identity and issuer are read from the SSH certificate created by your last "opkssh login" unless they are supplied with --identity and --issuer. The resulting file is checked with the same rules the server applies when you SSH in.`,
+		Args: cobra.NoArgs,
+		Example: `  opkssh user init
+  opkssh user init --identity alice@example.com --issuer google
+  opkssh user init --cert ~/.ssh/id_ed25519-cert.pub`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			currentUser, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("failed to determine current user: %w", err)
+			}
+			initCmd := commands.NewUserInitCmd(rt, currentUser.Username)
+
+			identity, issuer := userInitIdentityArg, expandIssuerAlias(userInitIssuerArg)
+			if identity == "" || issuer == "" {
+				certPaths := []string{userInitCertArg}
+				if userInitCertArg == "" {
+					certPaths = initCmd.DefaultCertPaths(currentUser.HomeDir)
+				}
+				var certErr error
+				for _, certPath := range certPaths {
+					var certIdentity, certIssuer string
+					if certIdentity, certIssuer, certErr = initCmd.IdentityFromCert(certPath); certErr == nil {
+						if identity == "" {
+							identity = certIdentity
+						}
+						if issuer == "" {
+							issuer = certIssuer
+						}
+						break
+					}
+				}
+				if certErr != nil {
+					fmt.Fprintf(rt.ErrOut, "No identity found, run \"opkssh login\" first or pass --identity and --issuer: %v\n", certErr)
+					return certErr
+				}
+			}
+
+			policyFilePath, err := initCmd.Run(identity, issuer)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Failed to initialize home policy: %v\n", err)
+				return err
+			}
+			fmt.Fprintf(rt.Out, "Successfully granted %s (%s) access to %s in %s\n", identity, issuer, currentUser.Username, policyFilePath)
+			return nil
+		},
+	}
+	userInitCmd.Flags().StringVar(&userInitIdentityArg, "identity", "", "Email, sub or group to grant access. Default: read from your opkssh SSH certificate")
+	userInitCmd.Flags().StringVar(&userInitIssuerArg, "issuer", "", "OpenID Provider (issuer) URL or alias. Default: read from your opkssh SSH certificate")
+	userInitCmd.Flags().StringVar(&userInitCertArg, "cert", "", "Path to the opkssh SSH certificate to read the identity from. Default: ~/.ssh/id_ecdsa-cert.pub or ~/.ssh/id_ed25519-cert.pub")
+	userCmd.AddCommand(userInitCmd)
+	rootCmd.AddCommand(userCmd)
+
+	oktaCmd := &cobra.Command{
+		Use:   "okta [subcommand]",
+		Short: "Revoke access as soon as Okta users are suspended or deactivated",
+		Long: fmt.Sprintf(`Okta revokes the identities of Okta users that are suspended or deactivated by adding them to the local revocation list at %s, instead of waiting for their PK Token to expire. Verify denies every identity on this list.
+
+Events are either pushed by an Okta event hook (serve) or pulled from the Okta System Log (poll). Both read the okta section of the server config and must be run as root.`, policy.SystemDefaultRevocationPath),
+		Example: `  sudo opkssh okta serve
+  sudo opkssh okta poll`,
+		Args: cobra.ExactArgs(0),
+	}
+	runOkta := func(run func(o *commands.OktaRevokeCmd, ctx context.Context) error) error {
+		serverConfig, err := loadRequiredServerConfig(rt)
+		if err != nil {
+			return err
+		}
+		preflight := commands.NewPreflight(rt, serverConfig.Preflight)
+		if _, err := preflight.Run(); err != nil {
+			return err
+		}
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		okta := commands.NewOktaRevokeCmd(rt, serverConfig.Okta)
+		commands.ConfigureBackups(okta.SystemPolicyLoader, serverConfig.PolicyBackups)
+		if err := commands.ConfigurePolicyStore(okta.SystemPolicyLoader, serverConfig.PolicyStore); err != nil {
+			return err
+		}
+		okta.Health = preflight
+		return run(okta, ctx)
+	}
+	oktaServeCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "serve",
+		Short:        "Receive Okta event hooks",
+		Long: `Serve listens for Okta event hooks on okta.listen (default 127.0.0.1:8089) and revokes users when a user.lifecycle.suspend or user.lifecycle.deactivate event is delivered. Requests must carry the Authorization header configured as okta.hook_secret.
+
+Okta only delivers event hooks to HTTPS endpoints, either set okta.tls_cert_file and okta.tls_key_file or run behind a TLS terminating proxy.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOkta(func(o *commands.OktaRevokeCmd, ctx context.Context) error { return o.Serve(ctx) })
+		},
+	}
+	oktaPollCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "poll",
+		Short:        "Poll the Okta System Log",
+		Long:         `Poll queries the Okta System Log at okta.org_url every okta.poll_interval (default 30s) with the okta.api_token and revokes users that were suspended or deactivated. Use this when Okta can not reach the server.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runOkta(func(o *commands.OktaRevokeCmd, ctx context.Context) error { return o.Poll(ctx) })
+		},
+	}
+	oktaCmd.AddCommand(oktaServeCmd)
+	oktaCmd.AddCommand(oktaPollCmd)
+	rootCmd.AddCommand(oktaCmd)
+
+	fleetCmd := &cobra.Command{
+		Use:   "fleet [subcommand]",
+		Short: "Distribute signed policy fragments from a leader host to replicas",
+		Long: fmt.Sprintf(`Fleet copies the signed policy fragments in %s from one leader host to replica hosts, so the policy generated by sync on the leader reaches every host without git or object storage.
+
+The leader serves its fragments over mutual TLS. Replicas pull the fragments that changed every fleet.interval (default 5m), only keep those signed by one of policy_fragments.trusted_keys, and report their status to the leader. Both read the fleet section of the server config and must be run as root.`, policy.Defaults.FragmentDir),
+		Example: `  sudo opkssh fleet agent
+  sudo opkssh fleet agent --once
+  sudo opkssh fleet status`,
+		Args: cobra.ExactArgs(0),
+	}
+	var fleetOnceArg bool
+	fleetAgentCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "agent",
+		Short:        "Run as the leader or a replica, as configured in fleet.role",
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfig, err := loadRequiredServerConfig(rt)
+			if err != nil {
+				return err
+			}
+			if _, err := commands.NewPreflight(rt, serverConfig.Preflight).Run(); err != nil {
+				return err
+			}
+			fleet, err := commands.NewFleetCmd(rt, serverConfig.Fleet, serverConfig.PolicyFragments)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+			return fleet.Run(ctx, fleetOnceArg)
+		},
+	}
+	fleetAgentCmd.Flags().BoolVar(&fleetOnceArg, "once", false, "Pull once and exit, replicas only")
+	var fleetJsonArg bool
+	fleetStatusCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "status",
+		Short:        "Show the revision and sync status each replica reported to the leader",
+		Long:         `Status asks the leader at fleet.leader, using the certificate in fleet.cert_file, for the status of the replicas. A replica is behind when it did not pull the current revision of the leader and stale when it has not reported for three intervals.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfig, err := loadRequiredServerConfig(rt)
+			if err != nil {
+				return err
+			}
+			fleet, err := commands.NewFleetCmd(rt, serverConfig.Fleet, serverConfig.PolicyFragments)
+			if err != nil {
+				return err
+			}
+			status, err := fleet.Status(cmd.Context())
+			if err != nil {
+				return err
+			}
+			return fleet.PrintStatus(status, fleetJsonArg)
+		},
+	}
+	fleetStatusCmd.Flags().BoolVarP(&fleetJsonArg, "json", "j", false, "Output the status in JSON")
+	fleetCmd.AddCommand(fleetAgentCmd)
+	fleetCmd.AddCommand(fleetStatusCmd)
+	rootCmd.AddCommand(fleetCmd)
+
+	syncCmd := &cobra.Command{
+		Use:   "sync [subcommand]",
+		Short: "Generate policy from directory group membership",
+		Long: fmt.Sprintf(`Sync grants the members of directory groups the principals mapped to their group in the server config. The generated policy is written to a fragment in %s, which verify reads in addition to the system policy, so membership changes reach the host without editing auth_id.
+
+Run sync periodically as root, for instance from a systemd timer or cron.`, policy.Defaults.FragmentDir),
+		Example: `  sudo opkssh sync azure
+  sudo opkssh sync google`,
+		Args: cobra.ExactArgs(0),
+	}
+	syncAzureCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "azure",
+		Short:        "Sync Azure AD group members with Microsoft Graph",
+		Long:         `Azure resolves the members of the groups listed in the azure_ad section of the server config with Microsoft Graph and writes them to the azuread policy fragment. Only membership changes are fetched after the first run, using Graph delta queries.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfig, err := loadRequiredServerConfig(rt)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			azureSync := commands.NewAzureSyncCmd(rt, serverConfig.AzureAD)
+			if azureSync.Fragments, err = commands.NewSigningFragmentStore(rt.Fs, serverConfig.PolicyFragments); err != nil {
+				return err
+			}
+			added, removed, err := azureSync.Run(ctx)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Failed to sync Azure AD groups: %v\n", err)
+				return err
+			}
+			fmt.Fprintf(rt.Out, "Synced %s: %d added, %d removed\n", azureSync.Fragments.Path("azuread"), len(added), len(removed))
+			return nil
+		},
+	}
+	syncGoogleCmd := &cobra.Command{
+		SilenceUsage: true,
+		Use:          "google",
+		Short:        "Sync Google Groups members with the Admin SDK",
+		Long:         `Google resolves the members of the Google Groups listed in the google_workspace section of the server config with the Admin SDK Directory API and writes them to the google policy fragment. Members of nested groups are included.`,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			serverConfig, err := loadRequiredServerConfig(rt)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			googleSync := commands.NewGoogleSyncCmd(rt, serverConfig.GoogleWorkspace)
+			if googleSync.Fragments, err = commands.NewSigningFragmentStore(rt.Fs, serverConfig.PolicyFragments); err != nil {
+				return err
+			}
+			added, removed, err := googleSync.Run(ctx)
+			if err != nil {
+				fmt.Fprintf(rt.ErrOut, "Failed to sync Google Groups: %v\n", err)
+				return err
+			}
+			fmt.Fprintf(rt.Out, "Synced %s: %d added, %d removed\n", googleSync.Fragments.Path("google"), len(added), len(removed))
+			return nil
+		},
+	}
+	syncCmd.AddCommand(syncAzureCmd)
+	syncCmd.AddCommand(syncGoogleCmd)
+	rootCmd.AddCommand(syncCmd)
+
 	// permissions command for checking and fixing file permissions/ACLs
-	permsCmd := commands.NewPermissionsCmd(os.Stdout, os.Stderr)
+	permsCmd := commands.NewPermissionsCmd(rt)
 	rootCmd.AddCommand(permsCmd.CobraCommand())
 
 	// genDocsCmd is a hidden command used as a helper for generating our
@@ -512,19 +1555,189 @@ Exit code: 0 if all entries are valid, 1 if any warnings or errors are found.`,
 	}
 	rootCmd.AddCommand(genDocsCmd)
 
-	err := rootCmd.Execute()
-	if err != nil {
+	cmd, err := rootCmd.ExecuteC()
+	if cmd != nil && cmd != rootCmd {
+		if recorder := newTelemetryRecorder(rt); recorder != nil {
+			feature := strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+			if err := recorder.Record(feature, err); err != nil {
+				rt.Logger.Warn("Failed to record telemetry", "error", err)
+			}
+			// verify is run by sshd for every login, which must not wait for
+			// the endpoint. Its counts are sent by opkssh serve or the next
+			// other command.
+			if feature != "verify" {
+				if err := recorder.Flush(); err != nil {
+					rt.Logger.Warn("Failed to send telemetry", "error", err)
+				}
+			}
+		}
+	}
+	var exitErr *commands.ExitCodeError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	} else if err != nil {
 		return 1
 	}
 	return 0
 }
 
+// newTelemetryRecorder returns a Recorder if telemetry is enabled in the
+// server config or, when there is no readable server config, in the client
+// config. Telemetry is off by default.
+func newTelemetryRecorder(rt *commands.Runtime) *telemetry.Recorder {
+	var cfg config.TelemetryConfig
+	var statePath string
+	if serverConfig, err := commands.LoadServerConfig(rt.Fs, policy.Defaults.ConfigPath); err == nil && serverConfig != nil {
+		cfg = serverConfig.Telemetry
+		statePath = filepath.Join(policy.TelemetryDir(), "telemetry.json")
+	} else if clientConfig, err := config.GetClientConfigFromFile("", rt.Fs); err == nil {
+		cfg = clientConfig.Telemetry
+		if dir, err := config.DefaultClientConfigDir(); err == nil {
+			statePath = filepath.Join(dir, "telemetry.json")
+		}
+	}
+	if !cfg.Enabled || statePath == "" {
+		return nil
+	}
+	recorder, err := telemetry.NewRecorder(rt.Fs, statePath, cfg.Endpoint, cfg.Interval, Version)
+	if err != nil {
+		rt.Logger.Warn("Telemetry disabled", "error", err)
+		return nil
+	}
+	return recorder
+}
+
+// newAdminAddCmd returns an AddCmd configured from the server config. The
+// server config is optional and unreadable by non-root users, who can only
+// update their home policy. Any other problem with it is an error, as
+// ignoring it would drop dual control.
+func newAdminAddCmd(rt *commands.Runtime, username string) (*commands.AddCmd, error) {
+	homePolicyLoader := policy.NewHomePolicyLoader()
+	homePolicyLoader.FileLoader.Fs = rt.Fs
+	homePolicyLoader.UserLookup = rt.UserLookup
+	systemPolicyLoader := policy.NewSystemPolicyLoader()
+	systemPolicyLoader.FileLoader.Fs = rt.Fs
+	systemPolicyLoader.FileLoader.Backups = files.NewBackups(rt.Fs, policy.SystemDefaultBackupDir)
+	systemPolicyLoader.UserLookup = rt.UserLookup
+	add := &commands.AddCmd{
+		HomePolicyLoader:   homePolicyLoader,
+		SystemPolicyLoader: systemPolicyLoader,
+		Username:           username,
+		Journal:            policy.NewJournal(),
+		Groups:             policy.NewOsGroupLookup(),
+		Pending:            policy.NewPendingStore(),
+	}
+
+	serverConfigPath := policy.Defaults.ConfigPath
+	serverConfig, err := commands.LoadServerConfig(rt.Fs, serverConfigPath)
+	if errors.Is(err, os.ErrPermission) {
+		return add, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to load server config %s: %w", serverConfigPath, err)
+	}
+	if serverConfig != nil {
+		add.DualControlPrincipals = serverConfig.DualControl.Principals
+		commands.ConfigureBackups(add.SystemPolicyLoader, serverConfig.PolicyBackups)
+		if err := commands.ConfigurePolicyStore(add.SystemPolicyLoader, serverConfig.PolicyStore); err != nil {
+			return nil, err
+		}
+		if err := commands.ConfigureNotifications(events.Default(), serverConfig.Notifications); err != nil {
+			fmt.Fprintf(rt.ErrOut, "Warning: ignoring invalid notifications in server config: %v\n", err)
+		}
+	}
+	return add, nil
+}
+
+// loadRequiredServerConfig loads the server config for admin commands that
+// can not run without it and configures notifications from it
+func loadRequiredServerConfig(rt *commands.Runtime) (*config.ServerConfig, error) {
+	serverConfigPath := policy.Defaults.ConfigPath
+	serverConfig, err := commands.LoadServerConfig(rt.Fs, serverConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server config: %w", err)
+	}
+	if serverConfig == nil {
+		return nil, fmt.Errorf("server config %s not found", serverConfigPath)
+	}
+	if err := commands.ConfigureNotifications(events.Default(), serverConfig.Notifications); err != nil {
+		fmt.Fprintf(rt.ErrOut, "Warning: ignoring invalid notifications in server config: %v\n", err)
+	}
+	return serverConfig, nil
+}
+
+// expandIssuerAlias returns the issuer URL for the convenience aliases users
+// may type instead of the full issuer (who is going to remember the hideous
+// Azure issuer string). The aliases in providers.yml come first.
+func expandIssuerAlias(issuer string) string {
+	if !strings.Contains(issuer, "://") {
+		providerPolicy, err := policy.NewProviderFileLoader().LoadProviderPolicy(policy.Defaults.ProvidersPath)
+		if err == nil {
+			if expanded, ok := providerPolicy.ExpandAlias(issuer); ok {
+				return expanded
+			}
+		}
+	}
+	switch issuer {
+	case "google":
+		return "https://accounts.google.com"
+	case "azure", "microsoft":
+		return "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0"
+	case "gitlab":
+		return "https://gitlab.com"
+	case "hello":
+		return "https://issuer.hello.coop"
+	}
+	return issuer
+}
+
+// newLocalVerifyCmd returns the verify command that checks the logins as
+// userArg with the providers, server config and policy of this host
+func newLocalVerifyCmd(rt *commands.Runtime, userArg string, serverConfigPath string, connection string) (*commands.VerifyCmd, error) {
+	providerPolicyPath := policy.Defaults.ProvidersPath
+	providerPolicy, err := policy.NewProviderFileLoader().LoadProviderPolicy(providerPolicyPath)
+	if err != nil {
+		slog.Error("Failed to open the providers file", "path", providerPolicyPath, "error", err)
+		eventlog.Report(eventlog.PolicyLoadFailed, "Failed to open %s: %v", providerPolicyPath, err)
+		return nil, err
+	}
+
+	printConfigProblems()
+	slog.Info("Providers loaded", "providers", providerPolicy.ToString())
+	providerPolicy.JWKSCache = policy.NewJWKSCache()
+	providerPolicy.Now = rt.Now
+
+	pktVerifier, err := providerPolicy.CreateVerifier()
+	if err != nil {
+		slog.Error("Failed to create the PK Token verifier, the configuration is likely bad", "error", err)
+		return nil, err
+	}
+
+	v := commands.NewVerifyCmd(rt.Fs, *pktVerifier, nil, serverConfigPath)
+	v.ProviderPolicy = providerPolicy
+	v.ConnectionArg = connection
+	if err := v.ReadFromServerConfig(); err != nil {
+		slog.Warn("Failed to set the environment variables of the config", "error", err)
+	}
+	preflightMode := ""
+	if v.ServerConfig != nil {
+		preflightMode = v.ServerConfig.Preflight
+	}
+	if _, err := commands.NewVerifyPreflight(rt, preflightMode).Run(); err != nil {
+		slog.Error("Refusing to verify", "error", err)
+		eventlog.Report(eventlog.VerifyFailed, "Refusing to verify: %v", err)
+		return nil, err
+	}
+	// The policy enforcer depends on the server config so it is only
+	// created once the config has been read
+	v.CheckPolicy = commands.OpkPolicyEnforcerFunc(userArg, v.ServerConfig, v.ProviderPolicy, v.RecordMatch)
+	return v, nil
+}
+
 func printConfigProblems() {
 	problems := files.ConfigProblems().GetProblems()
 	if len(problems) > 0 {
-		log.Println("Warning: Encountered the following configuration problems:")
 		for _, problem := range problems {
-			log.Println(problem.String())
+			slog.Warn("Configuration problem", "problem", problem.String())
 		}
 	}
 }
@@ -539,50 +1752,20 @@ func printConfigProblems() {
 func checkOpenSSHVersion() {
 	version := sysdetails.GetOpenSSHVersion()
 	if version == "" {
-		log.Println("Warning: Could not determine OpenSSH version")
+		slog.Warn("Could not determine the OpenSSH version")
 		return
 	}
 
 	if ok, _ := isOpenSSHVersion8Dot1OrGreater(version); !ok {
-		log.Println("Warning: OpenPubkey SSH requires OpenSSH v. 8.1 or greater")
+		slog.Warn("OpenPubkey SSH requires OpenSSH v. 8.1 or greater", "version", version)
 	}
 }
 
 func isOpenSSHVersion8Dot1OrGreater(opensshVersion string) (bool, error) {
-	// Extract version number from various formats:
-	// - "OpenSSH_9.5p1" -> "9.5"
-	// - "OpenSSH_for_Windows_9.5p2, LibreSSL 3.8.2" -> "9.5"
-
-	// First, get the part before comma (to handle LibreSSL suffix on Windows)
-	opensshVersion = strings.Split(opensshVersion, ",")[0]
-
-	// Try to extract version using regex that handles both Unix and Windows formats
-	// Matches: "OpenSSH_9.5", "OpenSSH_for_Windows_9.5", etc.
-	re, err := regexp.Compile(`OpenSSH[_a-zA-Z]*[_](\d+\.\d+)`)
+	ok, err := sysdetails.OpenSSHVersionAtLeast(opensshVersion, "v8.1")
 	if err != nil {
-		fmt.Println("Error compiling regex:", err)
+		slog.Warn("Failed to compare the OpenSSH version", "error", err)
 		return false, err
 	}
-
-	matches := re.FindStringSubmatch(opensshVersion)
-
-	if len(matches) < 2 {
-		// If regex didn't match, try a simpler approach: find any version pattern
-		simpleRe := regexp.MustCompile(`(\d+\.\d+)`)
-		matches = simpleRe.FindStringSubmatch(opensshVersion)
-
-		if len(matches) < 2 {
-			log.Printf("Invalid OpenSSH version format: %s", opensshVersion)
-			return false, fmt.Errorf("invalid OpenSSH version format: %s", opensshVersion)
-		}
-	}
-
-	version := "v" + matches[1] // semver requires that version strings start with 'v'
-	// OpenSSH doesn't use semantic versioning, but does use major.minor which after stripping the patch version can be compared using semver
-	if semver.Compare(version, "v8.1.0") >= 0 {
-		// if version is greater than or equal to v8.1.0
-		return true, nil
-	}
-
-	return false, nil
+	return ok, nil
 }
@@ -202,6 +202,12 @@ func TestRun(t *testing.T) {
 			wantOutput: "Audit validates all entries",
 			wantExit:   0,
 		},
+		{
+			name:       "Permissions check exits with 2 on an invalid flag",
+			args:       []string{"opkssh", "permissions", "check", "--bogus"},
+			wantOutput: "unknown flag: --bogus",
+			wantExit:   2,
+		},
 		{
 			name:       "Login Help flag",
 			args:       []string{"opkssh", "login", "--help"},
@@ -0,0 +1,142 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/verifier"
+)
+
+// SupportedAlgs are the ID Token signature algorithms that can be verified,
+// and the algorithms allowed for a provider that doesn't set allowed_algs
+var SupportedAlgs = []string{"RS256", "ES256", "EdDSA"}
+
+// unverifiableAlgs are asymmetric JWS algorithms that providers may use but
+// whose ID Tokens opkssh can't verify yet: the provider verifier and key
+// discovery of openpubkey v0.22.0 only accept RS256, ES256 and EdDSA. ES384
+// was requested and is the first to add once openpubkey verifies it.
+var unverifiableAlgs = []string{"RS384", "RS512", "ES384", "ES512", "PS256", "PS384", "PS512"}
+
+// AlgorithmError is a token signed with an algorithm that is not accepted.
+// Symmetric algorithms such as HS256 and unsigned tokens are never accepted
+// because anyone who knows the key, which for a provider is published in its
+// JWKS, could sign the token.
+type AlgorithmError struct {
+	// Token is the token that was rejected, "ID Token" or "PK Token"
+	Token string
+	Alg   string
+}
+
+func (e *AlgorithmError) Error() string {
+	if isSymmetricAlg(e.Alg) {
+		return fmt.Sprintf("%s is signed with %q, symmetric and unsigned tokens are never accepted", e.Token, e.Alg)
+	}
+	return fmt.Sprintf("%s is signed with %q, which is not allowed for this provider", e.Token, e.Alg)
+}
+
+func isSymmetricAlg(alg string) bool {
+	return alg == "" || strings.EqualFold(alg, "none") || strings.HasPrefix(strings.ToUpper(alg), "HS")
+}
+
+// ValidateAllowedAlgs checks the allowed_algs of a provider
+func ValidateAllowedAlgs(algs []string) error {
+	for _, alg := range algs {
+		if isSymmetricAlg(alg) {
+			return fmt.Errorf("allowed_algs can't contain %q, symmetric and unsigned tokens are never accepted", alg)
+		}
+		if slices.Contains(unverifiableAlgs, alg) {
+			return fmt.Errorf("allowed_algs can't contain %q, opkssh can't verify ID Tokens signed with it yet, use one of %s", alg, strings.Join(SupportedAlgs, ", "))
+		}
+		if !slices.Contains(SupportedAlgs, alg) {
+			return fmt.Errorf("unsupported algorithm %q in allowed_algs, expected one of %s", alg, strings.Join(SupportedAlgs, ", "))
+		}
+	}
+	return nil
+}
+
+// algorithmVerifier rejects ID Tokens that are not signed with one of the
+// allowed algorithms, and PK Tokens whose client key is symmetric, before
+// they reach the provider
+type algorithmVerifier struct {
+	verifier.ProviderVerifier
+	allowed []string
+}
+
+func (a algorithmVerifier) VerifyIDToken(ctx context.Context, idt []byte, cic *clientinstance.Claims) error {
+	alg, err := idTokenAlg(idt)
+	if err != nil {
+		return err
+	}
+	if isSymmetricAlg(alg) || !slices.Contains(a.allowed, alg) {
+		return &AlgorithmError{Token: "ID Token", Alg: alg}
+	}
+	if cic != nil && cic.PublicKey() != nil {
+		if cicAlg := cic.KeyAlgorithm().String(); isSymmetricAlg(cicAlg) {
+			return &AlgorithmError{Token: "PK Token", Alg: cicAlg}
+		}
+	}
+	return a.ProviderVerifier.VerifyIDToken(ctx, idt, cic)
+}
+
+// idTokenAlg returns the algorithm the provider signed the ID Token idt
+// with. The provider signature of a GQ signed ID Token is replaced by a GQ
+// signature and its original protected header is kept in the kid.
+func idTokenAlg(idt []byte) (string, error) {
+	protected, _, _, err := oidc.SplitCompact(idt)
+	if err != nil {
+		return "", err
+	}
+	header, err := decodeHeader(string(protected))
+	if err != nil {
+		return "", err
+	}
+	if header.Alg == "GQ256" {
+		header, err = decodeHeader(header.Kid)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the original header of a GQ signed ID Token: %w", err)
+		}
+		if header.Alg == "GQ256" {
+			return "", fmt.Errorf("GQ signed ID Token has a GQ256 original header")
+		}
+	}
+	return header.Alg, nil
+}
+
+type jwsHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func decodeHeader(encoded string) (*jwsHeader, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ID Token header: %w", err)
+	}
+	header := &jwsHeader{}
+	if err := json.Unmarshal(decoded, header); err != nil {
+		return nil, fmt.Errorf("failed to parse ID Token header: %w", err)
+	}
+	return header, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/stretchr/testify/require"
+)
+
+func testSignedIDToken(header string) []byte {
+	return []byte(base64.RawURLEncoding.EncodeToString([]byte(header)) + ".e30.c2ln")
+}
+
+func TestIDTokenAlg(t *testing.T) {
+	alg, err := idTokenAlg(testSignedIDToken(`{"alg":"ES256","kid":"1"}`))
+	require.NoError(t, err)
+	require.Equal(t, "ES256", alg)
+
+	// A GQ signed ID Token keeps the header of the provider in its kid
+	original := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"1"}`))
+	alg, err = idTokenAlg(testSignedIDToken(`{"alg":"GQ256","kid":"` + original + `"}`))
+	require.NoError(t, err)
+	require.Equal(t, "RS256", alg)
+
+	_, err = idTokenAlg(testSignedIDToken(`{"alg":"GQ256","kid":"1"}`))
+	require.ErrorContains(t, err, "original header")
+	_, err = idTokenAlg([]byte("not-a-token"))
+	require.Error(t, err)
+}
+
+func TestValidateAllowedAlgs(t *testing.T) {
+	require.NoError(t, ValidateAllowedAlgs(nil))
+	require.NoError(t, ValidateAllowedAlgs([]string{"ES256", "EdDSA"}))
+	require.ErrorContains(t, ValidateAllowedAlgs([]string{"HS256"}), "symmetric and unsigned tokens are never accepted")
+	require.ErrorContains(t, ValidateAllowedAlgs([]string{"none"}), "symmetric and unsigned tokens are never accepted")
+	require.ErrorContains(t, ValidateAllowedAlgs([]string{"ES384"}), `allowed_algs can't contain "ES384", opkssh can't verify ID Tokens signed with it yet, use one of RS256, ES256, EdDSA`)
+	require.ErrorContains(t, ValidateAllowedAlgs([]string{"ES999"}), `unsupported algorithm "ES999" in allowed_algs, expected one of RS256, ES256, EdDSA`)
+
+	_, errs := ParseProvidersYAML([]byte("providers:\n  - issuer: https://example.com\n    client_ids: [a]\n    expiration_policy: 24h\n    allowed_algs: [HS256]\n"))
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "provider 1 (https://example.com): allowed_algs can't contain \"HS256\"")
+}
+
+func TestAlgorithmVerifier(t *testing.T) {
+	tests := []struct {
+		alg     string
+		allowed []string
+		wantErr string
+	}{
+		{alg: "RS256", allowed: SupportedAlgs},
+		{alg: "ES256", allowed: SupportedAlgs},
+		{alg: "EdDSA", allowed: SupportedAlgs},
+		{alg: "ES256", allowed: []string{"ES256"}},
+		{alg: "RS256", allowed: []string{"ES256"}, wantErr: `ID Token is signed with "RS256", which is not allowed for this provider`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			providerOpts := providers.DefaultMockProviderOpts()
+			providerOpts.Alg = tt.alg
+			op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+			require.NoError(t, err)
+			idtTemplate.ExtraClaims = map[string]any{"email": "alice@example.com"}
+			signer, err := util.GenKeyPair(jwa.ES256)
+			require.NoError(t, err)
+			opkClient, err := client.New(op, client.WithSigner(signer, jwa.ES256))
+			require.NoError(t, err)
+			pkt, err := opkClient.Auth(context.Background())
+			require.NoError(t, err)
+
+			pv := verifier.ProviderVerifierExpires{
+				ProviderVerifier: algorithmVerifier{ProviderVerifier: op, allowed: tt.allowed},
+				Expiration:       verifier.ExpirationPolicies.NEVER_EXPIRE,
+			}
+			pktVerifier, err := verifier.NewFromMany([]verifier.ProviderVerifier{pv})
+			require.NoError(t, err)
+			err = pktVerifier.VerifyPKToken(context.Background(), pkt)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			var algErr *AlgorithmError
+			require.ErrorAs(t, err, &algErr)
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+
+	// Alg confusion is refused before the provider is asked to verify
+	a := algorithmVerifier{ProviderVerifier: nil, allowed: []string{"HS256"}}
+	for _, header := range []string{`{"alg":"HS256"}`, `{"alg":"none"}`, `{}`} {
+		err := a.VerifyIDToken(context.Background(), testSignedIDToken(header), nil)
+		var algErr *AlgorithmError
+		require.ErrorAs(t, err, &algErr, header)
+		require.ErrorContains(t, err, "symmetric and unsigned tokens are never accepted")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import "github.com/openpubkey/opkssh/policy/files"
+
+// ServerDefaults are the accounts, paths and expiration policy an opkssh
+// server uses when no flag or server config setting says otherwise
+type ServerDefaults struct {
+	files.ServerAccounts
+	// ConfigPath is the server config
+	ConfigPath string
+	// PolicyPath is the system policy
+	PolicyPath string
+	// ProvidersPath is the providers file
+	ProvidersPath string
+	// FragmentDir holds the policy fragments
+	FragmentDir string
+	// PolicyDBPath is the database of the sqlite policy store
+	PolicyDBPath string
+	// ExpirationPolicy applies to providers in providers.yml without an
+	// expiration_policy
+	ExpirationPolicy string
+}
+
+// Defaults are the ServerDefaults of this host
+var Defaults = ServerDefaults{
+	ServerAccounts:   files.DefaultServerAccounts,
+	ConfigPath:       SystemDefaultServerConfigPath,
+	PolicyPath:       SystemDefaultPolicyPath,
+	ProvidersPath:    SystemDefaultProvidersPath,
+	FragmentDir:      SystemDefaultFragmentDir,
+	PolicyDBPath:     SystemDefaultPolicyDBPath,
+	ExpirationPolicy: "24h",
+}
@@ -0,0 +1,46 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+// Codes of a DenialError
+const (
+	// DenyCodeDenyList is a login by an email or as a user in a deny list
+	DenyCodeDenyList = "deny_list"
+	// DenyCodeRevoked is a login by an identity that was revoked
+	DenyCodeRevoked = "revoked"
+	// DenyCodeNoPolicy is a login that no policy allows
+	DenyCodeNoPolicy = "no_policy"
+	// DenyCodeRateLimited is a login refused after too many failed logins
+	// from the same client as the same principal
+	DenyCodeRateLimited = "rate_limited"
+	// DenyCodeReplay is a PK Token already used on another host or client
+	DenyCodeReplay = "replay"
+)
+
+// DenialError is returned by CheckPolicy when policy denies the login, as
+// opposed to an error that prevented checking it
+type DenialError struct {
+	Code string
+	// PluginReasons are the reasons given by the policy plugins that denied
+	// the login
+	PluginReasons []string
+	msg           string
+}
+
+func (e *DenialError) Error() string {
+	return e.msg
+}
@@ -20,31 +20,107 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/openpubkey/openpubkey/pktoken"
+	"github.com/openpubkey/opkssh/internal/eventlog"
+	"github.com/openpubkey/opkssh/policy/ldap"
 	"github.com/openpubkey/opkssh/policy/plugins"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slices"
 )
 
 const (
 	OIDC_CLAIMS         = "oidc:"
 	OIDC_WILDCARD_EMAIL = "oidc-match-end:email:"
+	// EMAIL_DOMAIN matches every verified email address of a domain, e.g.
+	// domain:example.com
+	EMAIL_DOMAIN = "domain:"
 )
 
 // DenyList represents the DenyLists in the server config
 type DenyList struct {
 	Emails []string
 	Users  []string
+	// Revoked are identities revoked at their provider, see RevocationList
+	Revoked []RevokedIdentity
 }
 
 // Enforcer evaluates opkssh policy to determine if the desired principal is
 // permitted
 type Enforcer struct {
 	PolicyLoader Loader
+	// PluginWorkers and PluginTimeout set how the policy plugins run, 0
+	// uses the defaults of the plugins package
+	PluginWorkers int
+	PluginTimeout time.Duration
+	// PluginConfigs, if set, keeps the plugin configs between logins
+	PluginConfigs *plugins.ConfigCache
+	// PluginTrustedKeys, if not nil, are the keys the plugin configs must
+	// be signed with, see plugins.PolicyPluginEnforcer.TrustedKeys
+	PluginTrustedKeys []ssh.PublicKey
+	// OnAllow, if set, is called with what allowed the login when
+	// CheckPolicy grants access
+	OnAllow func(m Match)
+	// OnPluginResult, if set, is called with the result of every policy
+	// plugin config
+	OnPluginResult func(result *plugins.PluginResult)
+	// Groups resolves the members of %group principals, nil uses
+	// NewOsGroupLookup
+	Groups GroupLookup
+	// LDAP, if set, allows the members of LDAP groups when no policy entry
+	// allows the login
+	LDAP *ldap.Policy
+	// IssuerAliases, if set, makes the entries of an old issuer URL match ID
+	// Tokens of the issuer it was migrated to and the other way around
+	IssuerAliases IssuerAliases
+	// Now returns the current time entry expiries are checked against,
+	// defaults to time.Now
+	Now func() time.Time
+	// Trace, if set, is called with each step of CheckPolicy, used by
+	// opkssh verify --explain
+	Trace func(format string, args ...any)
+}
+
+func (p *Enforcer) trace(format string, args ...any) {
+	if p.Trace != nil {
+		p.Trace(format, args...)
+	}
+}
+
+func (p *Enforcer) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+// Match is the policy entry or plugin that allowed a login
+type Match struct {
+	// Plugin is the path of the plugin config that allowed the login, empty
+	// if it was allowed by a policy entry
+	Plugin string
+	// Entry is the policy entry as a "principal identity issuer" line
+	Entry string
+	// Source is the policy file(s) the entry was loaded from
+	Source string
+	// Options are the authorized_keys options the policy entry or the
+	// plugins that allowed the login restrict it with
+	Options []string
+	// CatchAll is true if the policy entry has the catchall=true option
+	CatchAll bool
+	// Expires is when the policy entry stops allowing logins, zero if never
+	Expires time.Time
+}
+
+func (p *Enforcer) allowed(m Match) {
+	if p.OnAllow != nil {
+		p.OnAllow(m)
+	}
 }
 
 // type for Identity Token checkedClaims
@@ -81,33 +157,127 @@ func (s *checkedClaims) UnmarshalJSON(data []byte) error {
 	s.ExtraClaims = make(map[string][]string, len(raw))
 
 	for k, v := range raw {
-		switch t := v.(type) {
-		case string:
-			s.ExtraClaims[k] = []string{t}
-		case []any:
-			// Turn all elements in a list into a string
-			out := make([]string, 0, len(t))
-			for _, e := range t {
-				if s, ok := e.(string); ok {
-					out = append(out, s)
-				} else {
-					out = append(out, fmt.Sprint(e))
-				}
+		flattenClaim(k, v, s.ExtraClaims)
+	}
+	addKeycloakRoles(s.ExtraClaims)
+
+	return nil
+}
+
+// flattenClaim adds the claim name with value v to out. Nested objects are
+// added under their dotted path, e.g. {"realm_access": {"roles": [...]}} is
+// added as realm_access.roles, so they can be matched by oidc: policy entries.
+func flattenClaim(name string, v any, out map[string][]string) {
+	switch t := v.(type) {
+	case string:
+		out[name] = []string{t}
+	case []any:
+		// Turn all elements in a list into a string
+		values := make([]string, 0, len(t))
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			} else {
+				values = append(values, fmt.Sprint(e))
 			}
-			s.ExtraClaims[k] = out
-		default:
-			// Turn numbers/bools etc into strings
-			s.ExtraClaims[k] = []string{fmt.Sprint(t)}
 		}
+		out[name] = values
+	case map[string]any:
+		for k, nested := range t {
+			flattenClaim(name+"."+k, nested, out)
+		}
+	default:
+		// Turn numbers/bools etc into strings
+		out[name] = []string{fmt.Sprint(t)}
 	}
+}
 
-	return nil
+// addKeycloakRoles adds the Keycloak role claims under shorter names:
+// realm_roles for realm_access.roles and client_roles for the roles of
+// every client in resource_access, each as <client>/<role>. Claims with
+// these names set by the provider are left untouched.
+func addKeycloakRoles(claims map[string][]string) {
+	if _, ok := claims["realm_roles"]; !ok {
+		if roles, ok := claims["realm_access.roles"]; ok {
+			claims["realm_roles"] = roles
+		}
+	}
+	if _, ok := claims["client_roles"]; ok {
+		return
+	}
+	clientRoles := []string{}
+	for k, roles := range claims {
+		client, ok := strings.CutPrefix(k, "resource_access.")
+		if !ok {
+			continue
+		}
+		if client, ok = strings.CutSuffix(client, ".roles"); !ok {
+			continue
+		}
+		for _, role := range roles {
+			clientRoles = append(clientRoles, client+"/"+role)
+		}
+	}
+	if len(clientRoles) > 0 {
+		claims["client_roles"] = clientRoles
+	}
+}
+
+// allowedPrincipal returns the principal of principals that allows
+// principalDesired, either principalDesired itself, a group it is a member
+// of or a pattern matching it. memberOf keeps the groups already resolved
+// during the login.
+func (p *Enforcer) allowedPrincipal(principals []string, principalDesired string, memberOf map[string]bool) (string, bool) {
+	if slices.Contains(principals, principalDesired) {
+		return principalDesired, true
+	}
+	for _, principal := range principals {
+		group, ok := IsGroupPrincipal(principal)
+		if !ok {
+			continue
+		}
+		member, resolved := memberOf[group]
+		if !resolved {
+			groups := p.Groups
+			if groups == nil {
+				groups = NewOsGroupLookup()
+			}
+			var err error
+			if member, err = groups.IsMember(principalDesired, group); err != nil {
+				slog.Warn("Failed to resolve the members of a policy group", "group", group, "error", err)
+			}
+			memberOf[group] = member
+		}
+		if member {
+			return principal, true
+		}
+	}
+	for _, principal := range principals {
+		if !IsPrincipalPattern(principal) {
+			continue
+		}
+		pattern, err := CompilePrincipalPattern(principal)
+		if err != nil {
+			slog.Warn("Skipping policy principal", "error", err)
+			continue
+		}
+		if pattern.Match(principalDesired) {
+			return principal, true
+		}
+	}
+	return "", false
 }
 
 // GetPluginPolicyDir returns the default location for policy plugins.
 // On Unix: /etc/opk/policy.d, On Windows: %ProgramData%\opk\policy.d
 func GetPluginPolicyDir() string {
-	return filepath.Join(GetSystemConfigBasePath(), "policy.d")
+	return SystemConfigPath("policy.d")
+}
+
+// GetPluginCacheDir returns where the results of policy plugins with a
+// cache_ttl are kept. It must be writable by the AuthorizedKeysCommandUser.
+func GetPluginCacheDir() string {
+	return filepath.Join(GetSystemStateBasePath(), "plugin-cache")
 }
 
 // EscapedSplit splits a string by a separator while ignoring the separator in quoted sections.
@@ -124,6 +294,18 @@ func EscapedSplit(s string, sep rune) []string {
 	return a
 }
 
+// emailInDomain returns true if the domain of email is domain
+func emailInDomain(email string, domain string) bool {
+	at := strings.LastIndex(email, "@")
+	return at > 0 && domain != "" && strings.EqualFold(email[at+1:], domain)
+}
+
+// emailVerified returns true if the provider asserts that it verified the
+// email address. Some providers send email_verified as a string.
+func (s *checkedClaims) emailVerified() bool {
+	return slices.Equal(s.ExtraClaims["email_verified"], []string{"true"})
+}
+
 // Validates that the server defined identity attribute matches the
 // respective claim from the identity token
 func validateClaim(claims *checkedClaims, user *User) bool {
@@ -143,6 +325,13 @@ func validateClaim(claims *checkedClaims, user *User) bool {
 		)
 	}
 
+	// Should we match on the email domain? Anyone can put an address of
+	// any domain in their profile at some providers, so the provider must
+	// assert that it verified the address.
+	if domain, ok := strings.CutPrefix(user.IdentityAttribute, EMAIL_DOMAIN); ok {
+		return emailInDomain(claims.Email, domain) && claims.emailVerified()
+	}
+
 	// Should we match on the email wildcard claim?
 	wildCardEmailMatch := false
 	if strings.HasPrefix(user.IdentityAttribute, OIDC_WILDCARD_EMAIL) {
@@ -178,24 +367,40 @@ func (p *Enforcer) CheckPolicy(principalDesired string, pkt *pktoken.PKToken, us
 	// Enforce deny list first
 	for _, email := range denyList.Emails {
 		if strings.EqualFold(claims.Email, email) {
-			return fmt.Errorf("denied email %s", email)
+			return &DenialError{Code: DenyCodeDenyList, msg: fmt.Sprintf("denied email %s", email)}
 		}
 	}
 	for _, user := range denyList.Users {
 		if strings.EqualFold(principalDesired, user) {
-			return fmt.Errorf("denied user %s", user)
+			return &DenialError{Code: DenyCodeDenyList, msg: fmt.Sprintf("denied user %s", user)}
 		}
 	}
+	for _, revoked := range denyList.Revoked {
+		if revoked.Matches(issuer, claims.Sub, claims.Email) {
+			return &DenialError{Code: DenyCodeRevoked, msg: fmt.Sprintf("identity (sub=%s, email=%s) was revoked by issuer %s: %s", claims.Sub, claims.Email, issuer, revoked.Reason)}
+		}
+	}
+	p.trace("Not in the deny lists (%d emails, %d users, %d revoked identities)", len(denyList.Emails), len(denyList.Users), len(denyList.Revoked))
 
 	pluginPolicy := plugins.NewPolicyPluginEnforcer()
+	pluginPolicy.CacheDir = GetPluginCacheDir()
+	pluginPolicy.Workers = p.PluginWorkers
+	pluginPolicy.Timeout = p.PluginTimeout
+	pluginPolicy.Configs = p.PluginConfigs
+	pluginPolicy.TrustedKeys = p.PluginTrustedKeys
 	pluginPolicyDir := GetPluginPolicyDir()
 
+	// Why plugins denied, added to the error if nothing else allows
+	var pluginReasons, pluginDenials []string
 	results, err := pluginPolicy.CheckPolicies(pluginPolicyDir, pkt, userInfoJson, principalDesired, sshCert, keyType, extraArgs)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			log.Println("Skipping policy plugins: no plugins found at " + pluginPolicyDir)
+			slog.Debug("Skipping policy plugins, none found", "dir", pluginPolicyDir)
+			p.trace("No policy plugins in %s", pluginPolicyDir)
 		} else {
-			log.Printf("Error checking policy plugins: %v \n", err)
+			p.trace("Failed to run the policy plugins in %s: %v", pluginPolicyDir, err)
+			slog.Error("Failed to check the policy plugins", "error", err)
+			eventlog.Report(eventlog.PluginFailed, "Error checking policy plugins in %s: %v", pluginPolicyDir, err)
 		}
 		// Despite the error, we don't fail here because we still want to check
 		// the standard policy below. Policy plugins can only expand the set of
@@ -203,18 +408,48 @@ func (p *Enforcer) CheckPolicy(principalDesired string, pkt *pktoken.PKToken, us
 	} else {
 		for _, result := range results {
 			commandRunStr := strings.Join(result.CommandRun, " ")
-			log.Printf("Policy plugin result, path: (%s), allowed: (%t), error: (%v), command_run: (%s), policyOutput: (%s)\n", result.Path, result.Allowed, result.Error, commandRunStr, result.PolicyOutput)
+			slog.Info("Policy plugin result", "path", result.Path, "allowed", result.Allowed, "error", result.Error, "command_run", commandRunStr, "output", result.PolicyOutput, "reason", result.Reason, "cached", result.Cached)
+			p.tracePlugin(result, commandRunStr)
+			if !result.Allowed && result.Reason != "" {
+				pluginReasons = append(pluginReasons, result.Reason)
+				pluginDenials = append(pluginDenials, fmt.Sprintf("%s: %s", result.Path, result.Reason))
+			}
+			if result.Error != nil {
+				eventlog.Report(eventlog.PluginFailed, "Policy plugin %s failed: %v", result.Path, result.Error)
+			}
+			if result.CacheErr != nil {
+				slog.Warn("Policy plugin cache not used", "path", result.Path, "error", result.CacheErr)
+			}
+			if p.OnPluginResult != nil {
+				p.OnPluginResult(result)
+			}
 		}
 		if results.Allowed() {
-			log.Printf("Access granted by policy plugin\n")
+			// Fail closed, the restrictions of every plugin must apply
+			options, err := results.Options()
+			if err != nil {
+				return err
+			}
+			slog.Info("Access granted by policy plugin")
+			for _, result := range results {
+				if result.Allowed {
+					p.allowed(Match{Plugin: result.Path, Options: options})
+					break
+				}
+			}
+			if len(options) > 0 {
+				p.trace("The policy plugins restrict the login with %s", strings.Join(options, ","))
+			}
 			return nil
 		}
 	}
 
 	policy, source, err := p.PolicyLoader.Load()
 	if err != nil {
+		eventlog.Report(eventlog.PolicyLoadFailed, "Error loading policy: %v", err)
 		return fmt.Errorf("error loading policy: %w", err)
 	}
+	p.trace("Checking %d policy entries from %s", len(policy.Users), source.Source())
 
 	var userInfoClaims *checkedClaims
 	if userInfoJson != "" {
@@ -224,6 +459,7 @@ func (p *Enforcer) CheckPolicy(principalDesired string, pkt *pktoken.PKToken, us
 		}
 	}
 
+	memberOf := map[string]bool{}
 	for _, user := range policy.Users {
 		// The underlying library checks idT.sub == userInfo.sub when we call the userinfo endpoint.
 		// We want to be extra sure so we also check it here as well.
@@ -231,28 +467,94 @@ func (p *Enforcer) CheckPolicy(principalDesired string, pkt *pktoken.PKToken, us
 			return fmt.Errorf("userInfo sub claim (%s) does not match user policy sub claim (%s)", userInfoClaims.Sub, claims.Sub)
 		}
 
-		if issuer != user.Issuer {
+		entry := strings.Join(user.Principals, ",") + " " + user.IdentityAttribute + " " + user.Issuer
+		if !p.IssuerAliases.Equivalent(issuer, user.Issuer) {
+			p.trace("  %s: skipped, the issuer of the ID Token is %s", entry, issuer)
+			continue
+		} else if issuer != user.Issuer {
+			p.trace("  %s: the issuer %s of the entry and %s of the ID Token are aliases", entry, user.Issuer, issuer)
+		}
+
+		if user.Expired(p.now()) {
+			slog.Debug("Skipping expired policy entry", "identity", user.IdentityAttribute, "expired", FormatExpiry(user.Expires))
+			p.trace("  %s: skipped, expired at %s", entry, FormatExpiry(user.Expires))
 			continue
 		}
 
 		// if they are, then check if the desired principal is allowed
-		if !slices.Contains(user.Principals, principalDesired) {
+		principal, ok := p.allowedPrincipal(user.Principals, principalDesired, memberOf)
+		if !ok {
+			p.trace("  %s: skipped, does not grant %s", entry, principalDesired)
 			continue
 		}
 
+		match := Match{Entry: principal + " " + user.IdentityAttribute + " " + user.Issuer, Source: source.Source(), Options: user.KeyOptions, CatchAll: user.CatchAll, Expires: user.Expires}
+
 		// check each entry to see if the user in the checkedClaims is included
 		if validateClaim(&claims, &user) {
 			// access granted
+			p.trace("  %s: matches the ID Token", entry)
+			p.allowed(match)
 			return nil
 		}
 
 		// check each entry to see if the user matches the userInfoClaims
 		if userInfoClaims != nil && validateClaim(userInfoClaims, &user) {
 			// access granted
+			p.trace("  %s: matches the userinfo claims", entry)
+			p.allowed(match)
+			return nil
+		}
+		if domain, ok := strings.CutPrefix(user.IdentityAttribute, EMAIL_DOMAIN); ok && emailInDomain(claims.Email, domain) {
+			p.trace("  %s: skipped, the provider does not assert email_verified for %s", entry, claims.Email)
+			continue
+		}
+		p.trace("  %s: skipped, %s does not match the claims of the ID Token", entry, user.IdentityAttribute)
+	}
+
+	if p.LDAP != nil {
+		rule, err := p.LDAP.Check(principalDesired, issuer, claims.ExtraClaims)
+		if err != nil {
+			slog.Error("Failed to check the LDAP group policy", "error", err)
+			p.trace("Failed to check the LDAP group policy: %v", err)
+			eventlog.Report(eventlog.PolicyLoadFailed, "Error checking LDAP group policy in %s: %v", p.LDAP.Source, err)
+		} else if rule != nil {
+			slog.Info("Access granted by LDAP group", "group", rule.GroupDN)
+			p.trace("LDAP group %s of %s grants %s", rule.GroupDN, p.LDAP.Source, principalDesired)
+			p.allowed(Match{Entry: principalDesired + " ldap:" + rule.GroupDN + " " + rule.Issuer, Source: p.LDAP.Source})
 			return nil
 		}
+		p.trace("No LDAP group of %s grants %s", p.LDAP.Source, principalDesired)
+	}
 
+	msg := fmt.Sprintf("no policy to allow %s with (issuer=%s) to assume %s, check policy config at %s", claims.Email, issuer, principalDesired, source.Source())
+	if len(pluginDenials) > 0 {
+		msg += fmt.Sprintf(", policy plugins denied (%s)", strings.Join(pluginDenials, "; "))
 	}
+	return &DenialError{Code: DenyCodeNoPolicy, PluginReasons: pluginReasons, msg: msg}
+}
 
-	return fmt.Errorf("no policy to allow %s with (issuer=%s) to assume %s, check policy config at %s", claims.Email, issuer, principalDesired, source.Source())
+// tracePlugin traces the result of a policy plugin config
+func (p *Enforcer) tracePlugin(result *plugins.PluginResult, commandRun string) {
+	if p.Trace == nil {
+		return
+	}
+	decision := "denied"
+	if result.Allowed {
+		decision = "allowed"
+	}
+	cached := ""
+	if result.Cached {
+		cached = " (cached)"
+	}
+	p.trace("Policy plugin %s %s%s, command: %s", result.Path, decision, cached, commandRun)
+	if result.PolicyOutput != "" {
+		p.trace("  output: %s", strings.TrimSpace(result.PolicyOutput))
+	}
+	if result.Reason != "" {
+		p.trace("  reason: %s", result.Reason)
+	}
+	if result.Error != nil {
+		p.trace("  error: %v", result.Error)
+	}
 }
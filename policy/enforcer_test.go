@@ -19,12 +19,16 @@ package policy_test
 import (
 	"context"
 	"fmt"
+	"slices"
 	"testing"
+	"time"
 
+	goldap "github.com/go-ldap/ldap/v3"
 	"github.com/openpubkey/openpubkey/client"
 	"github.com/openpubkey/openpubkey/providers"
 	"github.com/openpubkey/openpubkey/providers/mocks"
 	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/ldap"
 	"github.com/stretchr/testify/require"
 )
 
@@ -198,13 +202,265 @@ func TestPolicyApproved(t *testing.T) {
 	pkt, err := opkClient.Auth(context.Background())
 	require.NoError(t, err)
 
+	var matches []policy.Match
 	policyEnforcer := &policy.Enforcer{
 		PolicyLoader: &MockPolicyLoader{Policy: policyTest},
+		OnAllow:      func(m policy.Match) { matches = append(matches, m) },
 	}
 
 	// Check that policy file is properly parsed and checked
 	err = policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
 	require.NoError(t, err)
+	require.Equal(t, []policy.Match{{Entry: "test arthur.aardvark@example.com https://accounts.example.com", Source: "<mock data>"}}, matches)
+}
+
+func TestPolicyKeyOptions(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var matches []policy.Match
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{
+			Users: []policy.User{
+				{
+					IdentityAttribute: "arthur.aardvark@example.com",
+					Principals:        []string{"backup"},
+					Issuer:            "https://accounts.example.com",
+					KeyOptions:        []string{"no-pty", `command="/usr/bin/rrsync -ro /backup"`},
+				},
+			},
+		}},
+		OnAllow: func(m policy.Match) { matches = append(matches, m) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("backup", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Equal(t, []policy.Match{{
+		Entry:   "backup arthur.aardvark@example.com https://accounts.example.com",
+		Source:  "<mock data>",
+		Options: []string{"no-pty", `command="/usr/bin/rrsync -ro /backup"`},
+	}}, matches)
+}
+
+func TestPolicyCatchAllMatch(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var matches []policy.Match
+	expires := time.Now().Add(time.Hour)
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{
+			Users: []policy.User{
+				{
+					IdentityAttribute: "arthur.aardvark@example.com",
+					Principals:        []string{"*"},
+					Issuer:            "https://accounts.example.com",
+					CatchAll:          true,
+					Expires:           expires,
+				},
+			},
+		}},
+		OnAllow: func(m policy.Match) { matches = append(matches, m) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("root", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Len(t, matches, 1)
+	require.True(t, matches[0].CatchAll)
+	require.Equal(t, expires, matches[0].Expires)
+}
+
+// mockGroupLookup maps group names to their members
+type mockGroupLookup map[string][]string
+
+func (m mockGroupLookup) IsMember(username string, group string) (bool, error) {
+	members, ok := m[group]
+	if !ok {
+		return false, fmt.Errorf("group %s not found", group)
+	}
+	return slices.Contains(members, username), nil
+}
+
+func TestPolicyGroupPrincipal(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var matches []policy.Match
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{
+			Users: []policy.User{
+				{
+					IdentityAttribute: "arthur.aardvark@example.com",
+					Principals:        []string{"%missing", "%sshadmins"},
+					Issuer:            "https://accounts.example.com",
+				},
+			},
+		}},
+		Groups:  mockGroupLookup{"sshadmins": {"alice", "bob"}},
+		OnAllow: func(m policy.Match) { matches = append(matches, m) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("bob", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Equal(t, []policy.Match{{Entry: "%sshadmins arthur.aardvark@example.com https://accounts.example.com", Source: "<mock data>"}}, matches)
+
+	err = policyEnforcer.CheckPolicy("carol", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+	require.ErrorContains(t, err, "no policy to allow arthur.aardvark@example.com")
+
+	// The deny list applies to group members too
+	err = policyEnforcer.CheckPolicy("bob", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{Users: []string{"bob"}}, nil)
+	require.ErrorContains(t, err, "denied user bob")
+}
+
+func TestPolicyPrincipalPattern(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var matches []policy.Match
+	p, _ := policy.FromTable([]byte(
+		"dev-* arthur.aardvark@example.com https://accounts.example.com\n"+
+			"'re:ci[0-9]+' arthur.aardvark@example.com https://accounts.example.com\n"), "auth_id")
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: p},
+		OnAllow:      func(m policy.Match) { matches = append(matches, m) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("dev-web", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.NoError(t, policyEnforcer.CheckPolicy("ci42", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Equal(t, []policy.Match{
+		{Entry: "dev-* arthur.aardvark@example.com https://accounts.example.com", Source: "<mock data>"},
+		{Entry: "re:ci[0-9]+ arthur.aardvark@example.com https://accounts.example.com", Source: "<mock data>"},
+	}, matches)
+
+	// Regular expressions match the whole principal
+	for _, principal := range []string{"root", "dev", "ci42-root", "xci42"} {
+		err = policyEnforcer.CheckPolicy(principal, pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+		require.ErrorContains(t, err, "no policy to allow arthur.aardvark@example.com", principal)
+	}
+
+	// The deny list applies to the principals matched
+	err = policyEnforcer.CheckPolicy("dev-web", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{Users: []string{"dev-web"}}, nil)
+	require.ErrorContains(t, err, "denied user dev-web")
+}
+
+func TestPolicyExpiredEntry(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	expires := time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)
+	now := expires.Add(-time.Hour)
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{
+			Users: []policy.User{
+				{
+					IdentityAttribute: "arthur.aardvark@example.com",
+					Principals:        []string{"test"},
+					Issuer:            "https://accounts.example.com",
+					Expires:           expires,
+				},
+			},
+		}},
+		Now: func() time.Time { return now },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+
+	now = expires
+	err = policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+	require.ErrorContains(t, err, "no policy to allow arthur.aardvark@example.com")
+}
+
+func TestPolicyTrace(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var trace []string
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{
+			Users: []policy.User{
+				{IdentityAttribute: "arthur.aardvark@example.com", Principals: []string{"test"}, Issuer: "https://other.example.com"},
+				{IdentityAttribute: "arthur.aardvark@example.com", Principals: []string{"root"}, Issuer: "https://accounts.example.com"},
+				{IdentityAttribute: "bob@example.com", Principals: []string{"test"}, Issuer: "https://accounts.example.com"},
+				{IdentityAttribute: "arthur.aardvark@example.com", Principals: []string{"test", "dev"}, Issuer: "https://accounts.example.com"},
+			},
+		}},
+		Trace: func(format string, args ...any) { trace = append(trace, fmt.Sprintf(format, args...)) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Subset(t, trace, []string{
+		"Not in the deny lists (0 emails, 0 users, 0 revoked identities)",
+		"Checking 4 policy entries from <mock data>",
+		"  test arthur.aardvark@example.com https://other.example.com: skipped, the issuer of the ID Token is https://accounts.example.com",
+		"  root arthur.aardvark@example.com https://accounts.example.com: skipped, does not grant test",
+		"  test bob@example.com https://accounts.example.com: skipped, bob@example.com does not match the claims of the ID Token",
+		"  test,dev arthur.aardvark@example.com https://accounts.example.com: matches the ID Token",
+	})
+}
+
+// ldapMemberConn is an LDAP connection where every search finds a member
+type ldapMemberConn struct{}
+
+func (ldapMemberConn) Bind(username, password string) error { return nil }
+func (ldapMemberConn) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	return &goldap.SearchResult{Entries: []*goldap.Entry{{DN: "cn=arthur"}}}, nil
+}
+func (ldapMemberConn) Close() error { return nil }
+
+func TestPolicyLDAPGroup(t *testing.T) {
+	t.Parallel()
+
+	op, _, idTokenTemplate, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+	require.NoError(t, err)
+	idTokenTemplate.ExtraClaims = map[string]any{"email": "arthur.aardvark@example.com", "email_verified": true}
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	config, err := ldap.ParseConfig([]byte("url: ldap://dc.example.com\nbase_dn: dc=example,dc=com\ngroups:\n  - principals: [admin]\n    group_dn: cn=ssh-admins,dc=example,dc=com\n    issuer: https://accounts.example.com\n"))
+	require.NoError(t, err)
+	var matches []policy.Match
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: policyTest},
+		LDAP: &ldap.Policy{Config: config, Source: "/etc/opk/ldap.yml", Dial: func(*ldap.Config) (ldap.Conn, error) {
+			return ldapMemberConn{}, nil
+		}},
+		OnAllow: func(m policy.Match) { matches = append(matches, m) },
+	}
+
+	require.NoError(t, policyEnforcer.CheckPolicy("admin", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil))
+	require.Equal(t, []policy.Match{{Entry: "admin ldap:cn=ssh-admins,dc=example,dc=com https://accounts.example.com", Source: "/etc/opk/ldap.yml"}}, matches)
+
+	err = policyEnforcer.CheckPolicy("guest", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+	require.ErrorContains(t, err, "no policy to allow")
 }
 
 func TestPolicyEmailDifferentCase(t *testing.T) {
@@ -326,6 +582,36 @@ func TestPolicyDeniedWrongIssuer(t *testing.T) {
 	require.Error(t, err, "user should not have access due to wrong issuer")
 }
 
+func TestPolicyDeniedRevoked(t *testing.T) {
+	t.Parallel()
+
+	op := NewMockOpenIdProvider(t)
+
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: policyTest},
+	}
+
+	// Revocations for another issuer are ignored
+	denyList := policy.DenyList{Revoked: []policy.RevokedIdentity{
+		{Issuer: "https://other.example.com", Email: "arthur.aardvark@example.com"},
+	}}
+	err = policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", denyList, nil)
+	require.NoError(t, err)
+
+	denyList.Revoked = append(denyList.Revoked, policy.RevokedIdentity{
+		Issuer: "https://accounts.example.com",
+		Email:  "Arthur.Aardvark@example.com",
+		Reason: "user.lifecycle.suspend",
+	})
+	err = policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", denyList, nil)
+	require.ErrorContains(t, err, "was revoked by issuer https://accounts.example.com: user.lifecycle.suspend")
+}
+
 func TestPolicyApprovedOidcGroups(t *testing.T) {
 	t.Parallel()
 
@@ -426,6 +712,133 @@ func TestPolicyDeniedMissingOidcGroupsClaim(t *testing.T) {
 	require.Error(t, err, "user should not as the token is missing the groups claim")
 }
 
+func TestPolicyEmailDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		claims            map[string]any
+		identityAttribute string
+		allowed           bool
+	}{
+		{name: "verified", claims: map[string]any{"email": "Arthur@Example.com", "email_verified": true}, identityAttribute: "domain:example.com", allowed: true},
+		{name: "verified as a string", claims: map[string]any{"email": "arthur@example.com", "email_verified": "true"}, identityAttribute: "domain:example.com", allowed: true},
+		{name: "not verified", claims: map[string]any{"email": "arthur@example.com", "email_verified": false}, identityAttribute: "domain:example.com"},
+		{name: "no email_verified claim", claims: map[string]any{"email": "arthur@example.com"}, identityAttribute: "domain:example.com"},
+		{name: "subdomain", claims: map[string]any{"email": "arthur@eu.example.com", "email_verified": true}, identityAttribute: "domain:example.com"},
+		{name: "other domain ending with the domain", claims: map[string]any{"email": "arthur@notexample.com", "email_verified": true}, identityAttribute: "domain:example.com"},
+		{name: "empty domain", claims: map[string]any{"email": "arthur@", "email_verified": true}, identityAttribute: "domain:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			op, _, idTokenTemplate, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+			require.NoError(t, err)
+			idTokenTemplate.ExtraClaims = tt.claims
+			opkClient, err := client.New(op)
+			require.NoError(t, err)
+			pkt, err := opkClient.Auth(context.Background())
+			require.NoError(t, err)
+
+			var traces []string
+			policyEnforcer := &policy.Enforcer{
+				PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{Users: []policy.User{
+					{IdentityAttribute: tt.identityAttribute, Principals: []string{"deploy"}, Issuer: "https://accounts.example.com"},
+				}}},
+				Trace: func(format string, args ...any) { traces = append(traces, fmt.Sprintf(format, args...)) },
+			}
+			err = policyEnforcer.CheckPolicy("deploy", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+			if tt.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, "no policy to allow")
+			}
+			if tt.name == "not verified" {
+				require.Contains(t, traces, "  deploy domain:example.com https://accounts.example.com: skipped, the provider does not assert email_verified for arthur@example.com")
+			}
+		})
+	}
+}
+
+func TestPolicyIssuerAlias(t *testing.T) {
+	t.Parallel()
+
+	op, _, idTokenTemplate, err := providers.NewMockProvider(providers.DefaultMockProviderOpts())
+	require.NoError(t, err)
+	idTokenTemplate.ExtraClaims = map[string]any{"email": "arthur@example.com"}
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	var traces []string
+	policyEnforcer := &policy.Enforcer{
+		PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{Users: []policy.User{
+			{IdentityAttribute: "arthur@example.com", Principals: []string{"deploy"}, Issuer: "https://old.example.com"},
+		}}},
+		Trace: func(format string, args ...any) { traces = append(traces, fmt.Sprintf(format, args...)) },
+	}
+	err = policyEnforcer.CheckPolicy("deploy", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+	require.ErrorContains(t, err, "no policy to allow")
+
+	// The entries of the old issuer match once it is an alias
+	policyEnforcer.IssuerAliases = policy.IssuerAliases{"https://old.example.com": "https://accounts.example.com"}
+	traces = nil
+	err = policyEnforcer.CheckPolicy("deploy", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+	require.NoError(t, err)
+	require.Contains(t, traces, "  deploy arthur@example.com https://old.example.com: the issuer https://old.example.com of the entry and https://accounts.example.com of the ID Token are aliases")
+}
+
+func TestPolicyKeycloakRoles(t *testing.T) {
+	t.Parallel()
+
+	providerOpts := providers.DefaultMockProviderOpts()
+	op, _, idTokenTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idTokenTemplate.ExtraClaims = map[string]any{
+		"email":        "arthur.aardvark@example.com",
+		"realm_access": map[string]any{"roles": []string{"offline_access", "ssh-admin"}},
+		"resource_access": map[string]any{
+			"opkssh":  map[string]any{"roles": []string{"deploy"}},
+			"account": map[string]any{"roles": []string{"manage-account"}},
+		},
+	}
+	opkClient, err := client.New(op)
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		identityAttribute string
+		wantError         bool
+	}{
+		{name: "realm role by claim path", identityAttribute: "oidc:realm_access.roles:ssh-admin"},
+		{name: "realm role shorthand", identityAttribute: "oidc:realm_roles:ssh-admin"},
+		{name: "client role by claim path", identityAttribute: "oidc:resource_access.opkssh.roles:deploy"},
+		{name: "client role shorthand", identityAttribute: "oidc:client_roles:opkssh/deploy"},
+		{name: "missing realm role", identityAttribute: "oidc:realm_roles:deploy", wantError: true},
+		{name: "role of another client", identityAttribute: "oidc:client_roles:account/deploy", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policyEnforcer := &policy.Enforcer{
+				PolicyLoader: &MockPolicyLoader{Policy: &policy.Policy{Users: []policy.User{{
+					IdentityAttribute: tt.identityAttribute,
+					Principals:        []string{"test"},
+					Issuer:            "https://accounts.example.com",
+				}}}},
+			}
+			err := policyEnforcer.CheckPolicy("test", pkt, "", "example-base64Cert", "ssh-rsa", policy.DenyList{}, nil)
+			if tt.wantError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
 func TestEnforcerTableTest(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,120 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// ExpiresOption is the option of a policy entry that sets when it stops
+// allowing logins, e.g. expires=2025-12-31
+const ExpiresOption = "expires"
+
+const expiryDateLayout = "2006-01-02"
+
+// ParseExpiry parses the expiry of a policy entry, either a date, which
+// allows logins until the end of that day in UTC, or an RFC 3339 time
+func ParseExpiry(value string) (time.Time, error) {
+	if date, err := time.Parse(expiryDateLayout, value); err == nil {
+		return date.AddDate(0, 0, 1), nil
+	}
+	expires, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q, expected a date such as 2025-12-31 or an RFC 3339 time such as 2025-12-31T18:00:00Z", value)
+	}
+	return expires, nil
+}
+
+// FormatExpiry formats expires as ParseExpiry reads it, as a date if it is
+// the end of a day in UTC
+func FormatExpiry(expires time.Time) string {
+	if expires.Location() == time.UTC && expires.Equal(expires.Truncate(24*time.Hour)) {
+		return expires.AddDate(0, 0, -1).Format(expiryDateLayout)
+	}
+	return expires.Format(time.RFC3339)
+}
+
+// Expired returns true if the entry has an expiry that has passed at now
+func (u User) Expired(now time.Time) bool {
+	return !u.Expires.IsZero() && !now.Before(u.Expires)
+}
+
+// options returns the option columns of the entry
+func (u User) options() []string {
+	var options []string
+	if !u.Expires.IsZero() {
+		options = append(options, ExpiresOption+"="+FormatExpiry(u.Expires))
+	}
+	if u.CatchAll {
+		options = append(options, CatchAllOption+"=true")
+	}
+	for _, option := range u.KeyOptions {
+		name, value, _ := files.ParseKeyOption(option)
+		if strings.Contains(option, "=") {
+			name += "=" + value
+		}
+		options = append(options, name)
+	}
+	return options
+}
+
+// parseOptions sets the options in the columns after the issuer of row
+func (u *User) parseOptions(row files.Row) *files.ParseError {
+	for i := 3; i < len(row.Columns); i++ {
+		column := row.Columns[i]
+		name, value, ok := strings.Cut(column, "=")
+		pe := &files.ParseError{Line: row.Line, Column: row.Offsets[i], Token: column}
+		switch {
+		case files.IsKeyOption(name):
+			if err := files.CheckKeyOption(name, value, ok); err != nil {
+				pe.Message = err.Error()
+				pe.Suggestion = `write the option as in authorized_keys, such as no-pty or from="10.0.0.0/8"`
+				return pe
+			}
+			u.KeyOptions = append(u.KeyOptions, files.FormatKeyOption(strings.ToLower(name), value, ok))
+		case !ok:
+			pe.Message = "wrong number of arguments (expected=3, got=" + fmt.Sprint(len(row.Columns)) + ")"
+			pe.Suggestion = "expected principal identity issuer followed by options such as expires=2025-12-31, quote values that contain spaces"
+			return pe
+		case name == ExpiresOption:
+			expires, err := ParseExpiry(value)
+			if err != nil {
+				pe.Message = err.Error()
+				pe.Suggestion = "write the expiry as expires=2025-12-31"
+				return pe
+			}
+			u.Expires = expires
+		case name == CatchAllOption:
+			if value != "true" {
+				pe.Message = fmt.Sprintf("invalid %s value %q", CatchAllOption, value)
+				pe.Suggestion = "write the option as " + CatchAllOption + "=true"
+				return pe
+			}
+			u.CatchAll = true
+		default:
+			// Unknown options could be restrictions, so the entry is skipped
+			pe.Message = fmt.Sprintf("unknown option %s", name)
+			pe.Suggestion = "the supported options are " + ExpiresOption + ", " + CatchAllOption + " and authorized_keys options such as no-pty"
+			return pe
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/verifier"
+)
+
+// ExpiresAt returns the time at which a PK Token issued at iat with the ID
+// Token expiration exp stops being accepted under the expiration policy and
+// the max session of this row. Returns false if the PK Token never expires
+// or the expiration policy is invalid.
+func (p ProvidersRow) ExpiresAt(iat time.Time, exp time.Time) (time.Time, bool) {
+	expiresAt, ok := p.policyExpiresAt(iat, exp)
+	if _, err := p.GetExpirationPolicy(); err != nil || p.MaxSession <= 0 {
+		return expiresAt, ok
+	}
+	if sessionEnd := iat.Add(p.MaxSession); !ok || sessionEnd.Before(expiresAt) {
+		return sessionEnd, true
+	}
+	return expiresAt, true
+}
+
+// policyExpiresAt returns when a PK Token stops being accepted under the
+// expiration policy alone
+func (p ProvidersRow) policyExpiresAt(iat time.Time, exp time.Time) (time.Time, bool) {
+	switch p.ExpirationPolicy {
+	case "12h":
+		return iat.Add(12 * time.Hour), true
+	case "24h":
+		return iat.Add(24 * time.Hour), true
+	case "48h":
+		return iat.Add(48 * time.Hour), true
+	case "1week":
+		return iat.Add(7 * 24 * time.Hour), true
+	case "oidc", "oidc_refreshed":
+		// For oidc_refreshed the refreshed ID Token extends this, but we can
+		// only report on the ID Token we have
+		return exp, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// ExpiresAt returns when a PK Token from issuer for the comma separated
+// audience, issued at iat with the ID Token expiration exp, stops being
+// accepted. It uses the row of the issuer with a client ID in audience, or
+// the first row of the issuer if none has. Returns false if the issuer is
+// not configured or the PK Token never expires.
+func (p *ProviderPolicy) ExpiresAt(issuer string, audience string, iat time.Time, exp time.Time) (time.Time, bool) {
+	auds := strings.Split(audience, ",")
+	var match *ProvidersRow
+	for i, row := range p.rows {
+		if row.Issuer != issuer {
+			continue
+		}
+		for _, clientID := range row.GetClientIDs() {
+			if slices.Contains(auds, clientID) {
+				return row.ExpiresAt(iat, exp)
+			}
+		}
+		if match == nil {
+			match = &p.rows[i]
+		}
+	}
+	if match == nil {
+		return time.Time{}, false
+	}
+	return match.ExpiresAt(iat, exp)
+}
+
+// maxSessionVerifier rejects ID Tokens issued longer than maxSession ago,
+// after they are verified by the provider. Unlike the expiration policy, a
+// refreshed ID Token doesn't extend this.
+type maxSessionVerifier struct {
+	verifier.ProviderVerifier
+	maxSession time.Duration
+	// now defaults to time.Now
+	now func() time.Time
+}
+
+func (m maxSessionVerifier) VerifyIDToken(ctx context.Context, idt []byte, cic *clientinstance.Claims) error {
+	if err := m.ProviderVerifier.VerifyIDToken(ctx, idt, cic); err != nil {
+		return err
+	}
+	jwt, err := oidc.NewJwt(idt)
+	if err != nil {
+		return err
+	}
+	claims := jwt.GetClaims()
+	if claims.IssuedAt <= 0 {
+		return fmt.Errorf("the ID Token has no iat claim, needed to enforce max_session")
+	}
+	now := time.Now
+	if m.now != nil {
+		now = m.now
+	}
+	if sessionEnd := time.Unix(claims.IssuedAt, 0).Add(m.maxSession); now().After(sessionEnd) {
+		return fmt.Errorf("the PK Token has expired, sessions from %s last at most %s (expired at %s)", claims.Issuer, m.maxSession, sessionEnd.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxSessionVerifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := maxSessionVerifier{
+		ProviderVerifier: testProviderVerifier{issuer: "https://example.com"},
+		maxSession:       8 * time.Hour,
+		now:              func() time.Time { return now },
+	}
+	idt := func(iat time.Time) []byte {
+		return testIDToken(fmt.Sprintf(`{"iss":"https://example.com","iat":%d,"exp":%d}`, iat.Unix(), iat.Add(time.Hour).Unix()))
+	}
+	require.NoError(t, m.VerifyIDToken(context.Background(), idt(now.Add(-7*time.Hour)), nil))
+
+	// The ID Token may still be valid, or refreshed, but the session is over
+	err := m.VerifyIDToken(context.Background(), idt(now.Add(-9*time.Hour)), nil)
+	require.ErrorContains(t, err, "the PK Token has expired, sessions from https://example.com last at most 8h0m0s (expired at 2023-11-14T21:13:20Z)")
+
+	require.ErrorContains(t, m.VerifyIDToken(context.Background(), testIDToken(`{"iss":"https://example.com"}`), nil), "no iat claim")
+
+	// The provider is checked first
+	m.ProviderVerifier = testProviderVerifier{issuer: "https://example.com", err: fmt.Errorf("invalid signature")}
+	require.ErrorContains(t, m.VerifyIDToken(context.Background(), idt(now), nil), "invalid signature")
+}
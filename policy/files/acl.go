@@ -1,7 +1,9 @@
 package files
 
 import (
+	"fmt"
 	"io/fs"
+	"strings"
 )
 
 // ACE represents an access control entry (platform-agnostic minimal view)
@@ -26,6 +28,9 @@ type ExpectedACL struct {
 	// RequiredACEs lists ACE expectations that must be present.
 	// Used on Windows to verify that opksshuser has been granted read access.
 	RequiredACEs []ExpectedACE
+	// ForbiddenPrincipals lists the principals no ACE may grant access to,
+	// by name or SID, e.g. Everyone or S-1-1-0
+	ForbiddenPrincipals []string
 }
 
 // ExpectedACE describes a single required ACE.
@@ -40,6 +45,8 @@ type ACLReport struct {
 	Path   string
 	Exists bool
 	Owner  string
+	// Group is the group of the path on Unix
+	Group string
 	// OwnerSID contains the raw owner SID bytes on Windows when available.
 	// On non-Windows platforms this will be nil.
 	OwnerSID []byte
@@ -47,7 +54,10 @@ type ACLReport struct {
 	OwnerSIDStr string
 	Mode        fs.FileMode
 	ACEs        []ACE
-	Problems    []string
+	// Unexpected are the ACEs that grant access the expected ACL doesn't,
+	// such as the extended POSIX ACL entries on Unix
+	Unexpected []ACE
+	Problems   []string
 }
 
 // ACLVerifier verifies ACLs and ownership for a given path against expectations.
@@ -55,3 +65,241 @@ type ACLReport struct {
 type ACLVerifier interface {
 	VerifyACL(path string, expected ExpectedACL) (ACLReport, error)
 }
+
+// Well-known SIDs of the principals every desired DACL grants full control.
+// Their names are localized, the SIDs are not.
+const (
+	SIDAdministrators = "S-1-5-32-544"
+	SIDSystem         = "S-1-5-18"
+)
+
+// Well-known SIDs of the principals that stand for every user, which the
+// files no other user may read must not grant access to
+const (
+	SIDEveryone           = "S-1-1-0"
+	SIDUsers              = "S-1-5-32-545"
+	SIDAuthenticatedUsers = "S-1-5-11"
+)
+
+// fileGenericMasks maps generic rights to the file rights Windows stores in
+// the ACE in their place
+var fileGenericMasks = map[string]uint32{
+	"GENERIC_ALL":     0x001F01FF,
+	"GENERIC_READ":    0x00120089,
+	"GENERIC_WRITE":   0x00120116,
+	"GENERIC_EXECUTE": 0x001200A0,
+}
+
+var fileRightMasks = map[string]uint32{
+	"FILE_READ_DATA":        0x00000001,
+	"FILE_WRITE_DATA":       0x00000002,
+	"FILE_APPEND_DATA":      0x00000004,
+	"FILE_READ_EA":          0x00000008,
+	"FILE_WRITE_EA":         0x00000010,
+	"FILE_EXECUTE":          0x00000020,
+	"FILE_DELETE_CHILD":     0x00000040,
+	"FILE_READ_ATTRIBUTES":  0x00000080,
+	"FILE_WRITE_ATTRIBUTES": 0x00000100,
+	"DELETE":                0x00010000,
+	"READ_CONTROL":          0x00020000,
+	"WRITE_DAC":             0x00040000,
+	"WRITE_OWNER":           0x00080000,
+	"SYNCHRONIZE":           0x00100000,
+}
+
+// fileAccessMask returns the file rights granted by a comma separated list
+// of rights as reported in ACE.Rights
+func fileAccessMask(rights string) uint32 {
+	var m uint32
+	for _, r := range strings.Split(rights, ",") {
+		r = strings.TrimSpace(r)
+		m |= fileGenericMasks[r] | fileRightMasks[r]
+	}
+	return m
+}
+
+// DesiredDACL returns the ACEs permissions fix gives a path with the
+// permissions pi on Windows: full control for Administrators, SYSTEM and the
+// owner, and read for the group. Nothing is inherited from the parent.
+func DesiredDACL(pi PermInfo) []ACE {
+	return Principals().DesiredDACL(pi)
+}
+
+// DesiredDACL returns the DesiredDACL of pi with the principals of s
+func (s SecurityPrincipals) DesiredDACL(pi PermInfo) []ACE {
+	aces := []ACE{
+		s.Administrators.ACE("GENERIC_ALL"),
+		s.System.ACE("GENERIC_ALL"),
+	}
+	if pi.Owner != "" {
+		if owner := s.Resolve(pi.Owner); owner != s.Administrators && owner != s.System {
+			aces = append(aces, owner.ACE("GENERIC_ALL"))
+		}
+	}
+	if pi.Group != "" {
+		aces = append(aces, s.Resolve(pi.Group).ACE("GENERIC_READ"))
+	}
+	return aces
+}
+
+// samePrincipal compares by SID when both are known, by name otherwise.
+// Names may be reported with a domain, e.g. BUILTIN\Administrators.
+func samePrincipal(a, b ACE) bool {
+	if a.PrincipalSIDStr != "" && b.PrincipalSIDStr != "" {
+		return a.PrincipalSIDStr == b.PrincipalSIDStr
+	}
+	name := func(ace ACE) string {
+		if i := strings.LastIndex(ace.Principal, `\`); i >= 0 {
+			return ace.Principal[i+1:]
+		}
+		return ace.Principal
+	}
+	return strings.EqualFold(name(a), name(b))
+}
+
+func sameACE(a, b ACE) bool {
+	return samePrincipal(a, b) && strings.EqualFold(a.Type, b.Type) && fileAccessMask(a.Rights) == fileAccessMask(b.Rights)
+}
+
+// describeRights names the common rights the way the Windows security
+// dialog does
+func describeRights(rights string) string {
+	switch fileAccessMask(rights) {
+	case fileGenericMasks["GENERIC_ALL"]:
+		return "full control"
+	case fileGenericMasks["GENERIC_READ"]:
+		return "read"
+	default:
+		return rights
+	}
+}
+
+// UnexpectedACEs returns the ACEs of report that grant access expected
+// doesn't: those the verifier found unexpected, such as the named entries
+// of a POSIX ACL, and the explicit allow ACEs of a DACL for anyone but the
+// administrators, the owner and the principals of the required ACEs.
+// Inherited ACEs are not included, they go with inheritance.
+func UnexpectedACEs(report ACLReport, expected ExpectedACL) []ACE {
+	principals := Principals()
+	unexpected := append([]ACE(nil), report.Unexpected...)
+	for _, ace := range report.ACEs {
+		if ace.Inherited || ace.Type != "allow" || isPosixACLPrincipal(ace.Principal) || containsACE(report.Unexpected, ace) {
+			continue
+		}
+		if principals.IsAdministrator(ace.Principal, ace.PrincipalSIDStr) {
+			continue
+		}
+		if expected.Owner != "" && principals.Resolve(expected.Owner).Matches(ace.Principal, ace.PrincipalSIDStr) {
+			continue
+		}
+		required := false
+		for _, req := range expected.RequiredACEs {
+			required = required || principals.Resolve(req.Principal).Matches(ace.Principal, ace.PrincipalSIDStr)
+		}
+		if !required {
+			unexpected = append(unexpected, ace)
+		}
+	}
+	return unexpected
+}
+
+func containsACE(aces []ACE, ace ACE) bool {
+	for _, a := range aces {
+		if sameACE(a, ace) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExpectedACEs adds a problem to r for each required ACE that none of
+// aces grants, and for each of aces that grants a forbidden principal
+// access. The forbidden ACEs of r.ACEs that aren't inherited are also
+// unexpected. match reports whether an ACE is of principal, grants whether
+// it grants the rights of req.
+func checkExpectedACEs(r *ACLReport, aces []ACE, expected ExpectedACL, match func(ace ACE, principal string) bool, grants func(ace ACE, req ExpectedACE) bool) {
+	for _, req := range expected.RequiredACEs {
+		found := false
+		for _, ace := range aces {
+			if ace.Type == "allow" && match(ace, req.Principal) && grants(ace, req) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.Problems = append(r.Problems, fmt.Sprintf("missing ACE: allow %s %s", req.Principal, describeRights(req.Rights)))
+		}
+	}
+	for _, principal := range expected.ForbiddenPrincipals {
+		for _, ace := range aces {
+			if ace.Type != "allow" || !match(ace, principal) {
+				continue
+			}
+			if ace.Inherited {
+				r.Problems = append(r.Problems, fmt.Sprintf("forbidden principal %s has access (%s), inherited from the parent directory", ace.Principal, describeRights(ace.Rights)))
+				continue
+			}
+			r.Problems = append(r.Problems, fmt.Sprintf("forbidden principal %s has access (%s)", ace.Principal, describeRights(ace.Rights)))
+			if containsACE(r.ACEs, ace) && !containsACE(r.Unexpected, ace) {
+				r.Unexpected = append(r.Unexpected, ace)
+			}
+		}
+	}
+}
+
+// checkDACL evaluates the required ACEs and forbidden principals of
+// expected against the DACL in r
+func checkDACL(r *ACLReport, expected ExpectedACL) {
+	principals := Principals()
+	match := func(ace ACE, principal string) bool {
+		return principals.Resolve(principal).Matches(ace.Principal, ace.PrincipalSIDStr)
+	}
+	grants := func(ace ACE, req ExpectedACE) bool {
+		want := fileAccessMask(req.Rights)
+		return fileAccessMask(ace.Rights)&want == want
+	}
+	checkExpectedACEs(r, r.ACEs, expected, match, grants)
+}
+
+// DACLChanges describes each change that makes the DACL in report exactly
+// desired, with inheritance disabled. It is empty when nothing has to change.
+func DACLChanges(report ACLReport, desired []ACE) []string {
+	var changes []string
+	inherited := false
+	var explicit []ACE
+	for _, ace := range report.ACEs {
+		if ace.Inherited {
+			inherited = true
+			continue
+		}
+		explicit = append(explicit, ace)
+	}
+	if inherited {
+		changes = append(changes, "disable inheritance")
+	}
+	for _, ace := range explicit {
+		wanted := false
+		for _, d := range desired {
+			if sameACE(ace, d) {
+				wanted = true
+				break
+			}
+		}
+		if !wanted {
+			changes = append(changes, fmt.Sprintf("remove %s %s %s", ace.Type, ace.Principal, describeRights(ace.Rights)))
+		}
+	}
+	for _, d := range desired {
+		present := false
+		for _, ace := range explicit {
+			if sameACE(ace, d) {
+				present = true
+				break
+			}
+		}
+		if !present {
+			changes = append(changes, fmt.Sprintf("grant %s %s", d.Principal, describeRights(d.Rights)))
+		}
+	}
+	return changes
+}
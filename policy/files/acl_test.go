@@ -0,0 +1,109 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDesiredDACL(t *testing.T) {
+	dacl := DesiredDACL(PermInfo{Mode: 0o640, Owner: "root", Group: "opksshuser"})
+	require.Equal(t, []ACE{
+		{Principal: "Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "SYSTEM", PrincipalSIDStr: SIDSystem, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "opksshuser", Rights: "GENERIC_READ", Type: "allow"},
+	}, dacl)
+
+	// A user's home policy also grants its owner
+	dacl = DesiredDACL(PermInfo{Mode: 0o600, Owner: "alice"})
+	require.Len(t, dacl, 3)
+	require.Equal(t, ACE{Principal: "alice", Rights: "GENERIC_ALL", Type: "allow"}, dacl[2])
+}
+
+func TestDACLChanges(t *testing.T) {
+	desired := DesiredDACL(PermInfo{Mode: 0o640, Owner: "root", Group: "opksshuser"})
+
+	// Matched by SID, by name with a domain and by the specific rights
+	// Windows reports for a generic right
+	report := ACLReport{Exists: true, ACEs: []ACE{
+		{Principal: "BUILTIN\\Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "FILE_READ_DATA,FILE_WRITE_DATA,FILE_APPEND_DATA,FILE_READ_EA,FILE_WRITE_EA,FILE_EXECUTE,FILE_DELETE_CHILD,FILE_READ_ATTRIBUTES,FILE_WRITE_ATTRIBUTES,DELETE,READ_CONTROL,WRITE_DAC,WRITE_OWNER,SYNCHRONIZE", Type: "allow"},
+		{Principal: "NT AUTHORITY\\SYSTEM", Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "HOST\\opksshuser", Rights: "FILE_READ_DATA,FILE_READ_EA,FILE_READ_ATTRIBUTES,READ_CONTROL,SYNCHRONIZE", Type: "allow"},
+	}}
+	require.Empty(t, DACLChanges(report, desired))
+
+	report = ACLReport{Exists: true, ACEs: []ACE{
+		{Principal: "Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "opksshuser", Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "Everyone", Rights: "GENERIC_READ", Type: "allow", Inherited: true},
+	}}
+	require.Equal(t, []string{
+		"disable inheritance",
+		"remove allow opksshuser full control",
+		"grant SYSTEM full control",
+		"grant opksshuser read",
+	}, DACLChanges(report, desired))
+}
+
+func TestUnexpectedACEs(t *testing.T) {
+	expected := ExpectedACL{Owner: "root", RequiredACEs: []ExpectedACE{{Principal: "opksshuser", Rights: "GENERIC_READ", Type: "allow"}}}
+	report := ACLReport{Exists: true, ACEs: []ACE{
+		{Principal: "BUILTIN\\Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "NT AUTHORITY\\SYSTEM", Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "HOST\\opksshuser", Rights: "GENERIC_READ", Type: "allow"},
+		{Principal: "HOST\\alice", Rights: "GENERIC_READ", Type: "allow"},
+		{Principal: "Guests", Rights: "GENERIC_ALL", Type: "deny"},
+		{Principal: "Everyone", Rights: "GENERIC_READ", Type: "allow", Inherited: true},
+	}}
+	require.Equal(t, []ACE{{Principal: "HOST\\alice", Rights: "GENERIC_READ", Type: "allow"}}, UnexpectedACEs(report, expected))
+
+	// The entries a POSIX ACL verifier found are kept as they are
+	mallory := ACE{Principal: "user:mallory", Rights: "r--", Type: "allow"}
+	report = ACLReport{Exists: true, ACEs: []ACE{mallory}, Unexpected: []ACE{mallory}}
+	require.Equal(t, []ACE{mallory}, UnexpectedACEs(report, ExpectedACL{Owner: "root"}))
+}
+
+func TestCheckDACL(t *testing.T) {
+	everyone := ACE{Principal: "Everyone", PrincipalSIDStr: SIDEveryone, Rights: "GENERIC_READ", Type: "allow"}
+	r := ACLReport{Exists: true, ACEs: []ACE{
+		{Principal: "BUILTIN\\Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "HOST\\opksshuser", Rights: "FILE_READ_DATA,FILE_READ_EA,FILE_READ_ATTRIBUTES,READ_CONTROL,SYNCHRONIZE", Type: "allow"},
+		everyone,
+		{Principal: "BUILTIN\\Users", PrincipalSIDStr: SIDUsers, Rights: "GENERIC_READ", Type: "allow", Inherited: true},
+		{Principal: "Guests", PrincipalSIDStr: "S-1-5-32-546", Rights: "GENERIC_ALL", Type: "deny"},
+	}}
+	expected := ExpectedACL{
+		Owner: "root",
+		RequiredACEs: []ExpectedACE{
+			{Principal: "root", Rights: "GENERIC_ALL", Type: "allow"},
+			{Principal: "opksshuser", Rights: "GENERIC_READ", Type: "allow"},
+			{Principal: "SYSTEM", Rights: "GENERIC_ALL", Type: "allow"},
+		},
+		ForbiddenPrincipals: []string{SIDEveryone, "Users", "S-1-5-32-546"},
+	}
+	checkDACL(&r, expected)
+	require.Equal(t, []string{
+		"missing ACE: allow SYSTEM full control",
+		"forbidden principal Everyone has access (read)",
+		"forbidden principal BUILTIN\\Users has access (read), inherited from the parent directory",
+	}, r.Problems)
+	// Only the ACE of the file itself can be removed
+	require.Equal(t, []ACE{everyone}, r.Unexpected)
+	require.Equal(t, []ACE{everyone}, UnexpectedACEs(r, expected))
+}
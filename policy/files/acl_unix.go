@@ -54,6 +54,7 @@ func (u *UnixACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLRepor
 			groupName = gobj.Name
 		}
 		r.Owner = ownerName
+		r.Group = groupName
 		if expected.Owner != "" {
 			if ownerName == "" {
 				r.Problems = append(r.Problems, fmt.Sprintf("could not determine owner for %s (uid=%s)", path, uid))
@@ -61,7 +62,6 @@ func (u *UnixACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLRepor
 				r.Problems = append(r.Problems, fmt.Sprintf("expected owner (%s), got (%s)", expected.Owner, ownerName))
 			}
 		}
-		_ = groupName // currently not used in report
 	} else {
 		// Sys() not available (e.g., in-memory FS); only check owner if not specified
 		if expected.Owner != "" {
@@ -69,5 +69,51 @@ func (u *UnixACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLRepor
 			r.Problems = append(r.Problems, fmt.Sprintf("owner check requested but Sys() unavailable for %s", path))
 		}
 	}
+
+	// Extended POSIX ACLs (setfacl) grant access the mode bits don't show
+	if _, ok := u.Fs.(*afero.OsFs); ok {
+		u.checkPosixACL(path, &r)
+	}
+	checkPosixExpected(&r, expected)
 	return r, nil
 }
+
+// checkPosixACL reports each named user and group of the POSIX ACL of path
+func (u *UnixACLVerifier) checkPosixACL(path string, r *ACLReport) {
+	data, err := posixACL(path)
+	if err != nil {
+		r.Problems = append(r.Problems, err.Error())
+		return
+	}
+	if data == nil {
+		return
+	}
+	entries, err := parsePosixACL(data)
+	if err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("%s: %v", path, err))
+		return
+	}
+	for _, ace := range extendedACEs(entries, lookupUserName, lookupGroupName) {
+		r.ACEs = append(r.ACEs, ace)
+		r.Unexpected = append(r.Unexpected, ace)
+		r.Problems = append(r.Problems, fmt.Sprintf("extended ACL grants %s %s", ace.Principal, ace.Rights))
+	}
+}
+
+// lookupUserName returns the name of the user with the uid id, "" if it
+// is unknown
+func lookupUserName(id string) string {
+	if uobj, err := user.LookupId(id); err == nil {
+		return uobj.Username
+	}
+	return ""
+}
+
+// lookupGroupName returns the name of the group with the gid id, "" if it
+// is unknown
+func lookupGroupName(id string) string {
+	if gobj, err := user.LookupGroupId(id); err == nil {
+		return gobj.Name
+	}
+	return ""
+}
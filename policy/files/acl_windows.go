@@ -115,6 +115,14 @@ func utf16PtrFromStringNullable(s string) (*uint16, error) {
 	return syscall.UTF16PtrFromString(s)
 }
 
+// describePrincipal names a principal with its SID, when it is known
+func describePrincipal(name string, sid string) string {
+	if sid == "" || sid == name {
+		return name
+	}
+	return name + " [" + sid + "]"
+}
+
 func (w *WindowsACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLReport, error) {
 	r := ACLReport{Path: path}
 	if w.Fs == nil {
@@ -198,13 +206,18 @@ func (w *WindowsACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLRe
 				r.Problems = append(r.Problems, fmt.Sprintf("LookupAccountSidW failed: %v", err))
 			} else {
 				r.Owner = syscall.UTF16ToString(name)
-				if expected.Owner != "" && r.Owner != expected.Owner {
-					r.Problems = append(r.Problems, fmt.Sprintf("expected owner (%s), got (%s)", expected.Owner, r.Owner))
-				}
 			}
 		} else {
 			r.Problems = append(r.Problems, "LookupAccountSidW: could not determine required name buffer size")
 		}
+		// An account that no longer exists only has a SID
+		if r.Owner == "" {
+			r.Owner = r.OwnerSIDStr
+		}
+		if expected.Owner != "" && !Principals().IsOwner(expected.Owner, r.Owner, r.OwnerSIDStr) {
+			want := Principals().Resolve(expected.Owner)
+			r.Problems = append(r.Problems, fmt.Sprintf("expected owner (%s), got (%s)", describePrincipal(want.Name, want.SID), describePrincipal(r.Owner, r.OwnerSIDStr)))
+		}
 	} else {
 		r.Problems = append(r.Problems, "owner SID not available")
 	}
@@ -309,11 +322,15 @@ func (w *WindowsACLVerifier) VerifyACL(path string, expected ExpectedACL) (ACLRe
 				if len(sidBytes) > 0 {
 					if s, err := ConvertSidToString(sidBytes); err == nil {
 						ace.PrincipalSIDStr = s
+						if principal == "<unknown>" {
+							ace.Principal = s
+						}
 					}
 				}
 				r.ACEs = append(r.ACEs, ace)
 			}
 		}
+		checkDACL(&r, expected)
 	}
 
 	return r, nil
@@ -23,9 +23,14 @@ func TestMaskToRights_Common(t *testing.T) {
 	}
 }
 
-// Test resolving a well-known account and converting to textual SID.
+// Test resolving a well-known account by its localized name and
+// converting to textual SID.
 func TestResolveAndConvertAdministratorsSID(t *testing.T) {
-	sid, _, err := ResolveAccountToSID("Administrators")
+	name, err := LookupSIDName(SIDAdministrators)
+	if err != nil {
+		t.Fatalf("LookupSIDName failed: %v", err)
+	}
+	sid, _, err := ResolveAccountToSID(name)
 	if err != nil {
 		t.Fatalf("ResolveAccountToSID failed: %v", err)
 	}
@@ -36,7 +41,10 @@ func TestResolveAndConvertAdministratorsSID(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ConvertSidToString failed: %v", err)
 	}
-	if len(s) < 2 || s[:2] != "S-" {
+	if s != SIDAdministrators {
 		t.Fatalf("unexpected SID string: %q", s)
 	}
+	if Principals().Administrators.Name != name {
+		t.Fatalf("expected the Administrators principal to be named %q, got %q", name, Principals().Administrators.Name)
+	}
 }
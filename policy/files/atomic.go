@@ -0,0 +1,68 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// WriteFileAtomic replaces the file at path with content. The content is
+// written to a temporary file in the same directory, which is renamed over
+// path, so readers see either the old or the new file and never a partial
+// one. The new file has perm and, if path exists, the owner and group (on
+// Windows the owner and DACL) of the file it replaces.
+func WriteFileAtomic(fsys afero.Fs, path string, content []byte, perm fs.FileMode) error {
+	tmp, err := afero.TempFile(fsys, filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			_ = fsys.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	// Make sure the content is on disk before it replaces the old file
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+	if err := fsys.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", tmpPath, err)
+	}
+	if err := copyOwner(fsys, path, tmpPath); err != nil {
+		return fmt.Errorf("failed to keep the ownership of %s: %w", path, err)
+	}
+	if err := fsys.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	committed = true
+	return nil
+}
@@ -0,0 +1,85 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"unsafe"
+
+	"github.com/spf13/afero"
+)
+
+// copyOwner sets the owner and DACL of to to those of from, so that a file
+// replaced by WriteFileAtomic keeps its ACL instead of inheriting the one of
+// the directory. It does nothing if from does not exist or fsys is not the
+// OS file system.
+func copyOwner(fsys afero.Fs, from string, to string) error {
+	if _, ok := fsys.(*afero.OsFs); !ok {
+		return nil
+	}
+	if _, err := fsys.Stat(from); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	pFrom, err := syscall.UTF16PtrFromString(from)
+	if err != nil {
+		return err
+	}
+	var pOwner, pDacl, pSD uintptr
+	ret, _, _ := procGetNamedSecInfo.Call(
+		uintptr(unsafe.Pointer(pFrom)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(OWNER_SECURITY_INFORMATION|DACL_SECURITY_INFORMATION),
+		uintptr(unsafe.Pointer(&pOwner)),
+		0,
+		uintptr(unsafe.Pointer(&pDacl)),
+		0,
+		uintptr(unsafe.Pointer(&pSD)),
+	)
+	if ret != 0 {
+		return fmt.Errorf("GetNamedSecurityInfoW failed: %d", ret)
+	}
+	if pSD != 0 {
+		defer procLocalFree.Call(pSD)
+	}
+
+	pTo, err := syscall.UTF16PtrFromString(to)
+	if err != nil {
+		return err
+	}
+	ret, _, err = procSetNamedSecurityInfo.Call(
+		uintptr(unsafe.Pointer(pTo)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(OWNER_SECURITY_INFORMATION|DACL_SECURITY_INFORMATION|PROTECTED_DACL_SECURITY_INFORMATION),
+		pOwner,
+		0,
+		pDacl,
+		0,
+	)
+	if ret != 0 {
+		return fmt.Errorf("SetNamedSecurityInfoW failed: %v (ret=%d)", err, ret)
+	}
+	return nil
+}
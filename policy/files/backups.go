@@ -0,0 +1,116 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultBackupKeep is how many backups of each file are kept by default
+const DefaultBackupKeep = 10
+
+// backupTimeFormat sorts backups by name in the order they were made
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backups keeps timestamped copies of files in Dir before they are replaced,
+// e.g. /var/lib/opk/backups/auth_id.20260102T030405.000000000Z. Only the
+// Keep most recent backups of each file are kept.
+type Backups struct {
+	Fs   afero.Fs
+	Dir  string
+	Keep int
+	Now  func() time.Time
+}
+
+// NewBackups returns Backups keeping DefaultBackupKeep backups in dir
+func NewBackups(fsys afero.Fs, dir string) *Backups {
+	return &Backups{Fs: fsys, Dir: dir, Keep: DefaultBackupKeep, Now: time.Now}
+}
+
+// Save copies the file at path to Dir with its permissions and removes the
+// oldest backups of it. It returns the path of the copy, or "" if there is
+// no file at path.
+func (b *Backups) Save(path string) (string, error) {
+	info, err := b.Fs.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	content, err := afero.ReadFile(b.Fs, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := b.Fs.MkdirAll(b.Dir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	backupPath := filepath.Join(b.Dir, filepath.Base(path)+"."+b.Now().UTC().Format(backupTimeFormat))
+	if err := WriteFileAtomic(b.Fs, backupPath, content, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	if err := copyOwner(b.Fs, path, backupPath); err != nil {
+		return "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return backupPath, b.prune(path)
+}
+
+// List returns the backups of the file at path, oldest first
+func (b *Backups) List(path string) ([]string, error) {
+	entries, err := afero.ReadDir(b.Fs, b.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	backups := []string{}
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			backups = append(backups, filepath.Join(b.Dir, entry.Name()))
+		}
+	}
+	slices.Sort(backups)
+	return backups, nil
+}
+
+// prune removes all but the Keep most recent backups of path
+func (b *Backups) prune(path string) error {
+	backups, err := b.List(path)
+	if err != nil {
+		return err
+	}
+	for len(backups) > max(b.Keep, 1) {
+		if err := b.Fs.Remove(backups[0]); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
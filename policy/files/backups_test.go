@@ -0,0 +1,89 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte("old\n"), 0o644))
+
+	require.NoError(t, WriteFileAtomic(fs, "/etc/opk/auth_id", []byte("new\n"), ModeSystemPerms))
+	content, err := afero.ReadFile(fs, "/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(content))
+	info, err := fs.Stat("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, ModeSystemPerms, info.Mode().Perm())
+
+	// No temporary file is left behind
+	entries, err := afero.ReadDir(fs, "/etc/opk")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	// A failed write leaves the old file in place
+	err = WriteFileAtomic(afero.NewReadOnlyFs(fs), "/etc/opk/auth_id", []byte("partial"), ModeSystemPerms)
+	require.ErrorContains(t, err, "failed to create temporary file")
+	content, err = afero.ReadFile(fs, "/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(content))
+}
+
+func TestBackups(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	backups := &Backups{Fs: fs, Dir: "/var/lib/opk/backups", Keep: 3, Now: func() time.Time { return now }}
+
+	// Nothing to back up yet
+	path, err := backups.Save("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Empty(t, path)
+
+	loader := FileLoader{Fs: fs, RequiredPerm: ModeSystemPerms, Backups: backups}
+	for _, content := range []string{"1\n", "2\n", "3\n", "4\n", "5\n"} {
+		require.NoError(t, loader.Dump([]byte(content), "/etc/opk/auth_id"))
+		now = now.Add(time.Second)
+	}
+
+	// Only the latest three backups are kept, the current file is not one
+	list, err := backups.List("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join("/var/lib/opk/backups", "auth_id.20260102T030407.000000000Z"),
+		filepath.Join("/var/lib/opk/backups", "auth_id.20260102T030408.000000000Z"),
+		filepath.Join("/var/lib/opk/backups", "auth_id.20260102T030409.000000000Z"),
+	}, list)
+	content, err := afero.ReadFile(fs, list[2])
+	require.NoError(t, err)
+	require.Equal(t, "4\n", string(content))
+	info, err := fs.Stat(list[2])
+	require.NoError(t, err)
+	require.Equal(t, ModeSystemPerms, info.Mode().Perm())
+
+	// Backups of other files are not listed or pruned
+	require.NoError(t, afero.WriteFile(fs, "/var/lib/opk/backups/providers.20200101T000000.000000000Z", nil, 0o640))
+	list, err = backups.List("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Len(t, list, 3)
+}
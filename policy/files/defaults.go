@@ -0,0 +1,38 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+// ServerAccounts are the accounts the files of an opkssh server belong to.
+// They are POSIX names, on Windows root stands for Administrators, see
+// SecurityPrincipals.
+type ServerAccounts struct {
+	// Owner owns the system policy, providers and server config
+	Owner string
+	// User is the AuthorizedKeysCommandUser sshd runs opkssh verify as
+	User string
+	// Group can read the system files so that verify, running as User,
+	// can read them
+	Group string
+}
+
+// DefaultServerAccounts are the accounts install creates and every command
+// expects the files to belong to
+var DefaultServerAccounts = ServerAccounts{
+	Owner: "root",
+	User:  "opksshuser",
+	Group: "opksshuser",
+}
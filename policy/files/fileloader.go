@@ -17,9 +17,11 @@
 package files
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"path/filepath"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -30,6 +32,25 @@ import (
 type FileLoader struct {
 	Fs           afero.Fs
 	RequiredPerm fs.FileMode
+	// Cache, if set, keeps the files read, their permissions are only
+	// checked the first time they are read
+	Cache *ReadCache
+	// Backups, if set, keeps a copy of a file before Dump replaces it
+	Backups *Backups
+	// LockTimeout is how long Lock waits for another process to release
+	// a file (default DefaultLockTimeout)
+	LockTimeout time.Duration
+}
+
+// Lock takes the lock for changing the file at path. Hold it from reading
+// the file until it is written so changes made at the same time by other
+// processes are not lost.
+func (l *FileLoader) Lock(path string) (*FileLock, error) {
+	timeout := l.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	return LockFile(l.Fs, path, timeout)
 }
 
 // CreateIfDoesNotExist creates a file at the given path if it does not exist.
@@ -60,29 +81,36 @@ func (l FileLoader) CreateIfDoesNotExist(path string) error {
 // contents and returns the bytes if file permissions are valid and
 // reading is successful; otherwise returns an error.
 func (l *FileLoader) LoadFileAtPath(path string) ([]byte, error) {
-	// Check if file exists and we can access it
-	if _, err := l.Fs.Stat(path); err != nil {
-		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	if l.Cache != nil {
+		if content, ok := l.Cache.Get(path); ok {
+			return content, nil
+		}
 	}
 
-	// Validate that file has correct permission bits set
-	if err := NewPermsChecker(l.Fs).CheckPerm(path, []fs.FileMode{l.RequiredPerm}, "", ""); err != nil {
+	// The permission bits are checked on the opened file
+	content, err := NewPermsChecker(l.Fs).ReadFile(path, []fs.FileMode{l.RequiredPerm}, "", "")
+	var permsErr *PermsError
+	if errors.As(err, &permsErr) {
 		return nil, fmt.Errorf("policy file has insecure permissions: %w", err)
-	}
-
-	// Read file contents
-	afs := &afero.Afero{Fs: l.Fs}
-	content, err := afs.ReadFile(path)
-	if err != nil {
+	} else if err != nil {
 		return nil, err
 	}
+	if l.Cache != nil {
+		l.Cache.Put(path, content)
+	}
 	return content, nil
 }
 
-// Dump writes the bytes in fileBytes to the filepath
+// Dump writes the bytes in fileBytes to the filepath. The file is replaced
+// atomically so a crash never leaves it truncated.
 func (l *FileLoader) Dump(fileBytes []byte, path string) error {
+	if l.Backups != nil {
+		if _, err := l.Backups.Save(path); err != nil {
+			return err
+		}
+	}
 	// Write to disk
-	if err := afero.WriteFile(l.Fs, path, fileBytes, l.RequiredPerm); err != nil {
+	if err := WriteFileAtomic(l.Fs, path, fileBytes, l.RequiredPerm); err != nil {
 		return err
 	}
 	return nil
@@ -17,6 +17,7 @@
 package files
 
 import (
+	"fmt"
 	"io/fs"
 	"os"
 	"os/user"
@@ -39,8 +40,33 @@ type FilePermsOps interface {
 	// ApplyACE applies a single ACE to the target path. On platforms that
 	// don't support ACE modifications, this may be a no-op or return nil.
 	ApplyACE(path string, ace ACE) error
+	// RemoveACE removes the ACEs of the principal of ace from path, the
+	// named entry of a POSIX ACL on Linux
+	RemoveACE(path string, ace ACE) error
+	// SetDACL replaces the DACL of path with aces and stops it inheriting
+	// ACEs from the parent directory. Only supported on Windows.
+	SetDACL(path string, aces []ACE) error
+	// SetInheritance changes whether the DACL of path inherits ACEs from
+	// the parent directory. Only supported on Windows.
+	SetInheritance(path string, inheritance Inheritance) error
 }
 
+// Inheritance is a change SetInheritance makes to the ACEs a DACL inherits,
+// like the icacls /inheritance option
+type Inheritance int
+
+const (
+	// InheritanceEnable inherits the ACEs of the parent directory again
+	// (icacls /inheritance:e)
+	InheritanceEnable Inheritance = iota
+	// InheritanceCopy stops inheriting and keeps the inherited ACEs as
+	// ACEs of the path itself (icacls /inheritance:d)
+	InheritanceCopy
+	// InheritanceRemove stops inheriting and drops the inherited ACEs
+	// (icacls /inheritance:r)
+	InheritanceRemove
+)
+
 // OsFilePermsOps is a default implementation that delegates to an afero.Fs
 // for filesystem operations and uses os.Chown when required.
 type OsFilePermsOps struct {
@@ -89,35 +115,60 @@ func (o *OsFilePermsOps) Chown(path string, owner string, group string) error {
 	if runtime.GOOS == "windows" {
 		return nil
 	}
-	// Lookup uid/gid
-	var uid int
-	var gid int
+	uid, gid, err := lookupOwnerIDs(owner, group)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupOwnerIDs returns the uid of owner and the gid of group, -1 for the
+// one not requested so that chown leaves it unchanged
+func lookupOwnerIDs(owner string, group string) (int, int, error) {
+	uid := -1
+	gid := -1
 	if owner != "" {
 		uobj, err := user.Lookup(owner)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 		uid64, err := strconv.ParseInt(uobj.Uid, 10, 32)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 		uid = int(uid64)
 	}
 	if group != "" {
 		gobj, err := user.LookupGroup(group)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 		gid64, err := strconv.ParseInt(gobj.Gid, 10, 32)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 		gid = int(gid64)
 	}
-	return os.Chown(path, uid, gid)
+	return uid, gid, nil
 }
 
 func (o *OsFilePermsOps) ApplyACE(path string, ace ACE) error {
-	// POSIX: ACEs are not supported in this abstraction. No-op.
-	return nil
+	// POSIX: only the named users and groups of a POSIX ACL (user:alice,
+	// group:staff) are supported, other ACEs are a no-op
+	if ace.Type != "allow" || !isPosixACLPrincipal(ace.Principal) {
+		return nil
+	}
+	return changePosixACL(path, ace, false)
+}
+
+func (o *OsFilePermsOps) RemoveACE(path string, ace ACE) error {
+	return changePosixACL(path, ace, true)
+}
+
+func (o *OsFilePermsOps) SetDACL(path string, aces []ACE) error {
+	return fmt.Errorf("setting a DACL is only supported on Windows")
+}
+
+func (o *OsFilePermsOps) SetInheritance(path string, inheritance Inheritance) error {
+	return fmt.Errorf("DACL inheritance is only supported on Windows")
 }
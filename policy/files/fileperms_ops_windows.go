@@ -12,8 +12,8 @@ import (
 // WindowsFilePermsOps implements FilePermsOps for Windows.
 // On Windows, POSIX permission bits and chown semantics do not apply. This
 // implementation delegates file operations to the provided afero.Fs and
-// performs no-op for owner/group semantics. Later we can extend this to call
-// into Win32 APIs or PowerShell/icacls to verify and set ACLs.
+// performs no-op for owner/group semantics. WindowsACLFilePermsOps sets
+// them with the Win32 API.
 type WindowsFilePermsOps struct {
 	Fs afero.Fs
 }
@@ -53,8 +53,23 @@ func (w *WindowsFilePermsOps) Chown(path string, owner string, group string) err
 	return nil
 }
 
+func (w *WindowsFilePermsOps) SetDACL(path string, aces []ACE) error {
+	// No-op like ApplyACE, see WindowsACLFilePermsOps
+	return nil
+}
+
+func (w *WindowsFilePermsOps) RemoveACE(path string, ace ACE) error {
+	// No-op like ApplyACE
+	return nil
+}
+
+func (w *WindowsFilePermsOps) SetInheritance(path string, inheritance Inheritance) error {
+	// No-op like SetDACL
+	return nil
+}
+
 func (w *WindowsFilePermsOps) ApplyACE(path string, ace ACE) error {
 	// No-op default for simple WindowsFilePermsOps. Use WindowsACLFilePermsOps
-	// for icacls-based ACL modifications.
+	// for Win32 API based ACL modifications.
 	return nil
 }
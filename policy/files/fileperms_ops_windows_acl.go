@@ -7,23 +7,26 @@ import (
 	"fmt"
 	"io/fs"
 	"strings"
-	"syscall"
 	"unsafe"
 
 	"github.com/spf13/afero"
 )
 
-// WindowsACLFilePermsOps implements FilePermsOps using icacls for ACL changes.
-// This provides a stricter mapping of ownership/ACL semantics on Windows.
+// WindowsACLFilePermsOps implements FilePermsOps with the Win32 security
+// API for ownership and ACL changes, which works where icacls is missing,
+// such as Nano Server. This provides a stricter mapping of ownership/ACL
+// semantics on Windows.
 type WindowsACLFilePermsOps struct {
-	Fs afero.Fs
+	Fs  afero.Fs
+	api securityAPI
 }
 
 // NewWindowsACLFilePermsOps returns a FilePermsOps that applies ACL changes
-// using icacls. This is more suitable for production Windows installs where
-// runtime verification or repair of ACLs is desired.
+// with SetEntriesInAclW and SetNamedSecurityInfoW. This is more suitable for
+// production Windows installs where runtime verification or repair of ACLs
+// is desired.
 func NewWindowsACLFilePermsOps(fs afero.Fs) FilePermsOps {
-	return &WindowsACLFilePermsOps{Fs: fs}
+	return &WindowsACLFilePermsOps{Fs: fs, api: win32SecurityAPI{}}
 }
 
 func (w *WindowsACLFilePermsOps) MkdirAllWithPerm(path string, perm fs.FileMode) error {
@@ -46,57 +49,61 @@ func (w *WindowsACLFilePermsOps) Stat(path string) (fs.FileInfo, error) {
 	return w.Fs.Stat(path)
 }
 
-// Chown attempts to set owner and grant basic ACLs using icacls. If icacls is
-// not available or the operation fails, an error is returned.
+// Chown sets the owner of path and grants the group read access and
+// Administrators full control. An error is returned if any change fails.
 func (w *WindowsACLFilePermsOps) Chown(path string, owner string, group string) error {
 	// If nothing requested, nothing to do
 	if owner == "" && group == "" {
 		return nil
 	}
 
-	// Map common POSIX names to Windows principals
-	ownerName := owner
-	if owner == "root" {
-		ownerName = "Administrators"
-	}
+	// Map the POSIX names to the Windows principals, by SID as their
+	// names are localized
+	principals := Principals()
 
-	// Set owner via Win32 LookupAccountNameW -> SetNamedSecurityInfoW
-	if ownerName != "" {
-		sid, _, err := ResolveAccountToSID(ownerName)
+	if owner != "" {
+		sid, _, err := aceSID(principals.Resolve(owner).ACE(""))
 		if err != nil {
-			return fmt.Errorf("LookupAccountNameW failed for %s: %v", ownerName, err)
+			return fmt.Errorf("LookupAccountNameW failed for %s: %v", owner, err)
 		}
-		// Apply owner using SetNamedSecurityInfoW
-		pPath, _ := syscall.UTF16PtrFromString(path)
-		ret2, _, err := procSetNamedSecurityInfo.Call(
-			uintptr(unsafe.Pointer(pPath)),
-			uintptr(SE_FILE_OBJECT),
-			uintptr(OWNER_SECURITY_INFORMATION),
-			uintptr(unsafe.Pointer(&sid[0])),
-			0,
-			0,
-			0,
-		)
-		if ret2 != 0 {
-			return fmt.Errorf("SetNamedSecurityInfoW (owner) failed: %v (ret=%d)", err, ret2)
+		if err := w.api.SetOwner(path, sid); err != nil {
+			return err
 		}
 	}
 
 	// If group provided, grant GENERIC_READ via ApplyACE
 	if group != "" {
-		if err := w.ApplyACE(path, ACE{Principal: group, Rights: "GENERIC_READ", Type: "allow"}); err != nil {
+		if err := w.ApplyACE(path, principals.Resolve(group).ACE("GENERIC_READ")); err != nil {
 			return fmt.Errorf("failed to apply group ACE: %v", err)
 		}
 	}
 
 	// Ensure Administrators have full control via ApplyACE
-	if err := w.ApplyACE(path, ACE{Principal: "Administrators", Rights: "GENERIC_ALL", Type: "allow"}); err != nil {
+	if err := w.ApplyACE(path, principals.Administrators.ACE("GENERIC_ALL")); err != nil {
 		return fmt.Errorf("ensure admin ACE failed: %v", err)
 	}
 
 	return nil
 }
 
+// aceSID returns the raw SID of the principal of ace and its SID_NAME_USE
+// when it was looked up. Well-known principals are found by SID, so that
+// localized names such as Administratoren resolve.
+func aceSID(ace ACE) ([]byte, uint32, error) {
+	if len(ace.PrincipalSID) > 0 {
+		return ace.PrincipalSID, 0, nil
+	}
+	sid := ace.PrincipalSIDStr
+	if sid == "" {
+		sid = Principals().Resolve(ace.Principal).SID
+	}
+	if sid != "" {
+		raw, err := StringToSID(sid)
+		return raw, 0, err
+	}
+	return ResolveAccountToSID(ace.Principal)
+}
+
 // EXPLICIT_ACCESS and TRUSTEE definitions for calling SetEntriesInAclW
 type _TRUSTEE struct {
 	MultipleTrustee         uintptr
@@ -121,6 +128,9 @@ var (
 const (
 	// from Winnt.h / AccCtrl.h
 	GRANT_ACCESS       = 1
+	SET_ACCESS         = 2
+	DENY_ACCESS        = 3
+	REVOKE_ACCESS      = 4
 	NO_INHERITANCE     = 0
 	TRUSTEE_IS_NAME    = 1
 	TRUSTEE_IS_SID     = 0
@@ -202,103 +212,64 @@ func rightsToMask(rights string) uint32 {
 	return m
 }
 
-// ApplyACE via Win32 APIs (SetEntriesInAclW + SetNamedSecurityInfoW)
-func (w *WindowsACLFilePermsOps) ApplyACE(path string, ace ACE) error {
-	// Currently only supports adding simple allow/deny entries by account name
-	pPath, _ := syscall.UTF16PtrFromString(path)
-
-	// Get existing DACL
-	var pDacl uintptr
-	var pSD uintptr
-	ret, _, _ := procGetNamedSecInfo.Call(
-		uintptr(unsafe.Pointer(pPath)),
-		uintptr(SE_FILE_OBJECT),
-		uintptr(DACL_SECURITY_INFORMATION),
-		0,
-		0,
-		uintptr(unsafe.Pointer(&pDacl)),
-		0,
-		uintptr(unsafe.Pointer(&pSD)),
-	)
-	if ret != 0 {
-		return fmt.Errorf("GetNamedSecurityInfoW failed: %d", ret)
-	}
-	if pSD != 0 {
-		defer procLocalFree.Call(pSD)
-	}
+// PROTECTED_DACL_SECURITY_INFORMATION stops a DACL from inheriting ACEs
+const PROTECTED_DACL_SECURITY_INFORMATION = 0x80000000
 
-	// Build EXPLICIT_ACCESS
-	var ea _EXPLICIT_ACCESS
-	ea.GrfAccessPermissions = rightsToMask(ace.Rights)
-	if ace.Type == "allow" {
-		ea.GrfAccessMode = GRANT_ACCESS
-	} else {
-		// For deny use DENY_ACCESS(3) per ACCESS_MODE, but SetEntriesInAcl supports DENY_ACCESS as 3
-		ea.GrfAccessMode = 3
+// SetDACL builds a new DACL from aces and sets it as a protected DACL,
+// which drops the inherited ACEs
+func (w *WindowsACLFilePermsOps) SetDACL(path string, aces []ACE) error {
+	if len(aces) == 0 {
+		return fmt.Errorf("refusing to set an empty DACL on %s", path)
 	}
-	ea.GrfInheritance = NO_INHERITANCE
-
-	// Prefer using provided SID if available
-	if len(ace.PrincipalSID) > 0 {
-		ea.Trustee = _TRUSTEE{
-			MultipleTrustee:         0,
-			MultipleTrusteeOperator: 0,
-			TrusteeForm:             TRUSTEE_IS_SID,
-			TrusteeType:             TRUSTEE_TYPE_UNKNOWN,
-			PtstrName:               unsafe.Pointer(&ace.PrincipalSID[0]),
+	entries := make([]aclEntry, len(aces))
+	for i, ace := range aces {
+		sid, _, err := aceSID(ace)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", ace.Principal, err)
 		}
-	} else {
-		// Attempt to resolve to SID via helper
-		sid, sidUse, err := ResolveAccountToSID(ace.Principal)
-		if err == nil && len(sid) > 0 {
-			ea.Trustee = _TRUSTEE{
-				MultipleTrustee:         0,
-				MultipleTrusteeOperator: 0,
-				TrusteeForm:             TRUSTEE_IS_SID,
-				TrusteeType:             sidUseToTrusteeType(sidUse),
-				PtstrName:               unsafe.Pointer(&sid[0]),
-			}
-		} else {
-			pName, _ := syscall.UTF16PtrFromString(ace.Principal)
-			ea.Trustee = _TRUSTEE{
-				MultipleTrustee:         0,
-				MultipleTrusteeOperator: 0,
-				TrusteeForm:             TRUSTEE_IS_NAME,
-				TrusteeType:             TRUSTEE_TYPE_UNKNOWN,
-				PtstrName:               unsafe.Pointer(pName),
-			}
+		mode := uint32(SET_ACCESS)
+		if ace.Type == "deny" {
+			mode = DENY_ACCESS
 		}
+		entries[i] = aclEntry{SID: sid, Mask: rightsToMask(ace.Rights), Mode: mode}
 	}
+	return w.api.SetDACL(path, entries, false, true)
+}
 
-	// Call SetEntriesInAclW
-	var pNewAcl uintptr
-	ret2, _, err := procSetEntriesInAcl.Call(
-		uintptr(1),
-		uintptr(unsafe.Pointer(&ea)),
-		uintptr(pDacl),
-		uintptr(unsafe.Pointer(&pNewAcl)),
-	)
-	if ret2 != 0 {
-		return fmt.Errorf("SetEntriesInAclW failed: %v (ret=%d)", err, ret2)
+// ApplyACE adds an allow or deny entry to the DACL of path
+func (w *WindowsACLFilePermsOps) ApplyACE(path string, ace ACE) error {
+	entry := aclEntry{Mask: rightsToMask(ace.Rights), Mode: GRANT_ACCESS}
+	if ace.Type != "allow" {
+		entry.Mode = DENY_ACCESS
 	}
-	if pNewAcl == 0 {
-		return fmt.Errorf("SetEntriesInAclW returned nil ACL")
+	// Prefer the SID, fall back to the name if it can't be resolved
+	if sid, sidUse, err := aceSID(ace); err == nil && len(sid) > 0 {
+		entry.SID, entry.SIDUse = sid, sidUse
+	} else {
+		entry.Name = ace.Principal
 	}
-	defer procLocalFree.Call(pNewAcl)
+	return w.api.SetDACL(path, []aclEntry{entry}, true, false)
+}
 
-	// Apply new DACL to the file
-	ret3, _, err := procSetNamedSecurityInfo.Call(
-		uintptr(unsafe.Pointer(pPath)),
-		uintptr(SE_FILE_OBJECT),
-		uintptr(DACL_SECURITY_INFORMATION),
-		0,
-		0,
-		uintptr(unsafe.Pointer(pNewAcl)),
-		0,
-	)
-	if ret3 != 0 {
-		return fmt.Errorf("SetNamedSecurityInfoW failed: %v (ret=%d)", err, ret3)
+// RemoveACE revokes every allow and deny entry of the principal of ace
+// from the DACL of path
+func (w *WindowsACLFilePermsOps) RemoveACE(path string, ace ACE) error {
+	entry := aclEntry{Mode: REVOKE_ACCESS}
+	if sid, sidUse, err := aceSID(ace); err == nil && len(sid) > 0 {
+		entry.SID, entry.SIDUse = sid, sidUse
+	} else {
+		entry.Name = ace.Principal
 	}
+	return w.api.SetDACL(path, []aclEntry{entry}, true, false)
+}
 
-	return nil
+// SetInheritance stops or restarts the DACL of path inheriting ACEs, like
+// icacls /inheritance
+func (w *WindowsACLFilePermsOps) SetInheritance(path string, inheritance Inheritance) error {
+	switch inheritance {
+	case InheritanceEnable, InheritanceCopy, InheritanceRemove:
+	default:
+		return fmt.Errorf("unknown inheritance change %d for %s", inheritance, path)
+	}
+	return w.api.SetInheritance(path, inheritance)
 }
@@ -0,0 +1,191 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// daclCall records a call to securityAPI.SetDACL
+type daclCall struct {
+	path      string
+	entries   []aclEntry
+	merge     bool
+	protected bool
+}
+
+// fakeSecurityAPI records the changes instead of calling Win32
+type fakeSecurityAPI struct {
+	owners map[string][]byte
+	dacls  []daclCall
+	// inheritance records the inheritance changes of each path in order
+	inheritance map[string][]Inheritance
+	err         error
+}
+
+func (f *fakeSecurityAPI) SetOwner(path string, sid []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.owners == nil {
+		f.owners = map[string][]byte{}
+	}
+	f.owners[path] = sid
+	return nil
+}
+
+func (f *fakeSecurityAPI) SetDACL(path string, entries []aclEntry, merge bool, protected bool) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.dacls = append(f.dacls, daclCall{path: path, entries: entries, merge: merge, protected: protected})
+	return nil
+}
+
+func (f *fakeSecurityAPI) SetInheritance(path string, inheritance Inheritance) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.inheritance == nil {
+		f.inheritance = map[string][]Inheritance{}
+	}
+	f.inheritance[path] = append(f.inheritance[path], inheritance)
+	return nil
+}
+
+func mustSID(t *testing.T, sid string) []byte {
+	t.Helper()
+	raw, err := StringToSID(sid)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestWindowsACLFilePermsOpsChown(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	ops := &WindowsACLFilePermsOps{Fs: afero.NewMemMapFs(), api: api}
+
+	require.NoError(t, ops.Chown(`C:\ProgramData\opk\auth_id`, "root", "S-1-5-32-545"))
+	require.Equal(t, map[string][]byte{`C:\ProgramData\opk\auth_id`: mustSID(t, SIDAdministrators)}, api.owners)
+	require.Equal(t, []daclCall{
+		{path: `C:\ProgramData\opk\auth_id`, entries: []aclEntry{{SID: mustSID(t, "S-1-5-32-545"), Mask: rightsToMask("GENERIC_READ"), Mode: GRANT_ACCESS}}, merge: true},
+		{path: `C:\ProgramData\opk\auth_id`, entries: []aclEntry{{SID: mustSID(t, SIDAdministrators), Mask: rightsToMask("GENERIC_ALL"), Mode: GRANT_ACCESS}}, merge: true},
+	}, api.dacls)
+
+	// Nothing requested, nothing changed
+	api = &fakeSecurityAPI{}
+	ops.api = api
+	require.NoError(t, ops.Chown(`C:\ProgramData\opk\auth_id`, "", ""))
+	require.Empty(t, api.owners)
+	require.Empty(t, api.dacls)
+
+	ops.api = &fakeSecurityAPI{err: fmt.Errorf("access denied")}
+	require.ErrorContains(t, ops.Chown(`C:\ProgramData\opk\auth_id`, "root", ""), "access denied")
+}
+
+func TestWindowsACLFilePermsOpsSetDACL(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	ops := &WindowsACLFilePermsOps{Fs: afero.NewMemMapFs(), api: api}
+
+	require.NoError(t, ops.SetDACL(`C:\ProgramData\opk\auth_id`, []ACE{
+		{Principal: "Administrators", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "SYSTEM", Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "Guests", PrincipalSIDStr: "S-1-5-32-546", Rights: "GENERIC_READ", Type: "deny"},
+	}))
+	require.Equal(t, []daclCall{{
+		path: `C:\ProgramData\opk\auth_id`,
+		entries: []aclEntry{
+			{SID: mustSID(t, SIDAdministrators), Mask: rightsToMask("GENERIC_ALL"), Mode: SET_ACCESS},
+			{SID: mustSID(t, SIDSystem), Mask: rightsToMask("GENERIC_ALL"), Mode: SET_ACCESS},
+			{SID: mustSID(t, "S-1-5-32-546"), Mask: rightsToMask("GENERIC_READ"), Mode: DENY_ACCESS},
+		},
+		protected: true,
+	}}, api.dacls)
+
+	require.ErrorContains(t, ops.SetDACL(`C:\ProgramData\opk\auth_id`, nil), "refusing to set an empty DACL")
+}
+
+func TestWindowsACLFilePermsOpsApplyACE(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	ops := &WindowsACLFilePermsOps{Fs: afero.NewMemMapFs(), api: api}
+
+	// An account that can't be resolved is passed by name
+	require.NoError(t, ops.ApplyACE(`C:\x`, ACE{Principal: "no-such-account-opkssh", Rights: "GENERIC_READ", Type: "deny"}))
+	require.Equal(t, []daclCall{{
+		path:    `C:\x`,
+		entries: []aclEntry{{Name: "no-such-account-opkssh", Mask: rightsToMask("GENERIC_READ"), Mode: DENY_ACCESS}},
+		merge:   true,
+	}}, api.dacls)
+}
+
+func TestWindowsACLFilePermsOpsSetInheritance(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	ops := &WindowsACLFilePermsOps{Fs: afero.NewMemMapFs(), api: api}
+
+	require.NoError(t, ops.SetInheritance(`C:\ProgramData\opk`, InheritanceCopy))
+	require.NoError(t, ops.SetInheritance(`C:\ProgramData\opk`, InheritanceEnable))
+	require.NoError(t, ops.SetInheritance(`C:\ProgramData\opk\auth_id`, InheritanceRemove))
+	require.Equal(t, map[string][]Inheritance{
+		`C:\ProgramData\opk`:         {InheritanceCopy, InheritanceEnable},
+		`C:\ProgramData\opk\auth_id`: {InheritanceRemove},
+	}, api.inheritance)
+	require.Empty(t, api.dacls)
+
+	require.ErrorContains(t, ops.SetInheritance(`C:\x`, Inheritance(7)), "unknown inheritance change 7")
+	ops.api = &fakeSecurityAPI{err: fmt.Errorf("access denied")}
+	require.ErrorContains(t, ops.SetInheritance(`C:\x`, InheritanceCopy), "access denied")
+}
+
+func TestWindowsACLFilePermsOpsRemoveACE(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	ops := &WindowsACLFilePermsOps{Fs: afero.NewMemMapFs(), api: api}
+
+	require.NoError(t, ops.RemoveACE(`C:\x`, ACE{Principal: "Users", PrincipalSIDStr: "S-1-5-32-545", Rights: "GENERIC_READ", Type: "allow"}))
+	require.Equal(t, []daclCall{{
+		path:    `C:\x`,
+		entries: []aclEntry{{SID: mustSID(t, "S-1-5-32-545"), Mode: REVOKE_ACCESS}},
+		merge:   true,
+	}}, api.dacls)
+}
+
+func TestSetSecureFileStateWindows(t *testing.T) {
+	api := &fakeSecurityAPI{}
+	afs := afero.NewMemMapFs()
+	fsys := &defaultFileSystem{afs: afs, ops: &WindowsACLFilePermsOps{Fs: afs, api: api}}
+
+	// A file gets the owner and exactly the DesiredDACL
+	pi := PermInfo{Mode: 0o640, Owner: "Administrators"}
+	require.NoError(t, SetSecureFileState(fsys, `C:\ProgramData\opk\auth_id`, pi, false))
+	exists, err := afero.Exists(afs, `C:\ProgramData\opk\auth_id`)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, mustSID(t, SIDAdministrators), api.owners[`C:\ProgramData\opk\auth_id`])
+	last := api.dacls[len(api.dacls)-1]
+	require.True(t, last.protected)
+	require.False(t, last.merge)
+	require.Len(t, last.entries, len(DesiredDACL(pi)))
+
+	// A directory stops inheriting and keeps the access it has
+	require.NoError(t, SetSecureFileState(fsys, `C:\ProgramData\opk\policy.d`, PermInfo{Mode: 0o750}, true))
+	require.Equal(t, []Inheritance{InheritanceCopy}, api.inheritance[`C:\ProgramData\opk\policy.d`])
+}
@@ -17,7 +17,10 @@
 package files
 
 import (
+	"fmt"
 	"io/fs"
+	"os"
+	"runtime"
 
 	"github.com/spf13/afero"
 )
@@ -29,6 +32,8 @@ import (
 type FileSystem interface {
 	// Stat returns file info for the given path.
 	Stat(path string) (fs.FileInfo, error)
+	// Lstat is like Stat but does not follow a symbolic link at path.
+	Lstat(path string) (fs.FileInfo, error)
 	// Exists reports whether the path exists.
 	Exists(path string) (bool, error)
 	// Open opens a file for reading (e.g. directory listing via Readdir).
@@ -42,17 +47,50 @@ type FileSystem interface {
 	CreateFile(path string) (afero.File, error)
 	// WriteFile writes data to a file with the given permission.
 	WriteFile(path string, data []byte, perm fs.FileMode) error
+	// Remove removes a file or an empty directory.
+	Remove(path string) error
 
 	// Chmod sets the permission mode bits on a path.
 	Chmod(path string, perm fs.FileMode) error
 	// Chown sets the owner and group on a path.
 	Chown(path string, owner string, group string) error
+	// ChmodNoFollow is like Chmod, but changes the file it opened without
+	// following a symbolic link at path or at its directory.
+	ChmodNoFollow(path string, perm fs.FileMode) error
+	// ChownNoFollow is like Chown, but changes the file it opened without
+	// following a symbolic link at path or at its directory.
+	ChownNoFollow(path string, owner string, group string) error
 	// ApplyACE applies a single access control entry to a path.
 	ApplyACE(path string, ace ACE) error
+	// RemoveACE removes the ACEs of the principal of ace from a path.
+	RemoveACE(path string, ace ACE) error
+	// RemoveACENoFollow is like RemoveACE, but changes the file it opened
+	// without following a symbolic link at path or at its directory.
+	RemoveACENoFollow(path string, ace ACE) error
+	// SetDACL replaces the DACL of a path with aces and disables
+	// inheritance. Only supported on Windows.
+	SetDACL(path string, aces []ACE) error
+	// SetInheritance changes whether the DACL of a path inherits the ACEs
+	// of its directory. Only supported on Windows.
+	SetInheritance(path string, inheritance Inheritance) error
+	// IsImmutable reports whether the system immutable flag (chflags schg
+	// on BSD) is set on a path. Always false where it is not supported.
+	IsImmutable(path string) (bool, error)
+	// SetImmutable sets or clears the system immutable flag on a path.
+	SetImmutable(path string, immutable bool) error
+	// SELinuxType returns the SELinux type of a path. It is empty if
+	// SELinux is not enabled.
+	SELinuxType(path string) (string, error)
+	// SetSELinuxType gives a path, and everything in it if dir is set, the
+	// SELinux type typ with semanage fcontext and restorecon.
+	SetSELinuxType(path string, typ string, dir bool) error
 
 	// CheckPerm verifies that the file at path has one of the required
 	// permission modes and, optionally, the expected owner and group.
 	CheckPerm(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) error
+	// CheckPathChain returns a problem for each directory above path that
+	// a user other than root can write to.
+	CheckPathChain(path string) ([]string, error)
 	// VerifyACL checks ACLs and ownership against expectations.
 	VerifyACL(path string, expected ExpectedACL) (ACLReport, error)
 }
@@ -110,6 +148,14 @@ func (d *defaultFileSystem) ReadFile(path string) ([]byte, error) {
 	return afero.ReadFile(d.afs, path)
 }
 
+func (d *defaultFileSystem) Lstat(path string) (fs.FileInfo, error) {
+	if l, ok := d.afs.(afero.Lstater); ok {
+		fi, _, err := l.LstatIfPossible(path)
+		return fi, err
+	}
+	return d.afs.Stat(path)
+}
+
 func (d *defaultFileSystem) MkdirAll(path string, perm fs.FileMode) error {
 	return d.ops.MkdirAllWithPerm(path, perm)
 }
@@ -122,6 +168,10 @@ func (d *defaultFileSystem) WriteFile(path string, data []byte, perm fs.FileMode
 	return d.ops.WriteFileWithPerm(path, data, perm)
 }
 
+func (d *defaultFileSystem) Remove(path string) error {
+	return d.afs.Remove(path)
+}
+
 func (d *defaultFileSystem) Chmod(path string, perm fs.FileMode) error {
 	return d.ops.Chmod(path, perm)
 }
@@ -130,14 +180,115 @@ func (d *defaultFileSystem) Chown(path string, owner string, group string) error
 	return d.ops.Chown(path, owner, group)
 }
 
+func (d *defaultFileSystem) ChmodNoFollow(path string, perm fs.FileMode) error {
+	return d.noFollow(path, func(f *os.File) error {
+		return f.Chmod(perm)
+	}, func() error {
+		return d.ops.Chmod(path, perm)
+	})
+}
+
+func (d *defaultFileSystem) ChownNoFollow(path string, owner string, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+	return d.noFollow(path, func(f *os.File) error {
+		uid, gid, err := lookupOwnerIDs(owner, group)
+		if err != nil {
+			return err
+		}
+		return f.Chown(uid, gid)
+	}, func() error {
+		return d.ops.Chown(path, owner, group)
+	})
+}
+
+func (d *defaultFileSystem) RemoveACENoFollow(path string, ace ACE) error {
+	return d.noFollow(path, func(f *os.File) error {
+		// The magic link of the descriptor is the opened file
+		return changePosixACL(fmt.Sprintf("/proc/self/fd/%d", f.Fd()), ace, true)
+	}, func() error {
+		return d.ops.RemoveACE(path, ace)
+	})
+}
+
+// noFollow changes the file at path with opened, on a handle opened without
+// following a symbolic link, so that a user who owns the directory can't
+// swap the path for a link to another file once it was checked. Where
+// there is no such handle, on Windows or a filesystem other than the os, a
+// symbolic link at path is refused and byPath changes it.
+func (d *defaultFileSystem) noFollow(path string, opened func(*os.File) error, byPath func() error) error {
+	if _, ok := d.afs.(*afero.OsFs); ok && runtime.GOOS != "windows" {
+		f, err := openForChange(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return opened(f)
+	}
+	fi, err := d.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&(fs.ModeSymlink|fs.ModeIrregular) != 0 {
+		return &PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)}
+	}
+	return byPath()
+}
+
 func (d *defaultFileSystem) ApplyACE(path string, ace ACE) error {
 	return d.ops.ApplyACE(path, ace)
 }
 
+func (d *defaultFileSystem) RemoveACE(path string, ace ACE) error {
+	return d.ops.RemoveACE(path, ace)
+}
+
+func (d *defaultFileSystem) SetDACL(path string, aces []ACE) error {
+	return d.ops.SetDACL(path, aces)
+}
+
+func (d *defaultFileSystem) SetInheritance(path string, inheritance Inheritance) error {
+	return d.ops.SetInheritance(path, inheritance)
+}
+
+func (d *defaultFileSystem) IsImmutable(path string) (bool, error) {
+	// File flags only exist on the real filesystem
+	if _, ok := d.afs.(*afero.OsFs); !ok {
+		return false, nil
+	}
+	return isImmutable(path)
+}
+
+func (d *defaultFileSystem) SetImmutable(path string, immutable bool) error {
+	if _, ok := d.afs.(*afero.OsFs); !ok {
+		return nil
+	}
+	return setImmutable(path, immutable)
+}
+
+func (d *defaultFileSystem) SELinuxType(path string) (string, error) {
+	if _, ok := d.afs.(*afero.OsFs); !ok || !selinuxEnabled() {
+		return "", nil
+	}
+	return selinuxType(path)
+}
+
+func (d *defaultFileSystem) SetSELinuxType(path string, typ string, dir bool) error {
+	if _, ok := d.afs.(*afero.OsFs); !ok || !selinuxEnabled() {
+		return nil
+	}
+	return setSELinuxType(d.checker.CmdRunner, path, typ, dir)
+}
+
 func (d *defaultFileSystem) CheckPerm(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) error {
 	return d.checker.CheckPerm(path, requirePerm, requiredOwner, requiredGroup)
 }
 
+func (d *defaultFileSystem) CheckPathChain(path string) ([]string, error) {
+	return d.checker.CheckPathChain(path)
+}
+
 func (d *defaultFileSystem) VerifyACL(path string, expected ExpectedACL) (ACLReport, error) {
 	return d.acl.VerifyACL(path, expected)
 }
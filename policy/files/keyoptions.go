@@ -0,0 +1,93 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// keyOptions are the authorized_keys options opkssh may give sshd with the
+// cert-authority key, and whether they take a value. Only options that
+// restrict the login are supported.
+var keyOptions = map[string]bool{
+	"command":             true,
+	"expiry-time":         true,
+	"from":                true,
+	"permitlisten":        true,
+	"permitopen":          true,
+	"no-agent-forwarding": false,
+	"no-port-forwarding":  false,
+	"no-pty":              false,
+	"no-user-rc":          false,
+	"no-x11-forwarding":   false,
+	"restrict":            false,
+}
+
+// IsKeyOption returns true if name is a supported authorized_keys option
+func IsKeyOption(name string) bool {
+	_, ok := keyOptions[strings.ToLower(name)]
+	return ok
+}
+
+// CheckKeyOption returns why the authorized_keys option name with the
+// unquoted value, empty for options without one, can't be given to sshd
+func CheckKeyOption(name string, value string, hasValue bool) error {
+	takesValue, ok := keyOptions[strings.ToLower(name)]
+	switch {
+	case !ok:
+		return fmt.Errorf("unsupported option %s", name)
+	case takesValue && !hasValue:
+		return fmt.Errorf("option %s requires a value", name)
+	case !takesValue && hasValue:
+		return fmt.Errorf("option %s does not take a value", name)
+	case takesValue && value == "":
+		return fmt.Errorf("the value of option %s is empty", name)
+	case strings.ContainsAny(value, "\\\"") || strings.IndexFunc(value, unicode.IsControl) >= 0:
+		return fmt.Errorf("the value of option %s contains a quote, a backslash or a control character", name)
+	}
+	return nil
+}
+
+// ParseKeyOption returns the name, in lower case, and the unquoted value of
+// an authorized_keys option such as no-pty or from="10.0.0.0/8"
+func ParseKeyOption(option string) (string, string, error) {
+	name, value, hasValue := strings.Cut(option, "=")
+	name = strings.ToLower(name)
+	if hasValue {
+		inner, quoted := strings.CutPrefix(value, `"`)
+		inner, ok := strings.CutSuffix(inner, `"`)
+		if !quoted || !ok {
+			return "", "", fmt.Errorf("the value of option %s must be double-quoted, got %s", name, value)
+		}
+		value = inner
+	}
+	if err := CheckKeyOption(name, value, hasValue); err != nil {
+		return "", "", err
+	}
+	return name, value, nil
+}
+
+// FormatKeyOption returns the authorized_keys option name, with the value
+// quoted if hasValue is set
+func FormatKeyOption(name string, value string, hasValue bool) string {
+	if !hasValue {
+		return name
+	}
+	return name + `="` + value + `"`
+}
@@ -0,0 +1,45 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyOption(t *testing.T) {
+	name, value, err := ParseKeyOption(`From="10.0.0.0/8"`)
+	require.NoError(t, err)
+	require.Equal(t, "from", name)
+	require.Equal(t, "10.0.0.0/8", value)
+	require.Equal(t, `from="10.0.0.0/8"`, FormatKeyOption(name, value, true))
+
+	name, _, err = ParseKeyOption("no-pty")
+	require.NoError(t, err)
+	require.Equal(t, "no-pty", FormatKeyOption(name, "", false))
+
+	for option, want := range map[string]string{
+		`environment="A=b"`: "unsupported option environment",
+		`from=10.0.0.0/8`:   "must be double-quoted",
+		`"from"`:            "unsupported option",
+		`command="a\"b"`:    "contains a quote",
+	} {
+		_, _, err := ParseKeyOption(option)
+		require.ErrorContains(t, err, want, option)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultLockTimeout is how long LockFile waits for a lock held by another
+// process by default
+const DefaultLockTimeout = 10 * time.Second
+
+const lockRetryInterval = 100 * time.Millisecond
+
+// ErrLocked is returned when a file is locked by another process
+var ErrLocked = errors.New("file is locked by another process")
+
+// memLocks holds the locks taken on file systems other than the OS one,
+// such as in memory file systems in tests. They only exclude this process.
+var memLocks sync.Map
+
+type memLockKey struct {
+	fs   afero.Fs
+	path string
+}
+
+// FileLock is an exclusive advisory lock on a file, taken with flock on
+// Unix-like systems and LockFileEx on Windows
+type FileLock struct {
+	key  memLockKey
+	file *os.File
+}
+
+// LockFile takes an exclusive lock for changing the file at path, retrying
+// for up to timeout if another process holds it. The lock is taken on
+// path.lock rather than path as files replaced by WriteFileAtomic are new
+// files. Release it with Unlock.
+func LockFile(fsys afero.Fs, path string, timeout time.Duration) (*FileLock, error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := tryLock(fsys, lockPath)
+		if !errors.Is(err, ErrLocked) {
+			return lock, err
+		}
+		if !time.Now().Before(deadline) {
+			holder := ""
+			if pid := lockHolder(fsys, lockPath); pid != "" {
+				holder = " (process " + pid + ")"
+			}
+			return nil, fmt.Errorf("%s is being changed by another process%s, gave up after %s: %w", path, holder, timeout, ErrLocked)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// tryLock takes the lock on lockPath or returns ErrLocked if it is held
+func tryLock(fsys afero.Fs, lockPath string) (*FileLock, error) {
+	if _, ok := fsys.(*afero.OsFs); !ok {
+		key := memLockKey{fs: fsys, path: lockPath}
+		if _, held := memLocks.LoadOrStore(key, struct{}{}); held {
+			return nil, ErrLocked
+		}
+		return &FileLock{key: key}, nil
+	}
+
+	// The lock file can be shared by processes of different users, such as
+	// the telemetry counts of verify and of the commands run as root. Taking
+	// the lock only needs the file open, so a lock file created by another
+	// user is opened read only.
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if errors.Is(err, fs.ErrPermission) {
+		f, err = os.Open(lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockExclusive(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Record the holder so that contention errors can name it
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &FileLock{file: f}, nil
+}
+
+// lockHolder returns the pid recorded in lockPath, if it can be read
+func lockHolder(fsys afero.Fs, lockPath string) string {
+	content, err := afero.ReadFile(fsys, lockPath)
+	if err != nil {
+		return ""
+	}
+	pid := strings.TrimSpace(string(content))
+	if _, err := strconv.Atoi(pid); err != nil {
+		return ""
+	}
+	return pid
+}
+
+// Unlock releases the lock. The lock file is left in place, removing it
+// would let another process lock a file that is about to be removed.
+func (l *FileLock) Unlock() error {
+	if l.file == nil {
+		memLocks.Delete(l.key)
+		return nil
+	}
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFile(t *testing.T) {
+	for name, fs := range map[string]afero.Fs{
+		"os":     afero.NewOsFs(),
+		"memory": afero.NewMemMapFs(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, fs.MkdirAll(dir, 0o750))
+			path := filepath.Join(dir, "auth_id")
+
+			lock, err := LockFile(fs, path, time.Second)
+			require.NoError(t, err)
+
+			// A second lock waits for the timeout and then fails
+			start := time.Now()
+			_, err = LockFile(fs, path, 200*time.Millisecond)
+			require.True(t, errors.Is(err, ErrLocked), err)
+			require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+			require.ErrorContains(t, err, path+" is being changed by another process")
+
+			// It succeeds once the lock is released
+			released := make(chan struct{})
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				require.NoError(t, lock.Unlock())
+				close(released)
+			}()
+			second, err := LockFile(fs, path, 5*time.Second)
+			require.NoError(t, err)
+			<-released
+			require.NoError(t, second.Unlock())
+
+			// A lock on another file system or path is independent
+			other, err := LockFile(afero.NewMemMapFs(), path, 0)
+			require.NoError(t, err)
+			require.NoError(t, other.Unlock())
+		})
+	}
+}
+
+func TestLockFileNamesHolder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth_id")
+	lock, err := LockFile(afero.NewOsFs(), path, 0)
+	require.NoError(t, err)
+	defer lock.Unlock()
+
+	_, err = LockFile(afero.NewOsFs(), path, 0)
+	require.Error(t, err)
+	// Windows does not allow reading the locked byte that holds the pid
+	if runtime.GOOS != "windows" {
+		require.ErrorContains(t, err, "(process "+strconv.Itoa(os.Getpid())+")")
+	}
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+// openNoFollow opens the file at path for reading. Files of the os are
+// opened with O_NOFOLLOW, and opening a symbolic link fails. Opening a FIFO
+// doesn't block.
+func openNoFollow(fsys afero.Fs, path string) (afero.File, error) {
+	var file afero.File
+	var err error
+	if _, ok := fsys.(*afero.OsFs); ok {
+		file, err = os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	} else {
+		file, err = fsys.Open(path)
+	}
+	// FreeBSD returns EMLINK instead of ELOOP
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.EMLINK) {
+		return nil, &PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	return file, nil
+}
+
+// openForChange opens the file or directory at path to change its
+// permissions. Neither path nor its directory may be a symbolic link, so
+// the owner of the directory can't point path at another file, and a file
+// with other hard links is refused.
+func openForChange(path string) (*os.File, error) {
+	dir, err := os.OpenFile(filepath.Dir(path), os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_DIRECTORY, 0)
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.EMLINK) || errors.Is(err, syscall.ENOTDIR) {
+		return nil, &PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", filepath.Dir(path))}
+	} else if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	fd, err := unix.Openat(int(dir.Fd()), filepath.Base(path), unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.EMLINK) {
+		return nil, &PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)}
+	} else if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() && !fi.IsDir() {
+		f.Close()
+		return nil, &PermsError{Err: fmt.Errorf("%s is not a regular file or a directory", path)}
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && st.Nlink > 1 {
+		f.Close()
+		return nil, &PermsError{Err: fmt.Errorf("%s has %d hard links, refusing to change it", path, st.Nlink)}
+	}
+	return f, nil
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenForChange(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	require.NoError(t, os.WriteFile(target, []byte("secret"), 0o600))
+	home := filepath.Join(dir, "home")
+	require.NoError(t, os.Mkdir(home, 0o700))
+	policyPath := filepath.Join(home, "auth_id")
+	require.NoError(t, os.WriteFile(policyPath, nil, 0o666))
+
+	fsys := NewFileSystem(afero.NewOsFs())
+	require.NoError(t, fsys.ChmodNoFollow(policyPath, 0o600))
+	fi, err := os.Stat(policyPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	require.NoError(t, fsys.ChmodNoFollow(home, 0o750))
+
+	// A link at the path, or at its directory, is not followed
+	require.NoError(t, os.Remove(policyPath))
+	require.NoError(t, os.Symlink(target, policyPath))
+	require.ErrorContains(t, fsys.ChmodNoFollow(policyPath, 0o666), "is a symlink")
+	linkedDir := filepath.Join(dir, "linked")
+	require.NoError(t, os.Symlink(dir, linkedDir))
+	require.ErrorContains(t, fsys.ChmodNoFollow(filepath.Join(linkedDir, "target"), 0o666), "is a symlink")
+
+	// Nor is a hard link to another file
+	require.NoError(t, os.Remove(policyPath))
+	require.NoError(t, os.Link(target, policyPath))
+	require.ErrorContains(t, fsys.ChownNoFollow(policyPath, "root", ""), "has 2 hard links")
+
+	fi, err = os.Stat(target)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+}
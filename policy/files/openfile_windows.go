@@ -0,0 +1,67 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/windows"
+)
+
+// openNoFollow opens the file at path for reading. Files of the os are
+// opened with FILE_FLAG_OPEN_REPARSE_POINT, and opening a symbolic link or
+// a junction fails.
+func openNoFollow(fsys afero.Fs, path string) (afero.File, error) {
+	if _, ok := fsys.(*afero.OsFs); !ok {
+		file, err := fsys.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+		}
+		return file, nil
+	}
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(name, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil,
+		windows.OPEN_EXISTING, windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", &os.PathError{Op: "open", Path: path, Err: err})
+	}
+	var info windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &info); err != nil {
+		windows.CloseHandle(h)
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	if info.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0 {
+		windows.CloseHandle(h)
+		return nil, &PermsError{Err: fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+// openForChange is never called on Windows, where permissions are changed
+// by path once the path is checked not to be a reparse point
+func openForChange(path string) (*os.File, error) {
+	return nil, fmt.Errorf("changing %s through a handle is not supported on Windows", path)
+}
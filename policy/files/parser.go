@@ -0,0 +1,273 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxTokenLen is how much of the offending text a ParseError quotes
+const maxTokenLen = 40
+
+// ParseError is a problem on a line of a table file such as auth_id or
+// providers. Line and Column are 1-based, Column counts characters.
+type ParseError struct {
+	Line       int
+	Column     int
+	Token      string
+	Message    string
+	Suggestion string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d, %s", e.Line, e.Detail())
+}
+
+// Detail describes the error without the line, for reports that already
+// show it
+func (e *ParseError) Detail() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "column %d: %s", e.Column, e.Message)
+	if e.Token != "" {
+		fmt.Fprintf(&sb, " at %q", e.Token)
+	}
+	if e.Suggestion != "" {
+		sb.WriteString("; " + e.Suggestion)
+	}
+	return sb.String()
+}
+
+// Row is a line of a table file split into columns
+type Row struct {
+	Line    int
+	Columns []string
+	// Offsets is the column of the line each of Columns starts at
+	Offsets []int
+	// Content is the line as it appears in the file
+	Content string
+	// Err is set if the line can't be parsed, Columns is then empty
+	Err *ParseError
+}
+
+// CheckColumns returns an error unless row has one column for each of names
+func (r Row) CheckColumns(names ...string) *ParseError {
+	if len(r.Columns) == len(names) {
+		return nil
+	}
+	pe := &ParseError{
+		Line:       r.Line,
+		Message:    fmt.Sprintf("wrong number of arguments (expected=%d, got=%d)", len(names), len(r.Columns)),
+		Suggestion: "expected " + strings.Join(names, " "),
+	}
+	if len(r.Columns) > len(names) {
+		pe.Column = r.Offsets[len(names)]
+		pe.Token = truncateToken(r.Columns[len(names)])
+		pe.Suggestion += ", quote values that contain spaces"
+	} else {
+		pe.Column = utf8.RuneCountInString(strings.TrimRight(r.Content, " \t\r")) + 1
+		pe.Suggestion = fmt.Sprintf("missing %s, %s", names[len(r.Columns)], pe.Suggestion)
+	}
+	return pe
+}
+
+// ReplaceColumn returns Content with column i set to value. The rest of the
+// line, comments included, is kept if column i is written unquoted, else the
+// line is written again from its columns.
+func (r Row) ReplaceColumn(i int, value string) string {
+	runes := []rune(r.Content)
+	start := r.Offsets[i] - 1
+	end := start + utf8.RuneCountInString(r.Columns[i])
+	if end <= len(runes) && string(runes[start:end]) == r.Columns[i] &&
+		(end == len(runes) || strings.ContainsRune(" \t\r", runes[end])) {
+		return string(runes[:start]) + quoteWord(value) + string(runes[end:])
+	}
+	words := make([]string, len(r.Columns))
+	for j, column := range r.Columns {
+		words[j] = quoteWord(column)
+	}
+	words[i] = quoteWord(value)
+	line := strings.Join(words, " ")
+	if strings.HasSuffix(r.Content, "\r") {
+		line += "\r"
+	}
+	return line
+}
+
+// ParseRows splits content into rows of whitespace separated columns.
+// Columns may be quoted like in a shell and # starts a comment outside of
+// quotes. Blank and comment lines are skipped. A line that can't be parsed
+// is returned with Err set and parsing goes on, so all problems are found
+// in one pass.
+func ParseRows(content []byte) []Row {
+	rows := []Row{}
+	for i, line := range strings.Split(string(stripBOM(content)), "\n") {
+		row, err := parseLine(line, i+1)
+		if err != nil {
+			row.Columns, row.Offsets, row.Err = nil, nil, err
+		}
+		if err != nil || len(row.Columns) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func stripBOM(content []byte) []byte {
+	if len(content) >= 3 && content[0] == 0xEF && content[1] == 0xBB && content[2] == 0xBF {
+		return content[3:]
+	}
+	return content
+}
+
+// parseLine splits a single line. It follows the quoting of
+// github.com/kballard/go-shellquote, which the files were parsed with
+// before, so existing files keep their meaning.
+func parseLine(line string, lineNum int) (Row, *ParseError) {
+	row := Row{Line: lineNum, Content: line}
+	line = strings.TrimSuffix(line, "\r")
+
+	if !utf8.ValidString(line) {
+		col := 1
+		for i, r := range line {
+			if r == utf8.RuneError {
+				if _, size := utf8.DecodeRuneInString(line[i:]); size == 1 {
+					break
+				}
+			}
+			col++
+		}
+		return row, &ParseError{Line: lineNum, Column: col, Message: "invalid UTF-8", Suggestion: "save the file as UTF-8"}
+	}
+
+	var (
+		word      strings.Builder
+		inWord    bool
+		wordStart int
+		quote     rune // the open quote, 0 outside of quotes
+		quoteCol  int
+		escaped   bool
+	)
+	endWord := func() {
+		if inWord {
+			row.Columns = append(row.Columns, word.String())
+			row.Offsets = append(row.Offsets, wordStart)
+		}
+		word.Reset()
+		inWord = false
+	}
+	startWord := func(col int) {
+		if !inWord {
+			inWord = true
+			wordStart = col
+		}
+	}
+	partial := func(col int) string {
+		// The rest of the line from col, as written in the file
+		runes := []rune(line)
+		return truncateToken(string(runes[col-1:]))
+	}
+
+	col := 0
+	for _, r := range line {
+		col++
+		if unicode.IsControl(r) && r != '\t' {
+			return row, &ParseError{
+				Line:       lineNum,
+				Column:     col,
+				Token:      fmt.Sprintf("%U", r),
+				Message:    "unexpected control character",
+				Suggestion: "remove the character or check the file encoding",
+			}
+		}
+
+		switch {
+		case escaped:
+			// Inside double quotes only a few characters can be escaped,
+			// the backslash is kept before any other
+			if quote == '"' && !strings.ContainsRune("$`\"\\", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			startWord(col)
+			escaped = true
+		case r == '\'' || r == '"':
+			startWord(col)
+			quote = r
+			quoteCol = col
+		case r == '#':
+			endWord()
+			return row, nil
+		case r == ' ' || r == '\t':
+			endWord()
+		default:
+			startWord(col)
+			word.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		kind := "single"
+		if quote == '"' {
+			kind = "double"
+		}
+		return row, &ParseError{
+			Line:       lineNum,
+			Column:     quoteCol,
+			Token:      partial(quoteCol),
+			Message:    fmt.Sprintf("unterminated %s-quoted string", kind),
+			Suggestion: fmt.Sprintf("add the closing %c", quote),
+		}
+	}
+	if escaped {
+		return row, &ParseError{
+			Line:       lineNum,
+			Column:     col,
+			Token:      `\`,
+			Message:    "unfinished escape at end of line",
+			Suggestion: `remove the trailing \ or write it as \\`,
+		}
+	}
+	endWord()
+	return row, nil
+}
+
+func truncateToken(s string) string {
+	if utf8.RuneCountInString(s) <= maxTokenLen {
+		return s
+	}
+	return string([]rune(s)[:maxTokenLen]) + "..."
+}
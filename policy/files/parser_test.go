@@ -0,0 +1,185 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		columns [][]string
+		offsets [][]int
+	}{
+		{
+			name:    "quotes and escapes",
+			input:   `alice "a b" 'c d' e\ f "g\"h" "i\j"`,
+			columns: [][]string{{"alice", "a b", "c d", "e f", `g"h`, `i\j`}},
+			offsets: [][]int{{1, 7, 13, 19, 24, 31}},
+		},
+		{
+			name:    "comment inside quotes",
+			input:   "root 'oidc:groups:a#b' https://example.com # comment",
+			columns: [][]string{{"root", "oidc:groups:a#b", "https://example.com"}},
+			offsets: [][]int{{1, 6, 24}},
+		},
+		{
+			name:    "tabs, CRLF and empty column",
+			input:   "root\talice@example.com\t''\r\n\r\n# only a comment\r\n",
+			columns: [][]string{{"root", "alice@example.com", ""}},
+			offsets: [][]int{{1, 6, 24}},
+		},
+		{
+			name:    "characters are counted, not bytes",
+			input:   "héllo wörld",
+			columns: [][]string{{"héllo", "wörld"}},
+			offsets: [][]int{{1, 7}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows := ParseRows([]byte(tt.input))
+			require.Len(t, rows, len(tt.columns))
+			for i, row := range rows {
+				require.Nil(t, row.Err)
+				require.Equal(t, tt.columns[i], row.Columns)
+				require.Equal(t, tt.offsets[i], row.Offsets)
+			}
+		})
+	}
+}
+
+func TestParseRowsErrors(t *testing.T) {
+	input := "root alice@example.com https://accounts.google.com\n" +
+		"root 'alice@example.com https://accounts.google.com\n" +
+		"root alice@example.com \"https://accounts.google.com\n" +
+		"root alice@example.com https://accounts.google.com\\\n" +
+		"root alice\x00@example.com https://accounts.google.com\n" +
+		"root \xffalice@example.com https://accounts.google.com\n" +
+		"bob bob@example.com https://accounts.google.com\n"
+	rows := ParseRows([]byte(input))
+	require.Len(t, rows, 7)
+
+	// Parsing continues after each error
+	require.Nil(t, rows[0].Err)
+	require.Nil(t, rows[6].Err)
+	require.Equal(t, []string{"bob", "bob@example.com", "https://accounts.google.com"}, rows[6].Columns)
+
+	want := []ParseError{
+		{Line: 2, Column: 6, Token: "'alice@example.com https://accounts.goog...", Message: "unterminated single-quoted string", Suggestion: "add the closing '"},
+		{Line: 3, Column: 24, Token: `"https://accounts.google.com`, Message: "unterminated double-quoted string", Suggestion: `add the closing "`},
+		{Line: 4, Column: 51, Token: `\`, Message: "unfinished escape at end of line", Suggestion: `remove the trailing \ or write it as \\`},
+		{Line: 5, Column: 11, Token: "U+0000", Message: "unexpected control character", Suggestion: "remove the character or check the file encoding"},
+		{Line: 6, Column: 6, Message: "invalid UTF-8", Suggestion: "save the file as UTF-8"},
+	}
+	for i, w := range want {
+		require.NotNil(t, rows[i+1].Err, w.Message)
+		require.Equal(t, w, *rows[i+1].Err)
+		require.Empty(t, rows[i+1].Columns)
+	}
+	require.Equal(t, `line 3, column 24: unterminated double-quoted string at "\"https://accounts.google.com"; add the closing "`, rows[2].Err.Error())
+}
+
+func TestCheckColumns(t *testing.T) {
+	rows := ParseRows([]byte("root alice@example.com\nroot alice smith https://accounts.google.com \nroot alice https://accounts.google.com"))
+	require.Len(t, rows, 3)
+
+	err := rows[0].CheckColumns("principal", "identity", "issuer")
+	require.Equal(t, &ParseError{
+		Line:       1,
+		Column:     23,
+		Message:    "wrong number of arguments (expected=3, got=2)",
+		Suggestion: "missing issuer, expected principal identity issuer",
+	}, err)
+
+	err = rows[1].CheckColumns("principal", "identity", "issuer")
+	require.Equal(t, &ParseError{
+		Line:       2,
+		Column:     18,
+		Token:      "https://accounts.google.com",
+		Message:    "wrong number of arguments (expected=3, got=4)",
+		Suggestion: "expected principal identity issuer, quote values that contain spaces",
+	}, err)
+
+	require.Nil(t, rows[2].CheckColumns("principal", "identity", "issuer"))
+}
+
+func TestParseRowsMatchesShellquote(t *testing.T) {
+	// Files that parsed before must keep their meaning
+	for _, line := range []string{
+		`a b c`,
+		`"a b" 'c d' e\ f`,
+		`"a\$b" "a\b" 'a\b' a\b`,
+		`1 'oidc:claims["https://example.com/my-custom-groups"].contains("group with space and :")' 3`,
+		`a"b"c 'd'"e" ""`,
+	} {
+		want, err := shellquote.Split(line)
+		require.NoError(t, err)
+		rows := ParseRows([]byte(line))
+		require.Len(t, rows, 1)
+		require.Nil(t, rows[0].Err)
+		require.Equal(t, want, rows[0].Columns, line)
+	}
+}
+
+func FuzzParseRows(f *testing.F) {
+	f.Add([]byte("root alice@example.com https://accounts.google.com\n"))
+	f.Add([]byte("\xEF\xBB\xBF# comment\r\nroot 'a b' \"c\\\"d\" e\\ f # x\n"))
+	f.Add([]byte("root 'oidc:groups:a#b' https://example.com\n"))
+	f.Add([]byte("root \"unterminated\nroot trailing\\\n\x00\xff"))
+	f.Fuzz(func(t *testing.T, content []byte) {
+		rows := ParseRows(content)
+		lines := strings.Count(string(content), "\n") + 1
+		table := Table{}
+		for _, row := range rows {
+			if row.Line < 1 || row.Line > lines {
+				t.Fatalf("line %d out of range", row.Line)
+			}
+			if row.Err != nil {
+				if row.Err.Line != row.Line || row.Err.Column < 1 || row.Err.Error() == "" {
+					t.Fatalf("bad error %#v", row.Err)
+				}
+				continue
+			}
+			if len(row.Columns) == 0 || len(row.Columns) != len(row.Offsets) {
+				t.Fatalf("bad row %#v", row)
+			}
+			table.AddRow(row.Columns...)
+		}
+
+		// What ToString writes is read back unchanged
+		reparsed := ParseRows(table.ToBytes())
+		if len(reparsed) != len(table.GetRows()) {
+			t.Fatalf("round trip changed the number of rows: %q", table.ToString())
+		}
+		for i, row := range reparsed {
+			if row.Err != nil {
+				t.Fatalf("round trip failed to parse %q: %v", row.Content, row.Err)
+			}
+			if !reflect.DeepEqual(table.GetRows()[i], row.Columns) {
+				t.Fatalf("round trip changed %q to %q", table.GetRows()[i], row.Columns)
+			}
+		}
+	})
+}
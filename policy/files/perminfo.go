@@ -73,6 +73,11 @@ func ExpectedACLFromPerm(pi PermInfo) ExpectedACL {
 				Principal: pi.Group, Rights: "GENERIC_READ", Type: "allow",
 			})
 		}
+		// Like the other bits of the mode, no ACE may grant every user
+		// access
+		if pi.Mode != 0 && pi.Mode&0o007 == 0 {
+			ea.ForbiddenPrincipals = []string{SIDEveryone, SIDUsers, SIDAuthenticatedUsers}
+		}
 	}
 	return ea
 }
@@ -34,17 +34,53 @@ var RequiredPerms = struct {
 	// Config is the server configuration file
 	// (e.g. /etc/opk/config.yml).
 	Config PermInfo
+	// IssuerPolicyDir is the directory of the per-issuer system policies
+	// (e.g. /etc/opk/auth_id.d).
+	IssuerPolicyDir PermInfo
 	// PluginsDir is the directory containing policy plugin definitions
 	// (e.g. /etc/opk/policy.d).
 	PluginsDir PermInfo
 	// PluginFile is an individual plugin YAML file inside the plugins
 	// directory.
 	PluginFile PermInfo
+	// StateDir is the directory of state written by opkssh, such as the
+	// policy journal and revocation list (e.g. /var/lib/opk).
+	StateDir PermInfo
+	// JWKSCacheDir is the directory where verify caches the keys of the
+	// OpenID Providers (e.g. /var/lib/opk/jwks-cache).
+	JWKSCacheDir PermInfo
+	// RateLimitDir is where verify counts the failed logins of each
+	// client (e.g. /var/lib/opk/ratelimit).
+	RateLimitDir PermInfo
+	// ReplayCacheDir is where verify records the PK Tokens used to log in
+	// (e.g. /var/lib/opk/replay).
+	ReplayCacheDir PermInfo
+	// TelemetryDir is where the commands, verify included, count their
+	// runs for the usage telemetry (e.g. /var/lib/opk/telemetry).
+	TelemetryDir PermInfo
+	// FragmentDir holds the policy fragments written by the sync and
+	// fleet commands (e.g. /var/lib/opk/policy).
+	FragmentDir PermInfo
+	// PluginCacheDir is where verify keeps the results of policy plugins
+	// with a cache_ttl (e.g. /var/lib/opk/plugin-cache).
+	PluginCacheDir PermInfo
+	// BackupDir holds the copies of the system policy made before it is
+	// changed (e.g. /var/lib/opk/backups).
+	BackupDir PermInfo
+	// PolicyDB is the database of the sqlite policy store
+	// (e.g. /etc/opk/policy.db).
+	PolicyDB PermInfo
+	// CAKey is the private key of the SSH CA of opkssh ca
+	// (e.g. /etc/opk/ca_key).
+	CAKey PermInfo
+	// CAPublicKeys lists the public keys of the SSH CA for sshd's
+	// TrustedUserCAKeys (e.g. /etc/opk/ca_keys.pub).
+	CAPublicKeys PermInfo
 }{
 	SystemPolicy: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
-		Owner:     "root",
-		Group:     "opksshuser",
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
 		MustExist: true,
 	},
 	HomePolicy: PermInfo{
@@ -55,26 +91,98 @@ var RequiredPerms = struct {
 	},
 	Providers: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
-		Owner:     "root",
-		Group:     "opksshuser",
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
 		MustExist: false,
 	},
 	Config: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
-		Owner:     "root",
-		Group:     "opksshuser",
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	IssuerPolicyDir: PermInfo{
+		Mode:      0o750,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
 		MustExist: false,
 	},
 	PluginsDir: PermInfo{
 		Mode:      0o750,
-		Owner:     "root",
-		Group:     "",
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
 		MustExist: false,
 	},
 	PluginFile: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
-		Owner:     "root",
-		Group:     "",
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
+		MustExist: false,
+	},
+	StateDir: PermInfo{
+		Mode:      0o755,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
+		MustExist: false,
+	},
+	JWKSCacheDir: PermInfo{
+		Mode:      0o700,
+		Owner:     DefaultServerAccounts.User,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	RateLimitDir: PermInfo{
+		Mode:      0o700,
+		Owner:     DefaultServerAccounts.User,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	ReplayCacheDir: PermInfo{
+		Mode:      0o700,
+		Owner:     DefaultServerAccounts.User,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	TelemetryDir: PermInfo{
+		Mode:      0o700,
+		Owner:     DefaultServerAccounts.User,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	FragmentDir: PermInfo{
+		Mode:      0o750,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	PluginCacheDir: PermInfo{
+		Mode:      0o700,
+		Owner:     DefaultServerAccounts.User,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	BackupDir: PermInfo{
+		Mode:      0o750,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
+		MustExist: false,
+	},
+	PolicyDB: PermInfo{
+		Mode:      ModeSystemPerms, // 0o640
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	CAKey: PermInfo{
+		Mode:      0o600,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
+		MustExist: false,
+	},
+	CAPublicKeys: PermInfo{
+		Mode:      0o644,
+		Owner:     DefaultServerAccounts.Owner,
+		Group:     RootGroup,
 		MustExist: false,
 	},
 }
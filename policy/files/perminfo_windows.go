@@ -34,17 +34,53 @@ var RequiredPerms = struct {
 	// Config is the server configuration file
 	// (e.g. %ProgramData%\opk\config.yml).
 	Config PermInfo
+	// IssuerPolicyDir is the directory of the per-issuer system policies
+	// (e.g. %ProgramData%\opk\auth_id.d).
+	IssuerPolicyDir PermInfo
 	// PluginsDir is the directory containing policy plugin definitions
 	// (e.g. %ProgramData%\opk\policy.d).
 	PluginsDir PermInfo
 	// PluginFile is an individual plugin YAML file inside the plugins
 	// directory.
 	PluginFile PermInfo
+	// StateDir is the directory of state written by opkssh, such as the
+	// policy journal and revocation list (e.g. /var/lib/opk).
+	StateDir PermInfo
+	// JWKSCacheDir is the directory where verify caches the keys of the
+	// OpenID Providers (e.g. %ProgramData%\opk\state\jwks-cache).
+	JWKSCacheDir PermInfo
+	// RateLimitDir is where verify counts the failed logins of each
+	// client (e.g. %ProgramData%\opk\state\ratelimit).
+	RateLimitDir PermInfo
+	// ReplayCacheDir is where verify records the PK Tokens used to log in
+	// (e.g. %ProgramData%\opk\state\replay).
+	ReplayCacheDir PermInfo
+	// TelemetryDir is where the commands, verify included, count their
+	// runs for the usage telemetry (e.g. %ProgramData%\opk\state\telemetry).
+	TelemetryDir PermInfo
+	// FragmentDir holds the policy fragments written by the sync and
+	// fleet commands (e.g. %ProgramData%\opk\state\policy).
+	FragmentDir PermInfo
+	// PluginCacheDir is where verify keeps the results of policy plugins
+	// with a cache_ttl (e.g. %ProgramData%\opk\state\plugin-cache).
+	PluginCacheDir PermInfo
+	// BackupDir holds the copies of the system policy made before it is
+	// changed (e.g. %ProgramData%\opk\state\backups).
+	BackupDir PermInfo
+	// PolicyDB is the database of the sqlite policy store
+	// (e.g. %ProgramData%\opk\policy.db).
+	PolicyDB PermInfo
+	// CAKey is the private key of the SSH CA of opkssh ca
+	// (e.g. %ProgramData%\opk\ca_key).
+	CAKey PermInfo
+	// CAPublicKeys lists the public keys of the SSH CA for sshd's
+	// TrustedUserCAKeys (e.g. %ProgramData%\opk\ca_keys.pub).
+	CAPublicKeys PermInfo
 }{
 	SystemPolicy: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
 		Owner:     "Administrators",
-		Group:     "opksshuser",
+		Group:     DefaultServerAccounts.Group,
 		MustExist: true,
 	},
 	HomePolicy: PermInfo{
@@ -56,25 +92,97 @@ var RequiredPerms = struct {
 	Providers: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
 		Owner:     "Administrators",
-		Group:     "opksshuser",
+		Group:     DefaultServerAccounts.Group,
 		MustExist: false,
 	},
 	Config: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
 		Owner:     "Administrators",
-		Group:     "opksshuser",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	IssuerPolicyDir: PermInfo{
+		Mode:      0o750,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
 		MustExist: false,
 	},
 	PluginsDir: PermInfo{
 		Mode:      0o750,
 		Owner:     "Administrators",
-		Group:     "opksshuser",
+		Group:     DefaultServerAccounts.Group,
 		MustExist: false,
 	},
 	PluginFile: PermInfo{
 		Mode:      ModeSystemPerms, // 0o640
 		Owner:     "Administrators",
-		Group:     "opksshuser",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	StateDir: PermInfo{
+		Mode:      0o755,
+		Owner:     "Administrators",
+		Group:     "",
+		MustExist: false,
+	},
+	JWKSCacheDir: PermInfo{
+		Mode:      0o770,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	RateLimitDir: PermInfo{
+		Mode:      0o770,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	ReplayCacheDir: PermInfo{
+		Mode:      0o770,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	TelemetryDir: PermInfo{
+		Mode:      0o770,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	FragmentDir: PermInfo{
+		Mode:      0o750,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	PluginCacheDir: PermInfo{
+		Mode:      0o770,
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	BackupDir: PermInfo{
+		Mode:      0o750,
+		Owner:     "Administrators",
+		Group:     "",
+		MustExist: false,
+	},
+	PolicyDB: PermInfo{
+		Mode:      ModeSystemPerms, // 0o640
+		Owner:     "Administrators",
+		Group:     DefaultServerAccounts.Group,
+		MustExist: false,
+	},
+	CAKey: PermInfo{
+		Mode:      0o600,
+		Owner:     "Administrators",
+		Group:     "",
+		MustExist: false,
+	},
+	CAPublicKeys: PermInfo{
+		Mode:      0o644,
+		Owner:     "Administrators",
+		Group:     "",
 		MustExist: false,
 	},
 }
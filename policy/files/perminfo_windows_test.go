@@ -109,3 +109,15 @@ func TestRequiredPerms_HomePolicyHasNoGroup(t *testing.T) {
 		t.Errorf("HomePolicy.Group = %q, want empty", RequiredPerms.HomePolicy.Group)
 	}
 }
+
+func TestExpectedACLFromPerm_ForbidsEveryUserUnlessOtherBits(t *testing.T) {
+	ea := ExpectedACLFromPerm(PermInfo{Mode: 0o640, Owner: "Administrators"})
+	if len(ea.ForbiddenPrincipals) != 3 || ea.ForbiddenPrincipals[0] != SIDEveryone {
+		t.Fatalf("expected Everyone, Users and Authenticated Users to be forbidden, got %v", ea.ForbiddenPrincipals)
+	}
+
+	ea = ExpectedACLFromPerm(PermInfo{Mode: 0o755, Owner: "Administrators"})
+	if len(ea.ForbiddenPrincipals) != 0 {
+		t.Fatalf("expected no forbidden principals for mode 0755, got %v", ea.ForbiddenPrincipals)
+	}
+}
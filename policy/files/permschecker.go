@@ -21,8 +21,14 @@ package files
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+
+	"github.com/spf13/afero"
 )
 
 // CheckPerm checks the file at the given path if it has the desired permissions.
@@ -36,20 +42,57 @@ func (u *PermsChecker) CheckPerm(path string, requirePerm []fs.FileMode, require
 	if err != nil {
 		return fmt.Errorf("failed to describe the file at path: %w", err)
 	}
+	return u.checkInfo(fileInfo, requirePerm, requiredOwner, requiredGroup, func() (string, string, error) {
+		return u.owner(path)
+	})
+}
+
+// ReadFile returns the content of the file at path after checking it like
+// CheckPerm. The checks are made on the opened file, so the file can't be
+// replaced between the check and the read, and a symbolic link at path is
+// not followed. The errors of the checks are PermsErrors.
+func (u *PermsChecker) ReadFile(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) ([]byte, error) {
+	file, err := openNoFollow(u.Fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, &PermsError{Err: fmt.Errorf("%s is not a regular file", path)}
+	}
+	err = u.checkInfo(fileInfo, requirePerm, requiredOwner, requiredGroup, func() (string, string, error) {
+		// Only the files of the os have their owner in the FileInfo
+		if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+			if _, ok := u.Fs.(*afero.OsFs); ok {
+				return idName(lookupUserName, stat.Uid), idName(lookupGroupName, stat.Gid), nil
+			}
+		}
+		return u.owner(path)
+	})
+	if err != nil {
+		return nil, &PermsError{Err: err}
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return content, nil
+}
+
+// checkInfo checks the mode of fileInfo and, if required, the owner and
+// group returned by owner
+func (u *PermsChecker) checkInfo(fileInfo fs.FileInfo, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string, owner func() (string, string, error)) error {
 	mode := fileInfo.Mode()
 
 	// if the requiredOwner or requiredGroup are specified then run stat and check if they match
 	if requiredOwner != "" || requiredGroup != "" {
-		statOutput, err := u.CmdRunner("stat", "-c", "%U %G", path)
+		statOwner, statGroup, err := owner()
 		if err != nil {
-			return fmt.Errorf("failed to run stat: %w", err)
-		}
-
-		statOutputSplit := strings.Split(strings.TrimSpace(string(statOutput)), " ")
-		statOwner := statOutputSplit[0]
-		statGroup := statOutputSplit[1]
-		if len(statOutputSplit) != 2 {
-			return fmt.Errorf("expected stat command to return 2 values got %d", len(statOutputSplit))
+			return err
 		}
 
 		if requiredOwner != "" {
@@ -78,3 +121,61 @@ func (u *PermsChecker) CheckPerm(path string, requirePerm []fs.FileMode, require
 
 	return nil
 }
+
+// idName returns the name lookup finds for id, or id if it has none, like
+// stat does
+func idName(lookup func(string) string, id uint32) string {
+	s := strconv.FormatUint(uint64(id), 10)
+	if name := lookup(s); name != "" {
+		return name
+	}
+	return s
+}
+
+// owner returns the owner and group of path
+func (u *PermsChecker) owner(path string) (string, string, error) {
+	statOutput, err := u.CmdRunner("stat", append(append([]string{}, statOwnerArgs...), path)...)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to run stat: %w", err)
+	}
+
+	statOutputSplit := strings.Split(strings.TrimSpace(string(statOutput)), " ")
+	if len(statOutputSplit) != 2 {
+		return "", "", fmt.Errorf("expected stat command to return 2 values got %d", len(statOutputSplit))
+	}
+	return statOutputSplit[0], statOutputSplit[1], nil
+}
+
+// CheckPathChain checks every directory above path up to the root and
+// returns a problem for each one a user other than root can write to. Such
+// a user can replace path whatever its own permissions are. A world
+// writable directory with the sticky bit, like /tmp, is accepted.
+func (u *PermsChecker) CheckPathChain(path string) ([]string, error) {
+	var problems []string
+	dir := filepath.Dir(filepath.Clean(path))
+	for {
+		fileInfo, err := u.Fs.Stat(dir)
+		if err != nil {
+			return problems, fmt.Errorf("failed to describe the directory %s: %w", dir, err)
+		}
+		mode := fileInfo.Mode()
+		owner, group, err := u.owner(dir)
+		if err != nil {
+			return problems, err
+		}
+		switch {
+		case owner != "root":
+			problems = append(problems, fmt.Sprintf("%s is owned by %s", dir, owner))
+		case mode.Perm()&0o002 != 0 && mode&fs.ModeSticky == 0:
+			problems = append(problems, fmt.Sprintf("%s is writable by all users (%o)", dir, mode.Perm()))
+		case mode.Perm()&0o020 != 0 && group != "root" && group != "wheel":
+			problems = append(problems, fmt.Sprintf("%s is writable by group %s (%o)", dir, group, mode.Perm()))
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return problems, nil
+		}
+		dir = parent
+	}
+}
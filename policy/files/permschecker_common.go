@@ -33,6 +33,10 @@ const ModeSystemPerms = fs.FileMode(0o640)
 // user home policy files `~/.opk/auth_id`.
 const ModeHomePerms = fs.FileMode(0o600)
 
+// ModeHomeDirPerms is the permission bits permissions fix --user sets on the
+// `~/.opk` directory.
+const ModeHomeDirPerms = fs.FileMode(0o700)
+
 // PermsChecker contains methods to check the ownership, group
 // and file permissions of a file on a Unix-like system (or Windows).
 type PermsChecker struct {
@@ -48,3 +52,17 @@ func ExecCmd(name string, arg ...string) ([]byte, error) {
 	cmd := exec.Command(name, arg...)
 	return cmd.CombinedOutput()
 }
+
+// PermsError is returned by PermsChecker.ReadFile when the file was opened
+// but doesn't have the required permissions or owner
+type PermsError struct {
+	Err error
+}
+
+func (e *PermsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermsError) Unwrap() error {
+	return e.Err
+}
@@ -20,8 +20,13 @@
 package files
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -175,3 +180,104 @@ func TestPermissionsChecker(t *testing.T) {
 		})
 	}
 }
+
+func TestPermissionsCheckerStatArgs(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, "/test_file", []byte("1234"), 0640))
+
+	var gotArgs []string
+	permsChecker := PermsChecker{
+		Fs: mockFs,
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			gotArgs = arg
+			return []byte("root wheel"), nil
+		},
+	}
+	require.NoError(t, permsChecker.CheckPerm("/test_file", []fs.FileMode{0640}, "root", ""))
+	require.Equal(t, append(append([]string{}, statOwnerArgs...), "/test_file"), gotArgs)
+}
+
+func TestCheckPathChain(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, mockFs.MkdirAll("/etc/opk", 0o755))
+	require.NoError(t, afero.WriteFile(mockFs, "/etc/opk/auth_id", []byte(""), 0o640))
+	owners := map[string]string{}
+	permsChecker := PermsChecker{
+		Fs: mockFs,
+		CmdRunner: func(name string, arg ...string) ([]byte, error) {
+			if owner, ok := owners[arg[len(arg)-1]]; ok {
+				return []byte(owner), nil
+			}
+			return []byte("root root"), nil
+		},
+	}
+
+	problems, err := permsChecker.CheckPathChain("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Empty(t, problems)
+
+	// Each writable directory up to the root is reported
+	owners["/etc/opk"] = "alice staff"
+	require.NoError(t, mockFs.Chmod("/etc", 0o777))
+	require.NoError(t, mockFs.Chmod("/", 0o775))
+	problems, err = permsChecker.CheckPathChain("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/etc/opk is owned by alice",
+		"/etc is writable by all users (777)",
+	}, problems)
+
+	// The group root and the sticky bit are trusted
+	owners["/etc/opk"] = "root staff"
+	require.NoError(t, mockFs.Chmod("/etc", fs.ModeSticky|0o777))
+	require.NoError(t, mockFs.Chmod("/etc/opk", 0o775))
+	problems, err = permsChecker.CheckPathChain("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/etc/opk is writable by group staff (775)"}, problems)
+
+	_, err = permsChecker.CheckPathChain("/missing/auth_id")
+	require.ErrorContains(t, err, "failed to describe the directory /missing")
+}
+
+func TestPermissionsCheckerReadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth_id")
+	require.NoError(t, os.WriteFile(path, []byte("root alice@example.com https://accounts.google.com\n"), 0o640))
+	require.NoError(t, os.Chmod(path, 0o640))
+	u := NewPermsChecker(afero.NewOsFs())
+
+	content, err := u.ReadFile(path, []fs.FileMode{0o640}, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "root alice@example.com https://accounts.google.com\n", string(content))
+
+	// The owner is read from the opened file
+	current, err := user.Current()
+	require.NoError(t, err)
+	_, err = u.ReadFile(path, []fs.FileMode{0o640}, current.Username, "")
+	require.NoError(t, err)
+	_, err = u.ReadFile(path, []fs.FileMode{0o640}, "no-such-user-opkssh", "")
+	var permsErr *PermsError
+	require.ErrorAs(t, err, &permsErr)
+	require.ErrorContains(t, err, "expected owner (no-such-user-opkssh)")
+
+	_, err = u.ReadFile(path, []fs.FileMode{0o600}, "", "")
+	require.ErrorAs(t, err, &permsErr)
+	require.ErrorContains(t, err, "expected one of the following permissions [600], got (640)")
+
+	// A symbolic link is not followed, even to a file with the right mode
+	link := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink(path, link))
+	_, err = u.ReadFile(link, []fs.FileMode{0o640}, "", "")
+	require.ErrorAs(t, err, &permsErr)
+	require.ErrorContains(t, err, "is a symlink")
+
+	// Opening a FIFO doesn't wait for a writer
+	fifo := filepath.Join(dir, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0o640))
+	_, err = u.ReadFile(fifo, []fs.FileMode{0o640}, "", "")
+	require.ErrorContains(t, err, "is not a regular file")
+
+	_, err = u.ReadFile(filepath.Join(dir, "missing"), []fs.FileMode{0o640}, "", "")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.False(t, errors.As(err, &permsErr))
+}
@@ -21,7 +21,12 @@ package files
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
+	"os"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/windows"
 )
 
 // CheckPerm checks file permissions on Windows.
@@ -34,8 +39,9 @@ import (
 // - NTFS ACLs set by the installer (Administrators full control, opksshuser read)
 // - File system level security rather than permission bits
 //
-// This function validates the file exists and is accessible, but skips
-// the strict permission bit check that makes sense on Unix but not on Windows.
+// This function validates the file exists and is accessible, and that
+// requiredOwner owns it, compared by SID. It skips the strict permission
+// bit check that makes sense on Unix but not on Windows.
 func (u *PermsChecker) CheckPerm(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) error {
 	// Verify file exists and is accessible
 	fileInfo, err := u.Fs.Stat(path)
@@ -49,15 +55,105 @@ func (u *PermsChecker) CheckPerm(path string, requirePerm []fs.FileMode, require
 	// - There's no way to make a file appear as 0640 through file attributes alone
 	// - Security is enforced through NTFS ACLs set by the installer
 
-	// We also skip owner/group checks since Windows uses different security model
-	// (SIDs instead of uid/gid)
+	// We skip group checks, the group is granted read access in the ACL
+	// instead of owning the file
 
 	_ = fileInfo      // Suppress unused variable warning
 	_ = requirePerm   // Suppress unused variable warning
-	_ = requiredOwner // Suppress unused variable warning
 	_ = requiredGroup // Suppress unused variable warning
 
-	// On Windows, if we can stat the file, we consider it acceptable
-	// The actual security is enforced by NTFS ACLs
+	// On Windows, if we can stat the file and it has the right owner, we
+	// consider it acceptable. The actual security is enforced by NTFS ACLs
+	if requiredOwner != "" {
+		return u.checkOwner(path, requiredOwner)
+	}
 	return nil
 }
+
+// checkOwner compares the owner of path with requiredOwner by SID, owner
+// names are localized. The files of Administrators may be owned by any of
+// its members, which is how Windows creates the files of an elevated
+// administrator. Only the files of the os are checked.
+func (u *PermsChecker) checkOwner(path string, requiredOwner string) error {
+	if _, ok := u.Fs.(*afero.OsFs); !ok {
+		return nil
+	}
+	report, err := NewDefaultACLVerifier(u.Fs).VerifyACL(path, ExpectedACL{})
+	if err != nil {
+		return err
+	}
+	if report.OwnerSIDStr == "" {
+		return fmt.Errorf("failed to read the owner of %s: %v", path, report.Problems)
+	}
+	return matchOwner(requiredOwner, report.Owner, report.OwnerSIDStr)
+}
+
+// matchOwner returns an error if the owner name with the SID sid isn't
+// requiredOwner, see checkOwner
+func matchOwner(requiredOwner string, name string, sid string) error {
+	principals := Principals()
+	if principals.IsOwner(requiredOwner, name, sid) {
+		return nil
+	}
+	if principals.Resolve(requiredOwner) == principals.Administrators {
+		if member, err := isLocalGroupMember(sid, principals.Administrators); err == nil && member {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected owner (%s), got (%s)", requiredOwner, name)
+}
+
+// ReadFile returns the content of the file at path after checking it like
+// CheckPerm. The owner is read from the opened file, so the file can't be
+// replaced between the check and the read, and a symbolic link or junction
+// at path is not followed. The errors of the checks are PermsErrors.
+func (u *PermsChecker) ReadFile(path string, requirePerm []fs.FileMode, requiredOwner string, requiredGroup string) ([]byte, error) {
+	file, err := openNoFollow(u.Fs, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, &PermsError{Err: fmt.Errorf("%s is not a regular file", path)}
+	}
+	if f, ok := file.(*os.File); ok && requiredOwner != "" {
+		if err := checkHandleOwner(f, requiredOwner); err != nil {
+			return nil, &PermsError{Err: err}
+		}
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return content, nil
+}
+
+// checkHandleOwner compares the owner of the opened file f with
+// requiredOwner like checkOwner
+func checkHandleOwner(f *os.File, requiredOwner string) error {
+	sd, err := windows.GetSecurityInfo(windows.Handle(f.Fd()), windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION)
+	if err != nil {
+		return fmt.Errorf("failed to read the owner of %s: %w", f.Name(), err)
+	}
+	owner, _, err := sd.Owner()
+	if err != nil || owner == nil {
+		return fmt.Errorf("failed to read the owner of %s: %v", f.Name(), err)
+	}
+	name := owner.String()
+	if account, _, _, err := owner.LookupAccount(""); err == nil {
+		name = account
+	}
+	return matchOwner(requiredOwner, name, owner.String())
+}
+
+// CheckPathChain reports the directories above path that users other than
+// root can write to. On Windows the permission bits don't show who can
+// write to a directory, so like CheckPerm it leaves this to the NTFS ACLs
+// and reports nothing.
+func (u *PermsChecker) CheckPathChain(path string) ([]string, error) {
+	return nil, nil
+}
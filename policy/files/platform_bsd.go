@@ -0,0 +1,61 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+// +build freebsd openbsd netbsd dragonfly
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// RootGroup is the primary group of root. Directories owned by root are
+// expected to have this group.
+const RootGroup = "wheel"
+
+// ElevateCommand is the command users are told to run opkssh with when it
+// needs root
+const ElevateCommand = "doas"
+
+// sfImmutable is the system immutable file flag, set with chflags schg. It has
+// the same value on all BSDs.
+const sfImmutable = 0x00020000
+
+// ImmutableSupported is true if files can be made immutable on this platform
+const ImmutableSupported = true
+
+func isImmutable(path string) (bool, error) {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return false, err
+	}
+	return uint32(st.Flags)&sfImmutable != 0, nil
+}
+
+func setImmutable(path string, immutable bool) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return err
+	}
+	flags := uint32(st.Flags)
+	if immutable {
+		flags |= sfImmutable
+	} else {
+		flags &^= sfImmutable
+	}
+	return unix.Chflags(path, int(flags))
+}
@@ -0,0 +1,43 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+// +build freebsd openbsd netbsd dragonfly
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBSDDefaults(t *testing.T) {
+	require.Equal(t, "wheel", RequiredPerms.PluginsDir.Group)
+	require.Equal(t, "wheel", RequiredPerms.StateDir.Group)
+	require.Equal(t, []string{"-f", "%Su %Sg"}, statOwnerArgs)
+}
+
+func TestImmutableFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth_id")
+	require.NoError(t, os.WriteFile(path, []byte{}, 0o640))
+
+	immutable, err := isImmutable(path)
+	require.NoError(t, err)
+	require.False(t, immutable)
+}
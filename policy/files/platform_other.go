@@ -0,0 +1,44 @@
+//go:build !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !freebsd,!openbsd,!netbsd,!dragonfly
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// RootGroup is the primary group of root. It is empty on platforms where the
+// group of directories owned by root isn't checked.
+const RootGroup = ""
+
+// ElevateCommand is the command users are told to run opkssh with when it
+// needs root
+const ElevateCommand = "sudo"
+
+// ImmutableSupported is true if files can be made immutable on this platform
+const ImmutableSupported = false
+
+func isImmutable(path string) (bool, error) {
+	return false, nil
+}
+
+func setImmutable(path string, immutable bool) error {
+	return fmt.Errorf("immutable files are not supported on %s", runtime.GOOS)
+}
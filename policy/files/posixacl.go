@@ -0,0 +1,270 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tags of the entries of a POSIX ACL, from linux/posix_acl.h
+const (
+	posixACLUserObj  = 0x01
+	posixACLUser     = 0x02
+	posixACLGroupObj = 0x04
+	posixACLGroup    = 0x08
+	posixACLMask     = 0x10
+	posixACLOther    = 0x20
+
+	posixACLVersion = 2
+	// posixACLUndefinedID is the id of the entries that aren't named
+	posixACLUndefinedID = 0xffffffff
+)
+
+// posixACLEntry is an entry of the system.posix_acl_access extended
+// attribute
+type posixACLEntry struct {
+	Tag  uint16
+	Perm uint16
+	ID   uint32
+}
+
+// parsePosixACL parses the little-endian system.posix_acl_access extended
+// attribute: a version followed by entries of a tag, permissions and id
+func parsePosixACL(data []byte) ([]posixACLEntry, error) {
+	if len(data) < 4 || (len(data)-4)%8 != 0 {
+		return nil, fmt.Errorf("invalid POSIX ACL of %d bytes", len(data))
+	}
+	if v := binary.LittleEndian.Uint32(data); v != posixACLVersion {
+		return nil, fmt.Errorf("unsupported POSIX ACL version %d", v)
+	}
+	var entries []posixACLEntry
+	for b := data[4:]; len(b) > 0; b = b[8:] {
+		entries = append(entries, posixACLEntry{
+			Tag:  binary.LittleEndian.Uint16(b),
+			Perm: binary.LittleEndian.Uint16(b[2:]),
+			ID:   binary.LittleEndian.Uint32(b[4:]),
+		})
+	}
+	return entries, nil
+}
+
+// extendedACEs returns the named user and group entries of a POSIX ACL
+// that grant access beyond the mode bits, with the permissions left by the
+// mask. lookupUser and lookupGroup name a uid or gid, "" if it is unknown.
+func extendedACEs(entries []posixACLEntry, lookupUser func(id string) string, lookupGroup func(id string) string) []ACE {
+	mask := uint16(7)
+	for _, e := range entries {
+		if e.Tag == posixACLMask {
+			mask = e.Perm
+		}
+	}
+	var aces []ACE
+	for _, e := range entries {
+		var kind, name string
+		id := strconv.FormatUint(uint64(e.ID), 10)
+		switch e.Tag {
+		case posixACLUser:
+			kind, name = "user", lookupUser(id)
+		case posixACLGroup:
+			kind, name = "group", lookupGroup(id)
+		default:
+			continue
+		}
+		perm := e.Perm & mask
+		if perm == 0 {
+			continue
+		}
+		if name == "" {
+			name = id
+		}
+		aces = append(aces, ACE{Principal: kind + ":" + name, Rights: posixACLRights(perm), Type: "allow"})
+	}
+	return aces
+}
+
+// posixACLRights formats perm like getfacl, e.g. r-x
+func posixACLRights(perm uint16) string {
+	rights := []byte("---")
+	if perm&4 != 0 {
+		rights[0] = 'r'
+	}
+	if perm&2 != 0 {
+		rights[1] = 'w'
+	}
+	if perm&1 != 0 {
+		rights[2] = 'x'
+	}
+	return string(rights)
+}
+
+// parsePosixACLRights parses rights formatted like getfacl, e.g. r-x
+func parsePosixACLRights(rights string) (uint16, error) {
+	if len(rights) != 3 {
+		return 0, fmt.Errorf("invalid POSIX ACL rights %q", rights)
+	}
+	var perm uint16
+	for i, bit := range []uint16{4, 2, 1} {
+		switch rights[i] {
+		case "rwx"[i]:
+			perm |= bit
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid POSIX ACL rights %q", rights)
+		}
+	}
+	return perm, nil
+}
+
+// isPosixACLPrincipal reports whether principal names a user or group of
+// a POSIX ACL
+func isPosixACLPrincipal(principal string) bool {
+	return strings.HasPrefix(principal, "user:") || strings.HasPrefix(principal, "group:")
+}
+
+// posixACLPrincipal returns the tag and id of an ACE principal of a POSIX
+// ACL, user: or group: followed by a name or an id
+func posixACLPrincipal(principal string) (uint16, uint32, error) {
+	kind, name, _ := strings.Cut(principal, ":")
+	var tag uint16
+	id := name
+	switch kind {
+	case "user":
+		tag = posixACLUser
+		if u, err := user.Lookup(name); err == nil {
+			id = u.Uid
+		}
+	case "group":
+		tag = posixACLGroup
+		if g, err := user.LookupGroup(name); err == nil {
+			id = g.Gid
+		}
+	default:
+		return 0, 0, fmt.Errorf("%q is not a POSIX ACL user or group", principal)
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown %s %q", kind, name)
+	}
+	return tag, uint32(n), nil
+}
+
+// setPosixACLEntry returns entries with the named entry tag id removed if
+// perm is 0, or else set to perm. Like setfacl, the mask is recalculated to
+// cover the whole group class, and dropped with the last named entry.
+func setPosixACLEntry(entries []posixACLEntry, tag uint16, id uint32, perm uint16) []posixACLEntry {
+	var result []posixACLEntry
+	for _, e := range entries {
+		if (e.Tag == tag && e.ID == id) || e.Tag == posixACLMask {
+			continue
+		}
+		result = append(result, e)
+	}
+	if perm != 0 {
+		result = append(result, posixACLEntry{Tag: tag, Perm: perm, ID: id})
+	}
+	mask := posixACLEntry{Tag: posixACLMask, ID: posixACLUndefinedID}
+	named := false
+	for _, e := range result {
+		switch e.Tag {
+		case posixACLUser, posixACLGroup:
+			named = true
+			mask.Perm |= e.Perm
+		case posixACLGroupObj:
+			mask.Perm |= e.Perm
+		}
+	}
+	if named {
+		result = append(result, mask)
+	}
+	// The kernel wants the entries sorted by tag, then id
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Tag != result[j].Tag {
+			return result[i].Tag < result[j].Tag
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// encodePosixACL encodes entries as the system.posix_acl_access extended
+// attribute
+func encodePosixACL(entries []posixACLEntry) []byte {
+	data := binary.LittleEndian.AppendUint32(nil, posixACLVersion)
+	for _, e := range entries {
+		data = binary.LittleEndian.AppendUint16(data, e.Tag)
+		data = binary.LittleEndian.AppendUint16(data, e.Perm)
+		data = binary.LittleEndian.AppendUint32(data, e.ID)
+	}
+	return data
+}
+
+// posixRequiredPerm returns the POSIX permissions of the rights of an
+// expected ACE, a generic right or rights like r-x
+func posixRequiredPerm(rights string) uint16 {
+	switch rights {
+	case "GENERIC_ALL":
+		return 7
+	case "GENERIC_READ":
+		return 4
+	case "GENERIC_WRITE":
+		return 2
+	case "GENERIC_EXECUTE":
+		return 1
+	}
+	perm, _ := parsePosixACLRights(rights)
+	return perm
+}
+
+// checkPosixExpected evaluates the required ACEs and forbidden principals
+// of expected against the owner, group and mode of r and its extended ACL
+// entries. A principal is a user or group name, optionally with user: or
+// group:, or other (also Everyone) for the other class.
+func checkPosixExpected(r *ACLReport, expected ExpectedACL) {
+	if len(expected.RequiredACEs) == 0 && len(expected.ForbiddenPrincipals) == 0 {
+		return
+	}
+	var aces []ACE
+	if r.Owner != "" {
+		aces = append(aces, ACE{Principal: "user:" + r.Owner, Rights: posixACLRights(uint16(r.Mode>>6) & 7), Type: "allow"})
+	}
+	if r.Group != "" {
+		aces = append(aces, ACE{Principal: "group:" + r.Group, Rights: posixACLRights(uint16(r.Mode>>3) & 7), Type: "allow"})
+	}
+	aces = append(aces, ACE{Principal: "other", Rights: posixACLRights(uint16(r.Mode) & 7), Type: "allow"})
+	aces = append(aces, r.ACEs...)
+
+	match := func(ace ACE, principal string) bool {
+		if ace.Rights == "---" {
+			return false
+		}
+		if ace.Principal == "other" {
+			return principal == "other" || strings.EqualFold(principal, "Everyone") || principal == SIDEveryone
+		}
+		return ace.Principal == principal || ace.Principal == "user:"+principal || ace.Principal == "group:"+principal
+	}
+	grants := func(ace ACE, req ExpectedACE) bool {
+		have, err := parsePosixACLRights(ace.Rights)
+		want := posixRequiredPerm(req.Rights)
+		return err == nil && have&want == want
+	}
+	checkExpectedACEs(r, aces, expected, match, grants)
+}
@@ -0,0 +1,85 @@
+//go:build linux
+// +build linux
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// posixACL returns the access POSIX ACL of path, nil if it has none or the
+// filesystem doesn't support them
+func posixACL(path string) ([]byte, error) {
+	buf := make([]byte, 4096)
+	n, err := unix.Getxattr(path, "system.posix_acl_access", buf)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	} else if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+	return buf[:n], nil
+}
+
+// changePosixACL sets the entry of ace in the POSIX ACL of path, like
+// setfacl -m, or removes it, like setfacl -x
+func changePosixACL(path string, ace ACE, remove bool) error {
+	tag, id, err := posixACLPrincipal(ace.Principal)
+	if err != nil {
+		return err
+	}
+	var perm uint16
+	if !remove {
+		if perm, err = parsePosixACLRights(ace.Rights); err != nil {
+			return err
+		}
+	}
+	data, err := posixACL(path)
+	if err != nil {
+		return err
+	}
+	var entries []posixACLEntry
+	if data != nil {
+		if entries, err = parsePosixACL(data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	} else if remove {
+		return nil
+	} else {
+		// Without an ACL the mode bits are the ACL
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		mode := uint16(fi.Mode().Perm())
+		entries = []posixACLEntry{
+			{Tag: posixACLUserObj, Perm: mode >> 6 & 7, ID: posixACLUndefinedID},
+			{Tag: posixACLGroupObj, Perm: mode >> 3 & 7, ID: posixACLUndefinedID},
+			{Tag: posixACLOther, Perm: mode & 7, ID: posixACLUndefinedID},
+		}
+	}
+	entries = setPosixACLEntry(entries, tag, id, perm)
+	if err := unix.Setxattr(path, "system.posix_acl_access", encodePosixACL(entries), 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+//go:build linux
+// +build linux
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestUnixACLVerifierPosixACL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth_id")
+	require.NoError(t, afero.WriteFile(afero.NewOsFs(), path, []byte("x"), 0o640))
+	v := NewDefaultACLVerifier(afero.NewOsFs())
+
+	report, err := v.VerifyACL(path, ExpectedACL{Mode: 0o640})
+	require.NoError(t, err)
+	require.Empty(t, report.Unexpected)
+	require.Empty(t, report.Problems)
+
+	// setfacl -m u:4242:r
+	acl := encodePosixACL([]posixACLEntry{
+		{Tag: posixACLUserObj, Perm: 6},
+		{Tag: posixACLUser, Perm: 4, ID: 4242},
+		{Tag: posixACLGroupObj, Perm: 4},
+		{Tag: posixACLMask, Perm: 4},
+		{Tag: posixACLOther, Perm: 0},
+	})
+	if err := unix.Setxattr(path, "system.posix_acl_access", acl, 0); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("the filesystem doesn't support POSIX ACLs")
+	} else {
+		require.NoError(t, err)
+	}
+	report, err = v.VerifyACL(path, ExpectedACL{Mode: 0o640})
+	require.NoError(t, err)
+	// uid 4242 is named by its number unless the host has such a user
+	name := lookupUserName("4242")
+	if name == "" {
+		name = "4242"
+	}
+	require.Len(t, report.Unexpected, 1)
+	ace := report.Unexpected[0]
+	require.Equal(t, ACE{Principal: "user:" + name, Rights: "r--", Type: "allow"}, ace)
+	require.Equal(t, []string{"extended ACL grants " + ace.Principal + " r--"}, report.Problems)
+}
+
+func TestChangePosixACL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth_id")
+	require.NoError(t, afero.WriteFile(afero.NewOsFs(), path, []byte("x"), 0o640))
+	ace := ACE{Principal: "user:4242", Rights: "rw-", Type: "allow"}
+	if err := changePosixACL(path, ace, false); errors.Is(err, unix.ENOTSUP) {
+		t.Skip("the filesystem doesn't support POSIX ACLs")
+	} else {
+		require.NoError(t, err)
+	}
+	v := NewDefaultACLVerifier(afero.NewOsFs())
+	report, err := v.VerifyACL(path, ExpectedACL{})
+	require.NoError(t, err)
+	require.Len(t, report.Unexpected, 1)
+	require.Equal(t, "rw-", report.Unexpected[0].Rights)
+
+	// Removing the entry leaves the mode bits
+	require.NoError(t, changePosixACL(path, ace, true))
+	report, err = v.VerifyACL(path, ExpectedACL{Mode: 0o640})
+	require.NoError(t, err)
+	require.Empty(t, report.Unexpected)
+	require.Empty(t, report.Problems)
+}
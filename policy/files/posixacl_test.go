@@ -0,0 +1,126 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtendedACEs(t *testing.T) {
+	// getfacl: user::rw- user:alice:rwx user:bob:-w- group::r-- group:1500:r-x mask::r-x other::---
+	entries, err := parsePosixACL(encodePosixACL([]posixACLEntry{
+		{Tag: posixACLUserObj, Perm: 6, ID: posixACLUndefinedID},
+		{Tag: posixACLUser, Perm: 7, ID: 1000},
+		{Tag: posixACLUser, Perm: 2, ID: 1001},
+		{Tag: posixACLGroupObj, Perm: 4, ID: posixACLUndefinedID},
+		{Tag: posixACLGroup, Perm: 5, ID: 1500},
+		{Tag: posixACLMask, Perm: 5, ID: posixACLUndefinedID},
+		{Tag: posixACLOther, Perm: 0, ID: posixACLUndefinedID},
+	}))
+	require.NoError(t, err)
+	require.Len(t, entries, 7)
+
+	names := map[string]string{"1000": "alice"}
+	lookup := func(id string) string { return names[id] }
+	// The mask takes write from alice and everything from bob
+	require.Equal(t, []ACE{
+		{Principal: "user:alice", Rights: "r-x", Type: "allow"},
+		{Principal: "group:1500", Rights: "r-x", Type: "allow"},
+	}, extendedACEs(entries, lookup, lookup))
+
+	// An ACL that only mirrors the mode bits grants nothing more
+	entries, err = parsePosixACL(encodePosixACL([]posixACLEntry{
+		{Tag: posixACLUserObj, Perm: 6},
+		{Tag: posixACLGroupObj, Perm: 4},
+		{Tag: posixACLOther, Perm: 0},
+	}))
+	require.NoError(t, err)
+	require.Empty(t, extendedACEs(entries, lookup, lookup))
+
+	_, err = parsePosixACL([]byte{2, 0, 0, 0, 1})
+	require.ErrorContains(t, err, "invalid POSIX ACL of 5 bytes")
+	_, err = parsePosixACL([]byte{1, 0, 0, 0})
+	require.ErrorContains(t, err, "unsupported POSIX ACL version 1")
+}
+
+func TestSetPosixACLEntry(t *testing.T) {
+	base := []posixACLEntry{
+		{Tag: posixACLUserObj, Perm: 6, ID: posixACLUndefinedID},
+		{Tag: posixACLGroupObj, Perm: 4, ID: posixACLUndefinedID},
+		{Tag: posixACLOther, Perm: 0, ID: posixACLUndefinedID},
+	}
+
+	// setfacl -m u:1000:rw adds a mask covering the group class
+	entries := setPosixACLEntry(base, posixACLUser, 1000, 6)
+	require.Equal(t, []posixACLEntry{
+		{Tag: posixACLUserObj, Perm: 6, ID: posixACLUndefinedID},
+		{Tag: posixACLUser, Perm: 6, ID: 1000},
+		{Tag: posixACLGroupObj, Perm: 4, ID: posixACLUndefinedID},
+		{Tag: posixACLMask, Perm: 6, ID: posixACLUndefinedID},
+		{Tag: posixACLOther, Perm: 0, ID: posixACLUndefinedID},
+	}, entries)
+
+	// setfacl -m g:1500:x widens the mask
+	entries = setPosixACLEntry(entries, posixACLGroup, 1500, 1)
+	require.Equal(t, posixACLEntry{Tag: posixACLGroup, Perm: 1, ID: 1500}, entries[3])
+	require.Equal(t, posixACLEntry{Tag: posixACLMask, Perm: 7, ID: posixACLUndefinedID}, entries[4])
+
+	// setfacl -x u:1000 narrows it again
+	entries = setPosixACLEntry(entries, posixACLUser, 1000, 0)
+	unknown := func(string) string { return "" }
+	require.Equal(t, []ACE{{Principal: "group:1500", Rights: "--x", Type: "allow"}}, extendedACEs(entries, unknown, unknown))
+	require.Equal(t, posixACLEntry{Tag: posixACLMask, Perm: 5, ID: posixACLUndefinedID}, entries[3])
+
+	// The mask goes with the last named entry
+	require.Equal(t, base, setPosixACLEntry(entries, posixACLGroup, 1500, 0))
+
+	perm, err := parsePosixACLRights("r-x")
+	require.NoError(t, err)
+	require.Equal(t, uint16(5), perm)
+	_, err = parsePosixACLRights("rx-")
+	require.ErrorContains(t, err, `invalid POSIX ACL rights "rx-"`)
+
+	tag, id, err := posixACLPrincipal("group:1500")
+	require.NoError(t, err)
+	require.Equal(t, uint16(posixACLGroup), tag)
+	require.Equal(t, uint32(1500), id)
+	_, _, err = posixACLPrincipal("opksshuser")
+	require.ErrorContains(t, err, `"opksshuser" is not a POSIX ACL user or group`)
+	_, _, err = posixACLPrincipal("user:no-such-user-opkssh")
+	require.ErrorContains(t, err, `unknown user "no-such-user-opkssh"`)
+}
+
+func TestCheckPosixExpected(t *testing.T) {
+	alice := ACE{Principal: "user:alice", Rights: "r--", Type: "allow"}
+	r := ACLReport{Exists: true, Owner: "root", Group: "opksshuser", Mode: 0o604, ACEs: []ACE{alice}}
+	checkPosixExpected(&r, ExpectedACL{
+		RequiredACEs: []ExpectedACE{
+			{Principal: "root", Rights: "GENERIC_READ", Type: "allow"},
+			{Principal: "opksshuser", Rights: "GENERIC_READ", Type: "allow"},
+			{Principal: "alice", Rights: "r--", Type: "allow"},
+		},
+		ForbiddenPrincipals: []string{"Everyone", "user:alice", "bob"},
+	})
+	require.Equal(t, []string{
+		"missing ACE: allow opksshuser read",
+		"forbidden principal other has access (r--)",
+		"forbidden principal user:alice has access (r--)",
+	}, r.Problems)
+	require.Equal(t, []ACE{alice}, r.Unexpected)
+}
@@ -0,0 +1,110 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"strings"
+	"sync"
+)
+
+// SecurityPrincipal is an account that owns or is granted access to the
+// opkssh files. On Windows names are localized, the SID identifies it.
+type SecurityPrincipal struct {
+	Name string
+	// SID is the textual SID (S-1-5-...), empty when it is not known
+	SID string
+}
+
+// Matches reports if the account with name and sid is p. SIDs are
+// compared when both are known, names otherwise.
+func (p SecurityPrincipal) Matches(name string, sid string) bool {
+	return samePrincipal(ACE{Principal: p.Name, PrincipalSIDStr: p.SID}, ACE{Principal: name, PrincipalSIDStr: sid})
+}
+
+// ACE returns an allow ACE granting rights to p
+func (p SecurityPrincipal) ACE(rights string) ACE {
+	return ACE{Principal: p.Name, PrincipalSIDStr: p.SID, Rights: rights, Type: "allow"}
+}
+
+// SecurityPrincipals are the canonical administrator and service accounts
+// that the POSIX names root and opksshuser stand for on Windows
+type SecurityPrincipals struct {
+	// Administrators owns the system files, root stands for it
+	Administrators SecurityPrincipal
+	// System is the account Windows services run as
+	System SecurityPrincipal
+	// Service is the account opkssh verify runs as
+	Service SecurityPrincipal
+}
+
+// DefaultSecurityPrincipals are the principals before they are resolved:
+// the English names, and no SID for opksshuser, which is a local account
+var DefaultSecurityPrincipals = SecurityPrincipals{
+	Administrators: SecurityPrincipal{Name: "Administrators", SID: SIDAdministrators},
+	System:         SecurityPrincipal{Name: "SYSTEM", SID: SIDSystem},
+	Service:        SecurityPrincipal{Name: DefaultServerAccounts.User},
+}
+
+var principals = sync.OnceValue(resolveSecurityPrincipals)
+
+// Principals returns the SecurityPrincipals of this host. They are resolved
+// the first time they are needed.
+func Principals() SecurityPrincipals {
+	return principals()
+}
+
+// Resolve returns the principal that name stands for. name is a POSIX name
+// (root, opksshuser), the English or localized name of an account, or a
+// textual SID. Other accounts are returned by name only, or by SID.
+func (s SecurityPrincipals) Resolve(name string) SecurityPrincipal {
+	if name == "root" {
+		return s.Administrators
+	}
+	// The code uses the English names whatever the language of the host
+	d := DefaultSecurityPrincipals
+	for _, p := range [][2]SecurityPrincipal{
+		{s.Administrators, d.Administrators},
+		{s.System, d.System},
+		{s.Service, d.Service},
+	} {
+		resolved, english := p[0], p[1]
+		if resolved.SID != "" && strings.EqualFold(name, resolved.SID) || resolved.Matches(name, "") || english.Matches(name, "") {
+			return resolved
+		}
+	}
+	if strings.HasPrefix(strings.ToUpper(name), "S-1-") {
+		return SecurityPrincipal{Name: name, SID: name}
+	}
+	return SecurityPrincipal{Name: name}
+}
+
+// IsAdministrator reports if the account with name and sid administers the
+// host, Administrators or SYSTEM
+func (s SecurityPrincipals) IsAdministrator(name string, sid string) bool {
+	return s.Administrators.Matches(name, sid) || s.System.Matches(name, sid)
+}
+
+// IsOwner reports if the account with name and sid may own a file that
+// owner should own. The files of Administrators may also be owned by
+// SYSTEM, which the installer may run as.
+func (s SecurityPrincipals) IsOwner(owner string, name string, sid string) bool {
+	p := s.Resolve(owner)
+	if p == s.Administrators {
+		return s.IsAdministrator(name, sid)
+	}
+	return p.Matches(name, sid)
+}
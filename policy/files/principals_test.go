@@ -0,0 +1,68 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// germanPrincipals are the principals of a German Windows host
+var germanPrincipals = SecurityPrincipals{
+	Administrators: SecurityPrincipal{Name: "Administratoren", SID: SIDAdministrators},
+	System:         SecurityPrincipal{Name: "SYSTEM", SID: SIDSystem},
+	Service:        SecurityPrincipal{Name: "opksshuser", SID: "S-1-5-21-1-2-3-1001"},
+}
+
+func TestSecurityPrincipalsResolve(t *testing.T) {
+	s := germanPrincipals
+	require.Equal(t, s.Administrators, s.Resolve("root"))
+	require.Equal(t, s.Administrators, s.Resolve("Administrators"))
+	require.Equal(t, s.Administrators, s.Resolve(`BUILTIN\Administratoren`))
+	require.Equal(t, s.Administrators, s.Resolve("S-1-5-32-544"))
+	require.Equal(t, s.Service, s.Resolve("opksshuser"))
+	require.Equal(t, s.Service, s.Resolve("S-1-5-21-1-2-3-1001"))
+	require.Equal(t, SecurityPrincipal{Name: "alice"}, s.Resolve("alice"))
+}
+
+func TestSecurityPrincipalsIsOwner(t *testing.T) {
+	s := germanPrincipals
+	require.True(t, s.IsOwner("root", `VORDEFINIERT\Administratoren`, SIDAdministrators))
+	require.True(t, s.IsOwner("Administrators", `NT-AUTORITÄT\SYSTEM`, SIDSystem))
+	require.False(t, s.IsOwner("Administrators", `HOST\opksshuser`, "S-1-5-21-1-2-3-1001"))
+
+	// A renamed account is still found by its SID
+	require.True(t, s.IsOwner("opksshuser", `HOST\opk-service`, "S-1-5-21-1-2-3-1001"))
+	require.False(t, s.IsOwner("opksshuser", `HOST\opksshuser`, "S-1-5-21-1-2-3-1002"))
+}
+
+func TestSecurityPrincipalsDesiredDACL(t *testing.T) {
+	require.Equal(t, []ACE{
+		{Principal: "Administratoren", PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "SYSTEM", PrincipalSIDStr: SIDSystem, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: "opksshuser", PrincipalSIDStr: "S-1-5-21-1-2-3-1001", Rights: "GENERIC_READ", Type: "allow"},
+	}, germanPrincipals.DesiredDACL(PermInfo{Mode: 0o640, Owner: "Administrators", Group: "opksshuser"}))
+
+	// The localized names reported in a DACL match
+	report := ACLReport{Exists: true, ACEs: []ACE{
+		{Principal: `VORDEFINIERT\Administratoren`, PrincipalSIDStr: SIDAdministrators, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: `NT-AUTORITÄT\SYSTEM`, PrincipalSIDStr: SIDSystem, Rights: "GENERIC_ALL", Type: "allow"},
+		{Principal: `HOST\opksshuser`, PrincipalSIDStr: "S-1-5-21-1-2-3-1001", Rights: "GENERIC_READ", Type: "allow"},
+	}}
+	require.Empty(t, DACLChanges(report, germanPrincipals.DesiredDACL(RequiredPerms.SystemPolicy)))
+}
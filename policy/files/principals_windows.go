@@ -0,0 +1,106 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// resolveSecurityPrincipals looks up the localized names of Administrators
+// and SYSTEM, and the SID of opksshuser. What can't be resolved keeps its
+// default.
+func resolveSecurityPrincipals() SecurityPrincipals {
+	s := DefaultSecurityPrincipals
+	for _, p := range []*SecurityPrincipal{&s.Administrators, &s.System} {
+		if name, err := LookupSIDName(p.SID); err == nil {
+			p.Name = name
+		}
+	}
+	if sid, _, err := ResolveAccountToSID(s.Service.Name); err == nil {
+		if sidStr, err := ConvertSidToString(sid); err == nil {
+			s.Service.SID = sidStr
+		}
+	}
+	return s
+}
+
+// LookupSIDName returns the name of the account with the textual SID sid
+func LookupSIDName(sid string) (string, error) {
+	s, err := windows.StringToSid(sid)
+	if err != nil {
+		return "", err
+	}
+	name, _, _, err := s.LookupAccount("")
+	return name, err
+}
+
+var procNetUserGetLocalGroups = windows.NewLazySystemDLL("netapi32.dll").NewProc("NetUserGetLocalGroups")
+
+// isLocalGroupMember reports if the account with the textual SID sid is a
+// member of the local group, directly or through a domain group
+func isLocalGroupMember(sid string, group SecurityPrincipal) (bool, error) {
+	s, err := windows.StringToSid(sid)
+	if err != nil {
+		return false, err
+	}
+	account, domain, _, err := s.LookupAccount("")
+	if err != nil {
+		return false, err
+	}
+	name := account
+	if domain != "" {
+		name = domain + `\` + account
+	}
+	pName, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return false, err
+	}
+	const lgIncludeIndirect = 1
+	const maxPreferredLength = 0xFFFFFFFF
+	var buf *byte
+	var read, total uint32
+	ret, _, _ := procNetUserGetLocalGroups.Call(
+		0,
+		uintptr(unsafe.Pointer(pName)),
+		0,
+		lgIncludeIndirect,
+		uintptr(unsafe.Pointer(&buf)),
+		maxPreferredLength,
+		uintptr(unsafe.Pointer(&read)),
+		uintptr(unsafe.Pointer(&total)),
+	)
+	if ret != 0 {
+		return false, fmt.Errorf("NetUserGetLocalGroups failed for %s: error=%d", name, ret)
+	}
+	if buf == nil {
+		return false, nil
+	}
+	defer windows.NetApiBufferFree(buf)
+	// The buffer is an array of LOCALGROUP_USERS_INFO_0, a group name each
+	for _, groupName := range unsafe.Slice((**uint16)(unsafe.Pointer(buf)), read) {
+		if group.Matches(windows.UTF16PtrToString(groupName), "") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"slices"
+	"sync"
+)
+
+// ReadCache keeps the content of files read by a FileLoader so that a long
+// running process, such as opkssh serve, does not re-read and re-check
+// unchanged files. The owner of the cache must call Reset when a file changes.
+type ReadCache struct {
+	mu      sync.Mutex
+	content map[string][]byte
+}
+
+// NewReadCache returns an empty ReadCache
+func NewReadCache() *ReadCache {
+	return &ReadCache{content: map[string][]byte{}}
+}
+
+// Get returns a copy of the content of path, if it is in the cache
+func (c *ReadCache) Get(path string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	content, ok := c.content[path]
+	return slices.Clone(content), ok
+}
+
+// Put sets the content of path
+func (c *ReadCache) Put(path string, content []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.content[path] = slices.Clone(content)
+}
+
+// Reset empties the cache
+func (c *ReadCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.content = map[string][]byte{}
+}
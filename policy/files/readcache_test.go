@@ -0,0 +1,53 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLoaderReadCache(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte("root alice@example.com https://example.com\n"), 0o640))
+	loader := FileLoader{Fs: fs, RequiredPerm: ModeSystemPerms, Cache: NewReadCache()}
+
+	content, err := loader.LoadFileAtPath("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "root alice@example.com https://example.com\n", string(content))
+	// Callers can't change the cached content
+	content[0] = 'X'
+
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/auth_id", []byte("root bob@example.com https://example.com\n"), 0o640))
+	content, err = loader.LoadFileAtPath("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "root alice@example.com https://example.com\n", string(content))
+
+	loader.Cache.Reset()
+	content, err = loader.LoadFileAtPath("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "root bob@example.com https://example.com\n", string(content))
+
+	// Files that fail the checks are not cached
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte("x"), 0o666))
+	_, err = loader.LoadFileAtPath("/etc/opk/providers")
+	require.Error(t, err)
+	_, ok := loader.Cache.Get("/etc/opk/providers")
+	require.False(t, ok)
+}
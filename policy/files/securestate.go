@@ -0,0 +1,64 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// SetSecureFileState creates path if it is missing, as a directory if dir
+// is set, and gives it the permissions pi: the mode and owner on Unix, the
+// owner and the DACL on Windows. On Windows a file gets the DesiredDACL of
+// pi and a directory stops inheriting, keeping the access it has.
+func SetSecureFileState(fsys FileSystem, path string, pi PermInfo, dir bool) error {
+	if _, err := fsys.Stat(path); os.IsNotExist(err) {
+		if dir {
+			if err := fsys.MkdirAll(path, pi.Mode); err != nil {
+				return fmt.Errorf("failed to create %s: %w", path, err)
+			}
+		} else {
+			f, err := fsys.CreateFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to create %s: %w", path, err)
+			}
+			f.Close()
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := fsys.Chmod(path, pi.Mode); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	if err := fsys.Chown(path, pi.Owner, pi.Group); err != nil {
+		return fmt.Errorf("failed to set ownership of %s: %w", path, err)
+	}
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	if dir {
+		if err := fsys.SetInheritance(path, InheritanceCopy); err != nil {
+			return fmt.Errorf("failed to disable ACL inheritance of %s: %w", path, err)
+		}
+		return nil
+	}
+	if err := fsys.SetDACL(path, DesiredDACL(pi)); err != nil {
+		return fmt.Errorf("failed to set ACL of %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package files
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSecureFileState(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	fsys := NewFileSystem(afs)
+
+	// Missing paths are created with the mode
+	require.NoError(t, SetSecureFileState(fsys, "/etc/opk/policy.d", PermInfo{Mode: 0o750}, true))
+	fi, err := afs.Stat("/etc/opk/policy.d")
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+	require.Equal(t, fs.FileMode(0o750), fi.Mode().Perm())
+
+	require.NoError(t, SetSecureFileState(fsys, "/etc/opk/auth_id", PermInfo{Mode: 0o640}, false))
+	fi, err = afs.Stat("/etc/opk/auth_id")
+	require.NoError(t, err)
+	require.False(t, fi.IsDir())
+	require.Equal(t, fs.FileMode(0o640), fi.Mode().Perm())
+
+	// An existing file keeps its content and gets the mode
+	require.NoError(t, afero.WriteFile(afs, "/etc/opk/providers", []byte("content"), 0o666))
+	require.NoError(t, SetSecureFileState(fsys, "/etc/opk/providers", PermInfo{Mode: 0o640}, false))
+	content, err := afero.ReadFile(afs, "/etc/opk/providers")
+	require.NoError(t, err)
+	require.Equal(t, "content", string(content))
+	fi, err = afs.Stat("/etc/opk/providers")
+	require.NoError(t, err)
+	require.Equal(t, fs.FileMode(0o640), fi.Mode().Perm())
+
+	require.ErrorContains(t, SetSecureFileState(fsys, "/etc/opk/auth_id", PermInfo{Mode: 0o640, Owner: "no-such-user-opkssh"}, false), "failed to set ownership of /etc/opk/auth_id")
+}
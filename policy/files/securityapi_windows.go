@@ -0,0 +1,239 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// aclEntry is an entry SetEntriesInAclW adds to a DACL
+type aclEntry struct {
+	// SID is the raw SID of the trustee, looked up by Name when nil
+	SID    []byte
+	SIDUse uint32
+	Name   string
+	Mask   uint32
+	// Mode is GRANT_ACCESS, SET_ACCESS or DENY_ACCESS
+	Mode uint32
+}
+
+// securityAPI is the Win32 security API WindowsACLFilePermsOps changes
+// files with, replaced in tests
+type securityAPI interface {
+	// SetOwner sets the owner of path to the raw SID
+	SetOwner(path string, sid []byte) error
+	// SetDACL sets the DACL of path to entries, added to its current DACL
+	// if merge is set. A protected DACL does not inherit ACEs.
+	SetDACL(path string, entries []aclEntry, merge bool, protected bool) error
+	// SetInheritance changes whether the DACL of path inherits ACEs
+	SetInheritance(path string, inheritance Inheritance) error
+}
+
+var procDeleteAce = advapi32.NewProc("DeleteAce")
+
+// UNPROTECTED_DACL_SECURITY_INFORMATION makes a DACL inherit ACEs again
+const UNPROTECTED_DACL_SECURITY_INFORMATION = 0x20000000
+
+// win32SecurityAPI calls SetEntriesInAclW and SetNamedSecurityInfoW
+type win32SecurityAPI struct{}
+
+func (win32SecurityAPI) SetOwner(path string, sid []byte) error {
+	if len(sid) == 0 {
+		return fmt.Errorf("empty owner SID for %s", path)
+	}
+	pPath, _ := syscall.UTF16PtrFromString(path)
+	ret, _, err := procSetNamedSecurityInfo.Call(
+		uintptr(unsafe.Pointer(pPath)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(OWNER_SECURITY_INFORMATION),
+		uintptr(unsafe.Pointer(&sid[0])),
+		0,
+		0,
+		0,
+	)
+	if ret != 0 {
+		return fmt.Errorf("SetNamedSecurityInfoW (owner) failed: %v (ret=%d)", err, ret)
+	}
+	return nil
+}
+
+func (win32SecurityAPI) SetDACL(path string, entries []aclEntry, merge bool, protected bool) error {
+	if len(entries) == 0 {
+		return fmt.Errorf("no ACL entries to set on %s", path)
+	}
+	pPath, _ := syscall.UTF16PtrFromString(path)
+
+	// Get existing DACL
+	var pDacl uintptr
+	if merge {
+		var pSD uintptr
+		ret, _, _ := procGetNamedSecInfo.Call(
+			uintptr(unsafe.Pointer(pPath)),
+			uintptr(SE_FILE_OBJECT),
+			uintptr(DACL_SECURITY_INFORMATION),
+			0,
+			0,
+			uintptr(unsafe.Pointer(&pDacl)),
+			0,
+			uintptr(unsafe.Pointer(&pSD)),
+		)
+		if ret != 0 {
+			return fmt.Errorf("GetNamedSecurityInfoW failed: %d", ret)
+		}
+		if pSD != 0 {
+			defer procLocalFree.Call(pSD)
+		}
+	}
+
+	eas := make([]_EXPLICIT_ACCESS, len(entries))
+	// names keeps the trustee names referenced by eas alive until the ACL
+	// is built, the SIDs are kept by entries
+	names := make([]*uint16, len(entries))
+	for i, e := range entries {
+		eas[i] = _EXPLICIT_ACCESS{
+			GrfAccessPermissions: e.Mask,
+			GrfAccessMode:        e.Mode,
+			GrfInheritance:       NO_INHERITANCE,
+		}
+		if len(e.SID) > 0 {
+			eas[i].Trustee = _TRUSTEE{
+				TrusteeForm: TRUSTEE_IS_SID,
+				TrusteeType: sidUseToTrusteeType(e.SIDUse),
+				PtstrName:   unsafe.Pointer(&entries[i].SID[0]),
+			}
+		} else {
+			names[i], _ = syscall.UTF16PtrFromString(e.Name)
+			eas[i].Trustee = _TRUSTEE{
+				TrusteeForm: TRUSTEE_IS_NAME,
+				TrusteeType: TRUSTEE_TYPE_UNKNOWN,
+				PtstrName:   unsafe.Pointer(names[i]),
+			}
+		}
+	}
+
+	var pNewAcl uintptr
+	ret, _, err := procSetEntriesInAcl.Call(
+		uintptr(len(eas)),
+		uintptr(unsafe.Pointer(&eas[0])),
+		pDacl,
+		uintptr(unsafe.Pointer(&pNewAcl)),
+	)
+	if ret != 0 {
+		return fmt.Errorf("SetEntriesInAclW failed: %v (ret=%d)", err, ret)
+	}
+	if pNewAcl == 0 {
+		return fmt.Errorf("SetEntriesInAclW returned nil ACL")
+	}
+	defer procLocalFree.Call(pNewAcl)
+
+	flags := uint32(DACL_SECURITY_INFORMATION)
+	if protected {
+		flags |= PROTECTED_DACL_SECURITY_INFORMATION
+	}
+	ret2, _, err := procSetNamedSecurityInfo.Call(
+		uintptr(unsafe.Pointer(pPath)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(flags),
+		0,
+		0,
+		uintptr(unsafe.Pointer(pNewAcl)),
+		0,
+	)
+	if ret2 != 0 {
+		return fmt.Errorf("SetNamedSecurityInfoW failed: %v (ret=%d)", err, ret2)
+	}
+	return nil
+}
+
+// SetInheritance copies the current DACL of path, drops its inherited ACEs
+// or makes them ACEs of the path itself, and sets it protected or, to
+// inherit again, unprotected
+func (win32SecurityAPI) SetInheritance(path string, inheritance Inheritance) error {
+	pPath, _ := syscall.UTF16PtrFromString(path)
+	var pDacl, pSD uintptr
+	ret, _, _ := procGetNamedSecInfo.Call(
+		uintptr(unsafe.Pointer(pPath)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(DACL_SECURITY_INFORMATION),
+		0,
+		0,
+		uintptr(unsafe.Pointer(&pDacl)),
+		0,
+		uintptr(unsafe.Pointer(&pSD)),
+	)
+	if ret != 0 {
+		return fmt.Errorf("GetNamedSecurityInfoW failed: %d", ret)
+	}
+	if pSD != 0 {
+		defer procLocalFree.Call(pSD)
+	}
+	if pDacl == 0 {
+		return fmt.Errorf("%s has a NULL DACL", path)
+	}
+
+	// The ACL header is AclRevision(1), Sbz1(1), AclSize(2), AceCount(2),
+	// Sbz2(2)
+	size := *(*uint16)(unsafe.Pointer(pDacl + 2))
+	count := *(*uint16)(unsafe.Pointer(pDacl + 4))
+	acl := make([]byte, size)
+	copy(acl, unsafe.Slice((*byte)(unsafe.Pointer(pDacl)), size))
+	pAcl := uintptr(unsafe.Pointer(&acl[0]))
+
+	// Walk backwards so that deleting an ACE doesn't move the next one
+	for i := int(count) - 1; i >= 0; i-- {
+		var pAce uintptr
+		r, _, err := procGetAce.Call(pAcl, uintptr(i), uintptr(unsafe.Pointer(&pAce)))
+		if r == 0 || pAce == 0 {
+			return fmt.Errorf("GetAce failed for index %d: %v", i, err)
+		}
+		flags := pAce - pAcl + 1
+		if acl[flags]&INHERITED_ACE == 0 {
+			continue
+		}
+		if inheritance == InheritanceCopy {
+			acl[flags] &^= INHERITED_ACE
+			continue
+		}
+		// Inherited ACEs are recomputed when inheritance is enabled
+		if r, _, err := procDeleteAce.Call(pAcl, uintptr(i)); r == 0 {
+			return fmt.Errorf("DeleteAce failed for index %d: %v", i, err)
+		}
+	}
+
+	flags := uint32(DACL_SECURITY_INFORMATION | PROTECTED_DACL_SECURITY_INFORMATION)
+	if inheritance == InheritanceEnable {
+		flags = DACL_SECURITY_INFORMATION | UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+	ret2, _, err := procSetNamedSecurityInfo.Call(
+		uintptr(unsafe.Pointer(pPath)),
+		uintptr(SE_FILE_OBJECT),
+		uintptr(flags),
+		0,
+		0,
+		pAcl,
+		0,
+	)
+	if ret2 != 0 {
+		return fmt.Errorf("SetNamedSecurityInfoW failed: %v (ret=%d)", err, ret2)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SELinux types sshd needs the opkssh files to have on systems with SELinux
+// enabled. sshd can't exec the binary or read the config otherwise, and
+// the AuthorizedKeysCommand fails without saying why.
+const (
+	SELinuxConfigType = "etc_t"
+	SELinuxStateType  = "var_lib_t"
+	SELinuxBinaryType = "bin_t"
+)
+
+// selinuxContextType returns the type of the SELinux context ctx, as in
+// system_u:object_r:etc_t:s0
+func selinuxContextType(ctx string) string {
+	fields := strings.Split(strings.TrimSpace(strings.TrimRight(ctx, "\x00")), ":")
+	if len(fields) < 3 {
+		return ""
+	}
+	return fields[2]
+}
+
+// SELinuxFileSpec returns the semanage fcontext file spec of path, which
+// for a directory also covers everything in it
+func SELinuxFileSpec(path string, dir bool) string {
+	if dir {
+		return path + "(/.*)?"
+	}
+	return path
+}
+
+// setSELinuxType makes typ the SELinux type of path, and of everything in
+// it if it is a directory. A file context rule is added when the policy
+// doesn't already give path that type, so that a relabel of the filesystem
+// keeps it, and path is then relabeled with restorecon.
+func setSELinuxType(run func(string, ...string) ([]byte, error), path string, typ string, dir bool) error {
+	out, err := run("matchpathcon", "-n", path)
+	if err != nil || selinuxContextType(string(out)) != typ {
+		spec := SELinuxFileSpec(path, dir)
+		if out, err := run("semanage", "fcontext", "-a", "-t", typ, spec); err != nil {
+			// -a fails if there already is a rule for spec
+			if _, errModify := run("semanage", "fcontext", "-m", "-t", typ, spec); errModify != nil {
+				return fmt.Errorf("semanage fcontext failed: %w: %s", err, strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	args := []string{path}
+	if dir {
+		args = []string{"-R", path}
+	}
+	if out, err := run("restorecon", args...); err != nil {
+		return fmt.Errorf("restorecon failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// selinuxEnabled reports whether SELinux is enabled, in enforcing or
+// permissive mode
+func selinuxEnabled() bool {
+	_, err := os.Stat("/sys/fs/selinux/enforce")
+	return err == nil
+}
+
+// selinuxType returns the SELinux type of path, without following a
+// symbolic link
+func selinuxType(path string) (string, error) {
+	buf := make([]byte, 1024)
+	n, err := unix.Lgetxattr(path, "security.selinux", buf)
+	if errors.Is(err, unix.ENODATA) {
+		// The kernel treats files without a label as unlabeled_t
+		return "unlabeled_t", nil
+	} else if err != nil {
+		return "", &os.PathError{Op: "lgetxattr", Path: path, Err: err}
+	}
+	return selinuxContextType(string(buf[:n])), nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSELinuxType(t *testing.T) {
+	var ran []string
+	defaultType := "etc_t"
+	existingRule := false
+	run := func(name string, arg ...string) ([]byte, error) {
+		cmd := strings.Join(append([]string{name}, arg...), " ")
+		ran = append(ran, cmd)
+		switch {
+		case name == "matchpathcon":
+			return []byte("system_u:object_r:" + defaultType + ":s0\n"), nil
+		case strings.HasPrefix(cmd, "semanage fcontext -a") && existingRule:
+			return []byte("ValueError: File context for /opt/opkssh already defined"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+
+	// The policy already labels /etc/opk, it only has to be relabeled
+	require.NoError(t, setSELinuxType(run, "/etc/opk", "etc_t", true))
+	require.Equal(t, []string{"matchpathcon -n /etc/opk", "restorecon -R /etc/opk"}, ran)
+
+	ran, defaultType = nil, "usr_t"
+	require.NoError(t, setSELinuxType(run, "/opt/opkssh", "bin_t", false))
+	require.Equal(t, []string{"matchpathcon -n /opt/opkssh", "semanage fcontext -a -t bin_t /opt/opkssh", "restorecon /opt/opkssh"}, ran)
+
+	ran, existingRule = nil, true
+	require.NoError(t, setSELinuxType(run, "/opt/opkssh", "bin_t", false))
+	require.Equal(t, []string{"matchpathcon -n /opt/opkssh", "semanage fcontext -a -t bin_t /opt/opkssh", "semanage fcontext -m -t bin_t /opt/opkssh", "restorecon /opt/opkssh"}, ran)
+
+	require.Equal(t, "etc_t", selinuxContextType("system_u:object_r:etc_t:s0\x00"))
+	require.Equal(t, "/var/lib/opkssh(/.*)?", SELinuxFileSpec("/var/lib/opkssh", true))
+}
@@ -5,6 +5,11 @@ package files
 
 import "fmt"
 
+// StringToSID stub for non-Windows platforms.
+func StringToSID(s string) ([]byte, error) {
+	return nil, fmt.Errorf("StringToSID is only supported on Windows")
+}
+
 // ConvertSidToString stub for non-Windows platforms.
 func ConvertSidToString(sid []byte) (string, error) {
 	return "", fmt.Errorf("ConvertSidToString is only supported on Windows")
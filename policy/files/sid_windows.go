@@ -55,6 +55,16 @@ func ResolveAccountToSID(name string) ([]byte, uint32, error) {
 	return sid, sidUse, nil
 }
 
+// StringToSID converts a textual SID (e.g. S-1-5-18) to a raw SID byte slice
+func StringToSID(s string) ([]byte, error) {
+	sid, err := windows.StringToSid(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SID %s: %v", s, err)
+	}
+	n := windows.GetLengthSid(sid)
+	return append([]byte{}, unsafe.Slice((*byte)(unsafe.Pointer(sid)), n)...), nil
+}
+
 // ConvertSidToString converts a raw SID byte slice into the standard textual
 // SID representation (e.g. S-1-5-32-544). Caller must handle errors.
 func ConvertSidToString(sid []byte) (string, error) {
@@ -0,0 +1,24 @@
+//go:build !windows && !darwin && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !windows,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package files
+
+// statOwnerArgs are the arguments to GNU stat(1) that print the owner and
+// group names of a file
+var statOwnerArgs = []string{"-c", "%U %G"}
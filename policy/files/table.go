@@ -17,7 +17,7 @@
 package files
 
 import (
-	"log"
+	"log/slog"
 	"strings"
 
 	"github.com/kballard/go-shellquote"
@@ -27,26 +27,16 @@ type Table struct {
 	rows [][]string
 }
 
-// NewTable creates a new Table from the given content.
+// NewTable creates a new Table from the given content. Lines that can't be
+// parsed are logged and skipped.
 func NewTable(content []byte) *Table {
-	// Strip UTF-8 BOM if present
-	if len(content) >= 3 && content[0] == 0xEF && content[1] == 0xBB && content[2] == 0xBF {
-		content = content[3:]
-	}
 	table := [][]string{}
-	rows := strings.Split(string(content), "\n")
-	for _, row := range rows {
-		row := CleanRow(row)
-		if row == "" {
-			continue
-		}
-		columns, err := shellquote.Split(row)
-
-		if err != nil {
-			log.Printf("Unable to parse: %s. (%s), skipping...\n", row, err)
+	for _, row := range ParseRows(content) {
+		if row.Err != nil {
+			slog.Warn("Unable to parse, skipping", "error", row.Err)
 			continue
 		}
-		table = append(table, columns)
+		table = append(table, row.Columns)
 	}
 	return &Table{rows: table}
 }
@@ -66,7 +56,11 @@ func (t *Table) AddRow(row ...string) {
 func (t Table) ToString() string {
 	var sb strings.Builder
 	for _, row := range t.rows {
-		sb.WriteString(shellquote.Join(row...) + "\n")
+		words := make([]string, len(row))
+		for i, word := range row {
+			words[i] = quoteWord(word)
+		}
+		sb.WriteString(strings.Join(words, " ") + "\n")
 	}
 	return sb.String()
 }
@@ -79,42 +73,57 @@ func (t Table) GetRows() [][]string {
 	return t.rows
 }
 
+// quoteWord quotes word so that it is read back as a single column.
+// shellquote does not quote #, which starts a comment in a table, or a byte
+// order mark, which is dropped at the start of a file. Principal patterns
+// are single quoted rather than escaped so they stay readable.
+func quoteWord(word string) string {
+	if strings.ContainsAny(word, "#\ufeff*?[") {
+		return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+	}
+	return shellquote.Join(word)
+}
+
 type RowDetails struct {
 	Columns []string
+	// Offsets is the column of the line each of Columns starts at
+	Offsets []int
 	Content string
 	Empty   bool
-	Error   error
+	// Error is set when the line can't be parsed
+	Error *ParseError
+	// Line is the 1-based line number
+	Line int
+}
+
+// CheckColumns returns an error unless the row has one column for each of
+// names
+func (r RowDetails) CheckColumns(names ...string) *ParseError {
+	return r.Row().CheckColumns(names...)
+}
+
+// Row returns the parsed row of a line without a syntax error
+func (r RowDetails) Row() Row {
+	return Row{Line: r.Line, Columns: r.Columns, Offsets: r.Offsets, Content: r.Content}
 }
 
 // ReadRowsWithDetails reads rows from content, returning any parsing errors.
-// Useful for auditing and finding configuration problems.
+// There is one RowDetails for every line, including blank lines and
+// comments. Useful for auditing and finding configuration problems.
 func ReadRowsWithDetails(content []byte) []RowDetails {
 	tableDetails := []RowDetails{}
-	rows := strings.Split(string(content), "\n")
-	for _, rowContent := range rows {
-		row := CleanRow(rowContent)
-		if row == "" {
-			tableDetails = append(tableDetails,
-				RowDetails{
-					Empty:   true,
-					Content: rowContent,
-				})
-			continue
-		}
-
-		columns, err := shellquote.Split(row)
+	for i, rowContent := range strings.Split(string(stripBOM(content)), "\n") {
+		row, err := parseLine(rowContent, i+1)
+		details := RowDetails{Content: rowContent, Line: i + 1}
 		if err != nil {
-			tableDetails = append(tableDetails, RowDetails{
-				Error:   err,
-				Content: rowContent,
-			})
-			log.Printf("Unable to parse: %s. (%s), skipping...\n", row, err)
-			continue
+			details.Error = err
+			slog.Warn("Unable to parse, skipping", "error", err)
+		} else if len(row.Columns) == 0 {
+			details.Empty = true
+		} else {
+			details.Columns, details.Offsets = row.Columns, row.Offsets
 		}
-		tableDetails = append(tableDetails, RowDetails{
-			Columns: columns,
-			Content: rowContent,
-		})
+		tableDetails = append(tableDetails, details)
 	}
 	return tableDetails
 }
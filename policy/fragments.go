@@ -0,0 +1,346 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// SystemDefaultFragmentDir is the default directory of policy fragments
+// generated by opkssh sync jobs. Fragments are loaded in addition to the
+// system policy.
+var SystemDefaultFragmentDir = filepath.Join(GetSystemStateBasePath(), "policy")
+
+// FragmentExt is the file extension of policy fragments
+const FragmentExt = ".auth_id"
+
+// fragmentSignaturePrefix starts the comment on the last line of a signed
+// fragment. The signature covers every byte before that line.
+const fragmentSignaturePrefix = "# opkssh-signature: "
+
+// fragmentHeaderPrefix starts the first line of a signed fragment, which
+// holds the name of the fragment and its serial so that the signature can't
+// be reused for another fragment or to roll one back
+const fragmentHeaderPrefix = "# opkssh-fragment: "
+
+var validFragmentName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// FragmentStore reads and writes generated policy fragments. A fragment has
+// the same format and permissions as the system policy, but is owned by the
+// job that generates it and is replaced as a whole on every run.
+type FragmentStore struct {
+	Fs  afero.Fs
+	Dir string
+	// Ops, if set, is used to give the AuthorizedKeysCommandUser group
+	// ownership of written fragments
+	Ops files.FilePermsOps
+	// Signer, if set, signs written fragments
+	Signer ssh.Signer
+	// TrustedKeys, if set, are the keys allowed to sign fragments. Fragments
+	// without a valid signature from one of them are not loaded.
+	TrustedKeys []ssh.PublicKey
+}
+
+// NewFragmentStore returns a FragmentStore at SystemDefaultFragmentDir on the
+// OS filesystem
+func NewFragmentStore() *FragmentStore {
+	fs := afero.NewOsFs()
+	return &FragmentStore{
+		Fs:  fs,
+		Dir: SystemDefaultFragmentDir,
+		Ops: files.NewDefaultFilePermsOps(fs),
+	}
+}
+
+// Path returns the filepath of the fragment called name
+func (s *FragmentStore) Path(name string) string {
+	return filepath.Join(s.Dir, name+FragmentExt)
+}
+
+// Write replaces the fragment called name with p. header lines are written
+// as comments at the top of the file. The fragment is written to a temporary
+// file and renamed so verify never reads a partial fragment.
+func (s *FragmentStore) Write(name string, header []string, p *Policy) error {
+	if !validFragmentName.MatchString(name) {
+		return fmt.Errorf("invalid policy fragment name %q", name)
+	}
+	table, err := p.ToTable()
+	if err != nil {
+		return err
+	}
+	var content strings.Builder
+	if s.Signer != nil {
+		// A serial of at least the time keeps increasing after a fragment
+		// is removed and written again
+		serial := uint64(time.Now().Unix())
+		if prev, err := s.installedSerial(name); err == nil && prev >= serial {
+			serial = prev + 1
+		}
+		fmt.Fprintf(&content, "%sname=%s serial=%d\n", fragmentHeaderPrefix, name, serial)
+	}
+	for _, line := range header {
+		content.WriteString("# " + line + "\n")
+	}
+	content.Write(table)
+	if s.Signer != nil {
+		sig, err := s.Signer.Sign(rand.Reader, []byte(content.String()))
+		if err != nil {
+			return fmt.Errorf("failed to sign policy fragment: %w", err)
+		}
+		content.WriteString(fragmentSignaturePrefix + base64.StdEncoding.EncodeToString(ssh.Marshal(sig)) + "\n")
+	}
+	return s.writeContent(name, []byte(content.String()))
+}
+
+// WriteSigned replaces the fragment called name with content, a fragment
+// as written by a FragmentStore with a Signer. content must be signed by one
+// of TrustedKeys for name, with a serial above that of the installed
+// fragment.
+func (s *FragmentStore) WriteSigned(name string, content []byte) error {
+	if !validFragmentName.MatchString(name) {
+		return fmt.Errorf("invalid policy fragment name %q", name)
+	}
+	if len(s.TrustedKeys) == 0 {
+		return fmt.Errorf("no trusted keys to verify policy fragment %s", name)
+	}
+	serial, err := s.verifySignature(name, content)
+	if err != nil {
+		return fmt.Errorf("policy fragment %s: %w", name, err)
+	}
+	if prev, err := s.installedSerial(name); err == nil && prev >= serial {
+		if installed, err := afero.ReadFile(s.Fs, s.Path(name)); err == nil && bytes.Equal(installed, content) {
+			return nil
+		}
+		return fmt.Errorf("policy fragment %s: serial %d is not newer than the installed serial %d", name, serial, prev)
+	}
+	return s.writeContent(name, content)
+}
+
+func (s *FragmentStore) writeContent(name string, content []byte) error {
+	if err := s.Fs.MkdirAll(s.Dir, 0750); err != nil {
+		return fmt.Errorf("failed to create policy fragment directory: %w", err)
+	}
+	path := s.Path(name)
+	tmpPath := path + ".tmp"
+	if err := afero.WriteFile(s.Fs, tmpPath, content, files.ModeSystemPerms); err != nil {
+		return fmt.Errorf("failed to write policy fragment %s: %w", path, err)
+	}
+	// WriteFile does not change the mode of an existing file
+	if err := s.Fs.Chmod(tmpPath, files.ModeSystemPerms); err != nil {
+		return fmt.Errorf("failed to set policy fragment permissions: %w", err)
+	}
+	if s.Ops != nil {
+		for _, p := range []string{s.Dir, tmpPath} {
+			if err := s.Ops.Chown(p, Defaults.Owner, Defaults.Group); err != nil {
+				return fmt.Errorf("failed to set policy fragment ownership: %w", err)
+			}
+		}
+	}
+	if err := s.Fs.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace policy fragment %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadFragment reads the fragment called name. A missing fragment is an
+// empty policy.
+func (s *FragmentStore) LoadFragment(name string) (*Policy, error) {
+	path := s.Path(name)
+	if _, err := s.Fs.Stat(path); os.IsNotExist(err) {
+		return &Policy{}, nil
+	}
+	return s.loadAtPath(path)
+}
+
+// Read returns the content of the fragment called name, after checking its
+// permissions and, if TrustedKeys is set, its signature
+func (s *FragmentStore) Read(name string) ([]byte, error) {
+	if !validFragmentName.MatchString(name) {
+		return nil, fmt.Errorf("invalid policy fragment name %q", name)
+	}
+	return s.readAtPath(s.Path(name))
+}
+
+// Remove deletes the fragment called name. Removing a missing fragment is
+// not an error.
+func (s *FragmentStore) Remove(name string) error {
+	if !validFragmentName.MatchString(name) {
+		return fmt.Errorf("invalid policy fragment name %q", name)
+	}
+	if err := s.Fs.Remove(s.Path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove policy fragment %s: %w", s.Path(name), err)
+	}
+	return nil
+}
+
+// Names returns the names of the fragments in Dir, sorted. A missing
+// directory has no fragments.
+func (s *FragmentStore) Names() ([]string, error) {
+	entries, err := afero.ReadDir(s.Fs, s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), FragmentExt); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *FragmentStore) readAtPath(path string) ([]byte, error) {
+	loader := files.FileLoader{Fs: s.Fs, RequiredPerm: files.ModeSystemPerms}
+	content, err := loader.LoadFileAtPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.TrustedKeys) > 0 {
+		name := strings.TrimSuffix(filepath.Base(path), FragmentExt)
+		if _, err := s.verifySignature(name, content); err != nil {
+			return nil, fmt.Errorf("policy fragment %s: %w", path, err)
+		}
+	}
+	return content, nil
+}
+
+// loadAtPath reads the fragment at path, checking its permissions and, if
+// TrustedKeys is set, its signature
+func (s *FragmentStore) loadAtPath(path string) (*Policy, error) {
+	content, err := s.readAtPath(path)
+	if err != nil {
+		return nil, err
+	}
+	fragment, _ := FromTable(content, path)
+	return fragment, nil
+}
+
+// verifySignature checks that the last line of content is a signature of the
+// rest of content by one of TrustedKeys, and that the signed header is for
+// the fragment called name. It returns the serial of the fragment.
+func (s *FragmentStore) verifySignature(name string, content []byte) (uint64, error) {
+	body := bytes.TrimSuffix(content, []byte("\n"))
+	i := bytes.LastIndexByte(body, '\n')
+	sigLine := string(body[i+1:])
+	encoded, ok := strings.CutPrefix(sigLine, fragmentSignaturePrefix)
+	if !ok {
+		return 0, fmt.Errorf("not signed")
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	sig := new(ssh.Signature)
+	if err := ssh.Unmarshal(sigBytes, sig); err != nil {
+		return 0, fmt.Errorf("invalid signature: %w", err)
+	}
+	signed := content[:i+1]
+	trusted := false
+	for _, key := range s.TrustedKeys {
+		if key.Verify(signed, sig) == nil {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return 0, fmt.Errorf("signature is not from a trusted key")
+	}
+	signedName, serial, err := parseFragmentHeader(signed)
+	if err != nil {
+		return 0, err
+	}
+	if signedName != name {
+		return 0, fmt.Errorf("signed for the policy fragment %s", signedName)
+	}
+	return serial, nil
+}
+
+// parseFragmentHeader returns the name and serial in the first line of a
+// signed fragment
+func parseFragmentHeader(content []byte) (string, uint64, error) {
+	line, _, _ := bytes.Cut(content, []byte("\n"))
+	fields, ok := strings.CutPrefix(string(line), fragmentHeaderPrefix)
+	if !ok {
+		return "", 0, fmt.Errorf("missing %s line", strings.TrimSpace(fragmentHeaderPrefix))
+	}
+	var name string
+	var serial uint64
+	if _, err := fmt.Sscanf(fields, "name=%s serial=%d", &name, &serial); err != nil || !validFragmentName.MatchString(name) {
+		return "", 0, fmt.Errorf("invalid %s line %q", strings.TrimSpace(fragmentHeaderPrefix), line)
+	}
+	return name, serial, nil
+}
+
+// installedSerial returns the serial of the fragment called name in Dir. If
+// TrustedKeys is set, a fragment without a trusted signature has no serial.
+func (s *FragmentStore) installedSerial(name string) (uint64, error) {
+	content, err := afero.ReadFile(s.Fs, s.Path(name))
+	if err != nil {
+		return 0, err
+	}
+	if len(s.TrustedKeys) > 0 {
+		return s.verifySignature(name, content)
+	}
+	_, serial, err := parseFragmentHeader(content)
+	return serial, err
+}
+
+// Load reads every fragment in Dir and returns them merged along with the
+// paths read. Fragments with insecure permissions or, when TrustedKeys is
+// set, without a trusted signature are skipped. A missing directory has no
+// fragments.
+func (s *FragmentStore) Load() (*Policy, []string, error) {
+	names, err := s.Names()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged := &Policy{}
+	paths := []string{}
+	for _, name := range names {
+		path := s.Path(name)
+		fragment, err := s.loadAtPath(path)
+		if err != nil {
+			files.ConfigProblems().RecordProblem(files.ConfigProblem{
+				Filepath:     path,
+				ErrorMessage: err.Error(),
+				Source:       "policy fragment",
+			})
+			continue
+		}
+		merged.Users = append(merged.Users, fragment.Users...)
+		paths = append(paths, path)
+	}
+	return merged, paths, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFragmentStore(t *testing.T) {
+	t.Parallel()
+	store := &policy.FragmentStore{Fs: afero.NewMemMapFs(), Dir: "/var/lib/opk/policy"}
+
+	merged, paths, err := store.Load()
+	require.NoError(t, err)
+	require.Empty(t, merged.Users)
+	require.Empty(t, paths)
+
+	azure := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://login.example.com"},
+	}}
+	require.NoError(t, store.Write("azuread", []string{"Generated, do not edit"}, azure))
+	require.ErrorContains(t, store.Write("../auth_id", nil, azure), "invalid policy fragment name")
+
+	content, err := afero.ReadFile(store.Fs, "/var/lib/opk/policy/azuread.auth_id")
+	require.NoError(t, err)
+	require.Equal(t, "# Generated, do not edit\ndev alice@example.com https://login.example.com\n", string(content))
+
+	loaded, err := store.LoadFragment("azuread")
+	require.NoError(t, err)
+	require.Equal(t, azure.Users, loaded.Users)
+
+	missing, err := store.LoadFragment("google")
+	require.NoError(t, err)
+	require.Empty(t, missing.Users)
+
+	// Fragments with insecure permissions are skipped
+	require.NoError(t, afero.WriteFile(store.Fs, "/var/lib/opk/policy/insecure.auth_id", []byte("root eve@example.com https://login.example.com\n"), 0666))
+	merged, paths, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, azure.Users, merged.Users)
+	require.Equal(t, []string{"/var/lib/opk/policy/azuread.auth_id"}, paths)
+
+	info, err := store.Fs.Stat(store.Path("azuread"))
+	require.NoError(t, err)
+	require.Equal(t, files.ModeSystemPerms, info.Mode().Perm())
+}
+
+func TestFragmentStoreSignatures(t *testing.T) {
+	t.Parallel()
+	mockFs := afero.NewMemMapFs()
+
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(signingKey)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherSigner, err := ssh.NewSignerFromKey(otherKey)
+	require.NoError(t, err)
+
+	google := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"},
+	}}
+	writer := &policy.FragmentStore{Fs: mockFs, Dir: "/var/lib/opk/policy", Signer: signer}
+	require.NoError(t, writer.Write("google", []string{"Generated"}, google))
+	require.NoError(t, (&policy.FragmentStore{Fs: mockFs, Dir: "/var/lib/opk/policy"}).Write("unsigned", nil, google))
+
+	reader := &policy.FragmentStore{Fs: mockFs, Dir: "/var/lib/opk/policy", TrustedKeys: []ssh.PublicKey{signer.PublicKey()}}
+	loaded, err := reader.LoadFragment("google")
+	require.NoError(t, err)
+	require.Equal(t, google.Users, loaded.Users)
+
+	_, err = reader.LoadFragment("unsigned")
+	require.ErrorContains(t, err, "not signed")
+
+	// Only the signed fragment is loaded
+	merged, paths, err := reader.Load()
+	require.NoError(t, err)
+	require.Equal(t, google.Users, merged.Users)
+	require.Equal(t, []string{"/var/lib/opk/policy/google.auth_id"}, paths)
+
+	untrusting := &policy.FragmentStore{Fs: mockFs, Dir: "/var/lib/opk/policy", TrustedKeys: []ssh.PublicKey{otherSigner.PublicKey()}}
+	_, err = untrusting.LoadFragment("google")
+	require.ErrorContains(t, err, "not from a trusted key")
+
+	// Tampering with a signed fragment invalidates the signature
+	content, err := afero.ReadFile(mockFs, "/var/lib/opk/policy/google.auth_id")
+	require.NoError(t, err)
+	tampered := strings.Replace(string(content), "dev alice", "root alice", 1)
+	require.NoError(t, afero.WriteFile(mockFs, "/var/lib/opk/policy/google.auth_id", []byte(tampered), files.ModeSystemPerms))
+	_, err = reader.LoadFragment("google")
+	require.ErrorContains(t, err, "not from a trusted key")
+}
+
+func TestFragmentStoreWriteSigned(t *testing.T) {
+	t.Parallel()
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(signingKey)
+	require.NoError(t, err)
+
+	google := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"},
+	}}
+	leader := &policy.FragmentStore{Fs: afero.NewMemMapFs(), Dir: "/var/lib/opk/policy", Signer: signer}
+	require.NoError(t, leader.Write("google", nil, google))
+	content, err := leader.Read("google")
+	require.NoError(t, err)
+
+	replica := &policy.FragmentStore{Fs: afero.NewMemMapFs(), Dir: "/var/lib/opk/policy"}
+	require.ErrorContains(t, replica.WriteSigned("google", content), "no trusted keys")
+	replica.TrustedKeys = []ssh.PublicKey{signer.PublicKey()}
+	require.NoError(t, replica.WriteSigned("google", content))
+	require.ErrorContains(t, replica.WriteSigned("../auth_id", content), "invalid policy fragment name")
+	tampered := strings.Replace(string(content), "dev alice", "root alice", 1)
+	require.ErrorContains(t, replica.WriteSigned("azuread", []byte(tampered)), "not from a trusted key")
+
+	names, err := replica.Names()
+	require.NoError(t, err)
+	require.Equal(t, []string{"google"}, names)
+	loaded, err := replica.LoadFragment("google")
+	require.NoError(t, err)
+	require.Equal(t, google.Users, loaded.Users)
+
+	require.NoError(t, replica.Remove("google"))
+	require.NoError(t, replica.Remove("google"))
+	names, err = replica.Names()
+	require.NoError(t, err)
+	require.Empty(t, names)
+}
+
+func TestFragmentStoreSignedHeader(t *testing.T) {
+	t.Parallel()
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(signingKey)
+	require.NoError(t, err)
+
+	google := &policy.Policy{Users: []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://accounts.google.com"},
+	}}
+	leader := &policy.FragmentStore{Fs: afero.NewMemMapFs(), Dir: "/var/lib/opk/policy", Signer: signer}
+	require.NoError(t, leader.Write("google", nil, google))
+	first, err := leader.Read("google")
+	require.NoError(t, err)
+	require.Regexp(t, `^# opkssh-fragment: name=google serial=\d+\n`, string(first))
+	require.NoError(t, leader.Write("google", nil, &policy.Policy{}))
+	second, err := leader.Read("google")
+	require.NoError(t, err)
+
+	replicaFs := afero.NewMemMapFs()
+	replica := &policy.FragmentStore{Fs: replicaFs, Dir: "/var/lib/opk/policy", TrustedKeys: []ssh.PublicKey{signer.PublicKey()}}
+
+	// The signature is only valid for the fragment it was written as
+	require.ErrorContains(t, replica.WriteSigned("admins", first), "signed for the policy fragment google")
+	require.NoError(t, afero.WriteFile(replicaFs, "/var/lib/opk/policy/admins.auth_id", first, files.ModeSystemPerms))
+	_, err = replica.LoadFragment("admins")
+	require.ErrorContains(t, err, "signed for the policy fragment google")
+
+	require.Less(t, fragmentSerial(t, first), fragmentSerial(t, second))
+
+	// An older fragment can't replace a newer one
+	require.NoError(t, replica.WriteSigned("google", second))
+	require.NoError(t, replica.WriteSigned("google", second))
+	require.ErrorContains(t, replica.WriteSigned("google", first), "is not newer than the installed serial")
+
+	// A fragment changed locally is restored
+	tampered := strings.Replace(string(second), "# opkssh-fragment", "dev alice@example.com https://accounts.google.com\n# opkssh-fragment", 1)
+	require.NoError(t, afero.WriteFile(replicaFs, "/var/lib/opk/policy/google.auth_id", []byte(tampered), files.ModeSystemPerms))
+	require.NoError(t, replica.WriteSigned("google", second))
+	restored, err := replica.Read("google")
+	require.NoError(t, err)
+	require.Equal(t, second, restored)
+}
+
+// fragmentSerial returns the serial in the header of a signed fragment
+func fragmentSerial(t *testing.T, content []byte) uint64 {
+	t.Helper()
+	var name string
+	var serial uint64
+	_, err := fmt.Sscanf(string(content), "# opkssh-fragment: name=%s serial=%d", &name, &serial)
+	require.NoError(t, err)
+	return serial
+}
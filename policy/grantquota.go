@@ -0,0 +1,140 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/spf13/afero"
+)
+
+// GrantQuota limits how many distinct identities a local account's home
+// policy may admit. This is a cheap control against account sharing sprawl.
+// The zero value places no limits on the home policy.
+type GrantQuota struct {
+	// WarnThreshold, if greater than zero, logs a warning when the home
+	// policy admits more than this many identities
+	WarnThreshold int
+	// DenyThreshold, if greater than zero, ignores the entire home policy when
+	// it admits more than this many identities
+	DenyThreshold int
+	// AnomalyIncrease, if greater than zero, emits an audit event when the
+	// number of identities admitted grows by at least this much since it was
+	// last seen. Requires StateDir.
+	AnomalyIncrease int
+	// StateDir is the directory where the last seen identity count of each
+	// user is recorded
+	StateDir string
+	// Fs is the filesystem used to read and write state, defaults to the OS
+	// filesystem
+	Fs afero.Fs
+}
+
+// IsEmpty returns true if no quota is configured
+func (q GrantQuota) IsEmpty() bool {
+	return q.WarnThreshold <= 0 && q.DenyThreshold <= 0 && q.AnomalyIncrease <= 0
+}
+
+// CountIdentities returns the number of distinct identities (identity
+// attribute and issuer pairs) admitted by the policy
+func CountIdentities(p *Policy) int {
+	if p == nil {
+		return 0
+	}
+	seen := map[string]bool{}
+	for _, user := range p.Users {
+		seen[user.IdentityAttribute+" "+user.Issuer] = true
+	}
+	return len(seen)
+}
+
+// Check counts the identities that the home policy of username admits and
+// returns an error if the count exceeds DenyThreshold. Warnings and anomaly
+// audit events are written to the log.
+func (q GrantQuota) Check(username string, p *Policy) error {
+	count := CountIdentities(p)
+
+	if q.AnomalyIncrease > 0 && q.StateDir != "" {
+		previous, err := q.readCount(username)
+		if err != nil {
+			slog.Warn("Failed to read identity count state", "user", username, "error", err)
+		} else if previous >= 0 && count-previous >= q.AnomalyIncrease {
+			events.Emit(events.HomePolicyIdentityAnomaly, map[string]string{
+				"user":     username,
+				"previous": strconv.Itoa(previous),
+				"current":  strconv.Itoa(count),
+			})
+		}
+		if err := q.writeCount(username, count); err != nil {
+			slog.Warn("Failed to record identity count state", "user", username, "error", err)
+		}
+	}
+
+	if q.DenyThreshold > 0 && count > q.DenyThreshold {
+		events.Emit(events.HomePolicyQuotaExceeded, map[string]string{
+			"user":  username,
+			"count": strconv.Itoa(count),
+			"limit": strconv.Itoa(q.DenyThreshold),
+		})
+		return fmt.Errorf("home policy of %s admits %d identities, more than the allowed %d", username, count, q.DenyThreshold)
+	}
+	if q.WarnThreshold > 0 && count > q.WarnThreshold {
+		slog.Warn("Home policy admits more identities than the threshold", "user", username, "count", count, "threshold", q.WarnThreshold)
+	}
+	return nil
+}
+
+func (q GrantQuota) fs() afero.Fs {
+	if q.Fs == nil {
+		return afero.NewOsFs()
+	}
+	return q.Fs
+}
+
+func (q GrantQuota) statePath(username string) string {
+	return filepath.Join(q.StateDir, username+".count")
+}
+
+// readCount returns the last recorded identity count for username or -1 if
+// none has been recorded yet
+func (q GrantQuota) readCount(username string) (int, error) {
+	afs := &afero.Afero{Fs: q.fs()}
+	path := q.statePath(username)
+	if exists, err := afs.Exists(path); err != nil {
+		return -1, err
+	} else if !exists {
+		return -1, nil
+	}
+	content, err := afs.ReadFile(path)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
+
+func (q GrantQuota) writeCount(username string, count int) error {
+	afs := &afero.Afero{Fs: q.fs()}
+	if err := afs.MkdirAll(q.StateDir, 0700); err != nil {
+		return err
+	}
+	return afs.WriteFile(q.statePath(username), []byte(strconv.Itoa(count)+"\n"), 0600)
+}
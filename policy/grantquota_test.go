@@ -0,0 +1,76 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func policyWithIdentities(n int) *policy.Policy {
+	p := &policy.Policy{}
+	for i := 0; i < n; i++ {
+		p.Users = append(p.Users, policy.User{
+			IdentityAttribute: fmt.Sprintf("user%d@example.com", i),
+			Principals:        []string{"foo"},
+			Issuer:            "https://accounts.google.com",
+		})
+	}
+	return p
+}
+
+func TestCountIdentities(t *testing.T) {
+	p := policyWithIdentities(3)
+	// Same identity granted a second principal is not a new identity
+	p.Users = append(p.Users, policy.User{IdentityAttribute: "user0@example.com", Principals: []string{"bar"}, Issuer: "https://accounts.google.com"})
+	require.Equal(t, 3, policy.CountIdentities(p))
+	require.Equal(t, 0, policy.CountIdentities(nil))
+}
+
+func TestGrantQuotaCheck(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	quota := policy.GrantQuota{
+		WarnThreshold:   2,
+		DenyThreshold:   4,
+		AnomalyIncrease: 3,
+		StateDir:        "/var/lib/opk/quota",
+		Fs:              afero.NewMemMapFs(),
+	}
+
+	require.NoError(t, quota.Check("foo", policyWithIdentities(1)))
+	require.Empty(t, logBuf.String())
+
+	require.NoError(t, quota.Check("foo", policyWithIdentities(3)))
+	require.Contains(t, logBuf.String(), "more identities than the threshold user=foo count=3 threshold=2")
+	require.NotContains(t, logBuf.String(), "home_policy_identity_anomaly")
+
+	logBuf.Reset()
+	err := quota.Check("foo", policyWithIdentities(6))
+	require.ErrorContains(t, err, "admits 6 identities, more than the allowed 4")
+	require.Contains(t, logBuf.String(), "event=home_policy_identity_anomaly current=6 previous=3 user=foo")
+	require.Contains(t, logBuf.String(), "event=home_policy_identity_quota_exceeded")
+}
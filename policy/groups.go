@@ -0,0 +1,99 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// GroupPrefix marks a principal of the policy that is a local group, such
+// as %sshadmins. The entry allows the identity to log in as any member of
+// the group.
+const GroupPrefix = "%"
+
+// GroupLookup resolves the members of local groups
+type GroupLookup interface {
+	IsMember(username string, group string) (bool, error)
+}
+
+// OsGroupLookup implements GroupLookup with getent on Unix-like systems, so
+// that groups from NSS sources such as LDAP or SSSD are resolved, and with
+// net localgroup on Windows
+type OsGroupLookup struct {
+	CmdRunner func(name string, arg ...string) ([]byte, error)
+}
+
+func NewOsGroupLookup() GroupLookup {
+	return &OsGroupLookup{CmdRunner: files.ExecCmd}
+}
+
+// IsGroupPrincipal returns the group of principal, if it is a group
+func IsGroupPrincipal(principal string) (string, bool) {
+	group, ok := strings.CutPrefix(principal, GroupPrefix)
+	return group, ok && group != ""
+}
+
+// parseGetentGroup returns the gid and the members of the group in the
+// output of getent group, e.g. sshadmins:x:1001:alice,bob
+func parseGetentGroup(out []byte) (string, []string, error) {
+	fields := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(fields) != 4 {
+		return "", nil, fmt.Errorf("unexpected getent group output %q", strings.TrimSpace(string(out)))
+	}
+	var members []string
+	for _, m := range strings.Split(fields[3], ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			members = append(members, m)
+		}
+	}
+	return fields[2], members, nil
+}
+
+// parseGetentPasswd returns the primary gid of the user in the output of
+// getent passwd, e.g. alice:x:1000:1000::/home/alice:/bin/bash
+func parseGetentPasswd(out []byte) (string, error) {
+	fields := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(fields) != 7 {
+		return "", fmt.Errorf("unexpected getent passwd output %q", strings.TrimSpace(string(out)))
+	}
+	return fields[3], nil
+}
+
+// parseNetLocalgroup returns the members in the output of net localgroup
+// <group>. They are listed between a line of dashes and the completion
+// message, which is localized, so it is recognized as the last line.
+func parseNetLocalgroup(out []byte) []string {
+	var lines []string
+	inMembers := false
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if !inMembers {
+			inMembers = line != "" && strings.Trim(line, "-") == ""
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines[:len(lines)-1]
+}
@@ -0,0 +1,67 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsGroupPrincipal(t *testing.T) {
+	group, ok := IsGroupPrincipal("%sshadmins")
+	require.True(t, ok)
+	require.Equal(t, "sshadmins", group)
+
+	for _, principal := range []string{"root", "%", "a%b"} {
+		_, ok := IsGroupPrincipal(principal)
+		require.False(t, ok, principal)
+	}
+}
+
+func TestParseGetent(t *testing.T) {
+	gid, members, err := parseGetentGroup([]byte("sshadmins:x:1001:alice,bob\n"))
+	require.NoError(t, err)
+	require.Equal(t, "1001", gid)
+	require.Equal(t, []string{"alice", "bob"}, members)
+
+	_, members, err = parseGetentGroup([]byte("empty:x:1002:\n"))
+	require.NoError(t, err)
+	require.Empty(t, members)
+
+	_, _, err = parseGetentGroup([]byte("garbage"))
+	require.Error(t, err)
+
+	primaryGid, err := parseGetentPasswd([]byte("carol:x:1003:1001::/home/carol:/bin/bash\n"))
+	require.NoError(t, err)
+	require.Equal(t, "1001", primaryGid)
+}
+
+func TestParseNetLocalgroup(t *testing.T) {
+	out := "Alias name     sshadmins\r\n" +
+		"Comment        \r\n" +
+		"\r\n" +
+		"Members\r\n" +
+		"\r\n" +
+		"-------------------------------------------------------------------------------\r\n" +
+		"alice\r\n" +
+		"CONTOSO\\bob\r\n" +
+		"The command completed successfully.\r\n" +
+		"\r\n"
+	require.Equal(t, []string{"alice", "CONTOSO\\bob"}, parseNetLocalgroup([]byte(out)))
+	require.Empty(t, parseNetLocalgroup([]byte("System error 1376 has occurred.\r\n")))
+}
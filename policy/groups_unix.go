@@ -0,0 +1,51 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"slices"
+)
+
+// IsMember returns true if username is listed as a member of group or has
+// it as its primary group
+func (l *OsGroupLookup) IsMember(username string, group string) (bool, error) {
+	out, err := l.CmdRunner("getent", "group", group)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up group %s: %w", group, err)
+	}
+	gid, members, err := parseGetentGroup(out)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(members, username) {
+		return true, nil
+	}
+
+	out, err = l.CmdRunner("getent", "passwd", username)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+	primaryGid, err := parseGetentPasswd(out)
+	if err != nil {
+		return false, err
+	}
+	return primaryGid == gid, nil
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsGroupLookup(t *testing.T) {
+	getent := map[string]string{
+		"group sshadmins": "sshadmins:x:1001:alice,bob\n",
+		"passwd alice":    "alice:x:1000:1000::/home/alice:/bin/bash\n",
+		"passwd carol":    "carol:x:1003:1001::/home/carol:/bin/bash\n",
+		"passwd dave":     "dave:x:1004:1004::/home/dave:/bin/bash\n",
+	}
+	l := &OsGroupLookup{CmdRunner: func(name string, arg ...string) ([]byte, error) {
+		require.Equal(t, "getent", name)
+		if out, ok := getent[strings.Join(arg, " ")]; ok {
+			return []byte(out), nil
+		}
+		return nil, fmt.Errorf("exit status 2")
+	}}
+
+	for username, want := range map[string]bool{"alice": true, "carol": true, "dave": false} {
+		member, err := l.IsMember(username, "sshadmins")
+		require.NoError(t, err)
+		require.Equal(t, want, member, username)
+	}
+	_, err := l.IsMember("alice", "missing")
+	require.ErrorContains(t, err, "failed to look up group missing")
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// lookupAccountSID returns the SID of the account name, such as alice,
+// DOMAIN\alice or alice@example.com
+var lookupAccountSID = func(name string) (string, error) {
+	sid, _, _, err := windows.LookupSID("", name)
+	if err != nil {
+		return "", err
+	}
+	return sid.String(), nil
+}
+
+// IsMember returns true if username is a member of the local group. The
+// members and username are compared by SID, so that a domain account and a
+// local account with the same name are told apart.
+func (l *OsGroupLookup) IsMember(username string, group string) (bool, error) {
+	out, err := l.CmdRunner("net", "localgroup", group)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up group %s: %w", group, err)
+	}
+	userSID, err := lookupAccountSID(username)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+	for _, member := range parseNetLocalgroup(out) {
+		// A member that no longer resolves, such as a deleted account,
+		// can't be username
+		if sid, err := lookupAccountSID(member); err == nil && sid == userSID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
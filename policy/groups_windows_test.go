@@ -0,0 +1,68 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsGroupLookup(t *testing.T) {
+	sids := map[string]string{
+		"alice":             "S-1-5-21-1-1001",
+		`HOST\alice`:        "S-1-5-21-1-1001",
+		`CONTOSO\alice`:     "S-1-5-21-2-1105",
+		"alice@contoso.com": "S-1-5-21-2-1105",
+		"bob":               "S-1-5-21-1-1002",
+	}
+	orig := lookupAccountSID
+	defer func() { lookupAccountSID = orig }()
+	lookupAccountSID = func(name string) (string, error) {
+		if sid, ok := sids[name]; ok {
+			return sid, nil
+		}
+		return "", fmt.Errorf("no account %s", name)
+	}
+	l := &OsGroupLookup{CmdRunner: func(name string, arg ...string) ([]byte, error) {
+		require.Equal(t, "net", name)
+		return []byte("Members\r\n" +
+			"-------------------------------------------------------------------------------\r\n" +
+			"CONTOSO\\alice\r\n" +
+			"S-1-5-21-3-500\r\n" +
+			"The command completed successfully.\r\n"), nil
+	}}
+
+	// The domain account is a member, the local account of the same name
+	// isn't
+	ok, err := l.IsMember("alice@contoso.com", "sshadmins")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = l.IsMember(`HOST\alice`, "sshadmins")
+	require.NoError(t, err)
+	require.False(t, ok)
+	ok, err = l.IsMember("bob", "sshadmins")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, err = l.IsMember("mallory", "sshadmins")
+	require.ErrorContains(t, err, "failed to look up user mallory")
+}
@@ -0,0 +1,182 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"golang.org/x/exp/slices"
+)
+
+// HomePolicyConstraints restricts what a user managed home policy
+// (~/.opk/auth_id) is allowed to grant. The zero value places no restrictions
+// on the home policy.
+type HomePolicyConstraints struct {
+	// AllowedIssuers, if not empty, is the list of issuers home policy entries
+	// may reference. Entries with any other issuer are ignored.
+	AllowedIssuers []string
+	// ForbiddenPrincipals is a list of principals that a home policy can never
+	// grant access to, e.g. root.
+	ForbiddenPrincipals []string
+	// MaxEntries, if greater than zero, is the maximum number of entries
+	// honored in a home policy. Entries past this limit are ignored.
+	MaxEntries int
+	// RequiredEmailDomains, if not empty, requires every home policy entry to
+	// be an email address (or email suffix match) in one of these domains.
+	RequiredEmailDomains []string
+}
+
+// HomePolicyAccess selects the users whose home policy is read. The zero
+// value reads the home policy of every user.
+type HomePolicyAccess struct {
+	// Disabled, if true, never reads a home policy
+	Disabled bool
+	// AllowedPrincipals and AllowedGroups, if either is set, only read the
+	// home policy of these users and of the members of these local groups
+	AllowedPrincipals []string
+	AllowedGroups     []string
+}
+
+// Check returns nil if the home policy of username is read, else why it is
+// not. groups resolves AllowedGroups, nil uses NewOsGroupLookup.
+func (a HomePolicyAccess) Check(username string, groups GroupLookup) error {
+	if a.Disabled {
+		return fmt.Errorf("home policies are disabled")
+	}
+	if len(a.AllowedPrincipals) == 0 && len(a.AllowedGroups) == 0 {
+		return nil
+	}
+	if slices.Contains(a.AllowedPrincipals, username) {
+		return nil
+	}
+	if len(a.AllowedGroups) > 0 && groups == nil {
+		groups = NewOsGroupLookup()
+	}
+	for _, group := range a.AllowedGroups {
+		// A group that can't be resolved allows no one
+		if member, err := groups.IsMember(username, group); err != nil {
+			slog.Warn("Failed to check group membership", "user", username, "group", group, "error", err)
+		} else if member {
+			return nil
+		}
+	}
+	return fmt.Errorf("home policies are only read for allowed principals and members of allowed groups, %s is neither", username)
+}
+
+// IsEmpty returns true if no constraints are configured
+func (c HomePolicyConstraints) IsEmpty() bool {
+	return len(c.AllowedIssuers) == 0 &&
+		len(c.ForbiddenPrincipals) == 0 &&
+		c.MaxEntries <= 0 &&
+		len(c.RequiredEmailDomains) == 0
+}
+
+// Apply returns a copy of the policy p stripped of all entries that violate
+// the constraints. A problem is returned for each entry removed so that a
+// single bad entry does not prevent the remaining entries from being used.
+func (c HomePolicyConstraints) Apply(p *Policy, path string) (*Policy, []files.ConfigProblem) {
+	problems := []files.ConfigProblem{}
+	constrained := new(Policy)
+	if p == nil {
+		return constrained, problems
+	}
+
+	recordProblem := func(user User, msg string) {
+		configProblem := files.ConfigProblem{
+			Filepath:      path,
+			OffendingLine: strings.Join(append(append([]string{}, user.Principals...), user.IdentityAttribute, user.Issuer), " "),
+			ErrorMessage:  msg,
+			Source:        "home policy constraints",
+		}
+		problems = append(problems, configProblem)
+		files.ConfigProblems().RecordProblem(configProblem)
+	}
+
+	for _, user := range p.Users {
+		if len(c.AllowedIssuers) > 0 && !slices.Contains(c.AllowedIssuers, user.Issuer) {
+			recordProblem(user, fmt.Sprintf("issuer %s is not allowed in home policy", user.Issuer))
+			continue
+		}
+
+		principals := []string{}
+		for _, principal := range user.Principals {
+			if slices.Contains(c.ForbiddenPrincipals, principal) {
+				recordProblem(user, fmt.Sprintf("principal %s can not be granted by home policy", principal))
+				continue
+			}
+			principals = append(principals, principal)
+		}
+		if len(principals) == 0 {
+			continue
+		}
+
+		if len(c.RequiredEmailDomains) > 0 && !c.hasRequiredEmailDomain(user.IdentityAttribute) {
+			recordProblem(user, fmt.Sprintf("identity %s is not an email in an allowed domain (%s)", user.IdentityAttribute, strings.Join(c.RequiredEmailDomains, ", ")))
+			continue
+		}
+
+		if c.MaxEntries > 0 && len(constrained.Users) >= c.MaxEntries {
+			recordProblem(user, fmt.Sprintf("home policy exceeds the maximum of %d entries", c.MaxEntries))
+			continue
+		}
+
+		constrained.Users = append(constrained.Users, User{
+			IdentityAttribute: user.IdentityAttribute,
+			Principals:        principals,
+			Issuer:            user.Issuer,
+			Expires:           user.Expires,
+			KeyOptions:        user.KeyOptions,
+		})
+	}
+	return constrained, problems
+}
+
+// hasRequiredEmailDomain returns true if identityAttribute is an email
+// address, an email suffix match or a domain grant whose domain is listed in
+// RequiredEmailDomains.
+// Subject IDs and OIDC claim matchers never satisfy this check as we can not
+// determine which domain they belong to.
+func (c HomePolicyConstraints) hasRequiredEmailDomain(identityAttribute string) bool {
+	var domain string
+	if strings.HasPrefix(identityAttribute, OIDC_WILDCARD_EMAIL) {
+		suffix := identityAttribute[len(OIDC_WILDCARD_EMAIL):]
+		if !strings.HasPrefix(suffix, "@") {
+			return false
+		}
+		domain = suffix[1:]
+	} else if d, ok := strings.CutPrefix(identityAttribute, EMAIL_DOMAIN); ok {
+		domain = d
+	} else if strings.HasPrefix(identityAttribute, OIDC_CLAIMS) {
+		return false
+	} else {
+		at := strings.LastIndex(identityAttribute, "@")
+		if at < 1 {
+			return false
+		}
+		domain = identityAttribute[at+1:]
+	}
+
+	for _, required := range c.RequiredEmailDomains {
+		if strings.EqualFold(domain, strings.TrimPrefix(required, "@")) {
+			return true
+		}
+	}
+	return false
+}
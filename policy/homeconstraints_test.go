@@ -0,0 +1,133 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHomePolicyConstraints(t *testing.T) {
+	t.Parallel()
+
+	homePolicy := &policy.Policy{
+		Users: []policy.User{
+			{IdentityAttribute: "alice@example.com", Principals: []string{"alice"}, Issuer: "https://accounts.google.com"},
+			{IdentityAttribute: "bob@example.com", Principals: []string{"root", "alice"}, Issuer: "https://accounts.google.com"},
+			{IdentityAttribute: "carol@other.com", Principals: []string{"alice"}, Issuer: "https://accounts.google.com"},
+			{IdentityAttribute: "oidc-match-end:email:@example.com", Principals: []string{"alice"}, Issuer: "https://accounts.google.com"},
+			{IdentityAttribute: "oidc:groups:admins", Principals: []string{"alice"}, Issuer: "https://accounts.google.com"},
+			{IdentityAttribute: "dave@example.com", Principals: []string{"alice"}, Issuer: "https://gitlab.com"},
+		},
+	}
+
+	tests := []struct {
+		name             string
+		constraints      policy.HomePolicyConstraints
+		expectedIDs      []string
+		expectedProblems int
+	}{
+		{
+			name:        "no constraints",
+			constraints: policy.HomePolicyConstraints{},
+			expectedIDs: []string{"alice@example.com", "bob@example.com", "carol@other.com", "oidc-match-end:email:@example.com", "oidc:groups:admins", "dave@example.com"},
+		},
+		{
+			name:             "allowed issuers",
+			constraints:      policy.HomePolicyConstraints{AllowedIssuers: []string{"https://accounts.google.com"}},
+			expectedIDs:      []string{"alice@example.com", "bob@example.com", "carol@other.com", "oidc-match-end:email:@example.com", "oidc:groups:admins"},
+			expectedProblems: 1,
+		},
+		{
+			name:             "forbidden principals",
+			constraints:      policy.HomePolicyConstraints{ForbiddenPrincipals: []string{"root"}},
+			expectedIDs:      []string{"alice@example.com", "bob@example.com", "carol@other.com", "oidc-match-end:email:@example.com", "oidc:groups:admins", "dave@example.com"},
+			expectedProblems: 1,
+		},
+		{
+			name:             "max entries",
+			constraints:      policy.HomePolicyConstraints{MaxEntries: 2},
+			expectedIDs:      []string{"alice@example.com", "bob@example.com"},
+			expectedProblems: 4,
+		},
+		{
+			name:             "required email domains",
+			constraints:      policy.HomePolicyConstraints{RequiredEmailDomains: []string{"EXAMPLE.com"}},
+			expectedIDs:      []string{"alice@example.com", "bob@example.com", "oidc-match-end:email:@example.com", "dave@example.com"},
+			expectedProblems: 2,
+		},
+		{
+			name: "combined",
+			constraints: policy.HomePolicyConstraints{
+				AllowedIssuers:       []string{"https://accounts.google.com"},
+				RequiredEmailDomains: []string{"@example.com"},
+				MaxEntries:           2,
+			},
+			expectedIDs:      []string{"alice@example.com", "bob@example.com"},
+			expectedProblems: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			constrained, problems := tt.constraints.Apply(homePolicy, "/home/foo/.opk/auth_id")
+			require.Len(t, problems, tt.expectedProblems)
+
+			ids := []string{}
+			for _, user := range constrained.Users {
+				ids = append(ids, user.IdentityAttribute)
+			}
+			require.Equal(t, tt.expectedIDs, ids)
+		})
+	}
+}
+
+func TestHomePolicyConstraintsStripsForbiddenPrincipals(t *testing.T) {
+	t.Parallel()
+
+	homePolicy := &policy.Policy{
+		Users: []policy.User{
+			{IdentityAttribute: "bob@example.com", Principals: []string{"root", "bob"}, Issuer: "https://accounts.google.com"},
+		},
+	}
+	constraints := policy.HomePolicyConstraints{ForbiddenPrincipals: []string{"root"}}
+
+	constrained, problems := constraints.Apply(homePolicy, "/home/bob/.opk/auth_id")
+	require.Len(t, problems, 1)
+	require.Len(t, constrained.Users, 1)
+	require.Equal(t, []string{"bob"}, constrained.Users[0].Principals)
+
+	// The original policy must not be modified
+	require.Equal(t, []string{"root", "bob"}, homePolicy.Users[0].Principals)
+}
+
+func TestHomePolicyAccess(t *testing.T) {
+	t.Parallel()
+
+	groups := mockGroupLookup{"developers": {"alice"}}
+	require.NoError(t, policy.HomePolicyAccess{}.Check("bob", groups))
+	require.ErrorContains(t, policy.HomePolicyAccess{Disabled: true, AllowedPrincipals: []string{"bob"}}.Check("bob", groups), "home policies are disabled")
+
+	access := policy.HomePolicyAccess{AllowedPrincipals: []string{"bob"}, AllowedGroups: []string{"missing", "developers"}}
+	require.NoError(t, access.Check("bob", groups))
+	require.NoError(t, access.Check("alice", groups))
+	require.ErrorContains(t, access.Check("carol", groups), "home policies are only read for allowed principals and members of allowed groups, carol is neither")
+}
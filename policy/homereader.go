@@ -0,0 +1,133 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// HomeReader reads home policy files without trusting the user who owns
+// them. It never follows a symbolic link, and only reads an auth_id owned
+// by the user in a ~/.opk that no other user can change.
+type HomeReader struct {
+	Fs afero.Fs
+	// Owner returns the ID of the owner of the file at path, the UID on
+	// Unix and the SID on Windows
+	Owner func(path string, fi fs.FileInfo) (string, error)
+}
+
+// NewHomeReader returns a HomeReader of the files of the os
+func NewHomeReader() *HomeReader {
+	return &HomeReader{Fs: afero.NewOsFs(), Owner: FileOwner}
+}
+
+// Read returns the content of the home policy file of u
+func (r *HomeReader) Read(u *user.User) ([]byte, error) {
+	if u.HomeDir == "" {
+		return nil, fmt.Errorf("user %s does not have a home directory", u.Username)
+	}
+	dir := filepath.Join(u.HomeDir, ".opk")
+	path := filepath.Join(dir, "auth_id")
+
+	dirInfo, err := r.lstat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the directory at path: %w", err)
+	}
+	if err := r.checkOwnedBy(dir, dirInfo, u, true); err != nil {
+		return nil, err
+	}
+	if !dirInfo.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	if writableByOthers(dirInfo) {
+		return nil, fmt.Errorf("unsafe permissions on %s, other users can write to it (mode %o)", dir, dirInfo.Mode().Perm())
+	}
+
+	fileInfo, err := r.lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	if err := r.checkOwnedBy(path, fileInfo, u, false); err != nil {
+		return nil, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if err := files.NewPermsChecker(r.Fs).CheckPerm(path, []fs.FileMode{files.ModeHomePerms}, "", ""); err != nil {
+		return nil, fmt.Errorf("policy file has insecure permissions: %w", err)
+	}
+
+	file, err := r.Fs.OpenFile(path, os.O_RDONLY|openNoFollow, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	// The path is checked before it is opened, make sure it still names
+	// the file that was checked
+	openedInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe %s: %w", path, err)
+	}
+	if !r.sameFile(fileInfo, openedInfo) {
+		return nil, fmt.Errorf("%s was replaced while it was read", path)
+	}
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return content, nil
+}
+
+// checkOwnedBy returns an error if path is a symbolic link or isn't owned
+// by u. Directories may also belong to an administrator.
+func (r *HomeReader) checkOwnedBy(path string, fi fs.FileInfo, u *user.User, dir bool) error {
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symlink, symlinks are unsafe in this context", path)
+	}
+	owner, err := r.Owner(path, fi)
+	if err != nil {
+		return fmt.Errorf("failed to find the owner of %s: %w", path, err)
+	}
+	if owner == u.Uid || dir && isAdministrator(owner) {
+		return nil
+	}
+	return fmt.Errorf("unsafe file ownership on %s expected owner %s (%s) got %s", path, u.Username, u.Uid, owner)
+}
+
+func (r *HomeReader) lstat(path string) (fs.FileInfo, error) {
+	if lstater, ok := r.Fs.(afero.Lstater); ok {
+		fi, _, err := lstater.LstatIfPossible(path)
+		return fi, err
+	}
+	return r.Fs.Stat(path)
+}
+
+func (r *HomeReader) sameFile(a, b fs.FileInfo) bool {
+	if _, ok := r.Fs.(*afero.OsFs); ok {
+		return os.SameFile(a, b)
+	}
+	// Other filesystems don't identify their files
+	return a.Mode() == b.Mode() && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,190 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"io/fs"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// symlinkFs reports the paths of links as symbolic links
+type symlinkFs struct {
+	afero.Fs
+	links map[string]bool
+}
+
+type symlinkInfo struct{ fs.FileInfo }
+
+func (symlinkInfo) Mode() fs.FileMode { return fs.ModeSymlink | 0o777 }
+
+func (s symlinkFs) LstatIfPossible(name string) (fs.FileInfo, bool, error) {
+	fi, err := s.Fs.Stat(name)
+	if err == nil && s.links[name] {
+		fi = symlinkInfo{fi}
+	}
+	return fi, true, err
+}
+
+func TestHomeReader(t *testing.T) {
+	t.Parallel()
+
+	alice := &user.User{Username: "alice", Uid: "1000", HomeDir: filepath.Join(string(filepath.Separator), "home", "alice")}
+	dir := filepath.Join(alice.HomeDir, ".opk")
+	path := filepath.Join(dir, "auth_id")
+	content := []byte("alice alice@example.com https://accounts.google.com\n")
+
+	tests := []struct {
+		name    string
+		setup   func(fs afero.Fs, links map[string]bool, owners map[string]string)
+		wantErr string
+		unix    bool
+	}{
+		{
+			name:  "owned by the user",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {},
+		},
+		{
+			name: "directory owned by root",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				owners[dir] = "0"
+			},
+			unix: true,
+		},
+		{
+			name: "symlinked file",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				links[path] = true
+			},
+			wantErr: "is a symlink",
+		},
+		{
+			name: "symlinked directory",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				links[dir] = true
+			},
+			wantErr: "is a symlink",
+		},
+		{
+			name: "file owned by another user",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				owners[path] = "0"
+			},
+			wantErr: "unsafe file ownership on " + path + " expected owner alice (1000) got 0",
+		},
+		{
+			name: "directory owned by another user",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				owners[dir] = "1001"
+			},
+			wantErr: "unsafe file ownership on " + dir,
+		},
+		{
+			name: "directory writable by others",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				require.NoError(t, fs.Chmod(dir, 0o777))
+			},
+			wantErr: "other users can write to it",
+			unix:    true,
+		},
+		{
+			name: "file readable by others",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				require.NoError(t, fs.Chmod(path, 0o644))
+			},
+			wantErr: "policy file has insecure permissions",
+			unix:    true,
+		},
+		{
+			name: "directory instead of a file",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				require.NoError(t, fs.Remove(path))
+				require.NoError(t, fs.Mkdir(path, 0o700))
+			},
+			wantErr: "is not a regular file",
+		},
+		{
+			name: "missing file",
+			setup: func(fs afero.Fs, links map[string]bool, owners map[string]string) {
+				require.NoError(t, fs.Remove(path))
+			},
+			wantErr: "failed to describe the file at path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.unix && runtime.GOOS == "windows" {
+				t.Skip("permission bits and root are Unix only")
+			}
+			memFs := afero.NewMemMapFs()
+			require.NoError(t, memFs.MkdirAll(dir, 0o700))
+			require.NoError(t, afero.WriteFile(memFs, path, content, 0o600))
+			links := map[string]bool{}
+			owners := map[string]string{}
+			tt.setup(memFs, links, owners)
+
+			r := &policy.HomeReader{
+				Fs: symlinkFs{Fs: memFs, links: links},
+				Owner: func(path string, fi fs.FileInfo) (string, error) {
+					if owner, ok := owners[path]; ok {
+						return owner, nil
+					}
+					return "1000", nil
+				},
+			}
+			got, err := r.Read(alice)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				require.Nil(t, got)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, content, got)
+		})
+	}
+}
+
+func TestLoadHomePolicyWithReader(t *testing.T) {
+	t.Parallel()
+
+	memFs := afero.NewMemMapFs()
+	path := filepath.Join(ValidUser.HomeDir, ".opk", "auth_id")
+	require.NoError(t, memFs.MkdirAll(filepath.Dir(path), 0o700))
+	require.NoError(t, afero.WriteFile(memFs, path, []byte("foo alice@example.com https://accounts.google.com\n"), 0o600))
+	links := map[string]bool{}
+	loader := NewTestHomePolicyLoader(memFs, &MockUserLookup{User: ValidUser})
+	loader.Reader = &policy.HomeReader{
+		Fs:    symlinkFs{Fs: memFs, links: links},
+		Owner: func(string, fs.FileInfo) (string, error) { return ValidUser.Uid, nil },
+	}
+
+	pol, gotPath, err := loader.LoadHomePolicy("foo", true)
+	require.NoError(t, err)
+	require.Equal(t, path, gotPath)
+	require.Len(t, pol.Users, 1)
+
+	// The reader refuses what the file loader would read
+	links[path] = true
+	_, _, err = loader.LoadHomePolicy("foo", true)
+	require.ErrorContains(t, err, "is a symlink")
+}
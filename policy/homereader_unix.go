@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// openNoFollow makes opening a symbolic link fail
+const openNoFollow = syscall.O_NOFOLLOW
+
+// FileOwner returns the UID of the owner of the file described by fi
+func FileOwner(path string, fi fs.FileInfo) (string, error) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("failed to stat file %s", path)
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10), nil
+}
+
+func isAdministrator(uid string) bool {
+	return uid == "0"
+}
+
+func writableByOthers(fi fs.FileInfo) bool {
+	return fi.Mode().Perm()&0o022 != 0
+}
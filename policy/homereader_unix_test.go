@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHomeReaderOs(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+	home := t.TempDir()
+	require.NoError(t, os.Chmod(home, 0o700))
+	u := &user.User{Username: current.Username, Uid: current.Uid, HomeDir: home}
+	dir := filepath.Join(home, ".opk")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	target := filepath.Join(home, "secret")
+	require.NoError(t, os.WriteFile(target, []byte("secret\n"), 0o600))
+
+	// A symlink is refused even though the file it points to is safe
+	path := filepath.Join(dir, "auth_id")
+	require.NoError(t, os.Symlink(target, path))
+	_, err = NewHomeReader().Read(u)
+	require.ErrorContains(t, err, "is a symlink")
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.WriteFile(path, []byte("policy\n"), 0o600))
+	content, err := NewHomeReader().Read(u)
+	require.NoError(t, err)
+	require.Equal(t, "policy\n", string(content))
+
+	u.Uid = "4242"
+	_, err = NewHomeReader().Read(u)
+	require.ErrorContains(t, err, "unsafe file ownership")
+}
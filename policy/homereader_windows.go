@@ -0,0 +1,55 @@
+//go:build windows
+// +build windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"io/fs"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// openNoFollow is not needed on Windows, the path is checked with Lstat
+// and the opened file is compared with the one checked
+const openNoFollow = 0
+
+// FileOwner returns the SID of the owner of the file at path
+func FileOwner(path string, fi fs.FileInfo) (string, error) {
+	report, err := files.NewDefaultACLVerifier(afero.NewOsFs()).VerifyACL(path, files.ExpectedACL{})
+	if err != nil {
+		return "", err
+	}
+	if report.OwnerSIDStr == "" {
+		return "", fmt.Errorf("could not determine file owner for %s", path)
+	}
+	return report.OwnerSIDStr, nil
+}
+
+// isAdministrator reports if sid is SYSTEM or the Administrators group
+func isAdministrator(sid string) bool {
+	return files.Principals().IsAdministrator("", sid)
+}
+
+// writableByOthers is left to the NTFS ACLs on Windows, the permission
+// bits don't show who can write to a directory
+func writableByOthers(fi fs.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,96 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// IssuerAliases maps the old issuer URLs of migrated OpenID Providers to the
+// issuer they were migrated to, e.g. the URL of a new Azure tenant. Policy
+// entries of an old issuer match ID Tokens of the new one and the other way
+// around, so the policy can be rewritten after the migration.
+type IssuerAliases map[string]string
+
+// Canonical returns the issuer that issuer is an alias of, or issuer itself
+func (a IssuerAliases) Canonical(issuer string) string {
+	if canonical, ok := a[issuer]; ok {
+		return canonical
+	}
+	return issuer
+}
+
+// Equivalent returns true if x and y are the same issuer once aliases are
+// resolved
+func (a IssuerAliases) Equivalent(x string, y string) bool {
+	return x == y || a.Canonical(x) == a.Canonical(y)
+}
+
+// Valid returns the aliases that are valid and the problems of the others,
+// sorted by alias
+func (a IssuerAliases) Valid() (IssuerAliases, []error) {
+	aliases := make([]string, 0, len(a))
+	for alias := range a {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	valid := IssuerAliases{}
+	errs := []error{}
+	for _, alias := range aliases {
+		issuer := a[alias]
+		switch {
+		case alias == "" || issuer == "":
+			errs = append(errs, fmt.Errorf("issuer alias %q: %q: both issuers are required", alias, issuer))
+		case alias == issuer:
+			errs = append(errs, fmt.Errorf("issuer alias %s is an alias of itself", alias))
+		case a[issuer] != "":
+			errs = append(errs, fmt.Errorf("issuer alias %s points to %s, which is itself an alias of %s", alias, issuer, a[issuer]))
+		default:
+			valid[alias] = issuer
+		}
+	}
+	return valid, errs
+}
+
+// RewriteIssuer returns content, the content of a policy file, with the
+// issuer of the entries of oldIssuer replaced by newIssuer and the number of
+// entries changed. Comments and the other lines are kept as they are.
+func RewriteIssuer(content []byte, oldIssuer string, newIssuer string) ([]byte, int) {
+	bom := []byte{}
+	if bytes.HasPrefix(content, []byte("\ufeff")) {
+		bom, content = []byte("\ufeff"), content[3:]
+	}
+	lines := strings.Split(string(content), "\n")
+	changed := 0
+	for _, row := range files.ParseRows(content) {
+		if row.Err != nil || len(row.Columns) < 3 || row.Columns[2] != oldIssuer {
+			continue
+		}
+		lines[row.Line-1] = row.ReplaceColumn(2, newIssuer)
+		changed++
+	}
+	if changed == 0 {
+		return append(bom, content...), 0
+	}
+	return append(bom, strings.Join(lines, "\n")...), changed
+}
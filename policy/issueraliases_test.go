@@ -0,0 +1,85 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuerAliases(t *testing.T) {
+	aliases, errs := IssuerAliases{
+		"https://old.example.com":   "https://new.example.com",
+		"https://older.example.com": "https://old.example.com",
+		"https://self.example.com":  "https://self.example.com",
+		"https://empty.example.com": "",
+	}.Valid()
+	require.Equal(t, IssuerAliases{"https://old.example.com": "https://new.example.com"}, aliases)
+	require.Len(t, errs, 3)
+	require.ErrorContains(t, errs[0], `issuer alias "https://empty.example.com": "": both issuers are required`)
+	require.ErrorContains(t, errs[1], "issuer alias https://older.example.com points to https://old.example.com, which is itself an alias of https://new.example.com")
+	require.ErrorContains(t, errs[2], "issuer alias https://self.example.com is an alias of itself")
+
+	require.True(t, aliases.Equivalent("https://old.example.com", "https://new.example.com"))
+	require.True(t, aliases.Equivalent("https://new.example.com", "https://old.example.com"))
+	require.True(t, aliases.Equivalent("https://other.example.com", "https://other.example.com"))
+	require.False(t, aliases.Equivalent("https://other.example.com", "https://new.example.com"))
+	require.False(t, IssuerAliases(nil).Equivalent("https://old.example.com", "https://new.example.com"))
+}
+
+func TestRewriteIssuer(t *testing.T) {
+	content := "\ufeff# Admins\r\n" +
+		"root alice@example.com https://old.example.com expires=2030-01-01 # until the audit\r\n" +
+		"root bob@example.com https://new.example.com\r\n" +
+		"dev 'carol smith' \"https://old.example.com\"\r\n" +
+		"dev dave@example.com https://old.example.com.evil\r\n"
+	rewritten, changed := RewriteIssuer([]byte(content), "https://old.example.com", "https://new.example.com")
+	require.Equal(t, 2, changed)
+	require.Equal(t, "\ufeff# Admins\r\n"+
+		"root alice@example.com https://new.example.com expires=2030-01-01 # until the audit\r\n"+
+		"root bob@example.com https://new.example.com\r\n"+
+		"dev 'carol smith' https://new.example.com\r\n"+
+		"dev dave@example.com https://old.example.com.evil\r\n", string(rewritten))
+
+	unchanged, changed := RewriteIssuer([]byte(content), "https://missing.example.com", "https://new.example.com")
+	require.Zero(t, changed)
+	require.Equal(t, content, string(unchanged))
+}
+
+func TestLoadProviderPolicyIssuerAliases(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	loader := &ProvidersFileLoader{FileLoader: files.FileLoader{Fs: fs, RequiredPerm: files.ModeSystemPerms}}
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte("https://old.example.com old 24h\n"), 0o640))
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers.yml", []byte("providers:\n"+
+		"  - issuer: https://new.example.com\n"+
+		"    client_ids: [new]\n"+
+		"    expiration_policy: 24h\n"+
+		"aliases:\n"+
+		"  https://old.example.com: https://new.example.com\n"+
+		"  https://gone.example.com: https://missing.example.com\n"), 0o640))
+
+	files.ConfigProblems().Clear()
+	providerPolicy, err := loader.LoadProviderPolicy("/etc/opk/providers")
+	require.NoError(t, err)
+	require.Equal(t, IssuerAliases{"https://old.example.com": "https://new.example.com"}, providerPolicy.IssuerAliases())
+	problems := files.ConfigProblems().GetProblems()
+	require.Len(t, problems, 1)
+	require.Equal(t, "issuer alias https://gone.example.com points to https://missing.example.com, which is not a configured provider", problems[0].ErrorMessage)
+}
@@ -0,0 +1,130 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// SystemDefaultIssuerPolicyDir is the default directory of the per-issuer
+// system policies. On Unix: /etc/opk/auth_id.d
+var SystemDefaultIssuerPolicyDir = SystemConfigPath("auth_id.d")
+
+// IssuerPolicyName returns the file name of the policy of issuer in the
+// per-issuer policy directory, the first 16 hex digits of the SHA-256 of
+// issuer followed by .auth_id
+func IssuerPolicyName(issuer string) string {
+	sum := sha256.Sum256([]byte(issuer))
+	return hex.EncodeToString(sum[:8]) + FragmentExt
+}
+
+// IssuerPolicyStore reads the per-issuer system policies. Each file only
+// grants access to the identities of one issuer, so the entries of each
+// OpenID Provider can be managed separately, e.g. by a different team or
+// tool. A file has the same format and permissions as the system policy.
+type IssuerPolicyStore struct {
+	Fs  afero.Fs
+	Dir string
+}
+
+// NewIssuerPolicyStore returns an IssuerPolicyStore at
+// SystemDefaultIssuerPolicyDir on the OS filesystem
+func NewIssuerPolicyStore() *IssuerPolicyStore {
+	return &IssuerPolicyStore{
+		Fs:  afero.NewOsFs(),
+		Dir: SystemDefaultIssuerPolicyDir,
+	}
+}
+
+// Path returns the filepath of the policy of issuer
+func (s *IssuerPolicyStore) Path(issuer string) string {
+	return filepath.Join(s.Dir, IssuerPolicyName(issuer))
+}
+
+// IssuerPolicyHolds returns true if the per-issuer policy file at path may
+// grant access to the identities of issuer
+func IssuerPolicyHolds(path string, issuer string) bool {
+	return filepath.Base(path) == IssuerPolicyName(issuer)
+}
+
+// Paths returns the paths of the policies in Dir, sorted. A missing
+// directory has no policies.
+func (s *IssuerPolicyStore) Paths() ([]string, error) {
+	entries, err := afero.ReadDir(s.Fs, s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), FragmentExt) && !entry.IsDir() {
+			paths = append(paths, filepath.Join(s.Dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Load reads every policy in Dir and returns them merged along with the
+// paths read. Files with insecure permissions are skipped, as are the
+// entries of a file for an issuer other than the one it is named after.
+func (s *IssuerPolicyStore) Load() (*Policy, []string, error) {
+	paths, err := s.Paths()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	loader := PolicyLoader{FileLoader: files.FileLoader{Fs: s.Fs, RequiredPerm: files.ModeSystemPerms}}
+	merged := &Policy{}
+	read := []string{}
+	for _, path := range paths {
+		p, err := loader.LoadPolicyAtPath(path)
+		if err != nil {
+			files.ConfigProblems().RecordProblem(files.ConfigProblem{
+				Filepath:     path,
+				ErrorMessage: err.Error(),
+				Source:       "issuer policy",
+			})
+			continue
+		}
+		for _, user := range p.Users {
+			if !IssuerPolicyHolds(path, user.Issuer) {
+				files.ConfigProblems().RecordProblem(files.ConfigProblem{
+					Filepath:      path,
+					OffendingLine: strings.Join(user.Principals, ",") + " " + user.IdentityAttribute + " " + user.Issuer,
+					ErrorMessage:  fmt.Sprintf("the entries of issuer %s belong in %s", user.Issuer, IssuerPolicyName(user.Issuer)),
+					Source:        "issuer policy",
+				})
+				continue
+			}
+			merged.Users = append(merged.Users, user)
+		}
+		read = append(read, path)
+	}
+	return merged, read, nil
+}
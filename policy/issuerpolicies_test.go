@@ -0,0 +1,69 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuerPolicyStore(t *testing.T) {
+	t.Parallel()
+	store := &policy.IssuerPolicyStore{Fs: afero.NewMemMapFs(), Dir: "/etc/opk/auth_id.d"}
+
+	merged, paths, err := store.Load()
+	require.NoError(t, err)
+	require.Empty(t, merged.Users)
+	require.Empty(t, paths)
+
+	google, okta := "https://accounts.google.com", "https://example.okta.com"
+	require.Equal(t, "/etc/opk/auth_id.d/89a8000a68d759c6.auth_id", store.Path(google))
+	require.True(t, policy.IssuerPolicyHolds(store.Path(google), google))
+	require.False(t, policy.IssuerPolicyHolds(store.Path(google), okta))
+
+	// Entries of another issuer are skipped
+	require.NoError(t, afero.WriteFile(store.Fs, store.Path(google), []byte(
+		"root alice@example.com "+google+"\n"+
+			"root mallory@example.com "+okta+"\n"), 0o640))
+	require.NoError(t, afero.WriteFile(store.Fs, store.Path(okta), []byte("dev bob@example.com "+okta+"\n"), 0o640))
+	// Files with insecure permissions are skipped
+	require.NoError(t, afero.WriteFile(store.Fs, store.Path("https://gitlab.com"), []byte("root eve@example.com https://gitlab.com\n"), 0o666))
+
+	merged, paths, err = store.Load()
+	require.NoError(t, err)
+	require.Equal(t, []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"root"}, Issuer: google},
+		{IdentityAttribute: "bob@example.com", Principals: []string{"dev"}, Issuer: okta},
+	}, merged.Users)
+	require.ElementsMatch(t, []string{store.Path(google), store.Path(okta)}, paths)
+
+	// They are loaded alongside the system policy
+	loader := &policy.MultiPolicyLoader{
+		HomePolicyLoader:   NewTestHomePolicyLoader(store.Fs, &MockUserLookup{User: ValidUser}),
+		SystemPolicyLoader: NewTestSystemPolicyLoader(store.Fs, &MockUserLookup{User: ValidUser}),
+		LoaderScript:       MockTestSudoScript,
+		Username:           ValidUser.Username,
+		IssuerPolicies:     store,
+	}
+	p, source, err := loader.Load()
+	require.NoError(t, err)
+	require.Equal(t, merged.Users, p.Users)
+	require.Equal(t, store.Path(google)+", "+store.Path(okta), source.Source())
+}
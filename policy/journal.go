@@ -0,0 +1,141 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SystemDefaultJournalPath is the default filepath of the append-only journal
+// of policy mutations made by opkssh commands
+var SystemDefaultJournalPath = filepath.Join(GetSystemStateBasePath(), "policy.journal")
+
+// JournalEntry is a single record in the policy journal
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	// User is the OS user that ran the command
+	User string `json:"user"`
+	// SudoUser is the user that invoked sudo, if any
+	SudoUser string `json:"sudo_user,omitempty"`
+	// Command is the command line that made the change
+	Command string `json:"command"`
+	// Action is the kind of change, e.g. add or fix
+	Action string `json:"action"`
+	// Path is the file that was changed
+	Path string `json:"path,omitempty"`
+	// Summary describes the change, one line per change
+	Summary []string `json:"summary"`
+}
+
+// Journal appends JournalEntry records as JSON lines to a root owned file.
+// The journal is never rewritten, only appended to.
+type Journal struct {
+	Fs   afero.Fs
+	Path string
+	// Now can be replaced in tests
+	Now func() time.Time
+}
+
+// NewJournal returns a Journal at SystemDefaultJournalPath on the OS
+// filesystem
+func NewJournal() *Journal {
+	return &Journal{
+		Fs:   afero.NewOsFs(),
+		Path: SystemDefaultJournalPath,
+		Now:  time.Now,
+	}
+}
+
+// Append writes entry to the end of the journal. Time, User, SudoUser and
+// Command are filled in from the current process if not set.
+func (j *Journal) Append(entry JournalEntry) error {
+	if entry.Time.IsZero() {
+		now := time.Now
+		if j.Now != nil {
+			now = j.Now
+		}
+		entry.Time = now().UTC()
+	}
+	if entry.User == "" {
+		if u, err := user.Current(); err == nil {
+			entry.User = u.Username
+		}
+	}
+	if entry.SudoUser == "" {
+		entry.SudoUser = os.Getenv("SUDO_USER")
+	}
+	if entry.Command == "" {
+		entry.Command = strings.Join(os.Args, " ")
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := j.Fs.MkdirAll(filepath.Dir(j.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	f, err := j.Fs.OpenFile(j.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal %s: %w", j.Path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal %s: %w", j.Path, err)
+	}
+	return nil
+}
+
+// Entries returns every record in the journal, oldest first. A missing
+// journal has no entries.
+func (j *Journal) Entries() ([]JournalEntry, error) {
+	f, err := j.Fs.Open(j.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []JournalEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to open journal %s: %w", j.Path, err)
+	}
+	defer f.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt journal entry at %s:%d: %w", j.Path, lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
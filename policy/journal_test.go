@@ -0,0 +1,70 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	mockFs := afero.NewMemMapFs()
+	journal := &policy.Journal{Fs: mockFs, Path: policy.SystemDefaultJournalPath, Now: func() time.Time { return now }}
+
+	// Missing journal has no entries
+	entries, err := journal.Entries()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.NoError(t, journal.Append(policy.JournalEntry{
+		User:    "root",
+		Command: "opkssh add root alice@example.com google",
+		Action:  "add",
+		Path:    policy.SystemDefaultPolicyPath,
+		Summary: []string{"+ root alice@example.com https://accounts.google.com"},
+	}))
+	require.NoError(t, journal.Append(policy.JournalEntry{
+		User:     "root",
+		SudoUser: "bob",
+		Command:  "opkssh permissions fix",
+		Action:   "fix",
+		Summary:  []string{"chmod /etc/opk/auth_id to -rw-r-----"},
+	}))
+
+	entries, err = journal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, now, entries[0].Time)
+	require.Equal(t, "add", entries[0].Action)
+	require.Equal(t, "bob", entries[1].SudoUser)
+
+	info, err := mockFs.Stat(policy.SystemDefaultJournalPath)
+	require.NoError(t, err)
+	require.Equal(t, "-rw-------", info.Mode().Perm().String())
+
+	// A corrupt line is reported rather than silently skipped
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultJournalPath, []byte("{not json\n"), 0600))
+	_, err = journal.Entries()
+	require.ErrorContains(t, err, "corrupt journal entry")
+}
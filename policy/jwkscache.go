@@ -0,0 +1,307 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/openpubkey/openpubkey/discover"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultJWKSCacheTTL is how long a response without a Cache-Control
+	// max-age is fresh
+	DefaultJWKSCacheTTL = time.Hour
+	// MaxJWKSCacheTTL caps the max-age of the provider so that rotated keys
+	// are picked up
+	MaxJWKSCacheTTL = 24 * time.Hour
+	// DefaultJWKSMaxStale is how long after it expires a cached response is
+	// still used while the provider can't be reached
+	DefaultJWKSMaxStale = 24 * time.Hour
+
+	// JWKSCacheHit, JWKSCacheMiss and JWKSCacheStale are what OnLookup is
+	// called with: a fresh cached response, a request to the provider, and
+	// a cached response used while the provider can't be reached
+	JWKSCacheHit   = "hit"
+	JWKSCacheMiss  = "miss"
+	JWKSCacheStale = "stale"
+
+	discoveryPath = "/.well-known/openid-configuration"
+	// importedJWKSPath is the jwks_uri of imported keys, which don't come
+	// with the OpenID configuration of the provider
+	importedJWKSPath = "/.well-known/opkssh-imported-jwks"
+)
+
+// JWKSCacheDir returns the directory where verify caches the OpenID
+// configuration and keys of the providers
+func JWKSCacheDir() string {
+	return filepath.Join(GetSystemStateBasePath(), "jwks-cache")
+}
+
+// JWKSCache keeps the responses of the OpenID Providers to discovery and
+// JWKS requests on disk, so that a login doesn't wait for the provider and
+// still works during a short outage of the provider. Responses are fresh
+// for the max-age of their Cache-Control header.
+type JWKSCache struct {
+	Fs  afero.Fs
+	Dir string
+	// Refresh ignores fresh responses and always asks the provider, the
+	// cache is still updated and used when the provider can't be reached
+	Refresh bool
+	// MaxStale is how long cached responses are used after they expire, and
+	// imported keys after they are imported, while the provider can't be
+	// reached
+	MaxStale time.Duration
+	Now      func() time.Time
+	// OnLookup, if set, is called with JWKSCacheHit, JWKSCacheMiss or
+	// JWKSCacheStale for every request while the cache is enabled
+	OnLookup func(result string)
+}
+
+// NewJWKSCache returns the cache in JWKSCacheDir
+func NewJWKSCache() *JWKSCache {
+	return &JWKSCache{
+		Fs:       afero.NewOsFs(),
+		Dir:      JWKSCacheDir(),
+		MaxStale: DefaultJWKSMaxStale,
+		Now:      time.Now,
+	}
+}
+
+// jwksCacheEntry is a cached response, stored as JSON
+type jwksCacheEntry struct {
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Body      []byte    `json:"body"`
+	// Imported is set for keys imported with opkssh jwks import
+	Imported bool `json:"imported,omitempty"`
+}
+
+// Client returns an HTTP client that is next with the cache in front of
+// it. A nil next uses http.DefaultClient.
+func (c *JWKSCache) Client(next *http.Client) *http.Client {
+	if next == nil {
+		next = http.DefaultClient
+	}
+	transport := next.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client := *next
+	client.Transport = &jwksCacheTransport{cache: c, next: transport}
+	return &client
+}
+
+// Enabled returns true if the cache directory exists. It is created by
+// opkssh permissions fix, an admin can remove it to turn the cache off.
+func (c *JWKSCache) Enabled() bool {
+	info, err := c.Fs.Stat(c.Dir)
+	return err == nil && info.IsDir()
+}
+
+func (c *JWKSCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the cached response of url, nil if there is none or it can't
+// be trusted
+func (c *JWKSCache) get(url string) *jwksCacheEntry {
+	path := c.path(url)
+	info, err := c.Fs.Stat(path)
+	if err != nil {
+		return nil
+	}
+	// The cached keys decide which ID Tokens are accepted
+	if info.Mode().Perm()&0o022 != 0 {
+		slog.Warn("Ignoring cached response writable by others", "path", path, "mode", fmt.Sprintf("%o", info.Mode().Perm()))
+		return nil
+	}
+	content, err := afero.ReadFile(c.Fs, path)
+	if err != nil {
+		return nil
+	}
+	entry := &jwksCacheEntry{}
+	if err := json.Unmarshal(content, entry); err != nil || entry.URL != url {
+		return nil
+	}
+	return entry
+}
+
+// put caches body as the response of url. The cache is best effort so
+// errors are only logged.
+func (c *JWKSCache) put(url string, body []byte, ttl time.Duration) {
+	if err := c.write(jwksCacheEntry{URL: url, Body: body}, ttl); err != nil {
+		slog.Warn("Failed to cache the response", "url", url, "error", err)
+	}
+}
+
+func (c *JWKSCache) write(entry jwksCacheEntry, ttl time.Duration) error {
+	entry.FetchedAt = c.Now()
+	entry.ExpiresAt = entry.FetchedAt.Add(ttl)
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := c.path(entry.URL)
+	if err := files.WriteFileAtomic(c.Fs, path, content, 0o600); err != nil {
+		return err
+	}
+	// Keep the entry readable by verify when it is written by root
+	if err := chownToDir(c.Fs, c.Dir, path); err != nil {
+		return fmt.Errorf("failed to set the owner of %s: %w", path, err)
+	}
+	return nil
+}
+
+// Import stores jwks as the keys of issuer, for servers that can't reach
+// the provider. The imported keys are only used when the provider can't be
+// reached, for MaxStale after they are imported.
+func (c *JWKSCache) Import(issuer string, jwks []byte) error {
+	set, err := jwk.Parse(jwks)
+	if err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+	if set.Len() == 0 {
+		return fmt.Errorf("the JWKS has no keys")
+	}
+	base := strings.TrimSuffix(issuer, "/")
+	jwksURI := base + importedJWKSPath
+	discovery, err := json.Marshal(map[string]string{"issuer": issuer, "jwks_uri": jwksURI})
+	if err != nil {
+		return err
+	}
+	if err := c.write(jwksCacheEntry{URL: jwksURI, Body: jwks, Imported: true}, 0); err != nil {
+		return err
+	}
+	return c.write(jwksCacheEntry{URL: base + discoveryPath, Body: discovery, Imported: true}, 0)
+}
+
+// Fetch fetches the OpenID configuration and keys of the provider of row
+// through the cache
+func (c *JWKSCache) Fetch(ctx context.Context, row ProvidersRow) error {
+	_, err := discover.GetJwksByIssuer(ctx, row.Issuer, c.Client(row.httpClient))
+	return err
+}
+
+// cacheTTL returns how long a response with header is fresh, false if it
+// must not be stored
+func cacheTTL(header http.Header) (time.Duration, bool) {
+	ttl := DefaultJWKSCacheTTL
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(directive)), "=")
+		switch name {
+		case "no-store":
+			return 0, false
+		case "no-cache":
+			// Stored to be used during an outage only
+			ttl = 0
+		case "max-age":
+			if seconds, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && seconds >= 0 && ttl != 0 {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return min(ttl, MaxJWKSCacheTTL), true
+}
+
+type jwksCacheTransport struct {
+	cache *JWKSCache
+	next  http.RoundTripper
+}
+
+func (t *jwksCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || !t.cache.Enabled() {
+		return t.next.RoundTrip(req)
+	}
+	url := req.URL.String()
+	now := t.cache.Now()
+	entry := t.cache.get(url)
+	if entry != nil && !t.cache.Refresh && now.Before(entry.ExpiresAt) {
+		t.cache.lookup(JWKSCacheHit)
+		return entry.response(req), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if ttl, ok := cacheTTL(resp.Header); ok {
+			t.cache.put(url, body, ttl)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		t.cache.lookup(JWKSCacheMiss)
+		return resp, nil
+	}
+
+	// Survive an outage of the provider with the last response it sent
+	if entry != nil && (err != nil || resp.StatusCode >= http.StatusInternalServerError) &&
+		now.Before(entry.ExpiresAt.Add(t.cache.MaxStale)) {
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("status %s", resp.Status)
+		}
+		what := "cached"
+		if entry.Imported {
+			what = "imported"
+		}
+		slog.Warn("Using a stored response, the provider failed", "url", url, "source", what, "fetched", entry.FetchedAt.Format(time.RFC3339), "error", err)
+		t.cache.lookup(JWKSCacheStale)
+		return entry.response(req), nil
+	}
+	t.cache.lookup(JWKSCacheMiss)
+	return resp, err
+}
+
+func (c *JWKSCache) lookup(result string) {
+	if c.OnLookup != nil {
+		c.OnLookup(result)
+	}
+}
+
+func (e *jwksCacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/openpubkey/openpubkey/discover"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		ttl          time.Duration
+		store        bool
+	}{
+		{"", DefaultJWKSCacheTTL, true},
+		{"public, max-age=600", 10 * time.Minute, true},
+		{"max-age=31536000", MaxJWKSCacheTTL, true},
+		{"no-cache, max-age=600", 0, true},
+		{"private, no-store", 0, false},
+		{"max-age=soon", DefaultJWKSCacheTTL, true},
+	}
+	for _, tt := range tests {
+		ttl, store := cacheTTL(http.Header{"Cache-Control": {tt.cacheControl}})
+		require.Equal(t, tt.ttl, ttl, tt.cacheControl)
+		require.Equal(t, tt.store, store, tt.cacheControl)
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	requests := 0
+	status := http.StatusOK
+	cacheControl := "max-age=600"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Cache-Control", cacheControl)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"keys":[]}`))
+	}))
+	t.Cleanup(server.Close)
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fs := afero.NewMemMapFs()
+	cache := &JWKSCache{Fs: fs, Dir: "/var/lib/opk/jwks-cache", MaxStale: time.Hour, Now: func() time.Time { return now }}
+	var lookups []string
+	cache.OnLookup = func(result string) { lookups = append(lookups, result) }
+	client := cache.Client(server.Client())
+	get := func() (int, string) {
+		t.Helper()
+		resp, err := client.Get(server.URL + "/keys")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		n, _ := resp.Body.Read(body)
+		return resp.StatusCode, string(body[:n])
+	}
+
+	// Nothing is cached until the directory exists
+	get()
+	require.Equal(t, 1, requests)
+	require.False(t, cache.Enabled())
+	require.NoError(t, fs.MkdirAll(cache.Dir, 0o700))
+
+	code, body := get()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"keys":[]}`, body)
+	get()
+	require.Equal(t, 2, requests, "fresh responses come from the cache")
+
+	// Expired responses are fetched again
+	now = now.Add(11 * time.Minute)
+	get()
+	require.Equal(t, 3, requests)
+
+	// Refresh skips fresh responses
+	cache.Refresh = true
+	get()
+	require.Equal(t, 4, requests)
+	cache.Refresh = false
+
+	// During an outage the stale response is used up to MaxStale
+	status = http.StatusServiceUnavailable
+	now = now.Add(30 * time.Minute)
+	code, body = get()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"keys":[]}`, body)
+	now = now.Add(time.Hour)
+	code, _ = get()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, []string{JWKSCacheMiss, JWKSCacheHit, JWKSCacheMiss, JWKSCacheMiss, JWKSCacheStale, JWKSCacheMiss}, lookups)
+
+	// A client error is not an outage
+	status = http.StatusNotFound
+	now = now.Add(-time.Hour)
+	code, _ = get()
+	require.Equal(t, http.StatusNotFound, code)
+
+	// A cache entry others can write is not trusted
+	status = http.StatusOK
+	get()
+	requests = 0
+	require.NoError(t, fs.Chmod(cache.path(server.URL+"/keys"), 0o666))
+	get()
+	require.Equal(t, 1, requests)
+
+	// no-store responses are not cached
+	cacheControl = "no-store"
+	require.NoError(t, fs.RemoveAll(cache.Dir))
+	require.NoError(t, fs.MkdirAll(cache.Dir, 0o700))
+	get()
+	get()
+	require.Equal(t, 3, requests)
+}
+
+func testJWKS(t *testing.T) []byte {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key, err := jwk.FromRaw(priv.Public())
+	require.NoError(t, err)
+	require.NoError(t, key.Set(jwk.KeyIDKey, "kid-1"))
+	set := jwk.NewSet()
+	require.NoError(t, set.AddKey(key))
+	jwks, err := json.Marshal(set)
+	require.NoError(t, err)
+	return jwks
+}
+
+func TestJWKSCacheImport(t *testing.T) {
+	// The provider can't be reached
+	server := httptest.NewServer(http.NotFoundHandler())
+	issuer := server.URL
+	server.Close()
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fs := afero.NewMemMapFs()
+	require.NoError(t, fs.MkdirAll("/var/lib/opk/jwks-cache", 0o700))
+	cache := &JWKSCache{Fs: fs, Dir: "/var/lib/opk/jwks-cache", MaxStale: 30 * 24 * time.Hour, Now: func() time.Time { return now }}
+
+	require.ErrorContains(t, cache.Import(issuer, []byte("not json")), "invalid JWKS")
+	require.ErrorContains(t, cache.Import(issuer, []byte(`{"keys":[]}`)), "the JWKS has no keys")
+
+	jwks := testJWKS(t)
+	require.NoError(t, cache.Import(issuer, jwks))
+	got, err := discover.GetJwksByIssuer(context.Background(), issuer, cache.Client(nil))
+	require.NoError(t, err)
+	require.JSONEq(t, string(jwks), string(got))
+
+	// Imported keys are used for MaxStale
+	now = now.Add(31 * 24 * time.Hour)
+	_, err = discover.GetJwksByIssuer(context.Background(), issuer, cache.Client(nil))
+	require.Error(t, err)
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// chownToDir gives path the owner and group of the directory dir
+func chownToDir(fsys afero.Fs, dir string, path string) error {
+	info, err := fsys.Stat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if file, err := fsys.Stat(path); err == nil {
+		if fst, ok := file.Sys().(*syscall.Stat_t); ok && fst.Uid == st.Uid && fst.Gid == st.Gid {
+			return nil
+		}
+	}
+	return fsys.Chown(path, int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,302 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ldap grants logins to the members of LDAP or Active Directory
+// groups, as configured in /etc/opk/ldap.yml
+package ldap
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTimeout is how long connecting to and querying the server may take
+// when the config doesn't set a timeout
+const DefaultTimeout = 5 * time.Second
+
+// nestedMemberOf is the Active Directory matching rule that also matches
+// the members of nested groups
+const nestedMemberOf = "memberOf:1.2.840.113556.1.4.1941:"
+
+// Config is the LDAP group policy
+type Config struct {
+	// URL is the server, ldap://host:389 or ldaps://host:636
+	URL string `yaml:"url"`
+	// StartTLS upgrades an ldap:// connection to TLS
+	StartTLS bool `yaml:"start_tls,omitempty"`
+	// CAFile is a PEM file of the CAs that issue the server certificate,
+	// the system CAs are used when empty
+	CAFile             string `yaml:"ca_file,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+	// BindDN and the password are the service account used to search, an
+	// empty BindDN searches anonymously
+	BindDN           string `yaml:"bind_dn,omitempty"`
+	BindPassword     string `yaml:"bind_password,omitempty"`
+	BindPasswordFile string `yaml:"bind_password_file,omitempty"`
+	// BaseDN is where users are searched
+	BaseDN string `yaml:"base_dn"`
+	// UserAttribute is the attribute of the user entry holding the
+	// identity, mail by default. Use userPrincipalName for AD UPNs.
+	UserAttribute string `yaml:"user_attribute,omitempty"`
+	// IdentityClaim is the ID token claim matched with UserAttribute,
+	// email by default
+	IdentityClaim string `yaml:"identity_claim,omitempty"`
+	// Nested also matches the members of nested groups, Active Directory
+	// only
+	Nested bool `yaml:"nested,omitempty"`
+	// Timeout, e.g. 5s, overrides DefaultTimeout
+	Timeout string      `yaml:"timeout,omitempty"`
+	Groups  []GroupRule `yaml:"groups"`
+}
+
+// GroupRule allows the members of the group to log in as the principals
+// when their ID token is issued by issuer
+type GroupRule struct {
+	Principals []string `yaml:"principals"`
+	GroupDN    string   `yaml:"group_dn"`
+	Issuer     string   `yaml:"issuer"`
+}
+
+// ParseConfig parses and validates the content of an ldap.yml file
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse LDAP config: %w", err)
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q, expected ldap://host or ldaps://host", config.URL)
+	}
+	if config.StartTLS && u.Scheme == "ldaps" {
+		return nil, fmt.Errorf("start_tls can only be used with ldap:// urls")
+	}
+	if config.BaseDN == "" {
+		return nil, fmt.Errorf("base_dn is required")
+	}
+	if config.BindPassword != "" && config.BindPasswordFile != "" {
+		return nil, fmt.Errorf("set only one of bind_password and bind_password_file")
+	}
+	if config.BindDN != "" && config.BindPassword == "" && config.BindPasswordFile == "" {
+		return nil, fmt.Errorf("bind_dn requires bind_password or bind_password_file")
+	}
+	if _, err := config.TimeoutDuration(); err != nil {
+		return nil, err
+	}
+	if len(config.Groups) == 0 {
+		return nil, fmt.Errorf("no groups configured")
+	}
+	for i, rule := range config.Groups {
+		if len(rule.Principals) == 0 || rule.GroupDN == "" || rule.Issuer == "" {
+			return nil, fmt.Errorf("group %d: principals, group_dn and issuer are required", i+1)
+		}
+	}
+	if config.UserAttribute == "" {
+		config.UserAttribute = "mail"
+	}
+	if config.IdentityClaim == "" {
+		config.IdentityClaim = "email"
+	}
+	return config, nil
+}
+
+// LoadConfig reads the LDAP group policy at path, which must have mode
+// 0640 as it may hold the bind password. It returns nil and no error if the
+// file does not exist.
+func LoadConfig(fsys afero.Fs, path string) (*Config, error) {
+	loader := files.FileLoader{Fs: fsys, RequiredPerm: files.ModeSystemPerms}
+	content, err := loader.LoadFileAtPath(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read LDAP config %s: %w", path, err)
+	}
+	config, err := ParseConfig(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// TimeoutDuration returns how long connecting to and querying the server
+// may take
+func (c *Config) TimeoutDuration() (time.Duration, error) {
+	if c.Timeout == "" {
+		return DefaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected a positive duration such as 5s", c.Timeout)
+	}
+	return timeout, nil
+}
+
+// Conn is the part of an LDAP connection used by Policy
+type Conn interface {
+	Bind(username, password string) error
+	Search(searchRequest *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close() error
+}
+
+// Policy checks the group memberships configured in Config
+type Policy struct {
+	Config *Config
+	// Source is where Config was read, reported with the logins it allows
+	Source string
+	// Dial connects to the server of Config
+	Dial func(config *Config) (Conn, error)
+}
+
+// NewPolicy returns a Policy that connects to the server of config
+func NewPolicy(config *Config, source string) *Policy {
+	return &Policy{Config: config, Source: source, Dial: dial}
+}
+
+// Check returns the rule that allows the identity in claims, an ID token
+// from issuer, to log in as principal. It returns nil if no rule does. The
+// server is only queried when a rule is for principal and issuer.
+func (p *Policy) Check(principal string, issuer string, claims map[string][]string) (*GroupRule, error) {
+	var rules []GroupRule
+	for _, rule := range p.Config.Groups {
+		if rule.Issuer == issuer && slices.Contains(rule.Principals, principal) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	identities := claims[p.Config.IdentityClaim]
+	if len(identities) == 0 || identities[0] == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", p.Config.IdentityClaim)
+	}
+	identity := identities[0]
+	// Anyone can put any address in their profile at some providers, an
+	// email is only matched if the provider verified it
+	if p.Config.IdentityClaim == "email" && !slices.Equal(claims["email_verified"], []string{"true"}) {
+		return nil, fmt.Errorf("ID token does not assert email_verified for %s", identity)
+	}
+
+	conn, err := p.Dial(p.Config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.Config.URL, err)
+	}
+	defer conn.Close()
+	if p.Config.BindDN != "" {
+		password, err := p.Config.bindPassword()
+		if err != nil {
+			return nil, err
+		}
+		if err := conn.Bind(p.Config.BindDN, password); err != nil {
+			return nil, fmt.Errorf("failed to bind as %s: %w", p.Config.BindDN, err)
+		}
+	}
+
+	for _, rule := range rules {
+		memberOf := "memberOf="
+		if p.Config.Nested {
+			memberOf = nestedMemberOf + "="
+		}
+		filter := fmt.Sprintf("(&(%s=%s)(%s%s))", p.Config.UserAttribute, goldap.EscapeFilter(identity), memberOf, goldap.EscapeFilter(rule.GroupDN))
+		result, err := conn.Search(goldap.NewSearchRequest(
+			p.Config.BaseDN, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+			0, 0, false, filter, []string{"dn"}, nil))
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s: %w", p.Config.BaseDN, err)
+		}
+		if len(result.Entries) > 0 {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// bindPassword returns the password of BindDN
+func (c *Config) bindPassword() (string, error) {
+	if c.BindPasswordFile == "" {
+		return c.BindPassword, nil
+	}
+	password, err := os.ReadFile(c.BindPasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bind_password_file: %w", err)
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
+
+// tlsConfig returns the TLS settings of the connection to the server
+func (c *Config) tlsConfig() (*tls.Config, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file %s", c.CAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+func dial(config *Config) (Conn, error) {
+	timeout, err := config.TimeoutDuration()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := goldap.DialURL(config.URL,
+		goldap.DialWithTLSConfig(tlsConfig),
+		goldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ldap
+
+import (
+	"fmt"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+url: ldaps://dc.example.com
+bind_dn: cn=opkssh,ou=services,dc=example,dc=com
+bind_password: secret
+base_dn: dc=example,dc=com
+groups:
+  - principals: [root, admin]
+    group_dn: cn=ssh-admins,ou=groups,dc=example,dc=com
+    issuer: https://login.example.com
+  - principals: [dev]
+    group_dn: cn=developers,ou=groups,dc=example,dc=com
+    issuer: https://login.example.com
+`
+
+// mockConn answers searches for the members in groups, keyed by group DN
+type mockConn struct {
+	groups  map[string][]string
+	binds   []string
+	filters []string
+	closed  bool
+}
+
+func (c *mockConn) Bind(username, password string) error {
+	c.binds = append(c.binds, username+":"+password)
+	return nil
+}
+
+func (c *mockConn) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	c.filters = append(c.filters, req.Filter)
+	result := &goldap.SearchResult{}
+	for group, members := range c.groups {
+		for _, member := range members {
+			if req.Filter == fmt.Sprintf("(&(mail=%s)(memberOf=%s))", goldap.EscapeFilter(member), goldap.EscapeFilter(group)) {
+				result.Entries = append(result.Entries, &goldap.Entry{DN: "cn=" + member})
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *mockConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	require.NoError(t, err)
+	require.Equal(t, "mail", config.UserAttribute)
+	require.Equal(t, "email", config.IdentityClaim)
+	require.Len(t, config.Groups, 2)
+
+	tests := map[string]string{
+		"url: http://dc.example.com\nbase_dn: dc=x\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]":       "invalid url",
+		"url: ldaps://dc\nstart_tls: true\nbase_dn: dc=x\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]": "start_tls can only be used with ldap://",
+		"url: ldap://dc\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]":                                  "base_dn is required",
+		"url: ldap://dc\nbase_dn: dc=x\nbind_dn: cn=a\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]":    "bind_dn requires bind_password",
+		"url: ldap://dc\nbase_dn: dc=x\ntimeout: soon\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]":    "invalid timeout",
+		"url: ldap://dc\nbase_dn: dc=x\ngroups: [{principals: [root], group_dn: cn=g}]":                                      "group 1: principals, group_dn and issuer are required",
+		"url: ldap://dc\nbase_dn: dc=x\ngroups: []":                                                                          "no groups configured",
+		"url: ldap://dc\nbase_dn: dc=x\nbind_passwd: x\ngroups: [{principals: [root], group_dn: cn=g, issuer: https://i}]":   "field bind_passwd not found",
+	}
+	for content, want := range tests {
+		_, err := ParseConfig([]byte(content))
+		require.ErrorContains(t, err, want, content)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	config, err := LoadConfig(fs, "/etc/opk/ldap.yml")
+	require.NoError(t, err)
+	require.Nil(t, config)
+
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/ldap.yml", []byte(testConfig), 0o644))
+	_, err = LoadConfig(fs, "/etc/opk/ldap.yml")
+	require.ErrorContains(t, err, "failed to read LDAP config /etc/opk/ldap.yml")
+
+	require.NoError(t, fs.Chmod("/etc/opk/ldap.yml", 0o640))
+	config, err = LoadConfig(fs, "/etc/opk/ldap.yml")
+	require.NoError(t, err)
+	require.Equal(t, "dc=example,dc=com", config.BaseDN)
+}
+
+func TestPolicyCheck(t *testing.T) {
+	config, err := ParseConfig([]byte(testConfig))
+	require.NoError(t, err)
+	conn := &mockConn{groups: map[string][]string{
+		"cn=ssh-admins,ou=groups,dc=example,dc=com": {"alice@example.com"},
+		"cn=developers,ou=groups,dc=example,dc=com": {"bob*@example.com"},
+	}}
+	dials := 0
+	p := &Policy{Config: config, Source: "/etc/opk/ldap.yml", Dial: func(config *Config) (Conn, error) {
+		dials++
+		return conn, nil
+	}}
+	alice := map[string][]string{"email": {"alice@example.com"}, "email_verified": {"true"}}
+
+	rule, err := p.Check("admin", "https://login.example.com", alice)
+	require.NoError(t, err)
+	require.Equal(t, "cn=ssh-admins,ou=groups,dc=example,dc=com", rule.GroupDN)
+	require.Equal(t, []string{"cn=opkssh,ou=services,dc=example,dc=com:secret"}, conn.binds)
+	require.True(t, conn.closed)
+
+	rule, err = p.Check("dev", "https://login.example.com", alice)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+
+	// Filter characters in the identity are escaped
+	rule, err = p.Check("dev", "https://login.example.com", map[string][]string{"email": {"bob*@example.com"}, "email_verified": {"true"}})
+	require.NoError(t, err)
+	require.NotNil(t, rule)
+	require.Contains(t, conn.filters[len(conn.filters)-1], `(mail=bob\2a@example.com)`)
+
+	// The server is not queried for other principals or issuers
+	dials = 0
+	rule, err = p.Check("guest", "https://login.example.com", alice)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+	rule, err = p.Check("root", "https://other.example.com", alice)
+	require.NoError(t, err)
+	require.Nil(t, rule)
+	require.Equal(t, 0, dials)
+
+	_, err = p.Check("root", "https://login.example.com", map[string][]string{"sub": {"me"}})
+	require.ErrorContains(t, err, "ID token has no email claim")
+
+	// An email the provider didn't verify is not searched
+	dials = 0
+	_, err = p.Check("root", "https://login.example.com", map[string][]string{"email": {"alice@example.com"}})
+	require.ErrorContains(t, err, "ID token does not assert email_verified for alice@example.com")
+	_, err = p.Check("root", "https://login.example.com", map[string][]string{"email": {"alice@example.com"}, "email_verified": {"false"}})
+	require.ErrorContains(t, err, "does not assert email_verified")
+	require.Equal(t, 0, dials)
+
+	config.Nested = true
+	_, err = p.Check("root", "https://login.example.com", alice)
+	require.NoError(t, err)
+	require.Equal(t, "(&(mail=alice@example.com)(memberOf:1.2.840.113556.1.4.1941:=cn=ssh-admins,ou=groups,dc=example,dc=com))", conn.filters[len(conn.filters)-1])
+}
@@ -0,0 +1,232 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"io/fs"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/openpubkey/opkssh/policy/plugins"
+)
+
+// ManagedPath is a file or directory managed by opkssh and the state
+// opkssh permissions check expects it to be in
+type ManagedPath struct {
+	// Name selects the path on the command line, as with permissions fix
+	// --paths
+	Name string
+	Path string
+	// Perm is the expected owner, group and mode on this platform
+	Perm files.PermInfo
+	// Dir is set if the path is a directory
+	Dir bool
+	// Modes are the modes accepted by check, only Perm.Mode if empty
+	Modes []fs.FileMode
+	// Required makes check report the path when it is missing
+	Required bool
+	// Create makes fix create the path when it is missing
+	Create bool
+	// KeepPerms makes fix leave the owner and mode of a directory alone,
+	// only its entries are changed
+	KeepPerms bool
+	// EntrySuffix and EntryPerm are the names and the expected state of
+	// the files in a directory
+	EntrySuffix string
+	EntryPerm   files.PermInfo
+	// CommandModes, if set, are the modes check accepts for the commands
+	// run by the entries, which are policy plugin configs. The commands
+	// must be owned by the owner of the entries.
+	CommandModes []fs.FileMode
+	// SELinuxType is the SELinux type sshd needs the path to have where
+	// SELinux is enabled
+	SELinuxType string
+}
+
+// AllowedModes returns the modes check accepts for the path
+func (m ManagedPath) AllowedModes() []fs.FileMode {
+	if len(m.Modes) > 0 {
+		return m.Modes
+	}
+	return []fs.FileMode{m.Perm.Mode}
+}
+
+// SetSecureState creates the path if it is missing and gives it the
+// permissions Perm, see files.SetSecureFileState
+func (m ManagedPath) SetSecureState(fsys files.FileSystem) error {
+	return files.SetSecureFileState(fsys, m.Path, m.Perm, m.Dir)
+}
+
+// ManagedPaths returns the paths managed by opkssh, in the order they are
+// checked and fixed. A new file or directory opkssh relies on must be added
+// here so that permissions check and fix cover it.
+func ManagedPaths() []ManagedPath {
+	return []ManagedPath{
+		{
+			Name:        "policy",
+			Path:        SystemDefaultPolicyPath,
+			Perm:        files.RequiredPerms.SystemPolicy,
+			Required:    true,
+			Create:      true,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			// Read by verify, which runs as the AuthorizedKeysCommandUser
+			Name:        "auth_id.d",
+			Path:        SystemDefaultIssuerPolicyDir,
+			Perm:        files.RequiredPerms.IssuerPolicyDir,
+			Dir:         true,
+			EntrySuffix: FragmentExt,
+			EntryPerm:   files.RequiredPerms.SystemPolicy,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			Name:        "providers",
+			Path:        SystemDefaultProvidersPath,
+			Perm:        files.RequiredPerms.Providers,
+			Required:    true,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			Name:        "providers.yml",
+			Path:        ProvidersYAMLPath(SystemDefaultProvidersPath),
+			Perm:        files.RequiredPerms.Providers,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			Name:        "config",
+			Path:        SystemDefaultServerConfigPath,
+			Perm:        files.RequiredPerms.Config,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			// Holds the bind password of the LDAP group policy
+			Name:        "ldap",
+			Path:        SystemDefaultLDAPConfigPath,
+			Perm:        files.RequiredPerms.Config,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			// Read by opkssh ca sign, which runs as root
+			Name:        "ca_key",
+			Path:        SystemDefaultCAKeyPath,
+			Perm:        files.RequiredPerms.CAKey,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			// Read by sshd as TrustedUserCAKeys
+			Name:        "ca_keys.pub",
+			Path:        SystemDefaultCAPublicKeysPath,
+			Perm:        files.RequiredPerms.CAPublicKeys,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			// Used instead of the policy file when policy_store is sqlite
+			Name:        "policy.db",
+			Path:        SystemDefaultPolicyDBPath,
+			Perm:        files.RequiredPerms.PolicyDB,
+			SELinuxType: files.SELinuxConfigType,
+		},
+		{
+			Name:         "policy.d",
+			Path:         GetPluginPolicyDir(),
+			Perm:         files.RequiredPerms.PluginsDir,
+			Dir:          true,
+			Modes:        plugins.RequiredPolicyDirPerms(),
+			Required:     true,
+			Create:       true,
+			KeepPerms:    true,
+			EntrySuffix:  ".yml",
+			EntryPerm:    files.RequiredPerms.PluginFile,
+			CommandModes: plugins.RequiredPolicyCmdPerms(),
+			SELinuxType:  files.SELinuxConfigType,
+		},
+		{
+			// Created by the commands that write state
+			Name:        "state",
+			Path:        GetSystemStateBasePath(),
+			Perm:        files.RequiredPerms.StateDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by verify, which runs as the AuthorizedKeysCommandUser
+			Name:        "jwks-cache",
+			Path:        JWKSCacheDir(),
+			Perm:        files.RequiredPerms.JWKSCacheDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by verify, like the JWKS cache
+			Name:        "ratelimit",
+			Path:        RateLimitDir(),
+			Perm:        files.RequiredPerms.RateLimitDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by verify, holds the replay cache
+			Name:        "replay",
+			Path:        ReplayCacheDir(),
+			Perm:        files.RequiredPerms.ReplayCacheDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by every command when telemetry is enabled, verify
+			// included
+			Name:        "telemetry",
+			Path:        TelemetryDir(),
+			Perm:        files.RequiredPerms.TelemetryDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by verify for policy plugins with a cache_ttl
+			Name:        "plugin-cache",
+			Path:        GetPluginCacheDir(),
+			Perm:        files.RequiredPerms.PluginCacheDir,
+			Dir:         true,
+			Create:      true,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by the sync and fleet commands, read by verify
+			Name:        "fragments",
+			Path:        SystemDefaultFragmentDir,
+			Perm:        files.RequiredPerms.FragmentDir,
+			Dir:         true,
+			EntrySuffix: FragmentExt,
+			EntryPerm:   files.RequiredPerms.SystemPolicy,
+			SELinuxType: files.SELinuxStateType,
+		},
+		{
+			// Written by the commands that change the system policy. The
+			// backups keep the owner and mode of the file they copy.
+			Name:        "backups",
+			Path:        SystemDefaultBackupDir,
+			Perm:        files.RequiredPerms.BackupDir,
+			Dir:         true,
+			SELinuxType: files.SELinuxStateType,
+		},
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedPaths(t *testing.T) {
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for _, mp := range ManagedPaths() {
+		require.False(t, names[mp.Name], "duplicate name %s", mp.Name)
+		require.False(t, paths[mp.Path], "duplicate path %s", mp.Path)
+		names[mp.Name], paths[mp.Path] = true, true
+		require.NotEmpty(t, mp.Perm.Owner, mp.Name)
+		require.NotEmpty(t, mp.AllowedModes(), mp.Name)
+		if mp.EntrySuffix != "" {
+			require.True(t, mp.Dir, mp.Name)
+		}
+	}
+	require.True(t, paths[SystemDefaultPolicyPath])
+	require.True(t, paths[GetPluginPolicyDir()])
+	require.True(t, paths[GetSystemStateBasePath()])
+}
@@ -18,8 +18,10 @@ package policy
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"strings"
+
+	"github.com/openpubkey/opkssh/policy/files"
 )
 
 var _ Loader = &MultiPolicyLoader{
@@ -40,6 +42,8 @@ func NewMultiPolicyLoader(username string, loader OptionalLoader) *MultiPolicyLo
 		SystemPolicyLoader: NewSystemPolicyLoader(),
 		LoaderScript:       loader,
 		Username:           username,
+		Fragments:          NewFragmentStore(),
+		IssuerPolicies:     NewIssuerPolicyStore(),
 	}
 }
 
@@ -51,6 +55,33 @@ type MultiPolicyLoader struct {
 	SystemPolicyLoader *SystemPolicyLoader
 	LoaderScript       OptionalLoader
 	Username           string
+	// HomePolicyAccess selects the users whose home policy is read. The
+	// zero value reads the user policy of every user.
+	HomePolicyAccess HomePolicyAccess
+	// Groups resolves the groups of HomePolicyAccess, nil uses
+	// NewOsGroupLookup
+	Groups GroupLookup
+	// HomePolicyConstraints limits what the user policy is allowed to grant.
+	// The zero value places no restrictions on the user policy.
+	HomePolicyConstraints HomePolicyConstraints
+	// GrantQuota limits how many identities the user policy may admit. The
+	// zero value places no limits on the user policy.
+	GrantQuota GrantQuota
+	// Fragments, if set, are generated policies loaded alongside the system
+	// policy
+	Fragments *FragmentStore
+	// IssuerPolicies, if set, are the per-issuer system policies loaded
+	// alongside the system policy
+	IssuerPolicies *IssuerPolicyStore
+	// Trace, if set, is called with each policy file read, used by opkssh
+	// verify --explain
+	Trace func(format string, args ...any)
+}
+
+func (l *MultiPolicyLoader) trace(format string, args ...any) {
+	if l.Trace != nil {
+		l.Trace(format, args...)
+	}
 }
 
 func (l *MultiPolicyLoader) Load() (*Policy, Source, error) {
@@ -59,22 +90,75 @@ func (l *MultiPolicyLoader) Load() (*Policy, Source, error) {
 	// Try to load the root policy
 	rootPolicy, _, rootPolicyErr := l.SystemPolicyLoader.LoadSystemPolicy()
 	if rootPolicyErr != nil {
-		log.Println("warning: failed to load system default policy:", rootPolicyErr)
+		slog.Warn("Failed to load the system policy", "error", rootPolicyErr)
+		l.trace("Failed to read the system policy: %v", rootPolicyErr)
+	} else {
+		l.trace("Read the system policy %s: %d entries", SystemDefaultPolicyPath, len(rootPolicy.Users))
 	}
 
 	// Try to load the user policy
-	userPolicy, userPolicyFilePath, userPolicyErr := l.HomePolicyLoader.LoadHomePolicy(l.Username, true, l.LoaderScript)
+	var userPolicy *Policy
+	var userPolicyFilePath string
+	userPolicyErr := l.HomePolicyAccess.Check(l.Username, l.Groups)
 	if userPolicyErr != nil {
-		log.Println("warning: failed to load user policy:", userPolicyErr)
+		l.trace("Skipped the user policy of %s: %v", l.Username, userPolicyErr)
+	} else if userPolicy, userPolicyFilePath, userPolicyErr = l.HomePolicyLoader.LoadHomePolicy(l.Username, true, l.LoaderScript); userPolicyErr != nil {
+		slog.Warn("Failed to load the user policy", "error", userPolicyErr)
+		l.trace("Failed to read the user policy of %s: %v", l.Username, userPolicyErr)
+	} else if !l.HomePolicyConstraints.IsEmpty() {
+		var problems []files.ConfigProblem
+		userPolicy, problems = l.HomePolicyConstraints.Apply(userPolicy, userPolicyFilePath)
+		for _, problem := range problems {
+			slog.Warn("Ignoring user policy entry", "problem", problem.String())
+			l.trace("Ignoring a user policy entry: %s", problem.String())
+		}
+	}
+	if userPolicyErr == nil && !l.GrantQuota.IsEmpty() {
+		if err := l.GrantQuota.Check(l.Username, userPolicy); err != nil {
+			slog.Warn("Ignoring the user policy", "error", err)
+			l.trace("Ignoring the user policy: %v", err)
+			userPolicy, userPolicyErr = nil, err
+		}
+	}
+	if userPolicyErr == nil {
+		l.trace("Read the user policy %s: %d entries", userPolicyFilePath, len(userPolicy.Users))
 	}
 	// Log warning if no error loading, but userPolicy is empty meaning that
 	// there are no valid entries
 	if userPolicyErr == nil && len(userPolicy.Users) == 0 {
-		log.Printf("warning: user policy %s has no valid user entries; an entry is considered valid if it gives %s access.", userPolicyFilePath, l.Username)
+		slog.Warn("User policy has no entries that give the user access", "path", userPolicyFilePath, "user", l.Username)
 	}
 
-	// Failed to read both policies. Return multi-error
-	if rootPolicy == nil && userPolicy == nil {
+	var fragments *Policy
+	var fragmentPaths []string
+	if l.Fragments != nil {
+		var err error
+		if fragments, fragmentPaths, err = l.Fragments.Load(); err != nil {
+			slog.Warn("Failed to load the policy fragments", "error", err)
+			l.trace("Failed to read the policy fragments: %v", err)
+		} else if len(fragmentPaths) > 0 {
+			l.trace("Read the policy fragments %s: %d entries", strings.Join(fragmentPaths, ", "), len(fragments.Users))
+		} else {
+			fragments = nil
+		}
+	}
+
+	var issuerPolicies *Policy
+	var issuerPolicyPaths []string
+	if l.IssuerPolicies != nil {
+		var err error
+		if issuerPolicies, issuerPolicyPaths, err = l.IssuerPolicies.Load(); err != nil {
+			slog.Warn("Failed to load the issuer policies", "error", err)
+			l.trace("Failed to read the issuer policies: %v", err)
+		} else if len(issuerPolicyPaths) > 0 {
+			l.trace("Read the issuer policies %s: %d entries", strings.Join(issuerPolicyPaths, ", "), len(issuerPolicies.Users))
+		} else {
+			issuerPolicies = nil
+		}
+	}
+
+	// Failed to read every policy. Return multi-error
+	if rootPolicy == nil && userPolicy == nil && fragments == nil && issuerPolicies == nil {
 		return nil, EmptySource{}, errors.Join(rootPolicyErr, userPolicyErr)
 	}
 
@@ -85,6 +169,14 @@ func (l *MultiPolicyLoader) Load() (*Policy, Source, error) {
 		policy.Users = append(policy.Users, rootPolicy.Users...)
 		readPaths = append(readPaths, SystemDefaultPolicyPath)
 	}
+	if issuerPolicies != nil {
+		policy.Users = append(policy.Users, issuerPolicies.Users...)
+		readPaths = append(readPaths, issuerPolicyPaths...)
+	}
+	if fragments != nil {
+		policy.Users = append(policy.Users, fragments.Users...)
+		readPaths = append(readPaths, fragmentPaths...)
+	}
 	if userPolicy != nil {
 		policy.Users = append(policy.Users, userPolicy.Users...)
 		readPaths = append(readPaths, userPolicyFilePath)
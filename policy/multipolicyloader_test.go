@@ -239,6 +239,39 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestLoadHomePolicyAccess(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mockFs, policy.SystemDefaultPolicyPath, []byte("root alice@example.com https://example.com\n"), 0640))
+	homePolicyPath := filepath.Join(ValidUser.HomeDir, ".opk", "auth_id")
+	require.NoError(t, afero.WriteFile(mockFs, homePolicyPath, []byte(ValidUser.Username+" bob@example.com https://example.com\n"), 0600))
+
+	var traces []string
+	loader := &policy.MultiPolicyLoader{
+		HomePolicyLoader:   NewTestHomePolicyLoader(mockFs, &MockUserLookup{User: ValidUser}),
+		SystemPolicyLoader: NewTestSystemPolicyLoader(mockFs, &MockUserLookup{User: ValidUser}),
+		LoaderScript:       MockTestSudoScript,
+		Username:           ValidUser.Username,
+		HomePolicyAccess:   policy.HomePolicyAccess{AllowedPrincipals: []string{ValidUser.Username}},
+		Trace:              func(format string, args ...any) { traces = append(traces, fmt.Sprintf(format, args...)) },
+	}
+	p, source, err := loader.Load()
+	require.NoError(t, err)
+	require.Len(t, p.Users, 2)
+	require.Equal(t, policy.SystemDefaultPolicyPath+", "+homePolicyPath, source.Source())
+
+	// The home policy is not read at all
+	loader.HomePolicyAccess = policy.HomePolicyAccess{Disabled: true}
+	loader.LoaderScript = func(*policy.HomePolicyLoader, string) ([]byte, error) {
+		t.Fatal("home policy read")
+		return nil, nil
+	}
+	p, source, err = loader.Load()
+	require.NoError(t, err)
+	require.Len(t, p.Users, 1)
+	require.Equal(t, policy.SystemDefaultPolicyPath, source.Source())
+	require.Contains(t, traces, "Skipped the user policy of "+ValidUser.Username+": home policies are disabled")
+}
+
 func MockTestSudoScript(_ *policy.HomePolicyLoader, username string) ([]byte, error) {
 	return []byte{}, fmt.Errorf("mock error")
 }
@@ -0,0 +1,106 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The base paths can be changed at build time for distributions with a
+// different layout, e.g. to keep state outside of /var/lib/opk:
+//
+//	go build -ldflags "-X github.com/openpubkey/opkssh/policy.systemStateBasePath=/var/lib/opkssh"
+//
+// They are build time settings since every opkssh command, including verify
+// run by sshd with an empty environment, must agree on them. Empty uses the
+// platform default.
+var (
+	systemConfigBasePath string
+	vendorConfigBasePath string
+	systemStateBasePath  string
+)
+
+// GetSystemConfigBasePath returns the base path for system opkssh
+// configuration. This is /etc/opk on Unix-like systems and %ProgramData%\opk
+// on Windows.
+func GetSystemConfigBasePath() string {
+	if systemConfigBasePath != "" {
+		return systemConfigBasePath
+	}
+	return defaultSystemConfigBasePath()
+}
+
+// GetVendorConfigBasePath returns the base path of read-only configuration
+// shipped by the distribution, /usr/lib/opk on Unix-like systems. Files in
+// GetSystemConfigBasePath override the file of the same name here. Empty if
+// the platform has no distribution defaults.
+func GetVendorConfigBasePath() string {
+	if vendorConfigBasePath != "" {
+		return vendorConfigBasePath
+	}
+	return defaultVendorConfigBasePath()
+}
+
+// GetSystemStateBasePath returns the base path for state written by opkssh,
+// such as the policy journal. This is /var/lib/opk on Unix-like systems and
+// %ProgramData%\opk\state on Windows.
+func GetSystemStateBasePath() string {
+	if systemStateBasePath != "" {
+		return systemStateBasePath
+	}
+	return defaultSystemStateBasePath()
+}
+
+// TelemetryDir returns the directory where the usage counts of the server
+// are kept until they are sent, see the telemetry section of the server
+// config
+func TelemetryDir() string {
+	return filepath.Join(GetSystemStateBasePath(), "telemetry")
+}
+
+// SystemConfigPath returns the path of the system configuration file (or
+// directory) name. The file in GetSystemConfigBasePath is used if it exists
+// and otherwise the distribution default in GetVendorConfigBasePath, if that
+// exists.
+func SystemConfigPath(name string) string {
+	path := filepath.Join(GetSystemConfigBasePath(), name)
+	vendorBase := GetVendorConfigBasePath()
+	if vendorBase == "" {
+		return path
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return path
+	}
+	vendorPath := filepath.Join(vendorBase, name)
+	if _, err := os.Lstat(vendorPath); err == nil {
+		return vendorPath
+	}
+	return path
+}
+
+// IsVendorConfigPath returns true if path is a read-only distribution default
+// in GetVendorConfigBasePath
+func IsVendorConfigPath(path string) bool {
+	vendorBase := GetVendorConfigBasePath()
+	if vendorBase == "" {
+		return false
+	}
+	rel, err := filepath.Rel(vendorBase, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,65 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestConfigBasePaths(t *testing.T) (string, string) {
+	etc := t.TempDir()
+	vendor := t.TempDir()
+	oldSystem, oldVendor := systemConfigBasePath, vendorConfigBasePath
+	systemConfigBasePath, vendorConfigBasePath = etc, vendor
+	t.Cleanup(func() {
+		systemConfigBasePath, vendorConfigBasePath = oldSystem, oldVendor
+	})
+	return etc, vendor
+}
+
+func TestSystemConfigPath(t *testing.T) {
+	etc, vendor := setTestConfigBasePaths(t)
+
+	// Neither exists, the writable path is used
+	require.Equal(t, filepath.Join(etc, "auth_id"), SystemConfigPath("auth_id"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(vendor, "auth_id"), []byte{}, 0o640))
+	require.Equal(t, filepath.Join(vendor, "auth_id"), SystemConfigPath("auth_id"))
+	require.True(t, IsVendorConfigPath(SystemConfigPath("auth_id")))
+
+	// The system config directory overrides the distribution default
+	require.NoError(t, os.WriteFile(filepath.Join(etc, "auth_id"), []byte{}, 0o640))
+	require.Equal(t, filepath.Join(etc, "auth_id"), SystemConfigPath("auth_id"))
+	require.False(t, IsVendorConfigPath(SystemConfigPath("auth_id")))
+
+	require.False(t, IsVendorConfigPath(vendor+"-other"))
+	require.False(t, IsVendorConfigPath(filepath.Join(vendor, "..", "auth_id")))
+}
+
+func TestPolicyLoaderDumpRefusesVendorPath(t *testing.T) {
+	_, vendor := setTestConfigBasePaths(t)
+
+	loader := &PolicyLoader{FileLoader: files.FileLoader{Fs: afero.NewMemMapFs(), RequiredPerm: files.ModeSystemPerms}}
+	err := loader.Dump(&Policy{}, filepath.Join(vendor, "auth_id"))
+	require.ErrorContains(t, err, "read-only distribution default")
+}
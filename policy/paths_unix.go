@@ -19,8 +19,17 @@
 
 package policy
 
-// GetSystemConfigBasePath returns the base path for system opkssh configuration.
-// On Unix-like systems, this is /etc/opk
-func GetSystemConfigBasePath() string {
+// defaultSystemConfigBasePath is /etc/opk on Unix-like systems
+func defaultSystemConfigBasePath() string {
 	return "/etc/opk"
 }
+
+// defaultVendorConfigBasePath is /usr/lib/opk on Unix-like systems
+func defaultVendorConfigBasePath() string {
+	return "/usr/lib/opk"
+}
+
+// defaultSystemStateBasePath is /var/lib/opk on Unix-like systems
+func defaultSystemStateBasePath() string {
+	return "/var/lib/opk"
+}
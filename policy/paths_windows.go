@@ -24,9 +24,9 @@ import (
 	"path/filepath"
 )
 
-// GetSystemConfigBasePath returns the base path for system opkssh configuration.
-// On Windows, this is %ProgramData%\opk (typically C:\ProgramData\opk)
-func GetSystemConfigBasePath() string {
+// defaultSystemConfigBasePath is %ProgramData%\opk (typically
+// C:\ProgramData\opk) on Windows
+func defaultSystemConfigBasePath() string {
 	programData := os.Getenv("ProgramData")
 	if programData == "" {
 		// Fallback to default if ProgramData is not set
@@ -34,3 +34,14 @@ func GetSystemConfigBasePath() string {
 	}
 	return filepath.Join(programData, "opk")
 }
+
+// defaultVendorConfigBasePath is empty on Windows, there are no
+// distribution defaults
+func defaultVendorConfigBasePath() string {
+	return ""
+}
+
+// defaultSystemStateBasePath is %ProgramData%\opk\state on Windows
+func defaultSystemStateBasePath() string {
+	return filepath.Join(GetSystemConfigBasePath(), "state")
+}
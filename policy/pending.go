@@ -0,0 +1,177 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SystemDefaultPendingDir is the default directory where policy changes
+// awaiting approval are staged
+var SystemDefaultPendingDir = filepath.Join(GetSystemStateBasePath(), "pending")
+
+var validPendingID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// PendingChange is a proposed policy entry that must be approved by a
+// different admin before it is added to the system policy
+type PendingChange struct {
+	ID        string `json:"id"`
+	Principal string `json:"principal"`
+	Identity  string `json:"identity"`
+	Issuer    string `json:"issuer"`
+	// Expires is the expiry of the entry as written in the policy, empty
+	// if it never expires
+	Expires    string    `json:"expires,omitempty"`
+	ProposedBy string    `json:"proposed_by"`
+	ProposedAt time.Time `json:"proposed_at"`
+}
+
+// PendingStore stores each PendingChange as a JSON file in Dir
+type PendingStore struct {
+	Fs  afero.Fs
+	Dir string
+}
+
+// NewPendingStore returns a PendingStore at SystemDefaultPendingDir on the
+// OS filesystem
+func NewPendingStore() *PendingStore {
+	return &PendingStore{
+		Fs:  afero.NewOsFs(),
+		Dir: SystemDefaultPendingDir,
+	}
+}
+
+// loginUIDPath is where Linux records the user that logged in, which sudo
+// and su don't change
+var loginUIDPath = "/proc/self/loginuid"
+
+// unsetLoginUID is the login UID of processes not started by a login
+const unsetLoginUID = "4294967295"
+
+// CurrentActor returns the name of the person running opkssh. This is the
+// user that logged in, as recorded by the kernel on Linux, so that two
+// admins using sudo are told apart. SUDO_USER isn't used as whoever runs
+// sudo can set it. Elsewhere, or without a login UID, it is the real user.
+func CurrentActor() string {
+	if content, err := os.ReadFile(loginUIDPath); err == nil {
+		if uid := strings.TrimSpace(string(content)); uid != "" && uid != unsetLoginUID {
+			if u, err := user.LookupId(uid); err == nil {
+				return u.Username
+			}
+			return uid
+		}
+	}
+	// The real user, not the effective one
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// Propose stages change and returns it with its ID and proposed time set
+func (s *PendingStore) Propose(change PendingChange) (PendingChange, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return PendingChange{}, err
+	}
+	change.ID = hex.EncodeToString(idBytes)
+	if change.ProposedAt.IsZero() {
+		change.ProposedAt = time.Now().UTC()
+	}
+
+	changeBytes, err := json.MarshalIndent(change, "", "  ")
+	if err != nil {
+		return PendingChange{}, err
+	}
+	if err := s.Fs.MkdirAll(s.Dir, 0700); err != nil {
+		return PendingChange{}, fmt.Errorf("failed to create pending directory: %w", err)
+	}
+	if err := afero.WriteFile(s.Fs, s.path(change.ID), changeBytes, 0600); err != nil {
+		return PendingChange{}, fmt.Errorf("failed to stage change: %w", err)
+	}
+	return change, nil
+}
+
+// Get returns the pending change with id
+func (s *PendingStore) Get(id string) (PendingChange, error) {
+	if !validPendingID.MatchString(id) {
+		return PendingChange{}, fmt.Errorf("invalid pending change id %q", id)
+	}
+	changeBytes, err := afero.ReadFile(s.Fs, s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return PendingChange{}, fmt.Errorf("no pending change with id %s", id)
+		}
+		return PendingChange{}, err
+	}
+	var change PendingChange
+	if err := json.Unmarshal(changeBytes, &change); err != nil {
+		return PendingChange{}, fmt.Errorf("failed to parse pending change %s: %w", id, err)
+	}
+	return change, nil
+}
+
+// List returns all pending changes, oldest first
+func (s *PendingStore) List() ([]PendingChange, error) {
+	entries, err := afero.ReadDir(s.Fs, s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []PendingChange{}, nil
+		}
+		return nil, err
+	}
+	changes := []PendingChange{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		change, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ProposedAt.Before(changes[j].ProposedAt)
+	})
+	return changes, nil
+}
+
+// Remove deletes the pending change with id
+func (s *PendingStore) Remove(id string) error {
+	if !validPendingID.MatchString(id) {
+		return fmt.Errorf("invalid pending change id %q", id)
+	}
+	return s.Fs.Remove(s.path(id))
+}
+
+func (s *PendingStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
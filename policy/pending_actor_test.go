@@ -0,0 +1,47 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCurrentActor(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+	orig := loginUIDPath
+	defer func() { loginUIDPath = orig }()
+	t.Setenv("SUDO_USER", "mallory")
+
+	// The login UID is used, SUDO_USER is ignored
+	loginUIDPath = filepath.Join(t.TempDir(), "loginuid")
+	require.NoError(t, os.WriteFile(loginUIDPath, []byte(current.Uid), 0o600))
+	require.Equal(t, current.Username, CurrentActor())
+	require.NoError(t, os.WriteFile(loginUIDPath, []byte("4000000"), 0o600))
+	require.Equal(t, "4000000", CurrentActor())
+
+	// Without a login UID it is the real user
+	require.NoError(t, os.WriteFile(loginUIDPath, []byte(unsetLoginUID), 0o600))
+	require.Equal(t, current.Username, CurrentActor())
+	loginUIDPath = filepath.Join(t.TempDir(), "missing")
+	require.Equal(t, current.Username, CurrentActor())
+}
@@ -0,0 +1,77 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingStore(t *testing.T) {
+	t.Parallel()
+	store := &policy.PendingStore{Fs: afero.NewMemMapFs(), Dir: "/var/lib/opk/pending"}
+
+	changes, err := store.List()
+	require.NoError(t, err)
+	require.Empty(t, changes)
+
+	first, err := store.Propose(policy.PendingChange{
+		Principal:  "root",
+		Identity:   "alice@example.com",
+		Issuer:     "https://accounts.google.com",
+		ProposedBy: "alice",
+		ProposedAt: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.Regexp(t, "^[0-9a-f]{16}$", first.ID)
+
+	second, err := store.Propose(policy.PendingChange{
+		Principal:  "root",
+		Identity:   "bob@example.com",
+		Issuer:     "https://accounts.google.com",
+		ProposedBy: "alice",
+		ProposedAt: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, second.ID)
+
+	info, err := store.Fs.Stat(filepath.Join(store.Dir, first.ID+".json"))
+	require.NoError(t, err)
+	require.Equal(t, "-rw-------", info.Mode().Perm().String())
+
+	got, err := store.Get(first.ID)
+	require.NoError(t, err)
+	require.Equal(t, first, got)
+
+	changes, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, second.ID, changes[0].ID, "expected oldest change first")
+
+	require.NoError(t, store.Remove(first.ID))
+	_, err = store.Get(first.ID)
+	require.ErrorContains(t, err, "no pending change")
+
+	_, err = store.Get("../auth_id")
+	require.ErrorContains(t, err, "invalid pending change id")
+	require.ErrorContains(t, store.Remove("../auth_id"), "invalid pending change id")
+}
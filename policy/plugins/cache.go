@@ -0,0 +1,153 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// cacheKeyTokens are the tokens a cached result is for: the identity and
+// the principal it logs in as
+var cacheKeyTokens = []string{"OPKSSH_PLUGIN_ISS", "OPKSSH_PLUGIN_SUB", "OPKSSH_PLUGIN_AUD", "OPKSSH_PLUGIN_U"}
+
+// cacheEntry is the output of a plugin command for one identity and
+// principal
+type cacheEntry struct {
+	Output     string    `json:"output"`
+	Reason     string    `json:"reason,omitempty"`
+	Options    []string  `json:"options,omitempty"`
+	CommandRun []string  `json:"command_run"`
+	Expires    time.Time `json:"expires"`
+}
+
+// pluginCache is the file of cached results of one plugin config, keyed
+// by cacheKey
+type pluginCache struct {
+	Entries map[string]cacheEntry `json:"entries"`
+}
+
+// cacheKey identifies the result of the command of config for the identity
+// and principal in tokens. A change to the command or URL makes it a new key.
+func cacheKey(config PluginConfig, tokens map[string]string) string {
+	parts := []string{config.Name, config.Command}
+	if config.IsWebhook() {
+		parts = append(parts, config.URL)
+	}
+	for _, name := range cacheKeyTokens {
+		parts = append(parts, tokens[name])
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *PolicyPluginEnforcer) cachePath(pluginPath string) string {
+	sum := sha256.Sum256([]byte(pluginPath))
+	return filepath.Join(p.CacheDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+func (p *PolicyPluginEnforcer) loadCache(pluginPath string) (*pluginCache, error) {
+	cache := &pluginCache{Entries: map[string]cacheEntry{}}
+	path := p.cachePath(pluginPath)
+	info, err := p.Fs.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	// Anyone who can write the cache could allow themselves in
+	if info.Mode().Perm()&0o022 != 0 {
+		return nil, fmt.Errorf("policy plugin cache (%s) has insecure permissions %o", path, info.Mode().Perm())
+	}
+	content, err := afero.ReadFile(p.Fs, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, cache); err != nil {
+		// The cache only saves work, start over rather than fail
+		return &pluginCache{Entries: map[string]cacheEntry{}}, nil
+	}
+	if cache.Entries == nil {
+		cache.Entries = map[string]cacheEntry{}
+	}
+	return cache, nil
+}
+
+// cachedResult returns the unexpired result of the command of the plugin
+// config at pluginPath for key
+func (p *PolicyPluginEnforcer) cachedResult(pluginPath string, key string) (cacheEntry, bool, error) {
+	cache, err := p.loadCache(pluginPath)
+	if err != nil {
+		return cacheEntry{}, false, err
+	}
+	entry, ok := cache.Entries[key]
+	if !ok || !p.now().Before(entry.Expires) {
+		return cacheEntry{}, false, nil
+	}
+	return entry, true, nil
+}
+
+// cacheResult saves entry for key and drops the expired entries
+func (p *PolicyPluginEnforcer) cacheResult(pluginPath string, key string, entry cacheEntry) error {
+	cache, err := p.loadCache(pluginPath)
+	if err != nil {
+		return err
+	}
+	now := p.now()
+	for k, e := range cache.Entries {
+		if !now.Before(e.Expires) {
+			delete(cache.Entries, k)
+		}
+	}
+	cache.Entries[key] = entry
+
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := p.Fs.MkdirAll(p.CacheDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create policy plugin cache directory: %w", err)
+	}
+	// Concurrent logins may both write, the last one wins and the other
+	// result is only run again
+	path := p.cachePath(pluginPath)
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := afero.WriteFile(p.Fs, tmp, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write policy plugin cache: %w", err)
+	}
+	if err := p.Fs.Rename(tmp, path); err != nil {
+		_ = p.Fs.Remove(tmp)
+		return fmt.Errorf("failed to write policy plugin cache: %w", err)
+	}
+	return nil
+}
+
+func (p *PolicyPluginEnforcer) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
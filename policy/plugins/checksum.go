@@ -0,0 +1,72 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// ValidateSHA256 returns an error if the sha256 of the plugin config isn't
+// a hex SHA-256 digest, or is set on a webhook
+func (c PluginConfig) ValidateSHA256() error {
+	if c.SHA256 == "" {
+		return nil
+	}
+	if c.IsWebhook() {
+		return fmt.Errorf("sha256 is only for commands, not webhooks")
+	}
+	if raw, err := hex.DecodeString(c.SHA256); err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("invalid sha256 %q, expected the 64 hex digits printed by sha256sum", c.SHA256)
+	}
+	return nil
+}
+
+// CheckCommandSHA256 returns an error if the SHA-256 of the file at path is
+// not want
+func CheckCommandSHA256(fsys afero.Fs, path string, want string) error {
+	f, err := openCommandSHA256(fsys, path, want)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openCommandSHA256 opens the file at path and returns it if its SHA-256 is
+// want. Running the returned file with pinnedCommandPath runs the content
+// that was hashed, even if the file at path is replaced in between.
+func openCommandSHA256(fsys afero.Fs, path string, want string) (afero.File, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read policy plugin command (%s): %w", path, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(want) {
+		f.Close()
+		return nil, fmt.Errorf("policy plugin command (%s) has sha256 %s, the plugin config expects %s, refusing to run it", path, got, strings.ToLower(want))
+	}
+	return f, nil
+}
@@ -0,0 +1,56 @@
+package plugins
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginCommandSHA256(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/policy-cmd", []byte("#!/bin/sh\n"), 0755))
+	configs := map[string]string{
+		"a-pinned.yml":   "name: pinned\ncommand: /usr/bin/local/opk/policy-cmd\nsha256: a8076d3d28d21e02012b20eaf7dbf75409a6277134439025f282e368e3305abf\n",
+		"b-tampered.yml": "name: tampered\ncommand: /usr/bin/local/opk/policy-cmd\nsha256: " + strings.Repeat("0", 64) + "\n",
+		"c-invalid.yml":  "name: invalid\ncommand: /usr/bin/local/opk/policy-cmd\nsha256: not-a-digest\n",
+		"d-webhook.yml":  "name: webhook\ntype: webhook\nurl: https://example.com\nsha256: a8076d3d28d21e02012b20eaf7dbf75409a6277134439025f282e368e3305abf\n",
+	}
+	for name, content := range configs {
+		require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, name), []byte(content), 0640))
+	}
+
+	var ran int
+	enforcer := &PolicyPluginEnforcer{
+		Fs: mockFs,
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			ran++
+			return []byte("allow"), nil
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+
+	res, err := enforcer.checkPolicies(tempDir, map[string]string{})
+	require.NoError(t, err)
+	require.Len(t, res, 4)
+	require.NoError(t, res[0].Error)
+	require.True(t, res[0].Allowed)
+
+	// The tampered command is not run
+	require.Equal(t, 1, ran)
+	require.False(t, res[1].Allowed)
+	require.ErrorContains(t, res[1].Error, "policy plugin command (/usr/bin/local/opk/policy-cmd) has sha256 a8076d3d")
+	require.ErrorContains(t, res[1].Error, "refusing to run it")
+	require.ErrorContains(t, res[2].Error, `invalid sha256 "not-a-digest"`)
+	require.ErrorContains(t, res[3].Error, "sha256 is only for commands")
+}
@@ -0,0 +1,122 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ClaimEnvPrefix is the prefix of the variables of the claims listed in a
+// plugin config
+const ClaimEnvPrefix = "OPKSSH_PLUGIN_CLAIM_"
+
+// ClaimEnvName returns the variable a claim is exported as: the claim in
+// upper case with every character other than a letter or digit replaced by _
+func ClaimEnvName(claim string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, claim)
+	return ClaimEnvPrefix + name
+}
+
+// ValidateClaims returns why the claims of the config can't be exported
+func (c PluginConfig) ValidateClaims() error {
+	names := map[string]string{}
+	for _, claim := range c.Claims {
+		if claim == "" || strings.HasPrefix(claim, ".") || strings.HasSuffix(claim, ".") || strings.Contains(claim, "..") {
+			return fmt.Errorf("invalid claim %q, expected a claim name or a dot-separated path such as realm_access.roles", claim)
+		}
+		name := ClaimEnvName(claim)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("claims %q and %q are both exported as %s", other, claim, name)
+		}
+		names[name] = claim
+	}
+	return nil
+}
+
+// lookupClaim returns the value of claim in the ID Token payload. A claim
+// that is a top-level key, such as https://example.com/groups, wins over the
+// dot-separated path.
+func lookupClaim(payload map[string]any, claim string) (any, bool) {
+	if value, ok := payload[claim]; ok {
+		return value, true
+	}
+	var value any = payload
+	for _, key := range strings.Split(claim, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// claimEnvVars returns the variables of the claims of config in the ID Token
+// payload of tokens. Strings are exported as is and everything else as
+// JSON. A missing claim is the empty string, as other missing claims are.
+func claimEnvVars(config PluginConfig, tokens map[string]string) (map[string]string, error) {
+	if len(config.Claims) == 0 {
+		return nil, nil
+	}
+	payloadJson, err := base64.StdEncoding.DecodeString(tokens["OPKSSH_PLUGIN_PAYLOAD"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ID Token payload: %w", err)
+	}
+	payload := map[string]any{}
+	if len(payloadJson) > 0 {
+		// Numbers are kept as they were written, large ones don't lose digits
+		dec := json.NewDecoder(bytes.NewReader(payloadJson))
+		dec.UseNumber()
+		if err := dec.Decode(&payload); err != nil {
+			return nil, fmt.Errorf("error unmarshalling ID Token payload: %w", err)
+		}
+	}
+
+	vars := map[string]string{}
+	for _, claim := range config.Claims {
+		value, _ := lookupClaim(payload, claim)
+		switch v := value.(type) {
+		case string:
+			vars[ClaimEnvName(claim)] = v
+		case nil:
+			// Missing or null
+			vars[ClaimEnvName(claim)] = ""
+		default:
+			valueJson, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			vars[ClaimEnvName(claim)] = string(valueJson)
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimEnvName(t *testing.T) {
+	require.Equal(t, "OPKSSH_PLUGIN_CLAIM_PREFERRED_USERNAME", ClaimEnvName("preferred_username"))
+	require.Equal(t, "OPKSSH_PLUGIN_CLAIM_REALM_ACCESS_ROLES", ClaimEnvName("realm_access.roles"))
+	require.Equal(t, "OPKSSH_PLUGIN_CLAIM_HTTPS___EXAMPLE_COM_GROUPS", ClaimEnvName("https://example.com/groups"))
+
+	require.NoError(t, PluginConfig{Claims: []string{"groups", "realm_access.roles"}}.ValidateClaims())
+	require.ErrorContains(t, PluginConfig{Claims: []string{"realm_access..roles"}}.ValidateClaims(), "invalid claim")
+	require.ErrorContains(t, PluginConfig{Claims: []string{""}}.ValidateClaims(), "invalid claim")
+	require.ErrorContains(t, PluginConfig{Claims: []string{"a.b", "a_b"}}.ValidateClaims(), `claims "a.b" and "a_b" are both exported as OPKSSH_PLUGIN_CLAIM_A_B`)
+}
+
+func TestPluginClaims(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/policy-cmd", []byte(""), 0755))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "claims.yml"), []byte(`
+name: Claims
+command: /usr/bin/local/opk/policy-cmd
+claims:
+  - preferred_username
+  - email_verified
+  - groups
+  - realm_access.roles
+  - https://example.com/tenant
+  - exp
+  - missing.claim`), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "plain.yml"), []byte(`
+name: No claims
+command: /usr/bin/local/opk/policy-cmd`), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "invalid.yml"), []byte(`
+name: Invalid claims
+command: /usr/bin/local/opk/policy-cmd
+claims: [".groups"]`), 0640))
+
+	envs := map[int][]string{}
+	enforcer := &PolicyPluginEnforcer{
+		Fs:      mockFs,
+		Workers: 1,
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			envs[len(envs)] = env
+			return []byte("allow"), nil
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+
+	payload := `{"preferred_username":"alice","email_verified":true,"groups":["dev","ops"],` +
+		`"realm_access":{"roles":["admin"]},"https://example.com/tenant":"acme","exp":17000000000000000001}`
+	tokens := map[string]string{"OPKSSH_PLUGIN_PAYLOAD": b64(payload)}
+	res, err := enforcer.checkPolicies(tempDir, tokens)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	require.NoError(t, res[0].Error)
+	require.ErrorContains(t, res[1].Error, `invalid claim ".groups"`)
+	require.NoError(t, res[2].Error)
+	require.Len(t, envs, 2)
+
+	want := map[string]string{
+		"OPKSSH_PLUGIN_CLAIM_PREFERRED_USERNAME":         "alice",
+		"OPKSSH_PLUGIN_CLAIM_EMAIL_VERIFIED":             "true",
+		"OPKSSH_PLUGIN_CLAIM_GROUPS":                     `["dev","ops"]`,
+		"OPKSSH_PLUGIN_CLAIM_REALM_ACCESS_ROLES":         `["admin"]`,
+		"OPKSSH_PLUGIN_CLAIM_HTTPS___EXAMPLE_COM_TENANT": "acme",
+		"OPKSSH_PLUGIN_CLAIM_EXP":                        "17000000000000000001",
+		"OPKSSH_PLUGIN_CLAIM_MISSING_CLAIM":              "",
+	}
+	for name, value := range want {
+		got, ok := lookupEnv(envs[0], name)
+		require.True(t, ok, name)
+		require.Equal(t, value, got, name)
+	}
+
+	// Only the plugin that lists a claim gets it
+	for name := range want {
+		_, ok := lookupEnv(envs[1], name)
+		require.False(t, ok, name)
+	}
+	require.Len(t, tokens, 1)
+}
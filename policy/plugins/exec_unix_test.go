@@ -0,0 +1,72 @@
+//go:build !windows
+// +build !windows
+
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultCmdExecutorKillsProcessTree(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	env := []string{"PID_FILE=" + pidFile}
+	_, err := DefaultCmdExecutor(ctx, env, nil, "/bin/sh", "-c", `sleep 30 & echo $! > "$PID_FILE"; wait`)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	content, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	require.NoError(t, err)
+
+	// The background sleep was killed with the shell
+	require.Eventually(t, func() bool {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestDefaultCmdExecutorStdin(t *testing.T) {
+	// With stdin only stdout is returned
+	output, err := DefaultCmdExecutor(context.Background(), nil, []byte(`{"decision":"allow"}`), "/bin/sh", "-c", `echo warning >&2; cat`)
+	require.NoError(t, err)
+	require.Equal(t, `{"decision":"allow"}`, string(output))
+
+	output, err = DefaultCmdExecutor(context.Background(), nil, nil, "/bin/sh", "-c", `echo warning >&2; echo allow`)
+	require.NoError(t, err)
+	require.Equal(t, "warning\nallow\n", string(output))
+}
+
+func TestDefaultCmdExecutorOutputLimit(t *testing.T) {
+	// A command that never stops writing is stopped at the limit
+	_, err := DefaultCmdExecutor(context.Background(), nil, nil, "/bin/sh", "-c", `yes allow`)
+	require.ErrorContains(t, err, "policy command wrote more than 65536 bytes")
+}
@@ -0,0 +1,40 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/afero"
+	"golang.org/x/sys/unix"
+)
+
+// pinnedCommandPath returns the path that runs f, the command opened at
+// path, rather than whatever is at path when it runs. f must stay open until
+// the command has started. The children inherit the descriptor, so that the
+// interpreter of a script reads it through the same path.
+func pinnedCommandPath(f afero.File, path string) string {
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return path
+	}
+	if _, err := unix.FcntlInt(osFile.Fd(), unix.F_SETFD, 0); err != nil {
+		return path
+	}
+	return fmt.Sprintf("/proc/self/fd/%d", osFile.Fd())
+}
@@ -0,0 +1,62 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedCommandPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy_cmd.sh")
+	script := []byte("#!/bin/sh\necho allow\n")
+	require.NoError(t, os.WriteFile(path, script, 0o755))
+	sum := sha256.Sum256(script)
+
+	f, err := openCommandSHA256(afero.NewOsFs(), path, hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	defer f.Close()
+	name := pinnedCommandPath(f, path)
+	require.NotEqual(t, path, name)
+
+	// The file checked is run, not the one that replaced it
+	replacement := filepath.Join(dir, "replacement.sh")
+	require.NoError(t, os.WriteFile(replacement, []byte("#!/bin/sh\necho deny\n"), 0o755))
+	require.NoError(t, os.Rename(replacement, path))
+	output, err := DefaultCmdExecutor(context.Background(), nil, nil, name)
+	require.NoError(t, err)
+	require.Equal(t, "allow\n", string(output))
+
+	// Files of other file systems are run by their path
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, path, script, 0o755))
+	memFile, err := openCommandSHA256(mem, path, hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	defer memFile.Close()
+	require.Equal(t, path, pinnedCommandPath(memFile, path))
+
+	_, err = openCommandSHA256(mem, path, hex.EncodeToString(make([]byte, sha256.Size)))
+	require.ErrorContains(t, err, "refusing to run it")
+}
@@ -16,8 +16,89 @@
 
 package plugins
 
+import (
+	"fmt"
+	"time"
+)
+
+// Types of policy plugins
+const (
+	// PluginTypeExec runs a command, it is the default
+	PluginTypeExec = "exec"
+	// PluginTypeWebhook POSTs the login to an HTTPS URL
+	PluginTypeWebhook = "webhook"
+)
+
 // PluginConfig represents the structure of a policy command configuration.
 type PluginConfig struct {
-	Name    string `yaml:"name"`
-	Command string `yaml:"command"`
+	Name string `yaml:"name"`
+	// Type is exec, the default, or webhook
+	Type    string `yaml:"type,omitempty"`
+	Command string `yaml:"command,omitempty"`
+	// SHA256, if set, is the hex SHA-256 of the file the command runs. The
+	// command is not run if the file has changed.
+	SHA256 string `yaml:"sha256,omitempty"`
+	// Protocol is how the command gets the login and answers, env/v1,
+	// the default, or json/v2
+	Protocol string `yaml:"protocol,omitempty"`
+	// Claims are ID Token claims, or dot-separated paths to nested claims,
+	// that are exported to the command as OPKSSH_PLUGIN_CLAIM_ variables
+	Claims []string `yaml:"claims,omitempty"`
+	// URL is where a webhook plugin POSTs the login
+	URL string `yaml:"url,omitempty"`
+	// HMACSecretFile, if set, is a file with the secret that webhook
+	// requests are signed with
+	HMACSecretFile string `yaml:"hmac_secret_file,omitempty"`
+	// CAFile replaces the system CAs that the webhook's certificate is
+	// checked against
+	CAFile string `yaml:"ca_file,omitempty"`
+	// ClientCert and ClientKey are the certificate a webhook request
+	// authenticates with
+	ClientCert string `yaml:"client_cert,omitempty"`
+	ClientKey  string `yaml:"client_key,omitempty"`
+	// CacheTTL, e.g. 5m, reuses the output of the command for repeated
+	// logins of the same identity as the same principal within that time.
+	// Empty runs the command on every login.
+	CacheTTL string `yaml:"cache_ttl,omitempty"`
+	// Timeout, e.g. 5s, overrides how long the command may run before it
+	// and the processes it started are killed
+	Timeout string `yaml:"timeout,omitempty"`
+}
+
+// IsWebhook returns true if the plugin is a webhook
+func (c PluginConfig) IsWebhook() bool {
+	return c.Type == PluginTypeWebhook
+}
+
+// target returns the command or URL that the plugin calls
+func (c PluginConfig) target() string {
+	if c.IsWebhook() {
+		return c.URL
+	}
+	return c.Command
+}
+
+// CacheDuration returns how long the output of the command is cached, 0 if
+// caching is off
+func (c PluginConfig) CacheDuration() (time.Duration, error) {
+	if c.CacheTTL == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(c.CacheTTL)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid cache_ttl %q, expected a positive duration such as 5m", c.CacheTTL)
+	}
+	return ttl, nil
+}
+
+// TimeoutDuration returns the timeout of the command, 0 if it is not set
+func (c PluginConfig) TimeoutDuration() (time.Duration, error) {
+	if c.Timeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected a positive duration such as 5s", c.Timeout)
+	}
+	return timeout, nil
 }
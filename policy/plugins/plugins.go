@@ -18,18 +18,26 @@ package plugins
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kballard/go-shellquote"
 	"github.com/openpubkey/openpubkey/pktoken"
 	"github.com/openpubkey/opkssh/policy/files"
 	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/yaml.v3"
 )
 
@@ -45,13 +53,35 @@ func RequiredPolicyDirPerms() []fs.FileMode {
 	return requiredPolicyDirPerms
 }
 
+// RequiredPolicyCmdPerms returns the list of acceptable permission modes for
+// the commands run by policy plugins.
+func RequiredPolicyCmdPerms() []fs.FileMode {
+	return requiredPolicyCmdPerms
+}
+
 type PluginResult struct {
 	Path         string
 	PluginConfig PluginConfig
 	Error        error
 	CommandRun   []string
 	PolicyOutput string
-	Allowed      bool
+	// Reason is why a webhook or json/v2 plugin decided, if it said
+	Reason string
+	// Options are the authorized_keys options a webhook or json/v2 plugin
+	// restricts the login it allowed with
+	Options []string
+	Allowed bool
+	// Cached is set if PolicyOutput was not run but read from the cache
+	Cached bool
+	// TimedOut is set if the command was killed because it ran longer than
+	// its timeout
+	TimedOut bool
+	// CacheErr is why the cache could not be read or written. The command
+	// is run as if there was no cache.
+	CacheErr error
+	// Duration is how long the command or webhook ran, zero if the result
+	// was cached
+	Duration time.Duration
 }
 
 type PluginResults []*PluginResult
@@ -65,6 +95,36 @@ func (r PluginResults) Errors() (errs []error) {
 	return errs
 }
 
+// Options returns the authorized_keys options of the plugins that allowed
+// the login, in order and without duplicates. Every allowing plugin
+// restricts the login, so plugins that give an option different values
+// are an error.
+func (r PluginResults) Options() ([]string, error) {
+	var options []string
+	setBy := map[string]*PluginResult{}
+	values := map[string]string{}
+	for _, pluginResult := range r {
+		if !pluginResult.Allowed {
+			continue
+		}
+		for _, option := range pluginResult.Options {
+			name, value, err := files.ParseKeyOption(option)
+			if err != nil {
+				return nil, fmt.Errorf("policy plugin %s: %w", pluginResult.Path, err)
+			}
+			if first, ok := setBy[name]; ok {
+				if values[name] != value {
+					return nil, fmt.Errorf("policy plugins %s and %s set different values of option %s", first.Path, pluginResult.Path, name)
+				}
+				continue
+			}
+			setBy[name], values[name] = pluginResult, value
+			options = append(options, option)
+		}
+	}
+	return options, nil
+}
+
 func (r PluginResults) Allowed() bool {
 	for _, pluginResult := range r {
 		if pluginResult.Allowed {
@@ -85,14 +145,75 @@ func (r PluginResults) Allowed() bool {
 	return false
 }
 
-type CmdExecutor func(name string, arg ...string) ([]byte, error)
+// Defaults for plugins run in parallel
+const (
+	DefaultWorkers = 4
+	DefaultTimeout = 30 * time.Second
+)
 
-func DefaultCmdExecutor(name string, arg ...string) ([]byte, error) {
-	return exec.Command(name, arg...).CombinedOutput()
+// MaxOutputSize is the most output read from a policy command. A command
+// that writes more is stopped and fails.
+const MaxOutputSize = 64 << 10
+
+// CmdExecutor runs a policy command with the environment env. If stdin is
+// set it is written to the command and only its stdout is returned, else its
+// combined output, up to MaxOutputSize bytes. The command must be stopped when
+// ctx is done.
+type CmdExecutor func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error)
+
+func DefaultCmdExecutor(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, arg...)
+	cmd.Env = env
+	setKillTree(cmd)
+	// Don't wait for children of a stopped command that keep the output open
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	} else {
+		cmd.Stderr = cmd.Stdout
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	output, readErr := io.ReadAll(io.LimitReader(stdout, MaxOutputSize+1))
+	if len(output) > MaxOutputSize {
+		cancel()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("policy command wrote more than %d bytes", MaxOutputSize)
+	}
+	if err := cmd.Wait(); err != nil {
+		return output, err
+	}
+	return output, readErr
 }
 
 type PolicyPluginEnforcer struct {
-	Fs          afero.Fs
+	Fs afero.Fs
+	// CacheDir is where the results of plugins with a cache_ttl are kept,
+	// empty disables the cache
+	CacheDir string
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+	// Workers is how many plugin commands run at the same time, 0 uses
+	// DefaultWorkers
+	Workers int
+	// Timeout is how long a plugin command may run before it is stopped
+	// and counts as failed, 0 uses DefaultTimeout
+	Timeout time.Duration
+	// Configs, if set, keeps the plugin configs loaded from a directory
+	// instead of loading them for every login
+	Configs *ConfigCache
+	// TrustedKeys, if not nil, are the keys plugin configs must be signed
+	// with. A config without a detached signature from one of them, at its
+	// path followed by SignatureExt, is not loaded. An empty list loads no
+	// config.
+	TrustedKeys []ssh.PublicKey
 	cmdExecutor CmdExecutor // This lets us mock command exec in unit tests
 	permChecker files.PermsChecker
 }
@@ -109,8 +230,61 @@ func NewPolicyPluginEnforcer() *PolicyPluginEnforcer {
 	}
 }
 
+// ConfigCache keeps the plugin configs loaded from a directory, including the
+// configs that had errors, until Reset is called. It is used by long running
+// processes such as opkssh serve, which call Reset when the directory changes.
+type ConfigCache struct {
+	mu      sync.Mutex
+	results map[string][]PluginResult
+}
+
+// NewConfigCache returns an empty ConfigCache
+func NewConfigCache() *ConfigCache {
+	return &ConfigCache{results: map[string][]PluginResult{}}
+}
+
+// Reset empties the cache
+func (c *ConfigCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = map[string][]PluginResult{}
+}
+
+// load returns new results for the plugin configs in dir, loading them with
+// loadPlugins if they are not cached
+func (c *ConfigCache) load(dir string, loadPlugins func(dir string) (PluginResults, error)) (PluginResults, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loaded, ok := c.results[dir]
+	if !ok {
+		pluginResults, err := loadPlugins(dir)
+		if err != nil {
+			// Not cached, the directory may be fixed without changing it
+			return nil, err
+		}
+		for _, pluginResult := range pluginResults {
+			loaded = append(loaded, *pluginResult)
+		}
+		c.results[dir] = loaded
+	}
+	// Every login writes its own results
+	pluginResults := PluginResults{}
+	for _, config := range loaded {
+		pluginResults = append(pluginResults, &PluginResult{Path: config.Path, PluginConfig: config.PluginConfig, Error: config.Error})
+	}
+	return pluginResults, nil
+}
+
 // loadPlugins loads the plugin config files from the given directory.
 func (p *PolicyPluginEnforcer) loadPlugins(dir string) (pluginResults PluginResults, err error) {
+	if p.Configs != nil {
+		return p.Configs.load(dir, p.readPlugins)
+	}
+	return p.readPlugins(dir)
+}
+
+// readPlugins reads the plugin config files from the given directory.
+func (p *PolicyPluginEnforcer) readPlugins(dir string) (pluginResults PluginResults, err error) {
 	// Ensure the /opk/ssh/policy.d can only be written by root
 	if err := p.permChecker.CheckPerm(dir, requiredPolicyDirPerms, "root", ""); err != nil {
 		return nil, fmt.Errorf("policy plugin directory (%s) has insecure permissions: %w", dir, err)
@@ -134,17 +308,24 @@ func (p *PolicyPluginEnforcer) loadPlugins(dir string) (pluginResults PluginResu
 			pluginResults = append(pluginResults, pluginResult)
 			pluginResult.Path = path
 
-			if err := p.permChecker.CheckPerm(path, []fs.FileMode{requiredPolicyPerms}, "root", ""); err != nil {
+			// The permissions are checked on the opened file
+			file, err := p.permChecker.ReadFile(path, []fs.FileMode{requiredPolicyPerms}, "root", "")
+			var permsErr *files.PermsError
+			if errors.As(err, &permsErr) {
 				pluginResult.Error = fmt.Errorf("policy plugin config file (%s) has insecure permissions: %w", path, err)
 				continue
-			}
-
-			file, err := afero.ReadFile(p.Fs, path)
-			if err != nil {
+			} else if err != nil {
 				pluginResult.Error = fmt.Errorf("failed to read policy plugin config at (%s): %w", path, err)
 				continue
 			}
 
+			if p.TrustedKeys != nil {
+				if err := p.verifySignature(path, file); err != nil {
+					pluginResult.Error = fmt.Errorf("policy plugin config (%s) %w", path, err)
+					continue
+				}
+			}
+
 			var cmd PluginConfig
 			if err := yaml.Unmarshal(file, &cmd); err != nil {
 				pluginResult.Error = fmt.Errorf("failed to parse YAML in policy plugin config at (%s): %w", path, err)
@@ -156,8 +337,44 @@ func (p *PolicyPluginEnforcer) loadPlugins(dir string) (pluginResults PluginResu
 				continue
 			}
 
-			if cmd.Command == "" {
-				pluginResult.Error = fmt.Errorf("policy plugin config missing required field 'command' in policy plugin config at (%s): ", path)
+			switch cmd.Type {
+			case "", PluginTypeExec:
+				if cmd.Command == "" {
+					pluginResult.Error = fmt.Errorf("policy plugin config missing required field 'command' in policy plugin config at (%s): ", path)
+					continue
+				}
+			case PluginTypeWebhook:
+				if err := cmd.ValidateWebhook(); err != nil {
+					pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
+					continue
+				}
+			default:
+				pluginResult.Error = fmt.Errorf("unknown type %q, expected exec or webhook, in policy plugin config at (%s)", cmd.Type, path)
+				continue
+			}
+
+			if err := cmd.ValidateClaims(); err != nil {
+				pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
+				continue
+			}
+
+			if err := cmd.ValidateProtocol(); err != nil {
+				pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
+				continue
+			}
+
+			if err := cmd.ValidateSHA256(); err != nil {
+				pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
+				continue
+			}
+
+			if _, err := cmd.CacheDuration(); err != nil {
+				pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
+				continue
+			}
+
+			if _, err := cmd.TimeoutDuration(); err != nil {
+				pluginResult.Error = fmt.Errorf("%w in policy plugin config at (%s)", err, path)
 				continue
 			}
 
@@ -167,6 +384,21 @@ func (p *PolicyPluginEnforcer) loadPlugins(dir string) (pluginResults PluginResu
 	return pluginResults, nil
 }
 
+// verifySignature returns an error if the plugin config at path, whose
+// content is file, is not signed by one of TrustedKeys
+func (p *PolicyPluginEnforcer) verifySignature(path string, file []byte) error {
+	sig, err := afero.ReadFile(p.Fs, path+SignatureExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("is not signed, expected a signature at %s", path+SignatureExt)
+	} else if err != nil {
+		return fmt.Errorf("failed to read its signature: %w", err)
+	}
+	if err := VerifySignature(file, sig, p.TrustedKeys); err != nil {
+		return fmt.Errorf("has an invalid signature: %w", err)
+	}
+	return nil
+}
+
 // CheckPolicies loads the policies plugin configs in the directory dir
 // and then runs the policy command specified in which policy plugin config
 // to determine if the user is allowed to assume access as the given principal.
@@ -193,66 +425,211 @@ func (p *PolicyPluginEnforcer) checkPolicies(dir string, tokens map[string]strin
 	if err != nil {
 		return nil, fmt.Errorf("failed to load policy commands: %w", err)
 	}
+
+	// The commands run in parallel so a slow plugin only delays the login
+	// by its timeout. Each writes its own result, which keeps the results
+	// in the order of the plugin configs.
+	workers := p.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	queue := make(chan *PluginResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pluginResult := range queue {
+				p.runPlugin(pluginResult, tokens)
+			}
+		}()
+	}
 	for _, pluginResult := range pluginResults {
 		// Only run the command in the plugin config if there was no error loading the plugin config
 		if pluginResult.Error == nil {
-			commandRun, output, err := p.executePolicyCommand(pluginResult.PluginConfig, tokens)
-			output = bytes.TrimSpace(output)
-			pluginResult.Error = err
-			pluginResult.PolicyOutput = string(output)
-			pluginResult.CommandRun = commandRun
-			if err != nil {
-				pluginResult.Error = fmt.Errorf("failed to run policy command %s got error (%w)", pluginResult.PluginConfig.Command, err)
-				continue
-			} else if string(output) != "allow" {
-				pluginResult.Allowed = false
-			} else {
-				pluginResult.Allowed = true
-			}
+			queue <- pluginResult
 		}
 	}
+	close(queue)
+	wg.Wait()
 	return pluginResults, nil
 }
 
-// executePolicyCommand executes the policy command with the provided tokens.
-func (p *PolicyPluginEnforcer) executePolicyCommand(config PluginConfig, inputEnvVars map[string]string) ([]string, []byte, error) {
+// runPlugin sets the result of the command of pluginResult, from the cache
+// if the plugin config has a cache_ttl
+func (p *PolicyPluginEnforcer) runPlugin(pluginResult *PluginResult, tokens map[string]string) {
+	ttl, _ := pluginResult.PluginConfig.CacheDuration()
+	useCache := ttl > 0 && p.CacheDir != ""
+	key := cacheKey(pluginResult.PluginConfig, tokens)
+	if useCache {
+		entry, ok, err := p.cachedResult(pluginResult.Path, key)
+		if err != nil {
+			pluginResult.CacheErr = err
+			useCache = false
+		} else if ok {
+			pluginResult.Cached = true
+			pluginResult.PolicyOutput = entry.Output
+			pluginResult.Reason = entry.Reason
+			pluginResult.Options = entry.Options
+			pluginResult.CommandRun = entry.CommandRun
+			pluginResult.Allowed = entry.Output == "allow"
+			return
+		}
+	}
+
+	timeout, _ := pluginResult.PluginConfig.TimeoutDuration()
+	if timeout == 0 {
+		timeout = p.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var commandRun []string
+	var decision PluginDecision
+	var err error
+	start := time.Now()
+	if pluginResult.PluginConfig.IsWebhook() {
+		commandRun, decision, err = p.executeWebhook(ctx, pluginResult.PluginConfig, tokens)
+	} else {
+		commandRun, decision, err = p.executePolicyCommand(ctx, pluginResult.PluginConfig, tokens)
+	}
+	pluginResult.Duration = time.Since(start)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// Whatever it printed before it was killed is not a decision
+		pluginResult.TimedOut = true
+		decision = PluginDecision{}
+		if pluginResult.PluginConfig.IsWebhook() {
+			err = fmt.Errorf("timed out after %s waiting for the webhook", timeout)
+		} else {
+			err = fmt.Errorf("timed out after %s, the command and the processes it started were killed", timeout)
+		}
+	}
+	output := strings.TrimSpace(decision.Decision)
+	pluginResult.Error = err
+	pluginResult.PolicyOutput = output
+	pluginResult.Reason = decision.Reason
+	pluginResult.Options = decision.Options
+	pluginResult.CommandRun = commandRun
+	if err != nil {
+		// Failures are not cached, the next login runs the command again
+		pluginResult.Error = fmt.Errorf("failed to run policy command %s got error (%w)", pluginResult.PluginConfig.target(), err)
+		return
+	} else if output != "allow" {
+		pluginResult.Allowed = false
+	} else {
+		pluginResult.Allowed = true
+	}
+
+	if useCache {
+		entry := cacheEntry{Output: output, Reason: decision.Reason, Options: decision.Options, CommandRun: commandRun, Expires: p.now().Add(ttl)}
+		pluginResult.CacheErr = p.cacheResult(pluginResult.Path, key, entry)
+	}
+}
+
+// executePolicyCommand executes the policy command with the provided tokens
+// set as environment variables, or written to its stdin for json/v2.
+func (p *PolicyPluginEnforcer) executePolicyCommand(ctx context.Context, config PluginConfig, inputEnvVars map[string]string) ([]string, PluginDecision, error) {
 	// Add PluginConfig to the tokens map for expansion
 	configJson, err := yaml.Marshal(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to marshal config to JSON: %w", err)
+		return nil, PluginDecision{}, fmt.Errorf("failed to marshal config to JSON: %w", err)
 	}
-	inputEnvVars["OPKSSH_PLUGIN_CONFIG"] = base64.StdEncoding.EncodeToString(configJson)
-
-	// Ensure we don't use any environment variables as an input to
-	// the policy plugin command that this process inherited. We only
-	// want to pass values we set ourselves.
-	for _, envVar := range os.Environ() {
-		if strings.HasPrefix(envVar, "OPKSSH_PLUGIN_") {
-			os.Unsetenv(strings.Split(envVar, "=")[0])
+	claimVars, err := claimEnvVars(config, inputEnvVars)
+	if err != nil {
+		return nil, PluginDecision{}, err
+	}
+	if len(claimVars) > 0 {
+		// The tokens are shared with the other plugins
+		tokens := make(map[string]string, len(inputEnvVars)+len(claimVars))
+		for name, value := range inputEnvVars {
+			tokens[name] = value
 		}
+		for name, value := range claimVars {
+			tokens[name] = value
+		}
+		inputEnvVars = tokens
 	}
-
-	for envK, envV := range inputEnvVars {
-		if err := os.Setenv(envK, envV); err != nil {
-			return nil, nil, fmt.Errorf("failed to set environment variable %s: %w", envK, err)
+	configB64 := base64.StdEncoding.EncodeToString(configJson)
+
+	var env []string
+	var stdin []byte
+	if config.Protocol == ProtocolJSONv2 {
+		// Nothing about the login is in the environment
+		env = pluginEnv(nil, configB64)
+		req, err := newPluginRequest(inputEnvVars)
+		if err != nil {
+			return nil, PluginDecision{}, err
+		}
+		req.Env = inputEnvVars
+		if stdin, err = json.Marshal(req); err != nil {
+			return nil, PluginDecision{}, err
 		}
+	} else {
+		env = pluginEnv(inputEnvVars, configB64)
 	}
 
 	command, err := shellquote.Split(config.Command)
 	if err != nil {
-		return nil, nil, err
+		return nil, PluginDecision{}, err
 	}
 
 	if err := p.permChecker.CheckPerm(command[0], requiredPolicyCmdPerms, "root", ""); err != nil {
 		if strings.Contains(err.Error(), "file does not exist") {
-			return nil, nil, err
+			return nil, PluginDecision{}, err
 		} else {
-			return nil, nil, fmt.Errorf("policy plugin command (%s) has insecure permissions: %w", command[0], err)
+			return nil, PluginDecision{}, fmt.Errorf("policy plugin command (%s) has insecure permissions: %w", command[0], err)
 		}
 	}
+	name := command[0]
+	if config.SHA256 != "" {
+		f, err := openCommandSHA256(p.Fs, command[0], config.SHA256)
+		if err != nil {
+			return nil, PluginDecision{}, err
+		}
+		defer f.Close()
+		name = pinnedCommandPath(f, command[0])
+	}
 
-	output, err := p.cmdExecutor(command[0], command[1:]...)
-	return command, output, err
+	output, err := p.cmdExecutor(ctx, env, stdin, name, command[1:]...)
+	if stdin == nil {
+		return command, PluginDecision{Decision: string(output)}, err
+	}
+	if err != nil {
+		return command, PluginDecision{}, err
+	}
+	decision, err := parseDecision(bytes.TrimSpace(output))
+	if err != nil {
+		return command, PluginDecision{}, fmt.Errorf("invalid %s decision: %w", ProtocolJSONv2, err)
+	}
+	return command, decision, nil
+}
+
+// pluginEnv returns the environment of a policy command: the environment of
+// this process and the tokens. The environment is not changed since other
+// plugin commands run at the same time with other tokens.
+func pluginEnv(tokens map[string]string, configB64 string) []string {
+	var env []string
+	// Ensure we don't use any environment variables as an input to
+	// the policy plugin command that this process inherited. We only
+	// want to pass values we set ourselves.
+	for _, envVar := range os.Environ() {
+		if !strings.HasPrefix(envVar, "OPKSSH_PLUGIN_") {
+			env = append(env, envVar)
+		}
+	}
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		if name != "OPKSSH_PLUGIN_CONFIG" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+tokens[name])
+	}
+	return append(env, "OPKSSH_PLUGIN_CONFIG="+configB64)
 }
 
 // b64 is a simple helper function to base64 encode a string.
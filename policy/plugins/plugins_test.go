@@ -23,7 +23,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/openpubkey/openpubkey/client"
@@ -34,6 +36,16 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// lookupEnv returns the value of the variable name in env
+func lookupEnv(env []string, name string) (string, bool) {
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 type mockFile struct {
 	Name       string
 	Permission fs.FileMode
@@ -162,11 +174,53 @@ invalid_field: true
 	}
 }
 
+func TestLoadPolicyPluginsConfigCache(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	enforcer := &PolicyPluginEnforcer{
+		Fs:      mockFs,
+		Configs: NewConfigCache(),
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+	configPath := filepath.Join(tempDir, "policy.yml")
+	require.NoError(t, afero.WriteFile(mockFs, configPath, []byte("name: first\ncommand: /usr/bin/first\n"), 0640))
+
+	pluginResults, err := enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Len(t, pluginResults, 1)
+	require.Equal(t, "first", pluginResults[0].PluginConfig.Name)
+	pluginResults[0].Allowed = true
+
+	// Changes are only seen after a reset, and every load gets new results
+	require.NoError(t, afero.WriteFile(mockFs, configPath, []byte("name: second\ncommand: /usr/bin/second\n"), 0640))
+	pluginResults, err = enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Equal(t, "first", pluginResults[0].PluginConfig.Name)
+	require.False(t, pluginResults[0].Allowed)
+
+	enforcer.Configs.Reset()
+	pluginResults, err = enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Equal(t, "second", pluginResults[0].PluginConfig.Name)
+
+	// A directory that fails to load is not cached
+	_, err = enforcer.loadPlugins("/should/not/exist")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, mockFs.MkdirAll("/should/not/exist", 0750))
+	_, err = enforcer.loadPlugins("/should/not/exist")
+	require.NoError(t, err)
+}
+
 func TestPolicyPluginsWithMock(t *testing.T) {
-	mockCmdExecutor := func(name string, arg ...string) ([]byte, error) {
-		iss, _ := os.LookupEnv("OPKSSH_PLUGIN_ISS")
-		sub, _ := os.LookupEnv("OPKSSH_PLUGIN_SUB")
-		aud, _ := os.LookupEnv("OPKSSH_PLUGIN_AUD")
+	mockCmdExecutor := func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+		iss, _ := lookupEnv(env, "OPKSSH_PLUGIN_ISS")
+		sub, _ := lookupEnv(env, "OPKSSH_PLUGIN_SUB")
+		aud, _ := lookupEnv(env, "OPKSSH_PLUGIN_AUD")
 
 		if name == "/usr/bin/local/opk/policy-cmd" {
 
@@ -217,7 +271,7 @@ command: /usr/bin/local/opk/missing-cmd"`}}
 		name                string
 		tokens              map[string]string
 		files               []mockFile // File name to content mapping
-		cmdExecutor         func(name string, arg ...string) ([]byte, error)
+		cmdExecutor         CmdExecutor
 		expectedAllowed     bool
 		expectedResultCount int
 		expectErrorCount    int
@@ -388,9 +442,9 @@ func TestPluginUnsetsEnvVar(t *testing.T) {
 
 	enforcer := &PolicyPluginEnforcer{
 		Fs: mockFs,
-		cmdExecutor: func(name string, arg ...string) ([]byte, error) {
-			_, okTestValue := os.LookupEnv("OPKSSH_PLUGIN_TESTVALUE")
-			issValue, okIss := os.LookupEnv("OPKSSH_PLUGIN_ISS")
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			_, okTestValue := lookupEnv(env, "OPKSSH_PLUGIN_TESTVALUE")
+			issValue, okIss := lookupEnv(env, "OPKSSH_PLUGIN_ISS")
 			require.False(t, okTestValue, "OPKSSH_PLUGIN_TESTVALUE should have been unset before calling the command")
 			require.True(t, okIss, "OPKSSH_PLUGIN_ISS should still be set before calling the command")
 			require.Equal(t, issValue, "https://example.com")
@@ -424,9 +478,9 @@ func TestPublicCheckPolicy(t *testing.T) {
 
 	enforcer := &PolicyPluginEnforcer{
 		Fs: mockFs,
-		cmdExecutor: func(name string, arg ...string) ([]byte, error) {
-			_, okTestValue := os.LookupEnv("OPKSSH_PLUGIN_TESTVALUE")
-			_, okIss := os.LookupEnv("OPKSSH_PLUGIN_ISS")
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			_, okTestValue := lookupEnv(env, "OPKSSH_PLUGIN_TESTVALUE")
+			_, okIss := lookupEnv(env, "OPKSSH_PLUGIN_ISS")
 			require.False(t, okTestValue, "OPKSSH_PLUGIN_TESTVALUE should have been unset before calling the command")
 			require.True(t, okIss, "OPKSSH_PLUGIN_ISS should still be set before calling the command")
 			return []byte("allow"), nil
@@ -476,3 +530,238 @@ command: /usr/bin/local/opk/policy-cmd arg1 arg2 arg3`), 0640)
 	require.Error(t, err)
 	require.Nil(t, res)
 }
+
+func TestPluginResultCache(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/policy-cmd", []byte(""), 0755))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "cached.yml"), []byte(`
+name: Cached Policy Command
+command: /usr/bin/local/opk/policy-cmd
+cache_ttl: 5m`), 0640))
+
+	runs := 0
+	var cmdErr error
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	enforcer := &PolicyPluginEnforcer{
+		Fs:       mockFs,
+		CacheDir: "/var/lib/opk/plugin-cache",
+		Now:      func() time.Time { return now },
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			runs++
+			if u, _ := lookupEnv(env, "OPKSSH_PLUGIN_U"); u == "root" {
+				return []byte("allow"), cmdErr
+			}
+			return []byte("deny"), cmdErr
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+	tokens := func(principal string) map[string]string {
+		return map[string]string{
+			"OPKSSH_PLUGIN_ISS": "https://example.com",
+			"OPKSSH_PLUGIN_SUB": "1234",
+			"OPKSSH_PLUGIN_AUD": "abcd",
+			"OPKSSH_PLUGIN_U":   principal,
+		}
+	}
+	check := func(principal string) *PluginResult {
+		res, err := enforcer.checkPolicies(tempDir, tokens(principal))
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.NoError(t, res[0].Error)
+		require.NoError(t, res[0].CacheErr)
+		return res[0]
+	}
+
+	res := check("root")
+	require.True(t, res.Allowed)
+	require.False(t, res.Cached)
+	require.Equal(t, 1, runs)
+
+	// A repeated login within the TTL is not run again
+	now = now.Add(4 * time.Minute)
+	res = check("root")
+	require.True(t, res.Allowed)
+	require.True(t, res.Cached)
+	require.Equal(t, []string{"/usr/bin/local/opk/policy-cmd"}, res.CommandRun)
+	require.Equal(t, 1, runs)
+
+	// Denials are cached per principal too
+	res = check("alice")
+	require.False(t, res.Allowed)
+	require.False(t, res.Cached)
+	res = check("alice")
+	require.False(t, res.Allowed)
+	require.True(t, res.Cached)
+	require.Equal(t, 2, runs)
+
+	// The result expires
+	now = now.Add(2 * time.Minute)
+	res = check("root")
+	require.True(t, res.Allowed)
+	require.False(t, res.Cached)
+	require.Equal(t, 3, runs)
+
+	// Failures are not cached
+	now = now.Add(10 * time.Minute)
+	cmdErr = fmt.Errorf("exit status 1")
+	res2, err := enforcer.checkPolicies(tempDir, tokens("root"))
+	require.NoError(t, err)
+	require.Error(t, res2[0].Error)
+	cmdErr = nil
+	require.False(t, check("root").Cached)
+	require.Equal(t, 5, runs)
+
+	// A cache others can write to is not trusted
+	entries, err := afero.ReadDir(mockFs, enforcer.CacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, fs.FileMode(0600), entries[0].Mode().Perm())
+	require.NoError(t, mockFs.Chmod(filepath.Join(enforcer.CacheDir, entries[0].Name()), 0666))
+	res2, err = enforcer.checkPolicies(tempDir, tokens("root"))
+	require.NoError(t, err)
+	require.ErrorContains(t, res2[0].CacheErr, "insecure permissions")
+	require.False(t, res2[0].Cached)
+	require.True(t, res2[0].Allowed)
+	require.Equal(t, 6, runs)
+}
+
+func TestPluginInvalidCacheTTL(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "cached.yml"), []byte(`
+name: Cached Policy Command
+command: /usr/bin/local/opk/policy-cmd
+cache_ttl: -5m`), 0640))
+	enforcer := &PolicyPluginEnforcer{
+		Fs: mockFs,
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+	res, err := enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.ErrorContains(t, res[0].Error, `invalid cache_ttl "-5m"`)
+}
+
+func TestPluginsRunInParallel(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	for _, name := range []string{"a-slow", "b-deny", "c-allow", "d-hang"} {
+		require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/"+name, []byte(""), 0755))
+		require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, name+".yml"), []byte(
+			"name: "+name+"\ncommand: /usr/bin/local/opk/"+name+"\n"), 0640))
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	enforcer := &PolicyPluginEnforcer{
+		Fs:      mockFs,
+		Workers: 3,
+		Timeout: 200 * time.Millisecond,
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			mu.Lock()
+			running++
+			maxRunning = max(maxRunning, running)
+			mu.Unlock()
+			defer func() {
+				mu.Lock()
+				running--
+				mu.Unlock()
+			}()
+
+			switch filepath.Base(name) {
+			case "a-slow":
+				time.Sleep(100 * time.Millisecond)
+				return []byte("deny"), nil
+			case "b-deny":
+				time.Sleep(50 * time.Millisecond)
+				return []byte("deny"), nil
+			case "c-allow":
+				time.Sleep(50 * time.Millisecond)
+				return []byte("allow"), nil
+			default:
+				// Never finishes on its own
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+
+	start := time.Now()
+	res, err := enforcer.checkPolicies(tempDir, map[string]string{"OPKSSH_PLUGIN_ISS": "https://example.com"})
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.Equal(t, 3, maxRunning)
+
+	// The results are in the order of the plugin configs, whichever
+	// finishes first
+	require.Len(t, res, 4)
+	for i, name := range []string{"a-slow", "b-deny", "c-allow", "d-hang"} {
+		require.Equal(t, name, res[i].PluginConfig.Name)
+	}
+	require.Equal(t, []bool{false, false, true, false}, []bool{res[0].Allowed, res[1].Allowed, res[2].Allowed, res[3].Allowed})
+	require.Len(t, res.Errors(), 1)
+	require.ErrorContains(t, res[3].Error, "timed out after 200ms")
+	require.True(t, res.Allowed())
+}
+
+func TestPluginTimeout(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/policy-cmd", []byte(""), 0755))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "hang.yml"), []byte(`
+name: Hanging Policy Command
+command: /usr/bin/local/opk/policy-cmd
+timeout: 100ms`), 0640))
+	require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, "invalid.yml"), []byte(`
+name: Invalid Timeout
+command: /usr/bin/local/opk/policy-cmd
+timeout: 10`), 0640))
+
+	enforcer := &PolicyPluginEnforcer{
+		Fs: mockFs,
+		// The timeout of the plugin config wins
+		Timeout: time.Hour,
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			deadline, ok := ctx.Deadline()
+			require.True(t, ok)
+			require.WithinDuration(t, time.Now().Add(100*time.Millisecond), deadline, 100*time.Millisecond)
+			<-ctx.Done()
+			// Output before the kill is ignored
+			return []byte("allow"), fmt.Errorf("signal: killed")
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+
+	res, err := enforcer.checkPolicies(tempDir, map[string]string{})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.True(t, res[0].TimedOut)
+	require.False(t, res[0].Allowed)
+	require.Empty(t, res[0].PolicyOutput)
+	require.ErrorContains(t, res[0].Error, "timed out after 100ms")
+	require.False(t, res[1].TimedOut)
+	require.ErrorContains(t, res[1].Error, `invalid timeout "10"`)
+	require.False(t, res.Allowed())
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/openpubkey/opkssh/policy/files"
+)
+
+// Protocols of exec plugins
+const (
+	// ProtocolEnv sets the login as OPKSSH_PLUGIN_ variables and reads
+	// "allow" from the output of the command, it is the default
+	ProtocolEnv = "env/v1"
+	// ProtocolJSONv2 writes a PluginRequest to the stdin of the command and
+	// reads a PluginDecision from its stdout
+	ProtocolJSONv2 = "json/v2"
+)
+
+// maxDecisionSize is the largest decision read from a plugin
+const maxDecisionSize = 64 << 10
+
+// PluginRequest is the JSON document describing a login sent to webhooks
+// and to the commands of json/v2 plugins
+type PluginRequest struct {
+	Version   int             `json:"version"`
+	Principal string          `json:"principal"`
+	KeyType   string          `json:"key_type"`
+	Cert      string          `json:"cert"`
+	ExtraArgs []string        `json:"extra_args,omitempty"`
+	Claims    json.RawMessage `json:"claims"`
+	Userinfo  json.RawMessage `json:"userinfo,omitempty"`
+	PKToken   string          `json:"pk_token"`
+	// Env is what an env/v1 plugin would get as variables, only sent to
+	// commands
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// PluginDecision is the JSON document a webhook or json/v2 plugin answers
+// with. Only a decision of "allow" allows the login. Reason is why, it is
+// logged and reported when the login is denied.
+type PluginDecision struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	// Options are authorized_keys options, such as no-port-forwarding or
+	// command="/usr/bin/backup", that restrict a login the plugin allows
+	Options []string `json:"options,omitempty"`
+}
+
+// ValidateProtocol returns why the protocol of the config is invalid
+func (c PluginConfig) ValidateProtocol() error {
+	switch c.Protocol {
+	case "", ProtocolEnv:
+		return nil
+	case ProtocolJSONv2:
+		if c.IsWebhook() {
+			return fmt.Errorf("protocol %s is only for commands, webhooks always use JSON", c.Protocol)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown protocol %q, expected %s or %s", c.Protocol, ProtocolEnv, ProtocolJSONv2)
+	}
+}
+
+// newPluginRequest returns the request for the tokens of a login
+func newPluginRequest(tokens map[string]string) (*PluginRequest, error) {
+	payload, err := base64.StdEncoding.DecodeString(tokens["OPKSSH_PLUGIN_PAYLOAD"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ID Token payload: %w", err)
+	}
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	req := &PluginRequest{
+		Version:   2,
+		Principal: tokens["OPKSSH_PLUGIN_U"],
+		KeyType:   tokens["OPKSSH_PLUGIN_T"],
+		Cert:      tokens["OPKSSH_PLUGIN_K"],
+		Claims:    payload,
+		PKToken:   tokens["OPKSSH_PLUGIN_PKT"],
+	}
+	if userinfo := tokens["OPKSSH_PLUGIN_USERINFO"]; userinfo != "" && json.Valid([]byte(userinfo)) {
+		req.Userinfo = json.RawMessage(userinfo)
+	}
+	if extraArgs := tokens["OPKSSH_PLUGIN_EXTRA_ARGS"]; extraArgs != "" {
+		if err := json.Unmarshal([]byte(extraArgs), &req.ExtraArgs); err != nil {
+			return nil, fmt.Errorf("failed to decode extra arguments: %w", err)
+		}
+	}
+	return req, nil
+}
+
+// parseDecision parses the decision of a webhook or json/v2 plugin
+func parseDecision(content []byte) (PluginDecision, error) {
+	if len(content) > maxDecisionSize {
+		return PluginDecision{}, fmt.Errorf("decision is larger than %d bytes", maxDecisionSize)
+	}
+	var decision PluginDecision
+	if err := json.Unmarshal(content, &decision); err != nil {
+		return PluginDecision{}, err
+	}
+	if decision.Decision != "allow" && decision.Decision != "deny" {
+		return PluginDecision{}, fmt.Errorf("decision %q is neither allow nor deny", decision.Decision)
+	}
+	for _, option := range decision.Options {
+		if _, _, err := files.ParseKeyOption(option); err != nil {
+			return PluginDecision{}, err
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginProtocolJSONv2(t *testing.T) {
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	require.NoError(t, afero.WriteFile(mockFs, "/usr/bin/local/opk/policy-cmd", []byte(""), 0755))
+	configs := map[string]string{
+		"a-v2.yml":       "name: v2\ncommand: /usr/bin/local/opk/policy-cmd\nprotocol: json/v2\nclaims: [groups]\n",
+		"b-v1.yml":       "name: v1\ncommand: /usr/bin/local/opk/policy-cmd\nprotocol: env/v1\n",
+		"c-unknown.yml":  "name: unknown\ncommand: /usr/bin/local/opk/policy-cmd\nprotocol: json/v3\n",
+		"d-webhook.yml":  "name: webhook\ntype: webhook\nurl: https://example.com\nprotocol: json/v2\n",
+		"e-invalid.yml":  "name: invalid\ncommand: /usr/bin/local/opk/policy-cmd --invalid\nprotocol: json/v2\n",
+		"f-exitcode.yml": "name: exit code\ncommand: /usr/bin/local/opk/policy-cmd --fail\nprotocol: json/v2\n",
+	}
+	for name, content := range configs {
+		require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, name), []byte(content), 0640))
+	}
+
+	var requests []PluginRequest
+	enforcer := &PolicyPluginEnforcer{
+		Fs:      mockFs,
+		Workers: 1,
+		cmdExecutor: func(ctx context.Context, env []string, stdin []byte, name string, arg ...string) ([]byte, error) {
+			if stdin == nil {
+				_, ok := lookupEnv(env, "OPKSSH_PLUGIN_U")
+				require.True(t, ok)
+				return []byte("deny"), nil
+			}
+			// The login is only on stdin
+			_, ok := lookupEnv(env, "OPKSSH_PLUGIN_U")
+			require.False(t, ok)
+			_, ok = lookupEnv(env, "OPKSSH_PLUGIN_CONFIG")
+			require.True(t, ok)
+
+			var req PluginRequest
+			require.NoError(t, json.Unmarshal(stdin, &req))
+			requests = append(requests, req)
+			switch {
+			case len(arg) > 0 && arg[0] == "--invalid":
+				return []byte("allow"), nil
+			case len(arg) > 0 && arg[0] == "--fail":
+				return []byte(`{"decision":"allow"}`), fmt.Errorf("exit status 1")
+			case req.Principal == "root":
+				return []byte(`{"decision":"deny","reason":"root logins need a ticket"}` + "\n"), nil
+			default:
+				return []byte(`{"decision":"allow"}`), nil
+			}
+		},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+
+	tokens := map[string]string{
+		"OPKSSH_PLUGIN_U":       "dev",
+		"OPKSSH_PLUGIN_PAYLOAD": b64(`{"email":"alice@example.com","groups":["ops"]}`),
+	}
+	res, err := enforcer.checkPolicies(tempDir, tokens)
+	require.NoError(t, err)
+	require.Len(t, res, 6)
+	require.NoError(t, res[0].Error)
+	require.True(t, res[0].Allowed)
+	require.NoError(t, res[1].Error)
+	require.Equal(t, "deny", res[1].PolicyOutput)
+	require.ErrorContains(t, res[2].Error, `unknown protocol "json/v3"`)
+	require.ErrorContains(t, res[3].Error, "protocol json/v2 is only for commands")
+	require.ErrorContains(t, res[4].Error, "invalid json/v2 decision")
+	require.False(t, res[4].Allowed)
+	require.ErrorContains(t, res[5].Error, "exit status 1")
+	require.False(t, res[5].Allowed)
+
+	require.Len(t, requests, 3)
+	require.Equal(t, 2, requests[0].Version)
+	require.Equal(t, "dev", requests[0].Principal)
+	require.JSONEq(t, `{"email":"alice@example.com","groups":["ops"]}`, string(requests[0].Claims))
+	require.Equal(t, "dev", requests[0].Env["OPKSSH_PLUGIN_U"])
+	require.Equal(t, `["ops"]`, requests[0].Env["OPKSSH_PLUGIN_CLAIM_GROUPS"])
+
+	tokens["OPKSSH_PLUGIN_U"] = "root"
+	res, err = enforcer.checkPolicies(tempDir, tokens)
+	require.NoError(t, err)
+	require.NoError(t, res[0].Error)
+	require.False(t, res[0].Allowed)
+	require.Equal(t, "deny", res[0].PolicyOutput)
+	require.Equal(t, "root logins need a ticket", res[0].Reason)
+	require.False(t, res.Allowed())
+}
+
+func TestPluginDecisionOptions(t *testing.T) {
+	decision, err := parseDecision([]byte(`{"decision":"allow","options":["no-port-forwarding","command=\"/usr/bin/backup --daily\"","FROM=\"10.0.0.0/8,192.0.2.1\""]}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"no-port-forwarding", `command="/usr/bin/backup --daily"`, `FROM="10.0.0.0/8,192.0.2.1"`}, decision.Options)
+
+	for option, want := range map[string]string{
+		`environment="PATH=/tmp"`:  "unsupported option",
+		"permit-pty":               "unsupported option",
+		"command":                  "option command requires a value",
+		`no-pty="yes"`:             "option no-pty does not take a value",
+		"from=10.0.0.1":            "must be double-quoted",
+		`from="10.0.0.1`:           "must be double-quoted",
+		`from=10.0.0.1"`:           "must be double-quoted",
+		`command=""`:               "the value of option command is empty",
+		`command="a" ,no-pty`:      "must be double-quoted",
+		`command="a","no-pty"`:     "contains a quote",
+		`command="/bin/sh \\\" x"`: "contains a quote",
+		"command=\"/bin/sh\nid\"":  "control character",
+	} {
+		content, err := json.Marshal(PluginDecision{Decision: "allow", Options: []string{option}})
+		require.NoError(t, err)
+		_, err = parseDecision(content)
+		require.ErrorContains(t, err, want, option)
+	}
+}
+
+func TestPluginResultsOptions(t *testing.T) {
+	results := PluginResults{
+		{Path: "a.yml", Allowed: true, Options: []string{"no-pty", `from="10.0.0.0/8"`}},
+		{Path: "b.yml", Allowed: false, Options: []string{`command="/bin/false"`}},
+		{Path: "c.yml", Allowed: true, Options: []string{"NO-PTY", "restrict"}},
+	}
+	options, err := results.Options()
+	require.NoError(t, err)
+	require.Equal(t, []string{"no-pty", `from="10.0.0.0/8"`, "restrict"}, options)
+
+	// Every allowing plugin restricts the login, they must agree
+	results[2].Options = []string{`from="192.0.2.1"`}
+	_, err = results.Options()
+	require.ErrorContains(t, err, "policy plugins a.yml and c.yml set different values of option from")
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignatureNamespace is the namespace of the detached signatures of plugin
+// configs, as passed to ssh-keygen -Y sign -n
+const SignatureNamespace = "opkssh-plugin"
+
+// SignatureExt is appended to the path of a plugin config to get the path
+// of its detached signature
+const SignatureExt = ".sig"
+
+const sshSigMagic = "SSHSIG"
+
+// sshSig is an SSH signature as written by ssh-keygen -Y sign, after the
+// magic preamble
+type sshSig struct {
+	Version       uint32
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+// signedData is what an SSH signature of a message signs
+type signedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+// VerifySignature returns an error if armored, an SSH signature in the
+// format of ssh-keygen -Y sign, is not a signature of content in the
+// SignatureNamespace by one of trustedKeys
+func VerifySignature(content []byte, armored []byte, trustedKeys []ssh.PublicKey) error {
+	block, _ := pem.Decode(armored)
+	if block == nil || block.Type != "SSH SIGNATURE" {
+		return fmt.Errorf("invalid signature, expected an SSH SIGNATURE block")
+	}
+	blob, ok := bytes.CutPrefix(block.Bytes, []byte(sshSigMagic))
+	if !ok {
+		return fmt.Errorf("invalid signature, missing %s preamble", sshSigMagic)
+	}
+	var sig sshSig
+	if err := ssh.Unmarshal(blob, &sig); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if sig.Version != 1 {
+		return fmt.Errorf("unsupported signature version %d", sig.Version)
+	}
+	if sig.Namespace != SignatureNamespace {
+		return fmt.Errorf("signature is for namespace %q, expected %q", sig.Namespace, SignatureNamespace)
+	}
+	var hash []byte
+	switch sig.HashAlgorithm {
+	case "sha256":
+		sum := sha256.Sum256(content)
+		hash = sum[:]
+	case "sha512":
+		sum := sha512.Sum512(content)
+		hash = sum[:]
+	default:
+		return fmt.Errorf("unsupported signature hash algorithm %q", sig.HashAlgorithm)
+	}
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(sig.Signature, signature); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	signed := append([]byte(sshSigMagic), ssh.Marshal(signedData{
+		Namespace:     sig.Namespace,
+		Reserved:      sig.Reserved,
+		HashAlgorithm: sig.HashAlgorithm,
+		Hash:          hash,
+	})...)
+	for _, key := range trustedKeys {
+		if bytes.Equal(key.Marshal(), sig.PublicKey) && key.Verify(signed, signature) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature is not from a trusted key")
+}
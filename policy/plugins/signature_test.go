@@ -0,0 +1,91 @@
+package plugins
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSignatureKey signed the files in testdata with ssh-keygen -Y sign
+const testSignatureKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIr5mOPfGIBXZ+EnwCI6JyPhp2Hs/EgOp9ilc6eF+cyU plugins@example.com"
+
+func TestVerifySignature(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testSignatureKey))
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ssh.NewPublicKey(otherPub)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("testdata/check.yml")
+	require.NoError(t, err)
+	sig, err := os.ReadFile("testdata/check.yml.sig")
+	require.NoError(t, err)
+	require.NoError(t, VerifySignature(content, sig, []ssh.PublicKey{otherKey, key}))
+
+	require.ErrorContains(t, VerifySignature(content, sig, []ssh.PublicKey{otherKey}), "signature is not from a trusted key")
+	require.ErrorContains(t, VerifySignature(append(content, "timeout: 1h\n"...), sig, []ssh.PublicKey{key}), "signature is not from a trusted key")
+	require.ErrorContains(t, VerifySignature(content, []byte("not a signature"), []ssh.PublicKey{key}), "expected an SSH SIGNATURE block")
+
+	// A signature made for another purpose doesn't sign a plugin config
+	fileSig, err := os.ReadFile("testdata/check.yml.file-namespace.sig")
+	require.NoError(t, err)
+	require.ErrorContains(t, VerifySignature(content, fileSig, []ssh.PublicKey{key}), `signature is for namespace "file", expected "opkssh-plugin"`)
+}
+
+func TestLoadPolicyPluginsSigned(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testSignatureKey))
+	require.NoError(t, err)
+	content, err := os.ReadFile("testdata/check.yml")
+	require.NoError(t, err)
+	sig, err := os.ReadFile("testdata/check.yml.sig")
+	require.NoError(t, err)
+
+	mockFs := afero.NewMemMapFs()
+	tempDir, _ := afero.TempDir(mockFs, "", "policy_test")
+	write := func(name string, data []byte) {
+		require.NoError(t, afero.WriteFile(mockFs, filepath.Join(tempDir, name), data, 0640))
+	}
+	write("a-signed.yml", content)
+	write("a-signed.yml.sig", sig)
+	write("b-unsigned.yml", content)
+	write("c-tampered.yml", append(content, "timeout: 1h\n"...))
+	write("c-tampered.yml.sig", sig)
+
+	enforcer := &PolicyPluginEnforcer{
+		Fs:          mockFs,
+		TrustedKeys: []ssh.PublicKey{key},
+		permChecker: files.PermsChecker{
+			Fs: mockFs,
+			CmdRunner: func(name string, arg ...string) ([]byte, error) {
+				return []byte("root" + " " + "group"), nil
+			},
+		},
+	}
+	res, err := enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Len(t, res, 3)
+	require.NoError(t, res[0].Error)
+	require.Equal(t, "check", res[0].PluginConfig.Name)
+	require.ErrorContains(t, res[1].Error, "b-unsigned.yml) is not signed, expected a signature at")
+	require.ErrorContains(t, res[2].Error, "c-tampered.yml) has an invalid signature")
+
+	// No config is loaded with an empty list of keys
+	enforcer.TrustedKeys = []ssh.PublicKey{}
+	res, err = enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Len(t, res.Errors(), 3)
+
+	// Without keys signatures are not checked
+	enforcer.TrustedKeys = nil
+	res, err = enforcer.loadPlugins(tempDir)
+	require.NoError(t, err)
+	require.Empty(t, res.Errors())
+}
@@ -0,0 +1,177 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+// Headers of a signed webhook request
+const (
+	WebhookTimestampHeader = "X-Opkssh-Timestamp"
+	WebhookSignatureHeader = "X-Opkssh-Signature"
+)
+
+// requiredSecretPerms are the modes of the HMAC secret and client key of a
+// webhook, which must not be readable by others
+var requiredSecretPerms = []fs.FileMode{fs.FileMode(0600), fs.FileMode(0640)}
+
+// ValidateWebhook returns why the webhook fields of the config are invalid
+func (config PluginConfig) ValidateWebhook() error {
+	if config.URL == "" {
+		return fmt.Errorf("policy plugin config missing required field 'url'")
+	}
+	if config.Command != "" {
+		return fmt.Errorf("policy plugin config of type webhook can't set 'command'")
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid url %q, expected an https URL", config.URL)
+	}
+	if (config.ClientCert == "") != (config.ClientKey == "") {
+		return fmt.Errorf("policy plugin config must set both 'client_cert' and 'client_key'")
+	}
+	return nil
+}
+
+// webhookSignature returns the value of the signature header of body sent at
+// timestamp: the hex HMAC-SHA256 of "<timestamp>.<body>" with secret
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// readSecret reads a file that only root may write and others may not read
+func (p *PolicyPluginEnforcer) readSecret(path string) ([]byte, error) {
+	content, err := p.permChecker.ReadFile(path, requiredSecretPerms, "root", "")
+	var permsErr *files.PermsError
+	if errors.As(err, &permsErr) {
+		return nil, fmt.Errorf("policy plugin secret (%s) has insecure permissions: %w", path, err)
+	}
+	return content, err
+}
+
+// webhookClient returns the HTTP client of the webhook of config, with the
+// CA and client certificate it sets
+func (p *PolicyPluginEnforcer) webhookClient(config PluginConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.CAFile != "" {
+		pem, err := afero.ReadFile(p.Fs, config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file (%s)", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" {
+		certPEM, err := afero.ReadFile(p.Fs, config.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client_cert: %w", err)
+		}
+		keyPEM, err := p.readSecret(config.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client_cert or client_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+		// A redirect could send the login details somewhere else
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}, nil
+}
+
+// executeWebhook POSTs the login described by tokens to the webhook of config
+// and returns the decision it answered with
+func (p *PolicyPluginEnforcer) executeWebhook(ctx context.Context, config PluginConfig, tokens map[string]string) ([]string, PluginDecision, error) {
+	commandRun := []string{http.MethodPost, config.URL}
+	webhookReq, err := newPluginRequest(tokens)
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+	body, err := json.Marshal(webhookReq)
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+	client, err := p.webhookClient(config)
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, bytes.NewReader(body))
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.HMACSecretFile != "" {
+		secret, err := p.readSecret(config.HMACSecretFile)
+		if err != nil {
+			return commandRun, PluginDecision{}, err
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return commandRun, PluginDecision{}, fmt.Errorf("hmac_secret_file (%s) is empty", config.HMACSecretFile)
+		}
+		timestamp := strconv.FormatInt(p.now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, webhookSignature(secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxDecisionSize))
+	if err != nil {
+		return commandRun, PluginDecision{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return commandRun, PluginDecision{}, fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	decision, err := parseDecision(respBody)
+	if err != nil {
+		return commandRun, PluginDecision{}, fmt.Errorf("invalid webhook response: %w", err)
+	}
+	return commandRun, decision, nil
+}
@@ -17,9 +17,9 @@
 package policy
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/openpubkey/opkssh/policy/files"
 )
@@ -43,6 +43,14 @@ type User struct {
 	Principals []string
 	// Sub        string
 	Issuer string
+	// Expires, if set, is when the entry stops allowing logins
+	Expires time.Time
+	// CatchAll annotates a principal pattern that is meant to match
+	// privileged accounts such as root
+	CatchAll bool
+	// KeyOptions are authorized_keys options, such as no-pty or
+	// from="10.0.0.0/8", that restrict the logins the entry allows
+	KeyOptions []string
 }
 
 // Policy represents an opkssh policy
@@ -57,49 +65,102 @@ type Policy struct {
 // prevent all users from logging in.
 func FromTable(input []byte, path string) (*Policy, []files.ConfigProblem) {
 	problems := []files.ConfigProblem{}
-	table := files.NewTable(input)
+	report := func(content string, err *files.ParseError) {
+		configProblem := files.ConfigProblem{
+			Filepath:      path,
+			OffendingLine: content,
+			ErrorMessage:  err.Error(),
+			Source:        "user policy file",
+		}
+		problems = append(problems, configProblem)
+		files.ConfigProblems().RecordProblem(configProblem)
+	}
+
 	policy := &Policy{}
-	for _, row := range table.GetRows() {
+	for _, row := range files.ParseRows(input) {
 		// Error should not break everyone's ability to login, skip those rows
-		if len(row) != 3 {
-			configProblem := files.ConfigProblem{
-				Filepath:      path,
-				OffendingLine: strings.Join(row, " "),
-				ErrorMessage:  fmt.Sprintf("wrong number of arguments (expected=3, got=%d)", len(row)),
-				Source:        "user policy file",
-			}
-			problems = append(problems, configProblem)
-			files.ConfigProblems().RecordProblem(configProblem)
+		if row.Err != nil {
+			report(row.Content, row.Err)
 			continue
 		}
-		user := User{
-			Principals:        []string{row[0]},
-			IdentityAttribute: row[1],
-			Issuer:            row[2],
+		user, err := ParseEntry(row)
+		if err != nil {
+			report(row.Content, err)
+			continue
 		}
 		policy.Users = append(policy.Users, user)
 	}
 	return policy, problems
 }
 
+// ParseEntry returns the policy entry of row, a row of a policy file
+// without a syntax error. Principal patterns are compiled.
+func ParseEntry(row files.Row) (User, *files.ParseError) {
+	if len(row.Columns) < 3 {
+		return User{}, row.CheckColumns("principal", "identity", "issuer")
+	}
+	user := User{
+		Principals:        []string{row.Columns[0]},
+		IdentityAttribute: row.Columns[1],
+		Issuer:            row.Columns[2],
+	}
+	if IsPrincipalPattern(row.Columns[0]) {
+		if _, err := CompilePrincipalPattern(row.Columns[0]); err != nil {
+			return User{}, &files.ParseError{
+				Line:       row.Line,
+				Column:     row.Offsets[0],
+				Token:      row.Columns[0],
+				Message:    err.Error(),
+				Suggestion: "write the principal as a glob such as dev-* or a regular expression such as re:dev-[0-9]+",
+			}
+		}
+	}
+	if domain, ok := strings.CutPrefix(user.IdentityAttribute, EMAIL_DOMAIN); ok && (domain == "" || strings.Contains(domain, "@")) {
+		return User{}, &files.ParseError{
+			Line:       row.Line,
+			Column:     row.Offsets[1],
+			Token:      row.Columns[1],
+			Message:    "invalid email domain",
+			Suggestion: "write the domain without @, such as " + EMAIL_DOMAIN + "example.com",
+		}
+	}
+	if err := user.parseOptions(row); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
 // AddAllowedPrincipal adds a new allowed principal to the user whose email is
 // equal to userEmail. If no user can be found with the email userEmail, then a
 // new user entry is added with an initial allowed principals list containing
 // principal. No changes are made if the principal is already allowed for this
 // user.
 func (p *Policy) AddAllowedPrincipal(principal string, userEmail string, issuer string) {
-	var firstMatchingEntry *User // First entry that matches on userEmail AND issuer
+	p.AddAllowedPrincipalUntil(principal, userEmail, issuer, time.Time{})
+}
+
+// AddAllowedPrincipalUntil is AddAllowedPrincipal for an entry that expires
+// at expires, or never if it is zero. If the principal is already allowed
+// with another expiry, the expiry is replaced.
+func (p *Policy) AddAllowedPrincipalUntil(principal string, userEmail string, issuer string, expires time.Time) {
+	p.removeOtherExpiry(principal, userEmail, issuer, expires)
+
+	var firstMatchingEntry *User // First entry that matches on userEmail AND issuer AND expires
 	for i := range p.Users {
 		// Search to see if the current user already has an entry that matches on userEmail AND issuer
 		user := &p.Users[i]
-		if user.IdentityAttribute == userEmail && user.Issuer == issuer {
+		if user.IdentityAttribute == userEmail && user.Issuer == issuer && user.Expires.Equal(expires) {
+			if len(user.KeyOptions) > 0 {
+				// The principal must not get the restrictions of the entry
+				continue
+			}
 			if firstMatchingEntry == nil {
 				firstMatchingEntry = user
 			}
 			for _, p := range user.Principals {
 				if p == principal {
 					// If we find an entry that matches on userEmail AND issuer AND principal, nothing to add
-					log.Printf("User with email %s already has access under the principal %s, skipping...\n", userEmail, principal)
+					slog.Info("User already has access under the principal, skipping", "email", userEmail, "principal", principal)
 					return // return early, attempting to add a duplicate policy, a policy which already exists
 				}
 			}
@@ -110,7 +171,7 @@ func (p *Policy) AddAllowedPrincipal(principal string, userEmail string, issuer
 		// If we are here, then we found an entry where userEmail and user.Issuer match, but not the principal.
 		// Add the principal to that entries list of principals
 		firstMatchingEntry.Principals = append(firstMatchingEntry.Principals, principal)
-		log.Printf("Successfully added user with email %s with principal %s to the policy file\n", userEmail, principal)
+		slog.Info("Added user to the policy file", "email", userEmail, "principal", principal)
 		return // Done, we added the principal to the existing user
 	}
 
@@ -120,10 +181,59 @@ func (p *Policy) AddAllowedPrincipal(principal string, userEmail string, issuer
 		IdentityAttribute: userEmail,
 		Principals:        []string{principal},
 		Issuer:            issuer,
+		Expires:           expires,
 	}
 	// Add the new user to the list of users in the policy
 	p.Users = append(p.Users, newUser)
-	log.Printf("Successfully added user with email %s with principal %s to the policy file\n", userEmail, principal)
+	slog.Info("Added user to the policy file", "email", userEmail, "principal", principal)
+}
+
+// removeOtherExpiry removes principal from the entries of userEmail and
+// issuer that expire at another time than expires
+func (p *Policy) removeOtherExpiry(principal string, userEmail string, issuer string, expires time.Time) {
+	kept := p.Users[:0]
+	for _, user := range p.Users {
+		if user.IdentityAttribute == userEmail && user.Issuer == issuer && !user.Expires.Equal(expires) {
+			principals := []string{}
+			for _, p := range user.Principals {
+				if p != principal {
+					principals = append(principals, p)
+				}
+			}
+			if len(principals) == 0 {
+				continue
+			}
+			user.Principals = principals
+		}
+		kept = append(kept, user)
+	}
+	p.Users = kept
+}
+
+// RemoveIdentity removes every entry for issuer whose identity attribute is
+// one of identities (compared case insensitively). Returns the entries
+// removed.
+func (p *Policy) RemoveIdentity(issuer string, identities ...string) []User {
+	removed := []User{}
+	kept := p.Users[:0]
+	for _, user := range p.Users {
+		matched := false
+		if user.Issuer == issuer {
+			for _, identity := range identities {
+				if identity != "" && strings.EqualFold(user.IdentityAttribute, identity) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			removed = append(removed, user)
+		} else {
+			kept = append(kept, user)
+		}
+	}
+	p.Users = kept
+	return removed
 }
 
 // ToTable encodes the policy into a whitespace delimited table
@@ -131,7 +241,7 @@ func (p *Policy) ToTable() ([]byte, error) {
 	table := files.Table{}
 	for _, user := range p.Users {
 		for _, principal := range user.Principals {
-			table.AddRow(principal, user.IdentityAttribute, user.Issuer)
+			table.AddRow(append([]string{principal, user.IdentityAttribute, user.Issuer}, user.options()...)...)
 		}
 	}
 	return table.ToBytes(), nil
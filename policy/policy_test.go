@@ -17,7 +17,9 @@
 package policy_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/openpubkey/opkssh/policy"
 	"github.com/stretchr/testify/assert"
@@ -267,3 +269,158 @@ func TestAddAllowedPrincipal(t *testing.T) {
 		})
 	}
 }
+
+func TestRemoveIdentity(t *testing.T) {
+	t.Parallel()
+
+	p := &policy.Policy{
+		Users: []policy.User{
+			{IdentityAttribute: "alice@example.com", Principals: []string{"root"}, Issuer: "https://example.com"},
+			{IdentityAttribute: "bob@example.com", Principals: []string{"root"}, Issuer: "https://example.com"},
+			{IdentityAttribute: "00u1abcd", Principals: []string{"dev"}, Issuer: "https://example.com"},
+			{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://other.example.com"},
+		},
+	}
+
+	removed := p.RemoveIdentity("https://example.com", "Alice@Example.com", "00u1abcd")
+	assert.Len(t, removed, 2)
+	assert.Equal(t, []policy.User{
+		{IdentityAttribute: "bob@example.com", Principals: []string{"root"}, Issuer: "https://example.com"},
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://other.example.com"},
+	}, p.Users)
+
+	assert.Empty(t, p.RemoveIdentity("https://example.com", ""))
+}
+
+func TestFromTableProblems(t *testing.T) {
+	input := "root alice@example.com https://accounts.google.com\n" +
+		"root 'bob@example.com https://accounts.google.com\n" +
+		"root carol@example.com\n" +
+		"root dave@example.com https://accounts.google.com\n"
+	p, problems := policy.FromTable([]byte(input), "/etc/opk/auth_id")
+
+	// Every problem is reported and the valid lines are still loaded
+	assert.Len(t, p.Users, 2)
+	assert.Equal(t, "dave@example.com", p.Users[1].IdentityAttribute)
+	assert.Len(t, problems, 2)
+	assert.Equal(t, "line 2, column 6: unterminated single-quoted string at \"'bob@example.com https://accounts.google...\"; add the closing '", problems[0].ErrorMessage)
+	assert.Equal(t, "root 'bob@example.com https://accounts.google.com", problems[0].OffendingLine)
+	assert.Equal(t, "line 3, column 23: wrong number of arguments (expected=3, got=2); missing issuer, expected principal identity issuer", problems[1].ErrorMessage)
+}
+
+func TestFromTableEmailDomain(t *testing.T) {
+	input := "deploy domain:example.com https://accounts.google.com\n" +
+		"deploy domain:@example.com https://accounts.google.com\n" +
+		"deploy domain: https://accounts.google.com\n"
+	p, problems := policy.FromTable([]byte(input), "/etc/opk/auth_id")
+
+	assert.Len(t, p.Users, 1)
+	assert.Equal(t, "domain:example.com", p.Users[0].IdentityAttribute)
+	assert.Len(t, problems, 2)
+	assert.Equal(t, `line 2, column 8: invalid email domain at "domain:@example.com"; write the domain without @, such as domain:example.com`, problems[0].ErrorMessage)
+}
+
+func TestFromTableExpiry(t *testing.T) {
+	input := "root alice@example.com https://accounts.google.com expires=2025-12-31\n" +
+		"dev alice@example.com https://accounts.google.com expires=2025-12-31T18:00:00+02:00\n" +
+		"root bob@example.com https://accounts.google.com expires=tomorrow\n" +
+		"root carol@example.com https://accounts.google.com smith\n" +
+		"root dave@example.com https://accounts.google.com color=blue\n"
+	p, problems := policy.FromTable([]byte(input), "/etc/opk/auth_id")
+
+	assert.Len(t, p.Users, 2)
+	assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), p.Users[0].Expires)
+	assert.True(t, p.Users[1].Expires.Equal(time.Date(2025, 12, 31, 16, 0, 0, 0, time.UTC)))
+	assert.False(t, p.Users[0].Expired(time.Date(2025, 12, 31, 23, 59, 59, 0, time.UTC)))
+	assert.True(t, p.Users[0].Expired(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, policy.User{}.Expired(time.Now()))
+
+	// Entries with an invalid or unknown option are skipped
+	assert.Len(t, problems, 3)
+	assert.Contains(t, problems[0].ErrorMessage, `line 3, column 50: invalid expiry "tomorrow"`)
+	assert.Contains(t, problems[1].ErrorMessage, "line 4, column 52: wrong number of arguments (expected=3, got=4)")
+	assert.Contains(t, problems[2].ErrorMessage, "line 5, column 51: unknown option color")
+
+	// The expiry is written back as it was read
+	table, err := p.ToTable()
+	assert.NoError(t, err)
+	assert.Equal(t, "root alice@example.com https://accounts.google.com expires=2025-12-31\n"+
+		"dev alice@example.com https://accounts.google.com expires=2025-12-31T18:00:00+02:00\n", string(table))
+}
+
+func TestFromTableKeyOptions(t *testing.T) {
+	input := "backup ci@example.com https://accounts.google.com NO-PTY 'command=/usr/bin/rrsync -ro /backup' from=10.0.0.0/8,192.0.2.1\n" +
+		"backup ci@example.com https://accounts.google.com no-pty=yes\n" +
+		"backup ci@example.com https://accounts.google.com from\n" +
+		"backup ci@example.com https://accounts.google.com 'command=/bin/sh -c \"id\"'\n" +
+		"backup ci@example.com https://accounts.google.com environment=PATH=/tmp\n"
+	p, problems := policy.FromTable([]byte(input), "/etc/opk/auth_id")
+
+	assert.Len(t, p.Users, 1)
+	assert.Equal(t, []string{"no-pty", `command="/usr/bin/rrsync -ro /backup"`, `from="10.0.0.0/8,192.0.2.1"`}, p.Users[0].KeyOptions)
+	assert.Len(t, problems, 4)
+	assert.Contains(t, problems[0].ErrorMessage, "option no-pty does not take a value")
+	assert.Contains(t, problems[1].ErrorMessage, "option from requires a value")
+	assert.Contains(t, problems[2].ErrorMessage, "contains a quote")
+	assert.Contains(t, problems[3].ErrorMessage, "unknown option environment")
+
+	table, err := p.ToTable()
+	assert.NoError(t, err)
+	assert.Equal(t, "backup ci@example.com https://accounts.google.com no-pty 'command=/usr/bin/rrsync -ro /backup' from=10.0.0.0/8,192.0.2.1\n", string(table))
+
+	// Adding a principal doesn't give it the restrictions of an entry
+	p.AddAllowedPrincipal("root", "ci@example.com", "https://accounts.google.com")
+	assert.Len(t, p.Users, 2)
+	assert.Empty(t, p.Users[1].KeyOptions)
+}
+
+func TestAddAllowedPrincipalUntil(t *testing.T) {
+	expires := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := &policy.Policy{}
+	p.AddAllowedPrincipal("root", "alice@example.com", "https://example.com")
+	p.AddAllowedPrincipalUntil("dev", "alice@example.com", "https://example.com", expires)
+	p.AddAllowedPrincipalUntil("test", "alice@example.com", "https://example.com", expires)
+	assert.Equal(t, []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"root"}, Issuer: "https://example.com"},
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev", "test"}, Issuer: "https://example.com", Expires: expires},
+	}, p.Users)
+
+	// Adding an allowed principal again replaces its expiry
+	p.AddAllowedPrincipal("dev", "alice@example.com", "https://example.com")
+	p.AddAllowedPrincipalUntil("root", "alice@example.com", "https://example.com", expires)
+	assert.Equal(t, []policy.User{
+		{IdentityAttribute: "alice@example.com", Principals: []string{"dev"}, Issuer: "https://example.com"},
+		{IdentityAttribute: "alice@example.com", Principals: []string{"test", "root"}, Issuer: "https://example.com", Expires: expires},
+	}, p.Users)
+}
+
+func FuzzFromTable(f *testing.F) {
+	f.Add([]byte("root alice@example.com https://accounts.google.com\n"))
+	f.Add([]byte("root oidc:groups:ssh-users https://example.com # admins\nalice 'a b'\n"))
+	f.Add([]byte("root \"oidc:claims[\\\"x\\\"]\" https://example.com\r\nbob\\"))
+	f.Fuzz(func(t *testing.T, input []byte) {
+		p, problems := policy.FromTable(input, "/etc/opk/auth_id")
+		lines := strings.Count(string(input), "\n") + 1
+		if len(p.Users)+len(problems) > lines {
+			t.Fatalf("%d users and %d problems from %d lines", len(p.Users), len(problems), lines)
+		}
+		for _, u := range p.Users {
+			if len(u.Principals) != 1 {
+				t.Fatalf("bad user %#v", u)
+			}
+		}
+
+		// The policy written by ToTable loads back the same
+		table, err := p.ToTable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded, problems := policy.FromTable(table, "/etc/opk/auth_id")
+		if len(problems) > 0 {
+			t.Fatalf("ToTable wrote %q which has problems: %v", table, problems)
+		}
+		if len(reloaded.Users) != len(p.Users) {
+			t.Fatalf("round trip changed %d users to %d", len(p.Users), len(reloaded.Users))
+		}
+	})
+}
@@ -0,0 +1,200 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+
+	// Registers the pure Go sqlite driver, so opkssh still builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+// SystemDefaultPolicyDBPath is the default path of the SQLite policy store
+var SystemDefaultPolicyDBPath = filepath.Join(GetSystemConfigBasePath(), "policy.db")
+
+// policyDBSchema stores one row per principal, identity and issuer, as in a
+// line of the policy file. Rows are loaded in the order they were added.
+const policyDBSchema = `CREATE TABLE IF NOT EXISTS entries (
+	id INTEGER PRIMARY KEY,
+	principal TEXT NOT NULL,
+	identity TEXT NOT NULL,
+	issuer TEXT NOT NULL,
+	expires TEXT NOT NULL DEFAULT '',
+	UNIQUE (principal, identity, issuer)
+)`
+
+// PolicyDB is a system policy stored in a SQLite database instead of a flat
+// file, for servers with many entries. It holds the same entries and the
+// database file must have the permissions of the policy file.
+type PolicyDB struct {
+	Path string
+	// Ops, if set, is used to give the AuthorizedKeysCommandUser group
+	// ownership of a created database
+	Ops files.FilePermsOps
+}
+
+// NewPolicyDB returns the policy store at SystemDefaultPolicyDBPath
+func NewPolicyDB() *PolicyDB {
+	return &PolicyDB{
+		Path: SystemDefaultPolicyDBPath,
+		Ops:  files.NewDefaultFilePermsOps(afero.NewOsFs()),
+	}
+}
+
+// open opens the database. Reads use a read-only connection so that verify
+// only needs read access to the file.
+func (d *PolicyDB) open(readOnly bool) (*sql.DB, error) {
+	mode := "rw"
+	if readOnly {
+		mode = "ro"
+	}
+	dsn := (&url.URL{Scheme: "file", OmitHost: true, Path: filepath.ToSlash(d.Path), RawQuery: "mode=" + mode + "&_pragma=busy_timeout(5000)"}).String()
+	return sql.Open("sqlite", dsn)
+}
+
+// CreateIfDoesNotExist creates the database with the permissions and owner
+// of the policy file if it does not exist
+func (d *PolicyDB) CreateIfDoesNotExist() error {
+	f, err := os.OpenFile(d.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, files.ModeSystemPerms)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to create policy database: %w", err)
+	}
+	f.Close()
+	if err := os.Chmod(d.Path, files.ModeSystemPerms); err != nil {
+		return fmt.Errorf("failed to set policy database permissions: %w", err)
+	}
+	if d.Ops != nil {
+		if err := d.Ops.Chown(d.Path, Defaults.Owner, Defaults.Group); err != nil {
+			return fmt.Errorf("failed to set policy database ownership: %w", err)
+		}
+	}
+	db, err := d.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if _, err := db.Exec(policyDBSchema); err != nil {
+		return fmt.Errorf("failed to create policy database: %w", err)
+	}
+	return nil
+}
+
+// Load reads the policy in the database. Like the policy file it must have
+// the permissions of files.ModeSystemPerms.
+func (d *PolicyDB) Load() (*Policy, error) {
+	if _, err := os.Stat(d.Path); err != nil {
+		return nil, fmt.Errorf("failed to describe the file at path: %w", err)
+	}
+	if err := files.NewPermsChecker(afero.NewOsFs()).CheckPerm(d.Path, []fs.FileMode{files.ModeSystemPerms}, "", ""); err != nil {
+		return nil, fmt.Errorf("policy file has insecure permissions: %w", err)
+	}
+	db, err := d.open(true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT principal, identity, issuer, expires FROM entries ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy database %s: %w", d.Path, err)
+	}
+	defer rows.Close()
+	policy := &Policy{}
+	for rows.Next() {
+		var principal, expires string
+		user := User{}
+		if err := rows.Scan(&principal, &user.IdentityAttribute, &user.Issuer, &expires); err != nil {
+			return nil, fmt.Errorf("failed to read policy database %s: %w", d.Path, err)
+		}
+		if expires != "" {
+			// Like an invalid line of the policy file, skip the entry
+			if user.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+				slog.Warn("Skipping entry with invalid expiry", "principal", principal, "path", d.Path, "expires", expires)
+				continue
+			}
+		}
+		user.Principals = []string{principal}
+		policy.Users = append(policy.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read policy database %s: %w", d.Path, err)
+	}
+	return policy, nil
+}
+
+// Store replaces the entries in the database with those of policy in a
+// single transaction, readers see either the old or the new policy
+func (d *PolicyDB) Store(policy *Policy) error {
+	db, err := d.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(policyDBSchema); err != nil {
+		return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+	}
+	if _, err := tx.Exec("DELETE FROM entries"); err != nil {
+		return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+	}
+	insert, err := tx.Prepare("INSERT OR IGNORE INTO entries (principal, identity, issuer, expires) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+	}
+	defer insert.Close()
+	for _, user := range policy.Users {
+		if len(user.KeyOptions) > 0 {
+			// Storing the entry without its restrictions would widen it
+			return fmt.Errorf("failed to write policy database %s: the entry of %s has authorized_keys options, which the database can't hold", d.Path, user.IdentityAttribute)
+		}
+		if user.CatchAll {
+			// Dropping the option would make lint report the entry
+			return fmt.Errorf("failed to write policy database %s: the entry of %s has the %s option, which the database can't hold", d.Path, user.IdentityAttribute, CatchAllOption)
+		}
+		expires := ""
+		if !user.Expires.IsZero() {
+			expires = user.Expires.UTC().Format(time.RFC3339)
+		}
+		for _, principal := range user.Principals {
+			if _, err := insert.Exec(principal, user.IdentityAttribute, user.Issuer, expires); err != nil {
+				return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to write policy database %s: %w", d.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyDB(t *testing.T) {
+	db := &policy.PolicyDB{Path: filepath.Join(t.TempDir(), "policy.db")}
+	require.NoError(t, db.CreateIfDoesNotExist())
+	// Creating it again keeps the entries
+	require.NoError(t, db.CreateIfDoesNotExist())
+
+	p, err := db.Load()
+	require.NoError(t, err)
+	require.Empty(t, p.Users)
+
+	expires := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.AddAllowedPrincipal("root", "alice@example.com", "https://accounts.google.com")
+	p.AddAllowedPrincipal("dev", "alice@example.com", "https://accounts.google.com")
+	p.AddAllowedPrincipalUntil("guest", "oidc:groups:a b", "https://accounts.google.com", expires)
+	require.NoError(t, db.Store(p))
+
+	// The entries read back make the same policy file
+	loaded, err := db.Load()
+	require.NoError(t, err)
+	want, err := p.ToTable()
+	require.NoError(t, err)
+	got, err := loaded.ToTable()
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(got))
+	require.Equal(t, "root alice@example.com https://accounts.google.com\n"+
+		"dev alice@example.com https://accounts.google.com\n"+
+		"guest 'oidc:groups:a b' https://accounts.google.com expires=2025-12-31\n", string(got))
+
+	// Entries can't lose their restrictions
+	restricted := &policy.Policy{Users: []policy.User{{IdentityAttribute: "ci@example.com", Principals: []string{"backup"}, Issuer: "https://accounts.google.com", KeyOptions: []string{"no-pty"}}}}
+	require.ErrorContains(t, db.Store(restricted), "the entry of ci@example.com has authorized_keys options")
+
+	catchAll := &policy.Policy{Users: []policy.User{{IdentityAttribute: "breakglass@example.com", Principals: []string{"*"}, Issuer: "https://accounts.google.com", CatchAll: true}}}
+	require.ErrorContains(t, db.Store(catchAll), "the entry of breakglass@example.com has the catchall option")
+
+	// Storing replaces the entries
+	require.NoError(t, db.Store(&policy.Policy{}))
+	loaded, err = db.Load()
+	require.NoError(t, err)
+	require.Empty(t, loaded.Users)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(db.Path)
+		require.NoError(t, err)
+		require.Equal(t, files.ModeSystemPerms, info.Mode().Perm())
+
+		require.NoError(t, os.Chmod(db.Path, 0o644))
+		_, err = db.Load()
+		require.ErrorContains(t, err, "policy file has insecure permissions")
+	}
+}
+
+func TestSystemPolicyLoaderDB(t *testing.T) {
+	db := &policy.PolicyDB{Path: filepath.Join(t.TempDir(), "policy.db")}
+	loader := policy.SystemPolicyLoader{PolicyLoader: &policy.PolicyLoader{}, DB: db}
+	require.Equal(t, db.Path, loader.Path())
+
+	_, _, err := loader.LoadSystemPolicy()
+	require.ErrorContains(t, err, "failed to read system policy database")
+
+	require.NoError(t, loader.CreateIfDoesNotExist(loader.Path()))
+	p := &policy.Policy{}
+	p.AddAllowedPrincipal("root", "alice@example.com", "https://accounts.google.com")
+	require.NoError(t, loader.Dump(p, loader.Path()))
+
+	loaded, source, err := loader.LoadSystemPolicy()
+	require.NoError(t, err)
+	require.Equal(t, db.Path, source.Source())
+	require.Equal(t, p.Users, loaded.Users)
+
+	// A store that can't be used is not replaced by the policy file
+	loader.StoreErr = os.ErrInvalid
+	_, _, err = loader.LoadSystemPolicy()
+	require.ErrorIs(t, err, os.ErrInvalid)
+}
@@ -28,11 +28,27 @@ import (
 
 // SystemDefaultPolicyPath is the default filepath where opkssh policy is
 // defined. On Unix: /etc/opk/auth_id, On Windows: %ProgramData%\opk\auth_id
-var SystemDefaultPolicyPath = filepath.Join(GetSystemConfigBasePath(), "auth_id")
+var SystemDefaultPolicyPath = SystemConfigPath("auth_id")
 
 // SystemDefaultProvidersPath is the default filepath where opkssh provider
 // definitions are configured
-var SystemDefaultProvidersPath = filepath.Join(GetSystemConfigBasePath(), "providers")
+var SystemDefaultProvidersPath = SystemConfigPath("providers")
+
+// SystemDefaultServerConfigPath is the default filepath of the opkssh server
+// config
+var SystemDefaultServerConfigPath = SystemConfigPath("config.yml")
+
+// SystemDefaultLDAPConfigPath is the default filepath of the LDAP group
+// policy
+var SystemDefaultLDAPConfigPath = SystemConfigPath("ldap.yml")
+
+// SystemDefaultCAKeyPath is the default filepath of the private key of the
+// SSH CA of opkssh ca
+var SystemDefaultCAKeyPath = filepath.Join(GetSystemConfigBasePath(), "ca_key")
+
+// SystemDefaultCAPublicKeysPath is the default filepath of the public keys
+// of the SSH CA, the file sshd's TrustedUserCAKeys points to
+var SystemDefaultCAPublicKeysPath = filepath.Join(GetSystemConfigBasePath(), "ca_keys.pub")
 
 // UserLookup defines the minimal interface to lookup users on the current
 // system
@@ -78,6 +94,9 @@ func (l *PolicyLoader) LoadPolicyAtPath(path string) (*Policy, error) {
 // Dump encodes the policy into file and writes the contents to the filepath
 // path
 func (l *PolicyLoader) Dump(policy *Policy, path string) error {
+	if IsVendorConfigPath(path) {
+		return fmt.Errorf("policy file %s is a read-only distribution default, copy it to %s to change it", path, filepath.Join(GetSystemConfigBasePath(), filepath.Base(path)))
+	}
 	fileBytes, err := policy.ToTable()
 	if err != nil {
 		return err
@@ -91,14 +110,21 @@ func (l *PolicyLoader) Dump(policy *Policy, path string) error {
 	return nil
 }
 
+// SystemDefaultBackupDir is the default directory of the backups of the
+// system policy made before it is changed
+var SystemDefaultBackupDir = filepath.Join(GetSystemStateBasePath(), "backups")
+
 // NewSystemPolicyLoader returns an opkssh policy loader that uses the os library to
-// read/write system policy from/to the filesystem.
+// read/write system policy from/to the filesystem. The system policy is
+// backed up to SystemDefaultBackupDir before it is changed.
 func NewSystemPolicyLoader() *SystemPolicyLoader {
+	osFs := afero.NewOsFs()
 	return &SystemPolicyLoader{
 		PolicyLoader: &PolicyLoader{
 			FileLoader: files.FileLoader{
-				Fs:           afero.NewOsFs(),
+				Fs:           osFs,
 				RequiredPerm: files.ModeSystemPerms,
+				Backups:      files.NewBackups(osFs, SystemDefaultBackupDir),
 			},
 			UserLookup: NewOsUserLookup(),
 		},
@@ -110,12 +136,59 @@ func NewSystemPolicyLoader() *SystemPolicyLoader {
 // and return an error immediately if the permission bits are invalid.
 type SystemPolicyLoader struct {
 	*PolicyLoader
+	// DB, if set, stores the system policy instead of the policy file
+	DB *PolicyDB
+	// StoreErr, if set, is why the configured store can not be used. The
+	// system policy is not loaded rather than read from the wrong store.
+	StoreErr error
 }
 
-// LoadSystemPolicy reads the opkssh policy at SystemDefaultPolicyPath.
-// An error is returned if the file cannot be read or if the permissions bits
-// are not correct.
+// Path returns the path of the system policy, the policy file or the
+// database
+func (s *SystemPolicyLoader) Path() string {
+	if s.DB != nil {
+		return s.DB.Path
+	}
+	return SystemDefaultPolicyPath
+}
+
+// CreateIfDoesNotExist creates the system policy at path, or the database,
+// if it does not exist
+func (s *SystemPolicyLoader) CreateIfDoesNotExist(path string) error {
+	if s.DB != nil {
+		return s.DB.CreateIfDoesNotExist()
+	}
+	return s.PolicyLoader.CreateIfDoesNotExist(path)
+}
+
+// Dump writes policy to the system policy file at path, or replaces the
+// entries of the database
+func (s *SystemPolicyLoader) Dump(policy *Policy, path string) error {
+	if s.DB == nil {
+		return s.PolicyLoader.Dump(policy, path)
+	}
+	if s.FileLoader.Backups != nil {
+		if _, err := s.FileLoader.Backups.Save(s.DB.Path); err != nil {
+			return err
+		}
+	}
+	return s.DB.Store(policy)
+}
+
+// LoadSystemPolicy reads the opkssh policy at SystemDefaultPolicyPath, or in
+// the database if DB is set. An error is returned if the file cannot be read
+// or if the permissions bits are not correct.
 func (s *SystemPolicyLoader) LoadSystemPolicy() (*Policy, Source, error) {
+	if s.StoreErr != nil {
+		return nil, EmptySource{}, s.StoreErr
+	}
+	if s.DB != nil {
+		policy, err := s.DB.Load()
+		if err != nil {
+			return nil, EmptySource{}, fmt.Errorf("failed to read system policy database %s: %w", s.DB.Path, err)
+		}
+		return policy, FileSource(s.DB.Path), nil
+	}
 	policy, err := s.LoadPolicyAtPath(SystemDefaultPolicyPath)
 	if err != nil {
 		return nil, EmptySource{}, fmt.Errorf("failed to read system default policy file %s: %w", SystemDefaultPolicyPath, err)
@@ -130,6 +203,8 @@ type OptionalLoader func(h *HomePolicyLoader, username string) ([]byte, error)
 // and return an error immediately if the permission bits are invalid.
 type HomePolicyLoader struct {
 	*PolicyLoader
+	// Reader, if set, reads the policy file instead of FileLoader
+	Reader *HomeReader
 }
 
 // NewHomePolicyLoader returns an opkssh policy loader that uses the os library to
@@ -143,6 +218,7 @@ func NewHomePolicyLoader() *HomePolicyLoader {
 			},
 			UserLookup: NewOsUserLookup(),
 		},
+		Reader: NewHomeReader(),
 	}
 }
 
@@ -163,7 +239,7 @@ func (h *HomePolicyLoader) LoadHomePolicy(username string, skipInvalidEntries bo
 		return nil, "", fmt.Errorf("error getting user policy path for user %s: %w", username, err)
 	}
 
-	policyBytes, userPolicyErr := h.FileLoader.LoadFileAtPath(policyFilePath)
+	policyBytes, userPolicyErr := h.readPolicyFile(username, policyFilePath)
 	if userPolicyErr != nil {
 		if len(optLoader) == 1 {
 			// Try to read using the optional loader
@@ -191,6 +267,8 @@ func (h *HomePolicyLoader) LoadHomePolicy(username string, skipInvalidEntries bo
 					IdentityAttribute: user.IdentityAttribute,
 					Principals:        []string{username},
 					Issuer:            user.Issuer,
+					Expires:           user.Expires,
+					KeyOptions:        user.KeyOptions,
 				})
 			}
 		}
@@ -201,6 +279,17 @@ func (h *HomePolicyLoader) LoadHomePolicy(username string, skipInvalidEntries bo
 	}
 }
 
+func (h *HomePolicyLoader) readPolicyFile(username string, path string) ([]byte, error) {
+	if h.Reader == nil {
+		return h.FileLoader.LoadFileAtPath(path)
+	}
+	user, err := h.UserLookup.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup username %s: %w", username, err)
+	}
+	return h.Reader.Read(user)
+}
+
 // UserPolicyPath returns the path to the user's opkssh policy file at
 // ~/.opk/auth_id (Unix) or %USERPROFILE%\.opk\auth_id (Windows).
 func (h *HomePolicyLoader) UserPolicyPath(username string) (string, error) {
@@ -72,7 +72,7 @@ func NewTestHomePolicyLoader(fs afero.Fs, userLookup policy.UserLookup) *policy.
 
 func NewTestSystemPolicyLoader(fs afero.Fs, userLookup policy.UserLookup) *policy.SystemPolicyLoader {
 	return &policy.SystemPolicyLoader{
-		&policy.PolicyLoader{
+		PolicyLoader: &policy.PolicyLoader{
 			FileLoader: files.FileLoader{
 				Fs:           fs,
 				RequiredPerm: files.ModeSystemPerms,
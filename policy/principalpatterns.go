@@ -0,0 +1,109 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegexPrincipalPrefix marks a principal of the policy that is a regular
+// expression, such as re:dev-[0-9]+. The expression must match the whole
+// principal.
+const RegexPrincipalPrefix = "re:"
+
+// CatchAllOption is the option of a policy entry that allows its principal
+// pattern to match privileged accounts such as root, e.g. catchall=true.
+// Without it policy lint rejects such patterns.
+const CatchAllOption = "catchall"
+
+// privilegedPrincipals are the accounts a catch-all pattern would grant
+var privilegedPrincipals = []string{"root", "Administrator", "admin", "toor"}
+
+// principalPatterns caches the compiled patterns by principal, as verify
+// and serve match the same policy against every login
+var principalPatterns sync.Map
+
+// PrincipalPattern is a principal of the policy that matches several
+// accounts, either a glob where * matches any characters and ? a single
+// one, e.g. dev-*, or a regular expression prefixed with re:
+type PrincipalPattern struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// IsPrincipalPattern returns true if principal is a pattern rather than a
+// single account or a local group
+func IsPrincipalPattern(principal string) bool {
+	if strings.HasPrefix(principal, GroupPrefix) {
+		return false
+	}
+	return strings.HasPrefix(principal, RegexPrincipalPrefix) || strings.ContainsAny(principal, "*?")
+}
+
+// CompilePrincipalPattern compiles the pattern principal. Patterns are
+// compiled once, later calls return the cached pattern.
+func CompilePrincipalPattern(principal string) (*PrincipalPattern, error) {
+	if cached, ok := principalPatterns.Load(principal); ok {
+		return cached.(*PrincipalPattern), nil
+	}
+	var expr string
+	if re, ok := strings.CutPrefix(principal, RegexPrincipalPrefix); ok {
+		if re == "" {
+			return nil, fmt.Errorf("empty regular expression in principal %s", principal)
+		}
+		expr = re
+	} else {
+		var b strings.Builder
+		for _, r := range principal {
+			switch r {
+			case '*':
+				b.WriteString(".*")
+			case '?':
+				b.WriteString(".")
+			default:
+				b.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expr = b.String()
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid principal pattern %s: %w", principal, err)
+	}
+	pattern := &PrincipalPattern{Pattern: principal, re: re}
+	principalPatterns.Store(principal, pattern)
+	return pattern, nil
+}
+
+// Match returns true if the pattern matches principal
+func (p *PrincipalPattern) Match(principal string) bool {
+	return p.re.MatchString(principal)
+}
+
+// CatchAll returns the privileged account, such as root, the pattern
+// matches, empty if it matches none
+func (p *PrincipalPattern) CatchAll() string {
+	for _, principal := range privilegedPrincipals {
+		if p.Match(principal) {
+			return principal
+		}
+	}
+	return ""
+}
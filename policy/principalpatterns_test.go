@@ -0,0 +1,73 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipalPattern(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		pattern  string
+		match    []string
+		noMatch  []string
+		catchAll string
+	}{
+		{pattern: "dev-*", match: []string{"dev-", "dev-web"}, noMatch: []string{"dev", "xdev-web", "root"}},
+		{pattern: "web?", match: []string{"web1"}, noMatch: []string{"web", "web12"}},
+		{pattern: "a.b*", match: []string{"a.b", "a.bc"}, noMatch: []string{"axb"}},
+		{pattern: "re:dev-[0-9]+", match: []string{"dev-1", "dev-42"}, noMatch: []string{"dev-", "dev-1x", "xdev-1"}},
+		{pattern: "re:a|b", match: []string{"a", "b"}, noMatch: []string{"ab", "xa"}},
+		{pattern: "*", match: []string{"root", "alice"}, catchAll: "root"},
+		{pattern: "r*", match: []string{"root"}, catchAll: "root"},
+		{pattern: "re:.+", match: []string{"root"}, catchAll: "root"},
+		{pattern: "Admin*", match: []string{"Administrator"}, catchAll: "Administrator"},
+	}
+	for _, tt := range tests {
+		require.True(t, policy.IsPrincipalPattern(tt.pattern), tt.pattern)
+		pattern, err := policy.CompilePrincipalPattern(tt.pattern)
+		require.NoError(t, err)
+		for _, p := range tt.match {
+			require.True(t, pattern.Match(p), "%s should match %s", tt.pattern, p)
+		}
+		for _, p := range tt.noMatch {
+			require.False(t, pattern.Match(p), "%s should not match %s", tt.pattern, p)
+		}
+		require.Equal(t, tt.catchAll, pattern.CatchAll(), tt.pattern)
+	}
+
+	for _, principal := range []string{"root", "%sshadmins", "%dev-*", "alice.smith"} {
+		require.False(t, policy.IsPrincipalPattern(principal), principal)
+	}
+	_, err := policy.CompilePrincipalPattern("re:dev-[")
+	require.ErrorContains(t, err, "invalid principal pattern re:dev-[")
+
+	// Invalid patterns skip the entry, catchall=true is kept
+	p, problems := policy.FromTable([]byte(
+		"'re:dev-[' alice@example.com https://accounts.google.com\n"+
+			"* alice@example.com https://accounts.google.com catchall=true\n"+
+			"* alice@example.com https://accounts.google.com catchall=yes\n"), "auth_id")
+	require.Len(t, problems, 2)
+	require.Equal(t, []policy.User{{IdentityAttribute: "alice@example.com", Principals: []string{"*"}, Issuer: "https://accounts.google.com", CatchAll: true}}, p.Users)
+	table, err := p.ToTable()
+	require.NoError(t, err)
+	require.Equal(t, "'*' alice@example.com https://accounts.google.com catchall=true\n", string(table))
+}
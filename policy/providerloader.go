@@ -17,8 +17,12 @@
 package policy
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/openpubkey/openpubkey/providers"
 	"github.com/openpubkey/openpubkey/verifier"
@@ -30,6 +34,40 @@ type ProvidersRow struct {
 	Issuer           string
 	ClientID         string
 	ExpirationPolicy string
+
+	// The options below are only set by providers.yml
+
+	// ClientIDs are all the accepted client IDs, ClientID is the first
+	ClientIDs      []string
+	RequiredClaims map[string]string
+	CABundle       string
+	Aliases        []string
+	// AllowedAlgs are the ID Token signature algorithms accepted from the
+	// issuer, SupportedAlgs when empty
+	AllowedAlgs []string
+	// MaxSession, if set, is how long after the ID Token was issued its PK
+	// Token stops being accepted, whatever the expiration policy
+	MaxSession time.Duration
+
+	// httpClient trusts the CAs in CABundle
+	httpClient *http.Client
+}
+
+// GetClientIDs returns the client IDs accepted for the issuer
+func (p ProvidersRow) GetClientIDs() []string {
+	if len(p.ClientIDs) > 0 {
+		return p.ClientIDs
+	}
+	return []string{p.ClientID}
+}
+
+// GetAllowedAlgs returns the ID Token signature algorithms accepted from the
+// issuer
+func (p ProvidersRow) GetAllowedAlgs() []string {
+	if len(p.AllowedAlgs) > 0 {
+		return p.AllowedAlgs
+	}
+	return SupportedAlgs
 }
 
 func (p ProvidersRow) GetExpirationPolicy() (verifier.ExpirationPolicy, error) {
@@ -54,11 +92,18 @@ func (p ProvidersRow) GetExpirationPolicy() (verifier.ExpirationPolicy, error) {
 }
 
 func (p ProvidersRow) ToString() string {
-	return p.Issuer + " " + p.ClientID + " " + p.ExpirationPolicy
+	return strings.Join(append([]string{p.Issuer, p.ClientID, p.ExpirationPolicy}, p.requiredClaimColumns()...), " ")
 }
 
 type ProviderPolicy struct {
 	rows []ProvidersRow
+	// issuerAliases are the aliases of providers.yml
+	issuerAliases IssuerAliases
+	// JWKSCache, if set, caches the keys fetched by the verifier
+	JWKSCache *JWKSCache
+	// Now returns the current time the max_session of the providers is
+	// checked against, defaults to time.Now
+	Now func() time.Time
 }
 
 func (p *ProviderPolicy) AddRow(row ProvidersRow) {
@@ -69,39 +114,47 @@ func (p *ProviderPolicy) GetRows() []ProvidersRow {
 	return p.rows
 }
 
+// AddIssuerAlias makes the old issuer URL alias equivalent to issuer when
+// matching policy entries
+func (p *ProviderPolicy) AddIssuerAlias(alias string, issuer string) {
+	if p.issuerAliases == nil {
+		p.issuerAliases = IssuerAliases{}
+	}
+	p.issuerAliases[alias] = issuer
+}
+
+// IssuerAliases returns the issuer aliases of the providers, nil if there
+// are none
+func (p *ProviderPolicy) IssuerAliases() IssuerAliases {
+	return p.issuerAliases
+}
+
 func (p *ProviderPolicy) CreateVerifier() (*verifier.Verifier, error) {
 	pvs := []verifier.ProviderVerifier{}
 	var expirationPolicy verifier.ExpirationPolicy
 	var err error
 	for _, row := range p.rows {
 		var provider verifier.ProviderVerifier
-		// TODO: We should handle this issuer matching in a more generic way
-		// oidc.local and localhost: are a test issuers
-		if row.Issuer == "https://accounts.google.com" ||
-			strings.HasPrefix(row.Issuer, "http://oidc.local") ||
-			strings.HasPrefix(row.Issuer, "http://localhost:") {
-
-			opts := providers.GetDefaultGoogleOpOptions()
-			opts.Issuer = row.Issuer
-			opts.ClientID = row.ClientID
-			provider = providers.NewGoogleOpWithOptions(opts)
-		} else if strings.HasPrefix(row.Issuer, "https://login.microsoftonline.com") {
-			opts := providers.GetDefaultAzureOpOptions()
-			opts.Issuer = row.Issuer
-			opts.ClientID = row.ClientID
-			provider = providers.NewAzureOpWithOptions(opts)
-		} else if row.Issuer == "https://gitlab.com" {
-			opts := providers.GetDefaultGitlabOpOptions()
-			opts.Issuer = row.Issuer
-			opts.ClientID = row.ClientID
-			provider = providers.NewGitlabOpWithOptions(opts)
-		} else if row.Issuer == "https://token.actions.githubusercontent.com" {
-			provider = providers.NewGithubOp(row.Issuer, "")
+		httpClient := row.httpClient
+		if p.JWKSCache != nil {
+			httpClient = p.JWKSCache.Client(httpClient)
+		}
+		clientIDs := row.GetClientIDs()
+		if len(clientIDs) == 1 {
+			provider = row.newProvider(clientIDs[0], httpClient)
 		} else {
-			opts := providers.GetDefaultGoogleOpOptions()
-			opts.Issuer = row.Issuer
-			opts.ClientID = row.ClientID
-			provider = providers.NewGoogleOpWithOptions(opts)
+			multi := multiClientVerifier{}
+			for _, clientID := range clientIDs {
+				multi.verifiers = append(multi.verifiers, row.newProvider(clientID, httpClient))
+			}
+			provider = multi
+		}
+		if len(row.RequiredClaims) > 0 {
+			provider = requiredClaimsVerifier{ProviderVerifier: provider, claims: row.RequiredClaims}
+		}
+		provider = algorithmVerifier{ProviderVerifier: provider, allowed: row.GetAllowedAlgs()}
+		if row.MaxSession > 0 {
+			provider = maxSessionVerifier{ProviderVerifier: provider, maxSession: row.MaxSession, now: p.Now}
 		}
 
 		expirationPolicy, err = row.GetExpirationPolicy()
@@ -128,6 +181,43 @@ func (p *ProviderPolicy) CreateVerifier() (*verifier.Verifier, error) {
 	return pktVerifier, nil
 }
 
+// newProvider returns the verifier of ID Tokens from the issuer of the row
+// that are issued to clientID. The keys of the issuer are fetched with
+// httpClient, http.DefaultClient if nil.
+func (p ProvidersRow) newProvider(clientID string, httpClient *http.Client) verifier.ProviderVerifier {
+	// TODO: We should handle this issuer matching in a more generic way
+	// oidc.local and localhost: are a test issuers
+	if p.Issuer == "https://accounts.google.com" ||
+		strings.HasPrefix(p.Issuer, "http://oidc.local") ||
+		strings.HasPrefix(p.Issuer, "http://localhost:") {
+
+		opts := providers.GetDefaultGoogleOpOptions()
+		opts.Issuer = p.Issuer
+		opts.ClientID = clientID
+		opts.HttpClient = httpClient
+		return providers.NewGoogleOpWithOptions(opts)
+	} else if strings.HasPrefix(p.Issuer, "https://login.microsoftonline.com") {
+		opts := providers.GetDefaultAzureOpOptions()
+		opts.Issuer = p.Issuer
+		opts.ClientID = clientID
+		opts.HttpClient = httpClient
+		return providers.NewAzureOpWithOptions(opts)
+	} else if p.Issuer == "https://gitlab.com" {
+		opts := providers.GetDefaultGitlabOpOptions()
+		opts.Issuer = p.Issuer
+		opts.ClientID = clientID
+		opts.HttpClient = httpClient
+		return providers.NewGitlabOpWithOptions(opts)
+	} else if p.Issuer == "https://token.actions.githubusercontent.com" {
+		return providers.NewGithubOp(p.Issuer, "")
+	}
+	opts := providers.GetDefaultGoogleOpOptions()
+	opts.Issuer = p.Issuer
+	opts.ClientID = clientID
+	opts.HttpClient = httpClient
+	return providers.NewGoogleOpWithOptions(opts)
+}
+
 func (p ProviderPolicy) ToString() string {
 	var sb strings.Builder
 	for _, row := range p.rows {
@@ -155,12 +245,22 @@ func NewProviderFileLoader() *ProvidersFileLoader {
 	}
 }
 
+// LoadProviderPolicy reads the providers file at path and the providers.yml
+// file next to it. Either file may be missing, but not both.
 func (o *ProvidersFileLoader) LoadProviderPolicy(path string) (*ProviderPolicy, error) {
 	content, err := o.LoadFileAtPath(path)
-	if err != nil {
+	yamlPath := ProvidersYAMLPath(path)
+	yamlContent, yamlErr := o.LoadFileAtPath(yamlPath)
+	if err != nil && (!errors.Is(err, fs.ErrNotExist) || yamlErr != nil) {
 		return nil, err
 	}
+	if yamlErr != nil && !errors.Is(yamlErr, fs.ErrNotExist) {
+		return nil, yamlErr
+	}
 	policy := o.FromTable(content, path)
+	if yamlErr == nil {
+		o.loadYAML(policy, yamlPath, yamlContent)
+	}
 	return policy, nil
 }
 
@@ -168,7 +268,7 @@ func (o *ProvidersFileLoader) LoadProviderPolicy(path string) (*ProviderPolicy,
 func (o ProvidersFileLoader) ToTable(opPolicies ProviderPolicy) files.Table {
 	table := files.Table{}
 	for _, opPolicy := range opPolicies.rows {
-		table.AddRow(opPolicy.Issuer, opPolicy.ClientID, opPolicy.ExpirationPolicy)
+		table.AddRow(append([]string{opPolicy.Issuer, opPolicy.ClientID, opPolicy.ExpirationPolicy}, opPolicy.requiredClaimColumns()...)...)
 	}
 	return table
 }
@@ -176,26 +276,37 @@ func (o ProvidersFileLoader) ToTable(opPolicies ProviderPolicy) files.Table {
 // FromTable decodes whitespace delimited input into policy.Policy
 // Path is passed only for logging purposes
 func (o *ProvidersFileLoader) FromTable(input []byte, path string) *ProviderPolicy {
-	table := files.NewTable(input)
 	policy := &ProviderPolicy{
 		rows: []ProvidersRow{},
 	}
-	for _, row := range table.GetRows() {
+	for _, row := range files.ParseRows(input) {
 		// Error should not break everyone's ability to login, skip those rows
-		if len(row) != 3 {
+		var err error
+		var claims map[string]string
+		if row.Err != nil {
+			err = row.Err
+		} else if len(row.Columns) > 3 {
+			claims, err = ParseRequiredClaims(row.Columns[3:])
+		} else if pe := row.CheckColumns("issuer", "client-id", "expiration-policy"); pe != nil {
+			err = pe
+		}
+		if err != nil {
 			configProblem := files.ConfigProblem{
 				Filepath:      path,
-				OffendingLine: strings.Join(row, " "),
-				ErrorMessage:  fmt.Sprintf("wrong number of arguments (expected=3, got=%d)", len(row)),
+				OffendingLine: row.Content,
+				ErrorMessage:  err.Error(),
 				Source:        "providers policy file",
 			}
 			files.ConfigProblems().RecordProblem(configProblem)
 			continue
 		}
 		policyRow := ProvidersRow{
-			Issuer:           row[0],
-			ClientID:         row[1],
-			ExpirationPolicy: row[2], // TODO: Validate this so that we can determine the line number that has the error
+			Issuer:           row.Columns[0],
+			ClientID:         row.Columns[1],
+			ExpirationPolicy: row.Columns[2], // TODO: Validate this so that we can determine the line number that has the error
+		}
+		if len(claims) > 0 {
+			policyRow.RequiredClaims = claims
 		}
 		policy.AddRow(policyRow)
 	}
@@ -21,6 +21,7 @@ package policy
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/openpubkey/openpubkey/verifier"
 	"github.com/stretchr/testify/require"
@@ -61,6 +62,51 @@ func TestProvidersPolicyRow_GetExpirationPolicy(t *testing.T) {
 	}
 }
 
+// Test for ProviderPolicy.ExpiresAt.
+func TestProviderPolicy_ExpiresAt(t *testing.T) {
+	iat := time.Unix(1700000000, 0)
+	exp := iat.Add(time.Hour)
+
+	policy := ProviderPolicy{}
+	policy.AddRow(ProvidersRow{Issuer: "issuer1", ClientID: "client1", ExpirationPolicy: "12h"})
+	policy.AddRow(ProvidersRow{Issuer: "issuer2", ClientID: "client2", ExpirationPolicy: "oidc"})
+	policy.AddRow(ProvidersRow{Issuer: "issuer3", ClientID: "client3", ExpirationPolicy: "never"})
+	policy.AddRow(ProvidersRow{Issuer: "issuer4", ClientID: "client4", ExpirationPolicy: "never", MaxSession: 8 * time.Hour})
+	policy.AddRow(ProvidersRow{Issuer: "issuer5", ClientID: "client5", ExpirationPolicy: "oidc", MaxSession: 8 * time.Hour})
+
+	expiresAt, ok := policy.ExpiresAt("issuer1", "", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, iat.Add(12*time.Hour), expiresAt)
+
+	expiresAt, ok = policy.ExpiresAt("issuer2", "", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, exp, expiresAt)
+
+	_, ok = policy.ExpiresAt("issuer3", "", iat, exp)
+	require.False(t, ok)
+
+	// The max session bounds policies that would accept the token longer
+	expiresAt, ok = policy.ExpiresAt("issuer4", "", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, iat.Add(8*time.Hour), expiresAt)
+
+	expiresAt, ok = policy.ExpiresAt("issuer5", "", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, exp, expiresAt)
+
+	_, ok = policy.ExpiresAt("unknown", "", iat, exp)
+	require.False(t, ok)
+
+	// The row of the client ID in the audience is used, not the first one
+	policy.AddRow(ProvidersRow{Issuer: "issuer1", ClientID: "client6", ExpirationPolicy: "oidc"})
+	expiresAt, ok = policy.ExpiresAt("issuer1", "other,client6", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, exp, expiresAt)
+	expiresAt, ok = policy.ExpiresAt("issuer1", "other", iat, exp)
+	require.True(t, ok)
+	require.Equal(t, iat.Add(12*time.Hour), expiresAt)
+}
+
 // Test for ProviderPolicy.ToString.
 func TestProviderPolicy_ToString(t *testing.T) {
 	policy := ProviderPolicy{}
@@ -0,0 +1,354 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// ProvidersYAMLPath returns the path of the providers.yml file read
+// alongside the providers file at path
+func ProvidersYAMLPath(path string) string {
+	return path + ".yml"
+}
+
+// ProvidersYAML is the content of a providers.yml file, the alternative to
+// the providers file for providers that need more than an issuer, a client
+// ID and an expiration policy
+type ProvidersYAML struct {
+	Providers []ProviderYAML `yaml:"providers"`
+	// Aliases maps old issuer URLs to the issuer of a provider they were
+	// migrated to
+	Aliases IssuerAliases `yaml:"aliases,omitempty"`
+}
+
+// ProviderYAML configures one provider in providers.yml
+type ProviderYAML struct {
+	Issuer string `yaml:"issuer"`
+	// ClientIDs are the audiences accepted in ID Tokens from the issuer
+	ClientIDs        []string `yaml:"client_ids"`
+	ExpirationPolicy string   `yaml:"expiration_policy"`
+	// RequiredClaims are claims the ID Token must have with these values,
+	// such as hd for a Google Workspace domain
+	RequiredClaims map[string]string `yaml:"required_claims,omitempty"`
+	// CABundle is a PEM file of the CAs trusted when fetching the keys of
+	// the issuer, the system CAs are used when empty
+	CABundle string `yaml:"ca_bundle,omitempty"`
+	// Aliases are short names that can be typed instead of the issuer
+	Aliases []string `yaml:"aliases,omitempty"`
+	// AllowedAlgs are the signature algorithms accepted for ID Tokens from
+	// the issuer, such as [ES256], all supported algorithms when empty
+	AllowedAlgs []string `yaml:"allowed_algs,omitempty"`
+	// MaxSession bounds how long after login a PK Token from the issuer is
+	// accepted, such as 8h, even if the ID Token or its refresh is valid
+	// for longer
+	MaxSession string `yaml:"max_session,omitempty"`
+}
+
+// ProviderYAMLError is a provider of providers.yml that is invalid. Its
+// issuer is refused, rather than falling back to the line of the providers
+// file without the restrictions of providers.yml.
+type ProviderYAMLError struct {
+	// Index is the position of the provider in providers.yml, from 1
+	Index  int
+	Issuer string
+	Err    error
+}
+
+func (e *ProviderYAMLError) Error() string {
+	return fmt.Sprintf("provider %d (%s): %v", e.Index, e.Issuer, e.Err)
+}
+
+func (e *ProviderYAMLError) Unwrap() error {
+	return e.Err
+}
+
+// ParseProvidersYAML parses the content of a providers.yml file. Providers
+// that are invalid are left out and reported in the returned errors as a
+// ProviderYAMLError, so that one mistake does not break logins from the
+// other providers.
+func ParseProvidersYAML(data []byte) ([]ProvidersRow, []error) {
+	config, err := decodeProvidersYAML(data)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	rows := []ProvidersRow{}
+	errs := []error{}
+	issuers := map[string]bool{}
+	aliases := map[string]string{}
+	for i, p := range config.Providers {
+		if p.ExpirationPolicy == "" {
+			p.ExpirationPolicy = Defaults.ExpirationPolicy
+		}
+		row := ProvidersRow{
+			Issuer:           p.Issuer,
+			ClientIDs:        p.ClientIDs,
+			ExpirationPolicy: p.ExpirationPolicy,
+			RequiredClaims:   p.RequiredClaims,
+			CABundle:         p.CABundle,
+			Aliases:          p.Aliases,
+			AllowedAlgs:      p.AllowedAlgs,
+		}
+		if len(p.ClientIDs) > 0 {
+			row.ClientID = p.ClientIDs[0]
+		}
+		if p.MaxSession != "" {
+			maxSession, err := time.ParseDuration(p.MaxSession)
+			if err != nil || maxSession <= 0 {
+				errs = append(errs, &ProviderYAMLError{Index: i + 1, Issuer: p.Issuer, Err: fmt.Errorf("invalid max_session %q, expected a duration such as 8h", p.MaxSession)})
+				continue
+			}
+			row.MaxSession = maxSession
+		}
+		if err := row.validate(issuers, aliases); err != nil {
+			errs = append(errs, &ProviderYAMLError{Index: i + 1, Issuer: p.Issuer, Err: err})
+			continue
+		}
+		issuers[row.Issuer] = true
+		for _, alias := range row.Aliases {
+			aliases[alias] = row.Issuer
+		}
+		rows = append(rows, row)
+	}
+	return rows, errs
+}
+
+// ParseIssuerAliases returns the valid issuer aliases of the content of a
+// providers.yml file and the problems of the others
+func ParseIssuerAliases(data []byte) (IssuerAliases, []error) {
+	config, err := decodeProvidersYAML(data)
+	if err != nil {
+		return nil, []error{err}
+	}
+	return config.Aliases.Valid()
+}
+
+// providersYAMLIssuers returns the issuers named in a providers.yml file
+// that can't be decoded as a ProvidersYAML, such as one with a misspelled
+// setting. Returns false if the file isn't even valid YAML.
+func providersYAMLIssuers(data []byte) ([]string, bool) {
+	var config struct {
+		Providers []struct {
+			Issuer string `yaml:"issuer"`
+		} `yaml:"providers"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, false
+	}
+	issuers := []string{}
+	for _, p := range config.Providers {
+		issuers = append(issuers, p.Issuer)
+	}
+	return issuers, true
+}
+
+func decodeProvidersYAML(data []byte) (*ProvidersYAML, error) {
+	config := &ProvidersYAML{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse providers config: %w", err)
+	}
+	return config, nil
+}
+
+// validate checks a provider from providers.yml against itself and the
+// issuers and aliases of the providers before it
+func (p ProvidersRow) validate(issuers map[string]bool, aliases map[string]string) error {
+	if p.Issuer == "" {
+		return fmt.Errorf("issuer is required")
+	}
+	if issuers[p.Issuer] {
+		return fmt.Errorf("issuer is already configured")
+	}
+	if len(p.ClientIDs) == 0 || slices.Contains(p.ClientIDs, "") {
+		return fmt.Errorf("client_ids must list at least one client ID and none may be empty")
+	}
+	if _, err := p.GetExpirationPolicy(); err != nil {
+		return err
+	}
+	for claim := range p.RequiredClaims {
+		if claim == "" {
+			return fmt.Errorf("required_claims has an empty claim name")
+		}
+	}
+	if err := ValidateAllowedAlgs(p.AllowedAlgs); err != nil {
+		return err
+	}
+	for _, alias := range p.Aliases {
+		if alias == "" || strings.ContainsAny(alias, ":/ \t") {
+			return fmt.Errorf("invalid alias %q, expected a short name such as corp", alias)
+		}
+		if issuer, ok := aliases[alias]; ok {
+			return fmt.Errorf("alias %q is already used by %s", alias, issuer)
+		}
+	}
+	return nil
+}
+
+// loadYAML adds the providers of the providers.yml file at path to policy.
+// A provider in providers.yml replaces the line for the same issuer in the
+// providers file. Invalid providers are recorded as config problems and
+// their issuers are refused, even if the providers file has a line for
+// them, so that a mistake can't drop the restrictions of providers.yml. If
+// the file can't be decoded every issuer it names is refused, and every
+// provider if it isn't valid YAML.
+func (o *ProvidersFileLoader) loadYAML(policy *ProviderPolicy, path string, content []byte) {
+	config, err := decodeProvidersYAML(content)
+	if err != nil {
+		o.recordProblem(path, err)
+		issuers, ok := providersYAMLIssuers(content)
+		if !ok {
+			o.recordProblem(path, fmt.Errorf("refusing every provider, %s is not valid YAML", path))
+			policy.rows = []ProvidersRow{}
+			return
+		}
+		for _, issuer := range issuers {
+			o.refuseIssuer(policy, path, issuer)
+		}
+		return
+	}
+
+	rows, errs := ParseProvidersYAML(content)
+	failed := []string{}
+	for _, err := range errs {
+		o.recordProblem(path, err)
+		var providerErr *ProviderYAMLError
+		if errors.As(err, &providerErr) {
+			failed = append(failed, providerErr.Issuer)
+		}
+	}
+	for _, row := range rows {
+		if row.CABundle != "" {
+			client, err := caBundleClient(o.Fs, row.CABundle)
+			if err != nil {
+				o.recordProblem(path, fmt.Errorf("provider %s: %w", row.Issuer, err))
+				failed = append(failed, row.Issuer)
+				continue
+			}
+			row.httpClient = client
+		}
+		policy.rows = slices.DeleteFunc(policy.rows, func(r ProvidersRow) bool {
+			return r.Issuer == row.Issuer
+		})
+		policy.AddRow(row)
+	}
+	for _, issuer := range failed {
+		o.refuseIssuer(policy, path, issuer)
+	}
+
+	aliases, errs := config.Aliases.Valid()
+	for _, err := range errs {
+		o.recordProblem(path, err)
+	}
+	for alias, issuer := range aliases {
+		if !slices.ContainsFunc(policy.rows, func(r ProvidersRow) bool { return r.Issuer == issuer }) {
+			o.recordProblem(path, fmt.Errorf("issuer alias %s points to %s, which is not a configured provider", alias, issuer))
+			continue
+		}
+		policy.AddIssuerAlias(alias, issuer)
+	}
+}
+
+// refuseIssuer removes the providers of issuer from policy, because its
+// provider in the providers.yml file at path is invalid
+func (o *ProvidersFileLoader) refuseIssuer(policy *ProviderPolicy, path string, issuer string) {
+	if issuer == "" {
+		return
+	}
+	n := len(policy.rows)
+	policy.rows = slices.DeleteFunc(policy.rows, func(r ProvidersRow) bool {
+		return r.Issuer == issuer
+	})
+	if len(policy.rows) < n {
+		o.recordProblem(path, fmt.Errorf("refusing %s until its provider in %s is fixed", issuer, path))
+	}
+}
+
+func (o *ProvidersFileLoader) recordProblem(path string, err error) {
+	files.ConfigProblems().RecordProblem(files.ConfigProblem{
+		Filepath:     path,
+		ErrorMessage: err.Error(),
+		Source:       "providers config file",
+	})
+}
+
+// caBundleClient returns an HTTP client that only trusts the CAs in the PEM
+// file at path
+func caBundleClient(fsys afero.Fs, path string) (*http.Client, error) {
+	pem, err := afero.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca_bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in ca_bundle %s", path)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport}, nil
+}
+
+// ExpandAlias returns the issuer of the provider that has alias in its
+// aliases
+func (p *ProviderPolicy) ExpandAlias(alias string) (string, bool) {
+	for _, row := range p.rows {
+		if slices.Contains(row.Aliases, alias) {
+			return row.Issuer, true
+		}
+	}
+	return "", false
+}
+
+// multiClientVerifier accepts ID Tokens issued to any of several client IDs
+// of the same issuer
+type multiClientVerifier struct {
+	verifiers []verifier.ProviderVerifier
+}
+
+func (m multiClientVerifier) Issuer() string {
+	return m.verifiers[0].Issuer()
+}
+
+func (m multiClientVerifier) VerifyIDToken(ctx context.Context, idt []byte, cic *clientinstance.Claims) error {
+	errs := []error{}
+	for _, v := range m.verifiers {
+		err := v.VerifyIDToken(ctx, idt, cic)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
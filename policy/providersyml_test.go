@@ -0,0 +1,197 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+const testProvidersYAML = `providers:
+  - issuer: https://accounts.google.com
+    client_ids: [google-a, google-b]
+    expiration_policy: 12h
+    required_claims:
+      hd: example.com
+      email_verified: true
+    aliases: [corp]
+  - issuer: https://idp.example.com
+    client_ids: [idp]
+    expiration_policy: 24h
+    ca_bundle: /etc/opk/idp-ca.pem
+    max_session: 8h
+  - issuer: https://broken.example.com
+    client_ids: []
+    expiration_policy: 24h
+  - issuer: https://alias.example.com
+    client_ids: [alias]
+    expiration_policy: 24h
+    aliases: [corp]
+  - issuer: https://session.example.com
+    client_ids: [session]
+    expiration_policy: oidc_refreshed
+    max_session: 0s
+`
+
+func TestParseProvidersYAML(t *testing.T) {
+	rows, errs := ParseProvidersYAML([]byte(testProvidersYAML))
+	require.Len(t, rows, 2)
+	require.Equal(t, ProvidersRow{
+		Issuer:           "https://accounts.google.com",
+		ClientID:         "google-a",
+		ClientIDs:        []string{"google-a", "google-b"},
+		ExpirationPolicy: "12h",
+		RequiredClaims:   map[string]string{"hd": "example.com", "email_verified": "true"},
+		Aliases:          []string{"corp"},
+	}, rows[0])
+	require.Equal(t, "/etc/opk/idp-ca.pem", rows[1].CABundle)
+	require.Equal(t, 8*time.Hour, rows[1].MaxSession)
+
+	require.Len(t, errs, 3)
+	require.ErrorContains(t, errs[0], "provider 3 (https://broken.example.com): client_ids must list at least one client ID")
+	require.ErrorContains(t, errs[1], `provider 4 (https://alias.example.com): alias "corp" is already used by https://accounts.google.com`)
+	require.ErrorContains(t, errs[2], `provider 5 (https://session.example.com): invalid max_session "0s", expected a duration such as 8h`)
+	var providerErr *ProviderYAMLError
+	require.ErrorAs(t, errs[2], &providerErr)
+	require.Equal(t, "https://session.example.com", providerErr.Issuer)
+
+	rows, errs = ParseProvidersYAML([]byte("providers:\n  - issuer: https://accounts.google.com\n    client_ids: [a]\n"))
+	require.Empty(t, errs)
+	require.Equal(t, Defaults.ExpirationPolicy, rows[0].ExpirationPolicy)
+
+	_, errs = ParseProvidersYAML([]byte("providers:\n  - issuer: https://accounts.google.com\n    client_id: a\n"))
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "field client_id not found")
+}
+
+func TestLoadProviderPolicyWithYAML(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	loader := &ProvidersFileLoader{FileLoader: files.FileLoader{Fs: fs, RequiredPerm: files.ModeSystemPerms}}
+	yaml := "providers:\n" +
+		"  - issuer: https://accounts.google.com\n" +
+		"    client_ids: [google-a, google-b]\n" +
+		"    expiration_policy: 12h\n" +
+		"    aliases: [corp]\n" +
+		"  - issuer: https://idp.example.com\n" +
+		"    client_ids: [idp]\n" +
+		"    expiration_policy: 24h\n" +
+		"    ca_bundle: /etc/opk/missing.pem\n"
+
+	// Only providers.yml
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers.yml", []byte(yaml), 0o640))
+	providerPolicy, err := loader.LoadProviderPolicy("/etc/opk/providers")
+	require.NoError(t, err)
+	require.Len(t, providerPolicy.GetRows(), 1)
+	issuer, ok := providerPolicy.ExpandAlias("corp")
+	require.True(t, ok)
+	require.Equal(t, "https://accounts.google.com", issuer)
+
+	// providers.yml replaces the line for the same issuer
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers", []byte(
+		"https://accounts.google.com google-old 24h\n"+
+			"https://gitlab.com gitlab 24h\n"), 0o640))
+	providerPolicy, err = loader.LoadProviderPolicy("/etc/opk/providers")
+	require.NoError(t, err)
+	rows := providerPolicy.GetRows()
+	require.Len(t, rows, 2)
+	require.Equal(t, "https://gitlab.com", rows[0].Issuer)
+	require.Equal(t, []string{"gitlab"}, rows[0].GetClientIDs())
+	require.Equal(t, []string{"google-a", "google-b"}, rows[1].GetClientIDs())
+	pktVerifier, err := providerPolicy.CreateVerifier()
+	require.NoError(t, err)
+	require.NotNil(t, pktVerifier)
+
+	// An invalid provider doesn't fall back to the line of the providers
+	// file, which doesn't have its restrictions
+	for _, broken := range []string{
+		"providers:\n  - issuer: https://accounts.google.com\n    client_ids: [google-a]\n    expiration_policy: 12h\n    max_session: forever\n",
+		"providers:\n  - issuer: https://accounts.google.com\n    client_ids: [google-a]\n    expiration_policy: 12h\n    ca_bundle: /etc/opk/missing.pem\n",
+		// A misspelled setting fails the whole file
+		"providers:\n  - issuer: https://accounts.google.com\n    client_ids: [google-a]\n    expiration_policy: 12h\n    required_claim:\n      hd: example.com\n",
+	} {
+		require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers.yml", []byte(broken), 0o640))
+		providerPolicy, err = loader.LoadProviderPolicy("/etc/opk/providers")
+		require.NoError(t, err)
+		rows = providerPolicy.GetRows()
+		require.Len(t, rows, 1, broken)
+		require.Equal(t, "https://gitlab.com", rows[0].Issuer)
+	}
+
+	// Without knowing the issuers of providers.yml, every one is refused
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers.yml", []byte("providers: [\n"), 0o640))
+	providerPolicy, err = loader.LoadProviderPolicy("/etc/opk/providers")
+	require.NoError(t, err)
+	require.Empty(t, providerPolicy.GetRows())
+	require.NoError(t, afero.WriteFile(fs, "/etc/opk/providers.yml", []byte(yaml), 0o640))
+
+	// A providers.yml with insecure permissions is not ignored
+	require.NoError(t, fs.Chmod("/etc/opk/providers.yml", 0o666))
+	_, err = loader.LoadProviderPolicy("/etc/opk/providers")
+	require.ErrorContains(t, err, "insecure permissions")
+
+	require.NoError(t, fs.RemoveAll("/etc/opk"))
+	_, err = loader.LoadProviderPolicy("/etc/opk/providers")
+	require.ErrorContains(t, err, "file does not exist")
+}
+
+func TestCABundleClient(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/ca.pem", []byte("not a certificate"), 0o644))
+	_, err := caBundleClient(fs, "/ca.pem")
+	require.ErrorContains(t, err, "no certificates found in ca_bundle /ca.pem")
+	_, err = caBundleClient(fs, "/missing.pem")
+	require.ErrorContains(t, err, "failed to read ca_bundle")
+}
+
+type testProviderVerifier struct {
+	issuer string
+	err    error
+}
+
+func (v testProviderVerifier) Issuer() string { return v.issuer }
+
+func (v testProviderVerifier) VerifyIDToken(ctx context.Context, idt []byte, cic *clientinstance.Claims) error {
+	return v.err
+}
+
+func TestMultiClientVerifier(t *testing.T) {
+	wrongAud := errors.New("audience does not match")
+	multi := multiClientVerifier{verifiers: []verifier.ProviderVerifier{
+		testProviderVerifier{issuer: "https://example.com", err: wrongAud},
+		testProviderVerifier{issuer: "https://example.com"},
+	}}
+	require.Equal(t, "https://example.com", multi.Issuer())
+	require.NoError(t, multi.VerifyIDToken(context.Background(), nil, nil))
+
+	multi.verifiers = multi.verifiers[:1]
+	require.ErrorIs(t, multi.VerifyIDToken(context.Background(), nil, nil), wrongAud)
+
+	claims := requiredClaimsVerifier{
+		ProviderVerifier: testProviderVerifier{issuer: "https://example.com"},
+		claims:           map[string]string{"hd": "example.com"},
+	}
+	require.NoError(t, claims.VerifyIDToken(context.Background(), testIDToken(`{"hd":"example.com"}`), nil))
+	require.Error(t, claims.VerifyIDToken(context.Background(), testIDToken(`{"hd":"example.org"}`), nil))
+}
@@ -0,0 +1,182 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/openpubkey/opkssh/internal/events"
+	"github.com/openpubkey/opkssh/policy/files"
+	"github.com/spf13/afero"
+)
+
+const (
+	// DefaultRateLimitWindow is how long failed logins are counted for
+	DefaultRateLimitWindow = 10 * time.Minute
+	// DefaultRateLimitBlock is how long a throttled client is refused
+	DefaultRateLimitBlock = 15 * time.Minute
+)
+
+// RateLimitDir returns the directory where verify counts the failed logins
+// of each client and principal
+func RateLimitDir() string {
+	return filepath.Join(GetSystemStateBasePath(), "ratelimit")
+}
+
+// RateLimiter refuses the logins as a principal from a client address for
+// Block once MaxFailures of them failed within Window. The failures are
+// kept in Dir, as every login runs a new opkssh verify. It is best effort:
+// logins are not refused when the state can't be read, and concurrent logins
+// may each count a failure the other one does not see.
+type RateLimiter struct {
+	Fs          afero.Fs
+	Dir         string
+	MaxFailures int
+	Window      time.Duration
+	Block       time.Duration
+	// Report emits a LoginThrottled event when a client is throttled
+	Report bool
+	Now    func() time.Time
+}
+
+// NewRateLimiter returns a rate limiter in RateLimitDir, a zero window or
+// block uses the defaults
+func NewRateLimiter(maxFailures int, window time.Duration, block time.Duration) *RateLimiter {
+	if window <= 0 {
+		window = DefaultRateLimitWindow
+	}
+	if block <= 0 {
+		block = DefaultRateLimitBlock
+	}
+	return &RateLimiter{
+		Fs:          afero.NewOsFs(),
+		Dir:         RateLimitDir(),
+		MaxFailures: maxFailures,
+		Window:      window,
+		Block:       block,
+		Now:         time.Now,
+	}
+}
+
+// rateLimitState is the state of a client and principal, stored as JSON
+type rateLimitState struct {
+	Failures     []time.Time `json:"failures,omitempty"`
+	BlockedUntil time.Time   `json:"blocked_until,omitzero"`
+}
+
+func (l *RateLimiter) path(clientIP string, principal string) string {
+	sum := sha256.Sum256([]byte(clientIP + "\x00" + principal))
+	return filepath.Join(l.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (l *RateLimiter) read(path string) rateLimitState {
+	state := rateLimitState{}
+	content, err := afero.ReadFile(l.Fs, path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to read rate limit state", "path", path, "error", err)
+		}
+		return state
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		slog.Warn("Ignoring invalid rate limit state", "path", path, "error", err)
+		return rateLimitState{}
+	}
+	return state
+}
+
+// Check returns a DenialError if logins as principal from clientIP are
+// throttled. clientIP is empty when the address of the client is unknown.
+func (l *RateLimiter) Check(clientIP string, principal string) error {
+	state := l.read(l.path(clientIP, principal))
+	if now := l.Now(); now.Before(state.BlockedUntil) {
+		return &DenialError{Code: DenyCodeRateLimited, msg: fmt.Sprintf("too many failed logins as %s from %s, logins are refused until %s",
+			principal, clientName(clientIP), state.BlockedUntil.UTC().Format(time.RFC3339))}
+	}
+	return nil
+}
+
+// Record counts a failed login as principal from clientIP, and throttles
+// the client once it reaches MaxFailures. A successful login clears the
+// failures.
+func (l *RateLimiter) Record(clientIP string, principal string, failed bool) {
+	path := l.path(clientIP, principal)
+	if !failed {
+		if err := l.Fs.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Failed to clear rate limit state", "path", path, "error", err)
+		}
+		return
+	}
+
+	now := l.Now()
+	state := l.read(path)
+	failures := []time.Time{now}
+	for _, failure := range state.Failures {
+		if now.Sub(failure) < l.Window {
+			failures = append(failures, failure)
+		}
+	}
+	state.Failures = failures
+	if len(failures) >= l.MaxFailures {
+		state.Failures = nil
+		state.BlockedUntil = now.Add(l.Block)
+		slog.Warn("Throttling logins", "user", principal, "client", clientName(clientIP), "until", state.BlockedUntil.UTC().Format(time.RFC3339), "failures", len(failures))
+		if l.Report {
+			events.Emit(events.LoginThrottled, map[string]string{
+				"user":      principal,
+				"client_ip": clientIP,
+				"failures":  strconv.Itoa(len(failures)),
+				"until":     state.BlockedUntil.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	if err := l.write(path, state); err != nil {
+		slog.Warn("Failed to record failed login", "path", path, "error", err)
+	}
+}
+
+func (l *RateLimiter) write(path string, state rateLimitState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := l.Fs.MkdirAll(l.Dir, 0o700); err != nil {
+		return err
+	}
+	if err := files.WriteFileAtomic(l.Fs, path, content, 0o600); err != nil {
+		return err
+	}
+	// Keep the state readable by verify when it is written by opkssh serve
+	// running as root
+	return chownToDir(l.Fs, l.Dir, path)
+}
+
+func clientName(clientIP string) string {
+	if clientIP == "" {
+		return "an unknown address"
+	}
+	return clientIP
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	l := policy.NewRateLimiter(3, 10*time.Minute, 0)
+	l.Fs = afero.NewMemMapFs()
+	l.Dir = "/var/lib/opk/ratelimit"
+	l.Now = func() time.Time { return now }
+	l.Report = true
+	require.Equal(t, policy.DefaultRateLimitBlock, l.Block)
+
+	// Failures older than the window are not counted
+	l.Record("192.0.2.10", "root", true)
+	now = now.Add(11 * time.Minute)
+	l.Record("192.0.2.10", "root", true)
+	l.Record("192.0.2.10", "root", true)
+	require.NoError(t, l.Check("192.0.2.10", "root"))
+
+	// A success clears the failures
+	l.Record("192.0.2.10", "root", false)
+	l.Record("192.0.2.10", "root", true)
+	l.Record("192.0.2.10", "root", true)
+	require.NoError(t, l.Check("192.0.2.10", "root"))
+	require.NotContains(t, logBuf.String(), "login_throttled")
+
+	l.Record("192.0.2.10", "root", true)
+	err := l.Check("192.0.2.10", "root")
+	var denialErr *policy.DenialError
+	require.True(t, errors.As(err, &denialErr))
+	require.Equal(t, policy.DenyCodeRateLimited, denialErr.Code)
+	require.EqualError(t, err, "too many failed logins as root from 192.0.2.10, logins are refused until 2026-01-02T03:30:05Z")
+	require.Contains(t, logBuf.String(), "event=login_throttled client_ip=192.0.2.10 failures=3 until=2026-01-02T03:30:05Z user=root")
+
+	// Other principals and clients are not throttled
+	require.NoError(t, l.Check("192.0.2.10", "alice"))
+	require.NoError(t, l.Check("192.0.2.11", "root"))
+
+	now = now.Add(policy.DefaultRateLimitBlock)
+	require.NoError(t, l.Check("192.0.2.10", "root"))
+	info, err := l.Fs.Stat(l.Dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+}
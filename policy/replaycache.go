@@ -0,0 +1,291 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// replayPruneInterval is how often the file replay store removes the
+// entries of expired PK Tokens
+const replayPruneInterval = time.Hour
+
+// ReplayCacheDir returns the directory of the file replay store, and of the
+// database of the SQLite replay store
+func ReplayCacheDir() string {
+	return filepath.Join(GetSystemStateBasePath(), "replay")
+}
+
+// ReplayCacheDBPath returns the default path of the SQLite replay store
+func ReplayCacheDBPath() string {
+	return filepath.Join(ReplayCacheDir(), "replay.db")
+}
+
+// ReplayStore records the jti of the PK Tokens used to log in until they
+// expire. Servers sharing a store see the tokens used on each other.
+type ReplayStore interface {
+	// Record stores binding as the first use of jti until expires, unless
+	// jti is already recorded. It returns the binding of the first use and
+	// whether jti was seen before.
+	Record(jti string, binding string, expires time.Time) (first string, seen bool, err error)
+}
+
+// ReplayGuard refuses the logins with a PK Token whose jti was first used
+// on another host, or from another client with BindClient. With SingleUse
+// every PK Token can only be used once.
+type ReplayGuard struct {
+	Store ReplayStore
+	// Host identifies this server in the store, defaults to the hostname
+	Host       string
+	BindClient bool
+	SingleUse  bool
+	// RequireJTI refuses the PK Tokens without a jti claim, which can't be
+	// tracked
+	RequireJTI bool
+}
+
+// Check records the login with the PK Token jti from clientIP, the token
+// is accepted until expires. It returns a DenialError if it is a replay.
+func (g *ReplayGuard) Check(jti string, clientIP string, expires time.Time) error {
+	if jti == "" {
+		if g.RequireJTI {
+			return &DenialError{Code: DenyCodeReplay, msg: "ID Token has no jti claim, which this server requires to detect replays"}
+		}
+		return nil
+	}
+	binding := g.Host
+	if binding == "" {
+		binding, _ = os.Hostname()
+	}
+	if g.BindClient {
+		binding += " " + clientIP
+	}
+	first, seen, err := g.Store.Record(jti, binding, expires)
+	if err != nil {
+		// Fail closed, the replay could be what the store failed to report
+		return fmt.Errorf("failed to check the replay cache: %w", err)
+	}
+	if seen && g.SingleUse {
+		return &DenialError{Code: DenyCodeReplay, msg: fmt.Sprintf("ID Token with jti %s was already used by %s", jti, first)}
+	}
+	if first != binding {
+		return &DenialError{Code: DenyCodeReplay, msg: fmt.Sprintf("ID Token with jti %s was first used by %s, not %s", jti, first, binding)}
+	}
+	return nil
+}
+
+// MemoryReplayStore keeps the jti in memory, for opkssh serve
+type MemoryReplayStore struct {
+	Now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]replayEntry
+}
+
+// replayEntry is the first use of a jti
+type replayEntry struct {
+	Binding string    `json:"binding"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewMemoryReplayStore returns an empty in-memory store
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{Now: time.Now, entries: map[string]replayEntry{}}
+}
+
+func (m *MemoryReplayStore) Record(jti string, binding string, expires time.Time) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.Now()
+	for key, entry := range m.entries {
+		if !now.Before(entry.Expires) {
+			delete(m.entries, key)
+		}
+	}
+	if entry, ok := m.entries[jti]; ok {
+		return entry.Binding, true, nil
+	}
+	m.entries[jti] = replayEntry{Binding: binding, Expires: expires}
+	return binding, false, nil
+}
+
+// FileReplayStore keeps a file for each jti in Dir, which can be shared by
+// servers, e.g. over NFS. Files are created exclusively so that concurrent
+// logins agree on the first use.
+type FileReplayStore struct {
+	Fs  afero.Fs
+	Dir string
+	Now func() time.Time
+}
+
+// NewFileReplayStore returns the store in dir
+func NewFileReplayStore(dir string) *FileReplayStore {
+	return &FileReplayStore{Fs: afero.NewOsFs(), Dir: dir, Now: time.Now}
+}
+
+func (f *FileReplayStore) Record(jti string, binding string, expires time.Time) (string, bool, error) {
+	sum := sha256.Sum256([]byte(jti))
+	path := filepath.Join(f.Dir, hex.EncodeToString(sum[:])+".json")
+	content, err := json.Marshal(replayEntry{Binding: binding, Expires: expires})
+	if err != nil {
+		return "", false, err
+	}
+	if err := f.Fs.MkdirAll(f.Dir, 0o700); err != nil {
+		return "", false, err
+	}
+	f.prune()
+	// An expired entry is replaced once
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := f.Fs.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = file.Write(content)
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return "", false, fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			return binding, false, chownToDir(f.Fs, f.Dir, path)
+		} else if !errors.Is(err, fs.ErrExist) {
+			return "", false, err
+		}
+		entry, err := f.read(path)
+		if err != nil {
+			return "", false, err
+		}
+		if f.Now().Before(entry.Expires) {
+			return entry.Binding, true, nil
+		}
+		if err := f.Fs.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", false, err
+		}
+	}
+	return "", false, fmt.Errorf("failed to record jti in %s", path)
+}
+
+func (f *FileReplayStore) read(path string) (replayEntry, error) {
+	entry := replayEntry{}
+	content, err := afero.ReadFile(f.Fs, path)
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return entry, fmt.Errorf("invalid replay cache entry %s: %w", path, err)
+	}
+	return entry, nil
+}
+
+// prune removes the entries of expired tokens, at most once per
+// replayPruneInterval. A marker file records when it last ran.
+func (f *FileReplayStore) prune() {
+	marker := filepath.Join(f.Dir, ".pruned")
+	now := f.Now()
+	if info, err := f.Fs.Stat(marker); err == nil && now.Sub(info.ModTime()) < replayPruneInterval {
+		return
+	}
+	if err := afero.WriteFile(f.Fs, marker, nil, 0o600); err != nil {
+		return
+	}
+	_ = f.Fs.Chtimes(marker, now, now)
+	names, err := afero.Glob(f.Fs, filepath.Join(f.Dir, "*.json"))
+	if err != nil {
+		return
+	}
+	for _, path := range names {
+		if entry, err := f.read(path); err == nil && !now.Before(entry.Expires) {
+			_ = f.Fs.Remove(path)
+		}
+	}
+}
+
+// replayDBSchema stores the first use of each jti
+const replayDBSchema = `CREATE TABLE IF NOT EXISTS seen (
+	jti TEXT PRIMARY KEY,
+	binding TEXT NOT NULL,
+	expires INTEGER NOT NULL
+)`
+
+// SQLiteReplayStore keeps the jti in a SQLite database
+type SQLiteReplayStore struct {
+	Path string
+	Now  func() time.Time
+}
+
+// NewSQLiteReplayStore returns the store in the database at path
+func NewSQLiteReplayStore(path string) *SQLiteReplayStore {
+	return &SQLiteReplayStore{Path: path, Now: time.Now}
+}
+
+func (s *SQLiteReplayStore) Record(jti string, binding string, expires time.Time) (string, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return "", false, err
+	}
+	// Create the database only readable by the user running verify
+	if f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600); err == nil {
+		f.Close()
+	} else if !errors.Is(err, fs.ErrExist) {
+		return "", false, err
+	}
+	dsn := (&url.URL{Scheme: "file", OmitHost: true, Path: filepath.ToSlash(s.Path), RawQuery: "_pragma=busy_timeout(5000)"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return "", false, err
+	}
+	defer db.Close()
+	if _, err := db.Exec(replayDBSchema); err != nil {
+		return "", false, fmt.Errorf("failed to create replay cache %s: %w", s.Path, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+	now := s.Now().Unix()
+	if _, err := tx.Exec("DELETE FROM seen WHERE expires <= ?", now); err != nil {
+		return "", false, err
+	}
+	result, err := tx.Exec("INSERT OR IGNORE INTO seen (jti, binding, expires) VALUES (?, ?, ?)", jti, binding, expires.Unix())
+	if err != nil {
+		return "", false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return "", false, err
+	}
+	first := binding
+	if inserted == 0 {
+		if err := tx.QueryRow("SELECT binding FROM seen WHERE jti = ?", jti).Scan(&first); err != nil {
+			return "", false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("failed to update replay cache %s: %w", s.Path, err)
+	}
+	return first, inserted == 0, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayStores(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time { return now }
+	memory := policy.NewMemoryReplayStore()
+	memory.Now = clock
+	file := policy.NewFileReplayStore("/var/lib/opk/replay")
+	file.Fs = afero.NewMemMapFs()
+	file.Now = clock
+	sqlite := policy.NewSQLiteReplayStore(filepath.Join(t.TempDir(), "replay", "replay.db"))
+	sqlite.Now = clock
+
+	for name, store := range map[string]policy.ReplayStore{"memory": memory, "file": file, "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			now = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+			expires := now.Add(time.Hour)
+			first, seen, err := store.Record("jti-1", "host-a", expires)
+			require.NoError(t, err)
+			require.Equal(t, "host-a", first)
+			require.False(t, seen)
+
+			first, seen, err = store.Record("jti-1", "host-b", expires)
+			require.NoError(t, err)
+			require.Equal(t, "host-a", first)
+			require.True(t, seen)
+
+			first, _, err = store.Record("jti-2", "host-b", expires)
+			require.NoError(t, err)
+			require.Equal(t, "host-b", first)
+
+			// Entries are forgotten once the token expired
+			now = expires
+			first, seen, err = store.Record("jti-1", "host-b", now.Add(time.Hour))
+			require.NoError(t, err)
+			require.Equal(t, "host-b", first)
+			require.False(t, seen)
+		})
+	}
+}
+
+func TestReplayGuard(t *testing.T) {
+	store := policy.NewMemoryReplayStore()
+	expires := time.Now().Add(time.Hour)
+	hostA := &policy.ReplayGuard{Store: store, Host: "host-a"}
+	hostB := &policy.ReplayGuard{Store: store, Host: "host-b"}
+
+	require.NoError(t, hostA.Check("jti-1", "192.0.2.10", expires))
+	require.NoError(t, hostA.Check("jti-1", "192.0.2.11", expires))
+	err := hostB.Check("jti-1", "192.0.2.10", expires)
+	var denialErr *policy.DenialError
+	require.True(t, errors.As(err, &denialErr))
+	require.Equal(t, policy.DenyCodeReplay, denialErr.Code)
+	require.EqualError(t, err, "ID Token with jti jti-1 was first used by host-a, not host-b")
+
+	client := &policy.ReplayGuard{Store: store, Host: "host-a", BindClient: true}
+	require.NoError(t, client.Check("jti-2", "192.0.2.10", expires))
+	require.EqualError(t, client.Check("jti-2", "192.0.2.11", expires), "ID Token with jti jti-2 was first used by host-a 192.0.2.10, not host-a 192.0.2.11")
+
+	once := &policy.ReplayGuard{Store: store, Host: "host-a", SingleUse: true}
+	require.NoError(t, once.Check("jti-3", "192.0.2.10", expires))
+	require.EqualError(t, once.Check("jti-3", "192.0.2.10", expires), "ID Token with jti jti-3 was already used by host-a")
+
+	// Tokens without a jti can't be tracked
+	require.NoError(t, hostA.Check("", "192.0.2.10", expires))
+	hostA.RequireJTI = true
+	require.ErrorContains(t, hostA.Check("", "192.0.2.10", expires), "ID Token has no jti claim")
+
+	failing := &policy.ReplayGuard{Store: policy.NewSQLiteReplayStore(t.TempDir()), Host: "host-a"}
+	require.ErrorContains(t, failing.Check("jti-4", "192.0.2.10", expires), "failed to check the replay cache")
+}
@@ -0,0 +1,129 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openpubkey/openpubkey/oidc"
+	"github.com/openpubkey/openpubkey/pktoken/clientinstance"
+	"github.com/openpubkey/openpubkey/verifier"
+)
+
+// RequiredClaimError is an ID Token that was verified by its provider but
+// doesn't have a claim the provider is configured to require, such as an
+// account of another Google Workspace domain or Azure tenant
+type RequiredClaimError struct {
+	Claim string
+	Value string
+}
+
+func (e *RequiredClaimError) Error() string {
+	return fmt.Sprintf("ID Token claim %s does not have the required value %q", e.Claim, e.Value)
+}
+
+// ParseRequiredClaims parses the claim=value columns that may follow the
+// expiration policy in the providers file
+func ParseRequiredClaims(columns []string) (map[string]string, error) {
+	claims := map[string]string{}
+	for _, column := range columns {
+		name, value, ok := strings.Cut(column, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid required claim %q, expected claim=value such as hd=example.com", column)
+		}
+		if _, ok := claims[name]; ok {
+			return nil, fmt.Errorf("claim %s is required more than once", name)
+		}
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+// requiredClaimColumns returns the required claims of the row as the
+// claim=value columns of the providers file, sorted by claim
+func (p ProvidersRow) requiredClaimColumns() []string {
+	columns := []string{}
+	for name, value := range p.RequiredClaims {
+		columns = append(columns, name+"="+value)
+	}
+	slices.Sort(columns)
+	return columns
+}
+
+// requiredClaimsVerifier rejects ID Tokens that don't have the required
+// claims, after they are verified by the provider
+type requiredClaimsVerifier struct {
+	verifier.ProviderVerifier
+	claims map[string]string
+}
+
+func (r requiredClaimsVerifier) VerifyIDToken(ctx context.Context, idt []byte, cic *clientinstance.Claims) error {
+	if err := r.ProviderVerifier.VerifyIDToken(ctx, idt, cic); err != nil {
+		return err
+	}
+	return checkRequiredClaims(idt, r.claims)
+}
+
+// checkRequiredClaims checks that the payload of the ID Token idt has each
+// claim in required. A claim that is a list matches if any of its values
+// matches.
+func checkRequiredClaims(idt []byte, required map[string]string) error {
+	_, payload, _, err := oidc.SplitCompact(idt)
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(string(payload))
+	if err != nil {
+		return fmt.Errorf("failed to decode ID Token payload: %w", err)
+	}
+	claims := map[string]any{}
+	if err := json.Unmarshal(decoded, &claims); err != nil {
+		return fmt.Errorf("failed to parse ID Token payload: %w", err)
+	}
+	names := make([]string, 0, len(required))
+	for name := range required {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if !claimMatches(claims[name], required[name]) {
+			return &RequiredClaimError{Claim: name, Value: required[name]}
+		}
+	}
+	return nil
+}
+
+func claimMatches(value any, want string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == want
+	case bool, float64:
+		return fmt.Sprint(v) == want
+	case []any:
+		for _, item := range v {
+			if claimMatches(item, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/openpubkey/openpubkey/client"
+	"github.com/openpubkey/openpubkey/providers"
+	"github.com/openpubkey/openpubkey/util"
+	"github.com/openpubkey/openpubkey/verifier"
+	"github.com/stretchr/testify/require"
+)
+
+func testIDToken(payload string) []byte {
+	return []byte("e30." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2ln")
+}
+
+func TestCheckRequiredClaims(t *testing.T) {
+	idt := testIDToken(`{"hd":"example.com","email_verified":true,"groups":["a","b"]}`)
+	require.NoError(t, checkRequiredClaims(idt, map[string]string{"hd": "example.com", "email_verified": "true", "groups": "b"}))
+	require.ErrorContains(t, checkRequiredClaims(idt, map[string]string{"hd": "evil.com"}), `ID Token claim hd does not have the required value "evil.com"`)
+	require.ErrorContains(t, checkRequiredClaims(idt, map[string]string{"tid": "tenant"}), "claim tid")
+	require.Error(t, checkRequiredClaims([]byte("not-a-token"), map[string]string{"hd": "example.com"}))
+}
+
+func TestParseRequiredClaims(t *testing.T) {
+	claims, err := ParseRequiredClaims([]string{"hd=example.com", "tid=", "email_verified=true"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"hd": "example.com", "tid": "", "email_verified": "true"}, claims)
+
+	_, err = ParseRequiredClaims([]string{"example.com"})
+	require.ErrorContains(t, err, `invalid required claim "example.com"`)
+	_, err = ParseRequiredClaims([]string{"hd=a", "hd=b"})
+	require.ErrorContains(t, err, "claim hd is required more than once")
+}
+
+func TestProvidersFileRequiredClaims(t *testing.T) {
+	loader := ProvidersFileLoader{}
+	providerPolicy := loader.FromTable([]byte(
+		"https://accounts.google.com google 24h hd=example.com email_verified=true\n"+
+			"https://login.microsoftonline.com/tenant azure 24h tenant\n"), "providers")
+	rows := providerPolicy.GetRows()
+	require.Len(t, rows, 1)
+	require.Equal(t, map[string]string{"hd": "example.com", "email_verified": "true"}, rows[0].RequiredClaims)
+	require.Equal(t, "https://accounts.google.com google 24h email_verified=true hd=example.com", rows[0].ToString())
+	require.Equal(t, []string{"https://accounts.google.com", "google", "24h", "email_verified=true", "hd=example.com"}, loader.ToTable(*providerPolicy).GetRows()[0])
+}
+
+func TestRequiredClaimsVerifier(t *testing.T) {
+	alg := jwa.ES256
+	signer, err := util.GenKeyPair(alg)
+	require.NoError(t, err)
+	providerOpts := providers.DefaultMockProviderOpts()
+	providerOpts.Issuer = "https://accounts.google.com"
+	op, _, idtTemplate, err := providers.NewMockProvider(providerOpts)
+	require.NoError(t, err)
+	idtTemplate.ExtraClaims = map[string]any{"email": "alice@example.org", "hd": "example.org", "email_verified": true}
+	opkClient, err := client.New(op, client.WithSigner(signer, alg))
+	require.NoError(t, err)
+	pkt, err := opkClient.Auth(context.Background())
+	require.NoError(t, err)
+
+	tests := []struct {
+		claims  map[string]string
+		wantErr bool
+	}{
+		{map[string]string{"hd": "example.org", "email_verified": "true"}, false},
+		// An account of another Google Workspace domain
+		{map[string]string{"hd": "example.com"}, true},
+		{map[string]string{"tid": "tenant"}, true},
+	}
+	for _, tt := range tests {
+		pv := verifier.ProviderVerifierExpires{
+			ProviderVerifier: requiredClaimsVerifier{ProviderVerifier: op, claims: tt.claims},
+			Expiration:       verifier.ExpirationPolicies.NEVER_EXPIRE,
+		}
+		pktVerifier, err := verifier.NewFromMany([]verifier.ProviderVerifier{pv})
+		require.NoError(t, err)
+		err = pktVerifier.VerifyPKToken(context.Background(), pkt)
+		if !tt.wantErr {
+			require.NoError(t, err)
+			continue
+		}
+		var claimErr *RequiredClaimError
+		require.ErrorAs(t, err, &claimErr)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// SystemDefaultRevocationPath is the default filepath of the local list of
+// revoked identities
+var SystemDefaultRevocationPath = filepath.Join(GetSystemStateBasePath(), "revoked")
+
+// RevokedIdentity is an identity that must be denied regardless of policy,
+// for instance because it was suspended at the OpenID Provider before its
+// PK Token expired
+type RevokedIdentity struct {
+	Issuer string `json:"issuer"`
+	// Subject and Email identify the user, a login matching either is denied
+	Subject string `json:"sub,omitempty"`
+	Email   string `json:"email,omitempty"`
+	// Reason is why the identity was revoked, e.g. the provider event type
+	Reason    string    `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// Matches returns true if the token with issuer, sub and email is revoked
+func (r RevokedIdentity) Matches(issuer string, sub string, email string) bool {
+	if r.Issuer != issuer {
+		return false
+	}
+	return (r.Subject != "" && r.Subject == sub) ||
+		(r.Email != "" && strings.EqualFold(r.Email, email))
+}
+
+// RevocationList appends RevokedIdentity records as JSON lines to a root
+// owned file. verify runs as the AuthorizedKeysCommandUser so the file is
+// world readable, it only contains identities.
+type RevocationList struct {
+	Fs   afero.Fs
+	Path string
+}
+
+// NewRevocationList returns a RevocationList at SystemDefaultRevocationPath on
+// the OS filesystem
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		Fs:   afero.NewOsFs(),
+		Path: SystemDefaultRevocationPath,
+	}
+}
+
+// Revoke adds r to the revocation list
+func (l *RevocationList) Revoke(r RevokedIdentity) error {
+	if r.Issuer == "" || (r.Subject == "" && r.Email == "") {
+		return fmt.Errorf("revoked identity requires an issuer and a subject or email")
+	}
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = time.Now().UTC()
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := l.Fs.MkdirAll(filepath.Dir(l.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create revocation list directory: %w", err)
+	}
+	f, err := l.Fs.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open revocation list %s: %w", l.Path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write revocation list %s: %w", l.Path, err)
+	}
+	return nil
+}
+
+// Entries returns every revoked identity. A missing revocation list has no
+// entries.
+func (l *RevocationList) Entries() ([]RevokedIdentity, error) {
+	f, err := l.Fs.Open(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RevokedIdentity{}, nil
+		}
+		return nil, fmt.Errorf("failed to open revocation list %s: %w", l.Path, err)
+	}
+	defer f.Close()
+
+	entries := []RevokedIdentity{}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry RevokedIdentity
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("corrupt revocation entry at %s:%d: %w", l.Path, lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
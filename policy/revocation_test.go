@@ -0,0 +1,58 @@
+// Copyright 2026 OpenPubkey
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/openpubkey/opkssh/policy"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationList(t *testing.T) {
+	t.Parallel()
+	list := &policy.RevocationList{Fs: afero.NewMemMapFs(), Path: "/var/lib/opk/revoked"}
+
+	entries, err := list.Entries()
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	require.ErrorContains(t, list.Revoke(policy.RevokedIdentity{Issuer: "https://example.okta.com"}), "requires an issuer and a subject or email")
+
+	require.NoError(t, list.Revoke(policy.RevokedIdentity{
+		Issuer:  "https://example.okta.com",
+		Subject: "00u1abcd",
+		Email:   "alice@example.com",
+		Reason:  "user.lifecycle.suspend",
+	}))
+	require.NoError(t, list.Revoke(policy.RevokedIdentity{Issuer: "https://example.okta.com", Email: "bob@example.com"}))
+
+	entries, err = list.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.False(t, entries[0].RevokedAt.IsZero())
+
+	require.True(t, entries[0].Matches("https://example.okta.com", "00u1abcd", ""))
+	require.True(t, entries[0].Matches("https://example.okta.com", "", "ALICE@example.com"))
+	require.False(t, entries[0].Matches("https://accounts.google.com", "00u1abcd", "alice@example.com"))
+	require.False(t, entries[1].Matches("https://example.okta.com", "", "alice@example.com"))
+
+	info, err := list.Fs.Stat(list.Path)
+	require.NoError(t, err)
+	require.Equal(t, "-rw-r--r--", info.Mode().Perm().String())
+}
@@ -38,13 +38,16 @@ type ValidationRowResult struct {
 	IdentityAttr string           `json:"identity_attr"`
 	Issuer       string           `json:"issuer"`
 	Reason       string           `json:"reason"`
-	LineNumber   int              `json:"line_number"` // Line number in the policy file (1-indexed)
+	// AliasOf is the issuer that Issuer is an alias of, if any
+	AliasOf    string `json:"alias_of,omitempty"`
+	LineNumber int    `json:"line_number"` // Line number in the policy file (1-indexed)
 }
 
 // PolicyValidator validates policy file entries against provider definitions
 type PolicyValidator struct {
 	// issuerMap maps issuer URL to ProvidersRow
 	issuerMap map[string]ProvidersRow
+	aliases   IssuerAliases
 }
 
 // NewPolicyValidator creates a new PolicyValidator from a ProviderPolicy
@@ -56,6 +59,7 @@ func NewPolicyValidator(providerPolicy *ProviderPolicy) *PolicyValidator {
 
 	return &PolicyValidator{
 		issuerMap: issuerMap,
+		aliases:   providerPolicy.issuerAliases,
 	}
 }
 
@@ -75,6 +79,17 @@ func (v *PolicyValidator) ValidateEntry(principal, identityAttr, issuer string,
 		return result
 	}
 
+	if canonical, ok := v.aliases[issuer]; ok {
+		if _, exists := v.issuerMap[canonical]; exists {
+			result.Status = StatusSuccess
+			result.Reason = fmt.Sprintf("issuer is an alias of provider %s", canonical)
+			result.AliasOf = canonical
+			result.Hints = append(result.Hints,
+				fmt.Sprintf("Run opkssh policy rewrite-issuer %s %s once the migration is done", issuer, canonical))
+			return result
+		}
+	}
+
 	// Check if issuer exists in providers (exact match)
 	_, exists := v.issuerMap[issuer]
 	if !exists {
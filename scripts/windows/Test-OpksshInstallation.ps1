@@ -260,8 +260,13 @@ function Test-Permissions {
         try {
             $acl = Get-Acl $configPath
             
+            # Compare by SID, the names of the accounts are localized
+            $sids = $acl.Access | ForEach-Object {
+                try { $_.IdentityReference.Translate([System.Security.Principal.SecurityIdentifier]).Value } catch { $null }
+            }
+
             # Check if SYSTEM has access
-            $systemAccess = $acl.Access | Where-Object { $_.IdentityReference -like "*SYSTEM*" }
+            $systemAccess = $sids -contains "S-1-5-18"
             if ($systemAccess) {
                 Write-TestResult -TestName "SYSTEM has access" -Result Pass
             } else {
@@ -269,7 +274,7 @@ function Test-Permissions {
             }
             
             # Check if Administrators have access
-            $adminAccess = $acl.Access | Where-Object { $_.IdentityReference -like "*Administrators*" }
+            $adminAccess = $sids -contains "S-1-5-32-544"
             if ($adminAccess) {
                 Write-TestResult -TestName "Administrators have access" -Result Pass
             } else {
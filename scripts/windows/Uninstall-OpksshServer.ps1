@@ -332,6 +332,35 @@ function Remove-OpksshFromPath {
     return $true
 }
 
+function Remove-OpksshEventSource {
+    <#
+    .SYNOPSIS
+        Removes the opkssh event source registered by 'opkssh permissions install'.
+    #>
+    [CmdletBinding(SupportsShouldProcess=$true)]
+    param()
+
+    $sourceKey = "HKLM:\SYSTEM\CurrentControlSet\Services\EventLog\Application\opkssh"
+
+    if (-not (Test-Path $sourceKey)) {
+        Write-Verbose "Event source 'opkssh' is not registered"
+        return $true
+    }
+
+    if ($PSCmdlet.ShouldProcess("opkssh", "Remove event source")) {
+        try {
+            Remove-Item -Path $sourceKey -Recurse -Force -ErrorAction Stop
+            Write-UninstallLog "  Removed event source: opkssh" -Level Success
+            return $true
+        } catch {
+            Write-UninstallLog "Failed to remove event source 'opkssh': $($_.Exception.Message)" -Level Warning
+            return $false
+        }
+    }
+
+    return $true
+}
+
 function Restart-SshdService {
     <#
     .SYNOPSIS
@@ -396,6 +425,7 @@ try {
         Write-Host "  - sshd_config modifications (restored from backup)" -ForegroundColor White
         Write-Host "  - opksshuser account (if exists)" -ForegroundColor White
         Write-Host "  - System PATH entry" -ForegroundColor White
+        Write-Host "  - opkssh Event Log source" -ForegroundColor White
         Write-Host ""
         
         $confirmation = Read-Host "Are you sure you want to continue? (yes/no)"
@@ -427,8 +457,9 @@ try {
     Write-Host ""
     
     # Step 5: Remove from PATH
-    Write-Host "[5/6] Removing from system PATH..." -ForegroundColor Yellow
+    Write-Host "[5/6] Removing from system PATH and Event Log..." -ForegroundColor Yellow
     Remove-OpksshFromPath | Out-Null
+    Remove-OpksshEventSource | Out-Null
     Write-Host ""
     
     # Step 6: Restart sshd